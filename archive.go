@@ -0,0 +1,214 @@
+package backupstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/longhorn/backupstore/logging"
+)
+
+const (
+	// DefaultThawRetryInterval is the default interval of checking the archived blocks being restored
+	DefaultThawRetryInterval = 5 * time.Minute
+	// DefaultThawTimeout is the default max wait for an archived block, covering the bulk restores of S3 Glacier
+	// Deep Archive
+	DefaultThawTimeout = 48 * time.Hour
+)
+
+// ArchivedObjectBackupStoreDriver is implemented by the drivers whose objects can be moved to an archival
+// storage class, e.g. S3 Glacier, which cannot be read until they're restored for a while.
+type ArchivedObjectBackupStoreDriver interface {
+	// ThawObject requests the restore of the archived object if it isn't requested yet, and returns true if the
+	// object can be read now.
+	ThawObject(filePath string) (bool, error)
+}
+
+// ErrObjectArchived is returned by the reads of an archived object. The restore of the object is requested by
+// the driver, and the read succeeds once the object is restored.
+type ErrObjectArchived struct {
+	Path string
+}
+
+func (e *ErrObjectArchived) Error() string {
+	return fmt.Sprintf("object %v is archived and being restored, retry once it's restored, see ThawBackup", e.Path)
+}
+
+// IsObjectArchivedError returns true if the error is caused by reading an archived object.
+func IsObjectArchivedError(err error) bool {
+	var archivedErr *ErrObjectArchived
+	return errors.As(err, &archivedErr)
+}
+
+// ThawStatus is the progress of restoring the archived blocks of a backup.
+type ThawStatus struct {
+	TotalBlocks   int64 `json:",string"`
+	ThawedBlocks  int64 `json:",string"`
+	ThawingBlocks int64 `json:",string"`
+	// Progress is the percentage of the blocks which can be read
+	Progress int
+}
+
+// ThawBackup requests the restore of the archived blocks of the backup, and returns the progress. It's called
+// periodically before restoring a backup from an archival storage class until all the blocks are thawed, the
+// restores already requested are not requested again.
+func ThawBackup(backupURL string) (*ThawStatus, error) {
+	bsDriver, err := GetBackupStoreDriver(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	backupName, volumeName, _, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	log := log.WithFields(logrus.Fields{
+		LogFieldBackup: backupName,
+		LogFieldVolume: volumeName,
+	})
+
+	if _, err := loadVolume(bsDriver, volumeName); err != nil {
+		return nil, err
+	}
+	backup, err := loadBackup(bsDriver, backupName, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	if isBackupInProgress(backup) {
+		return nil, fmt.Errorf("backup %v of volume %v is still in progress", backupName, volumeName)
+	}
+
+	checksums := map[string]struct{}{}
+	for _, block := range backup.Blocks {
+		if _, inline := backup.InlineBlocks[block.BlockChecksum]; !inline {
+			checksums[block.BlockChecksum] = struct{}{}
+		}
+	}
+	status := &ThawStatus{TotalBlocks: int64(len(checksums))}
+
+	archived, ok := findDriver[ArchivedObjectBackupStoreDriver](bsDriver)
+	if !ok {
+		status.ThawedBlocks = status.TotalBlocks
+		status.Progress = 100
+		return status, nil
+	}
+
+	var (
+		lock sync.Mutex
+		errs []error
+	)
+	jobQueues := workerpool.New(runtime.NumCPU() * 16)
+	for checksum := range checksums {
+		blockFile := findBlockFilePath(bsDriver, volumeName, checksum)
+		jobQueues.Submit(func() {
+			thawed, err := archived.ThawObject(blockFile)
+			lock.Lock()
+			defer lock.Unlock()
+			switch {
+			case err != nil:
+				errs = append(errs, err)
+			case thawed:
+				status.ThawedBlocks++
+			default:
+				status.ThawingBlocks++
+			}
+		})
+	}
+	jobQueues.StopWait()
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to thaw %v blocks of backup %v: %v", len(errs), backupName, errs[0])
+	}
+	status.Progress = 100
+	if status.TotalBlocks > 0 {
+		status.Progress = int(status.ThawedBlocks * 100 / status.TotalBlocks)
+	}
+	log.Infof("Thawed %v of %v blocks of backup, %v blocks are being restored", status.ThawedBlocks,
+		status.TotalBlocks, status.ThawingBlocks)
+	return status, nil
+}
+
+// thawWait is the wait of the restores for the archived blocks.
+type thawWait struct {
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func getThawWait(config *DeltaRestoreConfig) thawWait {
+	wait := thawWait{interval: config.ThawRetryInterval, timeout: config.ThawTimeout}
+	if wait.interval <= 0 {
+		wait.interval = DefaultThawRetryInterval
+	}
+	if wait.timeout == 0 {
+		wait.timeout = DefaultThawTimeout
+	}
+	return wait
+}
+
+// restoreThawedBlockToFile restores the block, and waits for the block to be thawed if it's archived. The thaw
+// is checked by the interval until the block can be read again, the timeout, or the context is done.
+func (s blockSources) restoreThawedBlockToFile(ctx context.Context, wait thawWait, slots decompressionSlots, volumeName string, volDev *os.File, decompression string, blk BlockMapping) error {
+	err := s.restoreBlockToFile(slots, volumeName, volDev, decompression, blk)
+	if err == nil || !IsObjectArchivedError(err) || wait.timeout < 0 {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wait.timeout)
+	defer cancel()
+	blkFile := getBlockFilePath(volumeName, blk.BlockChecksum)
+	log.WithField(LogFieldVolume, volumeName).Infof("Waiting for archived block %v to be thawed", blkFile)
+	for {
+		thawed, thawErr := s.waitForThawedBlock(ctx, wait.interval, volumeName, blk.BlockChecksum)
+		if thawErr != nil {
+			return thawErr
+		}
+		if !thawed {
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return fmt.Errorf("cancelled waiting for archived block %v to be thawed: %w", blkFile, err)
+			}
+			return fmt.Errorf("timed out waiting for archived block %v to be thawed: %w", blkFile, err)
+		}
+		if err = s.restoreBlockToFile(slots, volumeName, volDev, decompression, blk); err == nil || !IsObjectArchivedError(err) {
+			return err
+		}
+	}
+}
+
+// waitForThawedBlock waits until any backup target can read the archived block, and returns false if the
+// context is done before it. The block is read again after the interval by the targets not reporting the thaws.
+func (s blockSources) waitForThawedBlock(ctx context.Context, interval time.Duration, volumeName, checksum string) (bool, error) {
+	for {
+		thawing := false
+		for _, source := range s {
+			archived, ok := findDriver[ArchivedObjectBackupStoreDriver](source.driver)
+			if !ok {
+				continue
+			}
+			thawing = true
+			thawed, err := archived.ThawObject(findBlockFilePath(source.driver, volumeName, checksum))
+			if err != nil {
+				return false, err
+			}
+			if thawed {
+				return true, nil
+			}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, nil
+		case <-timer.C:
+		}
+		if !thawing {
+			return true, nil
+		}
+	}
+}
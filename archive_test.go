@@ -0,0 +1,173 @@
+package backupstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const archivingMockDriverName = "archivemock"
+
+// archivingMockDriver thaws the archived blocks after they're requested a number of times.
+type archivingMockDriver struct {
+	*mockStoreDriver
+	lock     sync.Mutex
+	requests map[string]int
+	delay    int
+}
+
+func (d *archivingMockDriver) ThawObject(filePath string) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.requests[filePath]++
+	return d.requests[filePath] > d.delay, nil
+}
+
+func TestThawBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	volumeName := "pvc-1"
+	assert.NoError(saveVolume(m, &Volume{Name: volumeName, Size: 3 * DEFAULT_BLOCK_SIZE, CompressionMethod: "lz4"}))
+	assert.NoError(saveBackup(m, &Backup{
+		Name:              "backup-1",
+		VolumeName:        volumeName,
+		CreatedTime:       "2024-01-01T00:00:00Z",
+		CompressionMethod: "lz4",
+		Blocks: []BlockMapping{
+			{Offset: 0, BlockChecksum: "checksum-1"},
+			{Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: "checksum-2"},
+			{Offset: 2 * DEFAULT_BLOCK_SIZE, BlockChecksum: "checksum-1"},
+		},
+	}))
+
+	// The blocks of the drivers without archival storage classes can always be read
+	status, err := ThawBackup(EncodeBackupURL("backup-1", volumeName, mockDriverURL))
+	assert.NoError(err)
+	assert.Equal(&ThawStatus{TotalBlocks: 2, ThawedBlocks: 2, Progress: 100}, status)
+
+	d := &archivingMockDriver{mockStoreDriver: m, requests: map[string]int{}, delay: 1}
+	assert.NoError(RegisterDriver(archivingMockDriverName, func(destURL string) (BackupStoreDriver, error) { return d, nil }))
+	defer unregisterDriver(archivingMockDriverName) // nolint:errcheck
+	backupURL := EncodeBackupURL("backup-1", volumeName, archivingMockDriverName+"://localhost")
+
+	status, err = ThawBackup(backupURL)
+	assert.NoError(err)
+	assert.Equal(&ThawStatus{TotalBlocks: 2, ThawingBlocks: 2, Progress: 0}, status)
+	assert.Equal(1, d.requests[getBlockFilePath(volumeName, "checksum-1")])
+
+	status, err = ThawBackup(backupURL)
+	assert.NoError(err)
+	assert.Equal(&ThawStatus{TotalBlocks: 2, ThawedBlocks: 2, Progress: 100}, status)
+
+	_, err = ThawBackup(EncodeBackupURL("backup-2", volumeName, mockDriverURL))
+	assert.Error(err)
+
+	assert.True(IsObjectArchivedError(fmt.Errorf("failed to read block: %w", &ErrObjectArchived{Path: "blocks/1.blk"})))
+}
+
+// thawingMockDriver reports the blocks as archived by a number of reads before they can be read.
+type thawingMockDriver struct {
+	*mockStoreDriver
+	lock          sync.Mutex
+	archivedReads int
+	reads         int
+	thawChecks    int
+}
+
+func (d *thawingMockDriver) Read(src string) (io.ReadCloser, error) {
+	d.lock.Lock()
+	d.reads++
+	archived := d.reads <= d.archivedReads
+	d.lock.Unlock()
+	if archived {
+		return nil, &ErrObjectArchived{Path: src}
+	}
+	return d.mockStoreDriver.Read(src)
+}
+
+func (d *thawingMockDriver) ThawObject(filePath string) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.thawChecks++
+	// The block is thawed every other check
+	return d.thawChecks%2 == 0, nil
+}
+
+func TestRestoreThawedBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	data := bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE)
+	checksum := util.GetChecksum(data)
+	rs, err := util.CompressData("lz4", data)
+	assert.NoError(err)
+	compressed, err := io.ReadAll(rs)
+	assert.NoError(err)
+	assert.NoError(afero.WriteFile(m.fs, getBlockFilePath("pvc-1", checksum), compressed, 0644))
+	blk := BlockMapping{Offset: 0, BlockChecksum: checksum}
+
+	volDev, err := os.Create(filepath.Join(t.TempDir(), "volume"))
+	assert.NoError(err)
+	defer volDev.Close()
+
+	wait := getThawWait(&DeltaRestoreConfig{ThawRetryInterval: time.Millisecond, ThawTimeout: 10 * time.Second})
+	d := &thawingMockDriver{mockStoreDriver: m, archivedReads: 3}
+	sources := blockSources{{driver: d}}
+	assert.NoError(sources.restoreThawedBlockToFile(context.Background(), wait, nil, "pvc-1", volDev, "lz4", blk))
+	assert.Equal(4, d.reads)
+	assert.Equal(6, d.thawChecks)
+	restored, err := os.ReadFile(volDev.Name())
+	assert.NoError(err)
+	assert.Equal(data, restored)
+
+	// The restore fails on the first archived block without the wait
+	d = &thawingMockDriver{mockStoreDriver: m, archivedReads: 1}
+	sources = blockSources{{driver: d}}
+	err = sources.restoreThawedBlockToFile(context.Background(), thawWait{interval: time.Millisecond, timeout: -1},
+		nil, "pvc-1", volDev, "lz4", blk)
+	assert.True(IsObjectArchivedError(err))
+	assert.Equal(1, d.reads)
+
+	// The wait is bounded by the timeout and the context
+	d = &thawingMockDriver{mockStoreDriver: m, archivedReads: 1 << 30}
+	sources = blockSources{{driver: d}}
+	err = sources.restoreThawedBlockToFile(context.Background(), thawWait{interval: time.Millisecond, timeout: 20 * time.Millisecond},
+		nil, "pvc-1", volDev, "lz4", blk)
+	assert.True(IsObjectArchivedError(err))
+	assert.Contains(err.Error(), "timed out")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = sources.restoreThawedBlockToFile(ctx, wait, nil, "pvc-1", volDev, "lz4", blk)
+	assert.True(IsObjectArchivedError(err))
+	assert.Contains(err.Error(), "cancelled")
+
+	// The archived block is read from the mirror holding it
+	mirror := &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: "mock2://localhost"}
+	assert.NoError(afero.WriteFile(mirror.fs, getBlockFilePath("pvc-1", checksum), compressed, 0644))
+	d = &thawingMockDriver{mockStoreDriver: m, archivedReads: 1 << 30}
+	sources = blockSources{{driver: d}, {driver: mirror}}
+	assert.NoError(sources.restoreThawedBlockToFile(context.Background(), wait, nil, "pvc-1", volDev, "lz4", blk))
+	assert.Equal(0, d.thawChecks)
+
+	config := &DeltaRestoreConfig{}
+	assert.Equal(thawWait{interval: DefaultThawRetryInterval, timeout: DefaultThawTimeout}, getThawWait(config))
+}
@@ -0,0 +1,137 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore"
+)
+
+const (
+	// AccessTierOption is the backup target URL query parameter setting the access tier of the block blobs, e.g.
+	// azblob://container@core.windows.net/path/?accessTier=Cool. The other blobs, e.g. the configs and the locks,
+	// are kept in the Hot tier since they're small and frequently accessed.
+	AccessTierOption = "accessTier"
+
+	// The backup target URL query parameters of the rehydration of the archived blobs, e.g.
+	// azblob://container@core.windows.net/path/?accessTier=Archive&rehydrateTier=Hot&rehydratePriority=High.
+	// Unlike the restores of S3 Glacier, the rehydrated blobs are moved to the tier until they're archived again.
+	RehydrateTierOption     = "rehydrateTier"
+	RehydratePriorityOption = "rehydratePriority"
+
+	DefaultRehydrateTier     = blob.AccessTierCool
+	DefaultRehydratePriority = blob.RehydratePriorityStandard
+)
+
+// blockBlobAccessTiers are the access tiers of the block blobs, the others are the tiers of the premium page
+// blobs.
+var blockBlobAccessTiers = []blob.AccessTier{blob.AccessTierHot, blob.AccessTierCool, blob.AccessTierCold,
+	blob.AccessTierArchive}
+
+// accessTierOptions are the access tier of the block blobs and the rehydration options of the archived blobs.
+type accessTierOptions struct {
+	tier              blob.AccessTier
+	rehydrateTier     blob.AccessTier
+	rehydratePriority blob.RehydratePriority
+}
+
+func parseAccessTierOptions(values url.Values) (*accessTierOptions, error) {
+	opts := &accessTierOptions{
+		rehydrateTier:     DefaultRehydrateTier,
+		rehydratePriority: DefaultRehydratePriority,
+	}
+	if value := values.Get(AccessTierOption); value != "" {
+		tier, err := parseAccessTier(AccessTierOption, value, blockBlobAccessTiers)
+		if err != nil {
+			return nil, err
+		}
+		opts.tier = tier
+	}
+	if value := values.Get(RehydrateTierOption); value != "" {
+		tier, err := parseAccessTier(RehydrateTierOption, value,
+			[]blob.AccessTier{blob.AccessTierHot, blob.AccessTierCool, blob.AccessTierCold})
+		if err != nil {
+			return nil, err
+		}
+		opts.rehydrateTier = tier
+	}
+	if value := values.Get(RehydratePriorityOption); value != "" {
+		supported := []string{}
+		for _, priority := range blob.PossibleRehydratePriorityValues() {
+			if value == string(priority) {
+				opts.rehydratePriority = priority
+				return opts, nil
+			}
+			supported = append(supported, string(priority))
+		}
+		return nil, fmt.Errorf("invalid %v option %v in backup target URL, must be one of %v",
+			RehydratePriorityOption, value, strings.Join(supported, ", "))
+	}
+	return opts, nil
+}
+
+func parseAccessTier(option, value string, tiers []blob.AccessTier) (blob.AccessTier, error) {
+	supported := []string{}
+	for _, tier := range tiers {
+		if value == string(tier) {
+			return tier, nil
+		}
+		supported = append(supported, string(tier))
+	}
+	return "", fmt.Errorf("invalid %v option %v in backup target URL, must be one of %v", option, value,
+		strings.Join(supported, ", "))
+}
+
+// getAccessTier returns the access tier of the blob, or nil for the default tier of the account if the access
+// tier isn't set.
+func (s *service) getAccessTier(blobName string) *blob.AccessTier {
+	if s.accessTier.tier == "" {
+		return nil
+	}
+	if !strings.HasSuffix(blobName, backupstore.BLK_SUFFIX) {
+		tier := blob.AccessTierHot
+		return &tier
+	}
+	tier := s.accessTier.tier
+	return &tier
+}
+
+func isBlobArchivedError(err error) bool {
+	return bloberror.HasCode(err, bloberror.BlobArchived)
+}
+
+// rehydrateBlob requests the rehydration of the archived blob unless it's already requested, and returns true if
+// the blob can be read, i.e. it isn't in the Archive tier.
+func (s *service) rehydrateBlob(blobName string) (bool, error) {
+	props, err := s.getBlobProperties(blobName)
+	if err != nil {
+		return false, err
+	}
+	if props.AccessTier == nil || *props.AccessTier != string(blob.AccessTierArchive) {
+		return true, nil
+	}
+	if props.ArchiveStatus != nil && strings.HasPrefix(*props.ArchiveStatus, "rehydrate-pending") {
+		return false, nil
+	}
+
+	blobClient := s.ContainerClient.NewBlobClient(blobName)
+	priority := s.accessTier.rehydratePriority
+	_, err = blobClient.SetTier(context.Background(), s.accessTier.rehydrateTier, &blob.SetTierOptions{
+		RehydratePriority: &priority,
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobBeingRehydrated) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to rehydrate archived blob %v", blobName)
+	}
+	log.Infof("Requested rehydration of archived blob %v to %v tier with %v priority", blobName,
+		s.accessTier.rehydrateTier, priority)
+	return false, nil
+}
@@ -0,0 +1,172 @@
+package azblob
+
+import (
+	"bytes"
+	"io"
+	gohttp "net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+)
+
+func TestParseAccessTierOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		query    string
+		expected *accessTierOptions
+	}{
+		{"", &accessTierOptions{rehydrateTier: DefaultRehydrateTier, rehydratePriority: DefaultRehydratePriority}},
+		{"accessTier=Cool", &accessTierOptions{tier: blob.AccessTierCool, rehydrateTier: DefaultRehydrateTier,
+			rehydratePriority: DefaultRehydratePriority}},
+		{"accessTier=Cold", &accessTierOptions{tier: blob.AccessTierCold, rehydrateTier: DefaultRehydrateTier,
+			rehydratePriority: DefaultRehydratePriority}},
+		{"accessTier=Archive&rehydrateTier=Hot&rehydratePriority=High", &accessTierOptions{tier: blob.AccessTierArchive,
+			rehydrateTier: blob.AccessTierHot, rehydratePriority: blob.RehydratePriorityHigh}},
+		{"accessTier=Hot&rehydrateTier=Cold", &accessTierOptions{tier: blob.AccessTierHot,
+			rehydrateTier: blob.AccessTierCold, rehydratePriority: DefaultRehydratePriority}},
+		// The tiers of the premium page blobs are refused by the block blobs
+		{"accessTier=P10", nil},
+		{"accessTier=Premium", nil},
+		{"accessTier=cool", nil},
+		{"rehydrateTier=Archive", nil},
+		{"rehydratePriority=Low", nil},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err)
+		opts, err := parseAccessTierOptions(values)
+		if tc.expected == nil {
+			assert.Error(err, tc.query)
+			continue
+		}
+		assert.NoError(err, tc.query)
+		assert.Equal(tc.expected, opts, tc.query)
+	}
+}
+
+func TestGetAccessTier(t *testing.T) {
+	assert := assert.New(t)
+
+	blockFile := "backupstore/volumes/5e/b6/pvc-1/blocks/12/34/1234" + backupstore.BLK_SUFFIX
+	for _, tc := range []struct {
+		tier     blob.AccessTier
+		blobName string
+		expected *blob.AccessTier
+	}{
+		{"", blockFile, nil},
+		{"", "backupstore/volumes/5e/b6/pvc-1/volume.cfg", nil},
+		{blob.AccessTierArchive, blockFile, to(blob.AccessTierArchive)},
+		{blob.AccessTierCool, blockFile, to(blob.AccessTierCool)},
+		// The other blobs are kept in the Hot tier
+		{blob.AccessTierArchive, "backupstore/volumes/5e/b6/pvc-1/volume.cfg", to(blob.AccessTierHot)},
+		{blob.AccessTierArchive, "backupstore/volumes/5e/b6/pvc-1/backups/backup_backup-1.cfg", to(blob.AccessTierHot)},
+		{blob.AccessTierCold, "backupstore/volumes/5e/b6/pvc-1/locks/lock-1.lck", to(blob.AccessTierHot)},
+	} {
+		s := &service{accessTier: &accessTierOptions{tier: tc.tier}}
+		assert.Equal(tc.expected, s.getAccessTier(tc.blobName), "%v %v", tc.tier, tc.blobName)
+	}
+}
+
+func to[T any](v T) *T {
+	return &v
+}
+
+// archiveTransport serves the blobs in the Archive tier until their rehydration is requested.
+type archiveTransport struct {
+	lock      sync.Mutex
+	tiers     map[string]string
+	rehydrate map[string]string
+}
+
+func (a *archiveTransport) Do(req *gohttp.Request) (*gohttp.Response, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	name := strings.TrimPrefix(req.URL.Path, "/container/")
+	resp := &gohttp.Response{Header: gohttp.Header{}, Body: io.NopCloser(&bytes.Buffer{}), Request: req}
+	tier, exists := a.tiers[name]
+	switch {
+	case !exists:
+		resp.StatusCode = gohttp.StatusNotFound
+		resp.Header.Set("x-ms-error-code", "BlobNotFound")
+	case req.Method == gohttp.MethodHead:
+		resp.StatusCode = gohttp.StatusOK
+		resp.Header.Set("x-ms-access-tier", tier)
+		if rehydrate, ok := a.rehydrate[name]; ok {
+			resp.Header.Set("x-ms-archive-status", "rehydrate-pending-to-"+strings.ToLower(rehydrate))
+		}
+	case req.Method == gohttp.MethodPut && req.URL.Query().Get("comp") == "tier":
+		// The headers of the SDK are not canonicalized
+		for key, values := range req.Header {
+			if strings.EqualFold(key, "x-ms-access-tier") {
+				a.rehydrate[name] = values[0]
+			}
+		}
+		resp.StatusCode = gohttp.StatusAccepted
+	case req.Method == gohttp.MethodGet && tier == string(blob.AccessTierArchive):
+		resp.StatusCode = gohttp.StatusConflict
+		resp.Header.Set("x-ms-error-code", "BlobArchived")
+	case req.Method == gohttp.MethodGet:
+		resp.StatusCode = gohttp.StatusOK
+		resp.Body = io.NopCloser(strings.NewReader("data"))
+		resp.ContentLength = 4
+		resp.Header.Set("Content-Length", "4")
+	default:
+		resp.StatusCode = gohttp.StatusBadRequest
+	}
+	return resp, nil
+}
+
+func TestReadArchivedBlob(t *testing.T) {
+	assert := assert.New(t)
+
+	transport := &archiveTransport{
+		tiers: map[string]string{
+			"path/blocks/archived.blk": string(blob.AccessTierArchive),
+			"path/blocks/cool.blk":     string(blob.AccessTierCool),
+		},
+		rehydrate: map[string]string{},
+	}
+	client, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container?sig=abc",
+		&container.ClientOptions{ClientOptions: azcore.ClientOptions{
+			Transport: transport,
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		}})
+	assert.NoError(err)
+	opts, err := parseAccessTierOptions(url.Values{RehydrateTierOption: {"Hot"}})
+	assert.NoError(err)
+	d := &BackupStoreDriver{path: "path", service: &service{ContainerClient: client, accessTier: opts}}
+
+	rc, err := d.Read("blocks/cool.blk")
+	assert.NoError(err)
+	data, err := io.ReadAll(rc)
+	assert.NoError(err)
+	assert.Equal("data", string(data))
+	thawed, err := d.ThawObject("blocks/cool.blk")
+	assert.NoError(err)
+	assert.True(thawed)
+
+	// The read of the archived blob requests the rehydration
+	_, err = d.Read("blocks/archived.blk")
+	assert.True(backupstore.IsObjectArchivedError(err))
+	assert.Equal(map[string]string{"path/blocks/archived.blk": "Hot"}, transport.rehydrate)
+	_, err = d.ReadRange("blocks/archived.blk", 0, 2)
+	assert.True(backupstore.IsObjectArchivedError(err))
+	thawed, err = d.ThawObject("blocks/archived.blk")
+	assert.NoError(err)
+	assert.False(thawed)
+
+	// The other errors are returned as is
+	_, err = d.Read("blocks/missing.blk")
+	assert.Error(err)
+	assert.False(backupstore.IsObjectArchivedError(err))
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/sirupsen/logrus"
 
 	"github.com/longhorn/backupstore"
@@ -46,8 +47,12 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 		return nil, fmt.Errorf("wrong driver dispatching %v to %v?", u.Scheme, KIND)
 	}
 
+	credential, err := backupstore.LoadTargetCredential(destURL)
+	if err != nil {
+		return nil, err
+	}
 	b := &BackupStoreDriver{}
-	b.service, err = newService(u)
+	b.service, err = newService(u, credential)
 	if err != nil {
 		return nil, err
 	}
@@ -146,21 +151,101 @@ func (s *BackupStoreDriver) Remove(path string) error {
 	return s.service.deleteBlobs(s.updatePath(path))
 }
 
+// RemoveAll removes the files by the blob batches.
+func (s *BackupStoreDriver) RemoveAll(paths []string) error {
+	blobs := make([]string, 0, len(paths))
+	blobPaths := make(map[string]string, len(paths))
+	for _, path := range paths {
+		blob := s.updatePath(path)
+		blobs = append(blobs, blob)
+		blobPaths[blob] = path
+	}
+	failures := s.service.deleteBlobsBatch(blobs)
+	if len(failures) == 0 {
+		return nil
+	}
+	removeErr := &backupstore.ErrRemoveFailed{Errors: make(map[string]error, len(failures))}
+	for blob, err := range failures {
+		removeErr.Errors[blobPaths[blob]] = err
+	}
+	return removeErr
+}
+
 func (s *BackupStoreDriver) Read(src string) (io.ReadCloser, error) {
 	path := s.updatePath(src)
 	rc, err := s.service.getBlob(path)
 	if err != nil {
-		return nil, err
+		return nil, s.rehydrateIfArchived(src, err)
 	}
 	return rc, nil
 }
 
+// ThawObject requests the rehydration of the archived blob, and returns true if the blob can be read.
+func (s *BackupStoreDriver) ThawObject(filePath string) (bool, error) {
+	return s.service.rehydrateBlob(s.updatePath(filePath))
+}
+
+// rehydrateIfArchived requests the rehydration of the blob if it cannot be read for being in the Archive tier, so
+// the read succeeds after the blob is rehydrated.
+func (s *BackupStoreDriver) rehydrateIfArchived(filePath string, err error) error {
+	if !isBlobArchivedError(err) {
+		return err
+	}
+	if _, thawErr := s.ThawObject(filePath); thawErr != nil {
+		log.WithError(thawErr).Warnf("Failed to request rehydration of archived blob %v", filePath)
+	}
+	return &backupstore.ErrObjectArchived{Path: filePath}
+}
+
 // Write creates a item on the backup target from io stream
 func (s *BackupStoreDriver) Write(dst string, rs io.ReadSeeker) error {
 	path := s.updatePath(dst)
 	return s.service.putBlob(path, rs)
 }
 
+// WriteWithMetadata creates a item with the HTTP metadata on the backup target from io stream
+func (s *BackupStoreDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *backupstore.ObjectMetadata) error {
+	headers := &blob.HTTPHeaders{}
+	if metadata.ContentType != "" {
+		headers.BlobContentType = &metadata.ContentType
+	}
+	if metadata.ContentEncoding != "" {
+		headers.BlobContentEncoding = &metadata.ContentEncoding
+	}
+	return s.service.putBlobWithHeaders(s.updatePath(dst), rs, headers)
+}
+
+// GetMetadata returns the HTTP metadata of the item on the backup target
+func (s *BackupStoreDriver) GetMetadata(filePath string) (*backupstore.ObjectMetadata, error) {
+	blobProp, err := s.service.getBlobProperties(s.updatePath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	metadata := &backupstore.ObjectMetadata{}
+	if blobProp.ContentType != nil {
+		metadata.ContentType = *blobProp.ContentType
+	}
+	if blobProp.ContentEncoding != nil {
+		metadata.ContentEncoding = *blobProp.ContentEncoding
+	}
+	return metadata, nil
+}
+
+// ReadRange reads the data range of the item on the backup target, a negative length reads the data until the end
+func (s *BackupStoreDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if length < 0 {
+		length = 0
+	}
+	rc, err := s.service.getBlobRange(s.updatePath(src), offset, length)
+	if err != nil {
+		return nil, s.rehydrateIfArchived(src, err)
+	}
+	return rc, nil
+}
+
 // Upload creates a item on the backup target by opening source file
 func (s *BackupStoreDriver) Upload(src, dst string) error {
 	file, err := os.Open(src)
@@ -192,7 +277,7 @@ func (s *BackupStoreDriver) Download(src, dst string) error {
 	path := s.updatePath(src)
 	rc, err := s.service.getBlob(path)
 	if err != nil {
-		return err
+		return s.rehydrateIfArchived(src, err)
 	}
 	defer rc.Close()
 
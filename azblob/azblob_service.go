@@ -4,19 +4,24 @@ import (
 	"context"
 	"fmt"
 	"io"
+	gohttp "net/http"
 	"net/url"
-	"os"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/pkg/errors"
 
 	azblobsvc "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
 
+	"github.com/longhorn/backupstore"
 	"github.com/longhorn/backupstore/http"
+	"github.com/longhorn/backupstore/types"
 )
 
 const (
@@ -25,16 +30,26 @@ const (
 	blobEndpoint       = "BlobEndpoint=%s;"
 	blobEndpointScheme = "DefaultEndpointsProtocol=%s;"
 	blobEndpointSuffix = "EndpointSuffix=%s;"
+
+	// maxDeleteBlobsBatch is the max number of the sub-requests of a blob batch
+	maxDeleteBlobsBatch = 256
 )
 
 type service struct {
 	Container       string
 	EndpointSuffix  string
 	ContainerClient *container.Client
+
+	accessTier *accessTierOptions
+
+	// credential is the credential of the backup target, the environment variables are used if it's nil
+	credential map[string]string
+	// getenv looks up the settings in the credential, see backupstore.TargetEnv
+	getenv func(string) string
 }
 
-func newService(u *url.URL) (*service, error) {
-	s := service{}
+func newService(u *url.URL, credential map[string]string) (*service, error) {
+	s := service{credential: credential, getenv: backupstore.TargetEnv(credential)}
 	if u.User != nil {
 		s.EndpointSuffix = u.Host
 		s.Container = u.User.Username()
@@ -42,9 +57,80 @@ func newService(u *url.URL) (*service, error) {
 		s.Container = u.Host
 	}
 
-	accountName := os.Getenv("AZBLOB_ACCOUNT_NAME")
-	accountKey := os.Getenv("AZBLOB_ACCOUNT_KEY")
-	azureEndpoint := os.Getenv("AZBLOB_ENDPOINT")
+	source, err := parseCredentialSource(u.Query(), s.getenv)
+	if err != nil {
+		return nil, err
+	}
+	if s.accessTier, err = parseAccessTierOptions(u.Query()); err != nil {
+		return nil, err
+	}
+
+	tlsOpts, err := http.LoadTLSOptions(s.getenv, http.TLSEnvKeys{
+		CACerts:            types.AZBlobCert,
+		ClientCert:         types.AZBlobClientCert,
+		ClientKey:          types.AZBlobClientKey,
+		InsecureSkipVerify: types.AZBlobInsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tlsOpts.InsecureSkipVerify {
+		log.Warnf("Skipping TLS verification of Azure Blob Storage container %v", s.Container)
+	}
+	httpClient, err := http.GetClientWithOptions(tlsOpts, http.LoadProxyOptions(s.credential))
+	if err != nil {
+		return nil, err
+	}
+	s.ContainerClient, err = s.newContainerClient(source, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// newContainerClient returns the client authenticated by the account key, the SAS token, or the Azure AD
+// credential of the source.
+func (s *service) newContainerClient(source string, httpClient *gohttp.Client) (*container.Client, error) {
+	accountName := s.getenv(types.AZBlobAccountName)
+	accountKey := s.getenv(types.AZBlobAccountKey)
+	azureEndpoint := s.getenv(types.AZBlobEndpoint)
+	sasToken := s.getenv(types.AZBlobSASToken)
+
+	source, err := resolveCredentialSource(source, accountKey, sasToken)
+	if err != nil {
+		return nil, err
+	}
+	if accountName == "" {
+		return nil, fmt.Errorf("missing %v for %v credential source", types.AZBlobAccountName, source)
+	}
+
+	switch source {
+	case CredentialSourceSharedKey:
+	case CredentialSourceSAS:
+		// The SAS token may be scoped to the container, so the container is accessed without the service client
+		sas, err := parseSASToken(sasToken)
+		if err != nil {
+			return nil, err
+		}
+		containerURL := s.getServiceURL(accountName, azureEndpoint) + url.PathEscape(s.Container) + "?" + sas.query
+		return container.NewClientWithNoCredential(containerURL, &container.ClientOptions{
+			ClientOptions: azcore.ClientOptions{
+				Transport:       httpClient,
+				PerCallPolicies: []policy.Policy{sasExpiryPolicy{token: sas}},
+			},
+		})
+	default:
+		credential, err := s.newTokenCredential(source, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		serviceClient, err := azblobsvc.NewClient(s.getServiceURL(accountName, azureEndpoint), credential,
+			&azblobsvc.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: httpClient}})
+		if err != nil {
+			return nil, err
+		}
+		return serviceClient.NewContainerClient(s.Container), nil
+	}
 
 	connStr := fmt.Sprintf(azureConnNameKey, accountName, accountKey)
 	if azureEndpoint != "" {
@@ -59,30 +145,24 @@ func newService(u *url.URL) (*service, error) {
 	if s.EndpointSuffix != azureURL {
 		connStr = connStr + fmt.Sprintf(blobEndpointSuffix, s.EndpointSuffix)
 	}
-
-	customCerts := getCustomCerts()
-	httpClient, err := http.GetClientWithCustomCerts(customCerts)
+	serviceClient, err := azblobsvc.NewClientFromConnectionString(connStr,
+		&azblobsvc.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: httpClient}})
 	if err != nil {
 		return nil, err
 	}
-	opts := azblobsvc.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: httpClient}}
-	serviceClient, err := azblobsvc.NewClientFromConnectionString(connStr, &opts)
-	if err != nil {
-		return nil, err
-	}
-
-	s.ContainerClient = serviceClient.NewContainerClient(s.Container)
-	return &s, nil
+	return serviceClient.NewContainerClient(s.Container), nil
 }
 
-func getCustomCerts() []byte {
-	// Certificates in PEM format (base64)
-	certs := os.Getenv("AZBLOB_CERT")
-	if certs == "" {
-		return nil
+// getServiceURL returns the URL of the blob service of the account, like the one of the connection string.
+func (s *service) getServiceURL(accountName, azureEndpoint string) string {
+	if azureEndpoint != "" {
+		return fmt.Sprintf("%s/%s/", strings.TrimRight(azureEndpoint, "/"), accountName)
 	}
-
-	return []byte(certs)
+	endpointSuffix := s.EndpointSuffix
+	if endpointSuffix == "" {
+		endpointSuffix = azureURL
+	}
+	return fmt.Sprintf("https://%s.blob.%s/", accountName, endpointSuffix)
 }
 
 func (s *service) listBlobs(prefix, delimiter string) (*[]string, error) {
@@ -118,9 +198,16 @@ func (s *service) getBlobProperties(blob string) (*blob.GetPropertiesResponse, e
 }
 
 func (s *service) putBlob(blob string, reader io.ReadSeeker) error {
-	blobClient := s.ContainerClient.NewBlockBlobClient(blob)
+	return s.putBlobWithHeaders(blob, reader, nil)
+}
+
+func (s *service) putBlobWithHeaders(blobName string, reader io.ReadSeeker, headers *blob.HTTPHeaders) error {
+	blobClient := s.ContainerClient.NewBlockBlobClient(blobName)
 
-	_, err := blobClient.Upload(context.Background(), streaming.NopCloser(reader), nil)
+	_, err := blobClient.Upload(context.Background(), streaming.NopCloser(reader), &blockblob.UploadOptions{
+		HTTPHeaders: headers,
+		Tier:        s.getAccessTier(blobName),
+	})
 	if err != nil {
 		return err
 	}
@@ -129,9 +216,19 @@ func (s *service) putBlob(blob string, reader io.ReadSeeker) error {
 }
 
 func (s *service) getBlob(blob string) (io.ReadCloser, error) {
-	blobClient := s.ContainerClient.NewBlockBlobClient(blob)
+	return s.getBlobRange(blob, 0, 0)
+}
 
-	response, err := blobClient.DownloadStream(context.Background(), nil)
+// getBlobRange downloads the data range of the blob, a zero count reads the data until the end.
+func (s *service) getBlobRange(blobName string, offset, count int64) (io.ReadCloser, error) {
+	blobClient := s.ContainerClient.NewBlockBlobClient(blobName)
+
+	// Explicitly request the stored data, otherwise the HTTP transport transparently
+	// decompresses the blobs stored with the gzip Content-Encoding
+	ctx := policy.WithHTTPHeader(context.Background(), gohttp.Header{"Accept-Encoding": []string{"identity"}})
+	response, err := blobClient.DownloadStream(ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset, Count: count},
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -161,3 +258,52 @@ func (s *service) deleteBlobs(blob string) error {
 
 	return nil
 }
+
+// deleteBlobsBatch deletes the blobs by the batches of maxDeleteBlobsBatch blobs, and returns the errors of the
+// blobs which cannot be deleted. The missing blobs are ignored.
+func (s *service) deleteBlobsBatch(blobs []string) map[string]error {
+	failures := map[string]error{}
+	for start := 0; start < len(blobs); start += maxDeleteBlobsBatch {
+		end := start + maxDeleteBlobsBatch
+		if end > len(blobs) {
+			end = len(blobs)
+		}
+		if err := s.submitDeleteBatch(blobs[start:end], failures); err != nil {
+			for _, blob := range blobs[start:end] {
+				failures[blob] = err
+			}
+		}
+	}
+	return failures
+}
+
+func (s *service) submitDeleteBatch(blobs []string, failures map[string]error) error {
+	batch, err := s.ContainerClient.NewBatchBuilder()
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if err := batch.Delete(blob, nil); err != nil {
+			return err
+		}
+	}
+	resp, err := s.ContainerClient.SubmitBatch(context.Background(), batch, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to submit batch delete of blobs")
+	}
+	for i, item := range resp.Responses {
+		if item.Error == nil || bloberror.HasCode(item.Error, bloberror.BlobNotFound) {
+			continue
+		}
+		// The content ID is the index of the sub-request, the blob name parsed from the URL doesn't match the
+		// blob if the endpoint has a path, e.g. Azurite
+		index := i
+		if item.ContentID != nil {
+			index = *item.ContentID
+		}
+		if index < len(blobs) {
+			failures[blobs[index]] = item.Error
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package azblob
+
+import (
+	"fmt"
+	gohttp "net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// AzureCredentialSourceOption is the backup target URL query parameter selecting the Azure AD credential
+	// instead of the account key, e.g. azblob://container@core.windows.net/path/?azureCredentialSource=workloadIdentity
+	// The tenant, the client and the token file default to AZURE_TENANT_ID, AZURE_CLIENT_ID and
+	// AZURE_FEDERATED_TOKEN_FILE injected by the workload identity webhook. The source may also be selected by
+	// AZBLOB_CREDENTIAL_SOURCE of the credential if the URL doesn't set it.
+	AzureCredentialSourceOption = "azureCredentialSource"
+
+	// CredentialSourceSharedKey uses AZBLOB_ACCOUNT_NAME and AZBLOB_ACCOUNT_KEY
+	CredentialSourceSharedKey = "sharedKey"
+	// CredentialSourceSAS uses the SAS token AZBLOB_SAS_TOKEN of the account or the container, it's the default if
+	// the SAS token is set without the account key
+	CredentialSourceSAS = "sas"
+	// CredentialSourceClientSecret uses the client secret of the service principal
+	CredentialSourceClientSecret = "clientSecret"
+	// CredentialSourceWorkloadIdentity uses the federated token, e.g. the service account token of AKS
+	CredentialSourceWorkloadIdentity = "workloadIdentity"
+	// CredentialSourceManagedIdentity uses the managed identity of the VM, the user-assigned identity is
+	// selected by AZURE_CLIENT_ID
+	CredentialSourceManagedIdentity = "managedIdentity"
+)
+
+func parseCredentialSource(values url.Values, getenv func(string) string) (string, error) {
+	source := values.Get(AzureCredentialSourceOption)
+	if source == "" {
+		source = getenv(types.AZBlobCredentialSource)
+	}
+	switch source {
+	case "", CredentialSourceSharedKey, CredentialSourceSAS, CredentialSourceClientSecret,
+		CredentialSourceWorkloadIdentity, CredentialSourceManagedIdentity:
+		return source, nil
+	}
+	return "", fmt.Errorf("invalid %v option %v in backup target URL, must be %v, %v, %v, %v or %v",
+		AzureCredentialSourceOption, source, CredentialSourceSharedKey, CredentialSourceSAS,
+		CredentialSourceClientSecret, CredentialSourceWorkloadIdentity, CredentialSourceManagedIdentity)
+}
+
+// resolveCredentialSource returns the credential source of the account, the SAS token is used by default if
+// it's set without the account key.
+func resolveCredentialSource(source, accountKey, sasToken string) (string, error) {
+	switch {
+	case source == CredentialSourceSharedKey && accountKey == "":
+		return "", fmt.Errorf("missing %v for %v credential source", types.AZBlobAccountKey, CredentialSourceSharedKey)
+	case source != "":
+		return source, nil
+	case accountKey != "":
+		return CredentialSourceSharedKey, nil
+	case sasToken != "":
+		return CredentialSourceSAS, nil
+	}
+	return "", fmt.Errorf("missing %v or %v, or %v option in backup target URL", types.AZBlobAccountKey,
+		types.AZBlobSASToken, AzureCredentialSourceOption)
+}
+
+// sasExpiryLayouts are the ISO 8601 formats of the expiry of the SAS tokens.
+var sasExpiryLayouts = []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"}
+
+// sasToken is the query of a SAS token and its expiry, which is zero if the token doesn't expire, e.g. by a
+// stored access policy.
+type sasToken struct {
+	query     string
+	expiresOn time.Time
+}
+
+// parseSASToken parses the query of the SAS token, which may be copied with the leading "?". The expired tokens
+// are refused, since all the requests would fail by the authentication errors.
+func parseSASToken(token string) (*sasToken, error) {
+	token = strings.TrimPrefix(strings.TrimSpace(token), "?")
+	if token == "" {
+		return nil, fmt.Errorf("missing %v for %v credential source", types.AZBlobSASToken, CredentialSourceSAS)
+	}
+	query, err := url.ParseQuery(token)
+	if err != nil || query.Get("sig") == "" {
+		return nil, fmt.Errorf("invalid %v, must be the query of a SAS token with the signature", types.AZBlobSASToken)
+	}
+
+	sas := &sasToken{query: token}
+	if expiry := query.Get("se"); expiry != "" {
+		for _, layout := range sasExpiryLayouts {
+			if sas.expiresOn, err = time.Parse(layout, expiry); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry %v of %v", expiry, types.AZBlobSASToken)
+		}
+	}
+	if err := sas.checkNotExpired(); err != nil {
+		return nil, err
+	}
+	return sas, nil
+}
+
+func (t *sasToken) checkNotExpired() error {
+	if !t.expiresOn.IsZero() && !util.GetClock().Now().Before(t.expiresOn) {
+		return fmt.Errorf("%v expired at %v", types.AZBlobSASToken, t.expiresOn.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// sasExpiryPolicy fails the requests once the SAS token is expired, since the driver may be used longer than the
+// token is valid.
+type sasExpiryPolicy struct {
+	token *sasToken
+}
+
+func (p sasExpiryPolicy) Do(req *policy.Request) (*gohttp.Response, error) {
+	if err := p.token.checkNotExpired(); err != nil {
+		return nil, err
+	}
+	return req.Next()
+}
+
+// newTokenCredential returns the Azure AD credential of the source, the token requests are sent by the client
+// of the backup target.
+func (s *service) newTokenCredential(source string, client *gohttp.Client) (azcore.TokenCredential, error) {
+	tenantID := s.getPlatformEnv(types.AzureTenantID)
+	clientID := s.getPlatformEnv(types.AzureClientID)
+	clientOptions := azcore.ClientOptions{Transport: client}
+	if authorityHost := s.getPlatformEnv(types.AzureAuthorityHost); authorityHost != "" {
+		clientOptions.Cloud = cloud.Configuration{ActiveDirectoryAuthorityHost: authorityHost}
+	}
+
+	switch source {
+	case CredentialSourceClientSecret:
+		clientSecret := s.getenv(types.AzureClientSecret)
+		if tenantID == "" || clientID == "" || clientSecret == "" {
+			return nil, fmt.Errorf("missing %v, %v or %v for %v credential source", types.AzureTenantID,
+				types.AzureClientID, types.AzureClientSecret, CredentialSourceClientSecret)
+		}
+		return azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions})
+	case CredentialSourceWorkloadIdentity:
+		tokenFile := s.getPlatformEnv(types.AzureFederatedTokenFile)
+		if tenantID == "" || clientID == "" || tokenFile == "" {
+			return nil, fmt.Errorf("missing %v, %v or %v for %v credential source", types.AzureTenantID,
+				types.AzureClientID, types.AzureFederatedTokenFile, CredentialSourceWorkloadIdentity)
+		}
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+			ClientID:      clientID,
+			TenantID:      tenantID,
+			TokenFilePath: tokenFile,
+		})
+	case CredentialSourceManagedIdentity:
+		// The instance metadata service is link-local, it's never reached through the proxies
+		transport := gohttp.DefaultTransport.(*gohttp.Transport).Clone()
+		transport.Proxy = nil
+		opts := &azidentity.ManagedIdentityCredentialOptions{
+			ClientOptions: azcore.ClientOptions{Transport: &gohttp.Client{Transport: transport}},
+		}
+		if clientID != "" {
+			opts.ID = azidentity.ClientID(clientID)
+		}
+		return azidentity.NewManagedIdentityCredential(opts)
+	}
+	return nil, fmt.Errorf("BUG: unknown credential source %v", source)
+}
+
+// getPlatformEnv returns the value in the credential of the backup target, or the environment variable injected
+// by the platform, e.g. by the workload identity webhook into the pod, if the credential doesn't set it.
+func (s *service) getPlatformEnv(key string) string {
+	if value := s.getenv(key); value != "" {
+		return value
+	}
+	return os.Getenv(key)
+}
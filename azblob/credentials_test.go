@@ -0,0 +1,134 @@
+package azblob
+
+import (
+	"context"
+	"io"
+	gohttp "net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+type transporterFunc func(req *gohttp.Request) (*gohttp.Response, error)
+
+func (f transporterFunc) Do(req *gohttp.Request) (*gohttp.Response, error) {
+	return f(req)
+}
+
+func TestResolveCredentialSource(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		name       string
+		source     string
+		accountKey string
+		sasToken   string
+		expected   string
+		valid      bool
+	}{
+		{"account key by default", "", "key", "", CredentialSourceSharedKey, true},
+		{"account key preferred to SAS token", "", "key", "sig=abc", CredentialSourceSharedKey, true},
+		{"SAS token without account key", "", "", "sig=abc", CredentialSourceSAS, true},
+		{"selected source", CredentialSourceManagedIdentity, "", "", CredentialSourceManagedIdentity, true},
+		{"selected SAS token", CredentialSourceSAS, "key", "sig=abc", CredentialSourceSAS, true},
+		{"no credential", "", "", "", "", false},
+		{"shared key without account key", CredentialSourceSharedKey, "", "sig=abc", "", false},
+	} {
+		source, err := resolveCredentialSource(tc.source, tc.accountKey, tc.sasToken)
+		if !tc.valid {
+			assert.Error(err, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, source, tc.name)
+	}
+}
+
+func TestParseSASToken(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := util.NewFakeClock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	for _, tc := range []struct {
+		name      string
+		token     string
+		query     string
+		expiresOn time.Time
+		errMsg    string
+	}{
+		{"without expiry", "sv=2022-11-02&sp=rl&sig=abc", "sv=2022-11-02&sp=rl&sig=abc", time.Time{}, ""},
+		{"leading question mark", " ?sv=2022-11-02&sig=abc\n", "sv=2022-11-02&sig=abc", time.Time{}, ""},
+		{"expiry in seconds", "se=2024-07-01T00:00:00Z&sig=abc", "se=2024-07-01T00:00:00Z&sig=abc",
+			time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), ""},
+		{"expiry in minutes", "se=2024-07-01T12:30Z&sig=abc", "se=2024-07-01T12:30Z&sig=abc",
+			time.Date(2024, 7, 1, 12, 30, 0, 0, time.UTC), ""},
+		{"expiry date", "se=2024-07-01&sig=abc", "se=2024-07-01&sig=abc", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), ""},
+		{"empty", "?", "", time.Time{}, "missing"},
+		{"missing signature", "sv=2022-11-02&se=2024-07-01T00:00:00Z", "", time.Time{}, "signature"},
+		{"invalid query", "sig=%zz", "", time.Time{}, "signature"},
+		{"invalid expiry", "se=tomorrow&sig=abc", "", time.Time{}, "invalid expiry"},
+		{"expired", "se=2024-05-31T23:59:59Z&sig=abc", "", time.Time{}, "expired"},
+	} {
+		sas, err := parseSASToken(tc.token)
+		if tc.errMsg != "" {
+			if assert.Error(err, tc.name) {
+				assert.Contains(err.Error(), tc.errMsg, tc.name)
+			}
+			continue
+		}
+		if assert.NoError(err, tc.name) {
+			assert.Equal(tc.query, sas.query, tc.name)
+			assert.True(tc.expiresOn.Equal(sas.expiresOn), tc.name)
+		}
+	}
+}
+
+func TestSASExpiryPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := util.NewFakeClock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	sas, err := parseSASToken("se=2024-06-01T01:00:00Z&sig=abc")
+	assert.NoError(err)
+
+	requests := 0
+	client, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container?"+sas.query,
+		&container.ClientOptions{ClientOptions: azcore.ClientOptions{
+			Transport: transporterFunc(func(req *gohttp.Request) (*gohttp.Response, error) {
+				requests++
+				assert.Equal("abc", req.URL.Query().Get("sig"))
+				return &gohttp.Response{
+					StatusCode: gohttp.StatusOK,
+					Header:     gohttp.Header{},
+					Body:       io.NopCloser(strings.NewReader("")),
+					Request:    req,
+				}, nil
+			}),
+			PerCallPolicies: []policy.Policy{sasExpiryPolicy{token: sas}},
+			Retry:           policy.RetryOptions{MaxRetries: -1},
+		}})
+	assert.NoError(err)
+
+	_, err = client.GetProperties(context.Background(), nil)
+	assert.NoError(err)
+	assert.Equal(1, requests)
+
+	// The requests are refused by the driver once the token is expired
+	clock.Advance(time.Hour)
+	_, err = client.GetProperties(context.Background(), nil)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "expired at 2024-06-01T01:00:00Z")
+	}
+	assert.Equal(1, requests)
+}
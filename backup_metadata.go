@@ -0,0 +1,168 @@
+package backupstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	. "github.com/longhorn/backupstore/logging"
+)
+
+const (
+	// MAX_USER_METADATA_SIZE is the max size of the user metadata document of a backup, which is stored in the
+	// backup config and loaded along with it
+	MAX_USER_METADATA_SIZE = 8 * 1024
+)
+
+// BackupUpdate is the change of the user provided fields of a backup made by UpdateBackup, the nil fields are
+// kept unchanged.
+type BackupUpdate struct {
+	Description *string
+	// UserMetadata replaces the user metadata document, an empty document removes it
+	UserMetadata *json.RawMessage
+	// ExpectedMetadataVersion rejects the update if the metadata of the backup has been updated since the
+	// version, 0 skips the check
+	ExpectedMetadataVersion int64
+}
+
+// backupUpdateLock serializes the read-modify-write of the backup configs in the process, since the backup
+// locks held by the updates don't exclude each other. The updates of the different clients are detected by
+// the expected metadata versions instead.
+var backupUpdateLock sync.Mutex
+
+func validateUserMetadata(metadata json.RawMessage) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+	if len(metadata) > MAX_USER_METADATA_SIZE {
+		return fmt.Errorf("user metadata of size %v exceeds the limit %v", len(metadata), MAX_USER_METADATA_SIZE)
+	}
+	if !json.Valid(metadata) {
+		return fmt.Errorf("invalid JSON user metadata")
+	}
+	return nil
+}
+
+// UpdateBackup changes the description and the user metadata of the completed backup. The metadata version is
+// increased by each update.
+func UpdateBackup(backupURL string, update BackupUpdate) (*BackupInfo, error) {
+	if update.UserMetadata != nil {
+		if err := validateUserMetadata(*update.UserMetadata); err != nil {
+			return nil, err
+		}
+	}
+
+	return modifyBackup(backupURL, "update backup", func(backup *Backup) error {
+		if err := checkMetadataVersion(backup, update.ExpectedMetadataVersion); err != nil {
+			return err
+		}
+		if update.Description != nil {
+			backup.Description = *update.Description
+		}
+		if update.UserMetadata != nil {
+			backup.UserMetadata = *update.UserMetadata
+		}
+		backup.MetadataVersion++
+		return nil
+	})
+}
+
+// checkMetadataVersion rejects the update if the metadata of the backup has been updated since the expected
+// version, 0 skips the check.
+func checkMetadataVersion(backup *Backup, expectedMetadataVersion int64) error {
+	if expectedMetadataVersion != 0 && expectedMetadataVersion != backup.MetadataVersion {
+		return fmt.Errorf("metadata of backup %v has been updated to version %v, expected version %v",
+			backup.Name, backup.MetadataVersion, expectedMetadataVersion)
+	}
+	return nil
+}
+
+// modifyBackup applies the change to the config of the completed backup under the backup lock.
+func modifyBackup(backupURL, operation string, modify func(backup *Backup) error) (*BackupInfo, error) {
+	bsDriver, err := GetBackupStoreDriver(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckTargetMutable(bsDriver, operation); err != nil {
+		return nil, err
+	}
+
+	backupName, volumeName, _, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	log := log.WithFields(logrus.Fields{
+		LogFieldBackup: backupName,
+		LogFieldVolume: volumeName,
+	})
+
+	lock, err := New(bsDriver, volumeName, BACKUP_LOCK)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if unlockErr := lock.Unlock(); unlockErr != nil {
+			log.WithError(unlockErr).Warn("Failed to unlock")
+		}
+	}()
+
+	if err := CheckTargetRedirect(bsDriver); err != nil {
+		return nil, err
+	}
+
+	backupUpdateLock.Lock()
+	defer backupUpdateLock.Unlock()
+
+	backup, err := loadBackup(bsDriver, backupName, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	if isBackupInProgress(backup) {
+		return nil, fmt.Errorf("backup %v of volume %v is still in progress", backupName, volumeName)
+	}
+	if err := checkBackupResignable(bsDriver, backup); err != nil {
+		return nil, err
+	}
+	if err := modify(backup); err != nil {
+		return nil, err
+	}
+	if err := saveBackup(bsDriver, backup); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Updated backup to metadata version %v", backup.MetadataVersion)
+	return fillBackupInfo(backup, bsDriver.GetURL()), nil
+}
+
+// UpdateBackupLabels adds and removes the labels of the completed backup, the added labels replace the
+// existing ones of the same keys. The update is rejected if the metadata of the backup has been updated since
+// expectedMetadataVersion, 0 skips the check. The metadata version is increased by each update.
+func UpdateBackupLabels(backupURL string, add map[string]string, remove []string, expectedMetadataVersion int64) (*BackupInfo, error) {
+	for _, key := range remove {
+		if _, exists := add[key]; exists {
+			return nil, fmt.Errorf("label %v is both added and removed", key)
+		}
+	}
+
+	return modifyBackup(backupURL, "update backup labels", func(backup *Backup) error {
+		if err := checkMetadataVersion(backup, expectedMetadataVersion); err != nil {
+			return err
+		}
+		if backup.Labels == nil && len(add) > 0 {
+			backup.Labels = map[string]string{}
+		}
+		for key, value := range add {
+			backup.Labels[key] = value
+		}
+		for _, key := range remove {
+			delete(backup.Labels, key)
+		}
+		backup.MetadataVersion++
+		return nil
+	})
+}
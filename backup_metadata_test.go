@@ -0,0 +1,107 @@
+package backupstore
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestUpdateBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	// The lock acquisition doesn't wait with the fake clock
+	util.SetClock(util.NewFakeClock(time.Now()))
+	defer util.SetClock(nil)
+
+	err := saveVolume(m, &Volume{Name: "pvc-1", Size: DEFAULT_BLOCK_SIZE})
+	assert.NoError(err)
+	err = saveBackup(m, &Backup{Name: "backup-1", VolumeName: "pvc-1", CreatedTime: "2024-01-01T00:00:00Z",
+		Labels: map[string]string{"app": "db"}})
+	assert.NoError(err)
+	backupURL := EncodeBackupURL("backup-1", "pvc-1", mockDriverURL)
+
+	description := "before the schema migration"
+	metadata := json.RawMessage(`{"restoreHint":"replay-wal"}`)
+	info, err := UpdateBackup(backupURL, BackupUpdate{Description: &description, UserMetadata: &metadata})
+	assert.NoError(err)
+	assert.Equal(description, info.Description)
+	assert.JSONEq(string(metadata), string(info.UserMetadata))
+	assert.Equal(int64(1), info.MetadataVersion)
+	assert.Equal(map[string]string{"app": "db"}, info.Labels)
+
+	// The update based on a stale version is rejected
+	_, err = UpdateBackup(backupURL, BackupUpdate{Description: &description, ExpectedMetadataVersion: 2})
+	assert.Error(err)
+	cleared := json.RawMessage{}
+	info, err = UpdateBackup(backupURL, BackupUpdate{UserMetadata: &cleared, ExpectedMetadataVersion: 1})
+	assert.NoError(err)
+	assert.Empty(info.UserMetadata)
+	assert.Equal(description, info.Description)
+	assert.Equal(int64(2), info.MetadataVersion)
+
+	backup, err := loadBackup(m, "backup-1", "pvc-1")
+	assert.NoError(err)
+	assert.Equal(int64(2), backup.MetadataVersion)
+
+	invalid := json.RawMessage(`{"restoreHint":`)
+	_, err = UpdateBackup(backupURL, BackupUpdate{UserMetadata: &invalid})
+	assert.Error(err)
+	large := json.RawMessage(`"` + strings.Repeat("a", MAX_USER_METADATA_SIZE) + `"`)
+	_, err = UpdateBackup(backupURL, BackupUpdate{UserMetadata: &large})
+	assert.Error(err)
+}
+
+func TestUpdateBackupLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	util.SetClock(util.NewFakeClock(time.Now()))
+	defer util.SetClock(nil)
+
+	err := saveVolume(m, &Volume{Name: "pvc-1", Size: DEFAULT_BLOCK_SIZE})
+	assert.NoError(err)
+	err = saveBackup(m, &Backup{Name: "backup-1", VolumeName: "pvc-1", CreatedTime: "2024-01-01T00:00:00Z"})
+	assert.NoError(err)
+	backupURL := EncodeBackupURL("backup-1", "pvc-1", mockDriverURL)
+
+	info, err := UpdateBackupLabels(backupURL, map[string]string{"verified": "true", "replicated": "false"}, nil, 0)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"verified": "true", "replicated": "false"}, info.Labels)
+
+	info, err = UpdateBackupLabels(backupURL, map[string]string{"replicated": "true"}, []string{"verified", "absent"}, 0)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"replicated": "true"}, info.Labels)
+	assert.Equal(int64(2), info.MetadataVersion)
+
+	backup, err := loadBackup(m, "backup-1", "pvc-1")
+	assert.NoError(err)
+	assert.Equal(map[string]string{"replicated": "true"}, backup.Labels)
+
+	// The update based on the stale metadata is rejected instead of dropping the later update
+	_, err = UpdateBackupLabels(backupURL, map[string]string{"verified": "false"}, nil, 1)
+	assert.Error(err)
+	info, err = UpdateBackupLabels(backupURL, map[string]string{"verified": "false"}, nil, 2)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"replicated": "true", "verified": "false"}, info.Labels)
+	assert.Equal(int64(3), info.MetadataVersion)
+
+	_, err = UpdateBackupLabels(backupURL, map[string]string{"verified": "true"}, []string{"verified"}, 0)
+	assert.Error(err)
+
+	err = saveBackup(m, &Backup{Name: "backup-2", VolumeName: "pvc-1"})
+	assert.NoError(err)
+	_, err = UpdateBackupLabels(EncodeBackupURL("backup-2", "pvc-1", mockDriverURL), map[string]string{"verified": "true"}, nil, 0)
+	assert.Error(err)
+}
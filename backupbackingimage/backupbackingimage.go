@@ -109,6 +109,10 @@ func CreateBackingImageBackup(config *BackupConfig, backupBackingImage *BackupBa
 		}
 	}()
 
+	if err := backupstore.CheckTargetRedirect(bsDriver); err != nil {
+		return err
+	}
+
 	exists, err := addBackingImageConfigInBackupStore(bsDriver, backupBackingImage)
 	if err != nil {
 		return err
@@ -264,7 +268,7 @@ func backupMapping(bsDriver backupstore.BackupStoreDriver,
 		return err
 	}
 
-	return bsDriver.Write(blkFile, rs)
+	return backupstore.WriteCompressedObject(bsDriver, blkFile, rs, backupBackingImage.CompressionMethod)
 }
 
 // isBlockBeingProcessed check if the block is being processed by other goroutine and prevent redundant work
@@ -532,6 +536,10 @@ func RemoveBackingImageBackup(backupURL string) (err error) {
 	}
 	log := getLoggerForBackupBackingImage(config)
 
+	if err := backupstore.CheckTargetMutable(bsDriver, "delete backup backing image "+backingImageName); err != nil {
+		return err
+	}
+
 	lock, err := backupstore.New(bsDriver, types.BackupBackingImageLockName, backupstore.DELETION_LOCK)
 	if err != nil {
 		return err
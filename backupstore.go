@@ -1,6 +1,7 @@
 package backupstore
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"sync"
@@ -23,6 +24,22 @@ type Volume struct {
 	CompressionMethod    string `json:",string"`
 	StorageClassName     string `json:",string"`
 	DataEngine           string `json:",string"`
+
+	// LogicalSectorSize and PhysicalSectorSize are the sector sizes of the volume, 0 means 512 bytes
+	LogicalSectorSize  int64 `json:",string,omitempty"`
+	PhysicalSectorSize int64 `json:",string,omitempty"`
+
+	// LastVerification is the result of the last verification run of the backups of the volume
+	LastVerification           *VerificationResult `json:",omitempty"`
+	LastSuccessfulVerification *VerificationResult `json:",omitempty"`
+
+	// Writers are the clients that wrote the recent backups, most recent first
+	Writers []VolumeWriter `json:",omitempty"`
+
+	// RequireFullBackup demotes the next backup to a full backup after the backup chain is quarantined, and
+	// QuarantinedBackups are the backups of the broken chains by the names
+	RequireFullBackup  bool                          `json:",omitempty"`
+	QuarantinedBackups map[string]*QuarantinedBackup `json:",omitempty"`
 }
 
 type Snapshot struct {
@@ -50,10 +67,33 @@ type Backup struct {
 	NewlyUploadedDataSize int64 `json:",string"`
 	ReUploadedDataSize    int64 `json:",string"`
 
+	// ChainLength is the number of the incremental backups since the last full backup
+	ChainLength int64 `json:",string"`
+	// IsSyntheticFull is set for the full backups synthesized from the blocks of the existing backups
+	IsSyntheticFull bool
+
+	// SourceClusterID and SourceNodeID identify the client creating the backup
+	SourceClusterID string `json:",omitempty"`
+	SourceNodeID    string `json:",omitempty"`
+
+	// LogicalSectorSize and PhysicalSectorSize are the sector sizes of the volume when the backup is created
+	LogicalSectorSize  int64 `json:",string,omitempty"`
+	PhysicalSectorSize int64 `json:",string,omitempty"`
+
+	// Description and UserMetadata are provided by the user at the creation or by UpdateBackup, and
+	// MetadataVersion is increased by each update
+	Description     string          `json:",omitempty"`
+	UserMetadata    json.RawMessage `json:",omitempty"`
+	MetadataVersion int64           `json:",string"`
+
 	ProcessingBlocks *ProcessingBlocks
 
 	Blocks     []BlockMapping `json:",omitempty"`
 	SingleFile BackupFile     `json:",omitempty"`
+
+	// InlineBlocks are the compressed data of the blocks embedded in the backup config by the checksum,
+	// instead of the block files
+	InlineBlocks map[string][]byte `json:",omitempty"`
 }
 
 var (
@@ -77,6 +117,8 @@ func addVolume(driver BackupStoreDriver, volume *Volume) error {
 		return fmt.Errorf("invalid volume name %v", volume.Name)
 	}
 
+	// The creation time may be passed in the local time
+	volume.CreatedTime = util.NormalizeTimestamp(volume.CreatedTime)
 	if err := saveVolume(driver, volume); err != nil {
 		log.WithError(err).Errorf("Failed to add volume %v", volume.Name)
 		return err
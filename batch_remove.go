@@ -0,0 +1,92 @@
+package backupstore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// batchRemoveSize is the number of the files passed to each RemoveAll call, the drivers split them further
+	// by the limits of their backends
+	batchRemoveSize = 1000
+)
+
+// BatchRemoveBackupStoreDriver is implemented by the drivers removing many files by a single request, e.g. S3
+// DeleteObjects, so removing a large backup doesn't take a request per block.
+type BatchRemoveBackupStoreDriver interface {
+	// RemoveAll removes the files, unlike Remove the paths are not removed recursively. The missing files are
+	// ignored, and all the files are tried even if some of them cannot be removed, which are returned by
+	// ErrRemoveFailed.
+	RemoveAll(paths []string) error
+}
+
+// ErrRemoveFailed is returned by RemoveAll with the errors of the files which cannot be removed.
+type ErrRemoveFailed struct {
+	Errors map[string]error
+}
+
+func (e *ErrRemoveFailed) Error() string {
+	paths := make([]string, 0, len(e.Errors))
+	for path := range e.Errors {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return fmt.Sprintf("failed to remove %v files, %v: %v", len(paths), paths[0], e.Errors[paths[0]])
+}
+
+// getBatchRemover returns the driver removing the files by batches. The batches are not used if the files are
+// refused to be removed one by one, e.g. by the immutable mode. The wrappers forward RemoveAll, so the batches
+// are still measured and bounded by the deadlines, and are only used if the wrapped backend driver supports them.
+func getBatchRemover(driver BackupStoreDriver) (BatchRemoveBackupStoreDriver, bool) {
+	if CheckTargetMutable(driver, DriverOperationRemove) != nil {
+		return nil, false
+	}
+	return findBackendDriver[BatchRemoveBackupStoreDriver](driver)
+}
+
+// removeAll removes the files by the wrapped driver, one by one if it doesn't remove the files by batches.
+func removeAll(driver BackupStoreDriver, paths []string) error {
+	if batchRemover, ok := findDriver[BatchRemoveBackupStoreDriver](driver); ok {
+		return batchRemover.RemoveAll(paths)
+	}
+	errs := map[string]error{}
+	for _, path := range paths {
+		if err := driver.Remove(path); err != nil {
+			errs[path] = err
+		}
+	}
+	if len(errs) > 0 {
+		return &ErrRemoveFailed{Errors: errs}
+	}
+	return nil
+}
+
+// getRemoveFailures returns the paths which failed to be removed by RemoveAll.
+func getRemoveFailures(paths []string, err error) map[string]error {
+	if err == nil {
+		return nil
+	}
+	var removeErr *ErrRemoveFailed
+	if errors.As(err, &removeErr) {
+		return removeErr.Errors
+	}
+	failures := make(map[string]error, len(paths))
+	for _, path := range paths {
+		failures[path] = err
+	}
+	return failures
+}
+
+// splitBatches splits the paths by the batch size.
+func splitBatches(paths []string, size int) [][]string {
+	batches := [][]string{}
+	for start := 0; start < len(paths); start += size {
+		end := start + size
+		if end > len(paths) {
+			end = len(paths)
+		}
+		batches = append(batches, paths[start:end])
+	}
+	return batches
+}
@@ -0,0 +1,149 @@
+package backupstore
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/metrics"
+)
+
+const batchMockDriverName = "batchmock"
+
+// batchMockDriver removes the files by batches, failing to remove the given files.
+type batchMockDriver struct {
+	*mockStoreDriver
+	lock    sync.Mutex
+	batches [][]string
+	failed  map[string]bool
+	delay   time.Duration
+}
+
+func (d *batchMockDriver) RemoveAll(paths []string) error {
+	d.lock.Lock()
+	d.batches = append(d.batches, paths)
+	d.lock.Unlock()
+	time.Sleep(d.delay)
+
+	errs := map[string]error{}
+	for _, path := range paths {
+		if d.failed[path] {
+			errs[path] = fmt.Errorf("injected failure")
+			continue
+		}
+		if err := d.mockStoreDriver.Remove(path); err != nil {
+			errs[path] = err
+		}
+	}
+	if len(errs) > 0 {
+		return &ErrRemoveFailed{Errors: errs}
+	}
+	return nil
+}
+
+func TestRemoveFilesInBatches(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	d := &batchMockDriver{mockStoreDriver: m, failed: map[string]bool{}}
+	assert.NoError(RegisterDriver(batchMockDriverName, func(destURL string) (BackupStoreDriver, error) { return d, nil }))
+	defer unregisterDriver(batchMockDriverName) // nolint:errcheck
+	destURL := batchMockDriverName + "://localhost"
+
+	volumeName := "pvc-1"
+	assert.NoError(saveVolume(m, &Volume{Name: volumeName, Size: DEFAULT_BLOCK_SIZE}))
+	paths := []string{}
+	for i := 0; i < batchRemoveSize+1; i++ {
+		path := getBlockFilePath(volumeName, fmt.Sprintf("%064x", i))
+		assert.NoError(m.Write(path, bytes.NewReader([]byte("data"))))
+		paths = append(paths, path)
+	}
+	d.failed[paths[0]] = true
+
+	driver, err := GetBackupStoreDriver(destURL)
+	assert.NoError(err)
+	failures := removeFiles(driver, paths, &DeleteVolumeOptions{})
+	assert.Len(d.batches, 2)
+	assert.Len(failures, 1)
+	assert.Contains(failures, paths[0])
+	assert.True(m.FileExists(paths[0]))
+	assert.False(m.FileExists(paths[1]))
+
+	// The immutable mode refuses the files one by one instead of removing them by batches
+	d.batches = nil
+	driver, err = GetBackupStoreDriver(destURL + "?" + ImmutableTargetOption + "=true")
+	assert.NoError(err)
+	failures = removeFiles(driver, paths[:1], &DeleteVolumeOptions{})
+	assert.Empty(d.batches)
+	assert.True(IsImmutableTargetError(failures[paths[0]]))
+
+	err = removeObjects(driver, paths[:1], &DeleteVolumeOptions{})
+	assert.ErrorContains(err, "failed to remove 1 objects")
+}
+
+func TestRemoveFilesThroughWrappers(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+	// Use a distinct URL so the operations of the other tests are not counted
+	m.destURL = "mock://batch-remove-wrappers"
+
+	d := &batchMockDriver{mockStoreDriver: m, failed: map[string]bool{}}
+	assert.NoError(RegisterDriver(batchMockDriverName, func(destURL string) (BackupStoreDriver, error) { return d, nil }))
+	defer unregisterDriver(batchMockDriverName) // nolint:errcheck
+	destURL := batchMockDriverName + "://localhost"
+
+	paths := []string{}
+	for i := 0; i < 3; i++ {
+		path := getBlockFilePath("pvc-1", fmt.Sprintf("%064x", i))
+		assert.NoError(m.Write(path, bytes.NewReader([]byte("data"))))
+		paths = append(paths, path)
+	}
+
+	// The batches are removed through the wrappers, so the removals are measured
+	driver, err := GetBackupStoreDriver(destURL)
+	assert.NoError(err)
+	batchRemover, ok := getBatchRemover(driver)
+	assert.True(ok)
+	_, ok = batchRemover.(*deadlineDriver)
+	assert.True(ok)
+	target := getTargetHash(m.destURL)
+	count := getOperationCount(t, target, DriverOperationRemove, metrics.ResultSuccess)
+	assert.Empty(removeFiles(driver, paths[:1], &DeleteVolumeOptions{}))
+	assert.Len(d.batches, 1)
+	assert.False(m.FileExists(paths[0]))
+	assert.Equal(count+1, getOperationCount(t, target, DriverOperationRemove, metrics.ResultSuccess))
+
+	// The batches are bounded by the deadlines
+	d.delay = 200 * time.Millisecond
+	short := WithOperationDeadlines(driver, OperationDeadlines{Metadata: 50 * time.Millisecond})
+	failures := removeFiles(short, paths[1:2], &DeleteVolumeOptions{})
+	assert.True(IsOperationTimeoutError(failures[paths[1]]), "unexpected error %v", failures[paths[1]])
+	d.delay = 0
+
+	// The read-only and immutable wrappers refuse the batches even if they are called directly
+	d.batches = nil
+	lockFile := filepath.Join(backupstoreBase, "volumes", "pvc-1", LOCKS_DIRECTORY, "lock"+LOCK_SUFFIX)
+	assert.NoError(m.Write(lockFile, bytes.NewReader([]byte("lock"))))
+	immutable := &immutableDriver{driver}
+	failures = getRemoveFailures(paths[2:], immutable.RemoveAll([]string{paths[2], lockFile}))
+	assert.Len(failures, 1)
+	assert.True(IsImmutableTargetError(failures[paths[2]]))
+	assert.True(m.FileExists(paths[2]))
+	assert.False(m.FileExists(lockFile))
+	readOnly := &readOnlyDriver{driver}
+	failures = getRemoveFailures(paths[2:], readOnly.RemoveAll(paths[2:]))
+	assert.True(IsReadOnlyTargetError(failures[paths[2]]))
+	assert.True(m.FileExists(paths[2]))
+	assert.Len(d.batches, 1)
+}
@@ -0,0 +1,131 @@
+package backupstore
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gammazero/workerpool"
+)
+
+const (
+	// BLOCK_LISTING_CONCURRENCY is the max number of the block sub directories listed at the same time by the
+	// drivers listing the prefixes
+	BLOCK_LISTING_CONCURRENCY = 16
+)
+
+// listBlockFiles returns the paths of the block files under the blocks directory, which is organized in the
+// sub directories by the path layout. The drivers listing the prefixes list each sub directory of the prefix depth
+// of the layout at once in parallel, instead of listing the deeper sub directories one by one.
+func listBlockFiles(driver BackupStoreDriver, blocksDir string) ([]string, error) {
+	blocksDir = strings.TrimSuffix(blocksDir, "/")
+	lv1Dirs, err := driver.List(blocksDir)
+	if err != nil {
+		// Directory doesn't exist
+		return []string{}, nil
+	}
+
+	lister, ok := findDriver[PrefixListingBackupStoreDriver](driver)
+	if !ok {
+		return listBlockFilesByLevel(driver, blocksDir, lv1Dirs)
+	}
+
+	files := []string{}
+	dirs := []string{}
+	for _, lv1 := range lv1Dirs {
+		lv1Path := filepath.Join(blocksDir, lv1)
+		if isBlockFile(lv1Path) {
+			files = append(files, lv1Path)
+			continue
+		}
+		dirs = append(dirs, lv1Path)
+	}
+	for depth := 1; depth < getBlockPrefixDepth(GetPathLayout()); depth++ {
+		paths, err := listInParallel(dirs, func(dir string) ([]string, error) {
+			names, err := driver.List(dir)
+			if err != nil {
+				return nil, err
+			}
+			paths := make([]string, 0, len(names))
+			for _, name := range names {
+				paths = append(paths, filepath.Join(dir, name))
+			}
+			return paths, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		dirs = []string{}
+		for _, path := range paths {
+			if isBlockFile(path) {
+				files = append(files, path)
+				continue
+			}
+			dirs = append(dirs, path)
+		}
+	}
+
+	paths, err := listInParallel(dirs, func(dir string) ([]string, error) {
+		return lister.ListPrefix(dir + "/")
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if isBlockFile(path) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// listInParallel lists the directories by BLOCK_LISTING_CONCURRENCY workers and returns all the listed paths.
+func listInParallel(dirs []string, list func(dir string) ([]string, error)) ([]string, error) {
+	var (
+		result  []string
+		listErr error
+		lock    sync.Mutex
+	)
+	jobQueues := workerpool.New(BLOCK_LISTING_CONCURRENCY)
+	for _, dir := range dirs {
+		dir := dir
+		jobQueues.Submit(func() {
+			paths, err := list(dir)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				listErr = err
+				return
+			}
+			result = append(result, paths...)
+		})
+	}
+	jobQueues.StopWait()
+	if listErr != nil {
+		return nil, listErr
+	}
+	return result, nil
+}
+
+// listBlockFilesByLevel lists the sub directories of the blocks directory one by one, the entries which aren't
+// block files in the path layout are listed as the sub directories.
+func listBlockFilesByLevel(driver BackupStoreDriver, blocksDir string, lv1Dirs []string) ([]string, error) {
+	files := []string{}
+	for _, lv1 := range lv1Dirs {
+		lv1Path := filepath.Join(blocksDir, lv1)
+		if isBlockFile(lv1Path) {
+			files = append(files, lv1Path)
+			continue
+		}
+		names, err := driver.List(lv1Path)
+		if err != nil {
+			return nil, err
+		}
+		subFiles, err := listBlockFilesByLevel(driver, lv1Path, names)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, subFiles...)
+	}
+	return files, nil
+}
@@ -0,0 +1,68 @@
+package backupstore
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// prefixListingStoreDriver lists the prefixes of the mock backup target like an object storage.
+type prefixListingStoreDriver struct {
+	*mockStoreDriver
+	prefixListings int32
+}
+
+func (d *prefixListingStoreDriver) ListPrefix(prefix string) ([]string, error) {
+	atomic.AddInt32(&d.prefixListings, 1)
+	paths := []string{}
+	err := afero.Walk(d.fs, strings.TrimSuffix(prefix, "/"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	return paths, err
+}
+
+func TestListBlockFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	checksums := []string{
+		"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		"0145456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		"fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210",
+	}
+	for _, checksum := range checksums {
+		err := m.Write(getBlockFilePath("pvc-1", checksum), bytes.NewReader([]byte(checksum)))
+		assert.NoError(err)
+	}
+
+	names, err := getBlockNamesForVolume(m, "pvc-1")
+	assert.NoError(err)
+	assert.ElementsMatch(checksums, names)
+
+	// Each first level sub directory is listed once by the prefix
+	lister := &prefixListingStoreDriver{mockStoreDriver: m}
+	names, err = getBlockNamesForVolume(lister, "pvc-1")
+	assert.NoError(err)
+	assert.ElementsMatch(checksums, names)
+	assert.Equal(int32(2), lister.prefixListings)
+
+	files, err := getBlockFileManifest(lister, getBlockPath("pvc-1"))
+	assert.NoError(err)
+	assert.Len(files, 3)
+	assert.True(files[getBlockFilePath("pvc-1", checksums[2])])
+
+	names, err = getBlockNamesForVolume(lister, "pvc-2")
+	assert.NoError(err)
+	assert.Empty(names)
+}
@@ -0,0 +1,155 @@
+package backupstore
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// HardLinkBlocksOption is the backup target URL query parameter enabling the hard-linked block layout on the
+	// file system drivers, e.g. nfs://server:/path/?hardLinkBlocks=true. The identical blocks of the volumes
+	// compressed in the same way are stored once and hard linked into the block directories of the volumes.
+	HardLinkBlocksOption = "hardLinkBlocks"
+
+	BLOCK_LINKS_DIRECTORY = "block-links"
+)
+
+// blockLinkingDriver wraps a file system driver and enables the hard-linked block layout. The block files are
+// also linked into the shared directory indexed by the compression method and the checksum, which is only an
+// index: removing its entries never removes the data still linked by the volumes.
+type blockLinkingDriver struct {
+	BackupStoreDriver
+}
+
+func (d *blockLinkingDriver) Unwrap() BackupStoreDriver {
+	return d.BackupStoreDriver
+}
+
+func isBlockLinkingURL(destURL string) (bool, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return false, err
+	}
+	value := u.Query().Get(HardLinkBlocksOption)
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %v option %v in backup target URL", HardLinkBlocksOption, value)
+	}
+	return enabled, nil
+}
+
+// getBlockLinker returns the linking driver if the hard-linked block layout is enabled.
+func getBlockLinker(driver BackupStoreDriver) (LinkingBackupStoreDriver, bool) {
+	if _, ok := findDriver[*blockLinkingDriver](driver); !ok {
+		return nil, false
+	}
+	return findDriver[LinkingBackupStoreDriver](driver)
+}
+
+// link hard links the file by the wrapped driver.
+func link(driver BackupStoreDriver, src, dst string) error {
+	linker, ok := findDriver[LinkingBackupStoreDriver](driver)
+	if !ok {
+		return fmt.Errorf("backup target %v doesn't support hard links", driver.GetURL())
+	}
+	return linker.Link(src, dst)
+}
+
+// linkCount returns the number of the hard links of the file by the wrapped driver.
+func linkCount(driver BackupStoreDriver, filePath string) (int, error) {
+	linker, ok := findDriver[LinkingBackupStoreDriver](driver)
+	if !ok {
+		return 0, fmt.Errorf("backup target %v doesn't support hard links", driver.GetURL())
+	}
+	return linker.LinkCount(filePath)
+}
+
+func getLinkedBlocksPath(compressionMethod string) string {
+	if compressionMethod == "" {
+		compressionMethod = LEGACY_COMPRESSION_METHOD
+	}
+	return filepath.Join(backupstoreBase, BLOCK_LINKS_DIRECTORY, compressionMethod)
+}
+
+func getLinkedBlockFilePath(compressionMethod, checksum string) string {
+	return GetPathLayout().BlockFilePath(getLinkedBlocksPath(compressionMethod), checksum)
+}
+
+// findLinkedBlockFilePath returns the path of the existing index entry of the block, which may be written by the
+// legacy layout, or the path in the layout if the block isn't indexed.
+func findLinkedBlockFilePath(driver BackupStoreDriver, compressionMethod, checksum string) string {
+	return findLayoutBlockFilePath(driver, getLinkedBlocksPath(compressionMethod), checksum)
+}
+
+// writeBlockFile writes the compressed block of the volume. If the hard-linked block layout is enabled, the block
+// stored for another volume is linked instead, and the newly written block is linked into the shared index. The
+// block is written as is if it cannot be linked, e.g. the file system doesn't support hard links.
+func writeBlockFile(driver BackupStoreDriver, dst string, rs io.ReadSeeker, compressionMethod, checksum string) error {
+	linker, ok := getBlockLinker(driver)
+	if !ok {
+		return WriteCompressedObject(driver, dst, rs, compressionMethod)
+	}
+
+	linked := findLinkedBlockFilePath(driver, compressionMethod, checksum)
+	if driver.FileExists(linked) {
+		err := linker.Link(linked, dst)
+		if err == nil {
+			log.Debugf("Linked block %v to %v", linked, dst)
+			return nil
+		}
+		log.WithError(err).Warnf("Failed to link block %v, writing it instead", linked)
+	}
+
+	if err := WriteCompressedObject(driver, dst, rs, compressionMethod); err != nil {
+		return err
+	}
+	if err := linker.Link(dst, linked); err != nil {
+		log.WithError(err).Warnf("Failed to link block %v to %v", dst, linked)
+	}
+	return nil
+}
+
+// cleanupLinkedBlocks removes the shared index entries of the removed blocks of the volume which are no longer
+// linked by any volume.
+func cleanupLinkedBlocks(driver BackupStoreDriver, compressionMethod string, checksums []string) {
+	linker, ok := getBlockLinker(driver)
+	if !ok {
+		return
+	}
+
+	removed := 0
+	for _, checksum := range checksums {
+		linked := findLinkedBlockFilePath(driver, compressionMethod, checksum)
+		count, err := linker.LinkCount(linked)
+		// The blocks written before enabling the option are not indexed
+		if err != nil || count > 1 {
+			continue
+		}
+		if err := driver.Remove(linked); err != nil {
+			log.WithError(err).Warnf("Failed to remove unlinked block %v", linked)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Infof("Removed %v blocks no longer linked by any volume", removed)
+	}
+}
+
+// getBlockChecksums returns the checksums of the block files in the paths.
+func getBlockChecksums(paths []string) []string {
+	layout := GetPathLayout()
+	checksums := []string{}
+	for _, path := range paths {
+		if checksum, ok := layout.ParseBlockFileName(filepath.Base(path)); ok {
+			checksums = append(checksums, checksum)
+		}
+	}
+	return checksums
+}
@@ -0,0 +1,132 @@
+package backupstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+const linkingMockDriverName = "linkmock"
+
+// linkingMockDriver stores the files in a local directory, so they can be hard linked.
+type linkingMockDriver struct {
+	*mockStoreDriver
+	root string
+}
+
+func (d *linkingMockDriver) Link(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(d.root, dst)), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(d.root, dst)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(filepath.Join(d.root, src), filepath.Join(d.root, dst))
+}
+
+func (d *linkingMockDriver) LinkCount(filePath string) (int, error) {
+	st, err := os.Stat(filepath.Join(d.root, filePath))
+	if err != nil {
+		return 0, err
+	}
+	return int(st.Sys().(*syscall.Stat_t).Nlink), nil
+}
+
+func TestHardLinkBlocks(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	_, err := GetBackupStoreDriver(mockDriverURL + "?" + HardLinkBlocksOption + "=maybe")
+	assert.Error(err)
+	_, err = GetBackupStoreDriver(mockDriverURL + "?" + HardLinkBlocksOption + "=true")
+	assert.ErrorContains(err, "doesn't support")
+
+	root := t.TempDir()
+	m.fs = afero.NewBasePathFs(afero.NewOsFs(), root)
+	d := &linkingMockDriver{mockStoreDriver: m, root: root}
+	assert.NoError(RegisterDriver(linkingMockDriverName, func(destURL string) (BackupStoreDriver, error) { return d, nil }))
+	defer unregisterDriver(linkingMockDriverName) // nolint:errcheck
+	destURL := linkingMockDriverName + "://localhost?" + HardLinkBlocksOption + "=true"
+	driver, err := GetBackupStoreDriver(destURL)
+	assert.NoError(err)
+
+	blockSize := int64(DEFAULT_BLOCK_SIZE)
+	shared, other := bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE), bytes.Repeat([]byte{'b'}, DEFAULT_BLOCK_SIZE)
+	backupVolume := func(volumeName string, data []byte) {
+		volume := &Volume{Name: volumeName, Size: int64(len(data)), CompressionMethod: "lz4"}
+		assert.NoError(saveVolume(driver, volume))
+		config := &DeltaBackupConfig{
+			Volume:   volume,
+			Snapshot: &Snapshot{Name: "snap-1", CreatedTime: "2021-06-07T08:00:00Z"},
+			DestURL:  destURL,
+			DeltaOps: &memorySnapshotOps{data: data},
+		}
+		deltaBackup := &Backup{
+			Name:              "backup-1",
+			VolumeName:        volume.Name,
+			CompressionMethod: volume.CompressionMethod,
+			Blocks:            []BlockMapping{},
+			ProcessingBlocks: &ProcessingBlocks{
+				blocks: map[string][]*BlockMapping{},
+			},
+		}
+		delta := &types.Mappings{
+			Mappings:  []types.Mapping{{Offset: 0, Size: int64(len(data))}},
+			BlockSize: blockSize,
+		}
+		_, _, err := performBackup(driver, config, delta, deltaBackup, nil)
+		assert.NoError(err)
+	}
+	backupVolume("pvc-1", shared)
+	backupVolume("pvc-2", append(append([]byte{}, shared...), other...))
+
+	checksum := util.GetChecksum(shared)
+	linked := getLinkedBlockFilePath("lz4", checksum)
+	count, err := d.LinkCount(linked)
+	assert.NoError(err)
+	assert.Equal(3, count)
+	st1, err := os.Stat(filepath.Join(root, getBlockFilePath("pvc-1", checksum)))
+	assert.NoError(err)
+	st2, err := os.Stat(filepath.Join(root, getBlockFilePath("pvc-2", checksum)))
+	assert.NoError(err)
+	assert.True(os.SameFile(st1, st2))
+
+	// The immutable and read-only targets refuse to replace the block by a link
+	for _, option := range []string{ImmutableTargetOption, ReadOnlyTargetOption} {
+		protected, err := GetBackupStoreDriver(destURL + "&" + option + "=true")
+		assert.NoError(err)
+		linker, ok := getBlockLinker(protected)
+		assert.True(ok)
+		err = linker.Link(getBlockFilePath("pvc-2", util.GetChecksum(other)), getBlockFilePath("pvc-1", checksum))
+		assert.True(IsReadOnlyTargetError(err) || IsImmutableTargetError(err), "unexpected error %v", err)
+		count, err := linker.LinkCount(linked)
+		assert.NoError(err)
+		assert.Equal(3, count)
+	}
+
+	// The index entry is kept while another volume links the block
+	err = cleanupBlocks(driver, map[string]*BlockInfo{
+		checksum: {checksum: checksum, path: getBlockFilePath("pvc-1", checksum)},
+	}, "pvc-1")
+	assert.NoError(err)
+	assert.False(driver.FileExists(getBlockFilePath("pvc-1", checksum)))
+	count, err = d.LinkCount(linked)
+	assert.NoError(err)
+	assert.Equal(2, count)
+
+	// The index entries are removed along with the last volume linking them
+	assert.NoError(DeleteBackupVolume("pvc-2", destURL))
+	assert.False(driver.FileExists(linked))
+	assert.False(driver.FileExists(getLinkedBlockFilePath("lz4", util.GetChecksum(other))))
+}
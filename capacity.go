@@ -0,0 +1,44 @@
+package backupstore
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCapacityNotSupported is returned when the backend of the backup target cannot report its capacity, e.g.
+// the S3 buckets which don't have a quota.
+var ErrCapacityNotSupported = fmt.Errorf("backup target doesn't report its capacity")
+
+// TargetCapacity is the storage capacity of the backup target in bytes. The fields which the backend cannot
+// report are -1, e.g. the total and the available sizes of the bucket without a quota.
+type TargetCapacity struct {
+	Total     int64
+	Used      int64
+	Available int64
+}
+
+// CapacityBackupStoreDriver is implemented by the drivers whose backend reports the free space of the backup
+// target, e.g. the file system statistics of the mounted share or the bucket quota.
+type CapacityBackupStoreDriver interface {
+	GetCapacity() (*TargetCapacity, error)
+}
+
+// GetTargetCapacity returns the capacity of the backup target, so the backups to a nearly full target can be
+// refused before uploading anything. ErrCapacityNotSupported is returned if the backend cannot report it.
+func GetTargetCapacity(destURL string) (*TargetCapacity, error) {
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	reporter, ok := findDriver[CapacityBackupStoreDriver](driver)
+	if !ok {
+		return nil, ErrCapacityNotSupported
+	}
+	capacity, err := reporter.GetCapacity()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get capacity of backup target %v", driver.GetURL())
+	}
+	return capacity, nil
+}
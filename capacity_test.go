@@ -0,0 +1,34 @@
+package backupstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type capacityMockDriver struct {
+	*mockStoreDriver
+	capacity *TargetCapacity
+}
+
+func (d *capacityMockDriver) GetCapacity() (*TargetCapacity, error) {
+	return d.capacity, nil
+}
+
+func TestGetTargetCapacity(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	_, err := GetTargetCapacity(mockDriverURL)
+	assert.ErrorIs(err, ErrCapacityNotSupported)
+
+	d := &capacityMockDriver{mockStoreDriver: m, capacity: &TargetCapacity{Total: 100, Used: 60, Available: 40}}
+	assert.NoError(unregisterDriver(mockDriverName))
+	assert.NoError(RegisterDriver(mockDriverName, func(destURL string) (BackupStoreDriver, error) { return d, nil }))
+	capacity, err := GetTargetCapacity(mockDriverURL + "?immutable=true")
+	assert.NoError(err)
+	assert.Equal(d.capacity, capacity)
+}
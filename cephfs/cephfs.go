@@ -0,0 +1,343 @@
+package cephfs
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	mount "k8s.io/mount-utils"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/fsops"
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "cephfs"})
+
+	defaultMountInterval = 1 * time.Second
+	// The ceph clients connect to the monitors and the MDS before the mount completes, it takes longer than
+	// the NFS and CIFS mounts
+	defaultMountTimeout = 30 * time.Second
+)
+
+type BackupStoreDriver struct {
+	destURL      string
+	monitors     string
+	path         string
+	mountDir     string
+	client       string
+	fsName       string
+	mountOptions []string
+	getenv       func(string) string
+
+	*fsops.FileSystemOperator
+}
+
+const (
+	KIND = "cephfs"
+
+	// The backup target URL query parameters, e.g. cephfs://mon1:6789,mon2:6789/backups/?cephfsClient=fuse
+	// CephFSClientOption selects the kernel client or ceph-fuse, the kernel client is used by default
+	CephFSClientOption = "cephfsClient"
+	// CephFSNameOption selects the file system of the cluster with multiple file systems
+	CephFSNameOption = "cephfsName"
+	// CephFSOptions overrides the mount options except the credential
+	CephFSOptions = "cephfsOptions"
+
+	ClientKernel = "kernel"
+	ClientFuse   = "fuse"
+
+	defaultUser = "admin"
+
+	kernelFSType = "ceph"
+	fuseFSType   = "fuse.ceph"
+	fuseKind     = "fuse"
+
+	keyringDirectory = ".cephfs-keyrings"
+)
+
+func init() {
+	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+	if err := backupstore.RegisterReleaseFunc(KIND, releaseFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
+	credential, err := backupstore.LoadTargetCredential(destURL)
+	if err != nil {
+		return nil, err
+	}
+	b := &BackupStoreDriver{getenv: backupstore.TargetEnv(credential)}
+	b.FileSystemOperator = fsops.NewFileSystemOperator(b)
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("CephFS path must follow format: cephfs://<monitor>[,<monitor>...]/<path>/")
+	}
+
+	b.monitors = u.Host
+	b.path = "/" + strings.Trim(u.Path, "/")
+	b.destURL = KIND + "://" + b.monitors + b.path
+	b.mountDir = getMountDir(u)
+
+	b.client = u.Query().Get(CephFSClientOption)
+	switch b.client {
+	case "":
+		b.client = ClientKernel
+	case ClientKernel, ClientFuse:
+	default:
+		return nil, fmt.Errorf("invalid %v %v in CephFS URL, must be %v or %v", CephFSClientOption, b.client,
+			ClientKernel, ClientFuse)
+	}
+	b.fsName = u.Query().Get(CephFSNameOption)
+	if cephfsOptions, exist := u.Query()[CephFSOptions]; exist {
+		b.mountOptions = util.SplitMountOptions(cephfsOptions)
+		log.Infof("Overriding CephFS mountOptions:  %v", b.mountOptions)
+	}
+
+	if err := b.FileSystemOperator.EnsureMounted(); err != nil {
+		return nil, errors.Wrapf(err, "cannot mount CephFS %v with %v client", b.destURL, b.client)
+	}
+
+	if _, err := b.List(""); err != nil {
+		return nil, errors.Wrapf(err, "CephFS path %v doesn't exist or is not a directory", b.destURL)
+	}
+
+	log.Infof("Loaded driver for %v", b.destURL)
+
+	return b, nil
+}
+
+func (b *BackupStoreDriver) user() string {
+	if user := b.getenv(types.CephFSUser); user != "" {
+		return user
+	}
+	return defaultUser
+}
+
+// getKeyringSecret returns the key of the client in the keyring, or the only key if the keyring doesn't have
+// the section of the client.
+func getKeyringSecret(keyring, user string) (string, error) {
+	var section string
+	var keys []string
+	scanner := bufio.NewScanner(strings.NewReader(keyring))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(name) != "key" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if section == "client."+user {
+			return value, nil
+		}
+		keys = append(keys, value)
+	}
+	if len(keys) == 1 {
+		return keys[0], nil
+	}
+	return "", fmt.Errorf("cannot find the key of client.%v in the keyring", user)
+}
+
+// getSecret returns the secret of the client, either the one set directly or the one in the keyring.
+func (b *BackupStoreDriver) getSecret() (string, error) {
+	if secret := b.getenv(types.CephFSSecret); secret != "" {
+		return secret, nil
+	}
+	if keyring := b.getenv(types.CephFSKeyring); keyring != "" {
+		return getKeyringSecret(keyring, b.user())
+	}
+	return "", fmt.Errorf("missing CephFS credential, either %v or %v must be set", types.CephFSSecret,
+		types.CephFSKeyring)
+}
+
+// writeKeyring writes the keyring read by ceph-fuse, which doesn't take the secret in the mount options. The
+// keyring is generated from the secret if it isn't set.
+func (b *BackupStoreDriver) writeKeyring() (string, error) {
+	keyring := b.getenv(types.CephFSKeyring)
+	if keyring == "" {
+		secret, err := b.getSecret()
+		if err != nil {
+			return "", err
+		}
+		keyring = fmt.Sprintf("[client.%v]\n\tkey = %v\n", b.user(), secret)
+	}
+
+	keyringPath := b.keyringPath()
+	if err := os.MkdirAll(filepath.Dir(keyringPath), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(keyringPath, []byte(keyring), 0600); err != nil {
+		return "", errors.Wrapf(err, "failed to write keyring %v", keyringPath)
+	}
+	return keyringPath, nil
+}
+
+func (b *BackupStoreDriver) keyringPath() string {
+	return getKeyringPath(b.mountDir)
+}
+
+// getKeyringPath returns the path of the keyring written for ceph-fuse, outside the mount point.
+func getKeyringPath(mountDir string) string {
+	sum := sha256.Sum256([]byte(mountDir))
+	return filepath.Join(util.GetMountDir(), keyringDirectory, hex.EncodeToString(sum[:])[:16]+".keyring")
+}
+
+// getMountDir returns the mount point of CephFS of the URL.
+func getMountDir(u *url.URL) string {
+	path := "/" + strings.Trim(u.Path, "/")
+	return filepath.Join(util.GetMountDir(), strings.NewReplacer(".", "_", ",", "_", ":", "_").Replace(u.Host), path)
+}
+
+// releaseFunc unmounts CephFS of the backup target and removes its mount point as well as the keyring, without
+// mounting CephFS first like the driver.
+func releaseFunc(destURL string) error {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return err
+	}
+	mountDir := getMountDir(u)
+	if err := fsops.ReleaseMountPoint(mountDir, func() error {
+		return util.UnmountMountPoint(mountDir, log)
+	}); err != nil {
+		return err
+	}
+	if err := os.Remove(getKeyringPath(mountDir)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove keyring of CephFS %v", destURL)
+	}
+	return nil
+}
+
+// getMountArgs returns the source, the file system type, the mount options and the sensitive mount options of
+// the client.
+func (b *BackupStoreDriver) getMountArgs() (string, string, []string, []string, error) {
+	if b.client == ClientFuse {
+		keyringPath, err := b.writeKeyring()
+		if err != nil {
+			return "", "", nil, nil, err
+		}
+		options := []string{
+			"ceph.id=" + b.user(),
+			"ceph.mon_host=" + b.monitors,
+			"ceph.client_mountpoint=" + b.path,
+			"ceph.keyring=" + keyringPath,
+		}
+		if b.fsName != "" {
+			options = append(options, "ceph.client_fs="+b.fsName)
+		}
+		return "none", fuseFSType, append(options, b.mountOptions...), nil, nil
+	}
+
+	secret, err := b.getSecret()
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	options := []string{"name=" + b.user()}
+	if b.fsName != "" {
+		options = append(options, "fs="+b.fsName)
+	}
+	return b.monitors + ":" + b.path, kernelFSType, append(options, b.mountOptions...), []string{"secret=" + secret}, nil
+}
+
+func (b *BackupStoreDriver) mount() error {
+	mounter := mount.New("")
+
+	kind := KIND
+	if b.client == ClientFuse {
+		kind = fuseKind
+	}
+	mounted, err := util.EnsureMountPoint(kind, b.mountDir, mounter, log)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return nil
+	}
+
+	source, fstype, options, sensitiveOptions, err := b.getMountArgs()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Mounting CephFS %v on mount point %v with %v client and options %+v", b.destURL, b.mountDir,
+		b.client, options)
+
+	return util.MountWithTimeout(mounter, source, b.mountDir, fstype, options, sensitiveOptions,
+		defaultMountInterval, defaultMountTimeout)
+}
+
+// ShouldRemount returns true if the client lost its session, e.g. it's blocklisted by the cluster or ceph-fuse
+// exited.
+func (b *BackupStoreDriver) ShouldRemount(err error) bool {
+	return fsops.IsErrno(err, syscall.EIO, syscall.ENOTCONN, syscall.ESHUTDOWN, syscall.ESTALE)
+}
+
+// Remount cleans up the broken mount point and mounts CephFS again.
+func (b *BackupStoreDriver) Remount() error {
+	log.Warnf("Remounting CephFS %v on mount point %v", b.destURL, b.mountDir)
+	return b.mount()
+}
+
+// MountPoint returns the mount point of CephFS.
+func (b *BackupStoreDriver) MountPoint() string {
+	return b.mountDir
+}
+
+// Mount mounts CephFS again after it's unmounted for being idle.
+func (b *BackupStoreDriver) Mount() error {
+	return b.mount()
+}
+
+// Unmount unmounts the idle CephFS.
+func (b *BackupStoreDriver) Unmount() error {
+	return util.UnmountMountPoint(b.mountDir, log)
+}
+
+// Close unmounts CephFS and removes the mount point, as well as the keyring written for ceph-fuse.
+func (b *BackupStoreDriver) Close() error {
+	if err := b.FileSystemOperator.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(b.keyringPath()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove keyring of CephFS %v", b.destURL)
+	}
+	return nil
+}
+
+func (b *BackupStoreDriver) Kind() string {
+	return KIND
+}
+
+func (b *BackupStoreDriver) GetURL() string {
+	return b.destURL
+}
+
+func (b *BackupStoreDriver) LocalPath(path string) string {
+	return filepath.Join(b.mountDir, path)
+}
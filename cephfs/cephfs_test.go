@@ -0,0 +1,135 @@
+package cephfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestGetKeyringSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	keyring := `[client.admin]
+	key = YWRtaW4=
+	caps mds = "allow *"
+
+[client.backup]
+	key = YmFja3Vw
+`
+	for _, tc := range []struct {
+		name     string
+		keyring  string
+		user     string
+		expected string
+		valid    bool
+	}{
+		{"key of the client", keyring, "backup", "YmFja3Vw", true},
+		{"key of another client", keyring, "admin", "YWRtaW4=", true},
+		{"only key of the keyring", "[client.other]\nkey=b3RoZXI=\n", "backup", "b3RoZXI=", true},
+		{"missing client", keyring, "restore", "", false},
+		{"empty keyring", "", "admin", "", false},
+	} {
+		secret, err := getKeyringSecret(tc.keyring, tc.user)
+		if !tc.valid {
+			assert.ErrorContains(err, "cannot find the key of client."+tc.user, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, secret, tc.name)
+	}
+}
+
+func TestGetMountArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	defer util.SetMountDir(util.GetMountDir()) // nolint:errcheck
+	assert.NoError(util.SetMountDir(t.TempDir()))
+
+	newDriver := func(client string, credential map[string]string) *BackupStoreDriver {
+		return &BackupStoreDriver{
+			monitors:     "mon1:6789,mon2:6789",
+			path:         "/backups",
+			mountDir:     filepath.Join(util.GetMountDir(), "mon1_6789_mon2_6789", "backups"),
+			client:       client,
+			fsName:       "data",
+			mountOptions: []string{"noatime"},
+			getenv:       backupstore.TargetEnv(credential),
+		}
+	}
+
+	// The kernel client takes the secret in the sensitive mount options
+	b := newDriver(ClientKernel, map[string]string{types.CephFSUser: "backup", types.CephFSSecret: "c2VjcmV0"})
+	source, fstype, options, sensitiveOptions, err := b.getMountArgs()
+	assert.NoError(err)
+	assert.Equal("mon1:6789,mon2:6789:/backups", source)
+	assert.Equal(kernelFSType, fstype)
+	assert.Equal([]string{"name=backup", "fs=data", "noatime"}, options)
+	assert.Equal([]string{"secret=c2VjcmV0"}, sensitiveOptions)
+
+	b = newDriver(ClientKernel, map[string]string{types.CephFSKeyring: "[client.admin]\nkey = YWRtaW4=\n"})
+	_, _, options, sensitiveOptions, err = b.getMountArgs()
+	assert.NoError(err)
+	assert.Equal("name=admin", options[0])
+	assert.Equal([]string{"secret=YWRtaW4="}, sensitiveOptions)
+
+	b = newDriver(ClientKernel, map[string]string{})
+	_, _, _, _, err = b.getMountArgs()
+	assert.ErrorContains(err, "missing CephFS credential")
+
+	// ceph-fuse reads the keyring generated from the secret, which is removed by Close
+	b = newDriver(ClientFuse, map[string]string{types.CephFSUser: "backup", types.CephFSSecret: "c2VjcmV0"})
+	source, fstype, options, sensitiveOptions, err = b.getMountArgs()
+	assert.NoError(err)
+	assert.Equal("none", source)
+	assert.Equal(fuseFSType, fstype)
+	assert.Equal([]string{
+		"ceph.id=backup",
+		"ceph.mon_host=mon1:6789,mon2:6789",
+		"ceph.client_mountpoint=/backups",
+		"ceph.keyring=" + b.keyringPath(),
+		"ceph.client_fs=data",
+		"noatime",
+	}, options)
+	assert.Empty(sensitiveOptions)
+	keyring, err := os.ReadFile(b.keyringPath())
+	assert.NoError(err)
+	assert.Equal("[client.backup]\n\tkey = c2VjcmV0\n", string(keyring))
+	st, err := os.Stat(b.keyringPath())
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), st.Mode().Perm())
+	assert.NotContains(b.keyringPath(), b.mountDir)
+
+	// The keyring is written as is if it's set
+	b = newDriver(ClientFuse, map[string]string{types.CephFSKeyring: "[client.admin]\nkey = YWRtaW4=\n"})
+	_, _, _, _, err = b.getMountArgs()
+	assert.NoError(err)
+	keyring, err = os.ReadFile(b.keyringPath())
+	assert.NoError(err)
+	assert.Equal("[client.admin]\nkey = YWRtaW4=\n", string(keyring))
+}
+
+func TestShouldRemount(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &BackupStoreDriver{}
+	for _, tc := range []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{&os.PathError{Op: "stat", Path: "/mnt/cephfs", Err: syscall.ESHUTDOWN}, true},
+		{&os.PathError{Op: "open", Path: "/mnt/cephfs/volume.cfg", Err: syscall.EIO}, true},
+		{errors.New("Transport endpoint is not connected"), true},
+		{&os.PathError{Op: "open", Path: "/mnt/cephfs/volume.cfg", Err: syscall.ENOENT}, false},
+	} {
+		assert.Equal(tc.expected, b.ShouldRemount(tc.err), "error %v", tc.err)
+	}
+}
@@ -1,11 +1,12 @@
 package cifs
 
 import (
+	"context"
 	"fmt"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/longhorn/backupstore"
 	"github.com/longhorn/backupstore/fsops"
+	"github.com/longhorn/backupstore/types"
 	"github.com/longhorn/backupstore/util"
 )
 
@@ -22,8 +24,7 @@ var (
 	log = logrus.WithFields(logrus.Fields{"pkg": "cifs"})
 
 	// Ref: https://github.com/longhorn/backupstore/pull/91
-	defaultMountInterval = 1 * time.Second
-	defaultMountTimeout  = 5 * time.Second
+	defaultMountTimeout = 5 * time.Second
 )
 
 type BackupStoreDriver struct {
@@ -31,9 +32,16 @@ type BackupStoreDriver struct {
 	serverPath   string
 	mountDir     string
 	mountOptions []string
+	readOnly     bool
+	getenv       func(string) string
 
 	username string
 	password string
+	domain   string
+
+	// secFlavor is the requested security flavor, and krb5CCache is the Kerberos credentials cache of the mount
+	secFlavor  string
+	krb5CCache string
 
 	*fsops.FileSystemOperator
 }
@@ -48,10 +56,17 @@ func init() {
 	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
 		panic(err)
 	}
+	if err := backupstore.RegisterReleaseFunc(KIND, releaseFunc); err != nil {
+		panic(err)
+	}
 }
 
 func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
-	b := &BackupStoreDriver{}
+	credential, err := backupstore.LoadTargetCredential(destURL)
+	if err != nil {
+		return nil, err
+	}
+	b := &BackupStoreDriver{getenv: backupstore.TargetEnv(credential)}
 	b.FileSystemOperator = fsops.NewFileSystemOperator(b)
 
 	u, err := url.Parse(destURL)
@@ -69,11 +84,12 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 		return nil, fmt.Errorf("cannot find CIFS path")
 	}
 
-	b.username = os.Getenv("CIFS_USERNAME")
-	b.password = os.Getenv("CIFS_PASSWORD")
+	b.username = b.getenv(types.CIFSUsername)
+	b.password = b.getenv(types.CIFSPassword)
+	b.domain = b.getenv(types.CIFSDomain)
 	b.serverPath = u.Host + u.Path
 	b.destURL = KIND + "://" + b.serverPath
-	b.mountDir = filepath.Join(util.MountDir, strings.TrimRight(strings.Replace(u.Host, ".", "_", -1), ":"), u.Path)
+	b.mountDir = getMountDir(u)
 
 	cifsOptions, exist := u.Query()["cifsOptions"]
 	if exist {
@@ -82,8 +98,21 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 	} else {
 		b.mountOptions = []string{"soft"}
 	}
+	if err := b.parseMountOptions(u.Query()); err != nil {
+		return nil, err
+	}
+	if err := b.parseSecurity(u.Query()); err != nil {
+		return nil, err
+	}
+
+	if b.readOnly, err = backupstore.IsReadOnlyTargetURL(destURL); err != nil {
+		return nil, err
+	}
+	if b.readOnly {
+		b.mountDir = util.ReadOnlyMountPoint(b.mountDir)
+	}
 
-	if err := b.mount(); err != nil {
+	if err := b.FileSystemOperator.EnsureMounted(); err != nil {
 		return nil, errors.Wrapf(err, "cannot mount CIFS share %v, options %v", b.serverPath, b.mountOptions)
 	}
 
@@ -96,6 +125,31 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 	return b, nil
 }
 
+// getMountDir returns the mount point of the CIFS share of the URL, which is read-write.
+func getMountDir(u *url.URL) string {
+	return filepath.Join(util.GetMountDir(), strings.TrimRight(strings.Replace(u.Host, ".", "_", -1), ":"), u.Path)
+}
+
+// releaseFunc unmounts the CIFS share of the backup target and removes its mount point, without mounting the
+// share first like the driver.
+func releaseFunc(destURL string) error {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return err
+	}
+	mountDir := getMountDir(u)
+	readOnly, err := backupstore.IsReadOnlyTargetURL(destURL)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		mountDir = util.ReadOnlyMountPoint(mountDir)
+	}
+	return fsops.ReleaseMountPoint(mountDir, func() error {
+		return util.UnmountMountPoint(mountDir, log)
+	})
+}
+
 func (b *BackupStoreDriver) mount() error {
 	mounter := mount.New("")
 
@@ -107,15 +161,39 @@ func (b *BackupStoreDriver) mount() error {
 		return nil
 	}
 
-	sensitiveMountOptions := []string{
-		fmt.Sprintf("username=%v", b.username),
-		fmt.Sprintf("password=%v", b.password),
-	}
+	mountOptions := b.getMountOptions()
+	log.Infof("Mounting CIFS share %v on mount point %v with options %+v", b.destURL, b.mountDir, mountOptions)
 
-	log.Infof("Mounting CIFS share %v on mount point %v with options %+v", b.destURL, b.mountDir, b.mountOptions)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultMountTimeout)
+	defer cancel()
+	return util.MountWithEnv(ctx, mounter, "//"+b.serverPath, b.mountDir, KIND, mountOptions,
+		b.getSensitiveMountOptions(), b.getMountEnv())
+}
+
+// ShouldRemount returns true if the share is disconnected, e.g. after the file server reboots.
+func (b *BackupStoreDriver) ShouldRemount(err error) bool {
+	return fsops.IsErrno(err, syscall.EIO, syscall.ENOTCONN, syscall.EHOSTDOWN)
+}
+
+// Remount cleans up the broken mount point and mounts the share again.
+func (b *BackupStoreDriver) Remount() error {
+	log.Warnf("Remounting CIFS share %v on mount point %v", b.destURL, b.mountDir)
+	return b.mount()
+}
+
+// MountPoint returns the mount point of the CIFS share.
+func (b *BackupStoreDriver) MountPoint() string {
+	return b.mountDir
+}
+
+// Mount mounts the CIFS share again after it's unmounted for being idle.
+func (b *BackupStoreDriver) Mount() error {
+	return b.mount()
+}
 
-	return util.MountWithTimeout(mounter, "//"+b.serverPath, b.mountDir, KIND, b.mountOptions, sensitiveMountOptions,
-		defaultMountInterval, defaultMountTimeout)
+// Unmount unmounts the idle CIFS share.
+func (b *BackupStoreDriver) Unmount() error {
+	return util.UnmountMountPoint(b.mountDir, log)
 }
 
 func (b *BackupStoreDriver) Kind() string {
@@ -0,0 +1,29 @@
+package cifs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldRemount(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &BackupStoreDriver{}
+	for _, tc := range []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{&os.PathError{Op: "open", Path: "/mnt/share/volume.cfg", Err: syscall.EIO}, true},
+		{&os.PathError{Op: "stat", Path: "/mnt/share", Err: syscall.EHOSTDOWN}, true},
+		{errors.New("ls: cannot access '/mnt/share': Transport endpoint is not connected"), true},
+		{&os.PathError{Op: "open", Path: "/mnt/share/volume.cfg", Err: syscall.ENOENT}, false},
+		{&os.PathError{Op: "open", Path: "/mnt/share/volume.cfg", Err: syscall.EACCES}, false},
+	} {
+		assert.Equal(tc.expected, b.ShouldRemount(tc.err), "error %v", tc.err)
+	}
+}
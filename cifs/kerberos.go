@@ -0,0 +1,144 @@
+package cifs
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// The backup target URL query parameters configuring the authentication of the CIFS share, e.g.
+	// cifs://server/share/?cifsSec=krb5&cifsKrb5CCache=/var/lib/backupstore/krb5cc for the shares joined to
+	// Active Directory with NTLM disabled. The flavor can also be set by sec in cifsOptions.
+	CifsSecOption        = "cifsSec"
+	CifsKrb5CCacheOption = "cifsKrb5CCache"
+
+	SecKrb5     = "krb5"
+	SecKrb5i    = "krb5i"
+	SecNtlmssp  = "ntlmssp"
+	SecNtlmsspi = "ntlmsspi"
+	SecNtlmv2   = "ntlmv2"
+	SecNtlmv2i  = "ntlmv2i"
+	SecNtlm     = "ntlm"
+	SecNtlmi    = "ntlmi"
+	SecNone     = "none"
+
+	secMountOption    = "sec"
+	domainMountOption = "domain"
+)
+
+var (
+	supportedSecFlavors = []string{SecKrb5, SecKrb5i, SecNtlmssp, SecNtlmsspi, SecNtlmv2, SecNtlmv2i, SecNtlm, SecNtlmi, SecNone}
+)
+
+// parseSecurity parses the security flavor and the Kerberos credentials cache in the URL query. The flavor set
+// in the URL is added to the mount options if the options don't set it.
+func (b *BackupStoreDriver) parseSecurity(values url.Values) error {
+	flavor := values.Get(CifsSecOption)
+	if optionFlavor := getMountOptionValue(b.mountOptions, secMountOption); optionFlavor != "" {
+		if flavor != "" && flavor != optionFlavor {
+			return fmt.Errorf("conflicting %v %v and cifsOptions sec=%v in CIFS URL", CifsSecOption, flavor, optionFlavor)
+		}
+		flavor = optionFlavor
+	} else if flavor != "" {
+		b.mountOptions = append(b.mountOptions, secMountOption+"="+flavor)
+	}
+
+	if flavor != "" && !isSupportedSecFlavor(flavor) {
+		return fmt.Errorf("unsupported CIFS security flavor %v, must be one of %v", flavor,
+			strings.Join(supportedSecFlavors, ", "))
+	}
+	b.secFlavor = flavor
+
+	b.krb5CCache = values.Get(CifsKrb5CCacheOption)
+	if b.krb5CCache == "" {
+		return nil
+	}
+	if !b.usesKerberos() {
+		return fmt.Errorf("%v requires a Kerberos security flavor in CIFS URL", CifsKrb5CCacheOption)
+	}
+	// Only the file caches can be checked before the mount, the other types are left to cifs.upcall
+	if path, isFile := getCCacheFilePath(b.krb5CCache); isFile {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("cannot read Kerberos credentials cache %v: %v", path, err)
+		}
+		f.Close()
+	}
+	log.Infof("Using Kerberos credentials cache %v for CIFS share %v", b.krb5CCache, b.serverPath)
+	return nil
+}
+
+func isSupportedSecFlavor(flavor string) bool {
+	for _, supported := range supportedSecFlavors {
+		if flavor == supported {
+			return true
+		}
+	}
+	return false
+}
+
+func getCCacheFilePath(ccache string) (string, bool) {
+	if strings.HasPrefix(ccache, "FILE:") {
+		return strings.TrimPrefix(ccache, "FILE:"), true
+	}
+	if strings.Contains(ccache, ":") {
+		return "", false
+	}
+	return ccache, true
+}
+
+func (b *BackupStoreDriver) usesKerberos() bool {
+	return b.secFlavor == SecKrb5 || b.secFlavor == SecKrb5i
+}
+
+// getMountOptions returns the mount options with the domain, the share is mounted read-only for the read-only
+// target.
+func (b *BackupStoreDriver) getMountOptions() []string {
+	options := b.mountOptions
+	if b.domain != "" && getMountOptionValue(options, domainMountOption) == "" {
+		options = append(options[:len(options):len(options)], domainMountOption+"="+b.domain)
+	}
+	if b.readOnly {
+		options = util.WithReadOnlyOption(options)
+	}
+	return options
+}
+
+// getSensitiveMountOptions returns the credentials of the mount. The password isn't used by Kerberos, which
+// authenticates by the ticket in the credentials cache, and the username only picks the principal if it's set.
+func (b *BackupStoreDriver) getSensitiveMountOptions() []string {
+	if b.usesKerberos() {
+		if b.username == "" {
+			return nil
+		}
+		return []string{fmt.Sprintf("username=%v", b.username)}
+	}
+	return []string{
+		fmt.Sprintf("username=%v", b.username),
+		fmt.Sprintf("password=%v", b.password),
+	}
+}
+
+// getMountEnv returns the environment variables of the mount helper.
+func (b *BackupStoreDriver) getMountEnv() []string {
+	if b.krb5CCache == "" {
+		return nil
+	}
+	return []string{"KRB5CCNAME=" + b.krb5CCache}
+}
+
+// getMountOptionValue returns the value of the last occurrence of the option, or an empty string if it isn't set.
+func getMountOptionValue(options []string, name string) string {
+	value := ""
+	for _, option := range options {
+		key, v, _ := strings.Cut(option, "=")
+		if key == name {
+			value = v
+		}
+	}
+	return value
+}
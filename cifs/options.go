@@ -0,0 +1,72 @@
+package cifs
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// The backup target URL query parameters translated to the mount options for the hardened SMB servers, e.g.
+	// cifs://server/share/?smbVersion=3.1.1&domain=CORP&options=seal,noperm. Unlike cifsOptions, the options are
+	// added to the default mount options instead of overriding them.
+	SmbVersionOption   = "smbVersion"
+	DomainOption       = "domain"
+	MountOptionsOption = "options"
+	versionMountOption = "vers"
+)
+
+var (
+	supportedSMBVersions = []string{"1.0", "2.0", "2.1", "3", "3.0", "3.02", "3.1.1", "3.11", "default"}
+)
+
+// parseMountOptions translates the URL query parameters to the mount options. The parameters conflicting with
+// the options set by cifsOptions or options are refused.
+func (b *BackupStoreDriver) parseMountOptions(values url.Values) error {
+	if extraOptions, exist := values[MountOptionsOption]; exist {
+		for _, option := range util.SplitMountOptions(extraOptions) {
+			if option = strings.TrimSpace(option); option != "" {
+				b.mountOptions = append(b.mountOptions, option)
+			}
+		}
+	}
+
+	if version := values.Get(SmbVersionOption); version != "" {
+		if !isSupportedSMBVersion(version) {
+			return fmt.Errorf("unsupported SMB protocol version %v, must be one of %v", version,
+				strings.Join(supportedSMBVersions, ", "))
+		}
+		if optionVersion := getMountOptionValue(b.mountOptions, versionMountOption); optionVersion != "" {
+			if optionVersion != version {
+				return fmt.Errorf("conflicting %v %v and mount option vers=%v in CIFS URL", SmbVersionOption,
+					version, optionVersion)
+			}
+		} else {
+			b.mountOptions = append(b.mountOptions, versionMountOption+"="+version)
+		}
+	}
+
+	if domain := values.Get(DomainOption); domain != "" {
+		if b.domain != "" && b.domain != domain {
+			return fmt.Errorf("conflicting %v %v in CIFS URL and domain %v of the credential", DomainOption, domain,
+				b.domain)
+		}
+		if optionDomain := getMountOptionValue(b.mountOptions, domainMountOption); optionDomain != "" && optionDomain != domain {
+			return fmt.Errorf("conflicting %v %v and mount option domain=%v in CIFS URL", DomainOption, domain,
+				optionDomain)
+		}
+		b.domain = domain
+	}
+	return nil
+}
+
+func isSupportedSMBVersion(version string) bool {
+	for _, supported := range supportedSMBVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
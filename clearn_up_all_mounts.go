@@ -1,8 +1,11 @@
 package backupstore
 
 import (
+	"net/url"
 	"os"
 
+	"github.com/pkg/errors"
+
 	"github.com/longhorn/backupstore/util"
 	mount "k8s.io/mount-utils"
 )
@@ -10,7 +13,7 @@ import (
 func CleanUpAllMounts() (err error) {
 	mounter := mount.New("")
 
-	if _, err := os.Stat(util.MountDir); err != nil {
+	if _, err := os.Stat(util.GetMountDir()); err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		}
@@ -20,3 +23,22 @@ func CleanUpAllMounts() (err error) {
 	err = util.CleanUpMountPoints(mounter, log)
 	return err
 }
+
+// ReleaseBackupTarget releases the local resources of the backup target when it's removed, e.g. unmounts the
+// share of the mount-based drivers and deletes the mount directory. The data of the backup target is kept. The
+// driver isn't initialized, so the resources are released even if the server of the backup target is gone.
+func ReleaseBackupTarget(destURL string) error {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return err
+	}
+	release, exists := releasers[u.Scheme]
+	if !exists {
+		return nil
+	}
+	if err := release(destURL); err != nil {
+		return errors.Wrapf(err, "failed to release backup target %v", destURL)
+	}
+	log.Infof("Released backup target %v", destURL)
+	return nil
+}
@@ -0,0 +1,38 @@
+package backupstore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseBackupTarget(t *testing.T) {
+	assert := assert.New(t)
+
+	const kind = "releasemock"
+	released := []string{}
+	initialized := 0
+	assert.NoError(RegisterDriver(kind, func(destURL string) (BackupStoreDriver, error) {
+		initialized++
+		return nil, errors.New("server is gone")
+	}))
+	assert.NoError(RegisterReleaseFunc(kind, func(destURL string) error {
+		released = append(released, destURL)
+		return nil
+	}))
+	defer func() {
+		unregisterDriver(kind) // nolint:errcheck
+		delete(releasers, kind)
+	}()
+	assert.Error(RegisterReleaseFunc(kind, func(destURL string) error { return nil }))
+
+	// The target is released without initializing the driver, which fails for the unreachable server
+	destURL := kind + "://server/export"
+	assert.NoError(ReleaseBackupTarget(destURL))
+	assert.Equal([]string{destURL}, released)
+	assert.Equal(0, initialized)
+
+	// The drivers without the local resources have nothing to release
+	assert.NoError(ReleaseBackupTarget(mockDriverURL))
+}
@@ -0,0 +1,116 @@
+// Package api defines the API of the client package. It only depends on the standard library, so the libraries
+// taking the clients from the embedders don't depend on backupstore and its drivers.
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// BlockSize is the size of the blocks the volumes are backed up in. The volume sizes are multiples of it.
+const BlockSize = 2 * 1024 * 1024
+
+// Client backs up, restores, lists and deletes the volume backups of one backup target.
+type Client interface {
+	// Backup backs up the snapshot of a volume and returns once the backup is complete
+	Backup(ctx context.Context, opts BackupOptions) (*Backup, error)
+	// Restore restores a backup to a file or a block device and returns once the restore is complete
+	Restore(ctx context.Context, opts RestoreOptions) error
+	// ListVolumes returns the volumes having backups in the backup target
+	ListVolumes(ctx context.Context) ([]*Volume, error)
+	// ListBackups returns the complete backups of a volume, oldest first
+	ListBackups(ctx context.Context, volumeName string) ([]*Backup, error)
+	// GetBackup returns a backup of a volume
+	GetBackup(ctx context.Context, volumeName, backupName string) (*Backup, error)
+	// DeleteBackup deletes a backup of a volume and the blocks no other backup references
+	DeleteBackup(ctx context.Context, volumeName, backupName string) error
+	// DeleteVolume deletes a volume and all its backups
+	DeleteVolume(ctx context.Context, volumeName string) error
+}
+
+// Options configures a client.
+type Options struct {
+	// Credential is the credential of the backup target by the environment variable names of backupstore, e.g.
+	// AWS_ACCESS_KEY_ID. The environment variables are used if it's nil.
+	Credential map[string]string
+}
+
+// Extent is a range of a snapshot in bytes.
+type Extent struct {
+	Offset int64
+	Length int64
+}
+
+// SnapshotSource is the snapshot to back up.
+type SnapshotSource interface {
+	// ReadAt reads the snapshot, the part beyond the end of the snapshot reads as zeros
+	ReadAt(p []byte, off int64) (int, error)
+	// DataExtents returns the extents of the snapshot holding data, the other extents are backed up as holes
+	DataExtents() ([]Extent, error)
+}
+
+// IncrementalSnapshotSource is the snapshot which can be backed up incrementally, only the extents changed since
+// the snapshot of the last backup of the volume are backed up.
+type IncrementalSnapshotSource interface {
+	SnapshotSource
+	// HasSnapshot returns true if the snapshot the changes are computed against still exists
+	HasSnapshot(name string) bool
+	// ChangedExtents returns the extents changed since the snapshot
+	ChangedExtents(since string) ([]Extent, error)
+}
+
+// BackupOptions are the options of a backup.
+type BackupOptions struct {
+	VolumeName string
+	// VolumeSize is the size of the volume in bytes, it must be a multiple of BlockSize
+	VolumeSize   int64
+	SnapshotName string
+	Source       SnapshotSource
+
+	// BackupName is the name of the backup, a name is generated if it's empty
+	BackupName        string
+	Labels            map[string]string
+	CompressionMethod string
+	// Concurrency is the number of the blocks uploaded concurrently
+	Concurrency int
+	// Progress is called with the progress of the backup in percent
+	Progress func(percent int)
+}
+
+// RestoreOptions are the options of a restore.
+type RestoreOptions struct {
+	VolumeName string
+	BackupName string
+	// Path is the file or the block device the backup is restored to, an existing file is overwritten
+	Path string
+
+	// Concurrency is the number of the blocks downloaded concurrently
+	Concurrency int
+	// Progress is called with the progress of the restore in percent
+	Progress func(percent int)
+}
+
+// Volume is a volume in the backup target.
+type Volume struct {
+	Name           string
+	Size           int64
+	Labels         map[string]string
+	CreatedAt      time.Time
+	LastBackupName string
+	LastBackupAt   time.Time
+	DataStored     int64
+}
+
+// Backup is a backup of a volume.
+type Backup struct {
+	Name              string
+	VolumeName        string
+	URL               string
+	SnapshotName      string
+	SnapshotCreatedAt time.Time
+	CreatedAt         time.Time
+	Size              int64
+	Labels            map[string]string
+	IsIncremental     bool
+	CompressionMethod string
+}
@@ -0,0 +1,444 @@
+// Package client is a small facade over backupstore for the projects embedding it as a library. The API only
+// takes and returns the standard library types and the types of package api, so the embedders don't depend on
+// the logging, mount and configuration types of backupstore. This package still links backupstore and the
+// dependencies of its drivers, so the libraries which only take the clients import package api instead, which
+// only depends on the standard library.
+//
+// The drivers of the backup targets are registered by importing them, e.g.
+//
+//	import _ "github.com/longhorn/backupstore/s3"
+//
+// The memory driver keeps the backup targets in the process, so the backup and restore flows can be unit
+// tested with memory://name/ targets without a real backup store.
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/client/api"
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+// BlockSize is the size of the blocks the volumes are backed up in. The volume sizes are multiples of it.
+const BlockSize = api.BlockSize
+
+const defaultConcurrency = 4
+
+// Client backs up, restores, lists and deletes the volume backups of one backup target.
+type Client = api.Client
+
+// Options configures a client.
+type Options = api.Options
+
+// Extent is a range of a snapshot in bytes.
+type Extent = api.Extent
+
+// SnapshotSource is the snapshot to back up.
+type SnapshotSource = api.SnapshotSource
+
+// IncrementalSnapshotSource is the snapshot which can be backed up incrementally.
+type IncrementalSnapshotSource = api.IncrementalSnapshotSource
+
+// BackupOptions are the options of a backup.
+type BackupOptions = api.BackupOptions
+
+// RestoreOptions are the options of a restore.
+type RestoreOptions = api.RestoreOptions
+
+// Volume is a volume in the backup target.
+type Volume = api.Volume
+
+// Backup is a backup of a volume.
+type Backup = api.Backup
+
+type client struct {
+	targetURL string
+}
+
+// New returns the client of the backup target URL, e.g. s3://bucket@region/path/. The driver of the backup
+// target is loaded to check the URL and the credential.
+func New(targetURL string, opts Options) (Client, error) {
+	if opts.Credential != nil {
+		backupstore.SetTargetCredential(targetURL, opts.Credential)
+	}
+	if _, err := backupstore.GetBackupStoreDriver(targetURL); err != nil {
+		return nil, err
+	}
+	return &client{targetURL: targetURL}, nil
+}
+
+func (c *client) Backup(ctx context.Context, opts BackupOptions) (*Backup, error) {
+	if opts.VolumeName == "" || opts.SnapshotName == "" {
+		return nil, fmt.Errorf("missing volume name or snapshot name")
+	}
+	if opts.Source == nil {
+		return nil, fmt.Errorf("missing snapshot source of volume %v", opts.VolumeName)
+	}
+	if opts.VolumeSize <= 0 || opts.VolumeSize%BlockSize != 0 {
+		return nil, fmt.Errorf("invalid volume size %v, it must be a multiple of %v", opts.VolumeSize, BlockSize)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	backupName := opts.BackupName
+	if backupName == "" {
+		backupName = util.GenerateName("backup")
+	}
+	ops := &backupOperations{
+		ctx:        ctx,
+		source:     opts.Source,
+		volumeSize: opts.VolumeSize,
+		progress:   opts.Progress,
+		done:       make(chan backupResult, 1),
+	}
+	now := util.Now()
+	config := &backupstore.DeltaBackupConfig{
+		BackupName: backupName,
+		Volume: &backupstore.Volume{
+			Name:              opts.VolumeName,
+			Size:              opts.VolumeSize,
+			CreatedTime:       now,
+			CompressionMethod: opts.CompressionMethod,
+		},
+		Snapshot: &backupstore.Snapshot{
+			Name:        opts.SnapshotName,
+			CreatedTime: now,
+		},
+		DestURL:         c.targetURL,
+		DeltaOps:        ops,
+		Labels:          opts.Labels,
+		ConcurrentLimit: int32(getConcurrency(opts.Concurrency)),
+	}
+	if _, err := backupstore.CreateDeltaBlockBackup(backupName, config); err != nil {
+		return nil, err
+	}
+
+	// The backup runs in the background, a canceled context fails the snapshot reads of it
+	result := <-ops.done
+	if result.err != nil {
+		return nil, result.err
+	}
+	return c.GetBackup(ctx, opts.VolumeName, backupName)
+}
+
+func (c *client) Restore(ctx context.Context, opts RestoreOptions) error {
+	if opts.VolumeName == "" || opts.BackupName == "" || opts.Path == "" {
+		return fmt.Errorf("missing volume name, backup name or restore path")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ops := &restoreOperations{
+		progress: opts.Progress,
+		stopChan: make(chan struct{}),
+		done:     make(chan error, 1),
+	}
+	config := &backupstore.DeltaRestoreConfig{
+		BackupURL:       backupstore.EncodeBackupURL(opts.BackupName, opts.VolumeName, c.targetURL),
+		DeltaOps:        ops,
+		Filename:        opts.Path,
+		ConcurrentLimit: int32(getConcurrency(opts.Concurrency)),
+	}
+	if err := backupstore.RestoreDeltaBlockBackup(ctx, config); err != nil {
+		return err
+	}
+
+	// The restore stops on the canceled context, it's waited for so the restored file is closed on return
+	select {
+	case err := <-ops.done:
+		return err
+	case <-ctx.Done():
+		ops.Stop()
+		if err := <-ops.done; err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+}
+
+func (c *client) ListVolumes(ctx context.Context) ([]*Volume, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	volumeInfos, err := backupstore.List("", c.targetURL, true)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := []*Volume{}
+	for name := range volumeInfos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		info, err := backupstore.InspectVolume(backupstore.EncodeBackupURL("", name, c.targetURL))
+		if err != nil {
+			// The volume directory without the volume config is not a volume
+			continue
+		}
+		volumes = append(volumes, &Volume{
+			Name:           info.Name,
+			Size:           info.Size,
+			Labels:         info.Labels,
+			CreatedAt:      parseTime(info.Created),
+			LastBackupName: info.LastBackupName,
+			LastBackupAt:   parseTime(info.LastBackupAt),
+			DataStored:     info.DataStored,
+		})
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+func (c *client) ListBackups(ctx context.Context, volumeName string) ([]*Backup, error) {
+	if volumeName == "" {
+		return nil, fmt.Errorf("missing volume name")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	volumeInfos, err := backupstore.List(volumeName, c.targetURL, false)
+	if err != nil {
+		return nil, err
+	}
+	volumeInfo := volumeInfos[volumeName]
+	if volumeInfo == nil {
+		return nil, fmt.Errorf("cannot find volume %v", volumeName)
+	}
+	if msg := volumeInfo.Messages[types.MessageTypeError]; msg != "" {
+		return nil, fmt.Errorf("failed to list the backups of volume %v: %v", volumeName, msg)
+	}
+
+	backups := []*Backup{}
+	for name := range volumeInfo.Backups {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		backup, err := c.GetBackup(ctx, volumeName, name)
+		if err != nil {
+			// The backups in progress or failed are not listed
+			continue
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].CreatedAt.Equal(backups[j].CreatedAt) {
+			return backups[i].CreatedAt.Before(backups[j].CreatedAt)
+		}
+		return backups[i].Name < backups[j].Name
+	})
+	return backups, nil
+}
+
+func (c *client) GetBackup(ctx context.Context, volumeName, backupName string) (*Backup, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	info, err := backupstore.InspectBackup(backupstore.EncodeBackupURL(backupName, volumeName, c.targetURL))
+	if err != nil {
+		return nil, err
+	}
+	return &Backup{
+		Name:              info.Name,
+		VolumeName:        volumeName,
+		URL:               info.URL,
+		SnapshotName:      info.SnapshotName,
+		SnapshotCreatedAt: parseTime(info.SnapshotCreated),
+		CreatedAt:         parseTime(info.Created),
+		Size:              info.Size,
+		Labels:            info.Labels,
+		IsIncremental:     info.IsIncremental,
+		CompressionMethod: info.CompressionMethod,
+	}, nil
+}
+
+func (c *client) DeleteBackup(ctx context.Context, volumeName, backupName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return backupstore.DeleteDeltaBlockBackup(backupstore.EncodeBackupURL(backupName, volumeName, c.targetURL))
+}
+
+func (c *client) DeleteVolume(ctx context.Context, volumeName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return backupstore.DeleteBackupVolume(volumeName, c.targetURL)
+}
+
+func getConcurrency(concurrency int) int {
+	if concurrency <= 0 {
+		return defaultConcurrency
+	}
+	return concurrency
+}
+
+func parseTime(value string) time.Time {
+	t, err := util.ParseTimestamp(value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+type backupResult struct {
+	err error
+}
+
+// backupOperations adapts the snapshot source to the snapshot operations of backupstore.
+type backupOperations struct {
+	ctx        context.Context
+	source     SnapshotSource
+	volumeSize int64
+	progress   func(percent int)
+
+	doneOnce sync.Once
+	done     chan backupResult
+}
+
+func (o *backupOperations) HasSnapshot(id, volumeID string) bool {
+	source, ok := o.source.(IncrementalSnapshotSource)
+	return ok && source.HasSnapshot(id)
+}
+
+func (o *backupOperations) CompareSnapshot(id, compareID, volumeID string) (*types.Mappings, error) {
+	var extents []Extent
+	var err error
+	if source, ok := o.source.(IncrementalSnapshotSource); ok && compareID != "" {
+		extents, err = source.ChangedExtents(compareID)
+	} else {
+		extents, err = o.source.DataExtents()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toMappings(extents, o.volumeSize), nil
+}
+
+func (o *backupOperations) OpenSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (o *backupOperations) ReadSnapshot(id, volumeID string, start int64, data []byte) error {
+	if err := o.ctx.Err(); err != nil {
+		return err
+	}
+	n, err := o.source.ReadAt(data, start)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for i := n; i < len(data); i++ {
+		data[i] = 0
+	}
+	return nil
+}
+
+func (o *backupOperations) CloseSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (o *backupOperations) UpdateBackupStatus(id, volumeID string, backupState string, backupProgress int, backupURL string, err string) error {
+	switch {
+	case err != "":
+		o.finish(fmt.Errorf("failed to back up volume %v snapshot %v: %v", volumeID, id, err))
+	case backupURL != "":
+		o.finish(nil)
+	case o.progress != nil:
+		o.progress(backupProgress)
+	}
+	return nil
+}
+
+func (o *backupOperations) finish(err error) {
+	o.doneOnce.Do(func() {
+		o.done <- backupResult{err: err}
+	})
+}
+
+// toMappings aligns the extents to the blocks and merges the overlapping ones.
+func toMappings(extents []Extent, volumeSize int64) *types.Mappings {
+	sort.Slice(extents, func(i, j int) bool { return extents[i].Offset < extents[j].Offset })
+
+	mappings := &types.Mappings{BlockSize: BlockSize, Mappings: []types.Mapping{}}
+	for _, extent := range extents {
+		if extent.Length <= 0 {
+			continue
+		}
+		start := extent.Offset / BlockSize * BlockSize
+		end := (extent.Offset + extent.Length + BlockSize - 1) / BlockSize * BlockSize
+		if end > volumeSize {
+			end = volumeSize
+		}
+		if start >= end {
+			continue
+		}
+		if last := len(mappings.Mappings) - 1; last >= 0 && mappings.Mappings[last].Offset+mappings.Mappings[last].Size >= start {
+			if lastEnd := mappings.Mappings[last].Offset + mappings.Mappings[last].Size; end > lastEnd {
+				mappings.Mappings[last].Size = end - mappings.Mappings[last].Offset
+			}
+			continue
+		}
+		mappings.Mappings = append(mappings.Mappings, types.Mapping{Offset: start, Size: end - start})
+	}
+	return mappings
+}
+
+// restoreOperations adapts the restore path to the volume device operations of backupstore.
+type restoreOperations struct {
+	progress func(percent int)
+
+	lock     sync.Mutex
+	closed   bool
+	stopOnce sync.Once
+	stopChan chan struct{}
+	done     chan error
+}
+
+func (o *restoreOperations) OpenVolumeDev(volDevName string) (*os.File, string, error) {
+	fh, err := os.OpenFile(volDevName, os.O_RDWR|os.O_CREATE, 0644)
+	return fh, volDevName, err
+}
+
+func (o *restoreOperations) CloseVolumeDev(volDev *os.File) error {
+	o.lock.Lock()
+	o.closed = true
+	o.lock.Unlock()
+	if volDev == nil {
+		return nil
+	}
+	return volDev.Close()
+}
+
+// UpdateRestoreStatus reports the progress, the status reported after the volume device is closed is the
+// result of the restore
+func (o *restoreOperations) UpdateRestoreStatus(snapshot string, restoreProgress int, err error) {
+	o.lock.Lock()
+	closed := o.closed
+	o.lock.Unlock()
+	if closed {
+		o.done <- err
+		return
+	}
+	if o.progress != nil {
+		o.progress(restoreProgress)
+	}
+}
+
+func (o *restoreOperations) Stop() {
+	o.stopOnce.Do(func() {
+		close(o.stopChan)
+	})
+}
+
+func (o *restoreOperations) GetStopChan() chan struct{} {
+	return o.stopChan
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+
+	_ "github.com/longhorn/backupstore/vfs"
+)
+
+type memorySnapshot struct {
+	data    []byte
+	base    string
+	changed []Extent
+}
+
+func (s *memorySnapshot) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(s.data).ReadAt(p, off)
+}
+
+func (s *memorySnapshot) DataExtents() ([]Extent, error) {
+	return []Extent{{Offset: 0, Length: int64(len(s.data))}}, nil
+}
+
+func (s *memorySnapshot) HasSnapshot(name string) bool {
+	return name == s.base
+}
+
+func (s *memorySnapshot) ChangedExtents(since string) ([]Extent, error) {
+	return s.changed, nil
+}
+
+func TestToMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	mappings := toMappings([]Extent{
+		{Offset: 3 * BlockSize, Length: 1},
+		{Offset: 10, Length: 20},
+		{Offset: BlockSize - 1, Length: 2},
+		{Offset: 8 * BlockSize, Length: 0},
+		{Offset: 7*BlockSize + 1, Length: 2 * BlockSize},
+	}, 8*BlockSize)
+	assert.Equal(int64(BlockSize), mappings.BlockSize)
+	assert.Equal([]types.Mapping{
+		{Offset: 0, Size: 2 * BlockSize},
+		{Offset: 3 * BlockSize, Size: BlockSize},
+		{Offset: 7 * BlockSize, Size: BlockSize},
+	}, mappings.Mappings)
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	c, err := New("vfs://"+t.TempDir(), Options{})
+	assert.NoError(err)
+
+	data := make([]byte, 2*BlockSize)
+	copy(data, "first block")
+	copy(data[BlockSize:], "second block")
+	source := &memorySnapshot{data: data[:BlockSize+20]}
+
+	backup, err := c.Backup(ctx, BackupOptions{
+		VolumeName:   "volume",
+		VolumeSize:   int64(len(data)),
+		SnapshotName: "snap1",
+		BackupName:   "backup1",
+		Source:       source,
+		Labels:       map[string]string{"app": "test"},
+	})
+	assert.NoError(err)
+	assert.Equal("backup1", backup.Name)
+	assert.Equal("snap1", backup.SnapshotName)
+	assert.Equal(map[string]string{"app": "test"}, backup.Labels)
+	assert.False(backup.IsIncremental)
+
+	copy(data[BlockSize:], "changed block")
+	source = &memorySnapshot{data: data, base: "snap1", changed: []Extent{{Offset: BlockSize, Length: 13}}}
+	backup, err = c.Backup(ctx, BackupOptions{
+		VolumeName:   "volume",
+		VolumeSize:   int64(len(data)),
+		SnapshotName: "snap2",
+		BackupName:   "backup2",
+		Source:       source,
+	})
+	assert.NoError(err)
+	assert.True(backup.IsIncremental)
+
+	volumes, err := c.ListVolumes(ctx)
+	assert.NoError(err)
+	if assert.Len(volumes, 1) {
+		assert.Equal("volume", volumes[0].Name)
+		assert.Equal(int64(len(data)), volumes[0].Size)
+		assert.Equal("backup2", volumes[0].LastBackupName)
+	}
+
+	backups, err := c.ListBackups(ctx, "volume")
+	assert.NoError(err)
+	if assert.Len(backups, 2) {
+		assert.Equal("backup1", backups[0].Name)
+		assert.Equal("backup2", backups[1].Name)
+	}
+
+	var progress []int
+	path := filepath.Join(t.TempDir(), "volume.img")
+	assert.NoError(c.Restore(ctx, RestoreOptions{
+		VolumeName: "volume",
+		BackupName: "backup2",
+		Path:       path,
+		Progress:   func(percent int) { progress = append(progress, percent) },
+	}))
+	restored, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal(data, restored)
+	assert.NotEmpty(progress)
+
+	assert.NoError(c.DeleteBackup(ctx, "volume", "backup1"))
+	backups, err = c.ListBackups(ctx, "volume")
+	assert.NoError(err)
+	assert.Len(backups, 1)
+
+	assert.NoError(c.DeleteVolume(ctx, "volume"))
+	volumes, err = c.ListVolumes(ctx)
+	assert.NoError(err)
+	assert.Empty(volumes)
+}
+
+func TestBackupValidation(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	_, err := New("unknown://target", Options{})
+	assert.Error(err)
+
+	c, err := New("vfs://"+t.TempDir(), Options{})
+	assert.NoError(err)
+	_, err = c.Backup(ctx, BackupOptions{VolumeName: "volume", SnapshotName: "snap", VolumeSize: 10, Source: &memorySnapshot{}})
+	assert.Error(err)
+	_, err = c.Backup(ctx, BackupOptions{VolumeName: "volume", SnapshotName: "snap", VolumeSize: BlockSize})
+	assert.Error(err)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.Backup(canceled, BackupOptions{VolumeName: "volume", SnapshotName: "snap", VolumeSize: BlockSize, Source: &memorySnapshot{}})
+	assert.ErrorIs(err, context.Canceled)
+}
+
+func TestAPIDependencies(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(backupstore.DEFAULT_BLOCK_SIZE, BlockSize)
+
+	// The API only depends on the standard library
+	pkg, err := build.ImportDir("api", 0)
+	assert.NoError(err)
+	for _, path := range pkg.Imports {
+		assert.False(strings.Contains(strings.Split(path, "/")[0], "."), "api imports %v", path)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -35,7 +36,7 @@ const (
 )
 
 func getBackupConfigName(id string) string {
-	return BACKUP_CONFIG_PREFIX + id + CFG_SUFFIX
+	return GetPathLayout().BackupConfigName(id)
 }
 
 func LoadConfigInBackupStore(driver BackupStoreDriver, filePath string, v interface{}) error {
@@ -73,6 +74,10 @@ func SaveConfigInBackupStore(driver BackupStoreDriver, filePath string, v interf
 	if err != nil {
 		return err
 	}
+	return saveConfigDataInBackupStore(driver, filePath, j)
+}
+
+func saveConfigDataInBackupStore(driver BackupStoreDriver, filePath string, j []byte) error {
 	log.WithFields(logrus.Fields{
 		LogFieldReason:   LogReasonStart,
 		LogFieldObject:   LogObjectConfig,
@@ -125,7 +130,7 @@ func SaveBackupStoreToLocalFile(driver BackupStoreDriver, backupStoreFileURL, lo
 	})
 	log.Debug()
 
-	if err := driver.Download(backupStoreFileURL, localFilePath); err != nil {
+	if err := DownloadObject(driver, backupStoreFileURL, localFilePath, nil); err != nil {
 		return err
 	}
 
@@ -176,15 +181,34 @@ func getVolumeFilePath(volumeName string) string {
 
 // getVolumeNames returns all volume names based on the folders on the backupstore
 func getVolumeNames(jobQueues *workerpool.WorkerPool, driver BackupStoreDriver) ([]string, error) {
+	names, pathErrs, err := getVolumeNamesWithErrors(jobQueues, driver)
+	if err != nil {
+		return names, err
+	}
+	if len(pathErrs) > 0 {
+		var errs []string
+		for _, pathErr := range pathErrs {
+			errs = append(errs, pathErr.Error())
+		}
+		sort.Strings(errs)
+		return names, errors.New(strings.Join(errs, "\n"))
+	}
+	return names, nil
+}
+
+// getVolumeNamesWithErrors returns the volume names based on the folders on the backupstore along with the
+// errors by the second or third level folders which cannot be listed. It fails only if the volume folder
+// itself cannot be listed.
+func getVolumeNamesWithErrors(jobQueues *workerpool.WorkerPool, driver BackupStoreDriver) ([]string, map[string]error, error) {
 	names := []string{}
 	volumePathBase := filepath.Join(backupstoreBase, VOLUME_DIRECTORY)
 	lv1Dirs, err := driver.List(volumePathBase)
 	if err != nil {
 		log.WithError(err).Warnf("Failed to list first level dirs for path %v", volumePathBase)
-		return names, err
+		return names, nil, err
 	}
 
-	var errs []string
+	errs := map[string]error{}
 	lv1Trackers := make(chan types.JobResult)
 	lv2Trackers := make(chan types.JobResult)
 	defer close(lv1Trackers)
@@ -211,7 +235,7 @@ func getVolumeNames(jobQueues *workerpool.WorkerPool, driver BackupStoreDriver)
 			})
 			if err != nil {
 				lv1Trackers <- types.JobResult{
-					Payload: nil,
+					Payload: path,
 					Err:     err,
 				}
 				return
@@ -228,7 +252,7 @@ func getVolumeNames(jobQueues *workerpool.WorkerPool, driver BackupStoreDriver)
 		lv1Tracker := <-lv1Trackers
 		payload, err := lv1Tracker.Payload, lv1Tracker.Err
 		if err != nil {
-			errs = append(errs, err.Error())
+			errs[payload.(string)] = err
 			continue
 		}
 
@@ -248,7 +272,7 @@ func getVolumeNames(jobQueues *workerpool.WorkerPool, driver BackupStoreDriver)
 				})
 				if err != nil {
 					lv2Trackers <- types.JobResult{
-						Payload: nil,
+						Payload: path,
 						Err:     err,
 					}
 					return
@@ -265,17 +289,14 @@ func getVolumeNames(jobQueues *workerpool.WorkerPool, driver BackupStoreDriver)
 		lv2Tracker := <-lv2Trackers
 		payload, err := lv2Tracker.Payload, lv2Tracker.Err
 		if err != nil {
-			errs = append(errs, err.Error())
+			errs[payload.(string)] = err
 			continue
 		}
 		volumeNames := payload.([]string)
 		names = append(names, volumeNames...)
 	}
 
-	if len(errs) > 0 {
-		return names, errors.New(strings.Join(errs, "\n"))
-	}
-	return names, nil
+	return names, errs, nil
 }
 
 func loadVolume(driver BackupStoreDriver, volumeName string) (*Volume, error) {
@@ -306,7 +327,12 @@ func getBackupNamesForVolume(driver BackupStoreDriver, volumeName string) ([]str
 		// path doesn't exist
 		return result, nil
 	}
-	return util.ExtractNames(fileList, BACKUP_CONFIG_PREFIX, CFG_SUFFIX), nil
+	for _, fileName := range fileList {
+		if name, ok := GetPathLayout().ParseBackupConfigName(fileName); ok {
+			result = append(result, name)
+		}
+	}
+	return result, nil
 }
 
 func getBackupPath(volumeName string) string {
@@ -328,12 +354,16 @@ func loadBackup(bsDriver BackupStoreDriver, backupName, volumeName string) (*Bac
 	if err := LoadConfigInBackupStore(bsDriver, getBackupConfigPath(backupName, volumeName), backup); err != nil {
 		return nil, err
 	}
+	fillBackupDefaults(backup)
+	return backup, nil
+}
+
+func fillBackupDefaults(backup *Backup) {
 	// Backward compatibility
 	if backup.CompressionMethod == "" {
 		log.Infof("Fall back compression method to %v for backup %v", LEGACY_COMPRESSION_METHOD, backup.Name)
 		backup.CompressionMethod = LEGACY_COMPRESSION_METHOD
 	}
-	return backup, nil
 }
 
 func saveBackup(bsDriver BackupStoreDriver, backup *Backup) error {
@@ -341,7 +371,14 @@ func saveBackup(bsDriver BackupStoreDriver, backup *Backup) error {
 		return fmt.Errorf("missing volume specifier for backup: %v", backup.Name)
 	}
 	filePath := getBackupConfigPath(backup.Name, backup.VolumeName)
-	return SaveConfigInBackupStore(bsDriver, filePath, backup)
+	j, err := json.Marshal(backup)
+	if err != nil {
+		return err
+	}
+	if err := saveConfigDataInBackupStore(bsDriver, filePath, j); err != nil {
+		return err
+	}
+	return signBackup(bsDriver, backup, j)
 }
 
 func removeBackup(backup *Backup, bsDriver BackupStoreDriver) error {
@@ -349,6 +386,11 @@ func removeBackup(backup *Backup, bsDriver BackupStoreDriver) error {
 	if err := bsDriver.Remove(filePath); err != nil {
 		return err
 	}
+	if signaturePath := filePath + SIGNATURE_SUFFIX; bsDriver.FileExists(signaturePath) {
+		if err := bsDriver.Remove(signaturePath); err != nil {
+			return err
+		}
+	}
 	log.Infof("Removed %v on backupstore", filePath)
 	return nil
 }
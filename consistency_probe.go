@@ -0,0 +1,152 @@
+package backupstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// ConsistencyProbeOption is the backup target URL query parameter enabling the consistency probe on the
+	// driver initialization, e.g. s3://bucket@region/path/?probe=true
+	ConsistencyProbeOption = "probe"
+
+	PROBE_DIRECTORY = "probes"
+)
+
+// ErrInconsistentTarget is returned when the backup target fails the consistency probe, e.g. it's eventually
+// consistent or doesn't implement the object storage semantics backupstore relies on.
+type ErrInconsistentTarget struct {
+	DestURL string
+	Check   string
+	Detail  string
+}
+
+func (e *ErrInconsistentTarget) Error() string {
+	return fmt.Sprintf("backup target %v failed the %v consistency check: %v. The backup target is not "+
+		"read-after-write consistent, using it may corrupt the backup metadata", e.DestURL, e.Check, e.Detail)
+}
+
+// IsInconsistentTargetError returns true if the error is caused by a failed consistency probe.
+func IsInconsistentTargetError(err error) bool {
+	var inconsistentErr *ErrInconsistentTarget
+	return errors.As(err, &inconsistentErr)
+}
+
+var (
+	probedTargetsLock sync.Mutex
+	// probedTargets are the backup targets passing the probe, they're not probed again in this process
+	probedTargets = map[string]bool{}
+)
+
+func isConsistencyProbeURL(destURL string) (bool, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return false, err
+	}
+	value := u.Query().Get(ConsistencyProbeOption)
+	if value == "" {
+		return false, nil
+	}
+	probe, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %v option %v in backup target URL", ConsistencyProbeOption, value)
+	}
+	return probe, nil
+}
+
+// probeTargetConsistency probes the backup target once in this process. A failed probe is run again the next
+// time the driver is initialized.
+func probeTargetConsistency(driver BackupStoreDriver) error {
+	probedTargetsLock.Lock()
+	defer probedTargetsLock.Unlock()
+
+	if probedTargets[driver.GetURL()] {
+		return nil
+	}
+	if err := runConsistencyProbe(driver); err != nil {
+		return err
+	}
+	probedTargets[driver.GetURL()] = true
+	return nil
+}
+
+// runConsistencyProbe writes, overwrites, reads, lists and deletes a probe object, checking each step is
+// immediately visible to the following ones.
+func runConsistencyProbe(driver BackupStoreDriver) (err error) {
+	probeDir := filepath.Join(backupstoreBase, PROBE_DIRECTORY)
+	probeName := util.GenerateName("probe")
+	probeFile := filepath.Join(probeDir, probeName)
+	fail := func(check, format string, v ...interface{}) error {
+		return &ErrInconsistentTarget{DestURL: driver.GetURL(), Check: check, Detail: fmt.Sprintf(format, v...)}
+	}
+
+	defer func() {
+		if err != nil {
+			if removeErr := driver.Remove(probeFile); removeErr != nil {
+				log.WithError(removeErr).Warnf("Failed to clean up probe object %v", probeFile)
+			}
+		}
+	}()
+
+	for i, check := range []string{"read-after-write", "read-after-overwrite"} {
+		content := []byte(fmt.Sprintf("%v %v", probeName, i))
+		if err := driver.Write(probeFile, bytes.NewReader(content)); err != nil {
+			return fmt.Errorf("failed to write probe object %v: %v", probeFile, err)
+		}
+		if size := driver.FileSize(probeFile); size != int64(len(content)) {
+			return fail(check, "the size of the written probe object is %v instead of %v", size, len(content))
+		}
+		rc, err := driver.Read(probeFile)
+		if err != nil {
+			return fail(check, "failed to read the written probe object: %v", err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fail(check, "failed to read the written probe object: %v", err)
+		}
+		if !bytes.Equal(data, content) {
+			return fail(check, "the probe object read %q instead of the written %q", data, content)
+		}
+	}
+
+	names, err := driver.List(probeDir)
+	if err != nil {
+		return fail("list-after-write", "failed to list the probe objects: %v", err)
+	}
+	if !containsName(names, probeName) {
+		return fail("list-after-write", "the written probe object %v is not listed", probeName)
+	}
+
+	if err := driver.Remove(probeFile); err != nil {
+		return fmt.Errorf("failed to remove probe object %v: %v", probeFile, err)
+	}
+	if driver.FileExists(probeFile) {
+		return fail("read-after-delete", "the removed probe object %v still exists", probeFile)
+	}
+	names, err = driver.List(probeDir)
+	if err != nil {
+		return fail("list-after-delete", "failed to list the probe objects: %v", err)
+	}
+	if containsName(names, probeName) {
+		return fail("list-after-delete", "the removed probe object %v is still listed", probeName)
+	}
+	return nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
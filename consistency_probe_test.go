@@ -0,0 +1,63 @@
+package backupstore
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staleReadDriver keeps returning the first version written to each file, like an eventually consistent
+// target serving the cached content.
+type staleReadDriver struct {
+	BackupStoreDriver
+	first map[string][]byte
+}
+
+func (d *staleReadDriver) Write(dst string, rs io.ReadSeeker) error {
+	data, err := io.ReadAll(rs)
+	if err != nil {
+		return err
+	}
+	if _, exists := d.first[dst]; !exists {
+		d.first[dst] = data
+	}
+	return d.BackupStoreDriver.Write(dst, bytes.NewReader(data))
+}
+
+func (d *staleReadDriver) Read(src string) (io.ReadCloser, error) {
+	if data, exists := d.first[src]; exists {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return d.BackupStoreDriver.Read(src)
+}
+
+func TestConsistencyProbe(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	_, err := GetBackupStoreDriver(mockDriverURL + "?probe=maybe")
+	assert.Error(err)
+
+	_, err = GetBackupStoreDriver(mockDriverURL + "?probe=true")
+	assert.NoError(err)
+	assert.True(probedTargets[m.GetURL()])
+	defer delete(probedTargets, m.GetURL())
+	// The probe object is removed
+	names, err := m.List(filepath.Join(backupstoreBase, PROBE_DIRECTORY))
+	assert.NoError(err)
+	assert.Empty(names)
+
+	err = runConsistencyProbe(&staleReadDriver{BackupStoreDriver: m, first: map[string][]byte{}})
+	assert.True(IsInconsistentTargetError(err))
+	assert.Equal("read-after-overwrite", err.(*ErrInconsistentTarget).Check)
+	assert.Contains(err.Error(), m.GetURL())
+	names, err = m.List(filepath.Join(backupstoreBase, PROBE_DIRECTORY))
+	assert.NoError(err)
+	assert.Empty(names)
+}
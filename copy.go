@@ -0,0 +1,222 @@
+package backupstore
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/longhorn/backupstore/logging"
+	"github.com/longhorn/backupstore/util"
+)
+
+// BlockManifest is the set of the block checksums.
+type BlockManifest map[string]struct{}
+
+// getBlockManifest returns the checksums of the blocks stored for the volume.
+func getBlockManifest(driver BackupStoreDriver, volumeName string) (BlockManifest, error) {
+	names, err := getBlockNamesForVolume(driver, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	manifest := make(BlockManifest, len(names))
+	for _, name := range names {
+		manifest[name] = struct{}{}
+	}
+	return manifest, nil
+}
+
+// getBackupBlockManifest returns the checksums of the blocks referenced by the backup.
+func getBackupBlockManifest(backup *Backup) BlockManifest {
+	manifest := make(BlockManifest, len(backup.Blocks))
+	for _, block := range backup.Blocks {
+		manifest[block.BlockChecksum] = struct{}{}
+	}
+	return manifest
+}
+
+// Missing returns the checksums in the manifest which are absent in the other one.
+func (m BlockManifest) Missing(other BlockManifest) []string {
+	missing := []string{}
+	for checksum := range m {
+		if _, exists := other[checksum]; !exists {
+			missing = append(missing, checksum)
+		}
+	}
+	return missing
+}
+
+// getBlockFileManifest returns the paths of the block files under the blocks directory, which is
+// organized in the same two levels of sub directories for both the volumes and the backing images.
+func getBlockFileManifest(driver BackupStoreDriver, blocksDir string) (map[string]bool, error) {
+	paths, err := listBlockFiles(driver, blocksDir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		files[path] = true
+	}
+	return files, nil
+}
+
+// CopyBackup copies the backup to the destination backup target. The block manifests of both targets are
+// compared first, so only the blocks absent at the destination are transferred.
+func CopyBackup(backupURL, destURL string) error {
+	src, err := GetBackupStoreDriver(backupURL)
+	if err != nil {
+		return err
+	}
+	dst, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+
+	backupName, volumeName, _, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+	log := log.WithFields(logrus.Fields{
+		LogFieldBackup:  backupName,
+		LogFieldVolume:  volumeName,
+		LogFieldDestURL: dst.GetURL(),
+	})
+
+	srcLock, err := New(src, volumeName, RESTORE_LOCK)
+	if err != nil {
+		return err
+	}
+	if err := srcLock.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := srcLock.Unlock(); unlockErr != nil {
+			log.WithError(unlockErr).Warn("Failed to unlock")
+		}
+	}()
+
+	dstLock, err := New(dst, volumeName, BACKUP_LOCK)
+	if err != nil {
+		return err
+	}
+	if err := dstLock.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := dstLock.Unlock(); unlockErr != nil {
+			log.WithError(unlockErr).Warn("Failed to unlock")
+		}
+	}()
+
+	if err := CheckTargetRedirect(dst); err != nil {
+		return err
+	}
+
+	volume, err := loadVolume(src, volumeName)
+	if err != nil {
+		return err
+	}
+	backup, err := loadBackup(src, backupName, volumeName)
+	if err != nil {
+		return err
+	}
+	if isBackupInProgress(backup) {
+		return fmt.Errorf("backup %v of volume %v is still in progress", backupName, volumeName)
+	}
+	if backup.SingleFile.FilePath != "" {
+		return fmt.Errorf("copying single file backup %v is not supported", backupName)
+	}
+
+	if dst.FileExists(getBackupConfigPath(backupName, volumeName)) {
+		log.Info("Backup already exists in the destination backup target")
+		return nil
+	}
+
+	dstVolume := volume
+	if volumeExists(dst, volumeName) {
+		if dstVolume, err = loadVolume(dst, volumeName); err != nil {
+			return err
+		}
+		// The blocks of the same checksum must be compressed in the same way to be shared
+		if dstVolume.CompressionMethod != backup.CompressionMethod {
+			return fmt.Errorf("cannot copy backup %v compressed by %v to volume %v compressed by %v",
+				backupName, backup.CompressionMethod, volumeName, dstVolume.CompressionMethod)
+		}
+	}
+
+	dstManifest, err := getBlockManifest(dst, volumeName)
+	if err != nil {
+		return errors.Wrap(err, "failed to get block manifest of the destination backup target")
+	}
+	manifest := getBackupBlockManifest(backup)
+	// The embedded blocks are copied along with the backup config
+	for checksum := range backup.InlineBlocks {
+		delete(manifest, checksum)
+	}
+	missing := manifest.Missing(dstManifest)
+	log.Infof("Copying %v of %v blocks absent in the destination backup target", len(missing), len(backup.Blocks))
+
+	for _, checksum := range missing {
+		srcPath := findBlockFilePath(src, volumeName, checksum)
+		if err := copyFileTo(src, dst, srcPath, getBlockFilePath(volumeName, checksum)); err != nil {
+			return err
+		}
+	}
+
+	// The blocks already in the destination are retained as long as the copied backup referencing them
+	if err := extendBlockRetention(dst, backup, RETENTION_EXTENSION_CONCURRENCY); err != nil {
+		return err
+	}
+
+	// The backup config is saved after the blocks so an interrupted copy is never visible
+	if err := copyBackupConfig(src, dst, backup); err != nil {
+		return err
+	}
+	recordBackupHistory(dst, volumeName, backupName, HistoryEventCreated)
+
+	dstVolume.BlockCount = int64(len(dstManifest) + len(missing))
+	if isLaterBackup(backup, dstVolume.LastBackupAt) {
+		dstVolume.LastBackupName = backup.Name
+		dstVolume.LastBackupAt = backup.SnapshotCreatedAt
+		dstVolume.Size = volume.Size
+	}
+	if err := saveVolume(dst, dstVolume); err != nil {
+		return err
+	}
+
+	log.Info("Copied backup")
+	return nil
+}
+
+// copyBackupConfig saves the backup config in the destination backup target. The signed backup config is copied
+// as is along with its signature, since the signature covers the exact config data.
+func copyBackupConfig(src, dst BackupStoreDriver, backup *Backup) error {
+	signaturePath := getBackupSignaturePath(backup.Name, backup.VolumeName)
+	if !src.FileExists(signaturePath) {
+		return saveBackup(dst, backup)
+	}
+	if err := copyFileTo(src, dst, signaturePath, signaturePath); err != nil {
+		return errors.Wrapf(err, "failed to copy signature of backup %v", backup.Name)
+	}
+	configPath := getBackupConfigPath(backup.Name, backup.VolumeName)
+	return copyFileTo(src, dst, configPath, configPath)
+}
+
+// isLaterBackup returns true if the backup is taken after the last backup of the volume. The timestamps are
+// compared as the instants, since the ones stored by the earlier versions are in the other layouts.
+func isLaterBackup(backup *Backup, lastBackupAt string) bool {
+	if lastBackupAt == "" {
+		return true
+	}
+	backupTime, err := util.ParseTimestamp(backup.SnapshotCreatedAt)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to parse backup %v time %v", backup.Name, backup.SnapshotCreatedAt)
+		return false
+	}
+	lastBackupTime, err := util.ParseTimestamp(lastBackupAt)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to parse last backup time %v", lastBackupAt)
+		return true
+	}
+	return backupTime.After(lastBackupTime)
+}
@@ -0,0 +1,124 @@
+package backupstore
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	newDriverURL := "mock2://localhost"
+	dst := &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: newDriverURL}
+	err := RegisterDriver("mock2", func(destURL string) (BackupStoreDriver, error) {
+		return dst, nil
+	})
+	assert.NoError(err)
+	defer unregisterDriver("mock2") // nolint:errcheck
+
+	shared, absent := "0123456789abcdef", "fedcba9876543210"
+	err = m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"),
+		[]byte(`{"Name":"pvc-1","Size":"4096"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","SnapshotCreatedAt":"2021-06-07T08:00:00Z",`+
+			`"CreatedTime":"2021-06-07T08:00:00Z",`+
+			`"Blocks":[{"Offset":0,"BlockChecksum":"`+shared+`"},{"Offset":2097152,"BlockChecksum":"`+absent+`"}]}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBlockFilePath("pvc-1", shared), []byte("shared"), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBlockFilePath("pvc-1", absent), []byte("absent"), 0644)
+	assert.NoError(err)
+
+	// The block already in the destination is not transferred again
+	err = afero.WriteFile(dst.fs, getBlockFilePath("pvc-1", shared), []byte("existing"), 0644)
+	assert.NoError(err)
+
+	backupURL := EncodeBackupURL("backup-1", "pvc-1", mockDriverURL)
+	err = CopyBackup(backupURL, newDriverURL)
+	assert.NoError(err)
+
+	data, err := afero.ReadFile(dst.fs, getBlockFilePath("pvc-1", shared))
+	assert.NoError(err)
+	assert.Equal("existing", string(data))
+	data, err = afero.ReadFile(dst.fs, getBlockFilePath("pvc-1", absent))
+	assert.NoError(err)
+	assert.Equal("absent", string(data))
+
+	backup, err := loadBackup(dst, "backup-1", "pvc-1")
+	assert.NoError(err)
+	assert.Len(backup.Blocks, 2)
+	volume, err := loadVolume(dst, "pvc-1")
+	assert.NoError(err)
+	assert.Equal("backup-1", volume.LastBackupName)
+	assert.Equal(int64(2), volume.BlockCount)
+
+	// Copying the backup again is a no-op
+	err = CopyBackup(backupURL, newDriverURL)
+	assert.NoError(err)
+}
+
+func TestCopySignedBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	newDriverURL := "mock2://localhost"
+	dst := &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: newDriverURL}
+	err := RegisterDriver("mock2", func(destURL string) (BackupStoreDriver, error) {
+		return dst, nil
+	})
+	assert.NoError(err)
+	defer unregisterDriver("mock2") // nolint:errcheck
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	defer SetBackupSigningConfig(nil)
+	SetBackupSigningConfig(&BackupSigningConfig{SigningKey: privateKey})
+
+	checksum := "0123456789abcdef"
+	err = m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1","Size":"4096"}`), 0644)
+	assert.NoError(err)
+	err = saveBackup(m, &Backup{
+		Name:              "backup-1",
+		VolumeName:        "pvc-1",
+		SnapshotCreatedAt: "2021-06-07T08:00:00Z",
+		CreatedTime:       "2021-06-07T08:00:00Z",
+		Blocks:            []BlockMapping{{Offset: 0, BlockChecksum: checksum}},
+	})
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBlockFilePath("pvc-1", checksum), []byte("data"), 0644)
+	assert.NoError(err)
+
+	// The last backup in the legacy layout is taken after the copied backup
+	err = dst.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(dst.fs, getVolumeFilePath("pvc-1"),
+		[]byte(`{"Name":"pvc-1","Size":"4096","LastBackupName":"backup-2","LastBackupAt":"2021-06-07 09:00:00 +0000 UTC"}`), 0644)
+	assert.NoError(err)
+
+	// The signature is copied along with the backup config, and still verified by the trusted key only
+	SetBackupSigningConfig(&BackupSigningConfig{TrustedKeys: []ed25519.PublicKey{publicKey}, RequireSignature: true})
+	err = CopyBackup(EncodeBackupURL("backup-1", "pvc-1", mockDriverURL), newDriverURL)
+	assert.NoError(err)
+	assert.True(dst.FileExists(getBackupSignaturePath("backup-1", "pvc-1")))
+	_, err = loadBackup(dst, "backup-1", "pvc-1")
+	assert.NoError(err)
+
+	volume, err := loadVolume(dst, "pvc-1")
+	assert.NoError(err)
+	assert.Equal("backup-2", volume.LastBackupName)
+}
@@ -0,0 +1,224 @@
+package credential
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	inClusterTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// KubeConfig is the minimal configuration for accessing the Kubernetes API server.
+type KubeConfig struct {
+	Host      string
+	Namespace string
+
+	BearerToken     string
+	BearerTokenFile string // The token file is re-read on each request, so the rotated tokens are picked up
+	CAData          []byte
+	CertData        []byte
+	KeyData         []byte
+	Insecure        bool
+}
+
+// InClusterConfig returns the configuration using the service account mounted into the pod.
+func InClusterConfig() (*KubeConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("unable to load in-cluster configuration, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be defined")
+	}
+
+	caData, err := os.ReadFile(inClusterCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read in-cluster CA")
+	}
+
+	config := &KubeConfig{
+		Host:            "https://" + net.JoinHostPort(host, port),
+		BearerTokenFile: inClusterTokenFile,
+		CAData:          caData,
+	}
+	if namespace, err := os.ReadFile(inClusterNamespaceFile); err == nil {
+		config.Namespace = strings.TrimSpace(string(namespace))
+	}
+	return config, nil
+}
+
+type kubeConfigFile struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// LoadKubeConfig loads the current context of the kubeconfig file. Only the token and client certificate
+// authentications are supported.
+func LoadKubeConfig(path string) (*KubeConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read kubeconfig %v", path)
+	}
+
+	file := &kubeConfigFile{}
+	if err := yaml.Unmarshal(content, file); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse kubeconfig %v", path)
+	}
+
+	config := &KubeConfig{}
+	var clusterName, userName string
+	for _, c := range file.Contexts {
+		if c.Name == file.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+			config.Namespace = c.Context.Namespace
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("cannot find current context %v in kubeconfig %v", file.CurrentContext, path)
+	}
+
+	baseDir := filepath.Dir(path)
+	for _, c := range file.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		config.Host = c.Cluster.Server
+		config.Insecure = c.Cluster.InsecureSkipTLSVerify
+		if config.CAData, err = loadData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, baseDir); err != nil {
+			return nil, err
+		}
+	}
+	if config.Host == "" {
+		return nil, fmt.Errorf("cannot find cluster %v in kubeconfig %v", clusterName, path)
+	}
+
+	for _, u := range file.Users {
+		if u.Name != userName {
+			continue
+		}
+		config.BearerToken = u.User.Token
+		if u.User.TokenFile != "" {
+			config.BearerTokenFile = resolvePath(u.User.TokenFile, baseDir)
+		}
+		if config.CertData, err = loadData(u.User.ClientCertificateData, u.User.ClientCertificate, baseDir); err != nil {
+			return nil, err
+		}
+		if config.KeyData, err = loadData(u.User.ClientKeyData, u.User.ClientKey, baseDir); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func resolvePath(path, baseDir string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+func loadData(data, file, baseDir string) ([]byte, error) {
+	if data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode kubeconfig data")
+		}
+		return decoded, nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(resolvePath(file, baseDir))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read kubeconfig file %v", file)
+	}
+	return content, nil
+}
+
+type kubeClient struct {
+	config *KubeConfig
+	client *http.Client
+}
+
+func newKubeClient(config *KubeConfig) (*kubeClient, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure}
+	if len(config.CAData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(config.CAData) {
+			return nil, fmt.Errorf("failed to append the Kubernetes API server CA")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.CertData) > 0 || len(config.KeyData) > 0 {
+		cert, err := tls.X509KeyPair(config.CertData, config.KeyData)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load the client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &kubeClient{
+		config: config,
+		client: &http.Client{Transport: transport},
+	}, nil
+}
+
+func (c *kubeClient) newRequest(path string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(c.config.Host, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	token := c.config.BearerToken
+	if c.config.BearerTokenFile != "" {
+		content, err := os.ReadFile(c.config.BearerTokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the bearer token")
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
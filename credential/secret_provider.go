@@ -0,0 +1,256 @@
+package credential
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "credential"})
+
+	// SecretWatchRetryInterval is the interval for re-establishing the broken Secret watch
+	SecretWatchRetryInterval = 5 * time.Second
+
+	// EnvironmentKeys are the credential keys consumed from the environment by the backup store drivers
+	EnvironmentKeys = []string{
+		types.AWSAccessKey,
+		types.AWSSecretKey,
+		types.AWSEndPoint,
+		types.AWSCert,
+		types.AWSClientCert,
+		types.AWSClientKey,
+		types.AWSInsecureSkipVerify,
+		types.CIFSUsername,
+		types.CIFSPassword,
+		types.AZBlobAccountName,
+		types.AZBlobAccountKey,
+		types.AZBlobEndpoint,
+		types.AZBlobCert,
+		types.AZBlobSASToken,
+		types.AZBlobCredentialSource,
+		types.AZBlobClientCert,
+		types.AZBlobClientKey,
+		types.AZBlobInsecureSkipVerify,
+		types.AzureTenantID,
+		types.AzureClientID,
+		types.AzureClientSecret,
+		types.AzureFederatedTokenFile,
+		types.AzureAuthorityHost,
+		types.HTTPSProxy,
+		types.HTTPProxy,
+		types.NOProxy,
+		types.ProxyUsername,
+		types.ProxyPassword,
+		types.VirtualHostedStyle,
+	}
+)
+
+type secret struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string][]byte `json:"data"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// SecretProvider provides the backup target credentials stored in a Kubernetes Secret. The Secret is
+// watched once the provider is started, so the rotated credentials take effect without restarting.
+type SecretProvider struct {
+	namespace string
+	name      string
+	client    *kubeClient
+
+	lock            sync.RWMutex
+	credentials     map[string]string
+	resourceVersion string
+	handlers        []func(map[string]string)
+}
+
+// NewSecretProvider returns a provider of the credentials in the given Secret, the namespace of the
+// config is used if the namespace is empty. The Secret is loaded immediately.
+func NewSecretProvider(config *KubeConfig, namespace, name string) (*SecretProvider, error) {
+	if namespace == "" {
+		namespace = config.Namespace
+	}
+	if namespace == "" || name == "" {
+		return nil, fmt.Errorf("the namespace and the name of the credential secret are required")
+	}
+
+	client, err := newKubeClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &SecretProvider{
+		namespace: namespace,
+		name:      name,
+		client:    client,
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Get returns a copy of the current credentials.
+func (p *SecretProvider) Get() map[string]string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	credentials := make(map[string]string, len(p.credentials))
+	for k, v := range p.credentials {
+		credentials[k] = v
+	}
+	return credentials
+}
+
+// OnUpdate registers a handler called with the new credentials whenever the Secret changes.
+func (p *SecretProvider) OnUpdate(handler func(map[string]string)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.handlers = append(p.handlers, handler)
+}
+
+// Start watches the Secret until the context is done.
+func (p *SecretProvider) Start(ctx context.Context) {
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := p.watch(ctx); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warnf("Failed to watch credential secret %v/%v", p.namespace, p.name)
+			// The watch may be expired, reload the secret in case any update is missed
+			if err := p.load(); err != nil {
+				log.WithError(err).Warnf("Failed to reload credential secret %v/%v", p.namespace, p.name)
+			}
+		}
+	}, SecretWatchRetryInterval)
+}
+
+func (p *SecretProvider) secretPath() string {
+	return fmt.Sprintf("/api/v1/namespaces/%v/secrets", url.PathEscape(p.namespace))
+}
+
+func (p *SecretProvider) load() error {
+	req, err := p.client.newRequest(p.secretPath() + "/" + url.PathEscape(p.name))
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get credential secret %v/%v", p.namespace, p.name)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to get credential secret %v/%v: %v %v", p.namespace, p.name, resp.Status, string(body))
+	}
+
+	s := &secret{}
+	if err := json.NewDecoder(resp.Body).Decode(s); err != nil {
+		return errors.Wrapf(err, "failed to decode credential secret %v/%v", p.namespace, p.name)
+	}
+	p.update(s)
+	return nil
+}
+
+func (p *SecretProvider) watch(ctx context.Context) error {
+	p.lock.RLock()
+	resourceVersion := p.resourceVersion
+	p.lock.RUnlock()
+
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("fieldSelector", "metadata.name="+p.name)
+	query.Set("resourceVersion", resourceVersion)
+	req, err := p.client.newRequest(p.secretPath() + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected watch response %v", resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		event := &watchEvent{}
+		if err := decoder.Decode(event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			s := &secret{}
+			if err := json.Unmarshal(event.Object, s); err != nil {
+				return errors.Wrap(err, "failed to decode the watched secret")
+			}
+			p.update(s)
+		case "DELETED":
+			log.Warnf("Credential secret %v/%v is deleted, keeping the last credentials", p.namespace, p.name)
+		case "ERROR":
+			return fmt.Errorf("watch error: %v", string(event.Object))
+		}
+	}
+}
+
+func (p *SecretProvider) update(s *secret) {
+	credentials := make(map[string]string, len(s.Data))
+	for k, v := range s.Data {
+		credentials[k] = string(v)
+	}
+
+	p.lock.Lock()
+	if p.resourceVersion == s.Metadata.ResourceVersion {
+		p.lock.Unlock()
+		return
+	}
+	p.resourceVersion = s.Metadata.ResourceVersion
+	p.credentials = credentials
+	handlers := append([]func(map[string]string){}, p.handlers...)
+	p.lock.Unlock()
+
+	log.Infof("Loaded credential secret %v/%v of resource version %v", p.namespace, p.name, s.Metadata.ResourceVersion)
+	for _, handler := range handlers {
+		handler(credentials)
+	}
+}
+
+// ApplyToEnvironment sets the environment variables consumed by the backup store drivers. The known keys
+// missing from the credentials are unset.
+func ApplyToEnvironment(credentials map[string]string) error {
+	for _, key := range EnvironmentKeys {
+		value, exists := credentials[key]
+		if !exists {
+			if err := os.Unsetenv(key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return errors.Wrapf(err, "failed to set environment variable %v", key)
+		}
+	}
+	return nil
+}
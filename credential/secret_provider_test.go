@@ -0,0 +1,89 @@
+package credential
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	updates := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("Bearer test-token", r.Header.Get("Authorization"))
+		if r.URL.Query().Get("watch") != "true" {
+			assert.Equal("/api/v1/namespaces/longhorn-system/secrets/backup-secret", r.URL.Path)
+			fmt.Fprint(w, `{"metadata":{"resourceVersion":"1"},"data":{"AWS_ACCESS_KEY_ID":"b2xk"}}`)
+			return
+		}
+		assert.Equal("/api/v1/namespaces/longhorn-system/secrets", r.URL.Path)
+		assert.Equal("metadata.name=backup-secret", r.URL.Query().Get("fieldSelector"))
+		select {
+		case key := <-updates:
+			object := fmt.Sprintf(`{"metadata":{"resourceVersion":"2"},"data":{"AWS_ACCESS_KEY_ID":%q}}`, key)
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "MODIFIED", "object": json.RawMessage(object)}) // nolint:errcheck
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`
+apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test-cluster
+  cluster:
+    server: %v
+contexts:
+- name: test
+  context:
+    cluster: test-cluster
+    user: test-user
+    namespace: longhorn-system
+users:
+- name: test-user
+  user:
+    token: test-token
+`, server.URL)), 0600)
+	assert.NoError(err)
+
+	config, err := LoadKubeConfig(kubeconfig)
+	assert.NoError(err)
+	assert.Equal(server.URL, config.Host)
+	assert.Equal("longhorn-system", config.Namespace)
+
+	provider, err := NewSecretProvider(config, "", "backup-secret")
+	assert.NoError(err)
+	assert.Equal("old", provider.Get()["AWS_ACCESS_KEY_ID"])
+
+	updated := make(chan map[string]string, 1)
+	provider.OnUpdate(func(credentials map[string]string) {
+		updated <- credentials
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	provider.Start(ctx)
+
+	// b64 of "new"
+	updates <- "bmV3"
+	select {
+	case credentials := <-updated:
+		assert.Equal("new", credentials["AWS_ACCESS_KEY_ID"])
+	case <-time.After(10 * time.Second):
+		assert.Fail("timed out waiting for the secret update")
+	}
+	assert.Equal("new", provider.Get()["AWS_ACCESS_KEY_ID"])
+}
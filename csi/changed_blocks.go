@@ -0,0 +1,126 @@
+package csi
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+)
+
+// BlockMetadataType is the type of the block metadata returned by the CSI SnapshotMetadata service.
+type BlockMetadataType int32
+
+const (
+	BlockMetadataTypeUnknown        = BlockMetadataType(0)
+	BlockMetadataTypeFixedLength    = BlockMetadataType(1)
+	BlockMetadataTypeVariableLength = BlockMetadataType(2)
+)
+
+// BlockMetadata is an extent of the allocated or changed data of a snapshot.
+type BlockMetadata struct {
+	ByteOffset int64 `json:"byte_offset"`
+	SizeBytes  int64 `json:"size_bytes"`
+}
+
+// MetadataResponse mirrors a GetMetadataAllocatedResponse or GetMetadataDeltaResponse message streamed
+// by the CSI SnapshotMetadata service.
+type MetadataResponse struct {
+	BlockMetadataType   BlockMetadataType `json:"block_metadata_type"`
+	VolumeCapacityBytes int64             `json:"volume_capacity_bytes"`
+	BlockMetadata       []BlockMetadata   `json:"block_metadata"`
+}
+
+// ToMappings converts the streamed CSI block metadata into the mappings consumed by CreateDeltaBlockBackup.
+// The extents are expanded to the block boundaries and merged, so each backup block containing any
+// changed data is backed up.
+func ToMappings(responses []*MetadataResponse, blockSize int64) (*types.Mappings, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size %v", blockSize)
+	}
+
+	var (
+		extents  []types.Mapping
+		capacity int64
+	)
+	for _, resp := range responses {
+		if resp.BlockMetadataType != BlockMetadataTypeFixedLength && resp.BlockMetadataType != BlockMetadataTypeVariableLength {
+			return nil, fmt.Errorf("unsupported block metadata type %v", resp.BlockMetadataType)
+		}
+		if resp.VolumeCapacityBytes > capacity {
+			capacity = resp.VolumeCapacityBytes
+		}
+		for _, block := range resp.BlockMetadata {
+			if block.ByteOffset < 0 || block.SizeBytes < 0 {
+				return nil, fmt.Errorf("invalid block metadata at offset %v size %v", block.ByteOffset, block.SizeBytes)
+			}
+			if block.SizeBytes == 0 {
+				continue
+			}
+			start := block.ByteOffset / blockSize * blockSize
+			end := (block.ByteOffset + block.SizeBytes + blockSize - 1) / blockSize * blockSize
+			extents = append(extents, types.Mapping{Offset: start, Size: end - start})
+		}
+	}
+
+	sort.Slice(extents, func(i, j int) bool {
+		return extents[i].Offset < extents[j].Offset
+	})
+
+	mappings := &types.Mappings{
+		Mappings:  []types.Mapping{},
+		BlockSize: blockSize,
+	}
+	for _, extent := range extents {
+		if capacity > 0 && extent.Offset+extent.Size > capacity {
+			if extent.Offset >= capacity {
+				return nil, fmt.Errorf("block metadata at offset %v is beyond the volume capacity %v", extent.Offset, capacity)
+			}
+			return nil, fmt.Errorf("block metadata at offset %v size %v cannot be aligned within the volume capacity %v",
+				extent.Offset, extent.Size, capacity)
+		}
+		last := len(mappings.Mappings) - 1
+		if last >= 0 && extent.Offset <= mappings.Mappings[last].Offset+mappings.Mappings[last].Size {
+			if end := extent.Offset + extent.Size; end > mappings.Mappings[last].Offset+mappings.Mappings[last].Size {
+				mappings.Mappings[last].Size = end - mappings.Mappings[last].Offset
+			}
+			continue
+		}
+		mappings.Mappings = append(mappings.Mappings, extent)
+	}
+	return mappings, nil
+}
+
+// ChangedBlockDeltaOps feeds the changed blocks reported by the CSI SnapshotMetadata service into
+// CreateDeltaBlockBackup, instead of diffing the snapshots by the engine. The snapshot data is still
+// read through the wrapped operations.
+type ChangedBlockDeltaOps struct {
+	backupstore.DeltaBlockBackupOperations
+
+	// GetMetadataAllocated returns the allocated blocks of the snapshot, used for the full backups
+	GetMetadataAllocated func(snapshotID, volumeID string) ([]*MetadataResponse, error)
+	// GetMetadataDelta returns the changed blocks between the base snapshot and the snapshot
+	GetMetadataDelta func(baseSnapshotID, snapshotID, volumeID string) ([]*MetadataResponse, error)
+}
+
+func (o *ChangedBlockDeltaOps) CompareSnapshot(id, compareID, volumeID string) (*types.Mappings, error) {
+	var (
+		responses []*MetadataResponse
+		err       error
+	)
+	if compareID == "" {
+		if o.GetMetadataAllocated == nil {
+			return nil, fmt.Errorf("BUG: missing GetMetadataAllocated for the full backup of snapshot %v", id)
+		}
+		responses, err = o.GetMetadataAllocated(id, volumeID)
+	} else {
+		if o.GetMetadataDelta == nil {
+			return nil, fmt.Errorf("BUG: missing GetMetadataDelta for the incremental backup of snapshot %v", id)
+		}
+		responses, err = o.GetMetadataDelta(compareID, id, volumeID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the changed blocks of volume %v snapshot %v: %w", volumeID, id, err)
+	}
+	return ToMappings(responses, backupstore.DEFAULT_BLOCK_SIZE)
+}
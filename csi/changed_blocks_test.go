@@ -0,0 +1,50 @@
+package csi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+func TestToMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	const blockSize = 1024
+	responses := []*MetadataResponse{
+		{
+			BlockMetadataType:   BlockMetadataTypeVariableLength,
+			VolumeCapacityBytes: 16 * blockSize,
+			BlockMetadata: []BlockMetadata{
+				{ByteOffset: 8 * blockSize, SizeBytes: 10},
+				{ByteOffset: 100, SizeBytes: 2000},
+			},
+		},
+		{
+			BlockMetadataType:   BlockMetadataTypeVariableLength,
+			VolumeCapacityBytes: 16 * blockSize,
+			BlockMetadata: []BlockMetadata{
+				{ByteOffset: 2 * blockSize, SizeBytes: blockSize},
+				{ByteOffset: 9 * blockSize, SizeBytes: 0},
+				{ByteOffset: 15*blockSize + 1, SizeBytes: 1},
+			},
+		},
+	}
+
+	mappings, err := ToMappings(responses, blockSize)
+	assert.NoError(err)
+	assert.Equal(int64(blockSize), mappings.BlockSize)
+	assert.Equal([]types.Mapping{
+		{Offset: 0, Size: 3 * blockSize},
+		{Offset: 8 * blockSize, Size: blockSize},
+		{Offset: 15 * blockSize, Size: blockSize},
+	}, mappings.Mappings)
+
+	responses[0].BlockMetadata = append(responses[0].BlockMetadata, BlockMetadata{ByteOffset: 16 * blockSize, SizeBytes: 1})
+	_, err = ToMappings(responses, blockSize)
+	assert.Error(err)
+
+	_, err = ToMappings([]*MetadataResponse{{BlockMetadataType: BlockMetadataTypeUnknown}}, blockSize)
+	assert.Error(err)
+}
@@ -0,0 +1,423 @@
+package backupstore
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMetadataDeadline is the default max duration of listing, checking and removing the files
+	DefaultMetadataDeadline = 5 * time.Minute
+
+	// DefaultTransferDeadline is the default max duration of transferring a file, a read lasts until the reader
+	// is closed
+	DefaultTransferDeadline = 2 * time.Hour
+)
+
+// OperationDeadlines are the max durations of the driver operations by class. A zero duration keeps the
+// default, and a negative duration disables the deadline.
+type OperationDeadlines struct {
+	Metadata time.Duration // List, ListDeletedObjects, FileExists, FileSize, FileTime, GetMetadata, ExtendRetention and Remove
+	Transfer time.Duration // Read, ReadRange, Write, WriteWithMetadata, Copy, RestoreObjectVersion, Upload and Download
+}
+
+var (
+	defaultDeadlinesLock sync.RWMutex
+	defaultDeadlines     = OperationDeadlines{
+		Metadata: DefaultMetadataDeadline,
+		Transfer: DefaultTransferDeadline,
+	}
+)
+
+// SetDefaultOperationDeadlines sets the deadlines of the operations of all the drivers, the zero durations
+// reset the class to the built-in default.
+func SetDefaultOperationDeadlines(deadlines OperationDeadlines) {
+	defaultDeadlinesLock.Lock()
+	defer defaultDeadlinesLock.Unlock()
+	defaultDeadlines = deadlines.merge(OperationDeadlines{
+		Metadata: DefaultMetadataDeadline,
+		Transfer: DefaultTransferDeadline,
+	})
+}
+
+// GetDefaultOperationDeadlines returns the deadlines of the operations of all the drivers.
+func GetDefaultOperationDeadlines() OperationDeadlines {
+	defaultDeadlinesLock.RLock()
+	defer defaultDeadlinesLock.RUnlock()
+	return defaultDeadlines
+}
+
+// merge returns the deadlines with the zero durations taken from the fallback.
+func (d OperationDeadlines) merge(fallback OperationDeadlines) OperationDeadlines {
+	if d.Metadata == 0 {
+		d.Metadata = fallback.Metadata
+	}
+	if d.Transfer == 0 {
+		d.Transfer = fallback.Transfer
+	}
+	return d
+}
+
+// ErrOperationTimeout is returned when a driver operation doesn't complete within its deadline. The operation
+// may still be running in the background, e.g. blocked on a hung connection, but a write no longer reads the
+// data of the caller.
+type ErrOperationTimeout struct {
+	DestURL   string
+	Operation string
+	Path      string
+	Deadline  time.Duration
+}
+
+func (e *ErrOperationTimeout) Error() string {
+	return fmt.Sprintf("%v of %v on backup target %v didn't complete within %v", e.Operation, e.Path, e.DestURL,
+		e.Deadline)
+}
+
+// IsOperationTimeoutError returns true if the error is caused by a driver operation exceeding its deadline.
+func IsOperationTimeoutError(err error) bool {
+	var timeoutErr *ErrOperationTimeout
+	return errors.As(err, &timeoutErr)
+}
+
+// deadlineDriver wraps a driver and stops waiting for the operations exceeding their deadlines, so a hung
+// connection doesn't block a backup forever. The deadlines are nil to follow the default deadlines.
+type deadlineDriver struct {
+	BackupStoreDriver
+	deadlines *OperationDeadlines
+}
+
+// WithOperationDeadlines returns the driver with the deadlines overriding the default deadlines, e.g. for a
+// transfer known to exceed the default. The zero durations keep the deadlines of the driver.
+func WithOperationDeadlines(driver BackupStoreDriver, deadlines OperationDeadlines) BackupStoreDriver {
+	if d, ok := driver.(*deadlineDriver); ok {
+		if d.deadlines != nil {
+			deadlines = deadlines.merge(*d.deadlines)
+		}
+		driver = d.BackupStoreDriver
+	}
+	return &deadlineDriver{BackupStoreDriver: driver, deadlines: &deadlines}
+}
+
+func (d *deadlineDriver) Unwrap() BackupStoreDriver {
+	return d.BackupStoreDriver
+}
+
+func (d *deadlineDriver) getDeadlines() OperationDeadlines {
+	if d.deadlines == nil {
+		return GetDefaultOperationDeadlines()
+	}
+	return d.deadlines.merge(GetDefaultOperationDeadlines())
+}
+
+// run returns the error of the operation, or the timeout error once the deadline is exceeded.
+func (d *deadlineDriver) run(operation, path string, deadline time.Duration, f func() error) error {
+	return d.runOrAbandon(operation, path, deadline, f, nil)
+}
+
+// runOrAbandon is run calling abandon with the result of the operation once the deadline is exceeded, before
+// the timeout error is returned. The operation cannot be cancelled, so an operation hung forever keeps its
+// goroutine, and the one completing after the deadline sends the result to the buffered channel.
+func (d *deadlineDriver) runOrAbandon(operation, path string, deadline time.Duration, f func() error,
+	abandon func(result <-chan error)) error {
+	if deadline < 0 {
+		return f()
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- f()
+	}()
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+	select {
+	case err := <-errc:
+		return err
+	case <-timer.C:
+		log.Warnf("Stopped waiting for %v of %v on backup target %v after %v", operation, path, d.GetURL(), deadline)
+		if abandon != nil {
+			abandon(errc)
+		}
+		return &ErrOperationTimeout{DestURL: d.GetURL(), Operation: operation, Path: path, Deadline: deadline}
+	}
+}
+
+func (d *deadlineDriver) List(path string) ([]string, error) {
+	var names []string
+	err := d.run(DriverOperationList, path, d.getDeadlines().Metadata, func() error {
+		var err error
+		names, err = d.BackupStoreDriver.List(path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (d *deadlineDriver) FileExists(filePath string) bool {
+	return d.FileSize(filePath) >= 0
+}
+
+// FileSize returns -1 if the deadline is exceeded, since the size is unknown.
+func (d *deadlineDriver) FileSize(filePath string) int64 {
+	var size int64
+	if err := d.run(DriverOperationStat, filePath, d.getDeadlines().Metadata, func() error {
+		size = d.BackupStoreDriver.FileSize(filePath)
+		return nil
+	}); err != nil {
+		return -1
+	}
+	return size
+}
+
+func (d *deadlineDriver) FileTime(filePath string) time.Time {
+	var t time.Time
+	if err := d.run(DriverOperationStat, filePath, d.getDeadlines().Metadata, func() error {
+		t = d.BackupStoreDriver.FileTime(filePath)
+		return nil
+	}); err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (d *deadlineDriver) Remove(path string) error {
+	return d.run(DriverOperationRemove, path, d.getDeadlines().Metadata, func() error {
+		return d.BackupStoreDriver.Remove(path)
+	})
+}
+
+func (d *deadlineDriver) RemoveAll(paths []string) error {
+	return d.run(DriverOperationRemove, fmt.Sprintf("%v files", len(paths)), d.getDeadlines().Metadata, func() error {
+		return removeAll(d.BackupStoreDriver, paths)
+	})
+}
+
+// Read closes the reader once the deadline is exceeded, so the reads blocked on a hung connection return.
+func (d *deadlineDriver) Read(src string) (io.ReadCloser, error) {
+	return d.read(src, func() (io.ReadCloser, error) {
+		return d.BackupStoreDriver.Read(src)
+	})
+}
+
+// ReadRange closes the reader once the deadline is exceeded like Read.
+func (d *deadlineDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	return d.read(src, func() (io.ReadCloser, error) {
+		return readRange(d.BackupStoreDriver, src, offset, length)
+	})
+}
+
+func (d *deadlineDriver) read(src string, open func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	deadline := d.getDeadlines().Transfer
+	start := time.Now()
+	var rc io.ReadCloser
+	if err := d.run(DriverOperationRead, src, deadline, func() error {
+		var err error
+		rc, err = open()
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if deadline < 0 {
+		return rc, nil
+	}
+
+	r := &deadlineReadCloser{ReadCloser: rc}
+	r.err = &ErrOperationTimeout{DestURL: d.GetURL(), Operation: DriverOperationRead, Path: src, Deadline: deadline}
+	r.timer = time.AfterFunc(deadline-time.Since(start), r.expire)
+	return r, nil
+}
+
+// Write stops the abandoned write from reading the data once the deadline is exceeded, so the caller can reuse
+// or free the data after the timeout error is returned. The write may still complete if it has read all the
+// data already, and the late lock files are removed since the lock isn't acquired by the caller.
+func (d *deadlineDriver) Write(dst string, rs io.ReadSeeker) error {
+	return d.write(dst, rs, func(data io.ReadSeeker) error {
+		return d.BackupStoreDriver.Write(dst, data)
+	})
+}
+
+// WriteWithMetadata stops the abandoned write from reading the data like Write.
+func (d *deadlineDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *ObjectMetadata) error {
+	return d.write(dst, rs, func(data io.ReadSeeker) error {
+		return writeWithMetadata(d.BackupStoreDriver, dst, data, metadata)
+	})
+}
+
+func (d *deadlineDriver) write(dst string, rs io.ReadSeeker, write func(data io.ReadSeeker) error) error {
+	deadline := d.getDeadlines().Transfer
+	data, expire := newDeadlineReadSeeker(rs, &ErrOperationTimeout{DestURL: d.GetURL(), Operation: DriverOperationWrite,
+		Path: dst, Deadline: deadline})
+	return d.runOrAbandon(DriverOperationWrite, dst, deadline, func() error {
+		return write(data)
+	}, func(result <-chan error) {
+		expire()
+		if !isLockFile(dst) {
+			return
+		}
+		go func() {
+			if err := <-result; err != nil {
+				return
+			}
+			log.Warnf("Removing lock %v written on backup target %v after %v", dst, d.GetURL(), deadline)
+			if err := d.BackupStoreDriver.Remove(dst); err != nil {
+				log.WithError(err).Warnf("Failed to remove lock %v written after the deadline", dst)
+			}
+		}()
+	})
+}
+
+func (d *deadlineDriver) GetMetadata(filePath string) (*ObjectMetadata, error) {
+	var metadata *ObjectMetadata
+	if err := d.run(DriverOperationStat, filePath, d.getDeadlines().Metadata, func() error {
+		var err error
+		metadata, err = getMetadata(d.BackupStoreDriver, filePath)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func (d *deadlineDriver) Upload(src, dst string) error {
+	return d.run(DriverOperationUpload, dst, d.getDeadlines().Transfer, func() error {
+		return d.BackupStoreDriver.Upload(src, dst)
+	})
+}
+
+func (d *deadlineDriver) Download(src, dst string) error {
+	return d.run(DriverOperationDownload, src, d.getDeadlines().Transfer, func() error {
+		return d.BackupStoreDriver.Download(src, dst)
+	})
+}
+
+func (d *deadlineDriver) Copy(src, dst string) error {
+	return d.run(DriverOperationWrite, dst, d.getDeadlines().Transfer, func() error {
+		return copyObject(d.BackupStoreDriver, src, dst)
+	})
+}
+
+func (d *deadlineDriver) ListDeletedObjects(path string) ([]DeletedObject, error) {
+	var objects []DeletedObject
+	err := d.run(DriverOperationList, path, d.getDeadlines().Metadata, func() error {
+		var err error
+		objects, err = listDeletedObjects(d.BackupStoreDriver, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (d *deadlineDriver) RestoreObjectVersion(filePath, versionID string) error {
+	return d.run(DriverOperationWrite, filePath, d.getDeadlines().Transfer, func() error {
+		return restoreObjectVersion(d.BackupStoreDriver, filePath, versionID)
+	})
+}
+
+func (d *deadlineDriver) ExtendRetention(filePath string) error {
+	return d.run(DriverOperationWrite, filePath, d.getDeadlines().Metadata, func() error {
+		return extendRetention(d.BackupStoreDriver, filePath)
+	})
+}
+
+type deadlineReadCloser struct {
+	io.ReadCloser
+	timer *time.Timer
+	err   *ErrOperationTimeout
+
+	lock    sync.Mutex
+	expired bool
+}
+
+func (r *deadlineReadCloser) expire() {
+	r.lock.Lock()
+	r.expired = true
+	r.lock.Unlock()
+	log.Warnf("Closing the read of %v on backup target %v exceeding %v", r.err.Path, r.err.DestURL, r.err.Deadline)
+	r.ReadCloser.Close()
+}
+
+func (r *deadlineReadCloser) isExpired() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.expired
+}
+
+func (r *deadlineReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && r.isExpired() {
+		return n, r.err
+	}
+	return n, err
+}
+
+func (r *deadlineReadCloser) Close() error {
+	if !r.timer.Stop() && r.isExpired() {
+		return nil
+	}
+	return r.ReadCloser.Close()
+}
+
+// deadlineReadSeeker is the data of a write, which cannot be read once the write is abandoned. The reader at
+// is kept for the drivers reading the parts of the data in place.
+type deadlineReadSeeker struct {
+	rs  io.ReadSeeker
+	err *ErrOperationTimeout
+
+	lock    sync.Mutex
+	expired bool
+}
+
+type deadlineReadSeekerAt struct {
+	*deadlineReadSeeker
+	ra io.ReaderAt
+}
+
+// newDeadlineReadSeeker returns the guarded data and the function expiring it.
+func newDeadlineReadSeeker(rs io.ReadSeeker, err *ErrOperationTimeout) (io.ReadSeeker, func()) {
+	r := &deadlineReadSeeker{rs: rs, err: err}
+	if ra, ok := rs.(io.ReaderAt); ok {
+		return &deadlineReadSeekerAt{deadlineReadSeeker: r, ra: ra}, r.expire
+	}
+	return r, r.expire
+}
+
+// expire waits for the ongoing reads, and fails the later ones.
+func (r *deadlineReadSeeker) expire() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.expired = true
+}
+
+func (r *deadlineReadSeeker) Read(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.expired {
+		return 0, r.err
+	}
+	return r.rs.Read(p)
+}
+
+func (r *deadlineReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.expired {
+		return 0, r.err
+	}
+	return r.rs.Seek(offset, whence)
+}
+
+func (r *deadlineReadSeekerAt) ReadAt(p []byte, off int64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.expired {
+		return 0, r.err
+	}
+	return r.ra.ReadAt(p, off)
+}
@@ -0,0 +1,125 @@
+package backupstore
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hangingReadDriver returns the readers blocked until they are closed, like a hung connection.
+type hangingReadDriver struct {
+	*mockStoreDriver
+}
+
+func (d *hangingReadDriver) Read(src string) (io.ReadCloser, error) {
+	r, _ := io.Pipe()
+	return r, nil
+}
+
+// blockedWriteDriver blocks the writes until they are released, before or after reading the data.
+type blockedWriteDriver struct {
+	*mockStoreDriver
+	readFirst bool
+	release   chan struct{}
+	done      chan error
+}
+
+func (d *blockedWriteDriver) Write(dst string, rs io.ReadSeeker) error {
+	var data []byte
+	var err error
+	if d.readFirst {
+		data, err = io.ReadAll(rs)
+	}
+	<-d.release
+	if !d.readFirst {
+		data, err = io.ReadAll(rs)
+	}
+	if err == nil {
+		err = d.mockStoreDriver.Write(dst, bytes.NewReader(data))
+	}
+	d.done <- err
+	return err
+}
+
+func TestOperationDeadlines(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	driver, err := GetBackupStoreDriver(mockDriverURL)
+	assert.NoError(err)
+	assert.NoError(driver.Write("backupstore/volumes/volume.cfg", bytes.NewReader([]byte("config"))))
+	assert.True(driver.FileExists("backupstore/volumes/volume.cfg"))
+
+	// The per-call deadlines override the defaults
+	m.delay = 200 * time.Millisecond
+	short := WithOperationDeadlines(driver, OperationDeadlines{Metadata: 50 * time.Millisecond})
+	_, err = short.List("backupstore/volumes")
+	assert.True(IsOperationTimeoutError(err), "unexpected error %v", err)
+	assert.ErrorContains(err, "list of backupstore/volumes")
+	names, err := driver.List("backupstore/volumes")
+	assert.NoError(err)
+	assert.Equal([]string{"volume.cfg"}, names)
+
+	// The overrides are merged, the zero durations keep the previous override
+	merged := WithOperationDeadlines(short, OperationDeadlines{Transfer: time.Minute}).(*deadlineDriver)
+	assert.Equal(OperationDeadlines{Metadata: 50 * time.Millisecond, Transfer: time.Minute}, merged.getDeadlines())
+	disabled := WithOperationDeadlines(short, OperationDeadlines{Metadata: -1})
+	_, err = disabled.List("backupstore/volumes")
+	assert.NoError(err)
+	m.delay = 0
+
+	defer SetDefaultOperationDeadlines(OperationDeadlines{})
+	SetDefaultOperationDeadlines(OperationDeadlines{Transfer: 50 * time.Millisecond})
+	assert.Equal(OperationDeadlines{Metadata: DefaultMetadataDeadline, Transfer: 50 * time.Millisecond},
+		GetDefaultOperationDeadlines())
+
+	// The hung read is closed once the deadline is exceeded
+	hanging := &deadlineDriver{BackupStoreDriver: &hangingReadDriver{m}}
+	rc, err := hanging.Read("backupstore/volumes/volume.cfg")
+	assert.NoError(err)
+	_, err = io.ReadAll(rc)
+	assert.True(IsOperationTimeoutError(err), "unexpected error %v", err)
+	assert.NoError(rc.Close())
+
+	rc, err = driver.Read("backupstore/volumes/volume.cfg")
+	assert.NoError(err)
+	data, err := io.ReadAll(rc)
+	assert.NoError(err)
+	assert.NoError(rc.Close())
+	assert.Equal("config", string(data))
+}
+
+func TestWriteDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	// The abandoned write cannot read the data anymore
+	d := &blockedWriteDriver{mockStoreDriver: m, release: make(chan struct{}), done: make(chan error, 1)}
+	driver := WithOperationDeadlines(d, OperationDeadlines{Transfer: 20 * time.Millisecond})
+	configFile := "backupstore/volumes/volume.cfg"
+	err := driver.Write(configFile, bytes.NewReader([]byte("config")))
+	assert.True(IsOperationTimeoutError(err), "unexpected error %v", err)
+	close(d.release)
+	err = <-d.done
+	assert.True(IsOperationTimeoutError(err), "unexpected error %v", err)
+	assert.False(m.FileExists(configFile))
+
+	// The lock written after the deadline is removed
+	d = &blockedWriteDriver{mockStoreDriver: m, readFirst: true, release: make(chan struct{}), done: make(chan error, 1)}
+	driver = WithOperationDeadlines(d, OperationDeadlines{Transfer: 20 * time.Millisecond})
+	lockFile := getLockFilePath("pvc-1", "lock-1")
+	err = driver.Write(lockFile, bytes.NewReader([]byte("lock")))
+	assert.True(IsOperationTimeoutError(err), "unexpected error %v", err)
+	close(d.release)
+	assert.NoError(<-d.done)
+	assert.Eventually(func() bool { return !m.FileExists(lockFile) }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,142 @@
+package backupstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gammazero/workerpool"
+)
+
+const (
+	DEFAULT_DELETION_CONCURRENCY = 16
+)
+
+// DeleteVolumeOptions are the options of DeleteBackupVolumeWithOptions.
+type DeleteVolumeOptions struct {
+	// Concurrency is the max number of the objects removed at the same time, DEFAULT_DELETION_CONCURRENCY is
+	// used if it's not set
+	Concurrency int
+	// Progress is called with the number of the removed objects and the total number of the objects
+	Progress func(removed, total int)
+}
+
+// DeleteBackupVolumeWithOptions deletes the backup volume like DeleteBackupVolume, removing the backup configs
+// and then the blocks concurrently.
+func DeleteBackupVolumeWithOptions(volumeName string, destURL string, opts DeleteVolumeOptions) error {
+	return deleteBackupVolume(volumeName, destURL, &opts)
+}
+
+// getVolumeObjectPaths returns the paths of the backup configs followed by the blocks of the volume.
+func getVolumeObjectPaths(driver BackupStoreDriver, volumeName string) ([]string, error) {
+	backupNames, err := getBackupNamesForVolume(driver, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(backupNames))
+	for _, backupName := range backupNames {
+		paths = append(paths, getBackupConfigPath(backupName, volumeName))
+	}
+
+	blockFiles, err := getBlockFileManifest(driver, strings.TrimSuffix(getBlockPath(volumeName), "/"))
+	if err != nil {
+		return nil, err
+	}
+	blockPaths := make([]string, 0, len(blockFiles))
+	for blockFile := range blockFiles {
+		blockPaths = append(blockPaths, blockFile)
+	}
+	sort.Strings(blockPaths)
+	return append(paths, blockPaths...), nil
+}
+
+// removeObjects removes the objects with the bounded concurrency. The backup configs are all removed before the
+// blocks are, and the blocks are kept if any backup config cannot be removed, so no backup is left referring to
+// the removed blocks. Otherwise all the objects are tried even if some of them cannot be removed.
+func removeObjects(driver BackupStoreDriver, paths []string, opts *DeleteVolumeOptions) error {
+	configs, blocks := []string{}, []string{}
+	for _, path := range paths {
+		if strings.HasSuffix(path, BLK_SUFFIX) {
+			blocks = append(blocks, path)
+		} else {
+			configs = append(configs, path)
+		}
+	}
+	withProgress := func(offset int) *DeleteVolumeOptions {
+		passOpts := *opts
+		if opts.Progress != nil {
+			passOpts.Progress = func(removed, total int) { opts.Progress(offset+removed, len(paths)) }
+		}
+		return &passOpts
+	}
+
+	if failures := removeFiles(driver, configs, withProgress(0)); len(failures) > 0 {
+		return fmt.Errorf("failed to remove %v backup configs: %v, kept the %v blocks", len(failures),
+			getFailedNames(failures), len(blocks))
+	}
+	if failures := removeFiles(driver, blocks, withProgress(len(configs))); len(failures) > 0 {
+		return fmt.Errorf("failed to remove %v objects: %v", len(failures), getFailedNames(failures))
+	}
+	return nil
+}
+
+func getFailedNames(failures map[string]error) []string {
+	names := make([]string, 0, len(failures))
+	for path := range failures {
+		names = append(names, filepath.Base(path))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// removeFiles removes the files with the bounded concurrency, by batches if the driver supports it, and returns
+// the errors of the files which cannot be removed.
+func removeFiles(driver BackupStoreDriver, paths []string, opts *DeleteVolumeOptions) map[string]error {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DEFAULT_DELETION_CONCURRENCY
+	}
+
+	batches := splitBatches(paths, 1)
+	remove := func(batch []string) map[string]error {
+		return getRemoveFailures(batch, driver.Remove(batch[0]))
+	}
+	if batchRemover, ok := getBatchRemover(driver); ok {
+		batches = splitBatches(paths, batchRemoveSize)
+		remove = func(batch []string) map[string]error {
+			return getRemoveFailures(batch, batchRemover.RemoveAll(batch))
+		}
+	}
+
+	var (
+		removed  int64
+		failures = map[string]error{}
+		lock     sync.Mutex
+	)
+	jobQueues := workerpool.New(concurrency)
+	for _, batch := range batches {
+		batch := batch
+		jobQueues.Submit(func() {
+			batchFailures := remove(batch)
+			for path, err := range batchFailures {
+				log.WithError(err).Warnf("Failed to remove %v", path)
+			}
+			if len(batchFailures) > 0 {
+				lock.Lock()
+				for path, err := range batchFailures {
+					failures[path] = err
+				}
+				lock.Unlock()
+			}
+			count := atomic.AddInt64(&removed, int64(len(batch)-len(batchFailures)))
+			if opts.Progress != nil && len(batchFailures) < len(batch) {
+				opts.Progress(int(count), len(paths))
+			}
+		})
+	}
+	jobQueues.StopWait()
+	return failures
+}
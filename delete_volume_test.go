@@ -0,0 +1,99 @@
+package backupstore
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteBackupVolumeWithOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	volumeName := "volume"
+	err := saveVolume(m, &Volume{Name: volumeName, Size: DEFAULT_BLOCK_SIZE})
+	assert.NoError(err)
+	for _, backupName := range []string{"backup-1", "backup-2"} {
+		err = saveBackup(m, &Backup{Name: backupName, VolumeName: volumeName, CreatedTime: "2024-01-01T00:00:00Z"})
+		assert.NoError(err)
+	}
+	for _, checksum := range []string{
+		"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		"fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210",
+		"0123fedcba9876543210fedcba9876543210fedcba9876543210fedcba987654",
+	} {
+		err = m.Write(getBlockFilePath(volumeName, checksum), bytes.NewReader([]byte(checksum)))
+		assert.NoError(err)
+	}
+
+	paths, err := getVolumeObjectPaths(m, volumeName)
+	assert.NoError(err)
+	assert.Len(paths, 5)
+	// The backup configs are removed ahead of the blocks
+	assert.Equal(getBackupConfigPath("backup-1", volumeName), paths[0])
+	assert.Equal(getBackupConfigPath("backup-2", volumeName), paths[1])
+
+	var lock sync.Mutex
+	reported := []int{}
+	err = DeleteBackupVolumeWithOptions(volumeName, mockDriverURL, DeleteVolumeOptions{
+		Concurrency: 2,
+		Progress: func(removed, total int) {
+			lock.Lock()
+			defer lock.Unlock()
+			assert.Equal(5, total)
+			reported = append(reported, removed)
+		},
+	})
+	assert.NoError(err)
+	assert.ElementsMatch([]int{1, 2, 3, 4, 5}, reported)
+	assert.False(volumeExists(m, volumeName))
+	assert.False(m.FileExists(getVolumePath(volumeName)))
+}
+
+func TestRemoveObjectsKeepsBlocks(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	d := &batchMockDriver{mockStoreDriver: m, failed: map[string]bool{}}
+	assert.NoError(RegisterDriver(batchMockDriverName, func(destURL string) (BackupStoreDriver, error) { return d, nil }))
+	defer unregisterDriver(batchMockDriverName) // nolint:errcheck
+	driver, err := GetBackupStoreDriver(batchMockDriverName + "://localhost")
+	assert.NoError(err)
+
+	volumeName := "volume"
+	configPath := getBackupConfigPath("backup-1", volumeName)
+	blockPath := getBlockFilePath(volumeName, "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	for _, path := range []string{configPath, blockPath} {
+		assert.NoError(m.Write(path, bytes.NewReader([]byte("data"))))
+	}
+
+	// The blocks are kept if the backup config referring to them cannot be removed
+	d.failed[configPath] = true
+	err = removeObjects(driver, []string{configPath, blockPath}, &DeleteVolumeOptions{})
+	assert.ErrorContains(err, "failed to remove 1 backup configs")
+	assert.True(m.FileExists(configPath))
+	assert.True(m.FileExists(blockPath))
+
+	// The blocks are removed after the backup configs
+	delete(d.failed, configPath)
+	d.batches = nil
+	reported := []int{}
+	err = removeObjects(driver, []string{configPath, blockPath}, &DeleteVolumeOptions{
+		Progress: func(removed, total int) {
+			assert.Equal(2, total)
+			reported = append(reported, removed)
+		},
+	})
+	assert.NoError(err)
+	assert.Equal([][]string{{configPath}, {blockPath}}, d.batches)
+	assert.Equal([]int{1, 2}, reported)
+	assert.False(m.FileExists(blockPath))
+}
@@ -1,11 +1,15 @@
 package backupstore
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
@@ -28,6 +32,39 @@ type DeltaBackupConfig struct {
 	Labels          map[string]string
 	ConcurrentLimit int32
 	Parameters      map[string]string
+
+	// ReadCoalesceSize is the max size of a snapshot read coalescing the adjacent changed extents.
+	// DEFAULT_READ_COALESCE_SIZE is used if it's 0, and the snapshot is read block by block if it's
+	// smaller than the block size.
+	ReadCoalesceSize int64
+	// UseMmap enables reading the snapshot through a memory mapping, if DeltaOps implements SnapshotFileOperations
+	UseMmap bool
+	// CompressionWorkers is the number of the CPU-bound workers reading, hashing and compressing the blocks,
+	// GOMAXPROCS is used if it's not set
+	CompressionWorkers int32
+	// UploadWorkers is the number of the network-bound workers uploading the blocks, ConcurrentLimit or
+	// twice of GOMAXPROCS is used if it's not set
+	UploadWorkers int32
+	// InlineBlockLimit is the max number of the blocks embedded in the backup config. The new blocks of the
+	// backups changing no more blocks than the limit are embedded instead of uploaded one by one, 0 disables it.
+	InlineBlockLimit int
+	// MaxChainLength is the max number of the incremental backups since the last full backup. The backup
+	// exceeding it is promoted to a synthetic full backup, 0 means unlimited.
+	MaxChainLength int64
+	// OperationID identifies the backup in the log lines, the locks and the progress events, a new one is
+	// generated if it's not set
+	OperationID string
+
+	// Description and UserMetadata are stored in the backup, the user metadata is a JSON document of at most
+	// MAX_USER_METADATA_SIZE
+	Description  string
+	UserMetadata json.RawMessage
+	// SeedVolumeName is the volume in the same backup target whose blocks are reused by the first backup of
+	// the volume, e.g. the deleted volume which the volume is re-created from. The blocks found in the seed
+	// volume are copied inside the backup target instead of uploaded.
+	SeedVolumeName string
+
+	seedBlocks BlockManifest
 }
 
 type DeltaRestoreConfig struct {
@@ -36,6 +73,37 @@ type DeltaRestoreConfig struct {
 	LastBackupName  string
 	Filename        string
 	ConcurrentLimit int32
+
+	// MirrorURLs are the other backup targets holding the blocks of the backup, e.g. the replicated ones.
+	MirrorURLs []string
+	// TierWeights are the read costs of the backup targets by the URL. The blocks are read from the target
+	// of the lowest weight first, the targets not listed have the weight 0.
+	TierWeights map[string]int
+	// OperationID identifies the restore in the log lines, the locks and the progress events, and the status is
+	// persisted in RestoreStatusDirectory if it's set
+	OperationID string
+
+	// TargetSize is the size of the restored volume, which can be larger than the size of the backup volume to
+	// restore and expand the volume in one step. It's the size of the backup volume for a regular file and
+	// the size of the device for a block device if it's not set.
+	TargetSize int64
+	// ZeroRemainder zeroes the part of the device beyond the size of the backup volume. The expanded part of
+	// a regular file is always zero.
+	ZeroRemainder bool
+
+	// ThawRetryInterval is the interval of checking the archived blocks being restored by the backup target,
+	// DefaultThawRetryInterval if it's not set.
+	ThawRetryInterval time.Duration
+	// ThawTimeout is the max wait for an archived block to be restored before the restore fails,
+	// DefaultThawTimeout if it's not set. A negative timeout fails the restore on the first archived block.
+	ThawTimeout time.Duration
+
+	// DownloadWorkers is the number of the network-bound workers downloading the blocks, ConcurrentLimit or
+	// twice of GOMAXPROCS is used if it's not set
+	DownloadWorkers int32
+	// DecompressionWorkers is the number of the downloaded blocks decompressed and verified at once by the
+	// CPU-bound part of the restore, GOMAXPROCS is used if it's not set
+	DecompressionWorkers int32
 }
 
 type BlockMapping struct {
@@ -48,6 +116,7 @@ type Block struct {
 	blockChecksum     string
 	compressionMethod string
 	isZeroBlock       bool
+	inlineData        []byte
 }
 
 type BlockInfo struct {
@@ -90,6 +159,63 @@ func (r backupRequest) getBackupType() string {
 	return "full"
 }
 
+// findLastBackup returns the last backup of the volume the incremental backup is based on, or nil if a full
+// backup is required.
+func findLastBackup(bsDriver BackupStoreDriver, config *DeltaBackupConfig, volume *Volume) *Backup {
+	if volume.LastBackupName == "" || isFullBackup(config) {
+		return nil
+	}
+	if volume.RequireFullBackup {
+		log.WithFields(logrus.Fields{
+			LogFieldReason: LogReasonFallback,
+			LogFieldEvent:  LogEventBackup,
+			LogFieldVolume: volume.Name,
+		}).Info("Creating full backup since the backup chain is quarantined")
+		return nil
+	}
+
+	snapshot := config.Snapshot
+	destURL := config.DestURL
+	deltaOps := config.DeltaOps
+	log := logrus.WithFields(logrus.Fields{
+		"volume":   volume,
+		"snapshot": snapshot,
+		"destURL":  destURL,
+	})
+
+	lastBackupName := volume.LastBackupName
+	var backup, err = loadBackup(bsDriver, lastBackupName, volume.Name)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			LogFieldReason:  LogReasonFallback,
+			LogFieldEvent:   LogEventBackup,
+			LogFieldObject:  LogObjectBackup,
+			LogFieldBackup:  lastBackupName,
+			LogFieldVolume:  volume.Name,
+			LogFieldDestURL: destURL,
+		}).WithError(err).Info("Cannot find previous backup in backupstore")
+	} else if backup.SnapshotName == snapshot.Name {
+		// Generate full snapshot if the snapshot has been backed up last time
+		log.WithFields(logrus.Fields{
+			LogFieldReason:   LogReasonFallback,
+			LogFieldEvent:    LogEventCompare,
+			LogFieldObject:   LogObjectSnapshot,
+			LogFieldSnapshot: backup.SnapshotName,
+			LogFieldVolume:   volume.Name,
+		}).Info("Creating full snapshot config")
+	} else if backup.SnapshotName != "" && !deltaOps.HasSnapshot(backup.SnapshotName, volume.Name) {
+		log.WithFields(logrus.Fields{
+			LogFieldReason:   LogReasonFallback,
+			LogFieldObject:   LogObjectSnapshot,
+			LogFieldSnapshot: backup.SnapshotName,
+			LogFieldVolume:   volume.Name,
+		}).Info("Cannot find last snapshot in local storage")
+	} else {
+		return backup
+	}
+	return nil
+}
+
 type progress struct {
 	sync.Mutex
 
@@ -130,11 +256,21 @@ func CreateDeltaBlockBackup(backupName string, config *DeltaBackupConfig) (isInc
 	if deltaOps == nil {
 		return false, fmt.Errorf("BUG: missing DeltaBlockBackupOperations")
 	}
+	if err := validateUserMetadata(config.UserMetadata); err != nil {
+		return false, err
+	}
+	if err := util.ValidateSectorSizes(volume.LogicalSectorSize, volume.PhysicalSectorSize); err != nil {
+		return false, err
+	}
+
+	config.OperationID = getOperationID(config.OperationID)
+	setOperationID(deltaOps, config.OperationID)
 
 	log := logrus.WithFields(logrus.Fields{
-		"volume":   volume,
-		"snapshot": snapshot,
-		"destURL":  destURL,
+		"volume":            volume,
+		"snapshot":          snapshot,
+		"destURL":           destURL,
+		LogFieldOperationID: config.OperationID,
 	})
 
 	defer func() {
@@ -155,6 +291,7 @@ func CreateDeltaBlockBackup(backupName string, config *DeltaBackupConfig) (isInc
 	if err != nil {
 		return false, err
 	}
+	lock.OperationID = config.OperationID
 
 	defer func() {
 		if unlockErr := lock.Unlock(); unlockErr != nil {
@@ -165,6 +302,10 @@ func CreateDeltaBlockBackup(backupName string, config *DeltaBackupConfig) (isInc
 		return false, err
 	}
 
+	if err := CheckTargetRedirect(bsDriver); err != nil {
+		return false, err
+	}
+
 	if err := addVolume(bsDriver, volume); err != nil {
 		return false, err
 	}
@@ -177,45 +318,20 @@ func CreateDeltaBlockBackup(backupName string, config *DeltaBackupConfig) (isInc
 
 	config.Volume.CompressionMethod = volume.CompressionMethod
 	config.Volume.DataEngine = volume.DataEngine
+	if _, err := util.GetCompressor(volume.CompressionMethod); err != nil {
+		return false, err
+	}
 
-	if err := deltaOps.OpenSnapshot(snapshot.Name, volume.Name); err != nil {
+	if err := loadSeedBlocks(bsDriver, config, volume); err != nil {
 		return false, err
 	}
 
-	backupRequest := &backupRequest{}
-	if volume.LastBackupName != "" && !isFullBackup(config) {
-		lastBackupName := volume.LastBackupName
-		var backup, err = loadBackup(bsDriver, lastBackupName, volume.Name)
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				LogFieldReason:  LogReasonFallback,
-				LogFieldEvent:   LogEventBackup,
-				LogFieldObject:  LogObjectBackup,
-				LogFieldBackup:  lastBackupName,
-				LogFieldVolume:  volume.Name,
-				LogFieldDestURL: destURL,
-			}).WithError(err).Info("Cannot find previous backup in backupstore")
-		} else if backup.SnapshotName == snapshot.Name {
-			// Generate full snapshot if the snapshot has been backed up last time
-			log.WithFields(logrus.Fields{
-				LogFieldReason:   LogReasonFallback,
-				LogFieldEvent:    LogEventCompare,
-				LogFieldObject:   LogObjectSnapshot,
-				LogFieldSnapshot: backup.SnapshotName,
-				LogFieldVolume:   volume.Name,
-			}).Info("Creating full snapshot config")
-		} else if backup.SnapshotName != "" && !deltaOps.HasSnapshot(backup.SnapshotName, volume.Name) {
-			log.WithFields(logrus.Fields{
-				LogFieldReason:   LogReasonFallback,
-				LogFieldObject:   LogObjectSnapshot,
-				LogFieldSnapshot: backup.SnapshotName,
-				LogFieldVolume:   volume.Name,
-			}).Info("Cannot find last snapshot in local storage")
-		} else {
-			backupRequest.lastBackup = backup
-		}
+	if err := deltaOps.OpenSnapshot(snapshot.Name, volume.Name); err != nil {
+		return false, err
 	}
 
+	backupRequest := &backupRequest{lastBackup: findLastBackup(bsDriver, config, volume)}
+
 	log.WithFields(logrus.Fields{
 		LogFieldReason:       LogReasonStart,
 		LogFieldObject:       LogObjectSnapshot,
@@ -266,6 +382,7 @@ func CreateDeltaBlockBackup(backupName string, config *DeltaBackupConfig) (isInc
 
 	log = logrus.WithFields(logrus.Fields{
 		"compressionMethod": volume.CompressionMethod,
+		LogFieldOperationID: config.OperationID,
 	})
 
 	// keep lock alive for async go routine.
@@ -291,6 +408,10 @@ func CreateDeltaBlockBackup(backupName string, config *DeltaBackupConfig) (isInc
 			logrus.WithError(updateErr).Error("Failed to update backup status")
 		}
 
+		log.Info("Waiting for a backup slot of the backup target")
+		releaseBackupSlot := acquireBackupSlot(bsDriver)
+		defer releaseBackupSlot()
+
 		log.Info("Performing delta block backup")
 
 		if progress, backup, err := performBackup(bsDriver, config, delta, deltaBackup, backupRequest.lastBackup); err != nil {
@@ -315,7 +436,7 @@ func populateMappings(bsDriver BackupStoreDriver, config *DeltaBackupConfig, del
 		defer close(mappingChan)
 		defer close(errChan)
 
-		for _, mapping := range delta.Mappings {
+		for _, mapping := range coalesceMappings(delta.Mappings, delta.BlockSize, getReadCoalesceSize(config)) {
 			mappingChan <- mapping
 		}
 	}()
@@ -323,6 +444,48 @@ func populateMappings(bsDriver BackupStoreDriver, config *DeltaBackupConfig, del
 	return mappingChan, errChan
 }
 
+func getReadCoalesceSize(config *DeltaBackupConfig) int64 {
+	if config.ReadCoalesceSize == 0 {
+		return DEFAULT_READ_COALESCE_SIZE
+	}
+	return config.ReadCoalesceSize
+}
+
+// coalesceMappings merges the adjacent mappings and splits the large ones, so each mapping is read from the
+// snapshot in a sequential read of at most maxSize, aligned to the block size.
+func coalesceMappings(mappings []types.Mapping, blockSize, maxSize int64) []types.Mapping {
+	maxSize = maxSize / blockSize * blockSize
+	if maxSize < blockSize {
+		maxSize = blockSize
+	}
+
+	sorted := append([]types.Mapping{}, mappings...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Offset < sorted[j].Offset
+	})
+
+	result := []types.Mapping{}
+	for _, mapping := range sorted {
+		for mapping.Size > 0 {
+			if n := len(result); n > 0 {
+				last := &result[n-1]
+				if last.Offset+last.Size == mapping.Offset && last.Size < maxSize {
+					size := min(maxSize-last.Size, mapping.Size)
+					last.Size += size
+					mapping.Offset += size
+					mapping.Size -= size
+					continue
+				}
+			}
+			size := min(maxSize, mapping.Size)
+			result = append(result, types.Mapping{Offset: mapping.Offset, Size: size})
+			mapping.Offset += size
+			mapping.Size -= size
+		}
+	}
+	return result
+}
+
 func getProgress(total, processed int64) int {
 	return int((float64(processed+1) / float64(total)) * PROGRESS_PERCENTAGE_BACKUP_SNAPSHOT)
 }
@@ -373,13 +536,22 @@ func updateBlocksAndProgress(deltaBackup *Backup, progress *progress, checksum s
 	delete(processingBlocks.blocks, checksum)
 }
 
-func backupBlock(bsDriver BackupStoreDriver, config *DeltaBackupConfig,
-	deltaBackup *Backup, offset int64, block []byte, progress *progress) error {
-	var err error
-	newBlock := false
+// blockUpload is a compressed block waiting for upload by the network-bound workers.
+type blockUpload struct {
+	checksum string
+	blkFile  string
+	rs       io.ReadSeeker
+	reUpload bool
+	// seedFile is the block file of the seed volume copied instead of uploading the data
+	seedFile string
+}
+
+// prepareBlock hashes and compresses the block in the CPU-bound workers. It returns nil if the block doesn't
+// need to be uploaded. The block sliced from the mapped snapshot is only valid until the view returns, so it's
+// copied if the uploaded data refers to it.
+func prepareBlock(bsDriver BackupStoreDriver, config *DeltaBackupConfig,
+	deltaBackup *Backup, offset int64, block []byte, mapped bool, progress *progress) (*blockUpload, error) {
 	volume := config.Volume
-	snapshot := config.Snapshot
-	deltaOps := config.DeltaOps
 
 	checksum := util.GetChecksum(block)
 
@@ -387,53 +559,138 @@ func backupBlock(bsDriver BackupStoreDriver, config *DeltaBackupConfig,
 	// with the same checksum but different offsets).
 	// After uploading, `bsDriver.FileExists(blkFile)` is used to avoid repeat uploading.
 	if isBlockBeingProcessed(deltaBackup, offset, checksum) {
-		return nil
+		return nil, nil
 	}
 
-	defer func() {
-		if err != nil {
-			return
-		}
-		deltaBackup.Lock()
-		defer deltaBackup.Unlock()
-		updateBlocksAndProgress(deltaBackup, progress, checksum, newBlock)
-		if updateErr := deltaOps.UpdateBackupStatus(snapshot.Name, volume.Name, string(types.ProgressStateInProgress), progress.progress, "", ""); updateErr != nil {
-			logrus.WithError(updateErr).Warn("Failed to update backup status")
-		}
-	}()
-
-	blkFile := getBlockFilePath(volume.Name, checksum)
+	blkFile := findBlockFilePath(bsDriver, volume.Name, checksum)
 	reUpload := false
 	if bsDriver.FileExists(blkFile) {
-		if !isFullBackup(config) {
+		if !isFullBackup(config) || IsImmutableTarget(bsDriver) {
 			log.Debugf("Found existing block matching at %v", blkFile)
-			return nil
+			completeBlock(config, deltaBackup, progress, checksum, false)
+			return nil, nil
 		}
 		log.Debugf("Reupload existing block matching at %v", blkFile)
 		reUpload = true
 	}
+	if _, exists := config.seedBlocks[checksum]; exists && !reUpload {
+		return &blockUpload{
+			checksum: checksum,
+			blkFile:  blkFile,
+			seedFile: findBlockFilePath(bsDriver, config.SeedVolumeName, checksum),
+		}, nil
+	}
 
-	log.Tracef("Uploading block file at %v", blkFile)
-	newBlock = !reUpload
+	if mapped && deltaBackup.CompressionMethod == "none" {
+		block = bytes.Clone(block)
+	}
 	rs, err := util.CompressData(deltaBackup.CompressionMethod, block)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &blockUpload{
+		checksum: checksum,
+		blkFile:  blkFile,
+		rs:       rs,
+		reUpload: reUpload,
+	}, nil
+}
+
+// uploadBlock uploads the compressed block in the network-bound workers.
+func uploadBlock(bsDriver BackupStoreDriver, config *DeltaBackupConfig,
+	deltaBackup *Backup, upload *blockUpload, progress *progress) error {
+	log.Tracef("Uploading block file at %v", upload.blkFile)
+
+	if upload.seedFile != "" {
+		releaseUploadSlot := acquireUploadSlot(bsDriver)
+		throttleBackupUpload(bsDriver, 0)
+		err := copyBlockFile(bsDriver, upload.seedFile, upload.blkFile, deltaBackup.CompressionMethod)
+		releaseUploadSlot()
+		if err != nil {
+			return errors.Wrapf(err, "failed to copy seed block %v", upload.seedFile)
+		}
+		completeBlock(config, deltaBackup, progress, upload.checksum, true)
+		return nil
 	}
 
-	dataSize, err := getTransferDataSize(rs)
+	dataSize, err := getTransferDataSize(upload.rs)
 	if err != nil {
 		return errors.Wrapf(err, "failed to get transfer data size during saving blocks")
 	}
 
-	if err := bsDriver.Write(blkFile, rs); err != nil {
-		return errors.Wrapf(err, "failed to write data during saving blocks")
+	inlined, err := inlineBlock(deltaBackup, upload)
+	if err != nil {
+		return errors.Wrapf(err, "failed to embed block %v", upload.checksum)
+	}
+	if inlined {
+		updateUploadDataSize(upload.reUpload, deltaBackup, dataSize)
+		// The embedded block is not a block file of the volume
+		completeBlock(config, deltaBackup, progress, upload.checksum, false)
+		return nil
 	}
 
-	updateUploadDataSize(reUpload, deltaBackup, dataSize)
+	releaseUploadSlot := acquireUploadSlot(bsDriver)
+	throttleBackupUpload(bsDriver, dataSize)
+	err = writeBlockFile(bsDriver, upload.blkFile, upload.rs, deltaBackup.CompressionMethod, upload.checksum)
+	releaseUploadSlot()
+	if err != nil {
+		return errors.Wrapf(err, "failed to write data during saving blocks")
+	}
 
+	updateUploadDataSize(upload.reUpload, deltaBackup, dataSize)
+	completeBlock(config, deltaBackup, progress, upload.checksum, !upload.reUpload)
 	return nil
 }
 
+// inlineBlock embeds the compressed block in the backup if the backup is small enough.
+func inlineBlock(deltaBackup *Backup, upload *blockUpload) (bool, error) {
+	deltaBackup.Lock()
+	defer deltaBackup.Unlock()
+
+	if deltaBackup.InlineBlocks == nil {
+		return false, nil
+	}
+	data, err := io.ReadAll(upload.rs)
+	if err != nil {
+		return false, err
+	}
+	deltaBackup.InlineBlocks[upload.checksum] = data
+	return true, nil
+}
+
+// materializeInlineBlocks writes the embedded blocks carried over from the last backup into the block files,
+// once there are more of them than the limit. It returns the number of the new block files.
+func materializeInlineBlocks(bsDriver BackupStoreDriver, config *DeltaBackupConfig, backup *Backup) (int64, error) {
+	if len(backup.InlineBlocks) <= config.InlineBlockLimit {
+		return 0, nil
+	}
+
+	newBlocks := int64(0)
+	for checksum, data := range backup.InlineBlocks {
+		blkFile := findBlockFilePath(bsDriver, backup.VolumeName, checksum)
+		if !bsDriver.FileExists(blkFile) {
+			if err := writeBlockFile(bsDriver, blkFile, bytes.NewReader(data), backup.CompressionMethod, checksum); err != nil {
+				return newBlocks, errors.Wrapf(err, "failed to write embedded block %v", checksum)
+			}
+			newBlocks++
+		}
+	}
+	log.Infof("Wrote %v embedded blocks of backup %v into block files", len(backup.InlineBlocks), backup.Name)
+	backup.InlineBlocks = nil
+	return newBlocks, nil
+}
+
+// completeBlock adds the block and the ones of the same checksum to the backup, and updates the progress.
+func completeBlock(config *DeltaBackupConfig, deltaBackup *Backup, progress *progress, checksum string, newBlock bool) {
+	deltaBackup.Lock()
+	defer deltaBackup.Unlock()
+
+	updateBlocksAndProgress(deltaBackup, progress, checksum, newBlock)
+	if updateErr := config.DeltaOps.UpdateBackupStatus(config.Snapshot.Name, config.Volume.Name, string(types.ProgressStateInProgress), progress.progress, "", ""); updateErr != nil {
+		logrus.WithError(updateErr).Warn("Failed to update backup status")
+	}
+}
+
 func getTransferDataSize(rs io.ReadSeeker) (int64, error) {
 	size, err := rs.Seek(0, io.SeekEnd)
 	if err != nil {
@@ -459,39 +716,60 @@ func updateUploadDataSize(reUpload bool, deltaBackup *Backup, dataSize int64) {
 	}
 }
 
-func backupMapping(bsDriver BackupStoreDriver, config *DeltaBackupConfig,
-	deltaBackup *Backup, blockSize int64, mapping types.Mapping, progress *progress) error {
+func backupMapping(ctx context.Context, bsDriver BackupStoreDriver, config *DeltaBackupConfig, mappedSnap *mappedSnapshot,
+	deltaBackup *Backup, blockSize int64, mapping types.Mapping, progress *progress, uploads chan<- *blockUpload) error {
 	volume := config.Volume
 	snapshot := config.Snapshot
 	deltaOps := config.DeltaOps
 
-	block := make([]byte, DEFAULT_BLOCK_SIZE)
 	blkCounts := mapping.Size / blockSize
+	backupBlocks := func(data []byte, mapped bool) error {
+		for i := int64(0); i < blkCounts; i++ {
+			log.Tracef("Backup for %v: segment %+v, blocks %v/%v", snapshot.Name, mapping, i+1, blkCounts)
+			offset := mapping.Offset + i*blockSize
+			block := data[i*blockSize : (i+1)*blockSize]
 
-	for i := int64(0); i < blkCounts; i++ {
-		log.Tracef("Backup for %v: segment %+v, blocks %v/%v", snapshot.Name, mapping, i+1, blkCounts)
-		offset := mapping.Offset + i*blockSize
-		if err := deltaOps.ReadSnapshot(snapshot.Name, volume.Name, offset, block); err != nil {
-			logrus.WithError(err).Errorf("Failed to read volume %v snapshot %v block at offset %v size %v",
-				volume.Name, snapshot.Name, offset, len(block))
-			return err
+			upload, err := prepareBlock(bsDriver, config, deltaBackup, offset, block, mapped, progress)
+			if err != nil {
+				logrus.WithError(err).Errorf("Failed to back up volume %v snapshot %v block at offset %v size %v",
+					volume.Name, snapshot.Name, offset, len(block))
+				return err
+			}
+			if upload == nil {
+				continue
+			}
+			select {
+			case uploads <- upload:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
+		return nil
+	}
 
-		if err := backupBlock(bsDriver, config, deltaBackup, offset, block, progress); err != nil {
-			logrus.WithError(err).Errorf("Failed to back up volume %v snapshot %v block at offset %v size %v",
-				volume.Name, snapshot.Name, offset, len(block))
-			return err
-		}
+	// The blocks are sliced from the mapped snapshot file directly if possible
+	if mapped, err := mappedSnap.view(mapping.Offset, mapping.Size, func(data []byte) error {
+		return backupBlocks(data, true)
+	}); mapped {
+		return err
 	}
 
-	return nil
+	// The coalesced mapping is read in one sequential read, then sliced into blocks
+	data := make([]byte, mapping.Size)
+	if err := deltaOps.ReadSnapshot(snapshot.Name, volume.Name, mapping.Offset, data); err != nil {
+		logrus.WithError(err).Errorf("Failed to read volume %v snapshot %v at offset %v size %v",
+			volume.Name, snapshot.Name, mapping.Offset, len(data))
+		return err
+	}
+	return backupBlocks(data, false)
 }
 
-func backupMappings(ctx context.Context, bsDriver BackupStoreDriver, config *DeltaBackupConfig,
-	deltaBackup *Backup, blockSize int64, progress *progress, in <-chan types.Mapping) <-chan error {
+func backupMappings(ctx context.Context, bsDriver BackupStoreDriver, config *DeltaBackupConfig, mappedSnap *mappedSnapshot,
+	deltaBackup *Backup, blockSize int64, progress *progress, in <-chan types.Mapping, uploads chan<- *blockUpload, wg *sync.WaitGroup) <-chan error {
 	errChan := make(chan error, 1)
 
 	go func() {
+		defer wg.Done()
 		defer close(errChan)
 		for {
 			select {
@@ -502,7 +780,7 @@ func backupMappings(ctx context.Context, bsDriver BackupStoreDriver, config *Del
 					return
 				}
 
-				if err := backupMapping(bsDriver, config, deltaBackup, blockSize, mapping, progress); err != nil {
+				if err := backupMapping(ctx, bsDriver, config, mappedSnap, deltaBackup, blockSize, mapping, progress, uploads); err != nil {
 					errChan <- err
 					return
 				}
@@ -513,6 +791,87 @@ func backupMappings(ctx context.Context, bsDriver BackupStoreDriver, config *Del
 	return errChan
 }
 
+func uploadBlocks(ctx context.Context, bsDriver BackupStoreDriver, config *DeltaBackupConfig,
+	deltaBackup *Backup, progress *progress, in <-chan *blockUpload, wg *sync.WaitGroup) <-chan error {
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer wg.Done()
+		defer close(errChan)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case upload, open := <-in:
+				if !open {
+					return
+				}
+
+				if err := uploadBlock(bsDriver, config, deltaBackup, upload, progress); err != nil {
+					logrus.WithError(err).Errorf("Failed to upload volume %v snapshot %v block %v",
+						config.Volume.Name, config.Snapshot.Name, upload.blkFile)
+					errChan <- err
+					return
+				}
+			}
+		}
+	}()
+
+	return errChan
+}
+
+// getCompressionWorkers returns the number of the CPU-bound workers reading, hashing and compressing the blocks.
+func getCompressionWorkers(config *DeltaBackupConfig) int {
+	if config.CompressionWorkers > 0 {
+		return int(config.CompressionWorkers)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// getUploadWorkers returns the number of the network-bound workers uploading the blocks. ConcurrentLimit is
+// used if it's not set, for the backward compatibility.
+func getUploadWorkers(config *DeltaBackupConfig) int {
+	if config.UploadWorkers > 0 {
+		return int(config.UploadWorkers)
+	}
+	if config.ConcurrentLimit > 0 {
+		return int(config.ConcurrentLimit)
+	}
+	return 2 * runtime.GOMAXPROCS(0)
+}
+
+// getDownloadWorkers returns the number of the network-bound workers downloading the blocks. ConcurrentLimit
+// is used if it's not set, for the backward compatibility.
+func getDownloadWorkers(config *DeltaRestoreConfig) int {
+	if config.DownloadWorkers > 0 {
+		return int(config.DownloadWorkers)
+	}
+	if config.ConcurrentLimit > 0 {
+		return int(config.ConcurrentLimit)
+	}
+	return 2 * runtime.GOMAXPROCS(0)
+}
+
+// decompressionSlots bounds the downloaded blocks of a restore decompressed and verified at once, so the
+// CPU-bound part doesn't scale with the download workers. The nil slots are unbounded.
+type decompressionSlots chan struct{}
+
+func newDecompressionSlots(config *DeltaRestoreConfig) decompressionSlots {
+	workers := runtime.GOMAXPROCS(0)
+	if config.DecompressionWorkers > 0 {
+		workers = int(config.DecompressionWorkers)
+	}
+	return make(decompressionSlots, workers)
+}
+
+func (s decompressionSlots) acquire() func() {
+	if s == nil {
+		return func() {}
+	}
+	s <- struct{}{}
+	return func() { <-s }
+}
+
 func getTotalBackupBlockCounts(delta *types.Mappings) (int64, error) {
 	totalBlockCounts := int64(0)
 	for _, d := range delta.Mappings {
@@ -547,10 +906,10 @@ func sortBackupBlocks(blocks []BlockMapping, volumeSize, blockSize int64) []Bloc
 
 // performBackup if lastBackup is present we will do an incremental backup
 func performBackup(bsDriver BackupStoreDriver, config *DeltaBackupConfig, delta *types.Mappings, deltaBackup *Backup, lastBackup *Backup) (int, string, error) {
+	log := log.WithField(LogFieldOperationID, config.OperationID)
 	volume := config.Volume
 	snapshot := config.Snapshot
 	destURL := config.DestURL
-	concurrentLimit := config.ConcurrentLimit
 
 	// create an in progress backup config file
 	if err := saveBackup(bsDriver, &Backup{
@@ -576,16 +935,50 @@ func performBackup(bsDriver BackupStoreDriver, config *DeltaBackupConfig, delta
 		totalBlockCounts: totalBlockCounts,
 	}
 
+	if config.InlineBlockLimit > 0 && totalBlockCounts <= int64(config.InlineBlockLimit) {
+		log.Infof("Embedding the new blocks of the small backup of volume %v snapshot %v", volume.Name, snapshot.Name)
+		deltaBackup.InlineBlocks = map[string][]byte{}
+	}
+
+	mappedSnap, err := openMappedSnapshot(config)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() {
+		if closeErr := mappedSnap.Close(); closeErr != nil {
+			logrus.WithError(closeErr).Warnf("Failed to unmap volume %v snapshot %v", volume.Name, snapshot.Name)
+		}
+	}()
+
 	mappingChan, errChan := populateMappings(bsDriver, config, deltaBackup, delta)
 
+	uploadWorkers := getUploadWorkers(config)
+	uploadChan := make(chan *blockUpload, uploadWorkers)
+	compressionWG := &sync.WaitGroup{}
+	uploadWG := &sync.WaitGroup{}
+
 	errorChans := []<-chan error{errChan}
-	for i := 0; i < int(concurrentLimit); i++ {
-		errorChans = append(errorChans, backupMappings(ctx, bsDriver, config,
-			deltaBackup, delta.BlockSize, progress, mappingChan))
+	for i := 0; i < getCompressionWorkers(config); i++ {
+		compressionWG.Add(1)
+		errorChans = append(errorChans, backupMappings(ctx, bsDriver, config, mappedSnap,
+			deltaBackup, delta.BlockSize, progress, mappingChan, uploadChan, compressionWG))
+	}
+	// The uploads complete once all the blocks are compressed
+	go func() {
+		compressionWG.Wait()
+		close(uploadChan)
+	}()
+	for i := 0; i < uploadWorkers; i++ {
+		uploadWG.Add(1)
+		errorChans = append(errorChans, uploadBlocks(ctx, bsDriver, config, deltaBackup, progress, uploadChan, uploadWG))
 	}
 
 	mergedErrChan := mergeErrorChannels(ctx, errorChans...)
 	err = <-mergedErrChan
+	// The workers still running after the first error are stopped before the snapshot is unmapped
+	cancel()
+	compressionWG.Wait()
+	uploadWG.Wait()
 
 	if err != nil {
 		logrus.WithError(err).Errorf("Failed to backup volume %v snapshot %v", volume.Name, snapshot.Name)
@@ -603,19 +996,44 @@ func performBackup(bsDriver BackupStoreDriver, config *DeltaBackupConfig, delta
 	deltaBackup.Blocks = sortBackupBlocks(deltaBackup.Blocks, volume.Size, delta.BlockSize)
 
 	backup := mergeSnapshotMap(deltaBackup, lastBackup)
+	materialized, err := materializeInlineBlocks(bsDriver, config, backup)
+	if err != nil {
+		return progress.progress, "", err
+	}
+	progress.newBlockCounts += materialized
+	if len(backup.InlineBlocks) == 0 {
+		backup.InlineBlocks = nil
+	}
+	if err := extendBlockRetention(bsDriver, backup, uploadWorkers); err != nil {
+		return progress.progress, "", err
+	}
 	backup.SnapshotName = snapshot.Name
-	backup.SnapshotCreatedAt = snapshot.CreatedTime
+	backup.SnapshotCreatedAt = util.NormalizeTimestamp(snapshot.CreatedTime)
 	backup.CreatedTime = util.Now()
 	backup.Size = int64(len(backup.Blocks)) * DEFAULT_BLOCK_SIZE
 	backup.Labels = config.Labels
 	backup.Parameters = config.Parameters
+	backup.Description = config.Description
+	backup.UserMetadata = config.UserMetadata
+	backup.LogicalSectorSize = config.Volume.LogicalSectorSize
+	backup.PhysicalSectorSize = config.Volume.PhysicalSectorSize
+	backup.SourceClusterID = GetClientID()
+	backup.SourceNodeID = GetNodeID()
 	backup.IsIncremental = lastBackup != nil
+	if lastBackup != nil {
+		backup.ChainLength = lastBackup.ChainLength + 1
+	}
+	if config.MaxChainLength > 0 && backup.ChainLength > config.MaxChainLength {
+		log.Infof("Promoting backup %v to synthetic full backup since the chain length exceeds %v", backup.Name, config.MaxChainLength)
+		promoteToSyntheticFull(backup)
+	}
 	backup.NewlyUploadedDataSize = deltaBackup.NewlyUploadedDataSize
 	backup.ReUploadedDataSize = deltaBackup.ReUploadedDataSize
 
 	if err := saveBackup(bsDriver, backup); err != nil {
 		return progress.progress, "", err
 	}
+	recordBackupHistory(bsDriver, volume.Name, backup.Name, HistoryEventCreated)
 
 	volume, err = loadVolume(bsDriver, volume.Name)
 	if err != nil {
@@ -625,6 +1043,9 @@ func performBackup(bsDriver BackupStoreDriver, config *DeltaBackupConfig, delta
 	volume.LastBackupName = backup.Name
 	volume.LastBackupAt = backup.SnapshotCreatedAt
 	volume.BlockCount = volume.BlockCount + progress.newBlockCounts
+	if !backup.IsIncremental {
+		volume.RequireFullBackup = false
+	}
 	// The volume may be expanded
 	volume.Size = config.Volume.Size
 	volume.Labels = config.Labels
@@ -633,6 +1054,9 @@ func performBackup(bsDriver BackupStoreDriver, config *DeltaBackupConfig, delta
 	volume.CompressionMethod = config.Volume.CompressionMethod
 	volume.StorageClassName = config.Volume.StorageClassName
 	volume.DataEngine = config.Volume.DataEngine
+	volume.LogicalSectorSize = config.Volume.LogicalSectorSize
+	volume.PhysicalSectorSize = config.Volume.PhysicalSectorSize
+	recordVolumeWriter(volume, GetClientID())
 
 	if err := saveVolume(bsDriver, volume); err != nil {
 		return progress.progress, "", err
@@ -682,6 +1106,22 @@ func mergeSnapshotMap(deltaBackup, lastBackup *Backup) *Backup {
 		backup.Blocks = append(backup.Blocks, deltaBackup.Blocks[d:]...)
 	}
 
+	// The embedded blocks still referenced are carried over from the last backup
+	if len(deltaBackup.InlineBlocks) > 0 || len(lastBackup.InlineBlocks) > 0 {
+		backup.InlineBlocks = map[string][]byte{}
+		for checksum, data := range deltaBackup.InlineBlocks {
+			backup.InlineBlocks[checksum] = data
+		}
+		for _, block := range backup.Blocks {
+			if _, exists := backup.InlineBlocks[block.BlockChecksum]; exists {
+				continue
+			}
+			if data, exists := lastBackup.InlineBlocks[block.BlockChecksum]; exists {
+				backup.InlineBlocks[block.BlockChecksum] = data
+			}
+		}
+	}
+
 	return backup
 }
 
@@ -693,7 +1133,6 @@ func RestoreDeltaBlockBackup(ctx context.Context, config *DeltaRestoreConfig) er
 
 	volDevName := config.Filename
 	backupURL := config.BackupURL
-	concurrentLimit := config.ConcurrentLimit
 	deltaOps := config.DeltaOps
 	if deltaOps == nil {
 		return fmt.Errorf("missing DeltaRestoreOperations")
@@ -709,10 +1148,15 @@ func RestoreDeltaBlockBackup(ctx context.Context, config *DeltaRestoreConfig) er
 		return err
 	}
 
+	operationID := getOperationID(config.OperationID)
+	setOperationID(deltaOps, operationID)
+	log := log.WithField(LogFieldOperationID, operationID)
+
 	lock, err := New(bsDriver, srcVolumeName, RESTORE_LOCK)
 	if err != nil {
 		return err
 	}
+	lock.OperationID = operationID
 
 	defer func() {
 		if unlockErr := lock.Unlock(); unlockErr != nil {
@@ -752,11 +1196,24 @@ func RestoreDeltaBlockBackup(ctx context.Context, config *DeltaRestoreConfig) er
 		return err
 	}
 
-	backup, err := loadBackup(bsDriver, srcBackupName, srcVolumeName)
+	targetSize, err := getRestoreTargetSize(config, vol, volDev, stat)
 	if err != nil {
 		return err
 	}
 
+	if err = checkBackupNotQuarantined(vol, srcBackupName); err != nil {
+		return err
+	}
+	backup, err := loadVerifiedBackup(bsDriver, srcBackupName, srcVolumeName)
+	if err != nil {
+		return err
+	}
+	if err = checkRestoreSectorSize(backup, volDev, stat); err != nil {
+		return err
+	}
+
+	sources := getBlockSources(bsDriver, config)
+
 	log.WithFields(logrus.Fields{
 		LogFieldReason:     LogReasonStart,
 		LogFieldEvent:      LogEventRestore,
@@ -767,8 +1224,19 @@ func RestoreDeltaBlockBackup(ctx context.Context, config *DeltaRestoreConfig) er
 		LogEventBackupURL:  backupURL,
 	}).Info("Restoring delta block backup")
 
+	ctx, op, err := registerRestore(ctx, volDevName, volDevPath, stat.Mode().IsRegular())
+	if err != nil {
+		return err
+	}
+	if config, err = recordRestoreStatus(config); err != nil {
+		op.finish(volDevName)
+		return err
+	}
+	deltaOps = config.DeltaOps
+
 	// keep lock alive for async go routine.
 	if err := lock.Lock(); err != nil {
+		op.finish(volDevName)
 		return err
 	}
 
@@ -785,6 +1253,7 @@ func RestoreDeltaBlockBackup(ctx context.Context, config *DeltaRestoreConfig) er
 			if unlockErr := lock.Unlock(); unlockErr != nil {
 				logrus.WithError(unlockErr).Warn("Failed to unlock")
 			}
+			op.finish(volDevName)
 		}()
 
 		progress := &progress{
@@ -802,17 +1271,39 @@ func RestoreDeltaBlockBackup(ctx context.Context, config *DeltaRestoreConfig) er
 			if err != nil {
 				return
 			}
+			if targetSize > vol.Size {
+				log.Infof("Expand %v to size %v", volDevName, targetSize)
+				if err = volDev.Truncate(targetSize); err != nil {
+					return
+				}
+			}
 		}
 
-		blockChan, errChan := populateBlocksForFullRestore(bsDriver, backup)
+		restoreCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		blockChan, errChan := populateBlocksForFullRestore(restoreCtx, bsDriver, backup)
 
+		slots := newDecompressionSlots(config)
+		restoreWG := &sync.WaitGroup{}
 		errorChans := []<-chan error{errChan}
-		for i := 0; i < int(concurrentLimit); i++ {
-			errorChans = append(errorChans, restoreBlocks(ctx, bsDriver, config.DeltaOps, volDevPath, srcVolumeName, blockChan, progress))
+		for i := 0; i < getDownloadWorkers(config); i++ {
+			restoreWG.Add(1)
+			errorChans = append(errorChans, restoreBlocks(restoreCtx, sources, getThawWait(config), slots, config.DeltaOps, volDevPath, srcVolumeName, blockChan, progress, restoreWG))
 		}
 
 		mergedErrChan := mergeErrorChannels(ctx, errorChans...)
 		err = <-mergedErrChan
+		// The workers still running after the first error or the abort are stopped before the volume device is
+		// closed and the restore is finished, so no block is written once the aborted restore is truncated
+		cancel()
+		restoreWG.Wait()
+		if err == nil {
+			err = getRestoreAbortError(ctx, srcVolumeName)
+		}
+		if err == nil && config.ZeroRemainder && !stat.Mode().IsRegular() {
+			log.Infof("Zero %v from %v to %v", volDevName, vol.Size, targetSize)
+			err = zeroDeviceRange(volDev, vol.Size, targetSize)
+		}
 		if err != nil {
 			currentProgress = progress.progress
 			logrus.WithError(err).Errorf("Failed to delta restore volume %v backup %v", srcVolumeName, backup.Name)
@@ -824,9 +1315,72 @@ func RestoreDeltaBlockBackup(ctx context.Context, config *DeltaRestoreConfig) er
 	return nil
 }
 
-func restoreBlockToFile(bsDriver BackupStoreDriver, volumeName string, volDev *os.File, decompression string, blk BlockMapping) error {
-	blkFile := getBlockFilePath(volumeName, blk.BlockChecksum)
-	r, err := DecompressAndVerifyWithFallback(bsDriver, blkFile, decompression, blk.BlockChecksum)
+// getRestoreTargetSize returns the size of the restored volume, which must hold the whole backup volume.
+func getRestoreTargetSize(config *DeltaRestoreConfig, vol *Volume, volDev *os.File, stat os.FileInfo) (int64, error) {
+	if config.TargetSize != 0 && config.TargetSize < vol.Size {
+		return 0, fmt.Errorf("target size %v is smaller than the size %v of backup volume %v", config.TargetSize, vol.Size, vol.Name)
+	}
+	if stat.Mode().IsRegular() {
+		return max(config.TargetSize, vol.Size), nil
+	}
+
+	devSize, err := volDev.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get the size of volume device %v", volDev.Name())
+	}
+	if devSize < vol.Size {
+		return 0, fmt.Errorf("volume device %v of size %v is smaller than the size %v of backup volume %v", volDev.Name(), devSize, vol.Size, vol.Name)
+	}
+	if config.TargetSize > devSize {
+		return 0, fmt.Errorf("target size %v is larger than the size %v of volume device %v", config.TargetSize, devSize, volDev.Name())
+	}
+	if config.TargetSize == 0 {
+		return devSize, nil
+	}
+	return config.TargetSize, nil
+}
+
+// checkRestoreSectorSize checks the block device has the logical sector size of the backup volume, since the
+// file systems of the volume depend on it. The regular files have no sector size.
+func checkRestoreSectorSize(backup *Backup, volDev *os.File, stat os.FileInfo) error {
+	if stat.Mode().IsRegular() || backup.LogicalSectorSize == 0 {
+		return nil
+	}
+	logical, _, err := util.GetDeviceSectorSizes(volDev)
+	if err != nil {
+		log.WithError(err).Warnf("Skipped checking the sector size of volume device %v", volDev.Name())
+		return nil
+	}
+	if logical != backup.LogicalSectorSize {
+		return fmt.Errorf("volume device %v of logical sector size %v cannot be restored from backup %v of logical sector size %v",
+			volDev.Name(), logical, backup.Name, backup.LogicalSectorSize)
+	}
+	return nil
+}
+
+// zeroDeviceRange writes zeroes to the device from the start offset to the end offset.
+func zeroDeviceRange(volDev *os.File, start, end int64) error {
+	zeroes := make([]byte, DEFAULT_BLOCK_SIZE)
+	for offset := start; offset < end; offset += DEFAULT_BLOCK_SIZE {
+		size := min(end-offset, DEFAULT_BLOCK_SIZE)
+		if _, err := volDev.WriteAt(zeroes[:size], offset); err != nil {
+			return errors.Wrapf(err, "failed to zero volume device %v at offset %v", volDev.Name(), offset)
+		}
+	}
+	return nil
+}
+
+// restoreBlockToFile downloads the block, then decompresses and verifies it in one of the slots.
+func restoreBlockToFile(bsDriver BackupStoreDriver, slots decompressionSlots, volumeName string, volDev *os.File, decompression string, blk BlockMapping) error {
+	blkFile := findBlockFilePath(bsDriver, volumeName, blk.BlockChecksum)
+	data, err := readBlockData(getRestoreDriver(bsDriver), blkFile)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decompress and verify block %v with checksum %v", blkFile, blk.BlockChecksum)
+	}
+
+	release := slots.acquire()
+	r, err := decompressAndVerifyDataWithFallback(data, blkFile, decompression, blk.BlockChecksum)
+	release()
 	if err != nil {
 		return errors.Wrapf(err, "failed to decompress and verify block %v with checksum %v", blkFile, blk.BlockChecksum)
 	}
@@ -838,6 +1392,19 @@ func restoreBlockToFile(bsDriver BackupStoreDriver, volumeName string, volDev *o
 	return errors.Wrapf(err, "failed to write decompressed block %v to volume %v", blkFile, volumeName)
 }
 
+func restoreInlineBlockToFile(volumeName string, volDev *os.File, block *Block) error {
+	r, err := util.DecompressAndVerify(block.compressionMethod, bytes.NewReader(block.inlineData), block.blockChecksum)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decompress and verify embedded block with checksum %v", block.blockChecksum)
+	}
+
+	if _, err := volDev.Seek(block.offset, 0); err != nil {
+		return errors.Wrapf(err, "failed to seek to offset %v for embedded block %v", block.offset, block.blockChecksum)
+	}
+	_, err = io.CopyN(volDev, r, DEFAULT_BLOCK_SIZE)
+	return errors.Wrapf(err, "failed to write embedded block %v to volume %v", block.blockChecksum, volumeName)
+}
+
 func RestoreDeltaBlockBackupIncrementally(ctx context.Context, config *DeltaRestoreConfig) error {
 	if config == nil {
 		return fmt.Errorf("invalid empty config for restore")
@@ -861,10 +1428,15 @@ func RestoreDeltaBlockBackupIncrementally(ctx context.Context, config *DeltaRest
 		return err
 	}
 
+	operationID := getOperationID(config.OperationID)
+	setOperationID(deltaOps, operationID)
+	log := log.WithField(LogFieldOperationID, operationID)
+
 	lock, err := New(bsDriver, srcVolumeName, RESTORE_LOCK)
 	if err != nil {
 		return err
 	}
+	lock.OperationID = operationID
 
 	if err := lock.Lock(); err != nil {
 		return err
@@ -918,14 +1490,20 @@ func RestoreDeltaBlockBackupIncrementally(ctx context.Context, config *DeltaRest
 		return err
 	}
 
-	lastBackup, err := loadBackup(bsDriver, lastBackupName, srcVolumeName)
+	if err = checkBackupNotQuarantined(vol, srcBackupName); err != nil {
+		return err
+	}
+	lastBackup, err := loadVerifiedBackup(bsDriver, lastBackupName, srcVolumeName)
 	if err != nil {
 		return err
 	}
-	backup, err := loadBackup(bsDriver, srcBackupName, srcVolumeName)
+	backup, err := loadVerifiedBackup(bsDriver, srcBackupName, srcVolumeName)
 	if err != nil {
 		return err
 	}
+	if err = checkRestoreSectorSize(backup, volDev, stat); err != nil {
+		return err
+	}
 
 	log.WithFields(logrus.Fields{
 		LogFieldReason:     LogReasonStart,
@@ -936,8 +1514,19 @@ func RestoreDeltaBlockBackupIncrementally(ctx context.Context, config *DeltaRest
 		LogFieldVolumeDev:  volDevName,
 		LogEventBackupURL:  backupURL,
 	}).Infof("Started incrementally restoring from %v to %v", lastBackup, backup)
+	ctx, op, err := registerRestore(ctx, volDevName, volDevPath, stat.Mode().IsRegular())
+	if err != nil {
+		return err
+	}
+	if config, err = recordRestoreStatus(config); err != nil {
+		op.finish(volDevName)
+		return err
+	}
+	deltaOps = config.DeltaOps
+
 	// keep lock alive for async go routine.
 	if err := lock.Lock(); err != nil {
+		op.finish(volDevName)
 		return err
 	}
 	go func() {
@@ -954,6 +1543,7 @@ func RestoreDeltaBlockBackupIncrementally(ctx context.Context, config *DeltaRest
 			if unlockErr := lock.Unlock(); unlockErr != nil {
 				logrus.WithError(unlockErr).Warn("Failed to unlock")
 			}
+			op.finish(volDevName)
 		}()
 
 		// This pre-truncate is to ensure the XFS speculatively
@@ -979,7 +1569,7 @@ func RestoreDeltaBlockBackupIncrementally(ctx context.Context, config *DeltaRest
 	return nil
 }
 
-func populateBlocksForIncrementalRestore(bsDriver BackupStoreDriver, lastBackup, backup *Backup) (<-chan *Block, <-chan error) {
+func populateBlocksForIncrementalRestore(ctx context.Context, bsDriver BackupStoreDriver, lastBackup, backup *Backup) (<-chan *Block, <-chan error) {
 	blockChan := make(chan *Block, 10)
 	errChan := make(chan error, 1)
 
@@ -987,58 +1577,66 @@ func populateBlocksForIncrementalRestore(bsDriver BackupStoreDriver, lastBackup,
 		defer close(blockChan)
 		defer close(errChan)
 
-		for b, l := 0, 0; b < len(backup.Blocks) || l < len(lastBackup.Blocks); {
-			if b >= len(backup.Blocks) {
-				blockChan <- &Block{
-					offset:      lastBackup.Blocks[l].Offset,
-					isZeroBlock: true,
-				}
-				l++
-				continue
-			}
-			if l >= len(lastBackup.Blocks) {
-				blockChan <- &Block{
-					offset:            backup.Blocks[b].Offset,
-					blockChecksum:     backup.Blocks[b].BlockChecksum,
-					compressionMethod: backup.CompressionMethod,
-				}
-				b++
-				continue
-			}
-
-			bB := backup.Blocks[b]
-			lB := lastBackup.Blocks[l]
-			if bB.Offset == lB.Offset {
-				if bB.BlockChecksum != lB.BlockChecksum {
-					blockChan <- &Block{
-						offset:            bB.Offset,
-						blockChecksum:     bB.BlockChecksum,
-						compressionMethod: backup.CompressionMethod,
-					}
-				}
-				b++
-				l++
-			} else if bB.Offset < lB.Offset {
-				blockChan <- &Block{
-					offset:            bB.Offset,
-					blockChecksum:     bB.BlockChecksum,
-					compressionMethod: backup.CompressionMethod,
-				}
-				b++
-			} else {
-				blockChan <- &Block{
-					offset:      lB.Offset,
-					isZeroBlock: true,
-				}
-				l++
+		diffBackupBlocks(lastBackup, backup, func(block *Block) {
+			select {
+			case blockChan <- block:
+			case <-ctx.Done():
 			}
-		}
+		})
 	}()
 
 	return blockChan, errChan
 }
 
-func populateBlocksForFullRestore(bsDriver BackupStoreDriver, backup *Backup) (<-chan *Block, <-chan error) {
+// diffBackupBlocks calls fn with each block changed from the last backup to the backup, the blocks absent in
+// the backup are zero blocks.
+func diffBackupBlocks(lastBackup, backup *Backup, fn func(block *Block)) {
+	newBlock := func(blk BlockMapping) *Block {
+		return &Block{
+			offset:            blk.Offset,
+			blockChecksum:     blk.BlockChecksum,
+			compressionMethod: backup.CompressionMethod,
+			inlineData:        backup.InlineBlocks[blk.BlockChecksum],
+		}
+	}
+
+	for b, l := 0, 0; b < len(backup.Blocks) || l < len(lastBackup.Blocks); {
+		if b >= len(backup.Blocks) {
+			fn(&Block{
+				offset:      lastBackup.Blocks[l].Offset,
+				isZeroBlock: true,
+			})
+			l++
+			continue
+		}
+		if l >= len(lastBackup.Blocks) {
+			fn(newBlock(backup.Blocks[b]))
+			b++
+			continue
+		}
+
+		bB := backup.Blocks[b]
+		lB := lastBackup.Blocks[l]
+		if bB.Offset == lB.Offset {
+			if bB.BlockChecksum != lB.BlockChecksum {
+				fn(newBlock(bB))
+			}
+			b++
+			l++
+		} else if bB.Offset < lB.Offset {
+			fn(newBlock(bB))
+			b++
+		} else {
+			fn(&Block{
+				offset:      lB.Offset,
+				isZeroBlock: true,
+			})
+			l++
+		}
+	}
+}
+
+func populateBlocksForFullRestore(ctx context.Context, bsDriver BackupStoreDriver, backup *Backup) (<-chan *Block, <-chan error) {
 	blockChan := make(chan *Block, 10)
 	errChan := make(chan error, 1)
 
@@ -1047,10 +1645,15 @@ func populateBlocksForFullRestore(bsDriver BackupStoreDriver, backup *Backup) (<
 		defer close(errChan)
 
 		for _, block := range backup.Blocks {
-			blockChan <- &Block{
+			select {
+			case blockChan <- &Block{
 				offset:            block.Offset,
 				blockChecksum:     block.BlockChecksum,
 				compressionMethod: backup.CompressionMethod,
+				inlineData:        backup.InlineBlocks[block.BlockChecksum],
+			}:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -1058,7 +1661,7 @@ func populateBlocksForFullRestore(bsDriver BackupStoreDriver, backup *Backup) (<
 	return blockChan, errChan
 }
 
-func restoreBlock(bsDriver BackupStoreDriver, deltaOps DeltaRestoreOperations, volumeName string, volDev *os.File, block *Block, progress *progress) error {
+func restoreBlock(ctx context.Context, sources blockSources, wait thawWait, slots decompressionSlots, deltaOps DeltaRestoreOperations, volumeName string, volDev *os.File, block *Block, progress *progress) error {
 	defer func() {
 		progress.Lock()
 		defer progress.Unlock()
@@ -1072,18 +1675,23 @@ func restoreBlock(bsDriver BackupStoreDriver, deltaOps DeltaRestoreOperations, v
 		return fillZeros(volDev, block.offset, DEFAULT_BLOCK_SIZE)
 	}
 
-	return restoreBlockToFile(bsDriver, volumeName, volDev, block.compressionMethod,
+	if block.inlineData != nil {
+		return restoreInlineBlockToFile(volumeName, volDev, block)
+	}
+
+	return sources.restoreThawedBlockToFile(ctx, wait, slots, volumeName, volDev, block.compressionMethod,
 		BlockMapping{
 			Offset:        block.offset,
 			BlockChecksum: block.blockChecksum,
 		})
 }
 
-func restoreBlocks(ctx context.Context, bsDriver BackupStoreDriver, deltaOps DeltaRestoreOperations, volDevPath, volumeName string, in <-chan *Block, progress *progress) <-chan error {
+func restoreBlocks(ctx context.Context, sources blockSources, wait thawWait, slots decompressionSlots, deltaOps DeltaRestoreOperations, volDevPath, volumeName string, in <-chan *Block, progress *progress, wg *sync.WaitGroup) <-chan error {
 	errChan := make(chan error, 1)
 
 	go func() {
 		var err error
+		defer wg.Done()
 		defer close(errChan)
 
 		volDev, err := os.OpenFile(volDevPath, os.O_RDWR, 0666)
@@ -1101,7 +1709,9 @@ func restoreBlocks(ctx context.Context, bsDriver BackupStoreDriver, deltaOps Del
 		for {
 			select {
 			case <-ctx.Done():
-				err = fmt.Errorf(types.ErrorMsgRestoreCancelled+" since server stop for volume %v", volumeName)
+				if err = getRestoreAbortError(ctx, volumeName); err == nil {
+					err = fmt.Errorf(types.ErrorMsgRestoreCancelled+" since server stop for volume %v", volumeName)
+				}
 				return
 			case <-deltaOps.GetStopChan():
 				err = fmt.Errorf(types.ErrorMsgRestoreCancelled+" since received stop signal for volume %v", volumeName)
@@ -1111,7 +1721,7 @@ func restoreBlocks(ctx context.Context, bsDriver BackupStoreDriver, deltaOps Del
 					return
 				}
 
-				err = restoreBlock(bsDriver, deltaOps, volumeName, volDev, block, progress)
+				err = restoreBlock(ctx, sources, wait, slots, deltaOps, volumeName, volDev, block, progress)
 				if err != nil {
 					return
 				}
@@ -1125,21 +1735,32 @@ func restoreBlocks(ctx context.Context, bsDriver BackupStoreDriver, deltaOps Del
 func performIncrementalRestore(ctx context.Context, bsDriver BackupStoreDriver, config *DeltaRestoreConfig,
 	srcVolumeName, volDevPath string, lastBackup *Backup, backup *Backup) error {
 	var err error
-	concurrentLimit := config.ConcurrentLimit
 
 	progress := &progress{
 		totalBlockCounts: int64(len(backup.Blocks) + len(lastBackup.Blocks)),
 	}
 
-	blockChan, errChan := populateBlocksForIncrementalRestore(bsDriver, lastBackup, backup)
+	restoreCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	blockChan, errChan := populateBlocksForIncrementalRestore(restoreCtx, bsDriver, lastBackup, backup)
+	sources := getBlockSources(bsDriver, config)
 
+	slots := newDecompressionSlots(config)
+	restoreWG := &sync.WaitGroup{}
 	errorChans := []<-chan error{errChan}
-	for i := 0; i < int(concurrentLimit); i++ {
-		errorChans = append(errorChans, restoreBlocks(ctx, bsDriver, config.DeltaOps, volDevPath, srcVolumeName, blockChan, progress))
+	for i := 0; i < getDownloadWorkers(config); i++ {
+		restoreWG.Add(1)
+		errorChans = append(errorChans, restoreBlocks(restoreCtx, sources, getThawWait(config), slots, config.DeltaOps, volDevPath, srcVolumeName, blockChan, progress, restoreWG))
 	}
 
 	mergedErrChan := mergeErrorChannels(ctx, errorChans...)
 	err = <-mergedErrChan
+	// The workers still running after the first error or the abort are stopped before the restore is finished
+	cancel()
+	restoreWG.Wait()
+	if err == nil {
+		err = getRestoreAbortError(ctx, srcVolumeName)
+	}
 	if err != nil {
 		logrus.WithError(err).Errorf("Failed to incrementally restore volume %v backup %v", srcVolumeName, backup.Name)
 	}
@@ -1152,11 +1773,19 @@ func fillZeros(volDev *os.File, offset, length int64) error {
 }
 
 func DeleteBackupVolume(volumeName string, destURL string) error {
+	return deleteBackupVolume(volumeName, destURL, &DeleteVolumeOptions{})
+}
+
+func deleteBackupVolume(volumeName string, destURL string, opts *DeleteVolumeOptions) error {
 	bsDriver, err := GetBackupStoreDriver(destURL)
 	if err != nil {
 		return err
 	}
 
+	if err := CheckTargetMutable(bsDriver, "delete backup volume "+volumeName); err != nil {
+		return err
+	}
+
 	backupVolumeFolderExists, err := volumeFolderExists(bsDriver, volumeName)
 	if err != nil {
 		return err
@@ -1171,6 +1800,7 @@ func DeleteBackupVolume(volumeName string, destURL string) error {
 	if err != nil {
 		return err
 	}
+	lock.OperationID = NewOperationID()
 
 	if err := lock.Lock(); err != nil {
 		return err
@@ -1180,11 +1810,33 @@ func DeleteBackupVolume(volumeName string, destURL string) error {
 			logrus.WithError(unlockErr).Warn("Failed to unlock")
 		}
 	}()
-	return removeVolume(volumeName, bsDriver)
+
+	paths, err := getVolumeObjectPaths(bsDriver, volumeName)
+	if err != nil {
+		return err
+	}
+	log.Infof("Removing %v backups and blocks of volume %v", len(paths), volumeName)
+	if err := removeObjects(bsDriver, paths, opts); err != nil {
+		return errors.Wrapf(err, "failed to remove backups and blocks of volume %v", volumeName)
+	}
+	if _, ok := getBlockLinker(bsDriver); ok {
+		if v, err := loadVolume(bsDriver, volumeName); err == nil {
+			cleanupLinkedBlocks(bsDriver, v.CompressionMethod, getBlockChecksums(paths))
+		}
+	}
+	if err := removeVolume(volumeName, bsDriver); err != nil {
+		return err
+	}
+	removeBackupHistory(bsDriver, volumeName)
+	return nil
 }
 
 func checkBlockReferenceCount(blockInfos map[string]*BlockInfo, backup *Backup, volumeName string, driver BackupStoreDriver) {
 	for _, block := range backup.Blocks {
+		// The embedded blocks are removed along with the backup config
+		if _, inline := backup.InlineBlocks[block.BlockChecksum]; inline {
+			continue
+		}
 		info, known := blockInfos[block.BlockChecksum]
 		if !known {
 			log.Errorf("Backup %v refers to unknown block %v", backup.Name, block.BlockChecksum)
@@ -1204,12 +1856,12 @@ func getLatestBackup(backup *Backup, lastBackup *Backup) error {
 		return nil
 	}
 
-	backupTime, err := time.Parse(time.RFC3339, backup.SnapshotCreatedAt)
+	backupTime, err := util.ParseTimestamp(backup.SnapshotCreatedAt)
 	if err != nil {
 		return errors.Wrapf(err, "cannot parse backup %v time %v", backup.Name, backup.SnapshotCreatedAt)
 	}
 
-	lastBackupTime, err := time.Parse(time.RFC3339, lastBackup.SnapshotCreatedAt)
+	lastBackupTime, err := util.ParseTimestamp(lastBackup.SnapshotCreatedAt)
 	if err != nil {
 		return errors.Wrapf(err, "cannot parse last backup %v time %v", lastBackup.Name, lastBackup.SnapshotCreatedAt)
 	}
@@ -1232,15 +1884,22 @@ func DeleteDeltaBlockBackup(backupURL string) error {
 	if err != nil {
 		return err
 	}
+	operationID := NewOperationID()
 	log := log.WithFields(logrus.Fields{
-		"backup": backupName,
-		"volume": volumeName,
+		"backup":            backupName,
+		"volume":            volumeName,
+		LogFieldOperationID: operationID,
 	})
 
+	if err := CheckTargetMutable(bsDriver, "delete backup "+backupName); err != nil {
+		return err
+	}
+
 	lock, err := New(bsDriver, volumeName, DELETION_LOCK)
 	if err != nil {
 		return err
 	}
+	lock.OperationID = operationID
 	if err := lock.Lock(); err != nil {
 		return err
 	}
@@ -1264,6 +1923,7 @@ func DeleteDeltaBlockBackup(backupURL string) error {
 	if err := removeBackup(backupToBeDeleted, bsDriver); err != nil {
 		return err
 	}
+	recordBackupHistory(bsDriver, volumeName, backupName, HistoryEventDeleted)
 	log.Info("Removed backup for volume")
 
 	v, err := loadVolume(bsDriver, volumeName)
@@ -1286,14 +1946,14 @@ func DeleteDeltaBlockBackup(backupURL string) error {
 	}
 
 	blockInfos := make(map[string]*BlockInfo)
-	blockNames, err := getBlockNamesForVolume(bsDriver, volumeName)
+	blockFiles, err := getBlockFilesForVolume(bsDriver, volumeName)
 	if err != nil {
 		return err
 	}
-	for _, name := range blockNames {
+	for name, path := range blockFiles {
 		blockInfos[name] = &BlockInfo{
 			checksum: name,
-			path:     getBlockFilePath(volumeName, name),
+			path:     path,
 			refcount: 0,
 		}
 	}
@@ -1328,14 +1988,14 @@ func DeleteDeltaBlockBackup(backupURL string) error {
 			}
 		}
 	}
-	if updateLastBackup {
-		if deleteBlocks {
-			v.LastBackupName = lastBackup.Name
-			v.LastBackupAt = lastBackup.SnapshotCreatedAt
-		}
-		if err := saveVolume(bsDriver, v); err != nil {
-			return err
-		}
+	if updateLastBackup && deleteBlocks {
+		v.LastBackupName = lastBackup.Name
+		v.LastBackupAt = lastBackup.SnapshotCreatedAt
+	}
+	delete(v.QuarantinedBackups, backupName)
+	// The volume config is always saved, so the modification is picked up by the incremental listings
+	if err := saveVolume(bsDriver, v); err != nil {
+		return err
 	}
 
 	// check if there have been new backups created while we where processing
@@ -1357,33 +2017,43 @@ func DeleteDeltaBlockBackup(backupURL string) error {
 
 func cleanupBlocks(driver BackupStoreDriver, blockMap map[string]*BlockInfo, volume string) error {
 	var deletionFailures []string
+	var deletedBlocks []string
+	var deletedPaths []string
 	activeBlockCount := int64(0)
-	deletedBlockCount := int64(0)
 	for _, blk := range blockMap {
 		if isBlockSafeToDelete(blk) {
-			if err := driver.Remove(blk.path); err != nil {
-				deletionFailures = append(deletionFailures, blk.checksum)
-				continue
-			}
-			log.Debugf("Deleted block %v for volume %v", blk.checksum, volume)
-			deletedBlockCount++
+			deletedPaths = append(deletedPaths, blk.path)
 		} else if isBlockReferenced(blk) && isBlockPresent(blk) {
 			activeBlockCount++
 		}
 	}
 
+	failures := removeFiles(driver, deletedPaths, &DeleteVolumeOptions{})
+	for _, blk := range blockMap {
+		if !isBlockSafeToDelete(blk) {
+			continue
+		}
+		if _, failed := failures[blk.path]; failed {
+			deletionFailures = append(deletionFailures, blk.checksum)
+			continue
+		}
+		log.Debugf("Deleted block %v for volume %v", blk.checksum, volume)
+		deletedBlocks = append(deletedBlocks, blk.checksum)
+	}
+
 	if len(deletionFailures) > 0 {
 		return fmt.Errorf("failed to delete backup blocks: %v", deletionFailures)
 	}
 
 	log.Infof("Retained %v blocks for volume %v", activeBlockCount, volume)
-	log.Infof("Removed %v unused blocks for volume %v", deletedBlockCount, volume)
+	log.Infof("Removed %v unused blocks for volume %v", len(deletedBlocks), volume)
 	log.Info("GC completed")
 
 	v, err := loadVolume(driver, volume)
 	if err != nil {
 		return err
 	}
+	cleanupLinkedBlocks(driver, v.CompressionMethod, deletedBlocks)
 
 	// update the block count to what we actually have on disk that is in use
 	v.BlockCount = activeBlockCount
@@ -1391,30 +2061,32 @@ func cleanupBlocks(driver BackupStoreDriver, blockMap map[string]*BlockInfo, vol
 }
 
 func getBlockNamesForVolume(driver BackupStoreDriver, volumeName string) ([]string, error) {
-	names := []string{}
-	blockPathBase := getBlockPath(volumeName)
-	lv1Dirs, err := driver.List(blockPathBase)
-	// Directory doesn't exist
+	files, err := getBlockFilesForVolume(driver, volumeName)
 	if err != nil {
-		return names, nil
+		return nil, err
 	}
-	for _, lv1 := range lv1Dirs {
-		lv1Path := filepath.Join(blockPathBase, lv1)
-		lv2Dirs, err := driver.List(lv1Path)
-		if err != nil {
-			return nil, err
-		}
-		for _, lv2 := range lv2Dirs {
-			lv2Path := filepath.Join(lv1Path, lv2)
-			blockNames, err := driver.List(lv2Path)
-			if err != nil {
-				return nil, err
-			}
-			names = append(names, blockNames...)
-		}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
 	}
+	return names, nil
+}
 
-	return util.ExtractNames(names, "", BLK_SUFFIX), nil
+// getBlockFilesForVolume returns the paths of the block files of the volume by the checksums, the blocks written
+// by the legacy layout are listed at their paths.
+func getBlockFilesForVolume(driver BackupStoreDriver, volumeName string) (map[string]string, error) {
+	files, err := listBlockFiles(driver, getBlockPath(volumeName))
+	if err != nil {
+		return nil, err
+	}
+	layout := GetPathLayout()
+	paths := make(map[string]string, len(files))
+	for _, file := range files {
+		if name, ok := layout.ParseBlockFileName(filepath.Base(file)); ok {
+			paths[name] = file
+		}
+	}
+	return paths, nil
 }
 
 func isFullBackup(config *DeltaBackupConfig) bool {
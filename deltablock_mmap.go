@@ -0,0 +1,95 @@
+package backupstore
+
+import (
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotFileOperations is optionally implemented by the DeltaBlockBackupOperations backed by local files.
+// The snapshot file is memory mapped for reading if DeltaBackupConfig.UseMmap is set, so the block data is
+// not copied through the userspace buffers.
+type SnapshotFileOperations interface {
+	GetSnapshotFilePath(id, volumeID string) (string, error)
+}
+
+// mappedSnapshot is a read only memory mapping of a snapshot file. The reads beyond the end of the file are
+// not served, since accessing the mapping there causes SIGBUS.
+type mappedSnapshot struct {
+	sync.RWMutex
+	data []byte
+}
+
+// openMappedSnapshot maps the snapshot file if it's enabled for the backup and supported by the DeltaOps,
+// otherwise nil is returned and the snapshot is read by DeltaOps.ReadSnapshot.
+func openMappedSnapshot(config *DeltaBackupConfig) (*mappedSnapshot, error) {
+	if !config.UseMmap {
+		return nil, nil
+	}
+	fileOps, ok := config.DeltaOps.(SnapshotFileOperations)
+	if !ok {
+		log.Warnf("DeltaOps of volume %v doesn't support mmap reads, falling back to the regular reads", config.Volume.Name)
+		return nil, nil
+	}
+
+	filePath, err := fileOps.GetSnapshotFilePath(config.Snapshot.Name, config.Volume.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get file of snapshot %v", config.Snapshot.Name)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	// The mapping stays valid after the file is closed
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		return &mappedSnapshot{}, nil
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map snapshot file %v", filePath)
+	}
+	if err := syscall.Madvise(data, syscall.MADV_SEQUENTIAL); err != nil {
+		log.WithError(err).Debugf("Failed to advise sequential reads of snapshot file %v", filePath)
+	}
+	return &mappedSnapshot{data: data}, nil
+}
+
+// view calls the function with the mapped data of the range, and returns false if the range is not mapped.
+// The data must not be used after the function returns.
+func (m *mappedSnapshot) view(offset, size int64, fn func(data []byte) error) (bool, error) {
+	if m == nil {
+		return false, nil
+	}
+	m.RLock()
+	defer m.RUnlock()
+
+	if offset < 0 || offset+size > int64(len(m.data)) {
+		return false, nil
+	}
+	return true, fn(m.data[offset : offset+size])
+}
+
+// Close unmaps the snapshot file after the ongoing views complete.
+func (m *mappedSnapshot) Close() error {
+	if m == nil {
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	if m.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(m.data)
+	m.data = nil
+	return err
+}
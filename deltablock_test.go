@@ -0,0 +1,348 @@
+package backupstore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+func TestCoalesceMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	blockSize := int64(DEFAULT_BLOCK_SIZE)
+	mappings := []types.Mapping{
+		{Offset: 3 * blockSize, Size: blockSize},
+		{Offset: 0, Size: blockSize},
+		{Offset: blockSize, Size: blockSize},
+		{Offset: 2 * blockSize, Size: blockSize},
+		{Offset: 10 * blockSize, Size: 5 * blockSize},
+	}
+
+	assert.Equal([]types.Mapping{
+		{Offset: 0, Size: 3 * blockSize},
+		{Offset: 3 * blockSize, Size: blockSize},
+		{Offset: 10 * blockSize, Size: 3 * blockSize},
+		{Offset: 13 * blockSize, Size: 2 * blockSize},
+	}, coalesceMappings(mappings, blockSize, 3*blockSize))
+
+	// The mappings are sliced into blocks if the max size is smaller than the block size
+	result := coalesceMappings(mappings, blockSize, -1)
+	assert.Len(result, 9)
+	for _, mapping := range result {
+		assert.Equal(blockSize, mapping.Size)
+	}
+
+	assert.Equal([]types.Mapping{
+		{Offset: 0, Size: 4 * blockSize},
+		{Offset: 10 * blockSize, Size: 5 * blockSize},
+	}, coalesceMappings(mappings, blockSize, DEFAULT_READ_COALESCE_SIZE))
+}
+
+type fileSnapshotOps struct {
+	DeltaBlockBackupOperations
+	filePath string
+}
+
+func (f *fileSnapshotOps) GetSnapshotFilePath(id, volumeID string) (string, error) {
+	return f.filePath, nil
+}
+
+func TestMappedSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	filePath := filepath.Join(t.TempDir(), "snapshot.img")
+	content := append(bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE), bytes.Repeat([]byte{'b'}, DEFAULT_BLOCK_SIZE)...)
+	err := os.WriteFile(filePath, content, 0644)
+	assert.NoError(err)
+
+	config := &DeltaBackupConfig{
+		Volume:   &Volume{Name: "pvc-1"},
+		Snapshot: &Snapshot{Name: "snap-1"},
+		DeltaOps: &fileSnapshotOps{filePath: filePath},
+	}
+	mappedSnap, err := openMappedSnapshot(config)
+	assert.NoError(err)
+	assert.Nil(mappedSnap)
+
+	config.UseMmap = true
+	mappedSnap, err = openMappedSnapshot(config)
+	assert.NoError(err)
+	assert.NotNil(mappedSnap)
+
+	mapped, err := mappedSnap.view(DEFAULT_BLOCK_SIZE, DEFAULT_BLOCK_SIZE, func(data []byte) error {
+		assert.Equal(content[DEFAULT_BLOCK_SIZE:], data)
+		return nil
+	})
+	assert.True(mapped)
+	assert.NoError(err)
+
+	// The range beyond the end of the file is not mapped
+	mapped, _ = mappedSnap.view(DEFAULT_BLOCK_SIZE, 2*DEFAULT_BLOCK_SIZE, func(data []byte) error { return nil })
+	assert.False(mapped)
+
+	assert.NoError(mappedSnap.Close())
+	mapped, _ = mappedSnap.view(0, DEFAULT_BLOCK_SIZE, func(data []byte) error { return nil })
+	assert.False(mapped)
+}
+
+type memorySnapshotOps struct {
+	DeltaBlockBackupOperations
+	data []byte
+}
+
+func (m *memorySnapshotOps) ReadSnapshot(id, volumeID string, start int64, data []byte) error {
+	copy(data, m.data[start:])
+	return nil
+}
+
+func (m *memorySnapshotOps) UpdateBackupStatus(id, volumeID string, backupState string, backupProgress int, backupURL string, err string) error {
+	return nil
+}
+
+func TestPerformBackupWorkers(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	blockSize := int64(DEFAULT_BLOCK_SIZE)
+	// The first and the last blocks are identical, so they are uploaded once
+	data := append(bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE), bytes.Repeat([]byte{'b'}, DEFAULT_BLOCK_SIZE)...)
+	data = append(data, bytes.Repeat([]byte{'c'}, DEFAULT_BLOCK_SIZE)...)
+	data = append(data, bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE)...)
+
+	volume := &Volume{Name: "pvc-1", Size: int64(len(data)), CompressionMethod: "lz4",
+		LogicalSectorSize: 4096, PhysicalSectorSize: 4096}
+	err := saveVolume(m, volume)
+	assert.NoError(err)
+
+	config := &DeltaBackupConfig{
+		Volume:             volume,
+		Snapshot:           &Snapshot{Name: "snap-1", CreatedTime: "2021-06-07T08:00:00Z"},
+		DestURL:            mockDriverURL,
+		DeltaOps:           &memorySnapshotOps{data: data},
+		ReadCoalesceSize:   2 * blockSize,
+		CompressionWorkers: 2,
+		UploadWorkers:      3,
+	}
+	deltaBackup := &Backup{
+		Name:              "backup-1",
+		VolumeName:        volume.Name,
+		CompressionMethod: volume.CompressionMethod,
+		Blocks:            []BlockMapping{},
+		ProcessingBlocks: &ProcessingBlocks{
+			blocks: map[string][]*BlockMapping{},
+		},
+	}
+	delta := &types.Mappings{
+		Mappings:  []types.Mapping{{Offset: 0, Size: int64(len(data))}},
+		BlockSize: blockSize,
+	}
+
+	progress, _, err := performBackup(m, config, delta, deltaBackup, nil)
+	assert.NoError(err)
+	assert.Equal(PROGRESS_PERCENTAGE_BACKUP_TOTAL, progress)
+
+	backup, err := loadBackup(m, "backup-1", volume.Name)
+	assert.NoError(err)
+	assert.Len(backup.Blocks, 4)
+	assert.Equal(backup.Blocks[0].BlockChecksum, backup.Blocks[3].BlockChecksum)
+	assert.Equal(int64(4096), backup.LogicalSectorSize)
+	assert.Equal(int64(4096), backup.PhysicalSectorSize)
+	for _, block := range backup.Blocks {
+		assert.True(m.FileExists(getBlockFilePath(volume.Name, block.BlockChecksum)))
+	}
+	volume, err = loadVolume(m, volume.Name)
+	assert.NoError(err)
+	assert.Equal(int64(3), volume.BlockCount)
+}
+
+func TestPrepareMappedBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	config := &DeltaBackupConfig{Volume: &Volume{Name: "pvc-1"}}
+	newBackup := func() *Backup {
+		return &Backup{
+			Name:              "backup-1",
+			VolumeName:        "pvc-1",
+			CompressionMethod: "none",
+			ProcessingBlocks: &ProcessingBlocks{
+				blocks: map[string][]*BlockMapping{},
+			},
+		}
+	}
+
+	// The uncompressed block doesn't refer to the mapped snapshot after the view returns
+	block := bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE)
+	upload, err := prepareBlock(m, config, newBackup(), 0, block, true, &progress{})
+	assert.NoError(err)
+	block[0] = 'b'
+	data, err := io.ReadAll(upload.rs)
+	assert.NoError(err)
+	assert.Equal(byte('a'), data[0])
+
+	// The block read into a buffer is uploaded without the copy
+	block = bytes.Repeat([]byte{'c'}, DEFAULT_BLOCK_SIZE)
+	upload, err = prepareBlock(m, config, newBackup(), 0, block, false, &progress{})
+	assert.NoError(err)
+	block[0] = 'd'
+	data, err = io.ReadAll(upload.rs)
+	assert.NoError(err)
+	assert.Equal(byte('d'), data[0])
+}
+
+func TestRestoreWorkers(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(2*runtime.GOMAXPROCS(0), getDownloadWorkers(&DeltaRestoreConfig{}))
+	assert.Equal(5, getDownloadWorkers(&DeltaRestoreConfig{ConcurrentLimit: 5}))
+	assert.Equal(3, getDownloadWorkers(&DeltaRestoreConfig{ConcurrentLimit: 5, DownloadWorkers: 3}))
+
+	assert.Equal(runtime.GOMAXPROCS(0), cap(newDecompressionSlots(&DeltaRestoreConfig{})))
+	slots := newDecompressionSlots(&DeltaRestoreConfig{DecompressionWorkers: 1})
+	assert.Equal(1, cap(slots))
+
+	// The second decompression waits for the first one to release the slot
+	release := slots.acquire()
+	acquired := make(chan struct{})
+	go func() {
+		slots.acquire()()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		assert.Fail("decompression slot acquired twice")
+	case <-time.After(10 * time.Millisecond):
+	}
+	release()
+	<-acquired
+
+	// The nil slots are unbounded
+	decompressionSlots(nil).acquire()()
+}
+
+func TestPerformBackupInlineBlocks(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	blockSize := int64(DEFAULT_BLOCK_SIZE)
+	data := append(bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE), bytes.Repeat([]byte{'b'}, DEFAULT_BLOCK_SIZE)...)
+
+	volume := &Volume{Name: "pvc-1", Size: int64(len(data)), CompressionMethod: "lz4"}
+	err := saveVolume(m, volume)
+	assert.NoError(err)
+
+	config := &DeltaBackupConfig{
+		Volume:           volume,
+		Snapshot:         &Snapshot{Name: "snap-1", CreatedTime: "2021-06-07T08:00:00Z"},
+		DestURL:          mockDriverURL,
+		DeltaOps:         &memorySnapshotOps{data: data},
+		InlineBlockLimit: 2,
+	}
+	deltaBackup := &Backup{
+		Name:              "backup-1",
+		VolumeName:        volume.Name,
+		CompressionMethod: volume.CompressionMethod,
+		Blocks:            []BlockMapping{},
+		ProcessingBlocks: &ProcessingBlocks{
+			blocks: map[string][]*BlockMapping{},
+		},
+	}
+	delta := &types.Mappings{
+		Mappings:  []types.Mapping{{Offset: 0, Size: int64(len(data))}},
+		BlockSize: blockSize,
+	}
+
+	_, _, err = performBackup(m, config, delta, deltaBackup, nil)
+	assert.NoError(err)
+
+	backup, err := loadBackup(m, "backup-1", volume.Name)
+	assert.NoError(err)
+	assert.Len(backup.Blocks, 2)
+	assert.Len(backup.InlineBlocks, 2)
+	for _, block := range backup.Blocks {
+		assert.False(m.FileExists(getBlockFilePath(volume.Name, block.BlockChecksum)))
+	}
+	volume, err = loadVolume(m, volume.Name)
+	assert.NoError(err)
+	assert.Equal(int64(0), volume.BlockCount)
+
+	volDev, err := os.CreateTemp(t.TempDir(), "volume")
+	assert.NoError(err)
+	defer volDev.Close()
+	for _, blk := range backup.Blocks {
+		err = restoreInlineBlockToFile(volume.Name, volDev, &Block{
+			offset:            blk.Offset,
+			blockChecksum:     blk.BlockChecksum,
+			compressionMethod: backup.CompressionMethod,
+			inlineData:        backup.InlineBlocks[blk.BlockChecksum],
+		})
+		assert.NoError(err)
+	}
+	restored, err := os.ReadFile(volDev.Name())
+	assert.NoError(err)
+	assert.Equal(data, restored)
+
+	// The embedded blocks are written into the block files once the limit is exceeded
+	config.InlineBlockLimit = 1
+	newBlocks, err := materializeInlineBlocks(m, config, backup)
+	assert.NoError(err)
+	assert.Equal(int64(2), newBlocks)
+	assert.Nil(backup.InlineBlocks)
+	for _, block := range backup.Blocks {
+		assert.True(m.FileExists(getBlockFilePath(volume.Name, block.BlockChecksum)))
+	}
+}
+
+func TestRestoreTargetSize(t *testing.T) {
+	assert := assert.New(t)
+
+	volDev, err := os.Create(filepath.Join(t.TempDir(), "volume"))
+	assert.NoError(err)
+	defer volDev.Close()
+	stat, err := volDev.Stat()
+	assert.NoError(err)
+
+	vol := &Volume{Name: "pvc-1", Size: 2 * DEFAULT_BLOCK_SIZE}
+	// The regular files have no sector size
+	err = checkRestoreSectorSize(&Backup{Name: "backup-1", LogicalSectorSize: 4096}, volDev, stat)
+	assert.NoError(err)
+
+	size, err := getRestoreTargetSize(&DeltaRestoreConfig{}, vol, volDev, stat)
+	assert.NoError(err)
+	assert.Equal(vol.Size, size)
+
+	size, err = getRestoreTargetSize(&DeltaRestoreConfig{TargetSize: 3 * DEFAULT_BLOCK_SIZE}, vol, volDev, stat)
+	assert.NoError(err)
+	assert.Equal(int64(3*DEFAULT_BLOCK_SIZE), size)
+
+	_, err = getRestoreTargetSize(&DeltaRestoreConfig{TargetSize: DEFAULT_BLOCK_SIZE}, vol, volDev, stat)
+	assert.Error(err)
+
+	// Only the range beyond the backup volume is zeroed
+	_, err = volDev.Write(bytes.Repeat([]byte{'a'}, 3*DEFAULT_BLOCK_SIZE))
+	assert.NoError(err)
+	err = zeroDeviceRange(volDev, vol.Size, 3*DEFAULT_BLOCK_SIZE-1)
+	assert.NoError(err)
+	data, err := os.ReadFile(volDev.Name())
+	assert.NoError(err)
+	assert.Equal(bytes.Repeat([]byte{'a'}, int(vol.Size)), data[:vol.Size])
+	assert.Equal(make([]byte, DEFAULT_BLOCK_SIZE-1), data[vol.Size:3*DEFAULT_BLOCK_SIZE-1])
+	assert.Equal(byte('a'), data[3*DEFAULT_BLOCK_SIZE-1])
+}
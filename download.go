@@ -0,0 +1,185 @@
+package backupstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// DEFAULT_DOWNLOAD_CHUNK_SIZE is the size of the ranges a large object is downloaded in, the smaller
+	// objects are downloaded in one request
+	DEFAULT_DOWNLOAD_CHUNK_SIZE = 16 << 20
+	// DEFAULT_DOWNLOAD_WORKERS is the number of the ranges of an object downloaded concurrently
+	DEFAULT_DOWNLOAD_WORKERS = 4
+
+	// downloadChunkRetries is the number of times a failed range is downloaded again
+	downloadChunkRetries = 3
+)
+
+// DownloadOptions are the options of downloading a single large object, e.g. a system backup.
+type DownloadOptions struct {
+	// ChunkSize is the size of the ranges downloaded in parallel, DEFAULT_DOWNLOAD_CHUNK_SIZE is used if it's 0
+	ChunkSize int64
+	// Workers is the number of the concurrent ranged reads, DEFAULT_DOWNLOAD_WORKERS is used if it's 0
+	Workers int
+	// Checksum is the SHA256 checksum of the object verified after the download, it's not verified if empty
+	Checksum string
+}
+
+// DownloadObject downloads the object to the local file. The object larger than a chunk is downloaded in
+// parallel ranged reads and reassembled in place if the driver supports the ranged reads and the object is
+// not compressed by the content encoding, which speeds up the download over the high latency links. The
+// file is removed if the checksum doesn't match.
+func DownloadObject(driver BackupStoreDriver, src, dst string, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DEFAULT_DOWNLOAD_CHUNK_SIZE
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DEFAULT_DOWNLOAD_WORKERS
+	}
+
+	rangeDriver, ok := findDriver[ObjectMetadataBackupStoreDriver](driver)
+	size := int64(-1)
+	if ok {
+		size = driver.FileSize(src)
+		if size < 0 {
+			return fmt.Errorf("cannot find %v in backupstore", src)
+		}
+	}
+	if ok && size > chunkSize {
+		metadata, err := rangeDriver.GetMetadata(src)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get metadata of %v", src)
+		}
+		ok = getCompressionMethodFromMetadata(metadata) == ""
+	}
+
+	if !ok || size <= chunkSize {
+		if err := driver.Download(src, dst); err != nil {
+			return err
+		}
+	} else if err := downloadObjectRanges(rangeDriver, src, dst, size, chunkSize, workers); err != nil {
+		return err
+	}
+
+	if opts.Checksum == "" {
+		return nil
+	}
+	checksum, err := util.GetFileChecksum(dst)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %v checksum", dst)
+	}
+	if checksum != opts.Checksum {
+		if err := os.Remove(dst); err != nil {
+			log.WithError(err).Warnf("Failed to clean up local file %v", dst)
+		}
+		return fmt.Errorf("downloaded %v checksum mismatched: got %v: expect %v", dst, checksum, opts.Checksum)
+	}
+	return nil
+}
+
+// downloadObjectRanges downloads the ranges of the object into a temporary file of the object size, which is
+// renamed to the destination once all the ranges are complete.
+func downloadObjectRanges(driver ObjectMetadataBackupStoreDriver, src, dst string, size, chunkSize int64, workers int) (err error) {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0700); err != nil {
+		return err
+	}
+	tmpFile := dst + ".part"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+		if err != nil {
+			os.Remove(tmpFile)
+		}
+	}()
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+
+	log.Infof("Downloading %v of size %v in %v ranges of size %v", src, size, (size+chunkSize-1)/chunkSize, chunkSize)
+
+	var (
+		errLock     sync.Mutex
+		downloadErr error
+	)
+	failed := func() bool {
+		errLock.Lock()
+		defer errLock.Unlock()
+		return downloadErr != nil
+	}
+
+	jobQueues := workerpool.New(workers)
+	for offset := int64(0); offset < size; offset += chunkSize {
+		offset := offset
+		length := chunkSize
+		if offset+length > size {
+			length = size - offset
+		}
+		jobQueues.Submit(func() {
+			if failed() {
+				return
+			}
+			if err := downloadObjectRange(driver, src, f, offset, length); err != nil {
+				errLock.Lock()
+				if downloadErr == nil {
+					downloadErr = err
+				}
+				errLock.Unlock()
+			}
+		})
+	}
+	jobQueues.StopWait()
+	if downloadErr != nil {
+		return downloadErr
+	}
+
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, dst)
+}
+
+func downloadObjectRange(driver ObjectMetadataBackupStoreDriver, src string, w io.WriterAt, offset, length int64) error {
+	var err error
+	for attempt := 0; attempt <= downloadChunkRetries; attempt++ {
+		if attempt > 0 {
+			log.WithError(err).Warnf("Retrying to download %v at offset %v size %v", src, offset, length)
+		}
+
+		var rc io.ReadCloser
+		rc, err = driver.ReadRange(src, offset, length)
+		if err != nil {
+			continue
+		}
+		var written int64
+		written, err = io.Copy(io.NewOffsetWriter(w, offset), io.LimitReader(rc, length))
+		rc.Close()
+		if err == nil && written != length {
+			err = fmt.Errorf("read %v bytes instead of %v", written, length)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return errors.Wrapf(err, "failed to download %v at offset %v size %v", src, offset, length)
+}
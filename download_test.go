@@ -0,0 +1,83 @@
+package backupstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rangeCountingDriver counts the ranged reads and fails the first read of each range once.
+type rangeCountingDriver struct {
+	*metadataMockDriver
+
+	lock   sync.Mutex
+	reads  map[int64]int
+	failed map[int64]bool
+}
+
+func (d *rangeCountingDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	d.lock.Lock()
+	d.reads[offset]++
+	fail := !d.failed[offset]
+	d.failed[offset] = true
+	d.lock.Unlock()
+	if fail {
+		return nil, fmt.Errorf("connection reset")
+	}
+	return d.metadataMockDriver.ReadRange(src, offset, length)
+}
+
+func TestDownloadObject(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	driver := &rangeCountingDriver{
+		metadataMockDriver: &metadataMockDriver{mockStoreDriver: m, metadata: map[string]*ObjectMetadata{}},
+		reads:              map[int64]int{},
+		failed:             map[int64]bool{},
+	}
+	data := make([]byte, 10*1024+5)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	assert.NoError(driver.Write("backupstore/system-backups/backup.zip", bytes.NewReader(data)))
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	dst := filepath.Join(t.TempDir(), "download", "backup.zip")
+	assert.NoError(DownloadObject(driver, "backupstore/system-backups/backup.zip", dst, &DownloadOptions{
+		ChunkSize: 1024,
+		Workers:   3,
+		Checksum:  checksum,
+	}))
+	downloaded, err := os.ReadFile(dst)
+	assert.NoError(err)
+	assert.Equal(data, downloaded)
+	assert.Len(driver.reads, 11)
+	for offset, reads := range driver.reads {
+		assert.Equal(2, reads, "the range at offset %v should be retried once", offset)
+	}
+	assert.NoFileExists(dst + ".part")
+
+	// The mismatched download is removed
+	err = DownloadObject(driver, "backupstore/system-backups/backup.zip", dst, &DownloadOptions{
+		ChunkSize: 1024,
+		Checksum:  "bad",
+	})
+	assert.ErrorContains(err, "checksum mismatched")
+	assert.NoFileExists(dst)
+
+	err = DownloadObject(driver, "backupstore/system-backups/missing.zip", dst, nil)
+	assert.Error(err)
+}
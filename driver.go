@@ -13,6 +13,10 @@ import (
 
 type InitFunc func(destURL string) (BackupStoreDriver, error)
 
+// ReleaseFunc releases the local resources of the backup target without initializing the driver, so they are
+// released even if the backup target is unreachable.
+type ReleaseFunc func(destURL string) error
+
 type BackupStoreDriver interface {
 	Kind() string
 	GetURL() string
@@ -27,8 +31,68 @@ type BackupStoreDriver interface {
 	Download(src, dst string) error
 }
 
+// DeletedObject describes a removed object whose previous version is still kept by the backend.
+type DeletedObject struct {
+	Path      string
+	VersionID string
+	DeletedAt time.Time // Needs to be returned in UTC
+}
+
+// VersionedBackupStoreDriver is implemented by the drivers whose backend keeps the previous versions of
+// the removed objects, e.g. S3 buckets with versioning enabled.
+type VersionedBackupStoreDriver interface {
+	ListDeletedObjects(path string) ([]DeletedObject, error) // Behavior like "find", the latest versions only
+	RestoreObjectVersion(filePath, versionID string) error
+}
+
+// ObjectMetadata is the HTTP metadata stored along with the objects.
+type ObjectMetadata struct {
+	ContentType     string
+	ContentEncoding string
+}
+
+// ObjectMetadataBackupStoreDriver is implemented by the object storage drivers which store the HTTP metadata
+// along with the objects and support ranged reads.
+type ObjectMetadataBackupStoreDriver interface {
+	WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *ObjectMetadata) error
+	GetMetadata(filePath string) (*ObjectMetadata, error)
+	ReadRange(src string, offset, length int64) (io.ReadCloser, error) // Caller needs to close
+}
+
+// PrefixListingBackupStoreDriver is implemented by the object storage drivers which list all the objects under
+// a prefix at once instead of level by level.
+type PrefixListingBackupStoreDriver interface {
+	ListPrefix(prefix string) ([]string, error) // Behavior like "find", the paths of the objects under the prefix
+}
+
+// CopyingBackupStoreDriver is implemented by the drivers which copy the objects inside the backend without
+// transferring the data through the client, e.g. the S3 server-side copy.
+type CopyingBackupStoreDriver interface {
+	Copy(src, dst string) error
+}
+
+// LinkingBackupStoreDriver is implemented by the file system drivers which hard link the files, so the
+// identical files are stored once.
+type LinkingBackupStoreDriver interface {
+	Link(src, dst string) error             // Behavior like "ln -f", the existing dst is replaced
+	LinkCount(filePath string) (int, error) // The number of the hard links of the file
+}
+
+// RetainingBackupStoreDriver is implemented by the drivers which write the objects with the retention, e.g. the
+// S3 Object Lock, so the retention of the objects reused by the later backups can be extended.
+type RetainingBackupStoreDriver interface {
+	ExtendRetention(filePath string) error // Extends the retention of the object to the one of the objects written now
+}
+
+// ClosingBackupStoreDriver is implemented by the drivers holding the local resources of the backup target
+// across the operations, e.g. the mount points of the mount-based drivers.
+type ClosingBackupStoreDriver interface {
+	Close() error // Releases the local resources of the backup target, not the data
+}
+
 var (
 	initializers map[string]InitFunc
+	releasers    map[string]ReleaseFunc
 )
 
 var (
@@ -45,6 +109,7 @@ func generateError(fields logrus.Fields, format string, v ...interface{}) error
 
 func init() {
 	initializers = make(map[string]InitFunc)
+	releasers = make(map[string]ReleaseFunc)
 }
 
 func RegisterDriver(kind string, initFunc InitFunc) error {
@@ -55,6 +120,16 @@ func RegisterDriver(kind string, initFunc InitFunc) error {
 	return nil
 }
 
+// RegisterReleaseFunc registers the function releasing the local resources of the backup targets of the driver,
+// e.g. the mount points of the mount-based drivers.
+func RegisterReleaseFunc(kind string, releaseFunc ReleaseFunc) error {
+	if _, exists := releasers[kind]; exists {
+		return fmt.Errorf("release function of %s has already been registered", kind)
+	}
+	releasers[kind] = releaseFunc
+	return nil
+}
+
 func unregisterDriver(kind string) error {
 	if _, exists := initializers[kind]; !exists {
 		return fmt.Errorf("%s has not been registered", kind)
@@ -74,5 +149,98 @@ func GetBackupStoreDriver(destURL string) (BackupStoreDriver, error) {
 	if _, exists := initializers[u.Scheme]; !exists {
 		return nil, fmt.Errorf("driver %v is not supported", u.Scheme)
 	}
-	return initializers[u.Scheme](destURL)
+
+	immutable, err := isImmutableTargetURL(destURL)
+	if err != nil {
+		return nil, err
+	}
+	probe, err := isConsistencyProbeURL(destURL)
+	if err != nil {
+		return nil, err
+	}
+	linkBlocks, err := isBlockLinkingURL(destURL)
+	if err != nil {
+		return nil, err
+	}
+	rawAccess, err := isRawAccessURL(destURL)
+	if err != nil {
+		return nil, err
+	}
+	readOnly, err := IsReadOnlyTargetURL(destURL)
+	if err != nil {
+		return nil, err
+	}
+	if readOnly && probe {
+		return nil, fmt.Errorf("cannot probe the consistency of read-only backup target %v", destURL)
+	}
+
+	driver, err := initializers[u.Scheme](destURL)
+	if err != nil {
+		return nil, err
+	}
+	// The probe runs before the immutable mode is applied, since it removes the probe object
+	if probe {
+		if err := probeTargetConsistency(driver); err != nil {
+			return nil, err
+		}
+	}
+	if linkBlocks {
+		if _, ok := driver.(LinkingBackupStoreDriver); !ok {
+			return nil, fmt.Errorf("driver %v doesn't support the %v option", u.Scheme, HardLinkBlocksOption)
+		}
+	}
+	driver = newInstrumentedDriver(driver)
+	if linkBlocks {
+		driver = &blockLinkingDriver{driver}
+	}
+	if immutable {
+		driver = &immutableDriver{driver}
+	}
+	if readOnly {
+		driver = &readOnlyDriver{driver}
+	}
+	if rawAccess {
+		driver = &rawAccessDriver{driver}
+	}
+	return &deadlineDriver{BackupStoreDriver: driver}, nil
+}
+
+// driverWrapper is implemented by the drivers adding target-level behavior on top of another driver.
+type driverWrapper interface {
+	Unwrap() BackupStoreDriver
+}
+
+// findDriver walks through the wrapped drivers and returns the first one of the given type.
+func findDriver[T any](driver BackupStoreDriver) (T, bool) {
+	for driver != nil {
+		if d, ok := driver.(T); ok {
+			return d, true
+		}
+		wrapper, ok := driver.(driverWrapper)
+		if !ok {
+			break
+		}
+		driver = wrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// findBackendDriver returns the outermost driver of the given type, only if the backend driver wrapped by all the
+// wrappers is of the type as well. The wrappers forward the optional interfaces, so the operations still go
+// through the read-only and immutable modes, the deadlines and the metrics.
+func findBackendDriver[T any](driver BackupStoreDriver) (T, bool) {
+	backend := driver
+	for {
+		wrapper, ok := backend.(driverWrapper)
+		if !ok {
+			break
+		}
+		backend = wrapper.Unwrap()
+	}
+	if _, ok := backend.(T); !ok {
+		var zero T
+		return zero, false
+	}
+	return findDriver[T](driver)
 }
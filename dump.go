@@ -0,0 +1,258 @@
+package backupstore
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// DumpHistoryWindow is how far back the history records are included in the dump
+	DumpHistoryWindow = 30 * 24 * time.Hour
+
+	dumpManifestFile = "manifest.json"
+	dumpCatalogFile  = "catalog.json"
+	dumpRedacted     = "REDACTED"
+)
+
+var (
+	// sensitiveKeyPattern matches the keys of the values redacted from the dump
+	sensitiveKeyPattern = regexp.MustCompile(`(?i)(secret|password|passphrase|token|credential|accesskey|privatekey)`)
+)
+
+// DumpManifest is the summary of the dump, stored in the archive as manifest.json.
+type DumpManifest struct {
+	URL      string
+	DumpedAt string
+	Files    []string
+	// Errors are the errors by the paths which cannot be dumped. The configs which aren't valid JSON are only
+	// reported here, since they cannot be redacted.
+	Errors map[string]string `json:",omitempty"`
+}
+
+// DumpTargetState writes a gzipped tar archive of the state of the backup target for the bug reports: the
+// volume and backup configs, the lock files, the recent history records and the catalog of the target. No block
+// data is included, and the values of the sensitive keys are redacted. The lock files aren't created by the
+// dump, and the files which cannot be read are reported in the manifest instead of failing the dump.
+func DumpTargetState(destURL string, w io.Writer) error {
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	d := &targetDumper{
+		driver:    driver,
+		tarWriter: tarWriter,
+		manifest: &DumpManifest{
+			URL:      driver.GetURL(),
+			DumpedAt: util.Now(),
+			Files:    []string{},
+			Errors:   map[string]string{},
+		},
+	}
+
+	if err := d.dumpFiles(); err != nil {
+		return err
+	}
+
+	manifest, err := json.MarshalIndent(d.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := d.writeEntry(dumpManifestFile, manifest); err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+type targetDumper struct {
+	driver    BackupStoreDriver
+	tarWriter *tar.Writer
+	manifest  *DumpManifest
+}
+
+func (d *targetDumper) dumpFiles() error {
+	for _, filePath := range []string{getRedirectMarkerFilePath(), getMigrationManifestFilePath()} {
+		if d.driver.FileExists(filePath) {
+			if err := d.dumpFile(filePath); err != nil {
+				return err
+			}
+		}
+	}
+
+	jobQueues := workerpool.New(runtime.NumCPU() * 16)
+	defer jobQueues.StopWait()
+
+	volumeNames, pathErrs, err := getVolumeNamesWithErrors(jobQueues, d.driver)
+	if err != nil {
+		d.addError(filepath.Join(backupstoreBase, VOLUME_DIRECTORY), err)
+	}
+	for path, pathErr := range pathErrs {
+		d.addError(path, pathErr)
+	}
+	sort.Strings(volumeNames)
+	for _, volumeName := range volumeNames {
+		if err := d.dumpVolume(volumeName); err != nil {
+			return err
+		}
+	}
+
+	if err := d.dumpHistory(); err != nil {
+		return err
+	}
+
+	catalog, err := RegisterBackupTarget(d.driver.GetURL())
+	if err != nil {
+		d.addError(dumpCatalogFile, err)
+		return nil
+	}
+	data, err := json.Marshal(catalog)
+	if err != nil {
+		return err
+	}
+	return d.writeRedacted(dumpCatalogFile, data)
+}
+
+func (d *targetDumper) dumpVolume(volumeName string) error {
+	filePaths := []string{getVolumeFilePath(volumeName)}
+	backupNames, err := getBackupNamesForVolume(d.driver, volumeName)
+	if err != nil {
+		d.addError(getBackupPath(volumeName), err)
+	}
+	for _, backupName := range backupNames {
+		filePaths = append(filePaths, getBackupConfigPath(backupName, volumeName))
+	}
+	for _, lockName := range getLockNamesForVolume(volumeName, d.driver) {
+		filePaths = append(filePaths, getLockFilePath(volumeName, lockName))
+	}
+
+	for _, filePath := range filePaths {
+		if !d.driver.FileExists(filePath) {
+			continue
+		}
+		if err := d.dumpFile(filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpHistory dumps the history records within DumpHistoryWindow, including the ones of the volumes whose
+// folders are missing.
+func (d *targetDumper) dumpHistory() error {
+	historyDir := filepath.Join(backupstoreBase, HISTORY_DIRECTORY)
+	volumeNames, err := d.driver.List(historyDir)
+	if err != nil {
+		// path doesn't exist
+		return nil
+	}
+	sort.Strings(volumeNames)
+
+	since := util.GetClock().Now().Add(-DumpHistoryWindow)
+	for _, volumeName := range volumeNames {
+		records, err := getHistoryRecordsForVolume(d.driver, volumeName)
+		if err != nil {
+			d.addError(getHistoryPath(volumeName), err)
+			continue
+		}
+		for _, record := range records {
+			if record.Time.Before(since) {
+				continue
+			}
+			if err := d.dumpFile(getHistoryRecordFilePath(record)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dumpFile adds the redacted file to the archive. The errors reading the target are reported in the manifest,
+// and only the errors writing the archive are returned.
+func (d *targetDumper) dumpFile(filePath string) error {
+	rc, err := d.driver.Read(filePath)
+	if err != nil {
+		d.addError(filePath, err)
+		return nil
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		d.addError(filePath, err)
+		return nil
+	}
+	return d.writeRedacted(filePath, data)
+}
+
+func (d *targetDumper) writeRedacted(name string, data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		d.addError(name, fmt.Errorf("omitted %v bytes of invalid JSON: %v", len(data), err))
+		return nil
+	}
+	redacted, err := json.MarshalIndent(redactValue(v), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := d.writeEntry(name, redacted); err != nil {
+		return err
+	}
+	d.manifest.Files = append(d.manifest.Files, name)
+	return nil
+}
+
+func (d *targetDumper) writeEntry(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: util.GetClock().Now(),
+	}
+	if err := d.tarWriter.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "failed to write header of %v to the dump", name)
+	}
+	if _, err := d.tarWriter.Write(data); err != nil {
+		return errors.Wrapf(err, "failed to write %v to the dump", name)
+	}
+	return nil
+}
+
+func (d *targetDumper) addError(path string, err error) {
+	d.manifest.Errors[path] = err.Error()
+}
+
+// redactValue replaces the values of the sensitive keys in the decoded JSON.
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if sensitiveKeyPattern.MatchString(key) {
+				value[key] = dumpRedacted
+				continue
+			}
+			value[key] = redactValue(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(item)
+		}
+	}
+	return v
+}
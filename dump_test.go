@@ -0,0 +1,75 @@
+package backupstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestDumpTargetState(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	util.SetClock(util.NewFakeClock(time.Now()))
+	defer util.SetClock(nil)
+
+	assert.NoError(m.fs.MkdirAll(getBackupPath("pvc-1"), 0755))
+	assert.NoError(afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1","Size":"4096"}`), 0644))
+	assert.NoError(afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","CreatedTime":"2021-06-01T08:00:00Z","Parameters":{"secret":"s-1"}}`), 0644))
+	assert.NoError(afero.WriteFile(m.fs, getBackupConfigPath("backup-2", "pvc-1"), []byte(`invalid`), 0644))
+	assert.NoError(afero.WriteFile(m.fs, getBlockFilePath("pvc-1", "aabbcc"), []byte("data"), 0644))
+	recordBackupHistory(m, "pvc-1", "backup-1", HistoryEventCreated)
+
+	lock, err := New(m, "pvc-1", BACKUP_LOCK)
+	assert.NoError(err)
+	assert.NoError(saveLock(lock))
+
+	var buf bytes.Buffer
+	assert.NoError(DumpTargetState(mockDriverURL, &buf))
+
+	gzipReader, err := gzip.NewReader(&buf)
+	assert.NoError(err)
+	tarReader := tar.NewReader(gzipReader)
+	entries := map[string][]byte{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(err)
+		data, err := io.ReadAll(tarReader)
+		assert.NoError(err)
+		entries[header.Name] = data
+	}
+
+	assert.Contains(entries, getVolumeFilePath("pvc-1"))
+	assert.Contains(entries, getBackupConfigPath("backup-1", "pvc-1"))
+	assert.Contains(entries, getLockFilePath("pvc-1", lock.Name))
+	assert.Contains(entries, dumpCatalogFile)
+	assert.NotContains(entries, getBlockFilePath("pvc-1", "aabbcc"))
+	assert.Len(entries, 6)
+
+	// The sensitive values are redacted
+	assert.NotContains(string(entries[getBackupConfigPath("backup-1", "pvc-1")]), "s-1")
+	assert.Contains(string(entries[getBackupConfigPath("backup-1", "pvc-1")]), dumpRedacted)
+
+	// The invalid config is only reported in the manifest
+	manifest := &DumpManifest{}
+	assert.NoError(json.Unmarshal(entries[dumpManifestFile], manifest))
+	assert.Equal(mockDriverURL, manifest.URL)
+	assert.Len(manifest.Files, 5)
+	assert.Contains(manifest.Errors, getBackupConfigPath("backup-2", "pvc-1"))
+}
@@ -0,0 +1,114 @@
+package backupstore
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+// BackupSizeEstimate is the predicted result of a delta block backup.
+type BackupSizeEstimate struct {
+	IsIncremental bool
+	// ChangedBlocks is the number of the blocks changed since the last backup
+	ChangedBlocks int64
+	// NewBlocks is the number of the changed blocks absent in the backup target
+	NewBlocks int64
+	// NewDataSize is the uncompressed size of the new blocks, which is the upper bound of the data uploaded
+	NewDataSize int64
+	// ReUploadedDataSize is the uncompressed size of the existing blocks uploaded again by the full backup
+	ReUploadedDataSize int64
+	// BackupSize is the size of the resulting backup
+	BackupSize int64
+}
+
+// EstimateBackupSize walks the changed blocks of the snapshot, and predicts the data uploaded by the backup
+// without uploading anything. The blocks are read and hashed to be checked against the blocks in the backup
+// target, so it costs as much local I/O as the backup.
+func EstimateBackupSize(config *DeltaBackupConfig) (*BackupSizeEstimate, error) {
+	if config == nil {
+		return nil, fmt.Errorf("BUG: invalid empty config for backup size estimation")
+	}
+	if config.DeltaOps == nil {
+		return nil, fmt.Errorf("BUG: missing DeltaBlockBackupOperations")
+	}
+	snapshot := config.Snapshot
+	deltaOps := config.DeltaOps
+
+	bsDriver, err := GetBackupStoreDriver(config.DestURL)
+	if err != nil {
+		return nil, err
+	}
+
+	volume := config.Volume
+	if volumeExists(bsDriver, volume.Name) {
+		if volume, err = loadVolume(bsDriver, volume.Name); err != nil {
+			return nil, err
+		}
+	}
+	lastBackup := findLastBackup(bsDriver, config, volume)
+	lastSnapshotName := ""
+	if lastBackup != nil {
+		lastSnapshotName = lastBackup.SnapshotName
+	}
+
+	if err := deltaOps.OpenSnapshot(snapshot.Name, volume.Name); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := deltaOps.CloseSnapshot(snapshot.Name, volume.Name); closeErr != nil {
+			log.WithError(closeErr).Warn("Failed to close snapshot")
+		}
+	}()
+
+	delta, err := deltaOps.CompareSnapshot(snapshot.Name, lastSnapshotName, volume.Name)
+	if err != nil {
+		return nil, err
+	}
+	if delta.BlockSize != DEFAULT_BLOCK_SIZE {
+		return nil, fmt.Errorf("driver doesn't support block sizes other than %v", DEFAULT_BLOCK_SIZE)
+	}
+
+	// The block names are listed once instead of checking the existence of each block
+	manifest, err := getBlockManifest(bsDriver, volume.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get block manifest of volume %v", volume.Name)
+	}
+	reUpload := isFullBackup(config) && !IsImmutableTarget(bsDriver)
+
+	estimate := &BackupSizeEstimate{IsIncremental: lastBackup != nil}
+	offsets := map[int64]struct{}{}
+	if lastBackup != nil {
+		for _, block := range lastBackup.Blocks {
+			offsets[block.Offset] = struct{}{}
+		}
+	}
+	seen := map[string]struct{}{}
+	data := make([]byte, delta.BlockSize)
+	for _, mapping := range delta.Mappings {
+		for offset := mapping.Offset; offset < mapping.Offset+mapping.Size; offset += delta.BlockSize {
+			if err := deltaOps.ReadSnapshot(snapshot.Name, volume.Name, offset, data); err != nil {
+				return nil, errors.Wrapf(err, "failed to read volume %v snapshot %v at offset %v", volume.Name, snapshot.Name, offset)
+			}
+			estimate.ChangedBlocks++
+			offsets[offset] = struct{}{}
+
+			checksum := util.GetChecksum(data)
+			if _, exists := seen[checksum]; exists {
+				continue
+			}
+			seen[checksum] = struct{}{}
+			if _, exists := manifest[checksum]; !exists {
+				estimate.NewBlocks++
+				estimate.NewDataSize += delta.BlockSize
+			} else if reUpload {
+				estimate.ReUploadedDataSize += delta.BlockSize
+			}
+		}
+	}
+	estimate.BackupSize = int64(len(offsets)) * DEFAULT_BLOCK_SIZE
+
+	log.Infof("Estimated backup of volume %v snapshot %v: %+v", volume.Name, snapshot.Name, estimate)
+	return estimate, nil
+}
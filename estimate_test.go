@@ -0,0 +1,77 @@
+package backupstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+type estimateSnapshotOps struct {
+	memorySnapshotOps
+	mappings []types.Mapping
+}
+
+func (e *estimateSnapshotOps) HasSnapshot(id, volumeID string) bool {
+	return true
+}
+
+func (e *estimateSnapshotOps) CompareSnapshot(id, compareID, volumeID string) (*types.Mappings, error) {
+	return &types.Mappings{Mappings: e.mappings, BlockSize: DEFAULT_BLOCK_SIZE}, nil
+}
+
+func (e *estimateSnapshotOps) OpenSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (e *estimateSnapshotOps) CloseSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func TestEstimateBackupSize(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	existing := bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE)
+	data := append(bytes.Repeat([]byte{'b'}, DEFAULT_BLOCK_SIZE), existing...)
+	data = append(data, bytes.Repeat([]byte{'b'}, DEFAULT_BLOCK_SIZE)...)
+	data = append(data, bytes.Repeat([]byte{'c'}, DEFAULT_BLOCK_SIZE)...)
+
+	err := m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"),
+		[]byte(`{"Name":"pvc-1","Size":"8388608","LastBackupName":"backup-1"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","SnapshotName":"snap-1","CreatedTime":"2021-06-07T08:00:00Z",`+
+			`"Blocks":[{"Offset":2097152,"BlockChecksum":"`+util.GetChecksum(existing)+`"}]}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBlockFilePath("pvc-1", util.GetChecksum(existing)), []byte("existing"), 0644)
+	assert.NoError(err)
+
+	// The changed blocks are 2 identical new blocks and 1 existing block, the last block is not changed
+	config := &DeltaBackupConfig{
+		Volume:   &Volume{Name: "pvc-1", Size: int64(len(data))},
+		Snapshot: &Snapshot{Name: "snap-2"},
+		DestURL:  mockDriverURL,
+		DeltaOps: &estimateSnapshotOps{
+			memorySnapshotOps: memorySnapshotOps{data: data},
+			mappings:          []types.Mapping{{Offset: 0, Size: 3 * DEFAULT_BLOCK_SIZE}},
+		},
+	}
+	estimate, err := EstimateBackupSize(config)
+	assert.NoError(err)
+	assert.True(estimate.IsIncremental)
+	assert.Equal(int64(3), estimate.ChangedBlocks)
+	assert.Equal(int64(1), estimate.NewBlocks)
+	assert.Equal(int64(DEFAULT_BLOCK_SIZE), estimate.NewDataSize)
+	assert.Equal(int64(0), estimate.ReUploadedDataSize)
+	assert.Equal(int64(3*DEFAULT_BLOCK_SIZE), estimate.BackupSize)
+}
@@ -4,13 +4,19 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
 	"github.com/longhorn/backupstore"
 	"github.com/longhorn/backupstore/util"
-	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -21,21 +27,182 @@ type FileSystemOps interface {
 	LocalPath(path string) string
 }
 
+// FileOwnership configures the owner and the permission bits applied to the directories and files created
+// by the operator, e.g. for the exports squashing the root user to an unprivileged one.
+type FileOwnership struct {
+	UID      int         // -1 keeps the owner
+	GID      int         // -1 keeps the group
+	DirMode  os.FileMode // 0 keeps the default mode
+	FileMode os.FileMode // 0 keeps the default mode
+}
+
 type FileSystemOperator struct {
 	FileSystemOps
+	ownership   *FileOwnership
+	remountLock sync.Mutex
 }
 
 func NewFileSystemOperator(ops FileSystemOps) *FileSystemOperator {
-	return &FileSystemOperator{ops}
+	return &FileSystemOperator{FileSystemOps: ops}
+}
+
+// SetFileOwnership sets the ownership applied to the newly created directories and files. The files are
+// created by the owner if the process can take its ids, so the directories above the local path must be
+// searchable by the owner.
+func (f *FileSystemOperator) SetFileOwnership(ownership *FileOwnership) {
+	f.ownership = ownership
+}
+
+// chown changes the owner of the files which cannot be created by the owner
+var chown = os.Chown
+
+// ownerIDs returns the ids of the owner differing from the ones of the process, -1 for the others.
+func (f *FileSystemOperator) ownerIDs() (int, int) {
+	uid, gid := -1, -1
+	if f.ownership == nil {
+		return uid, gid
+	}
+	if f.ownership.UID >= 0 && f.ownership.UID != os.Geteuid() {
+		uid = f.ownership.UID
+	}
+	if f.ownership.GID >= 0 && f.ownership.GID != os.Getegid() {
+		gid = f.ownership.GID
+	}
+	return uid, gid
+}
+
+// asOwner runs the operation with the file system ids of the owner, so the files are created by the owner on the
+// exports squashing the root user, where the owner cannot be changed afterwards. The ids are per thread, so they
+// are set on a locked thread, which is terminated instead of reused if they cannot be restored. The operation
+// runs with the ids of the process if they cannot be set, e.g. without CAP_SETUID, and the owner is changed by
+// chown instead.
+func (f *FileSystemOperator) asOwner(op func() error) error {
+	uid, gid := f.ownerIDs()
+	if uid < 0 && gid < 0 {
+		return op()
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		restored := true
+		defer func() {
+			if restored {
+				runtime.UnlockOSThread()
+			}
+		}()
+
+		// The gid is set first, and restored last
+		prevGID, prevUID := -1, -1
+		if gid >= 0 {
+			prevGID, _ = setFSID(unix.SetfsgidRetGid, gid)
+		}
+		if uid >= 0 {
+			prevUID, _ = setFSID(unix.SetfsuidRetUid, uid)
+		}
+		err := op()
+		if prevUID >= 0 {
+			if _, ok := setFSID(unix.SetfsuidRetUid, prevUID); !ok {
+				restored = false
+			}
+		}
+		if prevGID >= 0 {
+			if _, ok := setFSID(unix.SetfsgidRetGid, prevGID); !ok {
+				restored = false
+			}
+		}
+		if !restored {
+			logrus.Warnf("Failed to restore file system ids %v:%v of thread, terminating it", prevUID, prevGID)
+		}
+		errc <- err
+	}()
+	return <-errc
+}
+
+// setFSID sets the file system id of the thread, and returns the previous one and if the id is set. The previous
+// id is returned whether or not the id is set, so it's checked again.
+func setFSID(set func(int) (int, error), id int) (int, bool) {
+	prev, err := set(id)
+	if err != nil {
+		return prev, false
+	}
+	current, err := set(-1)
+	return prev, err == nil && current == id
 }
 
 func (f *FileSystemOperator) preparePath(file string) error {
-	return os.MkdirAll(filepath.Dir(f.LocalPath(file)), os.ModeDir|0700)
+	dir := filepath.Dir(f.LocalPath(file))
+	if f.ownership == nil {
+		return os.MkdirAll(dir, os.ModeDir|0700)
+	}
+
+	// Record the missing directories, so only the newly created ones are updated
+	created := []string{}
+	for d := dir; d != "/" && d != "."; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, os.ModeDir|0700); err != nil {
+		return err
+	}
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := f.applyOwnership(created[i], f.ownership.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileSystemOperator) applyOwnership(path string, mode os.FileMode) error {
+	if f.ownership == nil {
+		return nil
+	}
+	if (f.ownership.UID >= 0 || f.ownership.GID >= 0) && !isOwnedBy(path, f.ownership.UID, f.ownership.GID) {
+		if err := chown(path, f.ownership.UID, f.ownership.GID); err != nil {
+			return errors.Wrapf(err, "failed to change owner of %v to %v:%v", path, f.ownership.UID, f.ownership.GID)
+		}
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return errors.Wrapf(err, "failed to change mode of %v to %v", path, mode)
+		}
+	}
+	return nil
+}
+
+// isOwnedBy checks if the file is created by the owner already, -1 matches any id.
+func isOwnedBy(path string, uid, gid int) bool {
+	st, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return (uid < 0 || int(sys.Uid) == uid) && (gid < 0 || int(sys.Gid) == gid)
+}
+
+func (f *FileSystemOperator) fileMode() os.FileMode {
+	if f.ownership == nil {
+		return 0
+	}
+	return f.ownership.FileMode
+}
+
+func (f *FileSystemOperator) stat(filePath string) (os.FileInfo, error) {
+	var st os.FileInfo
+	err := f.withRemount(func() (err error) {
+		st, err = os.Stat(f.LocalPath(filePath))
+		return err
+	})
+	return st, err
 }
 
 func (f *FileSystemOperator) FileSize(filePath string) int64 {
-	file := f.LocalPath(filePath)
-	st, err := os.Stat(file)
+	st, err := f.stat(filePath)
 	if err != nil || st.IsDir() {
 		return -1
 	}
@@ -43,8 +210,7 @@ func (f *FileSystemOperator) FileSize(filePath string) int64 {
 }
 
 func (f *FileSystemOperator) FileTime(filePath string) time.Time {
-	file := f.LocalPath(filePath)
-	st, err := os.Stat(file)
+	st, err := f.stat(filePath)
 	if err != nil || st.IsDir() {
 		return time.Time{}
 	}
@@ -57,34 +223,84 @@ func (f *FileSystemOperator) FileExists(filePath string) bool {
 }
 
 func (f *FileSystemOperator) Remove(path string) error {
-	if err := os.RemoveAll(f.LocalPath(path)); err != nil {
+	// The file system is kept in use while cleaning up the upper level directories
+	release, err := f.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := f.withRemount(func() error {
+		return f.asOwner(func() error { return os.RemoveAll(f.LocalPath(path)) })
+	}); err != nil {
 		return err
 	}
 	//Also automatically cleanup upper level directories
-	dir := f.LocalPath(path)
-	for i := 0; i < MaxCleanupLevel; i++ {
-		dir = filepath.Dir(dir)
-		// Don't clean above backupstore base
-		if strings.HasSuffix(dir, backupstore.GetBackupstoreBase()) {
-			break
-		}
-		// If directory is not empty, then we don't need to continue
-		if err := os.Remove(dir); err != nil {
-			break
+	return f.asOwner(func() error {
+		dir := f.LocalPath(path)
+		for i := 0; i < MaxCleanupLevel; i++ {
+			dir = filepath.Dir(dir)
+			// Don't clean above backupstore base
+			if strings.HasSuffix(dir, backupstore.GetBackupstoreBase()) {
+				break
+			}
+			// If directory is not empty, then we don't need to continue
+			if err := os.Remove(dir); err != nil {
+				break
+			}
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
+// Read keeps the file system in use until the returned reader is closed, so it's not unmounted for being idle
+// while the file is still being read.
 func (f *FileSystemOperator) Read(src string) (io.ReadCloser, error) {
-	file, err := os.Open(f.LocalPath(src))
+	release, err := f.use()
 	if err != nil {
 		return nil, err
 	}
-	return file, nil
+
+	var file *os.File
+	err = f.withRemount(func() (err error) {
+		file, err = os.Open(f.LocalPath(src))
+		return err
+	})
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &usedFile{File: file, release: release}, nil
+}
+
+// usedFile releases the file system once the file is closed.
+type usedFile struct {
+	*os.File
+	release     func()
+	releaseOnce sync.Once
+}
+
+func (f *usedFile) Close() error {
+	err := f.File.Close()
+	f.releaseOnce.Do(f.release)
+	return err
 }
 
 func (f *FileSystemOperator) Write(dst string, rs io.ReadSeeker) error {
+	offset, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return f.withRemount(func() error {
+		// Rewind the data in case of the retry after remounting
+		if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		return f.asOwner(func() error { return f.write(dst, rs) })
+	})
+}
+
+func (f *FileSystemOperator) write(dst string, rs io.ReadSeeker) error {
 	// we append the timestamp to the tmp files so that we should never have 2 backups using the same tmp file
 	tmpFile := dst + ".tmp" + "." + strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
 	if err := f.preparePath(dst); err != nil {
@@ -107,14 +323,76 @@ func (f *FileSystemOperator) Write(dst string, rs io.ReadSeeker) error {
 		return err
 	}
 
+	if err := f.applyOwnership(f.LocalPath(tmpFile), f.fileMode()); err != nil {
+		return err
+	}
+
 	return os.Rename(f.LocalPath(tmpFile), f.LocalPath(dst))
 }
 
+// Link creates the hard link dst of the file src, the existing dst is replaced.
+func (f *FileSystemOperator) Link(src, dst string) error {
+	return f.withRemount(func() error {
+		return f.asOwner(func() error { return f.link(src, dst) })
+	})
+}
+
+func (f *FileSystemOperator) link(src, dst string) error {
+	if err := f.preparePath(dst); err != nil {
+		return err
+	}
+	tmpFile := dst + ".tmp" + "." + strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
+	if err := os.Link(f.LocalPath(src), f.LocalPath(tmpFile)); err != nil {
+		return err
+	}
+	err := os.Rename(f.LocalPath(tmpFile), f.LocalPath(dst))
+	// The rename does nothing if dst is a link of src already
+	if removeErr := os.Remove(f.LocalPath(tmpFile)); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// LinkCount returns the number of the hard links of the file.
+func (f *FileSystemOperator) LinkCount(filePath string) (int, error) {
+	st, err := f.stat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errors.Errorf("cannot get the link count of %v", filePath)
+	}
+	return int(sys.Nlink), nil
+}
+
+// GetCapacity returns the file system statistics of the backup target.
+func (f *FileSystemOperator) GetCapacity() (*backupstore.TargetCapacity, error) {
+	var stat syscall.Statfs_t
+	if err := f.withRemount(func() error { return syscall.Statfs(f.LocalPath(""), &stat) }); err != nil {
+		return nil, err
+	}
+	blockSize := int64(stat.Bsize)
+	return &backupstore.TargetCapacity{
+		Total:     int64(stat.Blocks) * blockSize,
+		Used:      int64(stat.Blocks-stat.Bfree) * blockSize,
+		Available: int64(stat.Bavail) * blockSize,
+	}, nil
+}
+
 func (f *FileSystemOperator) List(path string) ([]string, error) {
-	out, err := util.Execute("ls", []string{"-1", f.LocalPath(path)})
-	if err != nil &&
-		!strings.Contains(err.Error(), "No such file or directory") &&
-		!strings.Contains(err.Error(), "cannot open directory") {
+	var out string
+	err := f.withRemount(func() (err error) {
+		out, err = util.Execute("ls", []string{"-1", f.LocalPath(path)})
+		if err != nil &&
+			(strings.Contains(err.Error(), "No such file or directory") ||
+				strings.Contains(err.Error(), "cannot open directory")) &&
+			!IsErrno(err, syscall.EIO, syscall.ENOTCONN, syscall.ESTALE) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 	var result []string
@@ -126,6 +404,19 @@ func (f *FileSystemOperator) List(path string) ([]string, error) {
 }
 
 func (f *FileSystemOperator) Upload(src, dst string) error {
+	// cp runs with the ids of the process, so the file is written by the operator as the owner
+	if uid, gid := f.ownerIDs(); uid >= 0 || gid >= 0 {
+		file, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return f.Write(dst, file)
+	}
+	return f.withRemount(func() error { return f.upload(src, dst) })
+}
+
+func (f *FileSystemOperator) upload(src, dst string) error {
 	tmpDst := dst + ".tmp" + "." + strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
 	if f.FileExists(tmpDst) {
 		if err := f.Remove(tmpDst); err != nil {
@@ -139,11 +430,16 @@ func (f *FileSystemOperator) Upload(src, dst string) error {
 	if err != nil {
 		return err
 	}
+	if err := f.applyOwnership(f.LocalPath(tmpDst), f.fileMode()); err != nil {
+		return err
+	}
 	_, err = util.Execute("mv", []string{f.LocalPath(tmpDst), f.LocalPath(dst)})
 	return err
 }
 
 func (f *FileSystemOperator) Download(src, dst string) error {
-	_, err := util.Execute("cp", []string{f.LocalPath(src), dst})
-	return err
+	return f.withRemount(func() error {
+		_, err := util.Execute("cp", []string{f.LocalPath(src), dst})
+		return err
+	})
 }
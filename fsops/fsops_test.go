@@ -0,0 +1,107 @@
+package fsops
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type localOps struct {
+	root string
+}
+
+func (o *localOps) LocalPath(path string) string {
+	return filepath.Join(o.root, path)
+}
+
+func getOwnership(t *testing.T, path string) (int, int, os.FileMode) {
+	st, err := os.Stat(path)
+	assert.NoError(t, err)
+	sys := st.Sys().(*syscall.Stat_t)
+	return int(sys.Uid), int(sys.Gid), st.Mode().Perm()
+}
+
+func TestFileOwnership(t *testing.T) {
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	f := NewFileSystemOperator(&localOps{root: root})
+	assert.NoError(f.Write("default/file", bytes.NewReader([]byte("data"))))
+	_, _, mode := getOwnership(t, filepath.Join(root, "default"))
+	assert.Equal(os.FileMode(0700), mode)
+
+	// The ownership is applied to the newly created directories and files only
+	assert.NoError(os.Chmod(root, 0755))
+	f.SetFileOwnership(&FileOwnership{UID: os.Getuid(), GID: os.Getgid(), DirMode: 0770, FileMode: 0660})
+	assert.NoError(f.Write("volumes/pvc-1/volume.cfg", bytes.NewReader([]byte("config"))))
+	for _, dir := range []string{"volumes", "volumes/pvc-1"} {
+		uid, gid, mode := getOwnership(t, filepath.Join(root, dir))
+		assert.Equal(os.Getuid(), uid, dir)
+		assert.Equal(os.Getgid(), gid, dir)
+		assert.Equal(os.FileMode(0770), mode, dir)
+	}
+	uid, gid, mode := getOwnership(t, filepath.Join(root, "volumes/pvc-1/volume.cfg"))
+	assert.Equal(os.Getuid(), uid)
+	assert.Equal(os.Getgid(), gid)
+	assert.Equal(os.FileMode(0660), mode)
+	_, _, mode = getOwnership(t, root)
+	assert.Equal(os.FileMode(0755), mode)
+
+	src := filepath.Join(t.TempDir(), "src")
+	assert.NoError(os.WriteFile(src, []byte("data"), 0600))
+	assert.NoError(f.Upload(src, "volumes/pvc-1/blocks/00/00/0000.blk"))
+	_, _, mode = getOwnership(t, filepath.Join(root, "volumes/pvc-1/blocks"))
+	assert.Equal(os.FileMode(0770), mode)
+	_, _, mode = getOwnership(t, filepath.Join(root, "volumes/pvc-1/blocks/00/00/0000.blk"))
+	assert.Equal(os.FileMode(0660), mode)
+
+	// The zero modes keep the default modes
+	f.SetFileOwnership(&FileOwnership{UID: -1, GID: -1})
+	assert.NoError(f.Write("backups/backup.cfg", bytes.NewReader([]byte("config"))))
+	_, _, mode = getOwnership(t, filepath.Join(root, "backups"))
+	assert.Equal(os.FileMode(0700), mode)
+}
+
+func TestFileOwnershipAsOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	if os.Geteuid() != 0 {
+		t.Skip("the files are created as the owner by root only")
+	}
+	chowns := 0
+	defer func(orig func(string, int, int) error) { chown = orig }(chown)
+	chown = func(path string, uid, gid int) error {
+		chowns++
+		return os.Chown(path, uid, gid)
+	}
+
+	// The owner creates the files in the directory writable by the owner only, like the root squashed export
+	root := t.TempDir()
+	assert.NoError(os.Chmod(filepath.Dir(root), 0711))
+	assert.NoError(os.Chown(root, 65534, 65534))
+	f := NewFileSystemOperator(&localOps{root: root})
+	f.SetFileOwnership(&FileOwnership{UID: 65534, GID: 65534, DirMode: 0700, FileMode: 0600})
+
+	assert.NoError(f.Write("volumes/pvc-1/volume.cfg", bytes.NewReader([]byte("config"))))
+	src := filepath.Join(t.TempDir(), "src")
+	assert.NoError(os.WriteFile(src, []byte("data"), 0600))
+	assert.NoError(f.Upload(src, "volumes/pvc-1/blocks/00/00/0000.blk"))
+	for _, path := range []string{"volumes", "volumes/pvc-1", "volumes/pvc-1/volume.cfg", "volumes/pvc-1/blocks/00/00/0000.blk"} {
+		uid, gid, _ := getOwnership(t, filepath.Join(root, path))
+		assert.Equal(65534, uid, path)
+		assert.Equal(65534, gid, path)
+	}
+	assert.Equal(0, chowns)
+
+	assert.NoError(f.Remove("volumes/pvc-1/blocks/00/00/0000.blk"))
+	_, err := os.Stat(filepath.Join(root, "volumes/pvc-1/blocks"))
+	assert.True(os.IsNotExist(err))
+
+	// The ids of the process are kept by the other operations
+	assert.Equal(0, os.Geteuid())
+	assert.NoError(os.WriteFile(filepath.Join(t.TempDir(), "file"), []byte("data"), 0600))
+}
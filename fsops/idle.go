@@ -0,0 +1,203 @@
+package fsops
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	mount "k8s.io/mount-utils"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+// IdleUnmounter is implemented by the FileSystemOps of the mount-based drivers. The file system is unmounted
+// once no operation uses it for the idle timeout, and mounted again lazily by the next operation.
+type IdleUnmounter interface {
+	MountPoint() string
+	Mount() error
+	Unmount() error
+}
+
+var (
+	idleUnmountTimeout int64 // time.Duration, 0 disables unmounting the idle file systems
+
+	mountUsagesLock sync.Mutex
+	mountUsages     = map[string]*mountUsage{}
+)
+
+// SetIdleUnmountTimeout sets the idle timeout after which the file systems of the mount-based drivers are
+// unmounted, 0 disables it.
+func SetIdleUnmountTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&idleUnmountTimeout, int64(timeout))
+}
+
+// GetIdleUnmountTimeout returns the idle timeout of the file systems of the mount-based drivers.
+func GetIdleUnmountTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&idleUnmountTimeout))
+}
+
+// mountUsage tracks the operations using a mount point. It's shared by all the drivers of the mount point in
+// the process, since the drivers are created per operation.
+type mountUsage struct {
+	sync.Mutex
+	active     int
+	unmounted  bool
+	generation int
+	// mounted is set once the file system is mounted by a driver of the process, so the drivers created later
+	// reuse the mount instead of checking it again
+	mounted bool
+}
+
+// isMountPoint is a quick check of the mount point reused by the drivers, the mount point unmounted outside the
+// process is mounted again.
+var isMountPoint = func(mountPoint string) bool {
+	notMountPoint, err := mount.New("").IsLikelyNotMountPoint(mountPoint)
+	return err == nil && !notMountPoint
+}
+
+func getMountUsage(mountPoint string) *mountUsage {
+	mountUsagesLock.Lock()
+	defer mountUsagesLock.Unlock()
+
+	usage, exists := mountUsages[mountPoint]
+	if !exists {
+		usage = &mountUsage{}
+		mountUsages[mountPoint] = usage
+	}
+	return usage
+}
+
+// EnsureMounted mounts the file system when the driver is initialized. The mount is shared by all the drivers of
+// the mount point in the process, so it's only mounted by the first driver and after it's unmounted, and the
+// concurrent drivers wait for the mount instead of mounting it again. Like the other operations, the idle mount
+// is unmounted once the last operation using it is done.
+func (f *FileSystemOperator) EnsureMounted() error {
+	unmounter, ok := f.FileSystemOps.(IdleUnmounter)
+	if !ok {
+		return nil
+	}
+	mountPoint := unmounter.MountPoint()
+	usage := getMountUsage(mountPoint)
+
+	usage.Lock()
+	defer usage.Unlock()
+
+	if usage.mounted && !usage.unmounted && isMountPoint(mountPoint) {
+		logrus.Debugf("Reusing file system mounted on mount point %v", mountPoint)
+		return nil
+	}
+	if err := unmounter.Mount(); err != nil {
+		usage.mounted = false
+		return err
+	}
+	usage.mounted = true
+	usage.unmounted = false
+	if usage.active == 0 {
+		usage.scheduleIdleUnmount(unmounter)
+	}
+	return nil
+}
+
+// use marks the file system in use, mounting it again if it's unmounted for being idle. The returned
+// function releases it.
+func (f *FileSystemOperator) use() (func(), error) {
+	unmounter, ok := f.FileSystemOps.(IdleUnmounter)
+	if !ok {
+		return func() {}, nil
+	}
+	mountPoint := unmounter.MountPoint()
+	usage := getMountUsage(mountPoint)
+
+	usage.Lock()
+	defer usage.Unlock()
+
+	if usage.unmounted {
+		logrus.Infof("Mounting idle unmounted file system on mount point %v", mountPoint)
+		if err := unmounter.Mount(); err != nil {
+			return nil, errors.Wrapf(err, "failed to mount file system on mount point %v", mountPoint)
+		}
+		usage.unmounted = false
+	}
+	usage.active++
+	// The pending idle unmount is canceled
+	usage.generation++
+
+	return func() { usage.release(unmounter) }, nil
+}
+
+func (u *mountUsage) release(unmounter IdleUnmounter) {
+	u.Lock()
+	defer u.Unlock()
+
+	u.active--
+	if u.active > 0 {
+		return
+	}
+	u.scheduleIdleUnmount(unmounter)
+}
+
+// scheduleIdleUnmount unmounts the file system if it's still unused after the idle timeout. It must be called
+// with the lock held.
+func (u *mountUsage) scheduleIdleUnmount(unmounter IdleUnmounter) {
+	timeout := GetIdleUnmountTimeout()
+	if timeout <= 0 {
+		return
+	}
+	u.generation++
+	generation := u.generation
+	time.AfterFunc(timeout, func() {
+		u.Lock()
+		defer u.Unlock()
+
+		if u.active > 0 || u.unmounted || u.generation != generation {
+			return
+		}
+		mountPoint := unmounter.MountPoint()
+		logrus.Infof("Unmounting file system on mount point %v idle for %v", mountPoint, timeout)
+		if err := unmounter.Unmount(); err != nil {
+			logrus.WithError(err).Warnf("Failed to unmount idle file system on mount point %v", mountPoint)
+			return
+		}
+		u.unmounted = true
+	})
+}
+
+// Close unmounts the file system and removes the mount point, e.g. when the backup target is removed. It fails if
+// any operation is still using the file system. The drivers created before still work, since the next operation
+// mounts the file system again like after the idle unmount.
+func (f *FileSystemOperator) Close() error {
+	unmounter, ok := f.FileSystemOps.(IdleUnmounter)
+	if !ok {
+		return nil
+	}
+	return ReleaseMountPoint(unmounter.MountPoint(), unmounter.Unmount)
+}
+
+// ReleaseMountPoint unmounts the file system by the unmount function and removes the mount point without a driver,
+// e.g. when the backup target is removed and its server may be gone. It fails if any operation of the process is
+// still using the file system.
+func ReleaseMountPoint(mountPoint string, unmount func() error) error {
+	usage := getMountUsage(mountPoint)
+
+	usage.Lock()
+	defer usage.Unlock()
+
+	if usage.active > 0 {
+		return fmt.Errorf("cannot close file system on mount point %v used by %v operations", mountPoint, usage.active)
+	}
+	// The pending idle unmount is canceled
+	usage.generation++
+	if !usage.unmounted {
+		logrus.Infof("Unmounting file system on mount point %v", mountPoint)
+		if err := unmount(); err != nil {
+			return errors.Wrapf(err, "failed to unmount file system on mount point %v", mountPoint)
+		}
+		usage.unmounted = true
+	}
+	util.RemoveMountDirs(mountPoint, logrus.StandardLogger())
+	return nil
+}
@@ -0,0 +1,163 @@
+package fsops
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+type idleOps struct {
+	sync.Mutex
+	dir      string
+	mounts   int
+	unmounts int
+}
+
+func (o *idleOps) LocalPath(path string) string {
+	return filepath.Join(o.dir, path)
+}
+
+func (o *idleOps) MountPoint() string {
+	return o.dir
+}
+
+func (o *idleOps) Mount() error {
+	o.Lock()
+	defer o.Unlock()
+	o.mounts++
+	return nil
+}
+
+func (o *idleOps) Unmount() error {
+	o.Lock()
+	defer o.Unlock()
+	o.unmounts++
+	return nil
+}
+
+func (o *idleOps) counts() (int, int) {
+	o.Lock()
+	defer o.Unlock()
+	return o.mounts, o.unmounts
+}
+
+func TestIdleUnmount(t *testing.T) {
+	assert := assert.New(t)
+
+	SetIdleUnmountTimeout(50 * time.Millisecond)
+	defer SetIdleUnmountTimeout(0)
+
+	ops := &idleOps{dir: t.TempDir()}
+	f := NewFileSystemOperator(ops)
+	err := os.WriteFile(ops.LocalPath("file"), []byte("data"), 0644)
+	assert.NoError(err)
+
+	assert.True(f.FileExists("file"))
+	mounts, unmounts := ops.counts()
+	assert.Equal(0, mounts)
+	assert.Equal(0, unmounts)
+
+	// The file system in use is not unmounted
+	release, err := f.use()
+	assert.NoError(err)
+	time.Sleep(150 * time.Millisecond)
+	_, unmounts = ops.counts()
+	assert.Equal(0, unmounts)
+	release()
+
+	assert.Eventually(func() bool {
+		_, unmounts := ops.counts()
+		return unmounts == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The next operation mounts the file system again
+	assert.True(f.FileExists("file"))
+	mounts, _ = ops.counts()
+	assert.Equal(1, mounts)
+
+	// The file system being read is not unmounted until the reader is closed
+	assert.Eventually(func() bool {
+		_, unmounts := ops.counts()
+		return unmounts == 2
+	}, time.Second, 10*time.Millisecond)
+	rc, err := f.Read("file")
+	assert.NoError(err)
+	time.Sleep(150 * time.Millisecond)
+	_, unmounts = ops.counts()
+	assert.Equal(2, unmounts)
+	assert.NoError(rc.Close())
+	assert.Eventually(func() bool {
+		_, unmounts := ops.counts()
+		return unmounts == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClose(t *testing.T) {
+	assert := assert.New(t)
+
+	defer util.SetMountDir(util.GetMountDir()) // nolint:errcheck
+	assert.NoError(util.SetMountDir(t.TempDir()))
+
+	ops := &idleOps{dir: filepath.Join(util.GetMountDir(), "server", "export")}
+	assert.NoError(os.MkdirAll(ops.dir, 0700))
+	f := NewFileSystemOperator(ops)
+
+	// The file system in use cannot be closed
+	release, err := f.use()
+	assert.NoError(err)
+	assert.Error(f.Close())
+	release()
+
+	assert.NoError(f.Close())
+	_, unmounts := ops.counts()
+	assert.Equal(1, unmounts)
+	_, err = os.Stat(filepath.Join(util.GetMountDir(), "server"))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(util.GetMountDir())
+	assert.NoError(err)
+
+	// The closed file system is mounted again by the next operation
+	assert.NoError(os.MkdirAll(ops.dir, 0700))
+	assert.NoError(os.WriteFile(ops.LocalPath("file"), []byte("data"), 0644))
+	assert.True(f.FileExists("file"))
+	mounts, _ := ops.counts()
+	assert.Equal(1, mounts)
+}
+
+func TestEnsureMounted(t *testing.T) {
+	assert := assert.New(t)
+
+	mounted := true
+	oldIsMountPoint := isMountPoint
+	defer func() { isMountPoint = oldIsMountPoint }()
+	isMountPoint = func(string) bool { return mounted }
+
+	// The drivers of the mount point share the mount of the first one
+	ops := &idleOps{dir: t.TempDir()}
+	for i := 0; i < 3; i++ {
+		assert.NoError(NewFileSystemOperator(ops).EnsureMounted())
+	}
+	mounts, _ := ops.counts()
+	assert.Equal(1, mounts)
+
+	// The mount point unmounted outside the process is mounted again
+	mounted = false
+	assert.NoError(NewFileSystemOperator(ops).EnsureMounted())
+	mounts, _ = ops.counts()
+	assert.Equal(2, mounts)
+
+	// The closed file system is mounted again by the next driver
+	mounted = true
+	f := NewFileSystemOperator(ops)
+	assert.NoError(f.Close())
+	assert.NoError(NewFileSystemOperator(ops).EnsureMounted())
+	mounts, unmounts := ops.counts()
+	assert.Equal(3, mounts)
+	assert.Equal(1, unmounts)
+}
@@ -0,0 +1,82 @@
+package fsops
+
+import (
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var (
+	// RemountBackoff is the backoff used for remounting the file system after a connection failure
+	RemountBackoff = wait.Backoff{
+		Duration: 1 * time.Second,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    5,
+	}
+)
+
+// Remounter is implemented by the FileSystemOps whose file system can be recovered by remounting,
+// e.g. a network share after the server restarts.
+type Remounter interface {
+	// ShouldRemount returns true if the operation error is caused by a broken mount
+	ShouldRemount(err error) bool
+	Remount() error
+}
+
+// IsErrno checks if the error is one of the given errnos. The errors of the executed commands are
+// matched by the error messages.
+func IsErrno(err error, errnos ...syscall.Errno) bool {
+	if err == nil {
+		return false
+	}
+	message := strings.ToLower(err.Error())
+	for _, errno := range errnos {
+		if errors.Is(err, errno) || strings.Contains(message, strings.ToLower(errno.Error())) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRemount runs the operation and transparently retries it after remounting the file system
+// if the operation failed because of a broken mount. The file system is mounted again first if it's
+// unmounted for being idle.
+func (f *FileSystemOperator) withRemount(op func() error) error {
+	release, err := f.use()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = op()
+	remounter, ok := f.FileSystemOps.(Remounter)
+	if err == nil || !ok || !remounter.ShouldRemount(err) {
+		return err
+	}
+
+	waitErr := wait.ExponentialBackoff(RemountBackoff, func() (bool, error) {
+		logrus.WithError(err).Warn("Remounting the backup store after the failed operation")
+		if remountErr := f.remount(remounter); remountErr != nil {
+			logrus.WithError(remountErr).Warn("Failed to remount the backup store")
+			return false, nil
+		}
+		err = op()
+		return err == nil || !remounter.ShouldRemount(err), nil
+	})
+	if waitErr != nil {
+		return errors.Wrapf(err, "failed to recover the operation by remounting the backup store")
+	}
+	return err
+}
+
+func (f *FileSystemOperator) remount(remounter Remounter) error {
+	f.remountLock.Lock()
+	defer f.remountLock.Unlock()
+	return remounter.Remount()
+}
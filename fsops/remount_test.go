@@ -0,0 +1,96 @@
+package fsops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// remountingOps fails the operations with the broken mount errors until it's remounted.
+type remountingOps struct {
+	localOps
+	broken       bool
+	remounts     int
+	remountFails int
+}
+
+func (o *remountingOps) ShouldRemount(err error) bool {
+	return IsErrno(err, syscall.ENOTCONN)
+}
+
+func (o *remountingOps) Remount() error {
+	o.remounts++
+	if o.remounts <= o.remountFails {
+		return errors.New("server unreachable")
+	}
+	o.broken = false
+	return nil
+}
+
+func TestIsErrno(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{syscall.ENOTCONN, true},
+		{&os.PathError{Op: "stat", Path: "/mnt/share", Err: syscall.ESTALE}, true},
+		{fmt.Errorf("failed to execute: ls: cannot access '/mnt/share': %v", syscall.ENOTCONN), true},
+		{errors.New("Transport endpoint is not connected"), true},
+		{syscall.ENOENT, false},
+		{errors.New("permission denied"), false},
+	} {
+		assert.Equal(tc.expected, IsErrno(tc.err, syscall.ESTALE, syscall.ENOTCONN), "error %v", tc.err)
+	}
+}
+
+func TestWithRemount(t *testing.T) {
+	assert := assert.New(t)
+
+	backoff := RemountBackoff
+	defer func() { RemountBackoff = backoff }()
+	RemountBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+
+	ops := &remountingOps{localOps: localOps{root: t.TempDir()}}
+	f := NewFileSystemOperator(ops)
+	calls := 0
+	op := func() error {
+		calls++
+		if ops.broken {
+			return &os.PathError{Op: "stat", Path: ops.root, Err: syscall.ENOTCONN}
+		}
+		return nil
+	}
+
+	// The operation is retried once the broken mount is remounted
+	ops.broken, ops.remountFails = true, 1
+	assert.NoError(f.withRemount(op))
+	assert.Equal(2, ops.remounts)
+	assert.Equal(2, calls)
+
+	// The other errors are returned without remounting
+	ops.remounts, calls = 0, 0
+	assert.True(os.IsNotExist(f.withRemount(func() error {
+		calls++
+		return &os.PathError{Op: "stat", Path: ops.root, Err: syscall.ENOENT}
+	})))
+	assert.Equal(0, ops.remounts)
+	assert.Equal(1, calls)
+
+	// The operation fails once the remounts are exhausted
+	ops.broken, ops.remounts, ops.remountFails, calls = true, 0, RemountBackoff.Steps, 0
+	err := f.withRemount(op)
+	assert.ErrorContains(err, "failed to recover the operation by remounting")
+	assert.True(errors.Is(err, syscall.ENOTCONN))
+	assert.Equal(RemountBackoff.Steps, ops.remounts)
+	assert.Equal(1, calls)
+}
@@ -0,0 +1,239 @@
+package gcs
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/backupstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "gcs"})
+)
+
+// BackupStoreDriver defines the variables and method that backupstore will use.
+type BackupStoreDriver struct {
+	destURL string
+	path    string
+	service *service
+}
+
+const (
+	// KIND defines the kind of backupstore driver
+	KIND = "gcs"
+)
+
+func init() {
+	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("wrong driver dispatching %v to %v?", u.Scheme, KIND)
+	}
+
+	b := &BackupStoreDriver{}
+	b.service, err = newService(u, backupstore.GetTargetCredential(destURL))
+	if err != nil {
+		return nil, err
+	}
+
+	b.path = u.Path
+	if b.service.Bucket == "" || b.path == "" {
+		return nil, fmt.Errorf("invalid URL. Must be either gcs://bucket@region/path/, or gcs://bucket/path")
+	}
+
+	b.path = strings.TrimLeft(b.path, "/")
+
+	if _, err := b.List(""); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + b.service.Bucket
+	if b.service.Region != "" {
+		b.destURL += "@" + b.service.Region
+	}
+	b.destURL += "/" + b.path
+
+	log.Infof("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+// Kind returns the driver type
+func (s *BackupStoreDriver) Kind() string {
+	return KIND
+}
+
+// GetURL returns URL of the backup target
+func (s *BackupStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *BackupStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+// List return items that on the backup target including prefixes
+func (s *BackupStoreDriver) List(listPath string) ([]string, error) {
+	var result []string
+
+	path := s.updatePath(listPath) + "/"
+	objects, prefixes, err := s.service.listObjects(path, "/")
+	if err != nil {
+		log.WithError(err).Error("Failed to list gcs")
+		return result, err
+	}
+
+	if len(objects) == 0 && len(prefixes) == 0 {
+		return result, nil
+	}
+	result = []string{}
+	for _, obj := range objects {
+		if r := strings.TrimPrefix(obj.Name, path); r != "" {
+			result = append(result, r)
+		}
+	}
+	for _, prefix := range prefixes {
+		r := strings.TrimPrefix(prefix, path)
+		r = strings.TrimSuffix(r, "/")
+		if r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// ListPrefix returns the paths of all the objects under the prefix
+func (s *BackupStoreDriver) ListPrefix(prefix string) ([]string, error) {
+	path := s.updatePath(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		path += "/"
+	}
+	objects, _, err := s.service.listObjects(path, "")
+	if err != nil {
+		log.WithError(err).Error("Failed to list gcs")
+		return nil, err
+	}
+
+	result := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		if r := strings.TrimPrefix(strings.TrimPrefix(obj.Name, s.path), "/"); r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// FileExists checks if file exists on the backup target
+func (s *BackupStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+// FileSize return content length of the filePath on the backup target
+func (s *BackupStoreDriver) FileSize(filePath string) int64 {
+	obj, err := s.service.getObjectMetadata(s.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return obj.Size
+}
+
+// FileTime returns file last modified time on the backup target
+func (s *BackupStoreDriver) FileTime(filePath string) time.Time {
+	obj, err := s.service.getObjectMetadata(s.updatePath(filePath))
+	if err != nil {
+		return time.Time{}
+	}
+	return obj.Updated.UTC()
+}
+
+// Remove deletes files on the backup target
+func (s *BackupStoreDriver) Remove(path string) error {
+	return s.service.deleteObjects(s.updatePath(path))
+}
+
+func (s *BackupStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return s.service.getObject(s.updatePath(src))
+}
+
+// Write creates a item on the backup target from io stream
+func (s *BackupStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return s.service.putObject(s.updatePath(dst), rs, "", "")
+}
+
+// WriteWithMetadata creates a item with the HTTP metadata on the backup target from io stream
+func (s *BackupStoreDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *backupstore.ObjectMetadata) error {
+	return s.service.putObject(s.updatePath(dst), rs, metadata.ContentType, metadata.ContentEncoding)
+}
+
+// GetMetadata returns the HTTP metadata of the item on the backup target
+func (s *BackupStoreDriver) GetMetadata(filePath string) (*backupstore.ObjectMetadata, error) {
+	obj, err := s.service.getObjectMetadata(s.updatePath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return &backupstore.ObjectMetadata{
+		ContentType:     obj.ContentType,
+		ContentEncoding: obj.ContentEncoding,
+	}, nil
+}
+
+// ReadRange reads the data range of the item on the backup target, a negative length reads the data until the end
+func (s *BackupStoreDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	return s.service.getObjectRange(s.updatePath(src), offset, length)
+}
+
+// Copy copies the item inside the bucket without transferring the data through the client
+func (s *BackupStoreDriver) Copy(src, dst string) error {
+	return s.service.copyObject(s.updatePath(src), s.updatePath(dst))
+}
+
+// Upload creates a item on the backup target by opening source file
+func (s *BackupStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.service.putObject(s.updatePath(dst), file, "", "")
+}
+
+// Download gets a item data from the backup target
+func (s *BackupStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0700); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rc, err := s.service.getObject(s.updatePath(src))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
@@ -0,0 +1,221 @@
+package gcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	storageScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	defaultTokenURI     = "https://oauth2.googleapis.com/token"
+	defaultMetadataHost = "metadata.google.internal"
+	jwtBearerGrantType  = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	// tokenRefreshMargin refreshes the access tokens before they expire, so the requests in flight don't
+	// carry an expired token
+	tokenRefreshMargin = time.Minute
+)
+
+// tokenSource returns the OAuth2 access token authorizing the requests to the GCS JSON API.
+type tokenSource interface {
+	token() (string, error)
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+// cachedTokenSource caches the token fetched from the underlying source until it's about to expire.
+type cachedTokenSource struct {
+	fetch func() (*tokenResponse, error)
+
+	lock        sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+func (c *cachedTokenSource) token() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.accessToken != "" && time.Now().Add(tokenRefreshMargin).Before(c.expiry) {
+		return c.accessToken, nil
+	}
+	resp, err := c.fetch()
+	if err != nil {
+		return "", err
+	}
+	if resp.AccessToken == "" {
+		return "", fmt.Errorf("empty access token in the token response")
+	}
+	c.accessToken = resp.AccessToken
+	c.expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	return c.accessToken, nil
+}
+
+// serviceAccountKey is the JSON key file of a Google service account.
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// newServiceAccountTokenSource exchanges a JWT signed by the service account key for the access tokens.
+func newServiceAccountTokenSource(client *http.Client, keyJSON []byte) (tokenSource, error) {
+	key := &serviceAccountKey{}
+	if err := json.Unmarshal(keyJSON, key); err != nil {
+		return nil, errors.Wrap(err, "failed to parse service account key")
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("unsupported credential type %v, only service account keys are supported", key.Type)
+	}
+	if key.ClientEmail == "" {
+		return nil, fmt.Errorf("missing client_email in service account key")
+	}
+	privateKey, err := parsePrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse private key of service account %v", key.ClientEmail)
+	}
+	tokenURI := key.TokenURI
+	if tokenURI == "" {
+		tokenURI = defaultTokenURI
+	}
+
+	return &cachedTokenSource{
+		fetch: func() (*tokenResponse, error) {
+			assertion, err := signJWT(key, privateKey, tokenURI)
+			if err != nil {
+				return nil, err
+			}
+			form := url.Values{"grant_type": {jwtBearerGrantType}, "assertion": {assertion}}
+			req, err := http.NewRequest(http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return fetchToken(client, req)
+		},
+	}, nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM data")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+func signJWT(key *serviceAccountKey, privateKey *rsa.PrivateKey, audience string) (string, error) {
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if key.PrivateKeyID != "" {
+		header["kid"] = key.PrivateKeyID
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": storageScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+
+	encode := func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(data), nil
+	}
+	encodedHeader, err := encode(header)
+	if err != nil {
+		return "", err
+	}
+	encodedClaims, err := encode(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodedHeader + "." + encodedClaims
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign JWT")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// newMetadataTokenSource gets the access tokens of the attached service account from the metadata server,
+// which is how the GKE workload identity and the GCE instances authenticate.
+func newMetadataTokenSource(client *http.Client) tokenSource {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	tokenURL := fmt.Sprintf("http://%s/computeMetadata/v1/instance/service-accounts/default/token?%s",
+		host, url.Values{"scopes": {storageScope}}.Encode())
+
+	return &cachedTokenSource{
+		fetch: func() (*tokenResponse, error) {
+			req, err := http.NewRequest(http.MethodGet, tokenURL, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Metadata-Flavor", "Google")
+			return fetchToken(client, req)
+		},
+	}
+}
+
+func fetchToken(client *http.Client, req *http.Request) (*tokenResponse, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get access token from %v", req.URL.Host)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read access token from %v", req.URL.Host)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get access token from %v: %v %v", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	token := &tokenResponse{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse access token from %v", req.URL.Host)
+	}
+	return token, nil
+}
@@ -0,0 +1,457 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore"
+	bhttp "github.com/longhorn/backupstore/http"
+	"github.com/longhorn/backupstore/types"
+)
+
+const (
+	defaultEndpoint = "https://storage.googleapis.com"
+
+	// resumableUploadThreshold is the size above which the objects are uploaded in resumable sessions,
+	// the smaller objects are uploaded in a single multipart request
+	resumableUploadThreshold = 8 << 20
+	// resumableChunkSize must be a multiple of 256 KiB
+	resumableChunkSize = 16 << 20
+	// resumableChunkRetries is the number of times a chunk upload is resumed after a failure
+	resumableChunkRetries = 3
+)
+
+var errNotFound = fmt.Errorf("object not found")
+
+type service struct {
+	Bucket   string
+	Region   string
+	Endpoint string
+	Client   *http.Client
+
+	// getenv looks up the settings of the backup target, see backupstore.TargetEnv
+	getenv func(string) string
+	tokens tokenSource
+}
+
+// object is the object resource of the GCS JSON API.
+type object struct {
+	Name            string    `json:"name"`
+	Size            int64     `json:"size,string"`
+	Updated         time.Time `json:"updated"`
+	ContentType     string    `json:"contentType,omitempty"`
+	ContentEncoding string    `json:"contentEncoding,omitempty"`
+}
+
+// objectMetadata is the metadata of the uploaded object.
+type objectMetadata struct {
+	Name            string `json:"name"`
+	ContentType     string `json:"contentType,omitempty"`
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+}
+
+type listResponse struct {
+	Items         []*object `json:"items"`
+	Prefixes      []string  `json:"prefixes"`
+	NextPageToken string    `json:"nextPageToken"`
+}
+
+type rewriteResponse struct {
+	Done         bool   `json:"done"`
+	RewriteToken string `json:"rewriteToken"`
+}
+
+func newService(u *url.URL, credential map[string]string) (*service, error) {
+	s := &service{getenv: backupstore.TargetEnv(credential)}
+	if u.User != nil {
+		s.Region = u.Host
+		s.Bucket = u.User.Username()
+	} else {
+		s.Bucket = u.Host
+	}
+
+	s.Endpoint = strings.TrimRight(s.getenv(types.GCSEndpoint), "/")
+	if s.Endpoint == "" {
+		s.Endpoint = defaultEndpoint
+	}
+
+	var customCerts []byte
+	if certs := s.getenv(types.GCSCert); certs != "" {
+		customCerts = []byte(certs)
+	}
+	client, err := bhttp.GetClientWithCustomCerts(customCerts)
+	if err != nil {
+		return nil, err
+	}
+	s.Client = client
+
+	s.tokens, err = s.newTokenSource()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newTokenSource authenticates with the service account key of the backup target, then the key file in
+// GOOGLE_APPLICATION_CREDENTIALS, and falls back to the workload identity of the metadata server.
+func (s *service) newTokenSource() (tokenSource, error) {
+	if keyJSON := s.getenv(types.GCSServiceAccount); keyJSON != "" {
+		return newServiceAccountTokenSource(s.Client, []byte(keyJSON))
+	}
+	if keyFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); keyFile != "" {
+		keyJSON, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read service account key file %v", keyFile)
+		}
+		return newServiceAccountTokenSource(s.Client, keyJSON)
+	}
+	return newMetadataTokenSource(s.Client), nil
+}
+
+func (s *service) objectURL(name string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", s.Endpoint, url.PathEscape(s.Bucket), url.PathEscape(name))
+}
+
+// do sends the authorized request, the response body is closed and an error is returned unless the
+// status is one of the expected ones.
+func (s *service) do(req *http.Request, expected ...int) (*http.Response, error) {
+	token, err := s.tokens.token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, fmt.Errorf("GCS error: %v %v %v", req.Method, resp.Status, strings.TrimSpace(string(body)))
+}
+
+func (s *service) doJSON(req *http.Request, result interface{}) error {
+	resp, err := s.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (s *service) listObjects(prefix, delimiter string) ([]*object, []string, error) {
+	var (
+		objects  []*object
+		prefixes []string
+	)
+	query := url.Values{"prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	for {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s/o?%s",
+			s.Endpoint, url.PathEscape(s.Bucket), query.Encode()), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		page := &listResponse{}
+		if err := s.doJSON(req, page); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to list objects with prefix %v", prefix)
+		}
+		objects = append(objects, page.Items...)
+		prefixes = append(prefixes, page.Prefixes...)
+		if page.NextPageToken == "" {
+			return objects, prefixes, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (s *service) getObjectMetadata(name string) (*object, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	obj := &object{}
+	if err := s.doJSON(req, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *service) getObject(name string) (io.ReadCloser, error) {
+	return s.getObjectRange(name, 0, -1)
+}
+
+// getObjectRange gets the data range of the object, a negative length reads the data until the end.
+func (s *service) getObjectRange(name string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	// Explicitly accept the gzip encoding, otherwise GCS transcodes the objects stored with the gzip
+	// Content-Encoding and ignores the range
+	req.Header.Set("Accept-Encoding", "gzip")
+	if offset > 0 || length > 0 {
+		byteRange := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			byteRange += strconv.FormatInt(offset+length-1, 10)
+		}
+		req.Header.Set("Range", byteRange)
+	}
+	resp, err := s.do(req, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get object %v", name)
+	}
+	return resp.Body, nil
+}
+
+// putObject uploads the object with the given Content-Type and Content-Encoding, the empty values are omitted.
+func (s *service) putObject(name string, reader io.ReadSeeker, contentType, contentEncoding string) error {
+	offset, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	metadata := &objectMetadata{Name: name, ContentType: contentType, ContentEncoding: contentEncoding}
+	if end-offset > resumableUploadThreshold {
+		err = s.putObjectResumable(metadata, reader, offset, end-offset)
+	} else {
+		err = s.putObjectMultipart(metadata, reader)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to put object %v", name)
+	}
+	return nil
+}
+
+func (s *service) uploadURL(uploadType string) string {
+	return fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=%s", s.Endpoint, url.PathEscape(s.Bucket), uploadType)
+}
+
+// putObjectMultipart uploads the metadata and the data of the object in a single request.
+func (s *service) putObjectMultipart(metadata *objectMetadata, reader io.Reader) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	metadataPart, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(metadataPart).Encode(metadata); err != nil {
+		return err
+	}
+	mediaType := metadata.ContentType
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	mediaPart, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {mediaType}})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(mediaPart, reader); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.uploadURL("multipart"), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+writer.Boundary())
+	resp, err := s.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// putObjectResumable uploads the object in chunks of a resumable session. A failed chunk is resumed from
+// the offset persisted by GCS instead of uploading the whole object again.
+func (s *service) putObjectResumable(metadata *objectMetadata, reader io.ReadSeeker, base, size int64) error {
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.uploadURL("resumable"), bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	resp, err := s.do(req, http.StatusOK)
+	if err != nil {
+		return errors.Wrap(err, "failed to start resumable upload")
+	}
+	resp.Body.Close()
+	sessionURL := resp.Header.Get("Location")
+	if sessionURL == "" {
+		return fmt.Errorf("missing session URL of resumable upload")
+	}
+
+	var uploaded int64
+	retries := 0
+	for uploaded < size {
+		chunk := int64(resumableChunkSize)
+		if size-uploaded < chunk {
+			chunk = size - uploaded
+		}
+		next, done, err := s.putChunk(sessionURL, reader, base, uploaded, chunk, size)
+		if err != nil {
+			if retries >= resumableChunkRetries {
+				return err
+			}
+			retries++
+			log.WithError(err).Warnf("Failed to upload chunk at offset %v of object %v, resuming the upload", uploaded, metadata.Name)
+			if next, done, err = s.queryUploadStatus(sessionURL, size); err != nil {
+				return err
+			}
+		} else {
+			retries = 0
+		}
+		if done {
+			return nil
+		}
+		uploaded = next
+	}
+	return nil
+}
+
+// putChunk uploads the chunk and returns the offset of the next chunk persisted by GCS.
+func (s *service) putChunk(sessionURL string, reader io.ReadSeeker, base, offset, length, size int64) (int64, bool, error) {
+	if _, err := reader.Seek(base+offset, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	req, err := http.NewRequest(http.MethodPut, sessionURL, io.LimitReader(reader, length))
+	if err != nil {
+		return 0, false, err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	return s.doChunkRequest(req)
+}
+
+func (s *service) queryUploadStatus(sessionURL string, size int64) (int64, bool, error) {
+	req, err := http.NewRequest(http.MethodPut, sessionURL, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	return s.doChunkRequest(req)
+}
+
+func (s *service) doChunkRequest(req *http.Request) (int64, bool, error) {
+	resp, err := s.do(req, http.StatusOK, http.StatusCreated, http.StatusPermanentRedirect)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		return 0, true, nil
+	}
+	// The range of the persisted data is in the form of "bytes=0-N", it's missing if nothing is persisted
+	persisted := resp.Header.Get("Range")
+	if persisted == "" {
+		return 0, false, nil
+	}
+	last, err := strconv.ParseInt(persisted[strings.LastIndex(persisted, "-")+1:], 10, 64)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "invalid persisted range %v of resumable upload", persisted)
+	}
+	return last + 1, false, nil
+}
+
+func (s *service) deleteObject(name string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		if err == errNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// deleteObjects deletes all the objects with the given prefix.
+func (s *service) deleteObjects(prefix string) error {
+	objects, _, err := s.listObjects(prefix, "")
+	if err != nil {
+		return errors.Wrapf(err, "failed to list objects with prefix %v before removing them", prefix)
+	}
+
+	var deletionFailures []string
+	for _, obj := range objects {
+		if err := s.deleteObject(obj.Name); err != nil {
+			log.WithError(err).Errorf("Failed to delete object: %v", obj.Name)
+			deletionFailures = append(deletionFailures, obj.Name)
+		}
+	}
+	if len(deletionFailures) > 0 {
+		return fmt.Errorf("failed to delete objects %v", deletionFailures)
+	}
+	return nil
+}
+
+// copyObject copies the object inside the bucket. The large objects are rewritten in multiple calls, each
+// continuing with the token of the previous one.
+func (s *service) copyObject(src, dst string) error {
+	rewriteURL := fmt.Sprintf("%s/rewriteTo/b/%s/o/%s", s.objectURL(src), url.PathEscape(s.Bucket), url.PathEscape(dst))
+	rewriteToken := ""
+	for {
+		target := rewriteURL
+		if rewriteToken != "" {
+			target += "?" + url.Values{"rewriteToken": {rewriteToken}}.Encode()
+		}
+		req, err := http.NewRequest(http.MethodPost, target, strings.NewReader("{}"))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		result := &rewriteResponse{}
+		if err := s.doJSON(req, result); err != nil {
+			return errors.Wrapf(err, "failed to copy object %v to %v", src, dst)
+		}
+		if result.Done {
+			return nil
+		}
+		rewriteToken = result.RewriteToken
+	}
+}
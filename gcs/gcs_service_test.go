@@ -0,0 +1,369 @@
+package gcs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+)
+
+// fakeGCS implements the subset of the GCS JSON API used by the driver.
+type fakeGCS struct {
+	t         *testing.T
+	publicKey *rsa.PublicKey
+
+	lock     sync.Mutex
+	objects  map[string]*fakeObject
+	sessions map[string]*fakeSession
+	// failChunks is the number of the chunk uploads failing after persisting half of the chunk
+	failChunks int
+}
+
+type fakeObject struct {
+	object
+	data []byte
+}
+
+type fakeSession struct {
+	metadata objectMetadata
+	data     []byte
+}
+
+func newFakeGCS(t *testing.T, publicKey *rsa.PublicKey) *fakeGCS {
+	return &fakeGCS{
+		t:         t,
+		publicKey: publicKey,
+		objects:   map[string]*fakeObject{},
+		sessions:  map[string]*fakeSession{},
+	}
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.URL.Path == "/token" {
+		f.serveToken(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer fake-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, "/upload/storage/v1/b/bucket/o"):
+		f.serveUpload(w, r)
+	case strings.HasPrefix(path, "/session/"):
+		f.serveSession(w, r, strings.TrimPrefix(path, "/session/"))
+	case path == "/storage/v1/b/bucket/o":
+		f.serveList(w, r)
+	case strings.HasPrefix(path, "/storage/v1/b/bucket/o/"):
+		name := strings.TrimPrefix(path, "/storage/v1/b/bucket/o/")
+		if parts := strings.SplitN(name, "/rewriteTo/b/bucket/o/", 2); len(parts) == 2 {
+			f.serveRewrite(w, unescape(f.t, parts[0]), unescape(f.t, parts[1]))
+			return
+		}
+		f.serveObject(w, r, unescape(f.t, name))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func unescape(t *testing.T, name string) string {
+	unescaped, err := url.PathUnescape(name)
+	assert.NoError(t, err)
+	return unescaped
+}
+
+func (f *fakeGCS) serveToken(w http.ResponseWriter, r *http.Request) {
+	assert.NoError(f.t, r.ParseForm())
+	assert.Equal(f.t, jwtBearerGrantType, r.PostForm.Get("grant_type"))
+
+	parts := strings.Split(r.PostForm.Get("assertion"), ".")
+	assert.Len(f.t, parts, 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(f.t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(f.t, rsa.VerifyPKCS1v15(f.publicKey, crypto.SHA256, digest[:], signature))
+
+	claims := map[string]interface{}{}
+	data, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(f.t, json.Unmarshal(data, &claims))
+	assert.Equal(f.t, "backup@project.iam.gserviceaccount.com", claims["iss"])
+	assert.Equal(f.t, storageScope, claims["scope"])
+
+	json.NewEncoder(w).Encode(&tokenResponse{AccessToken: "fake-token", ExpiresIn: 3600, TokenType: "Bearer"})
+}
+
+func (f *fakeGCS) save(metadata objectMetadata, data []byte) {
+	f.objects[metadata.Name] = &fakeObject{
+		object: object{
+			Name:            metadata.Name,
+			Size:            int64(len(data)),
+			Updated:         time.Now().UTC(),
+			ContentType:     metadata.ContentType,
+			ContentEncoding: metadata.ContentEncoding,
+		},
+		data: data,
+	}
+}
+
+func (f *fakeGCS) serveUpload(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("uploadType") {
+	case "multipart":
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		assert.NoError(f.t, err)
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		metadataPart, err := reader.NextPart()
+		assert.NoError(f.t, err)
+		metadata := objectMetadata{}
+		assert.NoError(f.t, json.NewDecoder(metadataPart).Decode(&metadata))
+		mediaPart, err := reader.NextPart()
+		assert.NoError(f.t, err)
+		data, err := io.ReadAll(mediaPart)
+		assert.NoError(f.t, err)
+		f.save(metadata, data)
+		json.NewEncoder(w).Encode(f.objects[metadata.Name].object)
+	case "resumable":
+		session := &fakeSession{}
+		assert.NoError(f.t, json.NewDecoder(r.Body).Decode(&session.metadata))
+		id := strconv.Itoa(len(f.sessions))
+		f.sessions[id] = session
+		w.Header().Set("Location", "http://"+r.Host+"/session/"+id)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (f *fakeGCS) serveSession(w http.ResponseWriter, r *http.Request, id string) {
+	session := f.sessions[id]
+	var start, end, total int64
+	contentRange := r.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(contentRange, "bytes */%d", &total); err != nil {
+		_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total)
+		assert.NoError(f.t, err)
+		data, err := io.ReadAll(r.Body)
+		assert.NoError(f.t, err)
+		assert.Equal(f.t, int64(len(session.data)), start)
+		if f.failChunks > 0 {
+			f.failChunks--
+			session.data = append(session.data, data[:len(data)/2]...)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		session.data = append(session.data, data...)
+	}
+	if int64(len(session.data)) == total {
+		f.save(session.metadata, session.data)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if len(session.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.data)-1))
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func (f *fakeGCS) serveList(w http.ResponseWriter, r *http.Request) {
+	prefix, delimiter := r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter")
+	names := []string{}
+	for name := range f.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Each page holds one item to exercise the pagination
+	resp := &listResponse{}
+	seenPrefixes := map[string]bool{}
+	started := r.URL.Query().Get("pageToken") == ""
+	for _, name := range names {
+		if !started {
+			started = name == r.URL.Query().Get("pageToken")
+			continue
+		}
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				p := name[:len(prefix)+i+1]
+				if !seenPrefixes[p] {
+					seenPrefixes[p] = true
+					resp.Prefixes = append(resp.Prefixes, p)
+				}
+				continue
+			}
+		}
+		if len(resp.Items) == 1 {
+			resp.NextPageToken = resp.Items[0].Name
+			break
+		}
+		resp.Items = append(resp.Items, &f.objects[name].object)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeGCS) serveObject(w http.ResponseWriter, r *http.Request, name string) {
+	obj, ok := f.objects[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		if r.URL.Query().Get("alt") != "media" {
+			json.NewEncoder(w).Encode(obj.object)
+			return
+		}
+		assert.Equal(f.t, "gzip", r.Header.Get("Accept-Encoding"))
+		data := obj.data
+		if byteRange := r.Header.Get("Range"); byteRange != "" {
+			var start, end int64
+			if _, err := fmt.Sscanf(byteRange, "bytes=%d-%d", &start, &end); err != nil {
+				end = int64(len(data)) - 1
+			}
+			data = data[start : end+1]
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		w.Write(data)
+	}
+}
+
+func (f *fakeGCS) serveRewrite(w http.ResponseWriter, src, dst string) {
+	obj, ok := f.objects[src]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.save(objectMetadata{Name: dst, ContentType: obj.ContentType, ContentEncoding: obj.ContentEncoding}, obj.data)
+	json.NewEncoder(w).Encode(&rewriteResponse{Done: true})
+}
+
+func newTestService(t *testing.T) (*service, *fakeGCS) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	fake := newFakeGCS(t, &privateKey.PublicKey)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	keyJSON, err := json.Marshal(&serviceAccountKey{
+		Type:        "service_account",
+		ClientEmail: "backup@project.iam.gserviceaccount.com",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		})),
+		TokenURI: server.URL + "/token",
+	})
+	assert.NoError(t, err)
+
+	u, err := url.Parse("gcs://bucket@us-east1/backupstore")
+	assert.NoError(t, err)
+	s, err := newService(u, map[string]string{
+		types.GCSServiceAccount: string(keyJSON),
+		types.GCSEndpoint:       server.URL,
+	})
+	assert.NoError(t, err)
+	return s, fake
+}
+
+func TestService(t *testing.T) {
+	assert := assert.New(t)
+
+	s, _ := newTestService(t)
+	assert.Equal("bucket", s.Bucket)
+	assert.Equal("us-east1", s.Region)
+
+	driver := &BackupStoreDriver{path: "backupstore", service: s}
+	body := []byte("this is only a test file")
+	for _, name := range []string{"dir/dir1/file_1", "dir/dir1/file_2", "dir/dir2/file_1", "dir/file_3"} {
+		assert.NoError(driver.Write(name, bytes.NewReader(body)))
+	}
+
+	result, err := driver.List("dir")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"file_3", "dir1", "dir2"}, result)
+	result, err = driver.ListPrefix("dir/dir1/")
+	assert.NoError(err)
+	assert.Equal([]string{"dir/dir1/file_1", "dir/dir1/file_2"}, result)
+
+	assert.Equal(int64(len(body)), driver.FileSize("dir/file_3"))
+	assert.False(driver.FileTime("dir/file_3").IsZero())
+	assert.Equal(int64(-1), driver.FileSize("dir/missing"))
+
+	rc, err := driver.ReadRange("dir/file_3", 5, 2)
+	assert.NoError(err)
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	assert.NoError(err)
+	assert.Equal(body[5:7], data)
+
+	assert.NoError(driver.Copy("dir/file_3", "dir/file_4"))
+	rc, err = driver.Read("dir/file_4")
+	assert.NoError(err)
+	data, err = io.ReadAll(rc)
+	rc.Close()
+	assert.NoError(err)
+	assert.Equal(body, data)
+
+	// The upload of the missing file fails
+	assert.Error(driver.Upload(filepath.Join(t.TempDir(), "missing"), "dir/file_5"))
+	assert.False(driver.FileExists("dir/file_5"))
+
+	assert.NoError(driver.Remove("dir/dir1"))
+	result, err = driver.List("dir")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"file_3", "file_4", "dir2"}, result)
+}
+
+func TestResumableUpload(t *testing.T) {
+	assert := assert.New(t)
+
+	s, fake := newTestService(t)
+	driver := &BackupStoreDriver{path: "backupstore", service: s}
+
+	// The interrupted chunk is resumed from the persisted offset
+	fake.failChunks = 1
+	data := bytes.Repeat([]byte("0123456789abcdef"), (resumableChunkSize+resumableChunkSize/2)/16)
+	err := driver.WriteWithMetadata("blocks/large", bytes.NewReader(data), &backupstore.ObjectMetadata{
+		ContentType:     "application/octet-stream",
+		ContentEncoding: "gzip",
+	})
+	assert.NoError(err)
+	assert.Len(fake.sessions, 1)
+
+	metadata, err := driver.GetMetadata("blocks/large")
+	assert.NoError(err)
+	assert.Equal("gzip", metadata.ContentEncoding)
+	assert.Equal(int64(len(data)), driver.FileSize("blocks/large"))
+	assert.True(bytes.Equal(data, fake.objects["backupstore/blocks/large"].data))
+}
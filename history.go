@@ -0,0 +1,256 @@
+package backupstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/longhorn/backupstore/logging"
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	HISTORY_DIRECTORY     = "history"
+	HISTORY_RECORD_SUFFIX = ".rec"
+
+	HistoryEventCreated = HistoryEvent("created")
+	HistoryEventDeleted = HistoryEvent("deleted")
+)
+
+type HistoryEvent string
+
+// HistoryRecord is a lightweight creation or tombstone record of a backup. The records are kept outside
+// of the volume directory so they survive the deletion of the backups, they're removed along with the backup
+// volume or pruned by PruneBackupHistory.
+type HistoryRecord struct {
+	BackupName string
+	VolumeName string
+	Event      HistoryEvent
+	Time       time.Time
+	// ClusterID and NodeID identify the client creating or deleting the backup, they're only kept in the
+	// content of the record
+	ClusterID string `json:",omitempty"`
+	NodeID    string `json:",omitempty"`
+}
+
+func getHistoryPath(volumeName string) string {
+	return filepath.Join(backupstoreBase, HISTORY_DIRECTORY, volumeName) + "/"
+}
+
+// getHistoryRecordName encodes the record into the file name, so the history can be replayed by listing
+// the directory without reading each record.
+func getHistoryRecordName(record *HistoryRecord) string {
+	return fmt.Sprintf("%019d_%v_%v", record.Time.UnixNano(), record.Event, record.BackupName)
+}
+
+func getHistoryRecordFilePath(record *HistoryRecord) string {
+	return filepath.Join(getHistoryPath(record.VolumeName), getHistoryRecordName(record)+HISTORY_RECORD_SUFFIX)
+}
+
+func parseHistoryRecordName(volumeName, name string) (*HistoryRecord, error) {
+	parts := strings.SplitN(name, "_", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid history record name %v", name)
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid timestamp in history record name %v", name)
+	}
+	event := HistoryEvent(parts[1])
+	if event != HistoryEventCreated && event != HistoryEventDeleted {
+		return nil, fmt.Errorf("invalid event in history record name %v", name)
+	}
+	return &HistoryRecord{
+		BackupName: parts[2],
+		VolumeName: volumeName,
+		Event:      event,
+		Time:       time.Unix(0, ts).UTC(),
+	}, nil
+}
+
+// recordBackupHistory saves a history record. Failing to save it doesn't fail the backup operation.
+func recordBackupHistory(driver BackupStoreDriver, volumeName, backupName string, event HistoryEvent) {
+	record := &HistoryRecord{
+		BackupName: backupName,
+		VolumeName: volumeName,
+		Event:      event,
+		Time:       util.GetClock().Now().UTC(),
+		ClusterID:  GetClientID(),
+		NodeID:     GetNodeID(),
+	}
+	if err := SaveConfigInBackupStore(driver, getHistoryRecordFilePath(record), record); err != nil {
+		log.WithError(err).WithFields(logrus.Fields{
+			LogFieldBackup: backupName,
+			LogFieldVolume: volumeName,
+		}).Warnf("Failed to record backup %v event in backupstore", event)
+	}
+}
+
+// removeBackupHistory removes the history records of the deleted backup volume. Failing to remove them doesn't
+// fail the deletion.
+func removeBackupHistory(driver BackupStoreDriver, volumeName string) {
+	if err := driver.Remove(getHistoryPath(volumeName)); err != nil {
+		log.WithError(err).WithField(LogFieldVolume, volumeName).Warn("Failed to remove backup history in backupstore")
+	}
+}
+
+func getHistoryRecordsForVolume(driver BackupStoreDriver, volumeName string) ([]*HistoryRecord, error) {
+	records := []*HistoryRecord{}
+	fileList, err := driver.List(getHistoryPath(volumeName))
+	if err != nil {
+		// path doesn't exist
+		return records, nil
+	}
+	for _, name := range util.ExtractNames(fileList, "", HISTORY_RECORD_SUFFIX) {
+		record, err := parseHistoryRecordName(volumeName, name)
+		if err != nil {
+			log.WithError(err).Warnf("Skipped invalid history record of volume %v", volumeName)
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+// ListBackups returns the names of the backups of the volume which existed at the given time.
+// It replays the creation and tombstone records, backups created before the history was recorded
+// are included if they still exist and were created before the given time.
+func ListBackups(volumeURL string, asOf time.Time) ([]string, error) {
+	driver, err := GetBackupStoreDriver(volumeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	_, volumeName, _, err := DecodeBackupURL(volumeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := getHistoryRecordsForVolume(driver, volumeName)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := map[string]bool{}
+	existing := map[string]bool{}
+	for _, record := range records {
+		recorded[record.BackupName] = true
+		if record.Time.After(asOf) {
+			continue
+		}
+		switch record.Event {
+		case HistoryEventCreated:
+			existing[record.BackupName] = true
+		case HistoryEventDeleted:
+			delete(existing, record.BackupName)
+		}
+	}
+
+	backupNames, err := getBackupNamesForVolume(driver, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	for _, backupName := range backupNames {
+		if recorded[backupName] {
+			continue
+		}
+		backup, err := loadBackup(driver, backupName, volumeName)
+		if err != nil || isBackupInProgress(backup) {
+			continue
+		}
+		createdTime, err := util.ParseTimestamp(backup.CreatedTime)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to parse created time of backup %v", backupName)
+			continue
+		}
+		if !createdTime.After(asOf) {
+			existing[backupName] = true
+		}
+	}
+
+	result := make([]string, 0, len(existing))
+	for backupName := range existing {
+		result = append(result, backupName)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// GetBackupHistory returns the history records of the backup, or of all the backups of the volume if the backup
+// name is empty, in the time order. The records are loaded to include the clients creating and deleting the
+// backups for the audit.
+func GetBackupHistory(volumeURL, backupName string) ([]*HistoryRecord, error) {
+	driver, err := GetBackupStoreDriver(volumeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	_, volumeName, _, err := DecodeBackupURL(volumeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := getHistoryRecordsForVolume(driver, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	result := []*HistoryRecord{}
+	for _, record := range records {
+		if backupName != "" && record.BackupName != backupName {
+			continue
+		}
+		loaded := &HistoryRecord{}
+		if err := LoadConfigInBackupStore(driver, getHistoryRecordFilePath(record), loaded); err != nil {
+			log.WithError(err).Warnf("Failed to load history record %v of volume %v", getHistoryRecordName(record), volumeName)
+		} else {
+			record.ClusterID = loaded.ClusterID
+			record.NodeID = loaded.NodeID
+		}
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+// PruneBackupHistory removes the history records of the volume older than the given time, and returns the number
+// of the removed records. ListBackups cannot tell the backups deleted before the time afterwards.
+func PruneBackupHistory(volumeURL string, before time.Time) (int, error) {
+	driver, err := GetBackupStoreDriver(volumeURL)
+	if err != nil {
+		return 0, err
+	}
+	if err := CheckTargetMutable(driver, "prune backup history"); err != nil {
+		return 0, err
+	}
+
+	_, volumeName, _, err := DecodeBackupURL(volumeURL)
+	if err != nil {
+		return 0, err
+	}
+
+	records, err := getHistoryRecordsForVolume(driver, volumeName)
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, record := range records {
+		if !record.Time.Before(before) {
+			break
+		}
+		if err := driver.Remove(getHistoryRecordFilePath(record)); err != nil {
+			return pruned, errors.Wrapf(err, "failed to remove history record %v of volume %v",
+				getHistoryRecordName(record), volumeName)
+		}
+		pruned++
+	}
+	log.WithField(LogFieldVolume, volumeName).Infof("Pruned %v backup history records before %v", pruned, before)
+	return pruned, nil
+}
@@ -0,0 +1,130 @@
+package backupstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListBackupsAsOf(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{delay: time.Millisecond}
+	m.Init()
+	defer m.uninstall()
+
+	driver, err := GetBackupStoreDriver(mockDriverURL)
+	assert.NoError(err)
+
+	err = m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1"}`), 0644)
+	assert.NoError(err)
+
+	// backup-1 is created before the history is recorded
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","CreatedTime":"2021-06-07T08:00:00Z"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-3", "pvc-1"),
+		[]byte(`{"Name":"backup-3","VolumeName":"pvc-1","CreatedTime":"2021-06-07T12:00:00Z"}`), 0644)
+	assert.NoError(err)
+
+	base := time.Date(2021, 6, 7, 0, 0, 0, 0, time.UTC)
+	records := []*HistoryRecord{
+		{BackupName: "backup-2", VolumeName: "pvc-1", Event: HistoryEventCreated, Time: base.Add(9 * time.Hour)},
+		{BackupName: "backup-2", VolumeName: "pvc-1", Event: HistoryEventDeleted, Time: base.Add(11 * time.Hour)},
+		{BackupName: "backup-3", VolumeName: "pvc-1", Event: HistoryEventCreated, Time: base.Add(12 * time.Hour)},
+	}
+	for _, record := range records {
+		assert.NoError(SaveConfigInBackupStore(driver, getHistoryRecordFilePath(record), record))
+	}
+
+	volumeURL := EncodeBackupURL("", "pvc-1", mockDriverURL)
+	testCases := []struct {
+		asOf          time.Time
+		expectBackups []string
+	}{
+		{base.Add(7 * time.Hour), []string{}},
+		{base.Add(8 * time.Hour), []string{"backup-1"}},
+		{base.Add(10 * time.Hour), []string{"backup-1", "backup-2"}},
+		{base.Add(11 * time.Hour), []string{"backup-1"}},
+		{base.Add(13 * time.Hour), []string{"backup-1", "backup-3"}},
+	}
+	for _, tc := range testCases {
+		backups, err := ListBackups(volumeURL, tc.asOf)
+		assert.NoError(err)
+		assert.Equal(tc.expectBackups, backups, "as of %v", tc.asOf)
+	}
+}
+
+func TestGetBackupHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	SetClientID("cluster-a")
+	SetNodeID("node-1")
+	recordBackupHistory(m, "pvc-1", "backup-1", HistoryEventCreated)
+	recordBackupHistory(m, "pvc-1", "backup-2", HistoryEventCreated)
+	SetClientID("cluster-b")
+	SetNodeID("")
+	recordBackupHistory(m, "pvc-1", "backup-1", HistoryEventDeleted)
+	SetClientID("")
+
+	records, err := GetBackupHistory(EncodeBackupURL("", "pvc-1", mockDriverURL), "backup-1")
+	assert.NoError(err)
+	assert.Len(records, 2)
+	assert.Equal(HistoryEventCreated, records[0].Event)
+	assert.Equal("cluster-a", records[0].ClusterID)
+	assert.Equal("node-1", records[0].NodeID)
+	assert.Equal(HistoryEventDeleted, records[1].Event)
+	assert.Equal("cluster-b", records[1].ClusterID)
+	// The host name is recorded if the node is not specified
+	assert.NotEmpty(records[1].NodeID)
+
+	records, err = GetBackupHistory(EncodeBackupURL("", "pvc-1", mockDriverURL), "")
+	assert.NoError(err)
+	assert.Len(records, 3)
+}
+
+func TestPruneBackupHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	base := time.Date(2021, 6, 7, 0, 0, 0, 0, time.UTC)
+	records := []*HistoryRecord{
+		{BackupName: "backup-1", VolumeName: "pvc-1", Event: HistoryEventCreated, Time: base},
+		{BackupName: "backup-1", VolumeName: "pvc-1", Event: HistoryEventDeleted, Time: base.Add(time.Hour)},
+		{BackupName: "backup-2", VolumeName: "pvc-1", Event: HistoryEventCreated, Time: base.Add(2 * time.Hour)},
+	}
+	for _, record := range records {
+		assert.NoError(SaveConfigInBackupStore(m, getHistoryRecordFilePath(record), record))
+	}
+
+	volumeURL := EncodeBackupURL("", "pvc-1", mockDriverURL)
+	pruned, err := PruneBackupHistory(volumeURL, base.Add(2*time.Hour))
+	assert.NoError(err)
+	assert.Equal(2, pruned)
+	history, err := GetBackupHistory(volumeURL, "")
+	assert.NoError(err)
+	assert.Len(history, 1)
+	assert.Equal("backup-2", history[0].BackupName)
+
+	_, err = PruneBackupHistory(EncodeBackupURL("", "pvc-1", mockDriverURL+"?"+ReadOnlyTargetOption+"=true"), base.Add(3*time.Hour))
+	assert.Error(err)
+
+	// The history is removed along with the backup volume
+	assert.NoError(saveVolume(m, &Volume{Name: "pvc-1", Size: DEFAULT_BLOCK_SIZE}))
+	assert.NoError(DeleteBackupVolume("pvc-1", mockDriverURL))
+	assert.False(m.FileExists(getHistoryRecordFilePath(records[2])))
+	history, err = GetBackupHistory(volumeURL, "")
+	assert.NoError(err)
+	assert.Empty(history)
+}
@@ -5,9 +5,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"net/http"
-	"net/url"
-
-	"golang.org/x/net/http/httpproxy"
+	"strconv"
 )
 
 func getSystemCerts() *x509.CertPool {
@@ -32,23 +30,82 @@ func GetClientWithCustomCerts(certs []byte) (*http.Client, error) {
 }
 
 func GetClient(insecure bool, customCerts []byte) (*http.Client, error) {
+	return GetClientWithTLSOptions(&TLSOptions{CACerts: customCerts, InsecureSkipVerify: insecure})
+}
+
+// TLSOptions are the TLS options of the connections to a backup target, e.g. an on-premise appliance with a
+// private PKI.
+type TLSOptions struct {
+	// CACerts are the PEM encoded CA certificates trusted in addition to the system certificates
+	CACerts []byte
+	// ClientCert and ClientKey are the PEM encoded client certificate and key, both or neither are set
+	ClientCert []byte
+	ClientKey  []byte
+
+	InsecureSkipVerify bool
+}
+
+// TLSEnvKeys are the keys of the TLS options in the credential of a backup target.
+type TLSEnvKeys struct {
+	CACerts            string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify string
+}
+
+// LoadTLSOptions loads the TLS options of a backup target by the given getenv, which returns the values of the
+// credential of the target.
+func LoadTLSOptions(getenv func(string) string, keys TLSEnvKeys) (*TLSOptions, error) {
+	opts := &TLSOptions{}
+	if certs := getenv(keys.CACerts); certs != "" {
+		opts.CACerts = []byte(certs)
+	}
+	clientCert, clientKey := getenv(keys.ClientCert), getenv(keys.ClientKey)
+	if (clientCert == "") != (clientKey == "") {
+		return nil, fmt.Errorf("%v and %v must be both set", keys.ClientCert, keys.ClientKey)
+	}
+	if clientCert != "" {
+		opts.ClientCert, opts.ClientKey = []byte(clientCert), []byte(clientKey)
+	}
+	if value := getenv(keys.InsecureSkipVerify); value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v %v", keys.InsecureSkipVerify, value)
+		}
+		opts.InsecureSkipVerify = insecure
+	}
+	return opts, nil
+}
+
+func GetClientWithTLSOptions(opts *TLSOptions) (*http.Client, error) {
+	return GetClientWithOptions(opts, nil)
+}
+
+// GetClientWithOptions returns a client with the TLS options and the proxies of a backup target, the proxy
+// environment variables are used if the proxy options are nil.
+func GetClientWithOptions(opts *TLSOptions, proxyOpts *ProxyOptions) (*http.Client, error) {
 	certs := getSystemCerts()
 
 	// CA's are base 64 encoded and appended together
 	// can contain root CAs, intermediate CAs and certificates
-	if ok := certs.AppendCertsFromPEM(customCerts); customCerts != nil && !ok {
-		return nil, fmt.Errorf("failed to append custom certificates: %v", string(customCerts))
+	if ok := certs.AppendCertsFromPEM(opts.CACerts); opts.CACerts != nil && !ok {
+		return nil, fmt.Errorf("failed to append custom certificates: %v", string(opts.CACerts))
 	}
 
 	// create custom http client that trusts our custom certs
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
 	customTransport.TLSClientConfig = &tls.Config{
-		InsecureSkipVerify: insecure,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
 		RootCAs:            certs,
 	}
-	customTransport.Proxy = func(request *http.Request) (*url.URL, error) {
-		return httpproxy.FromEnvironment().ProxyFunc()(request.URL)
+	if opts.ClientCert != nil {
+		clientCert, err := tls.X509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		customTransport.TLSClientConfig.Certificates = []tls.Certificate{clientCert}
 	}
+	customTransport.Proxy = getProxyFunc(proxyOpts)
 	client := &http.Client{Transport: customTransport}
 	return client, nil
 }
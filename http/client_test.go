@@ -0,0 +1,163 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert returns a certificate signed by the parent, or a self-signed CA if the parent is nil.
+func newTestCert(t *testing.T, commonName string, parent *testCert, template *x509.Certificate) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: commonName}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func TestLoadTLSOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := TLSEnvKeys{CACerts: "CA", ClientCert: "CERT", ClientKey: "KEY", InsecureSkipVerify: "INSECURE"}
+	for _, tc := range []struct {
+		name     string
+		env      map[string]string
+		expected *TLSOptions
+		errMsg   string
+	}{
+		{"empty", map[string]string{}, &TLSOptions{}, ""},
+		{"all set", map[string]string{"CA": "ca", "CERT": "cert", "KEY": "key", "INSECURE": "true"},
+			&TLSOptions{CACerts: []byte("ca"), ClientCert: []byte("cert"), ClientKey: []byte("key"), InsecureSkipVerify: true}, ""},
+		{"client cert without key", map[string]string{"CERT": "cert"}, nil, "CERT and KEY must be both set"},
+		{"client key without cert", map[string]string{"KEY": "key"}, nil, "CERT and KEY must be both set"},
+		{"invalid insecure", map[string]string{"INSECURE": "maybe"}, nil, "invalid INSECURE maybe"},
+	} {
+		opts, err := LoadTLSOptions(func(key string) string { return tc.env[key] }, keys)
+		if tc.errMsg != "" {
+			assert.ErrorContains(err, tc.errMsg, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, opts, tc.name)
+	}
+}
+
+func TestGetClientWithOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	ca := newTestCert(t, "ca", nil, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	serverCert := newTestCert(t, "server", ca, &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientCert := newTestCert(t, "client", ca, &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	otherCA := newTestCert(t, "other", nil, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			_, _ = io.WriteString(w, "anonymous")
+			return
+		}
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	keyPair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	assert.NoError(err)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(opts *TLSOptions) (string, error) {
+		client, err := GetClientWithOptions(opts, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	for _, tc := range []struct {
+		name     string
+		opts     *TLSOptions
+		expected string
+		valid    bool
+	}{
+		{"untrusted server", &TLSOptions{}, "", false},
+		{"server of another CA", &TLSOptions{CACerts: otherCA.certPEM}, "", false},
+		{"custom CA", &TLSOptions{CACerts: ca.certPEM}, "anonymous", true},
+		{"client certificate", &TLSOptions{CACerts: ca.certPEM, ClientCert: clientCert.certPEM, ClientKey: clientCert.keyPEM},
+			"client", true},
+		{"insecure skip verify", &TLSOptions{InsecureSkipVerify: true}, "anonymous", true},
+	} {
+		body, err := get(tc.opts)
+		if !tc.valid {
+			assert.Error(err, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, body, tc.name)
+	}
+
+	_, err = GetClientWithOptions(&TLSOptions{CACerts: []byte("not a certificate")}, nil)
+	assert.ErrorContains(err, "failed to append custom certificates")
+	_, err = GetClientWithOptions(&TLSOptions{ClientCert: clientCert.certPEM, ClientKey: serverCert.keyPEM}, nil)
+	assert.ErrorContains(err, "failed to load client certificate")
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+// ProxyOptions are the outbound proxies of a backup target, so the targets reached through different proxies
+// don't share the process-wide proxy environment variables.
+type ProxyOptions struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+	// Username and Password authenticate to the proxy, unless the proxy URL has the user info already
+	Username string
+	Password string
+}
+
+// LoadProxyOptions loads the proxies in the credential of a backup target. It returns nil if there is no
+// credential, in which case the proxy environment variables are used.
+func LoadProxyOptions(credential map[string]string) *ProxyOptions {
+	if credential == nil {
+		return nil
+	}
+	return &ProxyOptions{
+		HTTPProxy:  credential[types.HTTPProxy],
+		HTTPSProxy: credential[types.HTTPSProxy],
+		NoProxy:    credential[types.NOProxy],
+		Username:   credential[types.ProxyUsername],
+		Password:   credential[types.ProxyPassword],
+	}
+}
+
+func getProxyFunc(opts *ProxyOptions) func(*http.Request) (*url.URL, error) {
+	if opts == nil {
+		// The environment variables are read by each request as they may be updated by the credential setup
+		return func(request *http.Request) (*url.URL, error) {
+			proxyURL, err := httpproxy.FromEnvironment().ProxyFunc()(request.URL)
+			return authenticateProxy(proxyURL, err, os.Getenv(types.ProxyUsername), os.Getenv(types.ProxyPassword))
+		}
+	}
+
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  opts.HTTPProxy,
+		HTTPSProxy: opts.HTTPSProxy,
+		NoProxy:    opts.NoProxy,
+	}).ProxyFunc()
+	return func(request *http.Request) (*url.URL, error) {
+		proxyURL, err := proxyFunc(request.URL)
+		return authenticateProxy(proxyURL, err, opts.Username, opts.Password)
+	}
+}
+
+// authenticateProxy adds the user info to the proxy URL, which is sent by the transport as the
+// Proxy-Authorization header.
+func authenticateProxy(proxyURL *url.URL, err error, username, password string) (*url.URL, error) {
+	if err != nil || proxyURL == nil || username == "" || proxyURL.User != nil {
+		return proxyURL, err
+	}
+	authenticated := *proxyURL
+	authenticated.User = url.UserPassword(username, password)
+	return &authenticated, nil
+}
@@ -0,0 +1,279 @@
+package https
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/backupstore"
+	bhttp "github.com/longhorn/backupstore/http"
+	"github.com/longhorn/backupstore/types"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "https"})
+)
+
+// ErrReadOnlyTarget is returned when the data of the read-only backup target is going to be modified.
+type ErrReadOnlyTarget struct {
+	DestURL   string
+	Operation string
+	Path      string
+}
+
+func (e *ErrReadOnlyTarget) Error() string {
+	return fmt.Sprintf("refusing to %v %v on read-only backup target %v", e.Operation, e.Path, e.DestURL)
+}
+
+// BackupStoreDriver defines the variables and method that backupstore will use.
+type BackupStoreDriver struct {
+	destURL string
+	path    string
+	getenv  func(string) string
+	service *service
+
+	// The lock files are kept in the memory, they only coordinate the restores of this process since no one
+	// can create backups on the published target
+	lock  sync.Mutex
+	locks map[string]*lockFile
+}
+
+type lockFile struct {
+	data     []byte
+	modified time.Time
+}
+
+const (
+	// KIND defines the kind of backupstore driver
+	KIND = "https"
+)
+
+func init() {
+	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("wrong driver dispatching %v to %v?", u.Scheme, KIND)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid URL. Must be https://host/path/")
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("invalid URL. The credential must not be specified in the HTTPS URL")
+	}
+
+	b := &BackupStoreDriver{
+		getenv: backupstore.TargetEnv(backupstore.GetTargetCredential(destURL)),
+		locks:  map[string]*lockFile{},
+	}
+	b.path = strings.Trim(u.Path, "/")
+
+	var customCerts []byte
+	if certs := b.getenv(types.HTTPSCert); certs != "" {
+		customCerts = []byte(certs)
+	}
+	client, err := bhttp.GetClientWithCustomCerts(customCerts)
+	if err != nil {
+		return nil, err
+	}
+	b.service = &service{
+		Endpoint: &url.URL{Scheme: u.Scheme, Host: u.Host},
+		Client:   client,
+	}
+
+	if _, err := b.service.list(b.path); err != nil && err != errNotFound {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + u.Host + "/" + b.path
+	log.Infof("Loaded read-only driver for %v", b.destURL)
+	return b, nil
+}
+
+// Kind returns the driver type
+func (b *BackupStoreDriver) Kind() string {
+	return KIND
+}
+
+// GetURL returns URL of the backup target
+func (b *BackupStoreDriver) GetURL() string {
+	return b.destURL
+}
+
+func (b *BackupStoreDriver) updatePath(path string) string {
+	return filepath.Join(b.path, path)
+}
+
+func isLockFile(path string) bool {
+	return strings.HasSuffix(path, backupstore.LOCK_SUFFIX) &&
+		filepath.Base(filepath.Dir(path)) == backupstore.LOCKS_DIRECTORY
+}
+
+func (b *BackupStoreDriver) getLock(path string) (*lockFile, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	lock, ok := b.locks[filepath.Clean(path)]
+	return lock, ok
+}
+
+func (b *BackupStoreDriver) readOnlyError(operation, path string) error {
+	return &ErrReadOnlyTarget{DestURL: b.destURL, Operation: operation, Path: path}
+}
+
+// List return items that on the backup target including prefixes
+func (b *BackupStoreDriver) List(listPath string) ([]string, error) {
+	if filepath.Base(listPath) == backupstore.LOCKS_DIRECTORY {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		result := []string{}
+		for path := range b.locks {
+			if filepath.Dir(path) == filepath.Clean(listPath) {
+				result = append(result, filepath.Base(path))
+			}
+		}
+		sort.Strings(result)
+		return result, nil
+	}
+
+	result, err := b.service.list(b.updatePath(listPath))
+	if err == errNotFound {
+		return []string{}, nil
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to list https")
+		return nil, err
+	}
+	return result, nil
+}
+
+// FileExists checks if file exists on the backup target
+func (b *BackupStoreDriver) FileExists(filePath string) bool {
+	return b.FileSize(filePath) >= 0
+}
+
+// FileSize return content length of the filePath on the backup target
+func (b *BackupStoreDriver) FileSize(filePath string) int64 {
+	if lock, ok := b.getLock(filePath); ok {
+		return int64(len(lock.data))
+	}
+	obj, err := b.service.head(b.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return obj.size
+}
+
+// FileTime returns file last modified time on the backup target
+func (b *BackupStoreDriver) FileTime(filePath string) time.Time {
+	if lock, ok := b.getLock(filePath); ok {
+		return lock.modified
+	}
+	obj, err := b.service.head(b.updatePath(filePath))
+	if err != nil {
+		return time.Time{}
+	}
+	return obj.modified.UTC()
+}
+
+// Remove deletes the lock files, the other files on the backup target are read-only
+func (b *BackupStoreDriver) Remove(path string) error {
+	if !isLockFile(path) {
+		return b.readOnlyError("remove", path)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.locks, filepath.Clean(path))
+	return nil
+}
+
+func (b *BackupStoreDriver) Read(src string) (io.ReadCloser, error) {
+	if lock, ok := b.getLock(src); ok {
+		return io.NopCloser(bytes.NewReader(lock.data)), nil
+	}
+	return b.service.getRange(b.updatePath(src), 0, -1)
+}
+
+// Write creates the lock files, the other files on the backup target are read-only
+func (b *BackupStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	if !isLockFile(dst) {
+		return b.readOnlyError("write", dst)
+	}
+	data, err := io.ReadAll(rs)
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.locks[filepath.Clean(dst)] = &lockFile{data: data, modified: time.Now().UTC()}
+	return nil
+}
+
+// WriteWithMetadata refuses to create the item on the read-only backup target
+func (b *BackupStoreDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *backupstore.ObjectMetadata) error {
+	return b.readOnlyError("write", dst)
+}
+
+// GetMetadata returns the HTTP metadata of the item on the backup target
+func (b *BackupStoreDriver) GetMetadata(filePath string) (*backupstore.ObjectMetadata, error) {
+	obj, err := b.service.head(b.updatePath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return &backupstore.ObjectMetadata{
+		ContentType:     obj.contentType,
+		ContentEncoding: obj.contentEncoding,
+	}, nil
+}
+
+// ReadRange reads the data range of the item on the backup target, a negative length reads the data until the end
+func (b *BackupStoreDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	return b.service.getRange(b.updatePath(src), offset, length)
+}
+
+// Upload refuses to create the item on the read-only backup target
+func (b *BackupStoreDriver) Upload(src, dst string) error {
+	return b.readOnlyError("upload", dst)
+}
+
+// Download gets a item data from the backup target
+func (b *BackupStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0700); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rc, err := b.Read(src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
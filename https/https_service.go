@@ -0,0 +1,205 @@
+package https
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errNotFound = fmt.Errorf("resource not found")
+
+	hrefPattern = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*["']([^"']+)["']`)
+)
+
+type service struct {
+	// Endpoint is the base URL of the published backup target, e.g. https://cdn.example.com/backups
+	Endpoint *url.URL
+	Client   *http.Client
+}
+
+// object is the HTTP metadata of a published file.
+type object struct {
+	size            int64
+	modified        time.Time
+	contentType     string
+	contentEncoding string
+}
+
+// indexEntry is an entry of the JSON directory index, e.g. the nginx autoindex_format json.
+type indexEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func (s *service) objectURL(name string, isDirectory bool) string {
+	u := *s.Endpoint
+	u.Path = path.Join("/", u.Path, name)
+	if isDirectory && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// do sends the request, the response body is closed and an error is returned unless the status is one of the
+// expected ones.
+func (s *service) do(method, target string, header http.Header, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, fmt.Errorf("HTTP error: %v %v %v %v", method, target, resp.Status, strings.TrimSpace(string(respBody)))
+}
+
+func (s *service) head(name string) (*object, error) {
+	resp, err := s.do(http.MethodHead, s.objectURL(name, false), nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	obj := &object{
+		size:            resp.ContentLength,
+		contentType:     resp.Header.Get("Content-Type"),
+		contentEncoding: resp.Header.Get("Content-Encoding"),
+	}
+	if obj.size < 0 {
+		return nil, fmt.Errorf("missing content length of %v", name)
+	}
+	if modified := resp.Header.Get("Last-Modified"); modified != "" {
+		if obj.modified, err = http.ParseTime(modified); err != nil {
+			return nil, fmt.Errorf("invalid last modified time %v of %v", modified, name)
+		}
+	}
+	return obj, nil
+}
+
+// getRange returns the data range of the file, a negative length reads the data until the end.
+func (s *service) getRange(name string, offset, length int64) (io.ReadCloser, error) {
+	if offset == 0 && length < 0 {
+		resp, err := s.do(http.MethodGet, s.objectURL(name, false), nil, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		if length == 0 {
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, err := s.do(http.MethodGet, s.objectURL(name, false), http.Header{"Range": {byteRange}},
+		http.StatusPartialContent, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		return resp.Body, nil
+	}
+
+	// The server ignored the range and returned the whole file
+	if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return resp.Body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, length), resp.Body}, nil
+}
+
+// list returns the names of the entries in the directory index, the names of the subdirectories don't end
+// with a slash.
+func (s *service) list(name string) ([]string, error) {
+	resp, err := s.do(http.MethodGet, s.objectURL(name, true), nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		return parseJSONIndex(data)
+	}
+	return parseHTMLIndex(data, resp.Request.URL.Path), nil
+}
+
+func parseJSONIndex(data []byte) ([]string, error) {
+	entries := []indexEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid JSON directory index: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if name := strings.TrimSuffix(entry.Name, "/"); name != "" && name != "." && name != ".." {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// parseHTMLIndex returns the entries linked by the generated directory index pages, e.g. the Apache and nginx
+// autoindex. The links to the parent directory, the other sites and the sorting queries are skipped.
+func parseHTMLIndex(data []byte, dirPath string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, match := range hrefPattern.FindAllSubmatch(data, -1) {
+		href, err := url.Parse(html.UnescapeString(string(match[1])))
+		if err != nil || href.IsAbs() || href.Host != "" || href.RawQuery != "" || href.Fragment != "" {
+			continue
+		}
+		name := href.Path
+		if strings.HasPrefix(name, "/") {
+			// The entries linked by the absolute paths, e.g. by IIS
+			if !strings.HasPrefix(name, dirPath) {
+				continue
+			}
+			name = strings.TrimPrefix(name, dirPath)
+		}
+		name = strings.TrimPrefix(strings.TrimSuffix(name, "/"), "./")
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,149 @@
+package https
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/client"
+	"github.com/longhorn/backupstore/types"
+
+	_ "github.com/longhorn/backupstore/vfs"
+)
+
+type memorySnapshot struct {
+	data []byte
+}
+
+func (s *memorySnapshot) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(s.data).ReadAt(p, off)
+}
+
+func (s *memorySnapshot) DataExtents() ([]client.Extent, error) {
+	return []client.Extent{{Offset: 0, Length: int64(len(s.data))}}, nil
+}
+
+func newPublishedTarget(t *testing.T, dir string) (string, map[string]string) {
+	server := httptest.NewTLSServer(http.StripPrefix("/published", http.FileServer(http.Dir(dir))))
+	t.Cleanup(server.Close)
+	certs := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return server.URL + "/published/", map[string]string{types.HTTPSCert: string(certs)}
+}
+
+func TestDriver(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "backupstore", "volumes", "a"), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "backupstore", "data.blk"), []byte("0123456789"), 0644))
+	destURL, credential := newPublishedTarget(t, dir)
+
+	// The server certificate isn't trusted without the custom certificate
+	_, err := initFunc(destURL)
+	assert.Error(err)
+
+	backupstore.SetTargetCredential(destURL, credential)
+	defer backupstore.SetTargetCredential(destURL, nil)
+	driver, err := initFunc(destURL)
+	assert.NoError(err)
+
+	names, err := driver.List("backupstore")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"volumes", "data.blk"}, names)
+	names, err = driver.List("backupstore/missing")
+	assert.NoError(err)
+	assert.Empty(names)
+
+	assert.True(driver.FileExists("backupstore/data.blk"))
+	assert.False(driver.FileExists("backupstore/missing.blk"))
+	assert.Equal(int64(10), driver.FileSize("backupstore/data.blk"))
+	assert.False(driver.FileTime("backupstore/data.blk").IsZero())
+
+	rc, err := driver.(backupstore.ObjectMetadataBackupStoreDriver).ReadRange("backupstore/data.blk", 3, 4)
+	assert.NoError(err)
+	data, err := io.ReadAll(rc)
+	assert.NoError(err)
+	rc.Close()
+	assert.Equal("3456", string(data))
+
+	// The backup data is read-only
+	err = driver.Write("backupstore/data.blk", bytes.NewReader([]byte("new")))
+	assert.ErrorContains(err, "read-only")
+	err = driver.Remove("backupstore/data.blk")
+	assert.ErrorContains(err, "read-only")
+	err = driver.Upload(filepath.Join(dir, "backupstore", "data.blk"), "backupstore/copy.blk")
+	assert.ErrorContains(err, "read-only")
+
+	// The lock files are kept in the memory
+	lockPath := "backupstore/volumes/a/locks/lock-1.lck"
+	assert.NoError(driver.Write(lockPath, bytes.NewReader([]byte("{}"))))
+	assert.True(driver.FileExists(lockPath))
+	names, err = driver.List("backupstore/volumes/a/locks/")
+	assert.NoError(err)
+	assert.Equal([]string{"lock-1.lck"}, names)
+	assert.NoError(driver.Remove(lockPath))
+	assert.False(driver.FileExists(lockPath))
+	assert.NoFileExists(filepath.Join(dir, lockPath))
+}
+
+func TestRestorePublishedBackup(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	c, err := client.New("vfs://"+dir, client.Options{})
+	assert.NoError(err)
+	data := make([]byte, 2*client.BlockSize)
+	copy(data, "first block")
+	copy(data[client.BlockSize:], "second block")
+	_, err = c.Backup(ctx, client.BackupOptions{
+		VolumeName:   "volume",
+		VolumeSize:   int64(len(data)),
+		SnapshotName: "snap1",
+		BackupName:   "backup1",
+		Source:       &memorySnapshot{data: data},
+	})
+	assert.NoError(err)
+
+	destURL, credential := newPublishedTarget(t, dir)
+	c, err = client.New(destURL, client.Options{Credential: credential})
+	assert.NoError(err)
+	defer backupstore.RemoveTargetCredential(destURL)
+
+	backups, err := c.ListBackups(ctx, "volume")
+	assert.NoError(err)
+	if assert.Len(backups, 1) {
+		assert.Equal("backup1", backups[0].Name)
+	}
+
+	path := filepath.Join(t.TempDir(), "volume.img")
+	assert.NoError(c.Restore(ctx, client.RestoreOptions{VolumeName: "volume", BackupName: "backup1", Path: path}))
+	restored, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal(data, restored)
+}
+
+func TestParseIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	page := []byte(`<html><body><a href="?C=N;O=D">Name</a><a href="../">Parent Directory</a>
+<a href="volumes/">volumes/</a><a href='backup_a.cfg'>backup_a.cfg</a><a href="https://example.com/">site</a>
+<a href="/backups/volume.cfg">volume.cfg</a><a href="/other/x.cfg">x.cfg</a><a href="volumes/">volumes/</a>
+<a href="a%20b.blk">a b.blk</a></body></html>`)
+	assert.Equal([]string{"volumes", "backup_a.cfg", "volume.cfg", "a b.blk"}, parseHTMLIndex(page, "/backups/"))
+
+	names, err := parseJSONIndex([]byte(`[{"name":"volumes","type":"directory"},{"name":"volume.cfg","type":"file"}]`))
+	assert.NoError(err)
+	assert.Equal([]string{"volumes", "volume.cfg"}, names)
+	_, err = parseJSONIndex([]byte(`<html>`))
+	assert.Error(err)
+}
@@ -0,0 +1,227 @@
+package backupstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ImmutableTargetOption is the backup target URL query parameter enabling the append-only mode,
+	// e.g. s3://bucket@region/path/?immutable=true
+	ImmutableTargetOption = "immutable"
+)
+
+// ErrImmutableTarget is returned when a delete or overwrite operation is attempted on a backup target
+// configured in immutable (append-only) mode.
+type ErrImmutableTarget struct {
+	DestURL   string
+	Operation string
+	Path      string
+}
+
+func (e *ErrImmutableTarget) Error() string {
+	target := e.DestURL
+	if e.Path != "" {
+		target = fmt.Sprintf("%v (%v)", e.DestURL, e.Path)
+	}
+	return fmt.Sprintf("refusing to %v on immutable backup target %v: data on this target can only be expired by "+
+		"the storage lifecycle or Object Lock retention policy, remove the %v option from the backup target URL "+
+		"if the deletion is intended", e.Operation, target, ImmutableTargetOption)
+}
+
+// IsImmutableTargetError returns true if the error is caused by a refused operation on an immutable target.
+func IsImmutableTargetError(err error) bool {
+	var immutableErr *ErrImmutableTarget
+	return errors.As(err, &immutableErr)
+}
+
+// ErrRetainedObject is returned by the drivers refusing to remove an object within its retention period, e.g. the
+// objects written with the S3 Object Lock retention.
+type ErrRetainedObject struct {
+	Path        string
+	RetainUntil time.Time
+}
+
+func (e *ErrRetainedObject) Error() string {
+	return fmt.Sprintf("refusing to remove %v retained until %v", e.Path, e.RetainUntil.UTC().Format(time.RFC3339))
+}
+
+// IsRetainedObjectError returns true if the error is caused by removing an object within its retention period.
+func IsRetainedObjectError(err error) bool {
+	var retainedErr *ErrRetainedObject
+	return errors.As(err, &retainedErr)
+}
+
+// immutableDriver wraps a driver and refuses to remove or overwrite backup data. Lock files are exempted
+// since they are required for coordinating the backup creation.
+type immutableDriver struct {
+	BackupStoreDriver
+}
+
+func isImmutableTargetURL(destURL string) (bool, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return false, err
+	}
+	value := u.Query().Get(ImmutableTargetOption)
+	if value == "" {
+		return false, nil
+	}
+	immutable, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %v option %v in backup target URL", ImmutableTargetOption, value)
+	}
+	return immutable, nil
+}
+
+// IsImmutableTarget returns true if the driver is configured in immutable (append-only) mode.
+func IsImmutableTarget(driver BackupStoreDriver) bool {
+	_, ok := findDriver[*immutableDriver](driver)
+	return ok
+}
+
+// CheckTargetMutable returns ErrImmutableTarget if the driver refuses the given delete or overwrite operation, or
+// ErrReadOnlyTarget if the driver refuses all the writes.
+func CheckTargetMutable(driver BackupStoreDriver, operation string) error {
+	if IsReadOnlyTarget(driver) {
+		return &ErrReadOnlyTarget{DestURL: driver.GetURL(), Operation: operation}
+	}
+	if IsImmutableTarget(driver) {
+		return &ErrImmutableTarget{DestURL: driver.GetURL(), Operation: operation}
+	}
+	return nil
+}
+
+func (d *immutableDriver) Unwrap() BackupStoreDriver {
+	return d.BackupStoreDriver
+}
+
+func (d *immutableDriver) Remove(path string) error {
+	if isLockFile(path) {
+		return d.BackupStoreDriver.Remove(path)
+	}
+	return &ErrImmutableTarget{DestURL: d.GetURL(), Operation: "remove", Path: path}
+}
+
+func (d *immutableDriver) RemoveAll(paths []string) error {
+	errs := map[string]error{}
+	lockFiles := []string{}
+	for _, path := range paths {
+		if isLockFile(path) {
+			lockFiles = append(lockFiles, path)
+			continue
+		}
+		errs[path] = &ErrImmutableTarget{DestURL: d.GetURL(), Operation: "remove", Path: path}
+	}
+	if len(lockFiles) > 0 {
+		for path, err := range getRemoveFailures(lockFiles, removeAll(d.BackupStoreDriver, lockFiles)) {
+			errs[path] = err
+		}
+	}
+	if len(errs) > 0 {
+		return &ErrRemoveFailed{Errors: errs}
+	}
+	return nil
+}
+
+func (d *immutableDriver) Write(dst string, rs io.ReadSeeker) error {
+	if d.isOverwriteProtected(dst) {
+		return &ErrImmutableTarget{DestURL: d.GetURL(), Operation: "overwrite", Path: dst}
+	}
+	return d.BackupStoreDriver.Write(dst, rs)
+}
+
+func (d *immutableDriver) Upload(src, dst string) error {
+	if d.isOverwriteProtected(dst) {
+		return &ErrImmutableTarget{DestURL: d.GetURL(), Operation: "overwrite", Path: dst}
+	}
+	return d.BackupStoreDriver.Upload(src, dst)
+}
+
+func (d *immutableDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *ObjectMetadata) error {
+	if d.isOverwriteProtected(dst) {
+		return &ErrImmutableTarget{DestURL: d.GetURL(), Operation: "overwrite", Path: dst}
+	}
+	return writeWithMetadata(d.BackupStoreDriver, dst, rs, metadata)
+}
+
+func (d *immutableDriver) GetMetadata(filePath string) (*ObjectMetadata, error) {
+	return getMetadata(d.BackupStoreDriver, filePath)
+}
+
+func (d *immutableDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	return readRange(d.BackupStoreDriver, src, offset, length)
+}
+
+func (d *immutableDriver) Copy(src, dst string) error {
+	if d.isOverwriteProtected(dst) {
+		return &ErrImmutableTarget{DestURL: d.GetURL(), Operation: "overwrite", Path: dst}
+	}
+	return copyObject(d.BackupStoreDriver, src, dst)
+}
+
+func (d *immutableDriver) Link(src, dst string) error {
+	if d.isOverwriteProtected(dst) {
+		return &ErrImmutableTarget{DestURL: d.GetURL(), Operation: "overwrite", Path: dst}
+	}
+	return link(d.BackupStoreDriver, src, dst)
+}
+
+func (d *immutableDriver) LinkCount(filePath string) (int, error) {
+	return linkCount(d.BackupStoreDriver, filePath)
+}
+
+func (d *immutableDriver) ListDeletedObjects(path string) ([]DeletedObject, error) {
+	return listDeletedObjects(d.BackupStoreDriver, path)
+}
+
+// RestoreObjectVersion is refused since restoring a version overwrites the object if it exists.
+func (d *immutableDriver) RestoreObjectVersion(filePath, versionID string) error {
+	return &ErrImmutableTarget{DestURL: d.GetURL(), Operation: "restore version", Path: filePath}
+}
+
+// ExtendRetention is allowed since it only protects the existing object longer.
+func (d *immutableDriver) ExtendRetention(filePath string) error {
+	return extendRetention(d.BackupStoreDriver, filePath)
+}
+
+func isLockFile(path string) bool {
+	return strings.HasSuffix(path, LOCK_SUFFIX) && filepath.Base(filepath.Dir(path)) == LOCKS_DIRECTORY
+}
+
+// isOverwriteProtected checks if the destination contains data which must not be replaced.
+// The volume config and in progress backup configs are still allowed to be updated.
+func (d *immutableDriver) isOverwriteProtected(dst string) bool {
+	if filepath.Base(dst) == VOLUME_CONFIG_FILE || isLockFile(dst) {
+		return false
+	}
+	if !d.FileExists(dst) {
+		return false
+	}
+	if _, ok := GetPathLayout().ParseBackupConfigName(filepath.Base(dst)); ok {
+		return isCompletedBackupConfig(d.BackupStoreDriver, dst)
+	}
+	return true
+}
+
+func isCompletedBackupConfig(driver BackupStoreDriver, filePath string) bool {
+	rc, err := driver.Read(filePath)
+	if err != nil {
+		// Be conservative if the existing config cannot be checked
+		return true
+	}
+	defer rc.Close()
+
+	backup := &struct{ CreatedTime string }{}
+	if err := json.NewDecoder(rc).Decode(backup); err != nil {
+		return true
+	}
+	return backup.CreatedTime != ""
+}
@@ -0,0 +1,61 @@
+package backupstore
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImmutableTarget(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{delay: time.Millisecond}
+	m.Init()
+	defer m.uninstall()
+
+	driver, err := GetBackupStoreDriver(mockDriverURL)
+	assert.NoError(err)
+	assert.False(IsImmutableTarget(driver))
+
+	_, err = GetBackupStoreDriver(mockDriverURL + "?immutable=maybe")
+	assert.Error(err)
+
+	immutableURL := mockDriverURL + "?immutable=true"
+	driver, err = GetBackupStoreDriver(immutableURL)
+	assert.NoError(err)
+	assert.True(IsImmutableTarget(driver))
+
+	// create pvc-1 config, a block and a lock
+	err = m.fs.MkdirAll(getVolumePath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1"}`), 0644)
+	assert.NoError(err)
+	checksum := "0123456789abcdef"
+	err = afero.WriteFile(m.fs, getBlockFilePath("pvc-1", checksum), []byte("data"), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getLockFilePath("pvc-1", "lock-1"), []byte(`{}`), 0644)
+	assert.NoError(err)
+
+	err = driver.Remove(getBlockFilePath("pvc-1", checksum))
+	assert.True(IsImmutableTargetError(err))
+	err = driver.Write(getBlockFilePath("pvc-1", checksum), bytes.NewReader([]byte("other")))
+	assert.True(IsImmutableTargetError(err))
+
+	// volume config and locks are still updatable
+	assert.NoError(driver.Write(getVolumeFilePath("pvc-1"), bytes.NewReader([]byte(`{"Name":"pvc-1"}`))))
+	assert.NoError(driver.Remove(getLockFilePath("pvc-1", "lock-1")))
+
+	err = DeleteDeltaBlockBackup(EncodeBackupURL("backup-1", "pvc-1", immutableURL))
+	assert.True(IsImmutableTargetError(err))
+	err = DeleteBackupVolume("pvc-1", immutableURL)
+	assert.True(IsImmutableTargetError(err))
+
+	// The drivers refuse to remove the objects within the retention period by themselves
+	err = fmt.Errorf("failed to remove block: %w", &ErrRetainedObject{Path: "blocks/1.blk", RetainUntil: time.Now()})
+	assert.True(IsRetainedObjectError(err))
+	assert.False(IsImmutableTargetError(err))
+}
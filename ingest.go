@@ -0,0 +1,291 @@
+package backupstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	. "github.com/longhorn/backupstore/logging"
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+// IngestSnapshotConfig is the config of IngestSnapshotFile.
+type IngestSnapshotConfig struct {
+	BackupName string
+	// Volume is the volume of the backup, the size of the volume is the size of the snapshot file if it's not set
+	Volume            *Volume
+	SnapshotName      string
+	SnapshotCreatedAt string
+	DestURL           string
+	// SourcePath is the path of the raw snapshot file relative to the backup target, e.g. the file copied onto
+	// the NFS share out of band
+	SourcePath string
+	Labels     map[string]string
+	Parameters map[string]string
+
+	// CompressionWorkers and UploadWorkers are the same as the ones of DeltaBackupConfig
+	CompressionWorkers int32
+	UploadWorkers      int32
+}
+
+// localPathDriver is implemented by the file system drivers whose objects are the files of the mount point.
+type localPathDriver interface {
+	LocalPath(path string) string
+}
+
+// IngestSnapshotFile creates the first backup of the volume from the raw snapshot file already stored on the
+// backup target, instead of uploading the snapshot from the node. The file is sliced into the blocks in place:
+// the file system drivers read it from the mount point, only the allocated extents of the sparse file are
+// backed up, and the object storage drivers read it by the ranged reads. The file itself is kept, and the URL
+// of the created full backup is returned.
+func IngestSnapshotFile(config *IngestSnapshotConfig) (backupURL string, err error) {
+	if config == nil || config.Volume == nil {
+		return "", fmt.Errorf("BUG: invalid empty config for ingesting snapshot file")
+	}
+	volume := config.Volume
+	for _, name := range []string{config.BackupName, config.SnapshotName, volume.Name} {
+		if !util.ValidateName(name) {
+			return "", fmt.Errorf("invalid name %v", name)
+		}
+	}
+	sourcePath, err := cleanRawPath(config.SourcePath)
+	if err != nil {
+		return "", err
+	}
+	if sourcePath == "" {
+		return "", fmt.Errorf("missing source path of the snapshot file")
+	}
+	if err := util.ValidateSectorSizes(volume.LogicalSectorSize, volume.PhysicalSectorSize); err != nil {
+		return "", err
+	}
+
+	bsDriver, err := GetBackupStoreDriver(config.DestURL)
+	if err != nil {
+		return "", err
+	}
+	log := log.WithFields(logrus.Fields{
+		LogFieldVolume:   volume.Name,
+		LogFieldBackup:   config.BackupName,
+		LogFieldSnapshot: config.SnapshotName,
+	})
+
+	source, err := openTargetFile(bsDriver, sourcePath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to open snapshot file %v", sourcePath)
+	}
+	defer source.Close()
+
+	if volume.Size == 0 {
+		volume.Size = source.size
+	}
+	if volume.Size != source.size {
+		return "", fmt.Errorf("size %v of snapshot file %v doesn't match volume size %v", source.size, sourcePath, volume.Size)
+	}
+	if volume.Size == 0 || volume.Size%DEFAULT_BLOCK_SIZE != 0 {
+		return "", fmt.Errorf("invalid size %v of snapshot file %v, must be a multiple of %v", volume.Size, sourcePath, DEFAULT_BLOCK_SIZE)
+	}
+
+	lock, err := New(bsDriver, volume.Name, BACKUP_LOCK)
+	if err != nil {
+		return "", err
+	}
+	if err := lock.Lock(); err != nil {
+		return "", err
+	}
+	defer func() {
+		if unlockErr := lock.Unlock(); unlockErr != nil {
+			log.WithError(unlockErr).Warn("Failed to unlock")
+		}
+	}()
+
+	if err := CheckTargetRedirect(bsDriver); err != nil {
+		return "", err
+	}
+	if err := addVolume(bsDriver, volume); err != nil {
+		return "", err
+	}
+	stored, err := loadVolume(bsDriver, volume.Name)
+	if err != nil {
+		return "", err
+	}
+	if stored.LastBackupName != "" {
+		return "", fmt.Errorf("cannot ingest snapshot file into volume %v which already has backups", volume.Name)
+	}
+	volume.CompressionMethod = stored.CompressionMethod
+	volume.DataEngine = stored.DataEngine
+	if _, err := util.GetCompressor(volume.CompressionMethod); err != nil {
+		return "", err
+	}
+	if bsDriver.FileExists(getBackupConfigPath(config.BackupName, volume.Name)) {
+		return "", fmt.Errorf("backup %v of volume %v already exists", config.BackupName, volume.Name)
+	}
+
+	delta := &types.Mappings{
+		Mappings:  source.getDataExtents(),
+		BlockSize: DEFAULT_BLOCK_SIZE,
+	}
+	deltaConfig := &DeltaBackupConfig{
+		BackupName:         config.BackupName,
+		Volume:             volume,
+		Snapshot:           &Snapshot{Name: config.SnapshotName, CreatedTime: config.SnapshotCreatedAt},
+		DestURL:            config.DestURL,
+		DeltaOps:           &ingestSnapshotOps{source: source, log: log},
+		Labels:             config.Labels,
+		Parameters:         config.Parameters,
+		CompressionWorkers: config.CompressionWorkers,
+		UploadWorkers:      config.UploadWorkers,
+		OperationID:        getOperationID(""),
+	}
+	deltaBackup := &Backup{
+		Name:              config.BackupName,
+		VolumeName:        volume.Name,
+		SnapshotName:      config.SnapshotName,
+		CompressionMethod: volume.CompressionMethod,
+		Blocks:            []BlockMapping{},
+		ProcessingBlocks: &ProcessingBlocks{
+			blocks: map[string][]*BlockMapping{},
+		},
+	}
+
+	releaseBackupSlot := acquireBackupSlot(bsDriver)
+	defer releaseBackupSlot()
+
+	log.Infof("Ingesting %v extents of snapshot file %v", len(delta.Mappings), sourcePath)
+	if _, backupURL, err = performBackup(bsDriver, deltaConfig, delta, deltaBackup, nil); err != nil {
+		return "", errors.Wrapf(err, "failed to ingest snapshot file %v", sourcePath)
+	}
+	log.Infof("Ingested snapshot file %v as backup %v", sourcePath, backupURL)
+	return backupURL, nil
+}
+
+// targetFile is a file on the backup target read at random offsets.
+type targetFile struct {
+	io.ReaderAt
+	size int64
+	// file is the local file of the file system drivers, nil for the object storage drivers
+	file *os.File
+}
+
+func openTargetFile(driver BackupStoreDriver, filePath string) (*targetFile, error) {
+	if local, ok := findDriver[localPathDriver](driver); ok {
+		file, err := os.Open(local.LocalPath(filePath))
+		if err != nil {
+			return nil, err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &targetFile{ReaderAt: file, size: stat.Size(), file: file}, nil
+	}
+
+	reader, ok := findDriver[ObjectMetadataBackupStoreDriver](driver)
+	if !ok {
+		return nil, fmt.Errorf("driver %v doesn't support reading the files of the backup target in place", driver.Kind())
+	}
+	size := driver.FileSize(filePath)
+	if size < 0 {
+		return nil, fmt.Errorf("cannot find file %v", filePath)
+	}
+	return &targetFile{ReaderAt: &rangeReaderAt{driver: reader, path: filePath}, size: size}, nil
+}
+
+func (f *targetFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// getDataExtents returns the block aligned extents of the file containing the data. The holes of the sparse
+// local file are skipped, and the whole file is returned if the holes cannot be found.
+func (f *targetFile) getDataExtents() []types.Mapping {
+	whole := []types.Mapping{{Offset: 0, Size: f.size}}
+	if f.file == nil {
+		return whole
+	}
+
+	fd := int(f.file.Fd())
+	extents := []types.Mapping{}
+	for offset := int64(0); offset < f.size; {
+		start, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if err == unix.ENXIO {
+			break
+		}
+		if err != nil {
+			return whole
+		}
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return whole
+		}
+		start = start / DEFAULT_BLOCK_SIZE * DEFAULT_BLOCK_SIZE
+		end = min((end+DEFAULT_BLOCK_SIZE-1)/DEFAULT_BLOCK_SIZE*DEFAULT_BLOCK_SIZE, f.size)
+		if n := len(extents); n > 0 && extents[n-1].Offset+extents[n-1].Size >= start {
+			extents[n-1].Size = end - extents[n-1].Offset
+		} else {
+			extents = append(extents, types.Mapping{Offset: start, Size: end - start})
+		}
+		offset = end
+	}
+	return extents
+}
+
+// rangeReaderAt reads the object at random offsets by the ranged reads.
+type rangeReaderAt struct {
+	driver ObjectMetadataBackupStoreDriver
+	path   string
+}
+
+func (r *rangeReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	rc, err := r.driver.ReadRange(r.path, offset, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.ReadFull(rc, p)
+}
+
+// ingestSnapshotOps reads the snapshot from the file on the backup target.
+type ingestSnapshotOps struct {
+	source *targetFile
+	log    logrus.FieldLogger
+}
+
+func (o *ingestSnapshotOps) HasSnapshot(id, volumeID string) bool {
+	return true
+}
+
+func (o *ingestSnapshotOps) CompareSnapshot(id, compareID, volumeID string) (*types.Mappings, error) {
+	return &types.Mappings{Mappings: o.source.getDataExtents(), BlockSize: DEFAULT_BLOCK_SIZE}, nil
+}
+
+func (o *ingestSnapshotOps) OpenSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (o *ingestSnapshotOps) ReadSnapshot(id, volumeID string, start int64, data []byte) error {
+	n, err := o.source.ReadAt(data, start)
+	if n == len(data) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (o *ingestSnapshotOps) CloseSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (o *ingestSnapshotOps) UpdateBackupStatus(id, volumeID string, backupState string, backupProgress int, backupURL string, err string) error {
+	o.log.Debugf("Ingesting snapshot file, state %v progress %v", backupState, backupProgress)
+	return nil
+}
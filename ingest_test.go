@@ -0,0 +1,102 @@
+package backupstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	localMockDriverName = "mocklocal"
+	localMockDriverURL  = "mocklocal://localhost"
+)
+
+// localMockDriver reads the files of the backup target from a local directory, like the file system drivers.
+type localMockDriver struct {
+	*mockStoreDriver
+	dir string
+}
+
+func (d *localMockDriver) LocalPath(path string) string {
+	return filepath.Join(d.dir, path)
+}
+
+func TestIngestSnapshotFile(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+	m.destURL = localMockDriverURL
+	driver := &localMockDriver{mockStoreDriver: m, dir: t.TempDir()}
+	assert.NoError(RegisterDriver(localMockDriverName, func(destURL string) (BackupStoreDriver, error) {
+		return driver, nil
+	}))
+	defer unregisterDriver(localMockDriverName) // nolint:errcheck
+
+	util.SetClock(util.NewFakeClock(time.Now()))
+	defer util.SetClock(nil)
+
+	// The sparse snapshot file has the data in the first and the third blocks
+	file, err := os.Create(driver.LocalPath("snap-1.img"))
+	assert.NoError(err)
+	assert.NoError(file.Truncate(4 * DEFAULT_BLOCK_SIZE))
+	_, err = file.WriteAt(bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE), 0)
+	assert.NoError(err)
+	_, err = file.WriteAt(bytes.Repeat([]byte{'b'}, DEFAULT_BLOCK_SIZE), 2*DEFAULT_BLOCK_SIZE)
+	assert.NoError(err)
+	assert.NoError(file.Close())
+
+	config := &IngestSnapshotConfig{
+		BackupName:        "backup-1",
+		Volume:            &Volume{Name: "pvc-1", CompressionMethod: "lz4"},
+		SnapshotName:      "snap-1",
+		SnapshotCreatedAt: "2021-06-07T08:00:00Z",
+		DestURL:           localMockDriverURL,
+		SourcePath:        "snap-1.img",
+	}
+	backupURL, err := IngestSnapshotFile(config)
+	assert.NoError(err)
+	assert.Equal(EncodeBackupURL("backup-1", "pvc-1", localMockDriverURL), backupURL)
+
+	backup, err := loadBackup(m, "backup-1", "pvc-1")
+	assert.NoError(err)
+	assert.False(backup.IsIncremental)
+	assert.Equal("2021-06-07T08:00:00Z", backup.SnapshotCreatedAt)
+	offsets := []int64{}
+	for _, block := range backup.Blocks {
+		offsets = append(offsets, block.Offset)
+		assert.True(m.FileExists(getBlockFilePath("pvc-1", block.BlockChecksum)))
+	}
+	assert.Contains(offsets, int64(0))
+	assert.Contains(offsets, int64(2*DEFAULT_BLOCK_SIZE))
+	// The hole in the middle is skipped, unless the file system reports the whole file as data
+	source, err := openTargetFile(driver, "snap-1.img")
+	assert.NoError(err)
+	defer source.Close()
+	if len(source.getDataExtents()) > 1 {
+		assert.NotContains(offsets, int64(DEFAULT_BLOCK_SIZE))
+	}
+
+	volume, err := loadVolume(m, "pvc-1")
+	assert.NoError(err)
+	assert.Equal("backup-1", volume.LastBackupName)
+	assert.Equal(int64(4*DEFAULT_BLOCK_SIZE), volume.Size)
+
+	// Only the first backup of the volume can be ingested
+	config.BackupName = "backup-2"
+	_, err = IngestSnapshotFile(config)
+	assert.Error(err)
+
+	// The source path must be inside the backup target
+	config.Volume = &Volume{Name: "pvc-2"}
+	config.SourcePath = "../snap-1.img"
+	_, err = IngestSnapshotFile(config)
+	assert.Error(err)
+}
@@ -7,6 +7,7 @@ import (
 
 	. "github.com/longhorn/backupstore/logging"
 	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
 )
 
 func InspectVolume(volumeURL string) (*VolumeInfo, error) {
@@ -44,7 +45,7 @@ func InspectBackup(backupURL string) (*BackupInfo, error) {
 		return nil, err
 	}
 
-	backup, err := loadBackup(driver, backupName, volumeName)
+	backup, err := loadVerifiedBackup(driver, backupName, volumeName)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			LogFieldReason: LogReasonFallback,
@@ -63,13 +64,13 @@ func InspectBackup(backupURL string) (*BackupInfo, error) {
 }
 
 func fillVolumeInfo(volume *Volume) *VolumeInfo {
-	return &VolumeInfo{
+	info := &VolumeInfo{
 		Name:                 volume.Name,
 		Size:                 volume.Size,
 		Labels:               volume.Labels,
-		Created:              volume.CreatedTime,
+		Created:              util.NormalizeTimestamp(volume.CreatedTime),
 		LastBackupName:       volume.LastBackupName,
-		LastBackupAt:         volume.LastBackupAt,
+		LastBackupAt:         util.NormalizeTimestamp(volume.LastBackupAt),
 		DataStored:           int64(volume.BlockCount * DEFAULT_BLOCK_SIZE),
 		Messages:             make(map[types.MessageType]string),
 		Backups:              make(map[string]*BackupInfo),
@@ -77,7 +78,16 @@ func fillVolumeInfo(volume *Volume) *VolumeInfo {
 		BackingImageChecksum: volume.BackingImageChecksum,
 		StorageClassname:     volume.StorageClassName,
 		DataEngine:           volume.DataEngine,
+		LogicalSectorSize:    volume.LogicalSectorSize,
+		PhysicalSectorSize:   volume.PhysicalSectorSize,
+
+		LastVerification:           volume.LastVerification,
+		LastSuccessfulVerification: volume.LastSuccessfulVerification,
+	}
+	if warning := getMultiWriterWarning(volume); warning != "" {
+		info.Messages[types.MessageTypeWarning] = warning
 	}
+	return info
 }
 
 func fillBackupInfo(backup *Backup, destURL string) *BackupInfo {
@@ -85,8 +95,8 @@ func fillBackupInfo(backup *Backup, destURL string) *BackupInfo {
 		Name:                  backup.Name,
 		URL:                   EncodeBackupURL(backup.Name, backup.VolumeName, destURL),
 		SnapshotName:          backup.SnapshotName,
-		SnapshotCreated:       backup.SnapshotCreatedAt,
-		Created:               backup.CreatedTime,
+		SnapshotCreated:       util.NormalizeTimestamp(backup.SnapshotCreatedAt),
+		Created:               util.NormalizeTimestamp(backup.CreatedTime),
 		Size:                  backup.Size,
 		Labels:                backup.Labels,
 		Parameters:            backup.Parameters,
@@ -94,6 +104,15 @@ func fillBackupInfo(backup *Backup, destURL string) *BackupInfo {
 		CompressionMethod:     backup.CompressionMethod,
 		NewlyUploadedDataSize: backup.NewlyUploadedDataSize,
 		ReUploadedDataSize:    backup.ReUploadedDataSize,
+		ChainLength:           backup.ChainLength,
+		IsSyntheticFull:       backup.IsSyntheticFull,
+		Description:           backup.Description,
+		UserMetadata:          backup.UserMetadata,
+		MetadataVersion:       backup.MetadataVersion,
+		LogicalSectorSize:     backup.LogicalSectorSize,
+		PhysicalSectorSize:    backup.PhysicalSectorSize,
+		SourceClusterID:       backup.SourceClusterID,
+		SourceNodeID:          backup.SourceNodeID,
 	}
 }
 
@@ -101,7 +120,7 @@ func fillFullBackupInfo(backup *Backup, volume *Volume, destURL string) *BackupI
 	info := fillBackupInfo(backup, destURL)
 	info.VolumeName = volume.Name
 	info.VolumeSize = volume.Size
-	info.VolumeCreated = volume.CreatedTime
+	info.VolumeCreated = util.NormalizeTimestamp(volume.CreatedTime)
 	info.VolumeBackingImageName = volume.BackingImageName
 	return info
 }
@@ -0,0 +1,204 @@
+package backupstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/longhorn/backupstore/metrics"
+)
+
+const (
+	DriverOperationList     = "list"
+	DriverOperationStat     = "stat"
+	DriverOperationRead     = "read"
+	DriverOperationWrite    = "write"
+	DriverOperationUpload   = "upload"
+	DriverOperationDownload = "download"
+	DriverOperationRemove   = "remove"
+)
+
+// instrumentedDriver wraps a driver and records the latency of each operation, so the slow backup targets
+// can be told apart in the dashboards.
+type instrumentedDriver struct {
+	BackupStoreDriver
+	target string
+}
+
+func newInstrumentedDriver(driver BackupStoreDriver) *instrumentedDriver {
+	return &instrumentedDriver{
+		BackupStoreDriver: driver,
+		target:            getTargetHash(driver.GetURL()),
+	}
+}
+
+// getTargetHash returns a short stable identifier of the backup target URL.
+func getTargetHash(destURL string) string {
+	sum := sha256.Sum256([]byte(destURL))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+func (d *instrumentedDriver) Unwrap() BackupStoreDriver {
+	return d.BackupStoreDriver
+}
+
+func (d *instrumentedDriver) observe(operation string, start time.Time, err error) {
+	metrics.DriverOperationDuration.WithLabelValues(d.Kind(), d.target, operation, metrics.Result(err)).
+		Observe(time.Since(start).Seconds())
+}
+
+func (d *instrumentedDriver) List(path string) ([]string, error) {
+	start := time.Now()
+	names, err := d.BackupStoreDriver.List(path)
+	d.observe(DriverOperationList, start, err)
+	return names, err
+}
+
+func (d *instrumentedDriver) FileExists(filePath string) bool {
+	start := time.Now()
+	exists := d.BackupStoreDriver.FileExists(filePath)
+	d.observe(DriverOperationStat, start, nil)
+	return exists
+}
+
+func (d *instrumentedDriver) FileSize(filePath string) int64 {
+	start := time.Now()
+	size := d.BackupStoreDriver.FileSize(filePath)
+	d.observe(DriverOperationStat, start, nil)
+	return size
+}
+
+func (d *instrumentedDriver) FileTime(filePath string) time.Time {
+	start := time.Now()
+	t := d.BackupStoreDriver.FileTime(filePath)
+	d.observe(DriverOperationStat, start, nil)
+	return t
+}
+
+func (d *instrumentedDriver) Remove(path string) error {
+	start := time.Now()
+	err := d.BackupStoreDriver.Remove(path)
+	d.observe(DriverOperationRemove, start, err)
+	return err
+}
+
+func (d *instrumentedDriver) RemoveAll(paths []string) error {
+	start := time.Now()
+	err := removeAll(d.BackupStoreDriver, paths)
+	d.observe(DriverOperationRemove, start, err)
+	return err
+}
+
+// Read records the latency until the returned reader is closed, so the transfer time is included.
+func (d *instrumentedDriver) Read(src string) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := d.BackupStoreDriver.Read(src)
+	if err != nil {
+		d.observe(DriverOperationRead, start, err)
+		return nil, err
+	}
+	return &instrumentedReadCloser{ReadCloser: rc, driver: d, start: start}, nil
+}
+
+func (d *instrumentedDriver) Write(dst string, rs io.ReadSeeker) error {
+	start := time.Now()
+	err := d.BackupStoreDriver.Write(dst, rs)
+	d.observe(DriverOperationWrite, start, err)
+	return err
+}
+
+func (d *instrumentedDriver) Upload(src, dst string) error {
+	start := time.Now()
+	err := d.BackupStoreDriver.Upload(src, dst)
+	d.observe(DriverOperationUpload, start, err)
+	return err
+}
+
+func (d *instrumentedDriver) Download(src, dst string) error {
+	start := time.Now()
+	err := d.BackupStoreDriver.Download(src, dst)
+	d.observe(DriverOperationDownload, start, err)
+	return err
+}
+
+func (d *instrumentedDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *ObjectMetadata) error {
+	start := time.Now()
+	err := writeWithMetadata(d.BackupStoreDriver, dst, rs, metadata)
+	d.observe(DriverOperationWrite, start, err)
+	return err
+}
+
+func (d *instrumentedDriver) GetMetadata(filePath string) (*ObjectMetadata, error) {
+	start := time.Now()
+	metadata, err := getMetadata(d.BackupStoreDriver, filePath)
+	d.observe(DriverOperationStat, start, err)
+	return metadata, err
+}
+
+// ReadRange records the latency until the returned reader is closed like Read.
+func (d *instrumentedDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := readRange(d.BackupStoreDriver, src, offset, length)
+	if err != nil {
+		d.observe(DriverOperationRead, start, err)
+		return nil, err
+	}
+	return &instrumentedReadCloser{ReadCloser: rc, driver: d, start: start}, nil
+}
+
+func (d *instrumentedDriver) Copy(src, dst string) error {
+	start := time.Now()
+	err := copyObject(d.BackupStoreDriver, src, dst)
+	d.observe(DriverOperationWrite, start, err)
+	return err
+}
+
+func (d *instrumentedDriver) ListDeletedObjects(path string) ([]DeletedObject, error) {
+	start := time.Now()
+	objects, err := listDeletedObjects(d.BackupStoreDriver, path)
+	d.observe(DriverOperationList, start, err)
+	return objects, err
+}
+
+func (d *instrumentedDriver) RestoreObjectVersion(filePath, versionID string) error {
+	start := time.Now()
+	err := restoreObjectVersion(d.BackupStoreDriver, filePath, versionID)
+	d.observe(DriverOperationWrite, start, err)
+	return err
+}
+
+func (d *instrumentedDriver) ExtendRetention(filePath string) error {
+	start := time.Now()
+	err := extendRetention(d.BackupStoreDriver, filePath)
+	d.observe(DriverOperationWrite, start, err)
+	return err
+}
+
+type instrumentedReadCloser struct {
+	io.ReadCloser
+	driver *instrumentedDriver
+	start  time.Time
+	err    error
+	closed bool
+}
+
+func (r *instrumentedReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *instrumentedReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if !r.closed {
+		r.closed = true
+		if r.err == nil {
+			r.err = err
+		}
+		r.driver.observe(DriverOperationRead, r.start, r.err)
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package backupstore
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/longhorn/backupstore/metrics"
+)
+
+func getOperationCount(t *testing.T, target, operation, result string) uint64 {
+	observer, err := metrics.DriverOperationDuration.GetMetricWithLabelValues(mockDriverName, target, operation, result)
+	assert.NoError(t, err)
+	metric := &dto.Metric{}
+	assert.NoError(t, observer.(prometheus.Metric).Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestInstrumentedDriver(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+	// Use a distinct URL so the operations of the other tests are not counted
+	m.destURL = "mock://instrumented"
+
+	driver, err := GetBackupStoreDriver(mockDriverURL)
+	assert.NoError(err)
+	instrumented, ok := findDriver[*instrumentedDriver](driver)
+	assert.True(ok)
+	target := instrumented.target
+	assert.Equal(getTargetHash(m.destURL), target)
+
+	err = driver.Write("backupstore/file", strings.NewReader("data"))
+	assert.NoError(err)
+	assert.Equal(uint64(1), getOperationCount(t, target, DriverOperationWrite, metrics.ResultSuccess))
+
+	names, err := driver.List("backupstore")
+	assert.NoError(err)
+	assert.Contains(names, "file")
+	assert.Equal(uint64(1), getOperationCount(t, target, DriverOperationList, metrics.ResultSuccess))
+
+	rc, err := driver.Read("backupstore/file")
+	assert.NoError(err)
+	assert.Equal(uint64(0), getOperationCount(t, target, DriverOperationRead, metrics.ResultSuccess))
+	data, err := io.ReadAll(rc)
+	assert.NoError(err)
+	assert.Equal("data", string(data))
+	assert.NoError(rc.Close())
+	assert.Equal(uint64(1), getOperationCount(t, target, DriverOperationRead, metrics.ResultSuccess))
+
+	assert.True(driver.FileExists("backupstore/file"))
+	assert.NoError(driver.Remove("backupstore/file"))
+	assert.Equal(uint64(1), getOperationCount(t, target, DriverOperationStat, metrics.ResultSuccess))
+	assert.Equal(uint64(1), getOperationCount(t, target, DriverOperationRemove, metrics.ResultSuccess))
+}
@@ -0,0 +1,132 @@
+package backupstore
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PathLayout is the naming scheme of the backup configs and the blocks inside the folder of a volume. A backup
+// target must be accessed with the layout it's written with, the default layout is used by all the existing
+// backup targets.
+type PathLayout interface {
+	// BackupConfigName returns the file name of the backup config in the backups folder of the volume
+	BackupConfigName(backupName string) string
+	// ParseBackupConfigName returns the backup name of the file in the backups folder of the volume, false if
+	// the file isn't a backup config
+	ParseBackupConfigName(fileName string) (string, bool)
+	// BlockFilePath returns the path of the block file under the blocks folder of the volume
+	BlockFilePath(blocksPath, checksum string) string
+	// ParseBlockFileName returns the checksum of the block in the file, false if the file isn't a block file.
+	// The other entries found under the blocks folder are listed as the sub folders.
+	ParseBlockFileName(fileName string) (string, bool)
+}
+
+// DefaultPathLayout stores the backup configs as backups/backup_<name>.cfg, and the blocks as
+// blocks/<checksum[0:2]>/<checksum[2:4]>/<checksum>.blk.
+type DefaultPathLayout struct{}
+
+func (DefaultPathLayout) BackupConfigName(backupName string) string {
+	return BACKUP_CONFIG_PREFIX + backupName + CFG_SUFFIX
+}
+
+func (DefaultPathLayout) ParseBackupConfigName(fileName string) (string, bool) {
+	if !strings.HasPrefix(fileName, BACKUP_CONFIG_PREFIX) || !strings.HasSuffix(fileName, CFG_SUFFIX) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(fileName, BACKUP_CONFIG_PREFIX), CFG_SUFFIX), true
+}
+
+func (DefaultPathLayout) BlockFilePath(blocksPath, checksum string) string {
+	blockSubDirLayer1 := checksum[0:BLOCK_SEPARATE_LAYER1]
+	blockSubDirLayer2 := checksum[BLOCK_SEPARATE_LAYER1:BLOCK_SEPARATE_LAYER2]
+	return filepath.Join(blocksPath, blockSubDirLayer1, blockSubDirLayer2, checksum+BLK_SUFFIX)
+}
+
+func (DefaultPathLayout) ParseBlockFileName(fileName string) (string, bool) {
+	if !strings.HasSuffix(fileName, BLK_SUFFIX) {
+		return "", false
+	}
+	return strings.TrimSuffix(fileName, BLK_SUFFIX), true
+}
+
+// LegacyBlockPathLayout is implemented by the layouts moving the blocks written by another layout, the blocks
+// written before are still found at their legacy paths.
+type LegacyBlockPathLayout interface {
+	// LegacyBlockFilePath returns the path of the block file written before under the blocks folder
+	LegacyBlockFilePath(blocksPath, checksum string) string
+}
+
+// ShardedPathLayout is the default layout with an extra fan-out level of the blocks derived from the checksum,
+// blocks/<checksum[0:2]>/<checksum[2:4]>/<checksum[4:6]>/<checksum>.blk, for the volumes with so many blocks that
+// listing a first level folder takes long. The drivers listing the prefixes list each second level folder at once
+// in parallel. The new blocks are written in the extra level and the blocks written by the default layout are
+// still read from their paths, so the layout can be enabled for the existing backup targets but not disabled
+// again.
+type ShardedPathLayout struct {
+	DefaultPathLayout
+}
+
+func (ShardedPathLayout) BlockFilePath(blocksPath, checksum string) string {
+	blockSubDirLayer1 := checksum[0:BLOCK_SEPARATE_LAYER1]
+	blockSubDirLayer2 := checksum[BLOCK_SEPARATE_LAYER1:BLOCK_SEPARATE_LAYER2]
+	blockSubDirLayer3 := checksum[BLOCK_SEPARATE_LAYER2:BLOCK_SEPARATE_LAYER3]
+	return filepath.Join(blocksPath, blockSubDirLayer1, blockSubDirLayer2, blockSubDirLayer3, checksum+BLK_SUFFIX)
+}
+
+func (ShardedPathLayout) LegacyBlockFilePath(blocksPath, checksum string) string {
+	return DefaultPathLayout{}.BlockFilePath(blocksPath, checksum)
+}
+
+var (
+	pathLayoutLock sync.RWMutex
+	pathLayout     PathLayout = DefaultPathLayout{}
+)
+
+// SetPathLayout sets the naming scheme of the backup configs and the blocks, nil restores the default layout. It
+// should be called before any backup target is accessed, since the running operations may see either layout.
+func SetPathLayout(layout PathLayout) {
+	if layout == nil {
+		layout = DefaultPathLayout{}
+	}
+	pathLayoutLock.Lock()
+	defer pathLayoutLock.Unlock()
+	pathLayout = layout
+}
+
+// GetPathLayout returns the naming scheme of the backup configs and the blocks.
+func GetPathLayout() PathLayout {
+	pathLayoutLock.RLock()
+	defer pathLayoutLock.RUnlock()
+	return pathLayout
+}
+
+// isBlockFile returns true if the base name of the path is a block file in the path layout.
+func isBlockFile(path string) bool {
+	_, ok := GetPathLayout().ParseBlockFileName(filepath.Base(path))
+	return ok
+}
+
+// getBlockPrefixDepth returns the level of the block sub folders listed at once by the drivers listing the
+// prefixes.
+func getBlockPrefixDepth(layout PathLayout) int {
+	if _, ok := layout.(ShardedPathLayout); ok {
+		return 2
+	}
+	return 1
+}
+
+// findLayoutBlockFilePath returns the path of the existing block file under the blocks folder, or the path in the
+// layout if the block doesn't exist. The existing block is only looked up if the layout has the legacy paths.
+func findLayoutBlockFilePath(driver BackupStoreDriver, blocksPath, checksum string) string {
+	layout := GetPathLayout()
+	path := layout.BlockFilePath(blocksPath, checksum)
+	legacyLayout, ok := layout.(LegacyBlockPathLayout)
+	if !ok || driver.FileExists(path) {
+		return path
+	}
+	if legacy := legacyLayout.LegacyBlockFilePath(blocksPath, checksum); driver.FileExists(legacy) {
+		return legacy
+	}
+	return path
+}
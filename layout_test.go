@@ -0,0 +1,133 @@
+package backupstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flatPathLayout stores the blocks without the sub directories, and the backup configs with another suffix.
+type flatPathLayout struct{}
+
+func (flatPathLayout) BackupConfigName(backupName string) string {
+	return backupName + ".json"
+}
+
+func (flatPathLayout) ParseBackupConfigName(fileName string) (string, bool) {
+	if !strings.HasSuffix(fileName, ".json") {
+		return "", false
+	}
+	return strings.TrimSuffix(fileName, ".json"), true
+}
+
+func (flatPathLayout) BlockFilePath(blocksPath, checksum string) string {
+	return filepath.Join(blocksPath, checksum+".data")
+}
+
+func (flatPathLayout) ParseBlockFileName(fileName string) (string, bool) {
+	if !strings.HasSuffix(fileName, ".data") {
+		return "", false
+	}
+	return strings.TrimSuffix(fileName, ".data"), true
+}
+
+func TestPathLayout(t *testing.T) {
+	checksums := []string{
+		"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		"fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210",
+	}
+
+	for name, layout := range map[string]PathLayout{
+		"default": DefaultPathLayout{},
+		"sharded": ShardedPathLayout{},
+		"flat":    flatPathLayout{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			m := &mockStoreDriver{}
+			m.Init()
+			defer m.uninstall()
+			SetPathLayout(layout)
+			defer SetPathLayout(nil)
+
+			for _, checksum := range checksums {
+				err := m.Write(getBlockFilePath("pvc-1", checksum), bytes.NewReader([]byte(checksum)))
+				assert.NoError(err)
+			}
+			names, err := getBlockNamesForVolume(m, "pvc-1")
+			assert.NoError(err)
+			assert.ElementsMatch(checksums, names)
+
+			// The shared index of the hard-linked blocks follows the layout as well
+			assert.Equal(layout.BlockFilePath(filepath.Join(backupstoreBase, BLOCK_LINKS_DIRECTORY, "lz4"), checksums[0]),
+				getLinkedBlockFilePath("lz4", checksums[0]))
+			assert.Equal(checksums, getBlockChecksums([]string{
+				getBlockFilePath("pvc-1", checksums[0]),
+				getBlockFilePath("pvc-1", checksums[1]),
+				getVolumeFilePath("pvc-1"),
+			}))
+
+			assert.NoError(saveVolume(m, &Volume{Name: "pvc-1", Size: DEFAULT_BLOCK_SIZE}))
+			assert.NoError(saveBackup(m, &Backup{Name: "backup-1", VolumeName: "pvc-1", CreatedTime: "2024-01-01T00:00:00Z"}))
+			assert.True(m.FileExists(filepath.Join(getBackupPath("pvc-1"), layout.BackupConfigName("backup-1"))))
+			backupNames, err := getBackupNamesForVolume(m, "pvc-1")
+			assert.NoError(err)
+			assert.Equal([]string{"backup-1"}, backupNames)
+		})
+	}
+
+	// The default layout is kept by the existing backup targets
+	assert.Equal(t, "backupstore/volumes/7e/ed/pvc-1/backups/backup_backup-1.cfg", getBackupConfigPath("backup-1", "pvc-1"))
+	assert.Equal(t, "backupstore/volumes/7e/ed/pvc-1/blocks/01/23/"+checksums[0]+".blk", getBlockFilePath("pvc-1", checksums[0]))
+}
+
+func TestShardedPathLayout(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+	defer SetPathLayout(nil)
+
+	legacy := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	sharded := "0145456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	missing := "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	legacyPath := getBlockFilePath("pvc-1", legacy)
+	assert.NoError(m.Write(legacyPath, bytes.NewReader([]byte(legacy))))
+
+	// The new blocks are written in the extra level
+	SetPathLayout(ShardedPathLayout{})
+	assert.Equal("backupstore/volumes/7e/ed/pvc-1/blocks/01/45/45/"+sharded+".blk", getBlockFilePath("pvc-1", sharded))
+	assert.NoError(m.Write(getBlockFilePath("pvc-1", sharded), bytes.NewReader([]byte(sharded))))
+
+	// The blocks written by the default layout are still found at their paths
+	assert.Equal(legacyPath, findBlockFilePath(m, "pvc-1", legacy))
+	assert.Equal(getBlockFilePath("pvc-1", sharded), findBlockFilePath(m, "pvc-1", sharded))
+	assert.Equal(getBlockFilePath("pvc-1", missing), findBlockFilePath(m, "pvc-1", missing))
+
+	expected := map[string]string{legacy: legacyPath, sharded: getBlockFilePath("pvc-1", sharded)}
+	files, err := getBlockFilesForVolume(m, "pvc-1")
+	assert.NoError(err)
+	assert.Equal(expected, files)
+
+	// Each second level sub directory is listed once by the prefix
+	lister := &prefixListingStoreDriver{mockStoreDriver: m}
+	files, err = getBlockFilesForVolume(lister, "pvc-1")
+	assert.NoError(err)
+	assert.Equal(expected, files)
+	assert.Equal(int32(2), lister.prefixListings)
+
+	// The unused blocks are removed at their paths
+	assert.NoError(saveVolume(m, &Volume{Name: "pvc-1", Size: DEFAULT_BLOCK_SIZE}))
+	err = cleanupBlocks(m, map[string]*BlockInfo{
+		legacy:  {checksum: legacy, path: files[legacy]},
+		sharded: {checksum: sharded, path: files[sharded]},
+	}, "pvc-1")
+	assert.NoError(err)
+	assert.False(m.FileExists(legacyPath))
+	assert.False(m.FileExists(getBlockFilePath("pvc-1", sharded)))
+}
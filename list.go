@@ -1,9 +1,11 @@
 package backupstore
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/gammazero/workerpool"
@@ -29,6 +31,11 @@ type VolumeInfo struct {
 	BackingImageChecksum string
 	StorageClassname     string
 	DataEngine           string
+	LogicalSectorSize    int64 `json:",string,omitempty"`
+	PhysicalSectorSize   int64 `json:",string,omitempty"`
+
+	LastVerification           *VerificationResult `json:",omitempty"`
+	LastSuccessfulVerification *VerificationResult `json:",omitempty"`
 }
 
 type BackupInfo struct {
@@ -44,6 +51,15 @@ type BackupInfo struct {
 	CompressionMethod     string `json:",omitempty"`
 	NewlyUploadedDataSize int64  `json:",string"`
 	ReUploadedDataSize    int64  `json:",string"`
+	ChainLength           int64  `json:",string"`
+	IsSyntheticFull       bool
+	Description           string          `json:",omitempty"`
+	UserMetadata          json.RawMessage `json:",omitempty"`
+	MetadataVersion       int64           `json:",string"`
+	LogicalSectorSize     int64           `json:",string,omitempty"`
+	PhysicalSectorSize    int64           `json:",string,omitempty"`
+	SourceClusterID       string          `json:",omitempty"`
+	SourceNodeID          string          `json:",omitempty"`
 
 	VolumeName             string `json:",omitempty"`
 	VolumeSize             int64  `json:",string,omitempty"`
@@ -53,6 +69,28 @@ type BackupInfo struct {
 	Messages map[types.MessageType]string
 }
 
+// ErrPartialListing is returned along with the volumes listed when some volumes or volume folders of the
+// backup target cannot be listed, so a corrupt volume doesn't hide the rest of the target.
+type ErrPartialListing struct {
+	// Errors are the errors by the volume names, or by the volume folders which cannot be listed
+	Errors map[string]string
+}
+
+func (e *ErrPartialListing) Error() string {
+	errs := make([]string, 0, len(e.Errors))
+	for name, err := range e.Errors {
+		errs = append(errs, fmt.Sprintf("%v: %v", name, err))
+	}
+	sort.Strings(errs)
+	return strings.Join(errs, "\n")
+}
+
+// IsPartialListingError returns true if only a part of the backup target is listed.
+func IsPartialListingError(err error) bool {
+	var partialErr *ErrPartialListing
+	return errors.As(err, &partialErr)
+}
+
 func addListVolume(driver BackupStoreDriver, volumeName string, volumeOnly bool) (*VolumeInfo, error) {
 	if volumeName == "" {
 		return nil, fmt.Errorf("invalid empty volume Name")
@@ -97,26 +135,31 @@ func List(volumeName, destURL string, volumeOnly bool) (map[string]*VolumeInfo,
 	defer jobQueues.StopWait()
 
 	var resp = make(map[string]*VolumeInfo)
+	errs := map[string]string{}
 	volumeNames := []string{volumeName}
 	if volumeName == "" {
-		volumeNames, err = getVolumeNames(jobQueues, driver)
+		var pathErrs map[string]error
+		volumeNames, pathErrs, err = getVolumeNamesWithErrors(jobQueues, driver)
 		if err != nil {
 			return nil, err
 		}
+		for path, err := range pathErrs {
+			errs[path] = err.Error()
+		}
 	}
 
-	var errs []string
 	for _, volumeName := range volumeNames {
 		volumeInfo, err := addListVolume(driver, volumeName, volumeOnly)
 		if err != nil {
-			errs = append(errs, err.Error())
+			errs[volumeName] = err.Error()
 			continue
 		}
 		resp[volumeName] = volumeInfo
 	}
 
+	// The volumes listed are returned along with the errors
 	if len(errs) > 0 {
-		return nil, errors.New(strings.Join(errs, "\n"))
+		return resp, &ErrPartialListing{Errors: errs}
 	}
 	return resp, nil
 }
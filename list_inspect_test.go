@@ -62,16 +62,12 @@ func (m *mockStoreDriver) List(listPath string) ([]string, error) {
 }
 
 func (m *mockStoreDriver) FileExists(filePath string) bool {
-	exist, err := afero.Exists(m.fs, filePath)
-	if err != nil {
-		return false
-	}
-	return exist
+	return m.FileSize(filePath) >= 0
 }
 
 func (m *mockStoreDriver) FileSize(filePath string) int64 {
 	fi, err := m.fs.Stat(filePath)
-	if err != nil {
+	if err != nil || fi.IsDir() {
 		return -1
 	}
 	return fi.Size()
@@ -86,7 +82,7 @@ func (m *mockStoreDriver) FileTime(filePath string) time.Time {
 }
 
 func (m *mockStoreDriver) Remove(path string) error {
-	return m.fs.Remove(path)
+	return m.fs.RemoveAll(path)
 }
 
 func (m *mockStoreDriver) Read(src string) (io.ReadCloser, error) {
@@ -100,7 +96,14 @@ func (m *mockStoreDriver) Read(src string) (io.ReadCloser, error) {
 }
 
 func (m *mockStoreDriver) Write(dst string, rs io.ReadSeeker) error {
-	return nil
+	if err := m.fs.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(rs)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(m.fs, dst, data, 0644)
 }
 
 func (m *mockStoreDriver) Upload(src, dst string) error {
@@ -0,0 +1,123 @@
+package backupstore
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"time"
+
+	"github.com/gammazero/workerpool"
+
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+// ListOptions are the options of ListVolumes.
+type ListOptions struct {
+	// NameGlob filters the volumes by the name in the syntax of path.Match, all the volumes are listed if it's empty
+	NameGlob string
+	// ModifiedSince lists only the volumes modified since then, which is the ListedAt of the last listing.
+	// All the volumes are listed if it's zero.
+	ModifiedSince time.Time
+	// MaxRequestsPerSecond limits the requests checking the modification of the volumes, 0 means unlimited
+	MaxRequestsPerSecond int
+	// VolumeOnly skips listing the backups of the volumes
+	VolumeOnly bool
+}
+
+// VolumeListing is the result of ListVolumes.
+type VolumeListing struct {
+	Volumes map[string]*VolumeInfo
+	// ListedAt is the time the listing started, and is passed as ListOptions.ModifiedSince of the next listing
+	// to resume from there
+	ListedAt time.Time
+	// Errors are the errors by the volume names, or by the volume folders which cannot be listed. The volumes
+	// with errors still appear in Volumes if their folders are found.
+	Errors map[string]string `json:",omitempty"`
+}
+
+// ListVolumes lists the volumes in the backup target matching the options. The volume config is saved whenever
+// a backup of the volume is created or deleted, so the volumes not modified since the last listing are skipped
+// by checking the modification time of the config, without loading the backups. The clocks of the client and
+// the backup target are expected to be in sync.
+func ListVolumes(destURL string, opts ListOptions) (*VolumeListing, error) {
+	if opts.NameGlob != "" {
+		if _, err := path.Match(opts.NameGlob, ""); err != nil {
+			return nil, fmt.Errorf("invalid volume name glob %v: %v", opts.NameGlob, err)
+		}
+	}
+
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// The modification time may be truncated to seconds by the backup target
+	listing := &VolumeListing{
+		Volumes:  map[string]*VolumeInfo{},
+		ListedAt: util.GetClock().Now().UTC().Truncate(time.Second),
+	}
+
+	jobQueues := workerpool.New(runtime.NumCPU() * 16)
+	defer jobQueues.StopWait()
+
+	// Only the failure listing the volume folder fails the listing, the other errors are reported along with
+	// the volumes listed
+	volumeNames, pathErrs, err := getVolumeNamesWithErrors(jobQueues, driver)
+	if err != nil {
+		return nil, err
+	}
+	for path, err := range pathErrs {
+		listing.addError(path, err.Error())
+	}
+
+	var throttle <-chan time.Time
+	if opts.MaxRequestsPerSecond > 0 && !opts.ModifiedSince.IsZero() {
+		ticker := time.NewTicker(time.Second / time.Duration(opts.MaxRequestsPerSecond))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	for _, volumeName := range volumeNames {
+		if opts.NameGlob != "" {
+			if matched, _ := path.Match(opts.NameGlob, volumeName); !matched {
+				continue
+			}
+		}
+		if !opts.ModifiedSince.IsZero() {
+			if throttle != nil {
+				<-throttle
+			}
+			// The volume is listed if the config cannot be found, so the error is reported
+			modifiedAt := driver.FileTime(getVolumeFilePath(volumeName))
+			if !modifiedAt.IsZero() && modifiedAt.Before(opts.ModifiedSince) {
+				continue
+			}
+		}
+
+		volumeInfo, err := addListVolume(driver, volumeName, opts.VolumeOnly)
+		if err != nil {
+			listing.addError(volumeName, err.Error())
+			continue
+		}
+		if message, exists := volumeInfo.Messages[types.MessageTypeError]; exists {
+			listing.addError(volumeName, message)
+		}
+		listing.Volumes[volumeName] = volumeInfo
+	}
+
+	if len(listing.Errors) > 0 {
+		log.Warnf("Listed %v of %v volumes in backup target %v with %v errors", len(listing.Volumes), len(volumeNames),
+			driver.GetURL(), len(listing.Errors))
+		return listing, nil
+	}
+	log.Infof("Listed %v of %v volumes in backup target %v", len(listing.Volumes), len(volumeNames), driver.GetURL())
+	return listing, nil
+}
+
+func (l *VolumeListing) addError(name, message string) {
+	if l.Errors == nil {
+		l.Errors = map[string]string{}
+	}
+	l.Errors[name] = message
+}
@@ -0,0 +1,80 @@
+package backupstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListVolumes(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	for _, name := range []string{"pvc-1", "pvc-2", "data-1"} {
+		err := saveVolume(m, &Volume{Name: name, Size: DEFAULT_BLOCK_SIZE})
+		assert.NoError(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	err := m.fs.Chtimes(getVolumeFilePath("pvc-2"), old, old)
+	assert.NoError(err)
+
+	listing, err := ListVolumes(mockDriverURL, ListOptions{NameGlob: "pvc-*", VolumeOnly: true})
+	assert.NoError(err)
+	assert.Len(listing.Volumes, 2)
+	assert.Contains(listing.Volumes, "pvc-1")
+	assert.Contains(listing.Volumes, "pvc-2")
+
+	// The volume not modified since the last listing is skipped
+	listing, err = ListVolumes(mockDriverURL, ListOptions{
+		ModifiedSince:        old.Add(time.Minute),
+		MaxRequestsPerSecond: 100,
+	})
+	assert.NoError(err)
+	assert.Len(listing.Volumes, 2)
+	assert.Contains(listing.Volumes, "pvc-1")
+	assert.Contains(listing.Volumes, "data-1")
+
+	listing, err = ListVolumes(mockDriverURL, ListOptions{ModifiedSince: listing.ListedAt.Add(time.Hour)})
+	assert.NoError(err)
+	assert.Empty(listing.Volumes)
+
+	_, err = ListVolumes(mockDriverURL, ListOptions{NameGlob: "["})
+	assert.Error(err)
+}
+
+func TestListVolumesWithErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	err := saveVolume(m, &Volume{Name: "pvc-1", Size: DEFAULT_BLOCK_SIZE})
+	assert.NoError(err)
+	// pvc-2 lost its config, and a corrupt folder cannot be listed
+	err = m.fs.MkdirAll(getVolumePath("pvc-2"), 0755)
+	assert.NoError(err)
+	brokenPath := filepath.Join(backupstoreBase, VOLUME_DIRECTORY, "zz")
+	err = afero.WriteFile(m.fs, brokenPath, []byte("corrupt"), 0644)
+	assert.NoError(err)
+
+	listing, err := ListVolumes(mockDriverURL, ListOptions{})
+	assert.NoError(err)
+	assert.Len(listing.Volumes, 2)
+	assert.Len(listing.Errors, 2)
+	assert.Contains(listing.Errors, "pvc-2")
+	assert.Contains(listing.Errors, brokenPath)
+	assert.NotContains(listing.Errors, "pvc-1")
+
+	volumes, err := List("", mockDriverURL, true)
+	assert.True(IsPartialListingError(err), "unexpected error %v", err)
+	assert.Len(volumes, 2)
+	assert.Contains(volumes, "pvc-1")
+	assert.Contains(err.Error(), brokenPath)
+}
@@ -36,32 +36,35 @@ const BackupOperationDelete Operation = "delete"
 const BackupOperationUndefined Operation = "undefined"
 
 type FileLock struct {
-	Name       string
-	Type       LockType
-	Acquired   bool
-	driver     BackupStoreDriver
-	volume     string
-	count      int32
-	serverTime time.Time // UTC time
-	keepAlive  chan struct{}
-	mutex      sync.Mutex
+	Name        string
+	Type        LockType
+	Acquired    bool
+	OperationID string `json:",omitempty"` // ID of the operation holding the lock
+	Owner       string `json:",omitempty"` // ID of the client holding the lock
+	Node        string `json:",omitempty"` // ID of the node holding the lock
+	driver      BackupStoreDriver
+	volume      string
+	count       int32
+	serverTime  time.Time // UTC time
+	keepAlive   chan struct{}
+	mutex       sync.Mutex
 }
 
 func New(driver BackupStoreDriver, volumeName string, lockType LockType) (*FileLock, error) {
 	return &FileLock{driver: driver, volume: volumeName,
-		Type: lockType, Name: util.GenerateName(LOCK_PREFIX)}, nil
+		Type: lockType, Name: util.GenerateName(LOCK_PREFIX), Owner: GetClientID(), Node: GetNodeID()}, nil
 }
 
 // isExpired checks whether the current lock is expired
 func (lock *FileLock) isExpired() bool {
 	// server time is always in UTC
-	isExpired := time.Now().UTC().Sub(lock.serverTime) > LOCK_DURATION
+	isExpired := util.GetClock().Now().UTC().Sub(lock.serverTime) > LOCK_DURATION
 	return isExpired
 }
 
 func (lock *FileLock) String() string {
-	return fmt.Sprintf("{ volume: %v, name: %v, type: %v, acquired: %v, serverTime: %v }",
-		lock.volume, lock.Name, lock.Type, lock.Acquired, lock.serverTime)
+	return fmt.Sprintf("{ volume: %v, name: %v, type: %v, acquired: %v, serverTime: %v, operationID: %v, owner: %v, node: %v }",
+		lock.volume, lock.Name, lock.Type, lock.Acquired, lock.serverTime, lock.OperationID, lock.Owner, lock.Node)
 }
 
 func (lock *FileLock) canAcquire() bool {
@@ -71,10 +74,26 @@ func (lock *FileLock) canAcquire() bool {
 	log.WithField("lock", lock).Infof("Trying to acquire lock %v", file)
 	log.Infof("backupstore volume %v contains locks %v", lock.volume, locks)
 
+	// The locks of the same type share the volume, e.g. the restores of different backups run concurrently. Once
+	// the volume is held by an acquired lock of the same type, the pending locks of the other types cannot be
+	// acquired until it's released, so they don't keep the lock from joining it.
+	sharedWithAcquiredLock := false
 	for _, serverLock := range locks {
+		if serverLock.Type == lock.Type && serverLock.Acquired && serverLock.Name != lock.Name && !serverLock.isExpired() {
+			sharedWithAcquiredLock = true
+			break
+		}
+	}
+
+	for _, serverLock := range locks {
+		if serverLock.Owner != "" && lock.Owner != "" && serverLock.Owner != lock.Owner && !serverLock.isExpired() {
+			log.Warnf("backupstore volume %v is also locked by client %v node %v, the clients may be misconfigured to use the same backup target",
+				lock.volume, serverLock.Owner, serverLock.Node)
+		}
 		serverLockHasDifferentType := serverLock.Type != lock.Type
 		serverLockHasPriority := compareLocks(serverLock, lock) < 0
-		if serverLockHasDifferentType && serverLockHasPriority && !serverLock.isExpired() {
+		serverLockIsPending := !serverLock.Acquired && sharedWithAcquiredLock
+		if serverLockHasDifferentType && serverLockHasPriority && !serverLockIsPending && !serverLock.isExpired() {
 			canAcquire = false
 			break
 		}
@@ -100,6 +119,16 @@ func (lock *FileLock) Lock() error {
 		return nil
 	}
 
+	// The lock files cannot be stored on the read-only target, so the operations reading it don't coordinate
+	// with the clients writing it. The readers already tolerate the concurrent backups, but a restore fails if
+	// the backup is deleted by the writing clients meanwhile.
+	if IsReadOnlyTarget(lock.driver) {
+		log.Infof("Skipped storing lock of volume %v on read-only backup target", lock.volume)
+		lock.Acquired = true
+		atomic.AddInt32(&lock.count, 1)
+		return nil
+	}
+
 	// we create first then retrieve all locks
 	// because this way if another client creates at the same time
 	// one of us will be first in the times array
@@ -113,7 +142,7 @@ func (lock *FileLock) Lock() error {
 	// since the node times might not be perfectly in sync and the servers file time has second precision
 	// we wait 2 seconds before retrieving the current set of locks, this eliminates a race condition
 	// where 2 processes request a lock at the same time
-	time.Sleep(LOCK_CHECK_WAIT_TIME)
+	util.GetClock().Sleep(LOCK_CHECK_WAIT_TIME)
 
 	// we only try to acquire once, since backup operations generally take a long time
 	// there is no point in trying to wait for lock acquisition, better to throw an error
@@ -166,6 +195,9 @@ func (lock *FileLock) Unlock() error {
 		if lock.keepAlive != nil {
 			close(lock.keepAlive)
 		}
+		if IsReadOnlyTarget(lock.driver) {
+			return nil
+		}
 		if err := removeLock(lock); err != nil {
 			return err
 		}
@@ -0,0 +1,78 @@
+package backupstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestLockExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	clock := util.NewFakeClock(time.Now().UTC())
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	deletion, err := New(m, "volume", DELETION_LOCK)
+	assert.NoError(err)
+	err = deletion.Lock()
+	assert.NoError(err)
+
+	// The deletion lock created first blocks the backup until it expires
+	backup, err := New(m, "volume", BACKUP_LOCK)
+	assert.NoError(err)
+	err = backup.Lock()
+	assert.Error(err)
+
+	clock.Advance(LOCK_DURATION + time.Second)
+	err = backup.Lock()
+	assert.NoError(err)
+	err = backup.Unlock()
+	assert.NoError(err)
+}
+
+func TestConcurrentRestoreLocks(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	util.SetClock(util.NewFakeClock(time.Now().UTC()))
+	defer util.SetClock(nil)
+
+	first, err := New(m, "volume", RESTORE_LOCK)
+	assert.NoError(err)
+	assert.NoError(first.Lock())
+
+	// The pending deletion waiting for the first restore doesn't keep the other restores from joining it
+	pending, err := New(m, "volume", DELETION_LOCK)
+	assert.NoError(err)
+	assert.NoError(saveLock(pending))
+	second, err := New(m, "volume", RESTORE_LOCK)
+	assert.NoError(err)
+	assert.NoError(second.Lock())
+	assert.NoError(removeLock(pending))
+
+	// The deletion is excluded until all the restores are done
+	deletion, err := New(m, "volume", DELETION_LOCK)
+	assert.NoError(err)
+	assert.Error(deletion.Lock())
+	assert.NoError(first.Unlock())
+	assert.Error(deletion.Lock())
+	assert.NoError(second.Unlock())
+	assert.NoError(deletion.Lock())
+
+	// The restore doesn't join the deletion
+	third, err := New(m, "volume", RESTORE_LOCK)
+	assert.NoError(err)
+	assert.Error(third.Lock())
+	assert.NoError(deletion.Unlock())
+}
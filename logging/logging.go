@@ -21,6 +21,7 @@ const (
 	LogFieldSourceURL    = "source_url"
 	LogFieldKind         = "kind"
 	LogFieldFilepath     = "filepath"
+	LogFieldOperationID  = "operation_id"
 
 	LogFieldEvent        = "event"
 	LogEventBackup       = "backup"
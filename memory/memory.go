@@ -0,0 +1,313 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/util"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "memory"})
+)
+
+const (
+	// KIND defines the kind of backupstore driver
+	KIND = "memory"
+)
+
+// store keeps the objects of a memory backup target, the drivers initialized for the same store share them,
+// since backupstore initializes a driver for each operation.
+type store struct {
+	lock    sync.RWMutex
+	objects map[string]*object
+}
+
+type object struct {
+	data     []byte
+	modified time.Time
+	metadata backupstore.ObjectMetadata
+}
+
+var (
+	storesLock sync.Mutex
+	stores     = map[string]*store{}
+)
+
+// BackupStoreDriver keeps the objects in the memory of the process. It's meant for the tests of the backup and
+// restore flows which don't need a real backup store, the objects are lost when the process exits.
+type BackupStoreDriver struct {
+	destURL string
+	path    string
+	store   *store
+}
+
+func init() {
+	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func getStoreName(destURL string) (string, string, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != KIND {
+		return "", "", fmt.Errorf("wrong driver dispatching %v to %v?", u.Scheme, KIND)
+	}
+	if u.Host == "" {
+		return "", "", fmt.Errorf("invalid URL. Must be memory://store/path/")
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
+	name, path, err := getStoreName(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	s, ok := stores[name]
+	if !ok {
+		s = &store{objects: map[string]*object{}}
+		stores[name] = s
+	}
+
+	b := &BackupStoreDriver{path: path, store: s}
+	b.destURL = KIND + "://" + name
+	if path != "" {
+		b.destURL += "/" + path
+	}
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+// Reset removes all the objects of the memory store of the backup target, so the tests don't share them.
+func Reset(destURL string) error {
+	name, _, err := getStoreName(destURL)
+	if err != nil {
+		return err
+	}
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	delete(stores, name)
+	return nil
+}
+
+// Kind returns the driver type
+func (b *BackupStoreDriver) Kind() string {
+	return KIND
+}
+
+// GetURL returns URL of the backup target
+func (b *BackupStoreDriver) GetURL() string {
+	return b.destURL
+}
+
+func (b *BackupStoreDriver) updatePath(path string) string {
+	return strings.TrimPrefix(filepath.Join("/", b.path, path), "/")
+}
+
+func (b *BackupStoreDriver) get(filePath string) (*object, bool) {
+	b.store.lock.RLock()
+	defer b.store.lock.RUnlock()
+	obj, ok := b.store.objects[b.updatePath(filePath)]
+	return obj, ok
+}
+
+// notFoundError wraps os.ErrNotExist, like the errors of the file system drivers.
+func (b *BackupStoreDriver) notFoundError(filePath string) error {
+	return fmt.Errorf("cannot find %v in memory store %v: %w", filePath, b.destURL, os.ErrNotExist)
+}
+
+func (b *BackupStoreDriver) put(dst string, rs io.Reader, metadata *backupstore.ObjectMetadata) error {
+	data, err := io.ReadAll(rs)
+	if err != nil {
+		return err
+	}
+	obj := &object{data: data, modified: util.GetClock().Now().UTC()}
+	if metadata != nil {
+		obj.metadata = *metadata
+	}
+
+	b.store.lock.Lock()
+	defer b.store.lock.Unlock()
+	b.store.objects[b.updatePath(dst)] = obj
+	return nil
+}
+
+// List return items that on the backup target including prefixes
+func (b *BackupStoreDriver) List(listPath string) ([]string, error) {
+	prefix := b.updatePath(listPath)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	b.store.lock.RLock()
+	defer b.store.lock.RUnlock()
+	seen := map[string]bool{}
+	for name := range b.store.objects {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		child, _, _ := strings.Cut(strings.TrimPrefix(name, prefix), "/")
+		seen[child] = true
+	}
+	result := make([]string, 0, len(seen))
+	for name := range seen {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// ListPrefix returns the paths of all the objects under the prefix
+func (b *BackupStoreDriver) ListPrefix(prefix string) ([]string, error) {
+	path := b.updatePath(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		path += "/"
+	}
+	base := b.updatePath("")
+	if base != "" {
+		base += "/"
+	}
+
+	b.store.lock.RLock()
+	defer b.store.lock.RUnlock()
+	result := []string{}
+	for name := range b.store.objects {
+		if strings.HasPrefix(name, path) {
+			result = append(result, strings.TrimPrefix(name, base))
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// FileExists checks if file exists on the backup target
+func (b *BackupStoreDriver) FileExists(filePath string) bool {
+	_, ok := b.get(filePath)
+	return ok
+}
+
+// FileSize return content length of the filePath on the backup target
+func (b *BackupStoreDriver) FileSize(filePath string) int64 {
+	obj, ok := b.get(filePath)
+	if !ok {
+		return -1
+	}
+	return int64(len(obj.data))
+}
+
+// FileTime returns file last modified time on the backup target
+func (b *BackupStoreDriver) FileTime(filePath string) time.Time {
+	obj, ok := b.get(filePath)
+	if !ok {
+		return time.Time{}
+	}
+	return obj.modified
+}
+
+// Remove deletes the file or all the files under the path on the backup target
+func (b *BackupStoreDriver) Remove(path string) error {
+	name := b.updatePath(path)
+
+	b.store.lock.Lock()
+	defer b.store.lock.Unlock()
+	for key := range b.store.objects {
+		if key == name || name == "" || strings.HasPrefix(key, name+"/") {
+			delete(b.store.objects, key)
+		}
+	}
+	return nil
+}
+
+func (b *BackupStoreDriver) Read(src string) (io.ReadCloser, error) {
+	obj, ok := b.get(src)
+	if !ok {
+		return nil, b.notFoundError(src)
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// Write creates a item on the backup target from io stream
+func (b *BackupStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return b.put(dst, rs, nil)
+}
+
+// WriteWithMetadata creates a item with the HTTP metadata on the backup target from io stream
+func (b *BackupStoreDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *backupstore.ObjectMetadata) error {
+	return b.put(dst, rs, metadata)
+}
+
+// GetMetadata returns the HTTP metadata of the item on the backup target
+func (b *BackupStoreDriver) GetMetadata(filePath string) (*backupstore.ObjectMetadata, error) {
+	obj, ok := b.get(filePath)
+	if !ok {
+		return nil, b.notFoundError(filePath)
+	}
+	metadata := obj.metadata
+	return &metadata, nil
+}
+
+// ReadRange reads the data range of the item on the backup target, a negative length reads the data until the end
+func (b *BackupStoreDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	obj, ok := b.get(src)
+	if !ok {
+		return nil, b.notFoundError(src)
+	}
+	size := int64(len(obj.data))
+	if offset > size {
+		offset = size
+	}
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	return io.NopCloser(bytes.NewReader(obj.data[offset:end])), nil
+}
+
+// Copy copies the item inside the memory store
+func (b *BackupStoreDriver) Copy(src, dst string) error {
+	obj, ok := b.get(src)
+	if !ok {
+		return b.notFoundError(src)
+	}
+	return b.put(dst, bytes.NewReader(obj.data), &obj.metadata)
+}
+
+// Upload creates a item on the backup target by opening source file
+func (b *BackupStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return b.put(dst, file, nil)
+}
+
+// Download gets a item data from the backup target
+func (b *BackupStoreDriver) Download(src, dst string) error {
+	obj, ok := b.get(src)
+	if !ok {
+		return b.notFoundError(src)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0700); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, obj.data, 0600)
+}
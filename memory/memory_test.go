@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/client"
+)
+
+type memorySnapshot struct {
+	data []byte
+}
+
+func (s *memorySnapshot) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(s.data).ReadAt(p, off)
+}
+
+func (s *memorySnapshot) DataExtents() ([]client.Extent, error) {
+	return []client.Extent{{Offset: 0, Length: int64(len(s.data))}}, nil
+}
+
+func TestDriver(t *testing.T) {
+	assert := assert.New(t)
+
+	destURL := "memory://test-driver/path/"
+	defer Reset(destURL) // nolint:errcheck
+	driver, err := initFunc(destURL)
+	assert.NoError(err)
+	assert.Equal("memory://test-driver/path", driver.GetURL())
+
+	assert.NoError(driver.Write("volumes/01/volume.cfg", bytes.NewReader([]byte("config"))))
+	assert.NoError(driver.Write("volumes/02/blocks/a.blk", bytes.NewReader([]byte("0123456789"))))
+
+	// The drivers of the same store share the objects
+	other, err := backupstore.GetBackupStoreDriver(destURL)
+	assert.NoError(err)
+	assert.True(other.FileExists("volumes/01/volume.cfg"))
+	assert.Equal(int64(6), other.FileSize("volumes/01/volume.cfg"))
+	assert.False(other.FileTime("volumes/01/volume.cfg").IsZero())
+	assert.Equal(int64(-1), other.FileSize("volumes/01"))
+	separate, err := backupstore.GetBackupStoreDriver("memory://other-store/path/")
+	assert.NoError(err)
+	assert.False(separate.FileExists("volumes/01/volume.cfg"))
+
+	names, err := driver.List("volumes")
+	assert.NoError(err)
+	assert.Equal([]string{"01", "02"}, names)
+	names, err = driver.List("missing")
+	assert.NoError(err)
+	assert.Empty(names)
+	names, err = driver.(backupstore.PrefixListingBackupStoreDriver).ListPrefix("volumes/")
+	assert.NoError(err)
+	assert.Equal([]string{"volumes/01/volume.cfg", "volumes/02/blocks/a.blk"}, names)
+
+	rc, err := driver.(backupstore.ObjectMetadataBackupStoreDriver).ReadRange("volumes/02/blocks/a.blk", 3, 4)
+	assert.NoError(err)
+	data, err := io.ReadAll(rc)
+	assert.NoError(err)
+	assert.Equal("3456", string(data))
+	_, err = driver.Read("volumes/01/missing.cfg")
+	assert.ErrorIs(err, os.ErrNotExist)
+
+	assert.NoError(driver.(backupstore.CopyingBackupStoreDriver).Copy("volumes/01/volume.cfg", "volumes/03/volume.cfg"))
+	dst := filepath.Join(t.TempDir(), "volume.cfg")
+	assert.NoError(driver.Download("volumes/03/volume.cfg", dst))
+	data, err = os.ReadFile(dst)
+	assert.NoError(err)
+	assert.Equal("config", string(data))
+
+	assert.NoError(driver.Remove("volumes/02"))
+	names, err = driver.List("volumes")
+	assert.NoError(err)
+	assert.Equal([]string{"01", "03"}, names)
+
+	assert.NoError(Reset(destURL))
+	driver, err = initFunc(destURL)
+	assert.NoError(err)
+	assert.False(driver.FileExists("volumes/01/volume.cfg"))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	destURL := "memory://test-backup/"
+	defer Reset(destURL) // nolint:errcheck
+	c, err := client.New(destURL, client.Options{})
+	assert.NoError(err)
+
+	data := make([]byte, 2*client.BlockSize)
+	copy(data, "first block")
+	copy(data[client.BlockSize:], "second block")
+	_, err = c.Backup(ctx, client.BackupOptions{
+		VolumeName:   "volume",
+		VolumeSize:   int64(len(data)),
+		SnapshotName: "snap1",
+		BackupName:   "backup1",
+		Source:       &memorySnapshot{data: data},
+	})
+	assert.NoError(err)
+
+	path := filepath.Join(t.TempDir(), "volume.img")
+	assert.NoError(c.Restore(ctx, client.RestoreOptions{VolumeName: "volume", BackupName: "backup1", Path: path}))
+	restored, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal(data, restored)
+
+	assert.NoError(c.DeleteVolume(ctx, "volume"))
+	volumes, err := c.ListVolumes(ctx)
+	assert.NoError(err)
+	assert.Empty(volumes)
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "backupstore"
+
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	// ResultConflict is the replication result of a backup which exists in the secondary target with different content
+	ResultConflict = "conflict"
+)
+
+var (
+	// S3EndpointOperations counts the S3 operations served by each configured endpoint
+	S3EndpointOperations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "endpoint_operations_total",
+			Help:      "Number of S3 operations served by each endpoint",
+		},
+		[]string{"endpoint", "operation", "result"},
+	)
+
+	// S3EndpointFailovers counts the failovers from an endpoint to the next one after connection errors
+	S3EndpointFailovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "endpoint_failovers_total",
+			Help:      "Number of failovers from the endpoint after connection errors",
+		},
+		[]string{"endpoint"},
+	)
+
+	// S3EndpointHealthy is 0 while the endpoint is skipped after the failures, and 1 once it recovers
+	S3EndpointHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "endpoint_healthy",
+			Help:      "Whether the endpoint is used by the requests, 0 while it's skipped after the failures",
+		},
+		[]string{"endpoint"},
+	)
+
+	// S3ThrottledRequests counts the S3 requests throttled by the bucket rate limits, which are retried later
+	S3ThrottledRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "throttled_requests_total",
+			Help:      "Number of S3 requests throttled by the bucket rate limits",
+		},
+		[]string{"operation"},
+	)
+
+	// S3ConcurrencyLimit is the concurrency of the S3 requests to each bucket reduced after the throttling, 0
+	// for the unlimited concurrency
+	S3ConcurrencyLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "s3",
+			Name:      "concurrency_limit",
+			Help:      "Max concurrent S3 requests to the bucket after the throttling, 0 if unlimited",
+		},
+		[]string{"bucket"},
+	)
+
+	// ReplicationLag is the age of the oldest backup of each volume not yet replicated to the secondary target
+	ReplicationLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "replication",
+			Name:      "lag_seconds",
+			Help:      "Age in seconds of the oldest backup not yet replicated to the secondary target",
+		},
+		[]string{"source", "destination", "volume"},
+	)
+
+	// ReplicatedBackups counts the backups handled by the replication
+	ReplicatedBackups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "replication",
+			Name:      "backups_total",
+			Help:      "Number of backups handled by the replication by the result",
+		},
+		[]string{"source", "destination", "result"},
+	)
+
+	// ReplicationLastSyncTime is the time of the last completed replication pass
+	ReplicationLastSyncTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "replication",
+			Name:      "last_sync_timestamp_seconds",
+			Help:      "Unix time of the last completed replication pass",
+		},
+		[]string{"source", "destination"},
+	)
+
+	// DriverOperationDuration is the latency of the backup store driver operations. The backup target is
+	// identified by the hash of the URL, to keep the credentials and the long paths out of the labels.
+	DriverOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "driver",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the backup store driver operations",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		},
+		[]string{"kind", "target", "operation", "result"},
+	)
+
+	collectors = []prometheus.Collector{
+		S3EndpointOperations,
+		S3EndpointFailovers,
+		S3EndpointHealthy,
+		S3ThrottledRequests,
+		S3ConcurrencyLimit,
+		ReplicationLag,
+		ReplicatedBackups,
+		ReplicationLastSyncTime,
+		DriverOperationDuration,
+	}
+)
+
+// Register registers the backupstore metrics to the given registerer, e.g. prometheus.DefaultRegisterer.
+func Register(registerer prometheus.Registerer) error {
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
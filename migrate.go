@@ -0,0 +1,397 @@
+package backupstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	REDIRECT_MARKER_FILE = "redirect.cfg"
+
+	// MigrationMaxCatchUpPasses is the max number of the catch-up passes before the final pass under lock
+	MigrationMaxCatchUpPasses = 5
+	// MigrationCatchUpThreshold is the number of the changed files in a catch-up pass which is small enough
+	// for the final pass under lock
+	MigrationCatchUpThreshold = 16
+)
+
+// TargetRedirect is the marker written into the old backup target after it's migrated to a new one.
+type TargetRedirect struct {
+	URL        string
+	MigratedAt string
+}
+
+// ErrTargetRedirected is returned when creating backups in a backup target which has been migrated.
+type ErrTargetRedirected struct {
+	DestURL string
+	NewURL  string
+}
+
+func (e *ErrTargetRedirected) Error() string {
+	return fmt.Sprintf("backup target %v has been migrated to %v, update the backup target URL", e.DestURL, e.NewURL)
+}
+
+func getRedirectMarkerFilePath() string {
+	return filepath.Join(backupstoreBase, REDIRECT_MARKER_FILE)
+}
+
+// GetTargetRedirect returns the redirect marker of the backup target, or nil if the target is not migrated.
+func GetTargetRedirect(driver BackupStoreDriver) (*TargetRedirect, error) {
+	filePath := getRedirectMarkerFilePath()
+	if !driver.FileExists(filePath) {
+		return nil, nil
+	}
+	redirect := &TargetRedirect{}
+	if err := LoadConfigInBackupStore(driver, filePath, redirect); err != nil {
+		return nil, err
+	}
+	return redirect, nil
+}
+
+// CheckTargetRedirect returns ErrTargetRedirected if the backup target has been migrated.
+func CheckTargetRedirect(driver BackupStoreDriver) error {
+	redirect, err := GetTargetRedirect(driver)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check redirect marker of backup target %v", driver.GetURL())
+	}
+	if redirect != nil {
+		return &ErrTargetRedirected{DestURL: driver.GetURL(), NewURL: redirect.URL}
+	}
+	return nil
+}
+
+// MigrateTarget copies everything in the old backup target to the new one. After the initial copy, the changes
+// made in the meantime are copied by the catch-up passes. The last pass runs with all the backup volumes locked,
+// then a redirect marker is written into the old backup target so no more backups are created there.
+func MigrateTarget(oldURL, newURL string) error {
+	return MigrateTargetWithOptions(oldURL, newURL, MigrateOptions{})
+}
+
+// MigrateOptions are the options of MigrateTargetWithOptions.
+type MigrateOptions struct {
+	// Verify checks every file of the old backup target is in the new one after the final pass, before the
+	// redirect marker is written
+	Verify bool
+}
+
+// MigrateTargetWithOptions migrates the backup target like MigrateTarget. The interrupted migration is resumed
+// from the manifest kept in the new backup target, only the files changed since they were copied are copied
+// again.
+func MigrateTargetWithOptions(oldURL, newURL string, opts MigrateOptions) error {
+	src, err := GetBackupStoreDriver(oldURL)
+	if err != nil {
+		return err
+	}
+	dst, err := GetBackupStoreDriver(newURL)
+	if err != nil {
+		return err
+	}
+	log := log.WithFields(logrus.Fields{
+		"oldURL": src.GetURL(),
+		"newURL": dst.GetURL(),
+	})
+
+	if err := CheckTargetRedirect(src); err != nil {
+		return err
+	}
+
+	m := &targetMigration{src: src, dst: dst, copied: map[string]bool{}, dstBlocks: map[string]map[string]bool{}}
+	if err := m.loadManifest(); err != nil {
+		return err
+	}
+	since := time.Time{}
+	for i := 0; i <= MigrationMaxCatchUpPasses; i++ {
+		start := util.GetClock().Now().UTC()
+		count, err := m.pass(since)
+		if err != nil {
+			return errors.Wrapf(err, "failed to copy backup target in pass %v", i)
+		}
+		if err := m.saveManifest(); err != nil {
+			return err
+		}
+		log.Infof("Copied %v files in migration pass %v", count, i)
+		since = start
+		if i > 0 && count <= MigrationCatchUpThreshold {
+			break
+		}
+	}
+
+	var locks []*FileLock
+	defer func() {
+		for _, lock := range locks {
+			if unlockErr := lock.Unlock(); unlockErr != nil {
+				log.WithError(unlockErr).Warn("Failed to unlock")
+			}
+		}
+	}()
+	// The volumes are listed again once locked, so the ones created in the meantime are locked as well
+	locked := map[string]bool{}
+	for {
+		volumeNames, err := m.getLockNames()
+		if err != nil {
+			return err
+		}
+		newVolumes := 0
+		for _, volumeName := range volumeNames {
+			if locked[volumeName] {
+				continue
+			}
+			lock, err := New(src, volumeName, DELETION_LOCK)
+			if err != nil {
+				return err
+			}
+			if err := lock.Lock(); err != nil {
+				return errors.Wrapf(err, "failed to lock backup volume %v for the final migration pass", volumeName)
+			}
+			locks = append(locks, lock)
+			locked[volumeName] = true
+			newVolumes++
+		}
+		if newVolumes == 0 {
+			break
+		}
+	}
+
+	count, err := m.pass(since)
+	if err != nil {
+		return errors.Wrap(err, "failed to copy backup target in the final pass")
+	}
+	removed, err := m.removeDeleted()
+	if err != nil {
+		return err
+	}
+	log.Infof("Copied %v files and removed %v deleted files in the final migration pass", count, removed)
+
+	if opts.Verify {
+		result, err := verifyMigration(src, dst)
+		if err != nil {
+			return err
+		}
+		if !result.Succeeded() {
+			return fmt.Errorf("failed to verify migration: %v", result)
+		}
+		log.Infof("Verified %v files in the new backup target", result.VerifiedFiles)
+	}
+
+	// The volume created after the last listing is neither locked nor surely copied
+	volumeNames, err := m.getLockNames()
+	if err != nil {
+		return err
+	}
+	for _, volumeName := range volumeNames {
+		if !locked[volumeName] {
+			return fmt.Errorf("backup volume %v is created during the final migration pass", volumeName)
+		}
+	}
+
+	redirect := &TargetRedirect{
+		URL:        newURL,
+		MigratedAt: util.Now(),
+	}
+	if err := SaveConfigInBackupStore(src, getRedirectMarkerFilePath(), redirect); err != nil {
+		return errors.Wrap(err, "failed to write redirect marker")
+	}
+	if err := m.removeManifest(); err != nil {
+		log.WithError(err).Warn("Failed to remove migration manifest")
+	}
+	log.Info("Migrated backup target")
+	return nil
+}
+
+type targetMigration struct {
+	src    BackupStoreDriver
+	dst    BackupStoreDriver
+	copied map[string]bool
+	seen   map[string]bool
+
+	// manifest records the copied files, so the interrupted migration can be resumed
+	manifest *MigrationManifest
+	// unsaved is the number of the files copied since the manifest is saved
+	unsaved int
+
+	// dstBlocks caches the block manifests of the destination by the blocks directory
+	dstBlocks map[string]map[string]bool
+}
+
+// getLockNames returns the backup volumes to be locked, including the one shared by the backing images.
+func (m *targetMigration) getLockNames() ([]string, error) {
+	jobQueues := workerpool.New(runtime.NumCPU() * 16)
+	defer jobQueues.StopWait()
+
+	volumeNames, err := getVolumeNames(jobQueues, m.src)
+	if err != nil {
+		return nil, err
+	}
+	for _, volumeName := range volumeNames {
+		if volumeName == types.BackupBackingImageLockName {
+			return volumeNames, nil
+		}
+	}
+	return append(volumeNames, types.BackupBackingImageLockName), nil
+}
+
+// pass copies the files which are not copied yet, or changed since the given time.
+func (m *targetMigration) pass(since time.Time) (int, error) {
+	m.seen = map[string]bool{}
+	count := 0
+	err := walkFiles(m.src, backupstoreBase, func(filePath string) error {
+		m.seen[filePath] = true
+		changed, err := m.isChanged(filePath, since)
+		if err != nil || !changed {
+			return err
+		}
+		if err := copyFile(m.src, m.dst, filePath); err != nil {
+			return err
+		}
+		m.copied[filePath] = true
+		count++
+		return m.recordCopied(filePath)
+	})
+	return count, err
+}
+
+func (m *targetMigration) isChanged(filePath string, since time.Time) (bool, error) {
+	// The blocks are addressed by the checksums, so they never change and the ones already
+	// in the destination don't need to be transferred
+	if isBlockFile(filePath) {
+		if m.copied[filePath] {
+			return false, nil
+		}
+		exists, err := m.dstBlockExists(filePath)
+		if err != nil || !exists {
+			return true, err
+		}
+		m.copied[filePath] = true
+		return false, nil
+	}
+	if !m.copied[filePath] {
+		// The files copied by the interrupted migration are copied again only if they're changed
+		if !m.isCopiedBefore(filePath) {
+			return true, nil
+		}
+		m.copied[filePath] = true
+		return false, nil
+	}
+	if m.src.FileSize(filePath) != m.dst.FileSize(filePath) {
+		return true, nil
+	}
+	// Allow the second precision of the file times
+	return !m.src.FileTime(filePath).Before(since.Add(-time.Second)), nil
+}
+
+// dstBlockExists checks the block against the manifest of the destination, which is listed once
+// for each blocks directory.
+func (m *targetMigration) dstBlockExists(filePath string) (bool, error) {
+	blocksDir := filepath.Dir(filepath.Dir(filepath.Dir(filePath)))
+	manifest, exists := m.dstBlocks[blocksDir]
+	if !exists {
+		var err error
+		if manifest, err = getBlockFileManifest(m.dst, blocksDir); err != nil {
+			return false, errors.Wrapf(err, "failed to get block manifest of %v in the destination", blocksDir)
+		}
+		m.dstBlocks[blocksDir] = manifest
+	}
+	return manifest[filePath], nil
+}
+
+// removeDeleted removes the copied files which are deleted from the source in the meantime.
+func (m *targetMigration) removeDeleted() (int, error) {
+	count := 0
+	for filePath := range m.copied {
+		if m.seen[filePath] {
+			continue
+		}
+		if err := m.dst.Remove(filePath); err != nil {
+			return count, errors.Wrapf(err, "failed to remove deleted file %v", filePath)
+		}
+		delete(m.copied, filePath)
+		delete(m.manifest.Files, filePath)
+		count++
+	}
+	// The files copied by the interrupted migration may be deleted from the source before the resume
+	for filePath := range m.manifest.Files {
+		if m.seen[filePath] || m.copied[filePath] {
+			continue
+		}
+		if err := m.dst.Remove(filePath); err != nil {
+			return count, errors.Wrapf(err, "failed to remove deleted file %v", filePath)
+		}
+		delete(m.manifest.Files, filePath)
+		count++
+	}
+	return count, nil
+}
+
+// walkFiles calls the function for each file under the directory. The lock files, the temporary files, the
+// redirect marker, the migration manifest and the index of the hard-linked blocks are skipped.
+func walkFiles(driver BackupStoreDriver, dir string, fn func(filePath string) error) error {
+	names, err := driver.List(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list %v", dir)
+	}
+	for _, name := range names {
+		filePath := filepath.Join(dir, name)
+		if name == LOCKS_DIRECTORY || strings.Contains(name, ".tmp.") || filePath == getRedirectMarkerFilePath() ||
+			filePath == getMigrationManifestFilePath() || filePath == filepath.Join(backupstoreBase, BLOCK_LINKS_DIRECTORY) {
+			continue
+		}
+		if driver.FileExists(filePath) {
+			if err := fn(filePath); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := walkFiles(driver, filePath, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file between the backup targets. The data is staged in a temporary file since
+// the single file backups can be large.
+func copyFile(src, dst BackupStoreDriver, filePath string) error {
+	return copyFileTo(src, dst, filePath, filePath)
+}
+
+// copyFileTo copies the file to another path in the destination backup target, e.g. the block written by the
+// legacy layout in the source backup target.
+func copyFileTo(src, dst BackupStoreDriver, srcPath, dstPath string) error {
+	rc, err := src.Read(srcPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %v", srcPath)
+	}
+	defer rc.Close()
+
+	tmpFile, err := util.CreateStagingFile("backupstore-copy-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}()
+
+	if _, err := io.Copy(tmpFile, rc); err != nil {
+		return errors.Wrapf(err, "failed to read %v", srcPath)
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := dst.Write(dstPath, tmpFile); err != nil {
+		return errors.Wrapf(err, "failed to write %v", dstPath)
+	}
+	return nil
+}
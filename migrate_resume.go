@@ -0,0 +1,159 @@
+package backupstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	MIGRATION_MANIFEST_FILE = "migration.cfg"
+
+	// MigrationManifestSaveInterval is the number of the copied files between the saves of the manifest
+	MigrationManifestSaveInterval = 64
+)
+
+// MigrationManifest is kept in the new backup target during the migration, and records the files copied from
+// the old backup target. The blocks are not recorded since they never change and are listed instead.
+type MigrationManifest struct {
+	SourceURL string
+	StartedAt string
+	UpdatedAt string
+	Files     map[string]MigratedFile
+}
+
+// MigratedFile is the state of the file in the old backup target when it's copied.
+type MigratedFile struct {
+	Size       int64 `json:",string"`
+	SourceTime time.Time
+}
+
+func getMigrationManifestFilePath() string {
+	return filepath.Join(backupstoreBase, MIGRATION_MANIFEST_FILE)
+}
+
+// loadManifest loads the manifest of the interrupted migration from the same backup target, or starts a new one.
+func (m *targetMigration) loadManifest() error {
+	m.manifest = &MigrationManifest{
+		SourceURL: m.src.GetURL(),
+		StartedAt: util.Now(),
+		Files:     map[string]MigratedFile{},
+	}
+	filePath := getMigrationManifestFilePath()
+	if !m.dst.FileExists(filePath) {
+		return nil
+	}
+
+	manifest := &MigrationManifest{}
+	if err := LoadConfigInBackupStore(m.dst, filePath, manifest); err != nil {
+		return errors.Wrap(err, "failed to load migration manifest")
+	}
+	if manifest.SourceURL != m.src.GetURL() {
+		return fmt.Errorf("backup target %v is being migrated from another backup target %v", m.dst.GetURL(), manifest.SourceURL)
+	}
+	if manifest.Files == nil {
+		manifest.Files = map[string]MigratedFile{}
+	}
+	m.manifest = manifest
+	log.Infof("Resuming migration started at %v with %v copied files", manifest.StartedAt, len(manifest.Files))
+	return nil
+}
+
+// isCopiedBefore checks the file is copied by the interrupted migration, and is not changed since then.
+func (m *targetMigration) isCopiedBefore(filePath string) bool {
+	file, exists := m.manifest.Files[filePath]
+	if !exists {
+		return false
+	}
+	return m.src.FileSize(filePath) == file.Size && m.dst.FileSize(filePath) == file.Size &&
+		m.src.FileTime(filePath).Equal(file.SourceTime)
+}
+
+// recordCopied records the copied file in the manifest, which is saved once in a while.
+func (m *targetMigration) recordCopied(filePath string) error {
+	if isBlockFile(filePath) {
+		return nil
+	}
+	m.manifest.Files[filePath] = MigratedFile{
+		Size:       m.src.FileSize(filePath),
+		SourceTime: m.src.FileTime(filePath),
+	}
+	m.unsaved++
+	if m.unsaved < MigrationManifestSaveInterval {
+		return nil
+	}
+	return m.saveManifest()
+}
+
+func (m *targetMigration) saveManifest() error {
+	// The manifest cannot be updated in the immutable backup target, so the migration is not resumable
+	if IsImmutableTarget(m.dst) {
+		return nil
+	}
+	m.manifest.UpdatedAt = util.Now()
+	if err := SaveConfigInBackupStore(m.dst, getMigrationManifestFilePath(), m.manifest); err != nil {
+		return errors.Wrap(err, "failed to save migration manifest")
+	}
+	m.unsaved = 0
+	return nil
+}
+
+func (m *targetMigration) removeManifest() error {
+	if IsImmutableTarget(m.dst) {
+		return nil
+	}
+	return m.dst.Remove(getMigrationManifestFilePath())
+}
+
+// MigrationVerification is the result of the comparison between the old and the new backup targets.
+type MigrationVerification struct {
+	VerifiedFiles int
+	// MissingFiles are absent in the new backup target
+	MissingFiles []string
+	// MismatchedFiles are of different sizes in the backup targets
+	MismatchedFiles []string
+}
+
+func (v *MigrationVerification) Succeeded() bool {
+	return len(v.MissingFiles) == 0 && len(v.MismatchedFiles) == 0
+}
+
+func (v *MigrationVerification) String() string {
+	return fmt.Sprintf("%v missing files %v and %v mismatched files %v of %v files",
+		len(v.MissingFiles), v.MissingFiles, len(v.MismatchedFiles), v.MismatchedFiles, v.VerifiedFiles)
+}
+
+// VerifyMigration checks every file of the old backup target is in the new one with the same size.
+func VerifyMigration(oldURL, newURL string) (*MigrationVerification, error) {
+	src, err := GetBackupStoreDriver(oldURL)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := GetBackupStoreDriver(newURL)
+	if err != nil {
+		return nil, err
+	}
+	return verifyMigration(src, dst)
+}
+
+func verifyMigration(src, dst BackupStoreDriver) (*MigrationVerification, error) {
+	result := &MigrationVerification{MissingFiles: []string{}, MismatchedFiles: []string{}}
+	err := walkFiles(src, backupstoreBase, func(filePath string) error {
+		result.VerifiedFiles++
+		size := dst.FileSize(filePath)
+		if size < 0 {
+			result.MissingFiles = append(result.MissingFiles, filePath)
+		} else if size != src.FileSize(filePath) {
+			result.MismatchedFiles = append(result.MismatchedFiles, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
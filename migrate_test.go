@@ -0,0 +1,197 @@
+package backupstore
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestMigrateTarget(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{delay: time.Millisecond}
+	m.Init()
+	defer m.uninstall()
+
+	newDriverURL := "mock2://localhost"
+	dst := &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: newDriverURL}
+	err := RegisterDriver("mock2", func(destURL string) (BackupStoreDriver, error) {
+		return dst, nil
+	})
+	assert.NoError(err)
+	defer unregisterDriver("mock2") // nolint:errcheck
+
+	checksum := "0123456789abcdef"
+	err = m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","CreatedTime":"2021-06-07T08:00:00Z"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBlockFilePath("pvc-1", checksum), []byte("data"), 0644)
+	assert.NoError(err)
+
+	err = MigrateTarget(mockDriverURL, newDriverURL)
+	assert.NoError(err)
+
+	assert.True(dst.FileExists(getVolumeFilePath("pvc-1")))
+	assert.True(dst.FileExists(getBackupConfigPath("backup-1", "pvc-1")))
+	data, err := afero.ReadFile(dst.fs, getBlockFilePath("pvc-1", checksum))
+	assert.NoError(err)
+	assert.Equal("data", string(data))
+	exists, err := afero.DirExists(dst.fs, getLockPath("pvc-1"))
+	assert.NoError(err)
+	assert.False(exists)
+
+	redirect, err := GetTargetRedirect(m)
+	assert.NoError(err)
+	assert.Equal(newDriverURL, redirect.URL)
+	assert.Error(CheckTargetRedirect(m))
+	assert.NoError(CheckTargetRedirect(dst))
+
+	err = MigrateTarget(mockDriverURL, newDriverURL)
+	assert.Error(err)
+}
+
+func TestMigrateTargetResume(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	util.SetClock(util.NewFakeClock(time.Now()))
+	defer util.SetClock(nil)
+
+	newDriverURL := "mock2://localhost"
+	dst := &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: newDriverURL}
+	err := RegisterDriver("mock2", func(destURL string) (BackupStoreDriver, error) {
+		return dst, nil
+	})
+	assert.NoError(err)
+	defer unregisterDriver("mock2") // nolint:errcheck
+
+	backupConfig := `{"Name":"backup-1","VolumeName":"pvc-1","CreatedTime":"2021-06-07T08:00:00Z"}`
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"), []byte(backupConfig), 0644)
+	assert.NoError(err)
+	old := time.Now().Add(-time.Hour)
+	for _, filePath := range []string{getVolumeFilePath("pvc-1"), getBackupConfigPath("backup-1", "pvc-1")} {
+		err = m.fs.Chtimes(filePath, old, old)
+		assert.NoError(err)
+	}
+
+	// The interrupted migration copied the backup config and a volume config changed since then
+	copied := strings.Replace(backupConfig, "backup-1", "backup-x", 1)
+	err = afero.WriteFile(dst.fs, getBackupConfigPath("backup-1", "pvc-1"), []byte(copied), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(dst.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-0"}`), 0644)
+	assert.NoError(err)
+	err = SaveConfigInBackupStore(dst, getMigrationManifestFilePath(), &MigrationManifest{
+		SourceURL: mockDriverURL,
+		Files: map[string]MigratedFile{
+			getBackupConfigPath("backup-1", "pvc-1"): {
+				Size:       m.FileSize(getBackupConfigPath("backup-1", "pvc-1")),
+				SourceTime: m.FileTime(getBackupConfigPath("backup-1", "pvc-1")),
+			},
+			getVolumeFilePath("pvc-1"): {
+				Size:       m.FileSize(getVolumeFilePath("pvc-1")),
+				SourceTime: old.Add(-time.Hour),
+			},
+		},
+	})
+	assert.NoError(err)
+
+	err = MigrateTargetWithOptions(mockDriverURL, newDriverURL, MigrateOptions{Verify: true})
+	assert.NoError(err)
+
+	data, err := afero.ReadFile(dst.fs, getBackupConfigPath("backup-1", "pvc-1"))
+	assert.NoError(err)
+	assert.Equal(copied, string(data))
+	data, err = afero.ReadFile(dst.fs, getVolumeFilePath("pvc-1"))
+	assert.NoError(err)
+	assert.Equal(`{"Name":"pvc-1"}`, string(data))
+	assert.False(dst.FileExists(getMigrationManifestFilePath()))
+
+	// The verification reports the files absent in the new backup target
+	err = dst.Remove(getVolumeFilePath("pvc-1"))
+	assert.NoError(err)
+	result, err := VerifyMigration(mockDriverURL, newDriverURL)
+	assert.NoError(err)
+	assert.False(result.Succeeded())
+	assert.Equal([]string{getVolumeFilePath("pvc-1")}, result.MissingFiles)
+}
+
+// volumeCreatingDriver creates a new volume once the first volume is locked, and records the locked volumes.
+type volumeCreatingDriver struct {
+	*mockStoreDriver
+	once   sync.Once
+	locked map[string]bool
+}
+
+func (v *volumeCreatingDriver) Write(dst string, rs io.ReadSeeker) error {
+	for _, volumeName := range []string{"pvc-1", "pvc-2"} {
+		if strings.HasPrefix(dst, getLockPath(volumeName)) {
+			v.locked[volumeName] = true
+			v.once.Do(func() {
+				_ = afero.WriteFile(v.fs, getVolumeFilePath("pvc-2"), []byte(`{"Name":"pvc-2"}`), 0644)
+			})
+		}
+	}
+	return v.mockStoreDriver.Write(dst, rs)
+}
+
+func TestMigrateTargetNewVolume(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+	src := &volumeCreatingDriver{mockStoreDriver: m, locked: map[string]bool{}}
+	unregisterDriver(mockDriverName) // nolint:errcheck
+	assert.NoError(RegisterDriver(mockDriverName, func(destURL string) (BackupStoreDriver, error) {
+		return src, nil
+	}))
+
+	util.SetClock(util.NewFakeClock(time.Now()))
+	defer util.SetClock(nil)
+
+	newDriverURL := "mock2://localhost"
+	dst := &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: newDriverURL}
+	assert.NoError(RegisterDriver("mock2", func(destURL string) (BackupStoreDriver, error) {
+		return dst, nil
+	}))
+	defer unregisterDriver("mock2") // nolint:errcheck
+
+	assert.NoError(afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1"}`), 0644))
+
+	// The volume created while the existing volumes are locked is locked and copied as well
+	assert.NoError(MigrateTarget(mockDriverURL, newDriverURL))
+	assert.Equal(map[string]bool{"pvc-1": true, "pvc-2": true}, src.locked)
+	assert.True(dst.FileExists(getVolumeFilePath("pvc-2")))
+	assert.Error(CheckTargetRedirect(m))
+}
+
+func TestMigrateTargetManifestSource(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	dst := &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: "mock2://localhost"}
+	err := SaveConfigInBackupStore(dst, getMigrationManifestFilePath(), &MigrationManifest{SourceURL: "mock3://localhost"})
+	assert.NoError(err)
+
+	migration := &targetMigration{src: m, dst: dst}
+	assert.Error(migration.loadManifest())
+}
@@ -0,0 +1,153 @@
+package nfs
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mount "k8s.io/mount-utils"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// The backup target URL query parameters configuring the security flavor of the NFS share, e.g.
+	// nfs://server:/path/?nfsSec=krb5p&nfsKrb5CCache=/var/lib/backupstore/krb5cc. The flavor can also be set by
+	// sec in nfsOptions, and multiple flavors are separated by colons.
+	NfsSecOption        = "nfsSec"
+	NfsKrb5CCacheOption = "nfsKrb5CCache"
+
+	SecSys   = "sys"
+	SecKrb5  = "krb5"
+	SecKrb5i = "krb5i"
+	SecKrb5p = "krb5p"
+
+	secMountOption = "sec"
+)
+
+var (
+	supportedSecFlavors = map[string]bool{SecSys: true, SecKrb5: true, SecKrb5i: true, SecKrb5p: true}
+)
+
+// parseSecurity parses the security flavors and the Kerberos credentials cache in the URL query. The flavors
+// set in the URL are added to the overridden mount options if the options don't set them.
+func (b *BackupStoreDriver) parseSecurity(values url.Values) error {
+	flavors := values.Get(NfsSecOption)
+	if optionFlavors := getMountOptionValue(b.mountOptions, secMountOption); optionFlavors != "" {
+		if flavors != "" && flavors != optionFlavors {
+			return fmt.Errorf("conflicting %v %v and nfsOptions sec=%v in NFS URL", NfsSecOption, flavors, optionFlavors)
+		}
+		flavors = optionFlavors
+	} else if flavors != "" && len(b.mountOptions) > 0 {
+		b.mountOptions = append(b.mountOptions, secMountOption+"="+flavors)
+	}
+
+	if flavors != "" {
+		for _, flavor := range strings.Split(flavors, ":") {
+			if !supportedSecFlavors[flavor] {
+				return fmt.Errorf("unsupported NFS security flavor %v, must be one of %v, %v, %v or %v", flavor,
+					SecSys, SecKrb5, SecKrb5i, SecKrb5p)
+			}
+		}
+		b.secFlavors = strings.Split(flavors, ":")
+	}
+
+	b.krb5CCache = values.Get(NfsKrb5CCacheOption)
+	if b.krb5CCache == "" {
+		return nil
+	}
+	if !b.usesKerberos() {
+		return fmt.Errorf("%v requires a Kerberos security flavor in NFS URL", NfsKrb5CCacheOption)
+	}
+	// Only the file caches can be checked before the mount, the other types are left to the Kerberos library
+	if path, isFile := getCCacheFilePath(b.krb5CCache); isFile {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("cannot read Kerberos credentials cache %v: %v", path, err)
+		}
+		f.Close()
+	}
+	log.Infof("Using Kerberos credentials cache %v for NFS path %v", b.krb5CCache, b.serverPath)
+	return nil
+}
+
+func getCCacheFilePath(ccache string) (string, bool) {
+	if strings.HasPrefix(ccache, "FILE:") {
+		return strings.TrimPrefix(ccache, "FILE:"), true
+	}
+	if strings.Contains(ccache, ":") {
+		return "", false
+	}
+	return ccache, true
+}
+
+func (b *BackupStoreDriver) usesKerberos() bool {
+	for _, flavor := range b.secFlavors {
+		if flavor != SecSys {
+			return true
+		}
+	}
+	return false
+}
+
+// withSecurityOption returns the mount options picked by the driver with the security flavors.
+func (b *BackupStoreDriver) withSecurityOption(options []string) []string {
+	if len(b.secFlavors) == 0 {
+		return options
+	}
+	return append(options, secMountOption+"="+strings.Join(b.secFlavors, ":"))
+}
+
+// getMountEnv returns the environment variables of the mount helper.
+func (b *BackupStoreDriver) getMountEnv() []string {
+	if b.krb5CCache == "" {
+		return nil
+	}
+	return []string{"KRB5CCNAME=" + b.krb5CCache}
+}
+
+// validateSecurityFlavor checks the security flavor the share is mounted with, since the server may negotiate a
+// flavor other than the requested ones. The share is unmounted if the flavor isn't requested.
+func (b *BackupStoreDriver) validateSecurityFlavor(mounter mount.Interface) error {
+	if len(b.secFlavors) == 0 {
+		return nil
+	}
+
+	mountPoints, err := mounter.List()
+	if err != nil {
+		return err
+	}
+	mountDir := filepath.Clean(b.mountDir)
+	for _, mountPoint := range mountPoints {
+		if filepath.Clean(mountPoint.Path) != mountDir {
+			continue
+		}
+		flavor := getMountOptionValue(mountPoint.Opts, secMountOption)
+		for _, requested := range b.secFlavors {
+			// The default flavor isn't shown in the mount options by some kernels
+			if flavor == requested || (flavor == "" && requested == SecSys) {
+				return nil
+			}
+		}
+		if err := util.UnmountMountPoint(b.mountDir, log); err != nil {
+			log.WithError(err).Warnf("Failed to unmount NFS share %v mounted with security flavor %v", b.destURL, flavor)
+		}
+		return fmt.Errorf("NFS share %v is mounted with security flavor %v instead of %v", b.serverPath, flavor,
+			strings.Join(b.secFlavors, ":"))
+	}
+	return fmt.Errorf("cannot find mount point %v to validate the security flavor", b.mountDir)
+}
+
+// getMountOptionValue returns the value of the last occurrence of the option, or an empty string if it isn't set.
+func getMountOptionValue(options []string, name string) string {
+	value := ""
+	for _, option := range options {
+		key, v, _ := strings.Cut(option, "=")
+		if key == name {
+			value = v
+		}
+	}
+	return value
+}
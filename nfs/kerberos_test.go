@@ -0,0 +1,102 @@
+package nfs
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mount "k8s.io/mount-utils"
+)
+
+func TestParseSecurity(t *testing.T) {
+	assert := assert.New(t)
+
+	ccache := filepath.Join(t.TempDir(), "krb5cc")
+	assert.NoError(os.WriteFile(ccache, []byte("ccache"), 0600))
+	for _, tc := range []struct {
+		name         string
+		query        string
+		mountOptions []string
+		flavors      []string
+		expected     []string
+		errMsg       string
+	}{
+		{"not set", "", nil, nil, nil, ""},
+		{"flavor in URL", "nfsSec=krb5p", nil, []string{SecKrb5p}, nil, ""},
+		{"multiple flavors", "nfsSec=krb5i:krb5p", nil, []string{SecKrb5i, SecKrb5p}, nil, ""},
+		{"flavor added to nfsOptions", "nfsSec=krb5", []string{"nfsvers=4.1"}, []string{SecKrb5},
+			[]string{"nfsvers=4.1", "sec=krb5"}, ""},
+		{"flavor in nfsOptions", "", []string{"nfsvers=4.1", "sec=krb5i"}, []string{SecKrb5i},
+			[]string{"nfsvers=4.1", "sec=krb5i"}, ""},
+		{"same flavor in URL and nfsOptions", "nfsSec=krb5i", []string{"sec=krb5i"}, []string{SecKrb5i},
+			[]string{"sec=krb5i"}, ""},
+		{"conflicting flavors", "nfsSec=krb5p", []string{"sec=krb5"}, nil, nil, "conflicting nfsSec krb5p"},
+		{"unsupported flavor", "nfsSec=spkm3", nil, nil, nil, "unsupported NFS security flavor spkm3"},
+		{"unsupported flavor in nfsOptions", "", []string{"sec=sys:lkey"}, nil, nil, "unsupported NFS security flavor lkey"},
+		{"credentials cache", "nfsSec=krb5&nfsKrb5CCache=" + ccache, nil, []string{SecKrb5}, nil, ""},
+		{"credentials cache of file type", "nfsSec=krb5&nfsKrb5CCache=FILE:" + ccache, nil, []string{SecKrb5}, nil, ""},
+		{"credentials cache of other type", "nfsSec=krb5&nfsKrb5CCache=KEYRING:persistent:0", nil, []string{SecKrb5}, nil, ""},
+		{"missing credentials cache", "nfsSec=krb5&nfsKrb5CCache=" + ccache + ".missing", nil, nil, nil,
+			"cannot read Kerberos credentials cache"},
+		{"credentials cache without Kerberos", "nfsSec=sys&nfsKrb5CCache=" + ccache, nil, nil, nil,
+			"requires a Kerberos security flavor"},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err, tc.name)
+		b := &BackupStoreDriver{mountOptions: tc.mountOptions}
+		err = b.parseSecurity(values)
+		if tc.errMsg != "" {
+			assert.ErrorContains(err, tc.errMsg, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.flavors, b.secFlavors, tc.name)
+		assert.Equal(tc.expected, b.mountOptions, tc.name)
+		assert.Equal(values.Get(NfsKrb5CCacheOption), b.krb5CCache, tc.name)
+	}
+}
+
+func TestSecurityMountOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &BackupStoreDriver{}
+	assert.Equal([]string{"nfsvers=4.2"}, b.withSecurityOption([]string{"nfsvers=4.2"}))
+	assert.Nil(b.getMountEnv())
+
+	b = &BackupStoreDriver{secFlavors: []string{SecKrb5i, SecKrb5p}, krb5CCache: "FILE:/tmp/krb5cc"}
+	assert.Equal([]string{"nfsvers=4.2", "sec=krb5i:krb5p"}, b.withSecurityOption([]string{"nfsvers=4.2"}))
+	assert.Equal([]string{"KRB5CCNAME=FILE:/tmp/krb5cc"}, b.getMountEnv())
+}
+
+func TestValidateSecurityFlavor(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		name    string
+		flavors []string
+		opts    []string
+		valid   bool
+	}{
+		{"not requested", nil, []string{"rw", "sec=sys"}, true},
+		{"requested flavor", []string{SecKrb5p}, []string{"rw", "sec=krb5p"}, true},
+		{"one of requested flavors", []string{SecKrb5i, SecKrb5p}, []string{"rw", "sec=krb5i"}, true},
+		{"default flavor not shown", []string{SecSys}, []string{"rw"}, true},
+		{"negotiated weaker flavor", []string{SecKrb5p}, []string{"rw", "sec=sys"}, false},
+	} {
+		mountDir := t.TempDir()
+		mounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "server:/export", Path: mountDir, Type: "nfs4", Opts: tc.opts}})
+		b := &BackupStoreDriver{serverPath: "server:/export", mountDir: mountDir, secFlavors: tc.flavors}
+		err := b.validateSecurityFlavor(mounter)
+		if tc.valid {
+			assert.NoError(err, tc.name)
+			continue
+		}
+		assert.ErrorContains(err, "is mounted with security flavor sys instead of krb5p", tc.name)
+	}
+
+	b := &BackupStoreDriver{serverPath: "server:/export", mountDir: t.TempDir(), secFlavors: []string{SecKrb5}}
+	assert.ErrorContains(b.validateSecurityFlavor(mount.NewFakeMounter(nil)), "cannot find mount point")
+}
@@ -1,10 +1,14 @@
 package nfs
 
 import (
+	"context"
 	"fmt"
 	"net/url"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
@@ -25,6 +29,9 @@ var (
 	// Ref: https://github.com/longhorn/backupstore/pull/91
 	defaultMountInterval = 1 * time.Second
 	defaultMountTimeout  = 5 * time.Second
+
+	// newMounter returns the mounter of the NFS shares
+	newMounter = func() mount.Interface { return mount.New("") }
 )
 
 type BackupStoreDriver struct {
@@ -32,6 +39,18 @@ type BackupStoreDriver struct {
 	serverPath   string
 	mountDir     string
 	mountOptions []string
+	readOnly     bool
+
+	mountInterval   time.Duration
+	mountTimeout    time.Duration
+	mountRetryCount int
+	v3Fallback      bool
+
+	secFlavors []string
+	krb5CCache string
+	// ownership is the owner of the created files, which must be able to search the mount directories
+	ownership *fsops.FileOwnership
+
 	*fsops.FileSystemOperator
 }
 
@@ -40,6 +59,24 @@ const (
 
 	NfsPath = "nfs.path"
 
+	// The backup target URL query parameters configuring the ownership of the created directories and files,
+	// e.g. nfs://server:/path/?nfsUID=1000&nfsGID=1000&nfsDirMode=0770&nfsFileMode=0660
+	NfsUIDOption      = "nfsUID"
+	NfsGIDOption      = "nfsGID"
+	NfsDirModeOption  = "nfsDirMode"
+	NfsFileModeOption = "nfsFileMode"
+
+	// The backup target URL query parameters configuring the mount attempts for the slow servers,
+	// e.g. nfs://server:/path/?mountTimeout=30s&mountInterval=2s&retryCount=5
+	MountTimeoutOption  = "mountTimeout"
+	MountIntervalOption = "mountInterval"
+	RetryCountOption    = "retryCount"
+
+	// NfsV3FallbackOption falls back to NFSv3 if none of the NFSv4 minor versions works, e.g.
+	// nfs://server:/path/?nfsV3Fallback=true. NFSv3 can also be selected by nfsvers=3 in nfsOptions.
+	NfsV3FallbackOption = "nfsV3Fallback"
+	NfsV3Version        = "3"
+
 	MaxCleanupLevel = 10
 
 	UnsupportedProtocolError = "Protocol not supported"
@@ -49,10 +86,16 @@ func init() {
 	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
 		panic(err)
 	}
+	if err := backupstore.RegisterReleaseFunc(KIND, releaseFunc); err != nil {
+		panic(err)
+	}
 }
 
 func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
-	b := &BackupStoreDriver{}
+	b := &BackupStoreDriver{
+		mountInterval: defaultMountInterval,
+		mountTimeout:  defaultMountTimeout,
+	}
 	b.FileSystemOperator = fsops.NewFileSystemOperator(b)
 
 	u, err := url.Parse(destURL)
@@ -72,7 +115,7 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 
 	b.serverPath = u.Host + u.Path
 	b.destURL = KIND + "://" + b.serverPath
-	b.mountDir = filepath.Join(util.MountDir, strings.TrimRight(strings.Replace(u.Host, ".", "_", -1), ":"), u.Path)
+	b.mountDir = getMountDir(u)
 
 	nfsOptions, exist := u.Query()["nfsOptions"]
 	if exist {
@@ -80,7 +123,36 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 		log.Infof("Overriding NFS mountOptions:  %v", b.mountOptions)
 	}
 
-	if err := b.mount(); err != nil {
+	if b.readOnly, err = backupstore.IsReadOnlyTargetURL(destURL); err != nil {
+		return nil, err
+	}
+	if b.readOnly {
+		b.mountDir = util.ReadOnlyMountPoint(b.mountDir)
+	}
+
+	if err := b.parseMountAttempts(u.Query()); err != nil {
+		return nil, err
+	}
+	if err := b.parseSecurity(u.Query()); err != nil {
+		return nil, err
+	}
+	if value := u.Query().Get(NfsV3FallbackOption); value != "" {
+		if b.v3Fallback, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid %v %v in NFS URL", NfsV3FallbackOption, value)
+		}
+	}
+
+	ownership, err := parseFileOwnership(u.Query())
+	if err != nil {
+		return nil, err
+	}
+	if ownership != nil {
+		b.ownership = ownership
+		b.FileSystemOperator.SetFileOwnership(ownership)
+		log.Infof("Using file ownership %+v for NFS path %v", *ownership, b.serverPath)
+	}
+
+	if err := b.FileSystemOperator.EnsureMounted(); err != nil {
 		return nil, errors.Wrapf(err, "cannot mount nfs %v, options %v", b.serverPath, b.mountOptions)
 	}
 
@@ -93,27 +165,65 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 	return b, nil
 }
 
+// getMountDir returns the mount point of the NFS share of the URL, which is read-write.
+func getMountDir(u *url.URL) string {
+	return filepath.Join(util.GetMountDir(), strings.TrimRight(strings.Replace(u.Host, ".", "_", -1), ":"), u.Path)
+}
+
+// releaseFunc unmounts the NFS share of the backup target and removes its mount point, without mounting the share
+// first like the driver.
+func releaseFunc(destURL string) error {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return err
+	}
+	mountDir := getMountDir(u)
+	readOnly, err := backupstore.IsReadOnlyTargetURL(destURL)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		mountDir = util.ReadOnlyMountPoint(mountDir)
+	}
+	return fsops.ReleaseMountPoint(mountDir, func() error {
+		return util.UnmountMountPoint(mountDir, log)
+	})
+}
+
 func (b *BackupStoreDriver) mount() error {
-	mounter := mount.New("")
+	mounter := newMounter()
 
 	mounted, err := util.EnsureMountPoint(KIND, b.mountDir, mounter, log)
 	if err != nil {
 		return err
 	}
+	if b.ownership != nil && (b.ownership.UID >= 0 || b.ownership.GID >= 0) {
+		if err := util.AllowMountDirsSearch(b.mountDir); err != nil {
+			return err
+		}
+	}
 	if mounted {
 		return nil
 	}
 
 	retErr := errors.New("cannot mount using NFSv4")
 
-	// If overridden, assume minor version is specified or defaulted.
+	// If overridden, assume minor version is specified or defaulted. Otherwise the options of each attempt are
+	// picked without changing the configured ones, so the later mounts step down through the versions again.
 	if len(b.mountOptions) > 0 {
 		sensitiveMountOptions := []string{}
 
-		log.Infof("Mounting NFS share %v on mount point %v with options %+v", b.destURL, b.mountDir, b.mountOptions)
+		// The nfs4 type mounts only NFSv4, NFSv3 is mounted by the nfs type
+		fstype := "nfs4"
+		if getNFSVersion(b.mountOptions) == NfsV3Version {
+			fstype = "nfs"
+			retErr = errors.New("cannot mount using NFSv3")
+		}
+
+		log.Infof("Mounting NFS share %v on mount point %v with options %+v", b.destURL, b.mountDir,
+			b.getMountOptions(b.mountOptions))
 
-		err := util.MountWithTimeout(mounter, b.serverPath, b.mountDir, "nfs4", b.mountOptions, sensitiveMountOptions,
-			defaultMountInterval, defaultMountTimeout)
+		err := b.mountWithRetry(mounter, fstype, b.mountOptions, sensitiveMountOptions)
 		if err == nil {
 			return nil
 		}
@@ -125,30 +235,129 @@ func (b *BackupStoreDriver) mount() error {
 		for _, version := range MinorVersions {
 			log.Infof("Attempting mount for nfs path %v with nfsvers %v", b.serverPath, version)
 
-			b.mountOptions = []string{
+			options := b.withSecurityOption([]string{
 				fmt.Sprintf("nfsvers=%v", version),
 				"actimeo=1",
 				"soft",
 				"timeo=300",
 				"retry=2",
-			}
+			})
 			sensitiveMountOptions := []string{}
 
-			log.Infof("Mounting NFS share %v on mount point %v with options %+v", b.destURL, b.mountDir, b.mountOptions)
+			log.Infof("Mounting NFS share %v on mount point %v with options %+v", b.destURL, b.mountDir,
+				b.getMountOptions(options))
 
-			err := util.MountWithTimeout(mounter, b.serverPath, b.mountDir, "nfs4", b.mountOptions, sensitiveMountOptions,
-				defaultMountInterval, defaultMountTimeout)
+			err := b.mountWithRetry(mounter, "nfs4", options, sensitiveMountOptions)
 			if err == nil {
 				return nil
 			}
 
 			retErr = errors.Wrapf(retErr, "vers=%s: %v", version, err.Error())
 		}
+
+		if b.v3Fallback {
+			log.Warnf("Falling back to NFSv3 for nfs path %v", b.serverPath)
+
+			// The backups are protected by the lock files in the backupstore rather than the NLM locks, and
+			// rpc.statd required by the NLM locks isn't running in most containers
+			options := b.withSecurityOption([]string{
+				fmt.Sprintf("nfsvers=%v", NfsV3Version),
+				"nolock",
+				"actimeo=1",
+				"soft",
+				"timeo=300",
+				"retry=2",
+			})
+			sensitiveMountOptions := []string{}
+
+			log.Infof("Mounting NFS share %v on mount point %v with options %+v", b.destURL, b.mountDir,
+				b.getMountOptions(options))
+
+			err := b.mountWithRetry(mounter, "nfs", options, sensitiveMountOptions)
+			if err == nil {
+				return nil
+			}
+
+			retErr = errors.Wrapf(retErr, "vers=%s: %v", NfsV3Version, err.Error())
+		}
 	}
 
 	return retErr
 }
 
+// getNFSVersion returns the NFS version in the mount options, or an empty string if it isn't specified.
+func getNFSVersion(options []string) string {
+	version := ""
+	for _, option := range options {
+		key, value, _ := strings.Cut(option, "=")
+		if key == "nfsvers" || key == "vers" {
+			version = value
+		}
+	}
+	return version
+}
+
+// mountWithRetry mounts the NFS share with the given mount options, and retries the failed mount after the
+// mount interval up to the retry count. The security flavor of the mounted share is validated.
+func (b *BackupStoreDriver) mountWithRetry(mounter mount.Interface, fstype string,
+	options, sensitiveMountOptions []string) error {
+	var err error
+	for attempt := 0; attempt <= b.mountRetryCount; attempt++ {
+		if attempt > 0 {
+			log.WithError(err).Warnf("Retrying mounting NFS share %v after %v, attempt %v of %v", b.destURL,
+				b.mountInterval, attempt, b.mountRetryCount)
+			time.Sleep(b.mountInterval)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), b.mountTimeout)
+		err = util.MountWithEnv(ctx, mounter, b.serverPath, b.mountDir, fstype, b.getMountOptions(options), sensitiveMountOptions,
+			b.getMountEnv())
+		cancel()
+		if err == nil {
+			return b.validateSecurityFlavor(mounter)
+		}
+	}
+	return err
+}
+
+// ShouldRemount returns true if the file handles of the share are stale or the share is disconnected, e.g.
+// after the NFS server reboots.
+func (b *BackupStoreDriver) ShouldRemount(err error) bool {
+	return fsops.IsErrno(err, syscall.ESTALE, syscall.ENOTCONN)
+}
+
+// Remount lazily unmounts the broken share and mounts it again. The lazy unmount doesn't wait for the
+// unreachable server, and the operations still using the stale mount are left to fail.
+func (b *BackupStoreDriver) Remount() error {
+	log.Warnf("Remounting NFS share %v on mount point %v", b.destURL, b.mountDir)
+	if err := util.DetachMountPoint(b.mountDir, log); err != nil {
+		return err
+	}
+	return b.mount()
+}
+
+// getMountOptions returns the mount options of the attempt, the read-only share is mounted read-only.
+func (b *BackupStoreDriver) getMountOptions(options []string) []string {
+	if b.readOnly {
+		return util.WithReadOnlyOption(options)
+	}
+	return options
+}
+
+// MountPoint returns the mount point of the NFS share.
+func (b *BackupStoreDriver) MountPoint() string {
+	return b.mountDir
+}
+
+// Mount mounts the NFS share again after it's unmounted for being idle.
+func (b *BackupStoreDriver) Mount() error {
+	return b.mount()
+}
+
+// Unmount unmounts the idle NFS share.
+func (b *BackupStoreDriver) Unmount() error {
+	return util.UnmountMountPoint(b.mountDir, log)
+}
+
 func (b *BackupStoreDriver) Kind() string {
 	return KIND
 }
@@ -160,3 +369,61 @@ func (b *BackupStoreDriver) GetURL() string {
 func (b *BackupStoreDriver) LocalPath(path string) string {
 	return filepath.Join(b.mountDir, path)
 }
+
+func (b *BackupStoreDriver) parseMountAttempts(values url.Values) error {
+	for option, duration := range map[string]*time.Duration{MountTimeoutOption: &b.mountTimeout, MountIntervalOption: &b.mountInterval} {
+		value := values.Get(option)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return fmt.Errorf("invalid %v %v in NFS URL", option, value)
+		}
+		*duration = parsed
+	}
+	if value := values.Get(RetryCountOption); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 31)
+		if err != nil {
+			return fmt.Errorf("invalid %v %v in NFS URL", RetryCountOption, value)
+		}
+		b.mountRetryCount = int(parsed)
+	}
+	if b.mountTimeout != defaultMountTimeout || b.mountInterval != defaultMountInterval || b.mountRetryCount > 0 {
+		log.Infof("Mounting NFS path %v with timeout %v, interval %v and %v retries", b.serverPath, b.mountTimeout,
+			b.mountInterval, b.mountRetryCount)
+	}
+	return nil
+}
+
+func parseFileOwnership(values url.Values) (*fsops.FileOwnership, error) {
+	if values.Get(NfsUIDOption) == "" && values.Get(NfsGIDOption) == "" &&
+		values.Get(NfsDirModeOption) == "" && values.Get(NfsFileModeOption) == "" {
+		return nil, nil
+	}
+
+	ownership := &fsops.FileOwnership{UID: -1, GID: -1}
+	for option, id := range map[string]*int{NfsUIDOption: &ownership.UID, NfsGIDOption: &ownership.GID} {
+		value := values.Get(option)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseUint(value, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v %v in NFS URL", option, value)
+		}
+		*id = int(parsed)
+	}
+	for option, mode := range map[string]*os.FileMode{NfsDirModeOption: &ownership.DirMode, NfsFileModeOption: &ownership.FileMode} {
+		value := values.Get(option)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || parsed > 0777 {
+			return nil, fmt.Errorf("invalid %v %v in NFS URL", option, value)
+		}
+		*mode = os.FileMode(parsed)
+	}
+	return ownership, nil
+}
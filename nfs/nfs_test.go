@@ -0,0 +1,255 @@
+package nfs
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	mount "k8s.io/mount-utils"
+
+	"github.com/longhorn/backupstore/fsops"
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestParseFileOwnership(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		expected *fsops.FileOwnership
+		errMsg   string
+	}{
+		{"not set", "", nil, ""},
+		{"all set", "nfsUID=1000&nfsGID=2000&nfsDirMode=0770&nfsFileMode=0660",
+			&fsops.FileOwnership{UID: 1000, GID: 2000, DirMode: 0770, FileMode: 0660}, ""},
+		{"group only", "nfsGID=2000", &fsops.FileOwnership{UID: -1, GID: 2000}, ""},
+		{"mode without leading zero", "nfsDirMode=750", &fsops.FileOwnership{UID: -1, GID: -1, DirMode: 0750}, ""},
+		{"negative UID", "nfsUID=-1", nil, "invalid nfsUID -1"},
+		{"invalid GID", "nfsGID=users", nil, "invalid nfsGID users"},
+		{"mode not octal", "nfsFileMode=0888", nil, "invalid nfsFileMode 0888"},
+		{"mode with special bits", "nfsDirMode=1777", nil, "invalid nfsDirMode 1777"},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err, tc.name)
+		ownership, err := parseFileOwnership(values)
+		if tc.errMsg != "" {
+			assert.ErrorContains(err, tc.errMsg, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, ownership, tc.name)
+	}
+}
+
+// failingMounter fails the given number of the mounts before mounting the share, and all the mounts of the
+// unsupported file system types.
+type failingMounter struct {
+	*mount.FakeMounter
+	failures    int
+	unsupported map[string]bool
+	mounts      int
+}
+
+func (m *failingMounter) MountSensitiveWithoutSystemd(source, target, fstype string, options, sensitiveOptions []string) error {
+	m.mounts++
+	if m.mounts <= m.failures {
+		return errors.New("mount.nfs: Connection timed out")
+	}
+	if m.unsupported[fstype] {
+		return errors.New("mount.nfs4: " + UnsupportedProtocolError)
+	}
+	return m.FakeMounter.MountSensitiveWithoutSystemd(source, target, fstype, options, sensitiveOptions)
+}
+
+func TestParseMountAttempts(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		name       string
+		query      string
+		timeout    time.Duration
+		interval   time.Duration
+		retryCount int
+		errMsg     string
+	}{
+		{"default", "", defaultMountTimeout, defaultMountInterval, 0, ""},
+		{"all set", "mountTimeout=30s&mountInterval=2s&retryCount=5", 30 * time.Second, 2 * time.Second, 5, ""},
+		{"timeout only", "mountTimeout=1m", time.Minute, defaultMountInterval, 0, ""},
+		{"invalid timeout", "mountTimeout=30", 0, 0, 0, "invalid mountTimeout 30"},
+		{"zero interval", "mountInterval=0s", 0, 0, 0, "invalid mountInterval 0s"},
+		{"negative timeout", "mountTimeout=-1s", 0, 0, 0, "invalid mountTimeout -1s"},
+		{"negative retry count", "retryCount=-1", 0, 0, 0, "invalid retryCount -1"},
+		{"invalid retry count", "retryCount=many", 0, 0, 0, "invalid retryCount many"},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err, tc.name)
+		b := &BackupStoreDriver{mountTimeout: defaultMountTimeout, mountInterval: defaultMountInterval}
+		err = b.parseMountAttempts(values)
+		if tc.errMsg != "" {
+			assert.ErrorContains(err, tc.errMsg, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.timeout, b.mountTimeout, tc.name)
+		assert.Equal(tc.interval, b.mountInterval, tc.name)
+		assert.Equal(tc.retryCount, b.mountRetryCount, tc.name)
+	}
+}
+
+func TestMountWithRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	newDriver := func(retryCount int) *BackupStoreDriver {
+		return &BackupStoreDriver{
+			serverPath:      "server:/export",
+			mountDir:        t.TempDir(),
+			mountOptions:    []string{"nfsvers=4.2"},
+			mountInterval:   time.Millisecond,
+			mountTimeout:    time.Second,
+			mountRetryCount: retryCount,
+		}
+	}
+
+	// The failed mounts are retried up to the retry count
+	mounter := &failingMounter{FakeMounter: mount.NewFakeMounter(nil), failures: 2}
+	b := newDriver(2)
+	assert.NoError(b.mountWithRetry(mounter, "nfs4", b.mountOptions, nil))
+	assert.Equal(3, mounter.mounts)
+	mountPoints, err := mounter.List()
+	assert.NoError(err)
+	assert.Len(mountPoints, 1)
+	assert.Equal("server:/export", mountPoints[0].Device)
+	assert.Equal("nfs4", mountPoints[0].Type)
+
+	mounter = &failingMounter{FakeMounter: mount.NewFakeMounter(nil), failures: 2}
+	b = newDriver(1)
+	assert.ErrorContains(b.mountWithRetry(mounter, "nfs4", b.mountOptions, nil), "Connection timed out")
+	assert.Equal(2, mounter.mounts)
+
+	// The read-only share is mounted read-only
+	mounter = &failingMounter{FakeMounter: mount.NewFakeMounter(nil)}
+	b = newDriver(0)
+	b.readOnly = true
+	assert.NoError(b.mountWithRetry(mounter, "nfs4", b.mountOptions, nil))
+	mountPoints, err = mounter.List()
+	assert.NoError(err)
+	assert.Contains(mountPoints[0].Opts, "ro")
+}
+
+func TestGetNFSVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		options []string
+		version string
+	}{
+		{nil, ""},
+		{[]string{"soft", "timeo=300"}, ""},
+		{[]string{"nfsvers=3", "nolock"}, NfsV3Version},
+		{[]string{"vers=4.1"}, "4.1"},
+		{[]string{"vers=4.1", "nfsvers=3"}, NfsV3Version},
+	} {
+		assert.Equal(tc.version, getNFSVersion(tc.options), "options %v", tc.options)
+	}
+}
+
+func TestMountNFSv3(t *testing.T) {
+	assert := assert.New(t)
+
+	mounter := &failingMounter{}
+	defer func(f func() mount.Interface) { newMounter = f }(newMounter)
+	newMounter = func() mount.Interface { return mounter }
+
+	newDriver := func(mountOptions []string, v3Fallback bool) *BackupStoreDriver {
+		mounter.FakeMounter = mount.NewFakeMounter(nil)
+		mounter.mounts = 0
+		return &BackupStoreDriver{
+			destURL:       "nfs://server:/export",
+			serverPath:    "server:/export",
+			mountDir:      t.TempDir(),
+			mountOptions:  mountOptions,
+			mountInterval: time.Millisecond,
+			mountTimeout:  time.Second,
+			v3Fallback:    v3Fallback,
+		}
+	}
+	getMount := func() mount.MountPoint {
+		mountPoints, err := mounter.List()
+		assert.NoError(err)
+		if assert.Len(mountPoints, 1) {
+			return mountPoints[0]
+		}
+		return mount.MountPoint{}
+	}
+
+	// NFSv3 selected by nfsOptions is mounted by the nfs type
+	b := newDriver([]string{"nfsvers=3", "nolock"}, false)
+	assert.NoError(b.mount())
+	assert.Equal("nfs", getMount().Type)
+	assert.Equal([]string{"nfsvers=3", "nolock"}, getMount().Opts)
+
+	// The NFSv4 minor versions are tried before falling back to NFSv3
+	mounter.unsupported = map[string]bool{"nfs4": true}
+	b = newDriver(nil, true)
+	assert.NoError(b.mount())
+	assert.Equal(len(MinorVersions)+1, mounter.mounts)
+	assert.Equal("nfs", getMount().Type)
+	assert.Contains(getMount().Opts, "nfsvers=3")
+	assert.Contains(getMount().Opts, "nolock")
+	// The negotiated options are kept out of the configured ones, so the next mount steps down through the versions
+	assert.Nil(b.mountOptions)
+	assert.NoError(mounter.Unmount(b.mountDir))
+	mounter.unsupported = nil
+	mounter.mounts = 0
+	assert.NoError(b.mount())
+	assert.Equal(1, mounter.mounts)
+	assert.Equal("nfs4", getMount().Type)
+	assert.NotContains(getMount().Opts, "nolock")
+
+	// NFSv3 isn't tried without the fallback
+	mounter.unsupported = map[string]bool{"nfs4": true}
+	b = newDriver(nil, false)
+	err := b.mount()
+	assert.ErrorContains(err, "cannot mount using NFSv4")
+	assert.ErrorContains(err, "vers=4.0")
+	assert.NotContains(err.Error(), "vers=3")
+	assert.Equal(len(MinorVersions), mounter.mounts)
+
+	// The NFSv4 share is mounted by the nfs4 type
+	mounter.unsupported = nil
+	b = newDriver(nil, true)
+	assert.NoError(b.mount())
+	assert.Equal("nfs4", getMount().Type)
+	assert.Contains(getMount().Opts, "nfsvers=4.2")
+}
+
+func TestReleaseFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	defer util.SetMountDir(util.GetMountDir()) // nolint:errcheck
+	assert.NoError(util.SetMountDir(t.TempDir()))
+
+	// The mount point is removed without mounting the share of the unreachable server
+	for _, tc := range []struct {
+		destURL  string
+		expected string
+	}{
+		{"nfs://server.example.com:/export/backups/", filepath.Join(util.GetMountDir(), "server_example_com", "export", "backups")},
+		{"nfs://server.example.com:/export/backups/?readOnly=true", filepath.Join(util.GetMountDir(), "server_example_com", "export", "backups-ro")},
+	} {
+		assert.NoError(os.MkdirAll(tc.expected, 0700), tc.destURL)
+		assert.NoError(releaseFunc(tc.destURL), tc.destURL)
+		_, err := os.Stat(tc.expected)
+		assert.True(os.IsNotExist(err), tc.destURL)
+	}
+	_, err := os.Stat(filepath.Join(util.GetMountDir(), "server_example_com"))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(util.GetMountDir())
+	assert.NoError(err)
+}
@@ -0,0 +1,151 @@
+package backupstore
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	contentTypeOctetStream = "application/octet-stream"
+	contentTypeLZ4         = "application/x-lz4"
+	contentEncodingGzip    = "gzip"
+)
+
+// getObjectMetadata returns the metadata describing the data compressed with the given method. The gzip
+// compressed data is stored as encoded raw data, so HTTP clients are able to decode it. The lz4 has no
+// registered content encoding, the compressed data is stored as an lz4 archive instead.
+func getObjectMetadata(compressionMethod string) *ObjectMetadata {
+	switch compressionMethod {
+	case "gzip":
+		return &ObjectMetadata{ContentType: contentTypeOctetStream, ContentEncoding: contentEncodingGzip}
+	case "lz4":
+		return &ObjectMetadata{ContentType: contentTypeLZ4}
+	default:
+		return &ObjectMetadata{ContentType: contentTypeOctetStream}
+	}
+}
+
+// getCompressionMethodFromMetadata returns the compression method of the data described by the metadata,
+// or an empty string if the data is not compressed or the metadata is unknown.
+func getCompressionMethodFromMetadata(metadata *ObjectMetadata) string {
+	switch {
+	case metadata == nil:
+		return ""
+	case metadata.ContentEncoding == contentEncodingGzip:
+		return "gzip"
+	case metadata.ContentType == contentTypeLZ4:
+		return "lz4"
+	default:
+		return ""
+	}
+}
+
+// WriteCompressedObject writes the data compressed with the given method. The object storage drivers
+// store the data with the matching Content-Type and Content-Encoding metadata, so the objects can be
+// interpreted by the third-party tools reading the backup target.
+func WriteCompressedObject(driver BackupStoreDriver, dst string, rs io.ReadSeeker, compressionMethod string) error {
+	metadataDriver, ok := findBackendDriver[ObjectMetadataBackupStoreDriver](driver)
+	if !ok {
+		return driver.Write(dst, rs)
+	}
+	return metadataDriver.WriteWithMetadata(dst, rs, getObjectMetadata(compressionMethod))
+}
+
+// ReadObjectRange reads the range of the object data. The data is transparently decompressed according to
+// the object metadata, in which case the offset and the length are applied to the decompressed data.
+func ReadObjectRange(driver BackupStoreDriver, filePath string, offset, length int64) (io.ReadCloser, error) {
+	metadataDriver, ok := findBackendDriver[ObjectMetadataBackupStoreDriver](driver)
+	if !ok {
+		rc, err := driver.Read(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return limitReadCloser(rc, rc, offset, length)
+	}
+
+	metadata, err := metadataDriver.GetMetadata(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get metadata of %v", filePath)
+	}
+
+	compressionMethod := getCompressionMethodFromMetadata(metadata)
+	if compressionMethod == "" {
+		return metadataDriver.ReadRange(filePath, offset, length)
+	}
+
+	rc, err := driver.Read(filePath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := util.NewDecompressionReader(compressionMethod, rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return limitReadCloser(r, closers{r, rc}, offset, length)
+}
+
+// writeWithMetadata writes the data with the metadata by the wrapped driver, without the metadata if it doesn't
+// store the metadata.
+func writeWithMetadata(driver BackupStoreDriver, dst string, rs io.ReadSeeker, metadata *ObjectMetadata) error {
+	if metadataDriver, ok := findDriver[ObjectMetadataBackupStoreDriver](driver); ok {
+		return metadataDriver.WriteWithMetadata(dst, rs, metadata)
+	}
+	return driver.Write(dst, rs)
+}
+
+// getMetadata returns the metadata of the object by the wrapped driver, or the empty metadata if it doesn't
+// store the metadata.
+func getMetadata(driver BackupStoreDriver, filePath string) (*ObjectMetadata, error) {
+	if metadataDriver, ok := findDriver[ObjectMetadataBackupStoreDriver](driver); ok {
+		return metadataDriver.GetMetadata(filePath)
+	}
+	return &ObjectMetadata{}, nil
+}
+
+// readRange reads the range of the object by the wrapped driver, skipping the data before the offset if it
+// doesn't support the ranged reads.
+func readRange(driver BackupStoreDriver, src string, offset, length int64) (io.ReadCloser, error) {
+	if metadataDriver, ok := findDriver[ObjectMetadataBackupStoreDriver](driver); ok {
+		return metadataDriver.ReadRange(src, offset, length)
+	}
+	rc, err := driver.Read(src)
+	if err != nil {
+		return nil, err
+	}
+	return limitReadCloser(rc, rc, offset, length)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// limitReadCloser skips the data before the offset and limits the reader to the length. A negative length
+// reads the data until the end.
+func limitReadCloser(r io.Reader, c io.Closer, offset, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		c.Close()
+		return nil, errors.Wrapf(err, "failed to skip to offset %v", offset)
+	}
+	if length >= 0 {
+		r = io.LimitReader(r, length)
+	}
+	return &readCloser{Reader: r, Closer: c}, nil
+}
+
+// closers closes all the closers, e.g. the decompressor and the reader it reads, and returns the first error.
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var err error
+	for _, closer := range c {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
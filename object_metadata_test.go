@@ -0,0 +1,125 @@
+package backupstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const metadataMockDriverName = "metadatamock"
+
+// metadataMockDriver keeps the object metadata in memory like an object storage.
+type metadataMockDriver struct {
+	*mockStoreDriver
+	metadata map[string]*ObjectMetadata
+}
+
+func (d *metadataMockDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *ObjectMetadata) error {
+	d.metadata[dst] = metadata
+	return d.Write(dst, rs)
+}
+
+func (d *metadataMockDriver) GetMetadata(filePath string) (*ObjectMetadata, error) {
+	if metadata, ok := d.metadata[filePath]; ok {
+		return metadata, nil
+	}
+	return &ObjectMetadata{}, nil
+}
+
+func (d *metadataMockDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := d.Read(src)
+	if err != nil {
+		return nil, err
+	}
+	return limitReadCloser(rc, rc, offset, length)
+}
+
+func TestReadObjectRange(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{delay: time.Millisecond}
+	m.Init()
+	defer m.uninstall()
+
+	driver := &metadataMockDriver{mockStoreDriver: m, metadata: map[string]*ObjectMetadata{}}
+	data := []byte("0123456789abcdef")
+
+	for _, method := range []string{"none", "gzip", "lz4"} {
+		if method != "none" {
+			assert.Equal(method, getCompressionMethodFromMetadata(getObjectMetadata(method)))
+		}
+
+		rs, err := util.CompressData(method, data)
+		assert.NoError(err)
+		path := "backupstore/" + method + ".blk"
+		assert.NoError(WriteCompressedObject(driver, path, rs, method))
+
+		rc, err := ReadObjectRange(driver, path, 4, 6)
+		assert.NoError(err)
+		result, err := io.ReadAll(rc)
+		assert.NoError(err)
+		assert.NoError(rc.Close())
+		assert.Equal(data[4:10], result, "method %v", method)
+
+		rc, err = ReadObjectRange(driver, path, 10, -1)
+		assert.NoError(err)
+		result, err = io.ReadAll(rc)
+		assert.NoError(err)
+		assert.NoError(rc.Close())
+		assert.True(bytes.Equal(data[10:], result), "method %v", method)
+	}
+}
+
+func TestObjectMetadataThroughWrappers(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	d := &metadataMockDriver{mockStoreDriver: m, metadata: map[string]*ObjectMetadata{}}
+	assert.NoError(RegisterDriver(metadataMockDriverName, func(destURL string) (BackupStoreDriver, error) { return d, nil }))
+	defer unregisterDriver(metadataMockDriverName) // nolint:errcheck
+	destURL := metadataMockDriverName + "://localhost"
+
+	data := []byte("0123456789abcdef")
+	path := getBlockFilePath("pvc-1", fmt.Sprintf("%064x", 0))
+	driver, err := GetBackupStoreDriver(destURL)
+	assert.NoError(err)
+	rs, err := util.CompressData("gzip", data)
+	assert.NoError(err)
+	assert.NoError(WriteCompressedObject(driver, path, rs, "gzip"))
+	assert.Equal(getObjectMetadata("gzip"), d.metadata[path])
+
+	// The writes are refused by the read-only and immutable modes
+	for _, option := range []string{ReadOnlyTargetOption, ImmutableTargetOption} {
+		driver, err := GetBackupStoreDriver(destURL + "?" + option + "=true")
+		assert.NoError(err)
+		rs, err := util.CompressData("gzip", data)
+		assert.NoError(err)
+		err = WriteCompressedObject(driver, path, rs, "gzip")
+		assert.True(IsReadOnlyTargetError(err) || IsImmutableTargetError(err), "unexpected error %v", err)
+
+		rc, err := ReadObjectRange(driver, path, 4, 6)
+		assert.NoError(err)
+		result, err := io.ReadAll(rc)
+		assert.NoError(err)
+		assert.NoError(rc.Close())
+		assert.Equal(data[4:10], result)
+	}
+
+	// The ranged reads are bounded by the deadlines
+	path = getBlockFilePath("pvc-1", fmt.Sprintf("%064x", 1))
+	assert.NoError(WriteCompressedObject(driver, path, bytes.NewReader(data), "none"))
+	m.delay = 200 * time.Millisecond
+	short := WithOperationDeadlines(driver, OperationDeadlines{Transfer: 50 * time.Millisecond})
+	_, err = ReadObjectRange(short, path, 4, 6)
+	assert.True(IsOperationTimeoutError(err), "unexpected error %v", err)
+	m.delay = 0
+}
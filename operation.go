@@ -0,0 +1,33 @@
+package backupstore
+
+import (
+	"github.com/longhorn/backupstore/util"
+)
+
+const OPERATION_ID_PREFIX = "op"
+
+// OperationIDReceiver is optionally implemented by the DeltaBlockBackupOperations and the DeltaRestoreOperations.
+// The ID of the operation is set before its progress is updated, so the progress events can be correlated with
+// the log lines and the locks of the operation.
+type OperationIDReceiver interface {
+	SetOperationID(operationID string)
+}
+
+// NewOperationID generates an ID identifying a backup, restore or deletion operation.
+func NewOperationID() string {
+	return util.GenerateName(OPERATION_ID_PREFIX)
+}
+
+// getOperationID returns the ID of the operation, a new one is generated if it's not specified.
+func getOperationID(operationID string) string {
+	if operationID != "" {
+		return operationID
+	}
+	return NewOperationID()
+}
+
+func setOperationID(ops interface{}, operationID string) {
+	if receiver, ok := ops.(OperationIDReceiver); ok {
+		receiver.SetOperationID(operationID)
+	}
+}
@@ -0,0 +1,46 @@
+package backupstore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type operationIDOps struct {
+	memorySnapshotOps
+	operationID string
+}
+
+func (o *operationIDOps) SetOperationID(operationID string) {
+	o.operationID = operationID
+}
+
+func TestOperationID(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	assert.Equal("op-1", getOperationID("op-1"))
+	operationID := getOperationID("")
+	assert.True(strings.HasPrefix(operationID, OPERATION_ID_PREFIX+"-"))
+	assert.NotEqual(operationID, getOperationID(""))
+
+	ops := &operationIDOps{}
+	setOperationID(ops, operationID)
+	assert.Equal(operationID, ops.operationID)
+	// The operations not receiving the ID are skipped
+	setOperationID(&memorySnapshotOps{}, operationID)
+
+	// The ID is saved in the lock metadata
+	lock, err := New(m, "pvc-1", BACKUP_LOCK)
+	assert.NoError(err)
+	lock.OperationID = operationID
+	err = saveLock(lock)
+	assert.NoError(err)
+	serverLock, err := loadLock("pvc-1", lock.Name, m)
+	assert.NoError(err)
+	assert.Equal(operationID, serverLock.OperationID)
+}
@@ -0,0 +1,256 @@
+package oss
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/backupstore"
+	bhttp "github.com/longhorn/backupstore/http"
+	"github.com/longhorn/backupstore/types"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "oss"})
+)
+
+// BackupStoreDriver stores the backups in an Alibaba Cloud OSS bucket with the native OSS API, since the
+// multipart uploads through the S3 compatible API of OSS aren't verified reliably.
+type BackupStoreDriver struct {
+	destURL string
+	path    string
+	getenv  func(string) string
+	service *service
+}
+
+const (
+	// KIND defines the kind of backupstore driver
+	KIND = "oss"
+)
+
+func init() {
+	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("wrong driver dispatching %v to %v?", u.Scheme, KIND)
+	}
+
+	b := &BackupStoreDriver{getenv: backupstore.TargetEnv(backupstore.GetTargetCredential(destURL))}
+	b.path = strings.Trim(u.Path, "/")
+	if u.Host == "" || b.path == "" {
+		return nil, fmt.Errorf("invalid URL. Must be oss://bucket/path/")
+	}
+
+	endpoint := b.getenv(types.OSSEndpoint)
+	if endpoint == "" {
+		return nil, fmt.Errorf("missing OSS endpoint %v, e.g. https://oss-cn-hangzhou.aliyuncs.com", types.OSSEndpoint)
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid OSS endpoint %v", endpoint)
+	}
+
+	var customCerts []byte
+	if certs := b.getenv(types.OSSCert); certs != "" {
+		customCerts = []byte(certs)
+	}
+	client, err := bhttp.GetClientWithCustomCerts(customCerts)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := newOSSAuth(client, b.getenv)
+	if err != nil {
+		return nil, err
+	}
+	b.service = &service{
+		Bucket:   u.Host,
+		Endpoint: endpointURL,
+		PartSize: defaultPartSize,
+		Client:   client,
+		auth:     auth,
+	}
+
+	if _, err := b.List(""); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + u.Host + "/" + b.path
+	log.Infof("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+// Kind returns the driver type
+func (s *BackupStoreDriver) Kind() string {
+	return KIND
+}
+
+// GetURL returns URL of the backup target
+func (s *BackupStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *BackupStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+// List return items that on the backup target including prefixes
+func (s *BackupStoreDriver) List(listPath string) ([]string, error) {
+	path := s.updatePath(listPath) + "/"
+	keys, prefixes, err := s.service.listObjects(path, "/")
+	if err != nil {
+		log.WithError(err).Error("Failed to list oss")
+		return nil, err
+	}
+
+	result := []string{}
+	for _, name := range append(keys, prefixes...) {
+		if r := strings.TrimSuffix(strings.TrimPrefix(name, path), "/"); r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// ListPrefix returns the paths of all the objects under the prefix
+func (s *BackupStoreDriver) ListPrefix(prefix string) ([]string, error) {
+	path := s.updatePath(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		path += "/"
+	}
+	keys, _, err := s.service.listObjects(path, "")
+	if err != nil {
+		log.WithError(err).Error("Failed to list oss")
+		return nil, err
+	}
+
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if r := strings.TrimPrefix(strings.TrimPrefix(key, s.path), "/"); r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// FileExists checks if file exists on the backup target
+func (s *BackupStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+// FileSize return content length of the filePath on the backup target
+func (s *BackupStoreDriver) FileSize(filePath string) int64 {
+	info, err := s.service.headObject(s.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return info.size
+}
+
+// FileTime returns file last modified time on the backup target
+func (s *BackupStoreDriver) FileTime(filePath string) time.Time {
+	info, err := s.service.headObject(s.updatePath(filePath))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.modified.UTC()
+}
+
+// Remove deletes files on the backup target
+func (s *BackupStoreDriver) Remove(path string) error {
+	name := s.updatePath(path)
+	keys, _, err := s.service.listObjects(name+"/", "")
+	if err != nil {
+		return err
+	}
+	return s.service.deleteObjects(append(keys, name))
+}
+
+func (s *BackupStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return s.service.getObjectRange(s.updatePath(src), 0, -1)
+}
+
+// Write creates a item on the backup target from io stream
+func (s *BackupStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return s.service.putObject(s.updatePath(dst), rs, "", "")
+}
+
+// WriteWithMetadata creates a item with the HTTP metadata on the backup target from io stream
+func (s *BackupStoreDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *backupstore.ObjectMetadata) error {
+	return s.service.putObject(s.updatePath(dst), rs, metadata.ContentType, metadata.ContentEncoding)
+}
+
+// GetMetadata returns the HTTP metadata of the item on the backup target
+func (s *BackupStoreDriver) GetMetadata(filePath string) (*backupstore.ObjectMetadata, error) {
+	info, err := s.service.headObject(s.updatePath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return &backupstore.ObjectMetadata{
+		ContentType:     info.contentType,
+		ContentEncoding: info.contentEncoding,
+	}, nil
+}
+
+// ReadRange reads the data range of the item on the backup target, a negative length reads the data until the end
+func (s *BackupStoreDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	return s.service.getObjectRange(s.updatePath(src), offset, length)
+}
+
+// Copy copies the item inside the bucket without transferring the data through the client
+func (s *BackupStoreDriver) Copy(src, dst string) error {
+	return s.service.copyObject(s.updatePath(src), s.updatePath(dst))
+}
+
+// Upload creates a item on the backup target by opening source file
+func (s *BackupStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.service.putObject(s.updatePath(dst), file, "", "")
+}
+
+// Download gets a item data from the backup target
+func (s *BackupStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0700); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rc, err := s.service.getObjectRange(s.updatePath(src), 0, -1)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
@@ -0,0 +1,181 @@
+package oss
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+const (
+	// credentialRefreshMargin refreshes the temporary credentials of the RAM role before they expire, so the
+	// requests in flight don't carry the expired credentials
+	credentialRefreshMargin = 5 * time.Minute
+)
+
+var (
+	// ramRoleCredentialURL is the ECS metadata service path returning the temporary credentials of the RAM
+	// role attached to the instance
+	ramRoleCredentialURL = "http://100.100.100.200/latest/meta-data/ram/security-credentials/"
+
+	// signedSubresources are the query parameters included in the canonicalized resource of the signature
+	signedSubresources = map[string]bool{
+		"acl": true, "delete": true, "partNumber": true, "uploadId": true, "uploads": true,
+		"response-content-type": true, "response-content-encoding": true,
+	}
+)
+
+type credential struct {
+	accessKeyID     string
+	accessKeySecret string
+	securityToken   string
+}
+
+// ossAuth signs the requests with the static AccessKey pair and the optional STS token, or the temporary
+// credentials of the RAM role of the ECS instance.
+type ossAuth struct {
+	client *http.Client
+	getenv func(string) string
+
+	lock       sync.Mutex
+	credential *credential
+	expiry     time.Time
+}
+
+type ramRoleCredential struct {
+	Code            string    `json:"Code"`
+	AccessKeyID     string    `json:"AccessKeyId"`
+	AccessKeySecret string    `json:"AccessKeySecret"`
+	SecurityToken   string    `json:"SecurityToken"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func newOSSAuth(client *http.Client, getenv func(string) string) (*ossAuth, error) {
+	if getenv(types.OSSRAMRoleName) == "" &&
+		(getenv(types.OSSAccessKeyID) == "" || getenv(types.OSSAccessKeySecret) == "") {
+		return nil, fmt.Errorf("missing OSS credential, either %v and %v or %v must be set",
+			types.OSSAccessKeyID, types.OSSAccessKeySecret, types.OSSRAMRoleName)
+	}
+	return &ossAuth{client: client, getenv: getenv}, nil
+}
+
+// getCredential returns the static credential if it's set, otherwise the cached credential of the RAM role
+// until it's about to expire.
+func (a *ossAuth) getCredential() (*credential, error) {
+	if id := a.getenv(types.OSSAccessKeyID); id != "" {
+		return &credential{
+			accessKeyID:     id,
+			accessKeySecret: a.getenv(types.OSSAccessKeySecret),
+			securityToken:   a.getenv(types.OSSSessionToken),
+		}, nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.credential != nil && time.Now().Add(credentialRefreshMargin).Before(a.expiry) {
+		return a.credential, nil
+	}
+
+	role := a.getenv(types.OSSRAMRoleName)
+	resp, err := a.client.Get(ramRoleCredentialURL + url.PathEscape(role))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the credential of RAM role %v", role)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get the credential of RAM role %v: %v", role, resp.Status)
+	}
+	result := &ramRoleCredential{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode the credential of RAM role %v", role)
+	}
+	if result.Code != "Success" || result.AccessKeyID == "" {
+		return nil, fmt.Errorf("failed to get the credential of RAM role %v: %v", role, result.Code)
+	}
+
+	a.credential = &credential{
+		accessKeyID:     result.AccessKeyID,
+		accessKeySecret: result.AccessKeySecret,
+		securityToken:   result.SecurityToken,
+	}
+	a.expiry = result.Expiration
+	return a.credential, nil
+}
+
+// invalidate forces the next request to get the credential of the RAM role again, e.g. it's revoked.
+func (a *ossAuth) invalidate() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.credential = nil
+}
+
+// sign sets the Date, the STS token and the Authorization headers of the request to the resource of the bucket,
+// following the OSS header signature version 1.
+func (a *ossAuth) sign(req *http.Request, bucket, key string) error {
+	cred, err := a.getCredential()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if cred.securityToken != "" {
+		req.Header.Set("X-Oss-Security-Token", cred.securityToken)
+	}
+	req.Header.Set("Authorization", "OSS "+cred.accessKeyID+":"+signature(cred.accessKeySecret, req, bucket, key))
+	return nil
+}
+
+func signature(secret string, req *http.Request, bucket, key string) string {
+	var ossHeaders []string
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-oss-") {
+			ossHeaders = append(ossHeaders, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(ossHeaders)
+
+	resource := "/"
+	if bucket != "" {
+		resource += bucket + "/" + key
+	}
+	var subresources []string
+	for name, values := range req.URL.Query() {
+		if !signedSubresources[name] {
+			continue
+		}
+		if len(values) == 0 || values[0] == "" {
+			subresources = append(subresources, name)
+		} else {
+			subresources = append(subresources, name+"="+values[0])
+		}
+	}
+	sort.Strings(subresources)
+	if len(subresources) > 0 {
+		resource += "?" + strings.Join(subresources, "&")
+	}
+
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.Header.Get("Content-MD5") + "\n")
+	b.WriteString(req.Header.Get("Content-Type") + "\n")
+	b.WriteString(req.Header.Get("Date") + "\n")
+	for _, header := range ossHeaders {
+		b.WriteString(header + "\n")
+	}
+	b.WriteString(resource)
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,494 @@
+package oss
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultPartSize is the size above which the objects are uploaded in parts
+	defaultPartSize = 64 * 1024 * 1024
+
+	listLimit   = 1000
+	deleteLimit = 1000
+)
+
+var (
+	errNotFound = fmt.Errorf("object not found")
+
+	crcTable = crc64.MakeTable(crc64.ECMA)
+)
+
+type service struct {
+	Bucket   string
+	Endpoint *url.URL
+	PartSize int64
+	Client   *http.Client
+
+	auth *ossAuth
+}
+
+type listBucketResult struct {
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+	Contents    []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+type ossError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []completePart `xml:"Part"`
+}
+
+type completePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type deleteRequest struct {
+	XMLName xml.Name       `xml:"Delete"`
+	Quiet   bool           `xml:"Quiet"`
+	Objects []deleteObject `xml:"Object"`
+}
+
+type deleteObject struct {
+	Key string `xml:"Key"`
+}
+
+type objectInfo struct {
+	size            int64
+	modified        time.Time
+	contentType     string
+	contentEncoding string
+}
+
+func escapePath(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// objectURL returns the URL of the object, or the bucket if the key is empty. The bucket is addressed by the
+// virtual hosted style, except the endpoints addressed by the IP addresses which don't resolve the buckets.
+func (s *service) objectURL(key string, query url.Values) string {
+	u := *s.Endpoint
+	host := u.Hostname()
+	if net.ParseIP(host) != nil || host == "localhost" {
+		u.Path, u.RawPath = "/"+s.Bucket+"/"+key, "/"+s.Bucket+"/"+escapePath(key)
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path, u.RawPath = "/"+key, "/"+escapePath(key)
+	}
+	u.RawQuery = encodeQuery(query)
+	return u.String()
+}
+
+// encodeQuery encodes the query parameters sorted by name, the subresources without values like ?uploads are
+// encoded without the equal sign.
+func encodeQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			if value == "" && signedSubresources[name] {
+				params = append(params, url.QueryEscape(name))
+				continue
+			}
+			params = append(params, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// do sends the signed request for the object, or the bucket if the key is empty. The response body is closed
+// and an error is returned unless the status is one of the expected ones. The request is sent again with the
+// new credential of the RAM role if the credential is rejected.
+func (s *service) do(method, key string, query url.Values, body io.ReadSeeker, header http.Header, expected ...int) (*http.Response, error) {
+	var offset, size int64
+	if body != nil {
+		var err error
+		if offset, err = body.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		end, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		size = end - offset
+	}
+
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if body != nil {
+			if _, err := body.Seek(offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			reqBody = io.NopCloser(body)
+		}
+		req, err := http.NewRequest(method, s.objectURL(key, query), reqBody)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.ContentLength = size
+			if size == 0 {
+				req.Body = http.NoBody
+			}
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if err := s.auth.sign(req, s.Bucket, key); err != nil {
+			return nil, err
+		}
+
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range expected {
+			if resp.StatusCode == status {
+				return resp, nil
+			}
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, errNotFound
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		result := &ossError{}
+		if xml.Unmarshal(respBody, result) == nil && result.Code != "" {
+			if resp.StatusCode == http.StatusForbidden && attempt == 0 &&
+				(result.Code == "InvalidAccessKeyId" || result.Code == "SecurityTokenExpired") {
+				s.auth.invalidate()
+				continue
+			}
+			return nil, fmt.Errorf("oss error: %v %v %v %v: %v", method, key, resp.Status, result.Code, result.Message)
+		}
+		return nil, fmt.Errorf("oss error: %v %v %v %v", method, key, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+}
+
+func discard(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func decode(resp *http.Response, err error, v interface{}) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// listObjects lists the objects with the prefix, the objects under the delimiter after the prefix are listed
+// as the common prefixes if the delimiter is set.
+func (s *service) listObjects(prefix, delimiter string) ([]string, []string, error) {
+	var keys, prefixes []string
+	query := url.Values{
+		"prefix":   {prefix},
+		"max-keys": {strconv.Itoa(listLimit)},
+	}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	for {
+		result := &listBucketResult{}
+		resp, err := s.do(http.MethodGet, "", query, nil, nil, http.StatusOK)
+		if err := decode(resp, err, result); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to list objects with prefix %v", prefix)
+		}
+		for _, obj := range result.Contents {
+			keys = append(keys, obj.Key)
+		}
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !result.IsTruncated || result.NextMarker == "" {
+			return keys, prefixes, nil
+		}
+		query.Set("marker", result.NextMarker)
+	}
+}
+
+func (s *service) headObject(key string) (*objectInfo, error) {
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	info := &objectInfo{
+		size:            resp.ContentLength,
+		contentType:     resp.Header.Get("Content-Type"),
+		contentEncoding: resp.Header.Get("Content-Encoding"),
+	}
+	if value := resp.Header.Get("Content-Length"); value != "" {
+		if info.size, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "invalid content length of object %v", key)
+		}
+	}
+	if value := resp.Header.Get("Last-Modified"); value != "" {
+		if info.modified, err = http.ParseTime(value); err != nil {
+			return nil, errors.Wrapf(err, "invalid last modified time of object %v", key)
+		}
+	}
+	return info, nil
+}
+
+// getObjectRange gets the data range of the object, a negative length reads the data until the end.
+func (s *service) getObjectRange(key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	// The objects are read as stored, otherwise the HTTP client transparently decompresses the objects stored
+	// with the gzip Content-Encoding
+	header := http.Header{"Accept-Encoding": {"gzip"}}
+	if offset > 0 || length > 0 {
+		byteRange := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			byteRange += strconv.FormatInt(offset+length-1, 10)
+		}
+		header.Set("Range", byteRange)
+	}
+	resp, err := s.do(http.MethodGet, key, nil, nil, header, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get object %v", key)
+	}
+	return resp.Body, nil
+}
+
+// checksums returns the base64 MD5 and the CRC-64/ECMA-182 checksums of the data, and rewinds it.
+func checksums(data io.ReadSeeker) (string, uint64, error) {
+	hash := md5.New()
+	crc := crc64.New(crcTable)
+	if _, err := io.Copy(io.MultiWriter(hash, crc), data); err != nil {
+		return "", 0, err
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), crc.Sum64(), nil
+}
+
+// verifyCRC compares the CRC-64 checksum computed by OSS with the one of the data sent, OSS verifies the
+// Content-MD5 of each request but the object assembled from the parts is only verified by its CRC-64.
+func verifyCRC(resp *http.Response, key string, expected uint64) error {
+	value := resp.Header.Get("X-Oss-Hash-Crc64ecma")
+	if value == "" {
+		return nil
+	}
+	actual, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid CRC-64 checksum %v of object %v", value, key)
+	}
+	if actual != expected {
+		return fmt.Errorf("CRC-64 checksum %v of object %v mismatched, %v is expected", actual, key, expected)
+	}
+	return nil
+}
+
+// putObject uploads the object with the given Content-Type and Content-Encoding, the empty values are omitted.
+// The objects larger than the part size are uploaded in parts.
+func (s *service) putObject(key string, reader io.ReadSeeker, contentType, contentEncoding string) error {
+	offset, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if contentEncoding != "" {
+		header.Set("Content-Encoding", contentEncoding)
+	}
+
+	if end-offset > s.PartSize {
+		err = s.putMultipartObject(key, reader, offset, end-offset, header)
+	} else {
+		data := io.NewSectionReader(readerAt{reader}, offset, end-offset)
+		var resp *http.Response
+		var crc uint64
+		if resp, crc, err = s.putData(key, nil, data, header); err == nil {
+			err = s.verifyObjectCRC(resp, key, crc)
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to put object %v", key)
+	}
+	return nil
+}
+
+// putData uploads the object or the part with its MD5 checksum, so OSS rejects the corrupted uploads. The
+// CRC-64 checksum of the data is returned to be verified.
+func (s *service) putData(key string, query url.Values, data io.ReadSeeker, header http.Header) (*http.Response, uint64, error) {
+	md5sum, crc, err := checksums(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	dataHeader := header.Clone()
+	dataHeader.Set("Content-MD5", md5sum)
+	resp, err := s.do(http.MethodPut, key, query, data, dataHeader, http.StatusOK)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, crc, nil
+}
+
+// putMultipartObject uploads the parts of the object, then completes the upload joining them. The upload is
+// aborted on failures, so the parts uploaded don't occupy the bucket.
+func (s *service) putMultipartObject(key string, reader io.ReadSeeker, offset, size int64, header http.Header) (err error) {
+	initiated := &initiateMultipartUploadResult{}
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, bytes.NewReader(nil), header, http.StatusOK)
+	if err := decode(resp, err, initiated); err != nil {
+		return errors.Wrap(err, "failed to initiate multipart upload")
+	}
+	defer func() {
+		if err != nil {
+			if abortErr := discard(s.do(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil,
+				http.StatusNoContent)); abortErr != nil {
+				log.WithError(abortErr).Warnf("Failed to abort multipart upload %v of object %v", initiated.UploadID, key)
+			}
+		}
+	}()
+
+	// The CRC-64 checksum of the object assembled is the one of the whole data
+	crc := crc64.New(crcTable)
+	if _, err := io.Copy(crc, io.NewSectionReader(readerAt{reader}, offset, size)); err != nil {
+		return err
+	}
+
+	complete := &completeMultipartUpload{}
+	for number, base := 1, int64(0); base < size; number, base = number+1, base+s.PartSize {
+		length := min(s.PartSize, size-base)
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {initiated.UploadID}}
+		resp, partCRC, err := s.putData(key, query, io.NewSectionReader(readerAt{reader}, offset+base, length), http.Header{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to upload part %v", number)
+		}
+		if err := verifyCRC(resp, key, partCRC); err != nil {
+			return errors.Wrapf(err, "failed to upload part %v", number)
+		}
+		complete.Parts = append(complete.Parts, completePart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err = s.do(http.MethodPost, key, url.Values{"uploadId": {initiated.UploadID}}, bytes.NewReader(body), nil,
+		http.StatusOK)
+	if err := discard(resp, err); err != nil {
+		return errors.Wrap(err, "failed to complete multipart upload")
+	}
+	return s.verifyObjectCRC(resp, key, crc.Sum64())
+}
+
+// verifyObjectCRC verifies the CRC-64 checksum of the object stored, the corrupted object is removed so it's not
+// taken as a valid block.
+func (s *service) verifyObjectCRC(resp *http.Response, key string, expected uint64) error {
+	err := verifyCRC(resp, key, expected)
+	if err != nil {
+		if deleteErr := s.deleteObjects([]string{key}); deleteErr != nil {
+			log.WithError(deleteErr).Warnf("Failed to delete corrupted object %v", key)
+		}
+	}
+	return err
+}
+
+// deleteObjects deletes the objects in batches, the missing objects are ignored.
+func (s *service) deleteObjects(keys []string) error {
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), deleteLimit)]
+		keys = keys[len(batch):]
+
+		request := &deleteRequest{Quiet: true}
+		for _, key := range batch {
+			request.Objects = append(request.Objects, deleteObject{Key: key})
+		}
+		body, err := xml.Marshal(request)
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(body)
+		header := http.Header{
+			"Content-Md5":  {base64.StdEncoding.EncodeToString(sum[:])},
+			"Content-Type": {"application/xml"},
+		}
+		if err := discard(s.do(http.MethodPost, "", url.Values{"delete": {""}}, bytes.NewReader(body), header,
+			http.StatusOK)); err != nil {
+			return errors.Wrapf(err, "failed to delete objects %v", batch)
+		}
+	}
+	return nil
+}
+
+// copyObject copies the object inside the bucket on the server.
+func (s *service) copyObject(src, dst string) error {
+	header := http.Header{"X-Oss-Copy-Source": {"/" + s.Bucket + "/" + escapePath(src)}}
+	if err := discard(s.do(http.MethodPut, dst, nil, bytes.NewReader(nil), header, http.StatusOK)); err != nil {
+		return errors.Wrapf(err, "failed to copy object %v to %v", src, dst)
+	}
+	return nil
+}
+
+// readerAt reads the seekable reader at the offsets, the reads are not concurrent.
+type readerAt struct {
+	io.ReadSeeker
+}
+
+func (r readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
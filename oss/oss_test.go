@@ -0,0 +1,373 @@
+package oss
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+)
+
+const (
+	testBucket    = "bucket"
+	testKeyID     = "key-id"
+	testKeySecret = "key-secret"
+	testSTSToken  = "sts-token"
+)
+
+// fakeOSS implements the ECS RAM role credential API and the subset of the OSS API used by the driver, the
+// requests are verified with the OSS header signature.
+type fakeOSS struct {
+	t      *testing.T
+	server *httptest.Server
+
+	lock       sync.Mutex
+	objects    map[string]*fakeObject
+	uploads    map[string]map[int][]byte
+	maxKeys    int
+	corruptCRC bool
+	roleIssued int
+}
+
+type fakeObject struct {
+	data            []byte
+	contentType     string
+	contentEncoding string
+}
+
+func newFakeOSS(t *testing.T) *fakeOSS {
+	f := &fakeOSS{t: t, objects: map[string]*fakeObject{}, uploads: map[string]map[int][]byte{}, maxKeys: 2}
+	f.server = httptest.NewServer(f)
+	return f
+}
+
+func (f *fakeOSS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.URL.Path == "/ram/role" {
+		f.roleIssued++
+		json.NewEncoder(w).Encode(&ramRoleCredential{ // nolint:errcheck
+			Code:            "Success",
+			AccessKeyID:     testKeyID,
+			AccessKeySecret: testKeySecret,
+			SecurityToken:   testSTSToken,
+			Expiration:      time.Now().Add(time.Hour),
+		})
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	expected := "OSS " + testKeyID + ":" + signature(testKeySecret, r, bucket, key)
+	if bucket != testBucket || r.Header.Get("Authorization") != expected || r.Header.Get("X-Oss-Security-Token") != testSTSToken {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>signature mismatched</Message></Error>")
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.serveList(w, query)
+	case r.Method == http.MethodPost && key == "" && query.Has("delete"):
+		f.serveDelete(w, r)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%v</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.serveComplete(w, r, key, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.serveObject(w, r, key)
+	}
+}
+
+func (f *fakeOSS) writeCRC(w http.ResponseWriter, data []byte) {
+	crc := crc64.Checksum(data, crcTable)
+	if f.corruptCRC {
+		crc++
+	}
+	w.Header().Set("X-Oss-Hash-Crc64ecma", strconv.FormatUint(crc, 10))
+}
+
+func (f *fakeOSS) serveList(w http.ResponseWriter, query url.Values) {
+	prefix, delimiter, marker := query.Get("prefix"), query.Get("delimiter"), query.Get("marker")
+	var names []string
+	for name := range f.objects {
+		if strings.HasPrefix(name, prefix) {
+			if i := strings.Index(name[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+				name = name[:len(prefix)+i+len(delimiter)]
+			}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("<ListBucketResult>")
+	count := 0
+	for i, name := range names {
+		if name <= marker || (i > 0 && name == names[i-1]) {
+			continue
+		}
+		if count == f.maxKeys {
+			fmt.Fprintf(&b, "<IsTruncated>true</IsTruncated><NextMarker>%v</NextMarker>", names[i-1])
+			break
+		}
+		count++
+		if strings.HasSuffix(name, delimiter) && delimiter != "" {
+			fmt.Fprintf(&b, "<CommonPrefixes><Prefix>%v</Prefix></CommonPrefixes>", name)
+		} else {
+			fmt.Fprintf(&b, "<Contents><Key>%v</Key><Size>%d</Size></Contents>", name, len(f.objects[name].data))
+		}
+	}
+	b.WriteString("</ListBucketResult>")
+	fmt.Fprint(w, b.String())
+}
+
+func (f *fakeOSS) checkMD5(w http.ResponseWriter, r *http.Request, data []byte) bool {
+	sum := md5.Sum(data)
+	if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "<Error><Code>InvalidDigest</Code><Message>digest mismatched</Message></Error>")
+		return false
+	}
+	return true
+}
+
+func (f *fakeOSS) serveDelete(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !f.checkMD5(w, r, body) {
+		return
+	}
+	request := &deleteRequest{}
+	assert.NoError(f.t, xml.Unmarshal(body, request))
+	for _, obj := range request.Objects {
+		delete(f.objects, obj.Key)
+	}
+	fmt.Fprint(w, "<DeleteResult></DeleteResult>")
+}
+
+func (f *fakeOSS) serveComplete(w http.ResponseWriter, r *http.Request, key, id string) {
+	parts, ok := f.uploads[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	request := &completeMultipartUpload{}
+	body, _ := io.ReadAll(r.Body)
+	assert.NoError(f.t, xml.Unmarshal(body, request))
+	var data []byte
+	for i, part := range request.Parts {
+		assert.Equal(f.t, i+1, part.PartNumber)
+		assert.Equal(f.t, fmt.Sprintf("\"etag-%d\"", part.PartNumber), part.ETag)
+		data = append(data, parts[part.PartNumber]...)
+	}
+	delete(f.uploads, id)
+	f.objects[key] = &fakeObject{data: data}
+	f.writeCRC(w, data)
+	fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+}
+
+func (f *fakeOSS) serveObject(w http.ResponseWriter, r *http.Request, key string) {
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("X-Oss-Copy-Source"); source != "" {
+			src, ok := f.objects[strings.TrimPrefix(source, "/"+testBucket+"/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			copied := *src
+			f.objects[key] = &copied
+			fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if !f.checkMD5(w, r, data) {
+			return
+		}
+		f.writeCRC(w, data)
+		if id := r.URL.Query().Get("uploadId"); id != "" {
+			number, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+			f.uploads[id][number] = data
+			w.Header().Set("ETag", fmt.Sprintf("\"etag-%d\"", number))
+			return
+		}
+		f.objects[key] = &fakeObject{
+			data:            data,
+			contentType:     r.Header.Get("Content-Type"),
+			contentEncoding: r.Header.Get("Content-Encoding"),
+		}
+	case http.MethodHead, http.MethodGet:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if obj.contentType != "" {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		if obj.contentEncoding != "" {
+			w.Header().Set("Content-Encoding", obj.contentEncoding)
+		}
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(obj.data))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestDriver(t *testing.T) {
+	assert := assert.New(t)
+
+	f := newFakeOSS(t)
+	defer f.server.Close()
+	defer func(url string) { ramRoleCredentialURL = url }(ramRoleCredentialURL)
+	ramRoleCredentialURL = f.server.URL + "/ram/"
+
+	destURL := "oss://" + testBucket + "/backups/"
+	backupstore.SetTargetCredential(destURL, map[string]string{
+		types.OSSEndpoint:    f.server.URL,
+		types.OSSRAMRoleName: "role",
+	})
+	defer backupstore.RemoveTargetCredential(destURL)
+
+	driver, err := initFunc(destURL)
+	assert.NoError(err)
+	assert.Equal("oss://bucket/backups", driver.GetURL())
+	d := driver.(*BackupStoreDriver)
+	d.service.PartSize = 10
+
+	assert.NoError(driver.Write("volumes/01/volume.cfg", bytes.NewReader([]byte("config"))))
+	data := []byte("data uploaded in three parts")
+	assert.NoError(driver.Write("volumes/01/blocks/a.blk", bytes.NewReader(data)))
+	assert.Empty(f.uploads)
+	assert.Equal(data, f.objects["backups/volumes/01/blocks/a.blk"].data)
+	assert.NoError(driver.Write("volumes/02/volume.cfg", bytes.NewReader([]byte("config"))))
+	assert.NoError(driver.Write("volumes/03/volume.cfg", bytes.NewReader([]byte("config"))))
+	assert.Equal(1, f.roleIssued, "the credential of the RAM role should be cached")
+
+	assert.True(driver.FileExists("volumes/01/volume.cfg"))
+	assert.Equal(int64(len(data)), driver.FileSize("volumes/01/blocks/a.blk"))
+	assert.False(driver.FileTime("volumes/01/volume.cfg").IsZero())
+	assert.False(driver.FileExists("volumes/01/missing.cfg"))
+
+	// The listings are paginated
+	names, err := driver.List("volumes")
+	assert.NoError(err)
+	assert.Equal([]string{"01", "02", "03"}, names)
+	names, err = driver.List("volumes/01")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"blocks", "volume.cfg"}, names)
+	names, err = driver.(backupstore.PrefixListingBackupStoreDriver).ListPrefix("volumes/")
+	assert.NoError(err)
+	assert.Equal([]string{"volumes/01/blocks/a.blk", "volumes/01/volume.cfg", "volumes/02/volume.cfg",
+		"volumes/03/volume.cfg"}, names)
+
+	rc, err := driver.(backupstore.ObjectMetadataBackupStoreDriver).ReadRange("volumes/01/blocks/a.blk", 5, 8)
+	assert.NoError(err)
+	read, err := io.ReadAll(rc)
+	assert.NoError(err)
+	rc.Close()
+	assert.Equal("uploaded", string(read))
+
+	metadata := &backupstore.ObjectMetadata{ContentType: "application/json", ContentEncoding: "gzip"}
+	assert.NoError(driver.(backupstore.ObjectMetadataBackupStoreDriver).WriteWithMetadata("volumes/01/backup.cfg",
+		bytes.NewReader([]byte("compressed")), metadata))
+	got, err := driver.(backupstore.ObjectMetadataBackupStoreDriver).GetMetadata("volumes/01/backup.cfg")
+	assert.NoError(err)
+	assert.Equal(metadata, got)
+	rc, err = driver.Read("volumes/01/backup.cfg")
+	assert.NoError(err)
+	read, err = io.ReadAll(rc)
+	assert.NoError(err)
+	rc.Close()
+	assert.Equal("compressed", string(read), "the objects should be read as stored")
+
+	assert.NoError(driver.(backupstore.CopyingBackupStoreDriver).Copy("volumes/01/volume.cfg", "volumes/04/volume.cfg"))
+	assert.Equal([]byte("config"), f.objects["backups/volumes/04/volume.cfg"].data)
+
+	// The uploads mismatching the CRC-64 checksum computed by OSS fail, and the multipart upload is aborted
+	f.corruptCRC = true
+	assert.ErrorContains(driver.Write("volumes/05/volume.cfg", bytes.NewReader([]byte("config"))), "CRC-64")
+	assert.ErrorContains(driver.Write("volumes/05/blocks/a.blk", bytes.NewReader(data)), "CRC-64")
+	assert.Empty(f.uploads)
+	f.corruptCRC = false
+
+	assert.NoError(driver.Remove("volumes/01"))
+	names, err = driver.List("volumes")
+	assert.NoError(err)
+	assert.Equal([]string{"02", "03", "04"}, names)
+	assert.NoError(driver.Remove("volumes/02/volume.cfg"))
+	assert.False(driver.FileExists("volumes/02/volume.cfg"))
+}
+
+func TestStaticCredential(t *testing.T) {
+	assert := assert.New(t)
+
+	f := newFakeOSS(t)
+	defer f.server.Close()
+
+	destURL := "oss://" + testBucket + "/backups/"
+	credential := map[string]string{
+		types.OSSEndpoint:        f.server.URL,
+		types.OSSAccessKeyID:     testKeyID,
+		types.OSSAccessKeySecret: "wrong",
+		types.OSSSessionToken:    testSTSToken,
+	}
+	backupstore.SetTargetCredential(destURL, credential)
+	defer backupstore.RemoveTargetCredential(destURL)
+	_, err := initFunc(destURL)
+	assert.ErrorContains(err, "SignatureDoesNotMatch")
+
+	credential[types.OSSAccessKeySecret] = testKeySecret
+	backupstore.SetTargetCredential(destURL, credential)
+	driver, err := initFunc(destURL)
+	assert.NoError(err)
+	assert.NoError(driver.Write("volume.cfg", bytes.NewReader([]byte("config"))))
+	assert.Equal(0, f.roleIssued)
+
+	backupstore.SetTargetCredential(destURL, map[string]string{types.OSSEndpoint: f.server.URL})
+	_, err = initFunc(destURL)
+	assert.ErrorContains(err, "missing OSS credential")
+}
+
+func TestObjectURL(t *testing.T) {
+	assert := assert.New(t)
+
+	endpoint, err := url.Parse("https://oss-cn-hangzhou.aliyuncs.com")
+	assert.NoError(err)
+	s := &service{Bucket: "bucket", Endpoint: endpoint}
+	assert.Equal("https://bucket.oss-cn-hangzhou.aliyuncs.com/backups/a%20b.blk?partNumber=1&uploadId=id",
+		s.objectURL("backups/a b.blk", url.Values{"uploadId": {"id"}, "partNumber": {"1"}}))
+	assert.Equal("https://bucket.oss-cn-hangzhou.aliyuncs.com/backups?uploads",
+		s.objectURL("backups", url.Values{"uploads": {""}}))
+
+	s.Endpoint, err = url.Parse("http://127.0.0.1:9000")
+	assert.NoError(err)
+	assert.Equal("http://127.0.0.1:9000/bucket/?delimiter=%2F&prefix=", s.objectURL("", url.Values{
+		"delimiter": {"/"},
+		"prefix":    {""},
+	}))
+}
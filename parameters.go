@@ -0,0 +1,130 @@
+package backupstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	lhbackup "github.com/longhorn/go-common-libs/backup"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+// BackupParameters are the settings of the backups to a backup target, which are checked by
+// ValidateBackupParameters before any backup starts. The zero values are the defaults.
+type BackupParameters struct {
+	// DestURL is the backup target, whose driver is initialized to check the URL options and the capabilities
+	DestURL           string
+	CompressionMethod string
+	// BlockSize is the size of the backup blocks, DEFAULT_BLOCK_SIZE is the only supported size
+	BlockSize          int64
+	LogicalSectorSize  int64
+	PhysicalSectorSize int64
+	// Parameters are the same as the ones of DeltaBackupConfig, e.g. the backup mode and the secret encrypting
+	// the backing image
+	Parameters   map[string]string
+	UserMetadata json.RawMessage
+
+	ConcurrentLimit    int32
+	CompressionWorkers int32
+	UploadWorkers      int32
+	InlineBlockLimit   int
+	MaxChainLength     int64
+}
+
+// ErrInvalidBackupParameters is returned by ValidateBackupParameters with all the problems found.
+type ErrInvalidBackupParameters struct {
+	Problems []string
+}
+
+func (e *ErrInvalidBackupParameters) Error() string {
+	return fmt.Sprintf("invalid backup parameters: %v", strings.Join(e.Problems, "; "))
+}
+
+// IsInvalidBackupParametersError returns true if the error is caused by the invalid backup parameters.
+func IsInvalidBackupParametersError(err error) bool {
+	var invalidErr *ErrInvalidBackupParameters
+	return errors.As(err, &invalidErr)
+}
+
+// ValidateBackupParameters checks the backup settings up front, so the invalid settings are rejected before a
+// backup starts. All the problems are returned at once in ErrInvalidBackupParameters.
+func ValidateBackupParameters(params BackupParameters) error {
+	problems := []string{}
+	addProblem := func(format string, v ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, v...))
+	}
+
+	if params.CompressionMethod != "" {
+		if _, err := util.GetCompressor(params.CompressionMethod); err != nil {
+			addProblem("unsupported compression method %v, must be one of %v", params.CompressionMethod,
+				strings.Join(util.ListCompressors(), ", "))
+		}
+	}
+	if params.BlockSize != 0 && params.BlockSize != DEFAULT_BLOCK_SIZE {
+		addProblem("unsupported block size %v, must be %v", params.BlockSize, DEFAULT_BLOCK_SIZE)
+	}
+	if err := util.ValidateSectorSizes(params.LogicalSectorSize, params.PhysicalSectorSize); err != nil {
+		addProblem("%v", err)
+	}
+	if err := validateUserMetadata(params.UserMetadata); err != nil {
+		addProblem("%v", err)
+	}
+
+	if mode, exists := params.Parameters[lhbackup.LonghornBackupParameterBackupMode]; exists {
+		switch lhbackup.LonghornBackupMode(mode) {
+		case lhbackup.LonghornBackupModeFull, lhbackup.LonghornBackupModeIncremental:
+		default:
+			addProblem("invalid %v %v, must be %v or %v", lhbackup.LonghornBackupParameterBackupMode, mode,
+				lhbackup.LonghornBackupModeFull, lhbackup.LonghornBackupModeIncremental)
+		}
+	}
+	// The backing image is encrypted by the secret, which must be found by both the name and the namespace
+	secret := params.Parameters[lhbackup.LonghornBackupBackingImageParameterSecret]
+	secretNamespace := params.Parameters[lhbackup.LonghornBackupBackingImageParameterSecretNamespace]
+	if (secret == "") != (secretNamespace == "") {
+		addProblem("%v and %v of the encryption must be set together", lhbackup.LonghornBackupBackingImageParameterSecret,
+			lhbackup.LonghornBackupBackingImageParameterSecretNamespace)
+	}
+
+	for _, limit := range []struct {
+		name  string
+		value int64
+	}{
+		{"concurrent limit", int64(params.ConcurrentLimit)},
+		{"compression workers", int64(params.CompressionWorkers)},
+		{"upload workers", int64(params.UploadWorkers)},
+		{"inline block limit", int64(params.InlineBlockLimit)},
+		{"max chain length", params.MaxChainLength},
+	} {
+		if limit.value < 0 {
+			addProblem("invalid %v %v, must not be negative", limit.name, limit.value)
+		}
+	}
+
+	if params.DestURL != "" {
+		problems = append(problems, validateTargetCapabilities(params.DestURL)...)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ErrInvalidBackupParameters{Problems: problems}
+}
+
+// validateTargetCapabilities checks the backup target accepts the backups.
+func validateTargetCapabilities(destURL string) []string {
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot initialize backup target %v: %v", destURL, err)}
+	}
+	problems := []string{}
+	if IsReadOnlyTarget(driver) {
+		problems = append(problems, fmt.Sprintf("backup target %v is read-only", driver.GetURL()))
+	}
+	if err := CheckTargetRedirect(driver); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
@@ -0,0 +1,43 @@
+package backupstore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBackupParameters(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	assert.NoError(ValidateBackupParameters(BackupParameters{}))
+	assert.NoError(ValidateBackupParameters(BackupParameters{
+		DestURL:           mockDriverURL,
+		CompressionMethod: "lz4",
+		BlockSize:         DEFAULT_BLOCK_SIZE,
+		LogicalSectorSize: 4096,
+		Parameters:        map[string]string{"backup-mode": "full"},
+	}))
+
+	// All the problems are returned at once
+	err := ValidateBackupParameters(BackupParameters{
+		DestURL:           mockDriverURL + "?readOnly=true",
+		CompressionMethod: "zip",
+		BlockSize:         4096,
+		Parameters:        map[string]string{"backup-mode": "differential", "secret": "key"},
+		UserMetadata:      json.RawMessage(`{`),
+		UploadWorkers:     -1,
+	})
+	assert.True(IsInvalidBackupParametersError(err))
+	invalidErr := err.(*ErrInvalidBackupParameters)
+	assert.Len(invalidErr.Problems, 7)
+	assert.Contains(err.Error(), "unsupported compression method zip")
+	assert.Contains(err.Error(), "is read-only")
+
+	err = ValidateBackupParameters(BackupParameters{DestURL: "unknown://target"})
+	assert.True(IsInvalidBackupParametersError(err))
+}
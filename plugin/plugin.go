@@ -0,0 +1,368 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/backupstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "plugin"})
+)
+
+const (
+	// SchemePrefix is the prefix of the backup target URL scheme of the plugins, e.g. plugin+foo://bucket/path/
+	// is served by the plugin foo
+	SchemePrefix = "plugin+"
+
+	// SocketSuffix is the suffix of the unix sockets of the plugins found by RegisterDirectory
+	SocketSuffix = ".sock"
+
+	// chunkSize is the size of the data sent by each Read and WriteChunk call
+	chunkSize = 4 * 1024 * 1024
+
+	dialTimeout = 10 * time.Second
+)
+
+// connection is shared by the drivers of a plugin, net/rpc serializes the requests in flight on it.
+type connection struct {
+	name       string
+	socketPath string
+
+	lock   sync.Mutex
+	client *rpc.Client
+}
+
+var (
+	// idempotentMethods are the methods safe to be sent again after the connection is lost
+	idempotentMethods = map[string]bool{
+		"Init": true,
+		"List": true,
+		"Stat": true,
+		"Read": true,
+	}
+
+	connectionsLock sync.Mutex
+	connections     = map[string]*connection{}
+)
+
+// Register registers the out-of-tree driver served by the plugin listening on the unix socket, for the
+// backup target URLs with the plugin+<name> scheme. The plugins should be registered when the process starts,
+// before any driver is initialized.
+func Register(name, socketPath string) error {
+	if name == "" || strings.ContainsAny(name, ":/+") {
+		return fmt.Errorf("invalid plugin name %q", name)
+	}
+
+	kind := SchemePrefix + name
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+	if err := backupstore.RegisterDriver(kind, initFunc); err != nil {
+		return err
+	}
+	connections[kind] = &connection{name: name, socketPath: socketPath}
+	log.Infof("Registered plugin %v at %v", name, socketPath)
+	return nil
+}
+
+// RegisterDirectory registers the plugins listening on the unix sockets in the directory, the name of each
+// plugin is the name of its socket without the .sock suffix.
+func RegisterDirectory(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), SocketSuffix)
+		if !ok || entry.Type()&os.ModeSocket == 0 {
+			continue
+		}
+		if err := Register(name, filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call calls the method of the plugin, the connection is established again if the plugin has restarted. Only
+// the idempotent methods are sent again on the new connection, the mutations may have been applied by the plugin
+// before the connection was lost, so their errors are returned and the next call connects again.
+func (c *connection) call(method string, args, reply interface{}) error {
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+	err = client.Call(ServiceName+"."+method, args, reply)
+	if err == rpc.ErrShutdown || err == io.ErrUnexpectedEOF {
+		c.reset(client)
+		if !idempotentMethods[method] {
+			return errors.Wrapf(err, "plugin %v lost connection during %v", c.name, method)
+		}
+		if client, err = c.getClient(); err != nil {
+			return err
+		}
+		err = client.Call(ServiceName+"."+method, args, reply)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "plugin %v failed to %v", c.name, method)
+	}
+	return nil
+}
+
+func (c *connection) getClient() (*rpc.Client, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	conn, err := net.DialTimeout("unix", c.socketPath, dialTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to plugin %v", c.name)
+	}
+	c.client = jsonrpc.NewClient(conn)
+	return c.client, nil
+}
+
+func (c *connection) reset(client *rpc.Client) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.client == client {
+		c.client.Close()
+		c.client = nil
+	}
+}
+
+// BackupStoreDriver forwards the operations to the driver served by the plugin.
+type BackupStoreDriver struct {
+	kind    string
+	destURL string
+	url     string
+	conn    *connection
+}
+
+func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	connectionsLock.Lock()
+	conn, ok := connections[u.Scheme]
+	connectionsLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("wrong driver dispatching %v to %v?", u.Scheme, SchemePrefix+"*")
+	}
+
+	resp := &InitResponse{}
+	if err := conn.call("Init", &InitRequest{
+		DestURL:    destURL,
+		Credential: backupstore.GetTargetCredential(destURL),
+	}, resp); err != nil {
+		return nil, err
+	}
+	if resp.ProtocolVersion != ProtocolVersion {
+		return nil, fmt.Errorf("plugin %v serves protocol version %v, but version %v is required",
+			conn.name, resp.ProtocolVersion, ProtocolVersion)
+	}
+
+	b := &BackupStoreDriver{kind: u.Scheme, destURL: destURL, conn: conn}
+	b.url = u.Scheme + "://" + u.Host + "/" + strings.Trim(u.Path, "/")
+	log.Infof("Loaded driver for %v", b.url)
+	return b, nil
+}
+
+// Kind returns the driver type
+func (b *BackupStoreDriver) Kind() string {
+	return b.kind
+}
+
+// GetURL returns URL of the backup target
+func (b *BackupStoreDriver) GetURL() string {
+	return b.url
+}
+
+// List return items that on the backup target including prefixes
+func (b *BackupStoreDriver) List(listPath string) ([]string, error) {
+	resp := &ListResponse{}
+	if err := b.conn.call("List", &PathRequest{DestURL: b.destURL, Path: listPath}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Names, nil
+}
+
+func (b *BackupStoreDriver) stat(filePath string) (*StatResponse, error) {
+	resp := &StatResponse{}
+	if err := b.conn.call("Stat", &PathRequest{DestURL: b.destURL, Path: filePath}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// FileExists checks if file exists on the backup target
+func (b *BackupStoreDriver) FileExists(filePath string) bool {
+	return b.FileSize(filePath) >= 0
+}
+
+// FileSize return content length of the filePath on the backup target
+func (b *BackupStoreDriver) FileSize(filePath string) int64 {
+	resp, err := b.stat(filePath)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to get the size of %v", filePath)
+		return -1
+	}
+	return resp.Size
+}
+
+// FileTime returns file last modified time on the backup target
+func (b *BackupStoreDriver) FileTime(filePath string) time.Time {
+	resp, err := b.stat(filePath)
+	if err != nil || resp.Size < 0 {
+		return time.Time{}
+	}
+	return resp.ModTime.UTC()
+}
+
+// Remove deletes files on the backup target
+func (b *BackupStoreDriver) Remove(path string) error {
+	return b.conn.call("Remove", &PathRequest{DestURL: b.destURL, Path: path}, &Empty{})
+}
+
+// Read returns the reader of the file on the backup target, the data is read from the plugin in chunks.
+func (b *BackupStoreDriver) Read(src string) (io.ReadCloser, error) {
+	r := &reader{driver: b, path: src}
+	// Read the first chunk, so the missing file is reported right away
+	if err := r.fill(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write creates a item on the backup target from io stream
+func (b *BackupStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	resp := &OpenWriterResponse{}
+	if err := b.conn.call("OpenWriter", &OpenWriterRequest{DestURL: b.destURL, Path: dst}, resp); err != nil {
+		return err
+	}
+
+	commit := false
+	defer func() {
+		if !commit {
+			if err := b.conn.call("CloseWriter", &CloseWriterRequest{WriterID: resp.WriterID}, &Empty{}); err != nil {
+				log.WithError(err).Warnf("Failed to discard the data written to %v", dst)
+			}
+		}
+	}()
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(rs, buf)
+		if n > 0 {
+			if err := b.conn.call("WriteChunk", &WriteChunkRequest{WriterID: resp.WriterID, Data: buf[:n]}, &Empty{}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	commit = true
+	return b.conn.call("CloseWriter", &CloseWriterRequest{WriterID: resp.WriterID, Commit: true}, &Empty{})
+}
+
+// Upload creates a item on the backup target by opening source file
+func (b *BackupStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return b.Write(dst, file)
+}
+
+// Download gets a item data from the backup target
+func (b *BackupStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0700); err != nil {
+		return err
+	}
+
+	rc, err := b.Read(src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
+
+// reader reads the file from the plugin in chunks.
+type reader struct {
+	driver *BackupStoreDriver
+	path   string
+	offset int64
+	data   []byte
+	eof    bool
+}
+
+func (r *reader) fill() error {
+	resp := &ReadResponse{}
+	if err := r.driver.conn.call("Read", &ReadRequest{
+		DestURL: r.driver.destURL,
+		Path:    r.path,
+		Offset:  r.offset,
+		Length:  chunkSize,
+	}, resp); err != nil {
+		return err
+	}
+	if len(resp.Data) == 0 && !resp.EOF {
+		return fmt.Errorf("plugin %v returned no data of %v at offset %v", r.driver.conn.name, r.path, r.offset)
+	}
+	r.data = resp.Data
+	r.offset += int64(len(resp.Data))
+	r.eof = resp.EOF
+	return nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	return nil
+}
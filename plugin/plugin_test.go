@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/memory"
+)
+
+// servePlugin serves the memory driver as the plugin in the directory.
+func servePlugin(t *testing.T, dir, name string) {
+	listener, err := net.Listen("unix", filepath.Join(dir, name+SocketSuffix))
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go Serve(listener, func(destURL string, credential map[string]string) (backupstore.BackupStoreDriver, error) { // nolint:errcheck
+		if credential["TOKEN"] != "secret" {
+			return nil, os.ErrPermission
+		}
+		destURL = "memory" + strings.TrimPrefix(destURL, SchemePrefix+name)
+		return backupstore.GetBackupStoreDriver(destURL)
+	})
+}
+
+func TestPlugin(t *testing.T) {
+	assert := assert.New(t)
+
+	// The unix socket paths are limited to 108 bytes, the test temporary directories may be longer
+	dir, err := os.MkdirTemp("", "plugin")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	servePlugin(t, dir, "test")
+	assert.NoError(os.WriteFile(filepath.Join(dir, "other.sock"), nil, 0600))
+	assert.NoError(RegisterDirectory(dir))
+	_, err = backupstore.GetBackupStoreDriver("plugin+other://store/path/")
+	assert.ErrorContains(err, "not supported")
+
+	destURL := "plugin+test://test-plugin/path/"
+	defer memory.Reset("memory://test-plugin/") // nolint:errcheck
+	_, err = backupstore.GetBackupStoreDriver(destURL)
+	assert.ErrorContains(err, "plugin test failed to Init")
+	backupstore.SetTargetCredential(destURL, map[string]string{"TOKEN": "secret"})
+	defer backupstore.RemoveTargetCredential(destURL)
+
+	driver, err := backupstore.GetBackupStoreDriver(destURL)
+	assert.NoError(err)
+	assert.Equal("plugin+test", driver.Kind())
+	assert.Equal("plugin+test://test-plugin/path", driver.GetURL())
+
+	// The data larger than a chunk is sent in multiple calls
+	data := make([]byte, chunkSize+10)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	assert.NoError(driver.Write("volumes/01/blocks/a.blk", bytes.NewReader(data)))
+	assert.NoError(driver.Write("volumes/01/volume.cfg", bytes.NewReader([]byte("config"))))
+	assert.True(driver.FileExists("volumes/01/volume.cfg"))
+	assert.Equal(int64(len(data)), driver.FileSize("volumes/01/blocks/a.blk"))
+	assert.False(driver.FileTime("volumes/01/volume.cfg").IsZero())
+	assert.False(driver.FileExists("volumes/01/missing.cfg"))
+	assert.True(driver.FileTime("volumes/01/missing.cfg").IsZero())
+
+	names, err := driver.List("volumes/01")
+	assert.NoError(err)
+	assert.Equal([]string{"blocks", "volume.cfg"}, names)
+
+	rc, err := driver.Read("volumes/01/blocks/a.blk")
+	assert.NoError(err)
+	read, err := io.ReadAll(rc)
+	assert.NoError(err)
+	assert.NoError(rc.Close())
+	assert.Equal(data, read)
+	_, err = driver.Read("volumes/01/missing.cfg")
+	assert.Error(err)
+
+	dst := filepath.Join(t.TempDir(), "volume.cfg")
+	assert.NoError(driver.Download("volumes/01/volume.cfg", dst))
+	read, err = os.ReadFile(dst)
+	assert.NoError(err)
+	assert.Equal("config", string(read))
+
+	assert.NoError(driver.Remove("volumes/01/blocks"))
+	names, err = driver.List("volumes/01")
+	assert.NoError(err)
+	assert.Equal([]string{"volume.cfg"}, names)
+}
+
+func TestRegister(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, name := range []string{"", "a/b", "a+b", "a:b"} {
+		assert.Error(Register(name, "/tmp/plugin.sock"), name)
+	}
+	assert.NoError(Register("test-register", "/tmp/missing.sock"))
+	assert.ErrorContains(Register("test-register", "/tmp/missing.sock"), "already been registered")
+	_, err := backupstore.GetBackupStoreDriver("plugin+test-register://store/path/")
+	assert.ErrorContains(err, "failed to connect to plugin test-register")
+}
+
+func TestCallAfterConnectionLost(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := os.MkdirTemp("", "plugin")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	servePlugin(t, dir, "flaky")
+	assert.NoError(Register("flaky", filepath.Join(dir, "flaky"+SocketSuffix)))
+
+	destURL := "plugin+flaky://flaky-plugin/path/"
+	defer memory.Reset("memory://flaky-plugin/") // nolint:errcheck
+	backupstore.SetTargetCredential(destURL, map[string]string{"TOKEN": "secret"})
+	defer backupstore.RemoveTargetCredential(destURL)
+	driver, err := backupstore.GetBackupStoreDriver(destURL)
+	assert.NoError(err)
+	assert.NoError(driver.Write("volumes/01/volume.cfg", bytes.NewReader([]byte("config"))))
+
+	conn := connections[SchemePrefix+"flaky"]
+	loseConnection := func() {
+		client, err := conn.getClient()
+		assert.NoError(err)
+		assert.NoError(client.Close())
+	}
+
+	// The reads are sent again on the new connection
+	loseConnection()
+	names, err := driver.List("volumes/01")
+	assert.NoError(err)
+	assert.Equal([]string{"volume.cfg"}, names)
+
+	// The mutations fail, and the next call connects again
+	loseConnection()
+	err = driver.Remove("volumes/01/volume.cfg")
+	assert.ErrorContains(err, "plugin flaky lost connection during Remove")
+	assert.True(driver.FileExists("volumes/01/volume.cfg"))
+	loseConnection()
+	assert.Error(driver.Write("volumes/01/backup.cfg", bytes.NewReader([]byte("backup"))))
+	assert.NoError(driver.Remove("volumes/01/volume.cfg"))
+	assert.False(driver.FileExists("volumes/01/volume.cfg"))
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"time"
+)
+
+// The plugins serve the JSON-RPC 1.0 protocol of net/rpc/jsonrpc over a unix socket, so they can be implemented
+// in any language without sharing the Go types. The methods are named ServiceName.<Method>, e.g.
+// BackupStoreDriver.Stat, the paths in the requests are relative to the backup target URL of the request.
+const (
+	// ServiceName is the name of the RPC service the plugins serve
+	ServiceName = "BackupStoreDriver"
+
+	// ProtocolVersion is the version of the protocol reported by the plugins in the response of Init
+	ProtocolVersion = 1
+)
+
+// InitRequest initializes the driver of the backup target URL in the plugin, the URL keeps the plugin+<name>
+// scheme. The credential is the one set by backupstore.SetTargetCredential for the target, nil if unset.
+type InitRequest struct {
+	DestURL    string
+	Credential map[string]string
+}
+
+// InitResponse reports the protocol version of the plugin.
+type InitResponse struct {
+	ProtocolVersion int
+}
+
+// PathRequest is the request of the methods operating on a path, i.e. List, Stat and Remove.
+type PathRequest struct {
+	DestURL string
+	Path    string
+}
+
+// ListResponse is the response of List.
+type ListResponse struct {
+	Names []string
+}
+
+// StatResponse is the response of Stat, the size is -1 if the file doesn't exist.
+type StatResponse struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// ReadRequest reads at most Length bytes of the file at the offset.
+type ReadRequest struct {
+	DestURL string
+	Path    string
+	Offset  int64
+	Length  int64
+}
+
+// ReadResponse returns the data read, EOF is set once the end of the file is reached.
+type ReadResponse struct {
+	Data []byte
+	EOF  bool
+}
+
+// OpenWriterRequest starts writing the file, the data is sent by WriteChunk and the file is created or
+// replaced by CloseWriter, so the readers never see a partial file.
+type OpenWriterRequest struct {
+	DestURL string
+	Path    string
+}
+
+// OpenWriterResponse returns the ID of the writer used by WriteChunk and CloseWriter.
+type OpenWriterResponse struct {
+	WriterID string
+}
+
+// WriteChunkRequest appends the data to the writer.
+type WriteChunkRequest struct {
+	WriterID string
+	Data     []byte
+}
+
+// CloseWriterRequest creates the file from the data written if Commit is set, otherwise discards the data.
+type CloseWriterRequest struct {
+	WriterID string
+	Commit   bool
+}
+
+// Empty is the request or the response of the methods without any.
+type Empty struct{}
@@ -0,0 +1,221 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/longhorn/backupstore"
+)
+
+// DriverFactory initializes the driver of the backup target URL with the credential of the target in the plugin.
+type DriverFactory func(destURL string, credential map[string]string) (backupstore.BackupStoreDriver, error)
+
+// Service implements the RPC service of the plugins on top of a BackupStoreDriver, so the plugins written in Go
+// only implement the driver.
+type Service struct {
+	factory DriverFactory
+
+	lock    sync.Mutex
+	drivers map[string]backupstore.BackupStoreDriver
+	writers map[string]*writer
+	nextID  uint64
+}
+
+type writer struct {
+	destURL string
+	path    string
+	file    *os.File
+}
+
+// NewService returns the RPC service of the drivers initialized by the factory.
+func NewService(factory DriverFactory) *Service {
+	return &Service{
+		factory: factory,
+		drivers: map[string]backupstore.BackupStoreDriver{},
+		writers: map[string]*writer{},
+	}
+}
+
+// Serve serves the drivers initialized by the factory on the listener until it's closed, e.g.
+//
+//	listener, _ := net.Listen("unix", "/var/run/backupstore-plugins/foo.sock")
+//	plugin.Serve(listener, newFooDriver)
+func Serve(listener net.Listener, factory DriverFactory) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(ServiceName, NewService(factory)); err != nil {
+		return err
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+func (s *Service) getDriver(destURL string) (backupstore.BackupStoreDriver, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	driver, ok := s.drivers[destURL]
+	if !ok {
+		return nil, fmt.Errorf("driver of %v is not initialized", destURL)
+	}
+	return driver, nil
+}
+
+// Init initializes the driver of the backup target, the driver is replaced each time, so the updated
+// credential takes effect.
+func (s *Service) Init(req *InitRequest, resp *InitResponse) error {
+	driver, err := s.factory(req.DestURL, req.Credential)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.drivers[req.DestURL] = driver
+	s.lock.Unlock()
+
+	resp.ProtocolVersion = ProtocolVersion
+	return nil
+}
+
+// List lists the items under the path
+func (s *Service) List(req *PathRequest, resp *ListResponse) error {
+	driver, err := s.getDriver(req.DestURL)
+	if err != nil {
+		return err
+	}
+	resp.Names, err = driver.List(req.Path)
+	return err
+}
+
+// Stat returns the size and the modification time of the file
+func (s *Service) Stat(req *PathRequest, resp *StatResponse) error {
+	driver, err := s.getDriver(req.DestURL)
+	if err != nil {
+		return err
+	}
+	resp.Size = driver.FileSize(req.Path)
+	if resp.Size >= 0 {
+		resp.ModTime = driver.FileTime(req.Path)
+	}
+	return nil
+}
+
+// Remove removes the path
+func (s *Service) Remove(req *PathRequest, resp *Empty) error {
+	driver, err := s.getDriver(req.DestURL)
+	if err != nil {
+		return err
+	}
+	return driver.Remove(req.Path)
+}
+
+// Read reads the data range of the file, the drivers which don't read ranges skip the data before the offset.
+func (s *Service) Read(req *ReadRequest, resp *ReadResponse) error {
+	driver, err := s.getDriver(req.DestURL)
+	if err != nil {
+		return err
+	}
+
+	var rc io.ReadCloser
+	if ranged, ok := driver.(backupstore.ObjectMetadataBackupStoreDriver); ok {
+		rc, err = ranged.ReadRange(req.Path, req.Offset, req.Length+1)
+		if err != nil {
+			return err
+		}
+	} else {
+		if rc, err = driver.Read(req.Path); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(io.Discard, rc, req.Offset); err != nil && err != io.EOF {
+			rc.Close()
+			return err
+		}
+	}
+	defer rc.Close()
+
+	// Read one more byte to find out if the end of the file is reached
+	data, err := io.ReadAll(io.LimitReader(rc, req.Length+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > req.Length {
+		resp.Data = data[:req.Length]
+		return nil
+	}
+	resp.Data = data
+	resp.EOF = true
+	return nil
+}
+
+// OpenWriter starts writing the file, the data is kept in a temporary file until the writer is closed.
+func (s *Service) OpenWriter(req *OpenWriterRequest, resp *OpenWriterResponse) error {
+	if _, err := s.getDriver(req.DestURL); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp("", "backupstore-plugin-")
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nextID++
+	resp.WriterID = strconv.FormatUint(s.nextID, 10)
+	s.writers[resp.WriterID] = &writer{destURL: req.DestURL, path: req.Path, file: file}
+	return nil
+}
+
+func (s *Service) getWriter(id string) (*writer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	w, ok := s.writers[id]
+	if !ok {
+		return nil, fmt.Errorf("writer %v is not found", id)
+	}
+	return w, nil
+}
+
+// WriteChunk appends the data to the writer
+func (s *Service) WriteChunk(req *WriteChunkRequest, resp *Empty) error {
+	w, err := s.getWriter(req.WriterID)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Write(req.Data)
+	return err
+}
+
+// CloseWriter writes the file on the backup target if the data is committed, and removes the writer.
+func (s *Service) CloseWriter(req *CloseWriterRequest, resp *Empty) error {
+	w, err := s.getWriter(req.WriterID)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	delete(s.writers, req.WriterID)
+	s.lock.Unlock()
+	defer func() {
+		w.file.Close()
+		os.Remove(w.file.Name())
+	}()
+
+	if !req.Commit {
+		return nil
+	}
+	driver, err := s.getDriver(w.destURL)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return driver.Write(w.path, w.file)
+}
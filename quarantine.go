@@ -0,0 +1,161 @@
+package backupstore
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/longhorn/backupstore/logging"
+	"github.com/longhorn/backupstore/util"
+)
+
+// QuarantinedBackup is the record of a backup of a broken backup chain. The quarantined backups are kept for
+// salvaging the data, but are refused by the restores and never extended by the incremental backups.
+type QuarantinedBackup struct {
+	Reason        string
+	QuarantinedAt string
+}
+
+// ErrQuarantinedBackup is returned when restoring a quarantined backup.
+type ErrQuarantinedBackup struct {
+	VolumeName string
+	BackupName string
+	Reason     string
+}
+
+func (e *ErrQuarantinedBackup) Error() string {
+	return fmt.Sprintf("backup %v of volume %v is quarantined: %v", e.BackupName, e.VolumeName, e.Reason)
+}
+
+// IsQuarantinedBackupError returns true if the error is caused by a quarantined backup.
+func IsQuarantinedBackupError(err error) bool {
+	var quarantinedErr *ErrQuarantinedBackup
+	return errors.As(err, &quarantinedErr)
+}
+
+func checkBackupNotQuarantined(volume *Volume, backupName string) error {
+	if quarantined, exists := volume.QuarantinedBackups[backupName]; exists {
+		return &ErrQuarantinedBackup{
+			VolumeName: volume.Name,
+			BackupName: backupName,
+			Reason:     quarantined.Reason,
+		}
+	}
+	return nil
+}
+
+// QuarantineBackupChain quarantines the broken backup and the incremental backups based on it, and demotes the
+// next backup of the volume to a full backup. The last backup of the volume is quarantined if the backup URL
+// doesn't specify the backup. The configs of the in progress backups left by the failed attempts are removed,
+// and the names of the quarantined backups are returned.
+func QuarantineBackupChain(backupURL, reason string) ([]string, error) {
+	driver, err := GetBackupStoreDriver(backupURL)
+	if err != nil {
+		return nil, err
+	}
+
+	backupName, volumeName, _, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	log := log.WithFields(logrus.Fields{
+		LogFieldVolume: volumeName,
+	})
+
+	if err := CheckTargetMutable(driver, "quarantine backups of volume "+volumeName); err != nil {
+		return nil, err
+	}
+
+	// The deletion lock keeps the backups from running while the in progress backup configs are removed
+	lock, err := New(driver, volumeName, DELETION_LOCK)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if unlockErr := lock.Unlock(); unlockErr != nil {
+			log.WithError(unlockErr).Warn("Failed to unlock")
+		}
+	}()
+
+	volume, err := loadVolume(driver, volumeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot find volume %v in backupstore", volumeName)
+	}
+	if backupName == "" {
+		backupName = volume.LastBackupName
+	}
+	if backupName == "" {
+		return nil, fmt.Errorf("volume %v doesn't have a backup to quarantine", volumeName)
+	}
+
+	backupNames, err := getBackupNamesForVolume(driver, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	completed := []*Backup{}
+	for _, name := range backupNames {
+		if name == backupName {
+			found = true
+		}
+		backup, err := loadBackup(driver, name, volumeName)
+		if err != nil {
+			// The damaged configs other than the broken backup are left to the deletion
+			log.WithError(err).Warnf("Failed to load backup %v", name)
+			continue
+		}
+		if isBackupInProgress(backup) {
+			if err := removeBackup(backup, driver); err != nil {
+				return nil, errors.Wrapf(err, "failed to remove in progress backup %v", name)
+			}
+			log.Infof("Removed in progress backup %v", name)
+			continue
+		}
+		completed = append(completed, backup)
+	}
+	if !found {
+		return nil, fmt.Errorf("cannot find backup %v of volume %v", backupName, volumeName)
+	}
+
+	// The incremental backups created after the broken backup until the next full backup are based on it. If
+	// the broken backup cannot be loaded, only itself is quarantined.
+	sort.Slice(completed, func(i, j int) bool {
+		return completed[i].CreatedTime < completed[j].CreatedTime
+	})
+	quarantined := []string{backupName}
+	for i, backup := range completed {
+		if backup.Name != backupName {
+			continue
+		}
+		for _, next := range completed[i+1:] {
+			if !next.IsIncremental {
+				break
+			}
+			quarantined = append(quarantined, next.Name)
+		}
+		break
+	}
+
+	if volume.QuarantinedBackups == nil {
+		volume.QuarantinedBackups = map[string]*QuarantinedBackup{}
+	}
+	now := util.Now()
+	for _, name := range quarantined {
+		volume.QuarantinedBackups[name] = &QuarantinedBackup{
+			Reason:        reason,
+			QuarantinedAt: now,
+		}
+	}
+	volume.RequireFullBackup = true
+	if err := saveVolume(driver, volume); err != nil {
+		return nil, err
+	}
+
+	log.Warnf("Quarantined backups %v for %v, the next backup of the volume will be a full backup", quarantined, reason)
+	return quarantined, nil
+}
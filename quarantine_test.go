@@ -0,0 +1,59 @@
+package backupstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestQuarantineBackupChain(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	util.SetClock(util.NewFakeClock(time.Now()))
+	defer util.SetClock(nil)
+
+	backups := map[string]string{
+		"backup-1": `{"Name":"backup-1","VolumeName":"pvc-1","CreatedTime":"2021-06-01T08:00:00Z"}`,
+		"backup-2": `{"Name":"backup-2","VolumeName":"pvc-1","CreatedTime":"2021-06-02T08:00:00Z","IsIncremental":true}`,
+		"backup-3": `{"Name":"backup-3","VolumeName":"pvc-1","CreatedTime":"2021-06-03T08:00:00Z","IsIncremental":true}`,
+		"backup-4": `{"Name":"backup-4","VolumeName":"pvc-1"}`,
+	}
+	assert.NoError(saveVolume(m, &Volume{Name: "pvc-1", Size: DEFAULT_BLOCK_SIZE, LastBackupName: "backup-3"}))
+	for name, cfg := range backups {
+		assert.NoError(afero.WriteFile(m.fs, getBackupConfigPath(name, "pvc-1"), []byte(cfg), 0644))
+	}
+
+	quarantined, err := QuarantineBackupChain(EncodeBackupURL("backup-2", "pvc-1", mockDriverURL), "missing blocks")
+	assert.NoError(err)
+	assert.Equal([]string{"backup-2", "backup-3"}, quarantined)
+
+	// The in progress backup left by the failed attempt is removed
+	assert.False(m.FileExists(getBackupConfigPath("backup-4", "pvc-1")))
+	assert.True(m.FileExists(getBackupConfigPath("backup-2", "pvc-1")))
+
+	// The next backup is a full backup, and the quarantined backups cannot be restored
+	volume, err := loadVolume(m, "pvc-1")
+	assert.NoError(err)
+	assert.True(volume.RequireFullBackup)
+	assert.Nil(findLastBackup(m, &DeltaBackupConfig{Snapshot: &Snapshot{Name: "snap-4"}}, volume))
+	assert.NoError(checkBackupNotQuarantined(volume, "backup-1"))
+	err = checkBackupNotQuarantined(volume, "backup-3")
+	assert.True(IsQuarantinedBackupError(err), "unexpected error %v", err)
+	assert.Contains(err.Error(), "missing blocks")
+
+	// The last backup is quarantined if the backup isn't specified
+	quarantined, err = QuarantineBackupChain(EncodeBackupURL("", "pvc-1", mockDriverURL), "damaged config")
+	assert.NoError(err)
+	assert.Equal([]string{"backup-3"}, quarantined)
+
+	_, err = QuarantineBackupChain(EncodeBackupURL("backup-5", "pvc-1", mockDriverURL), "unknown")
+	assert.Error(err)
+}
@@ -0,0 +1,107 @@
+package backupstore
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RawAccessOption is the backup target URL query parameter enabling the raw access to the objects of the
+	// backup target for the support tooling, e.g. s3://bucket@region/path/?rawAccess=true
+	RawAccessOption = "rawAccess"
+)
+
+// RawAccessBackupStoreDriver reads and writes the objects of the backup target by the paths relative to the
+// backup target, e.g. to fetch or repair a damaged config. The objects are accessed through the credentials and
+// the mount of the driver, bypassing the validation of the backup data.
+type RawAccessBackupStoreDriver interface {
+	RawGet(filePath string) (io.ReadCloser, error) // Caller needs to close
+	RawPut(filePath string, rs io.ReadSeeker) error
+	RawList(path string) ([]string, error) // Behavior like "ls", not like "find"
+}
+
+// rawAccessDriver wraps a driver and enables the raw access. The writes still go through the other wrappers,
+// e.g. the overwrites are refused on an immutable target.
+type rawAccessDriver struct {
+	BackupStoreDriver
+}
+
+func (d *rawAccessDriver) Unwrap() BackupStoreDriver {
+	return d.BackupStoreDriver
+}
+
+func isRawAccessURL(destURL string) (bool, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return false, err
+	}
+	value := u.Query().Get(RawAccessOption)
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %v option %v in backup target URL", RawAccessOption, value)
+	}
+	return enabled, nil
+}
+
+// GetRawAccessDriver returns the raw access of the driver, which must be enabled by the RawAccessOption in the
+// backup target URL.
+func GetRawAccessDriver(driver BackupStoreDriver) (RawAccessBackupStoreDriver, error) {
+	raw, ok := findDriver[*rawAccessDriver](driver)
+	if !ok {
+		return nil, fmt.Errorf("raw access isn't enabled on backup target %v, set the %v option in the backup target URL",
+			driver.GetURL(), RawAccessOption)
+	}
+	return raw, nil
+}
+
+// cleanRawPath returns the cleaned path relative to the backup target, the path escaping the backup target is
+// refused.
+func cleanRawPath(path string) (string, error) {
+	cleaned := filepath.Clean(path)
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid raw path %v, must be relative to the backup target", path)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+func (d *rawAccessDriver) RawGet(filePath string) (io.ReadCloser, error) {
+	cleaned, err := cleanRawPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if cleaned == "" {
+		return nil, fmt.Errorf("raw path of the object must not be empty")
+	}
+	log.Infof("Reading raw object %v of backup target %v", cleaned, d.GetURL())
+	return d.Read(cleaned)
+}
+
+func (d *rawAccessDriver) RawPut(filePath string, rs io.ReadSeeker) error {
+	cleaned, err := cleanRawPath(filePath)
+	if err != nil {
+		return err
+	}
+	if cleaned == "" {
+		return fmt.Errorf("raw path of the object must not be empty")
+	}
+	log.Warnf("Writing raw object %v of backup target %v", cleaned, d.GetURL())
+	return d.Write(cleaned, rs)
+}
+
+func (d *rawAccessDriver) RawList(path string) ([]string, error) {
+	cleaned, err := cleanRawPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return d.List(cleaned)
+}
@@ -0,0 +1,60 @@
+package backupstore
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawAccess(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	// The raw access must be enabled explicitly
+	driver, err := GetBackupStoreDriver(mockDriverURL)
+	assert.NoError(err)
+	_, err = GetRawAccessDriver(driver)
+	assert.Error(err)
+	_, err = GetBackupStoreDriver(mockDriverURL + "?rawAccess=maybe")
+	assert.Error(err)
+
+	driver, err = GetBackupStoreDriver(mockDriverURL + "?rawAccess=true")
+	assert.NoError(err)
+	raw, err := GetRawAccessDriver(driver)
+	assert.NoError(err)
+
+	err = m.fs.MkdirAll(getVolumePath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":`), 0644)
+	assert.NoError(err)
+
+	// The damaged volume config is fetched and repaired as is
+	rc, err := raw.RawGet(getVolumeFilePath("pvc-1"))
+	assert.NoError(err)
+	data, err := io.ReadAll(rc)
+	assert.NoError(err)
+	rc.Close()
+	assert.Equal(`{"Name":`, string(data))
+	assert.NoError(raw.RawPut(getVolumeFilePath("pvc-1"), bytes.NewReader([]byte(`{"Name":"pvc-1"}`))))
+	volume, err := loadVolume(driver, "pvc-1")
+	assert.NoError(err)
+	assert.Equal("pvc-1", volume.Name)
+
+	names, err := raw.RawList(getVolumePath("pvc-1"))
+	assert.NoError(err)
+	assert.Contains(names, VOLUME_CONFIG_FILE)
+
+	// The paths escaping the backup target are refused
+	for _, path := range []string{"/etc/passwd", "../other", "backupstore/../../other", ""} {
+		_, err = raw.RawGet(path)
+		assert.Error(err, path)
+	}
+	_, err = raw.RawList("..")
+	assert.Error(err)
+}
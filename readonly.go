@@ -0,0 +1,131 @@
+package backupstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// ReadOnlyTargetOption is the backup target URL query parameter enabling the read-only mode, e.g.
+	// nfs://server:/path/?readOnly=true for the restore-only clusters. The mount-based drivers mount the share
+	// read-only as well.
+	ReadOnlyTargetOption = "readOnly"
+)
+
+// ErrReadOnlyTarget is returned when a write operation is attempted on a backup target configured in read-only
+// mode.
+type ErrReadOnlyTarget struct {
+	DestURL   string
+	Operation string
+	Path      string
+}
+
+func (e *ErrReadOnlyTarget) Error() string {
+	target := e.DestURL
+	if e.Path != "" {
+		target = fmt.Sprintf("%v (%v)", e.DestURL, e.Path)
+	}
+	return fmt.Sprintf("refusing to %v on read-only backup target %v, remove the %v option from the backup target "+
+		"URL if the backup target is intended to be modified", e.Operation, target, ReadOnlyTargetOption)
+}
+
+// IsReadOnlyTargetError returns true if the error is caused by a refused operation on a read-only target.
+func IsReadOnlyTargetError(err error) bool {
+	var readOnlyErr *ErrReadOnlyTarget
+	return errors.As(err, &readOnlyErr)
+}
+
+// readOnlyDriver wraps a driver and refuses all the writes. The locks aren't stored on the read-only target
+// either, see FileLock.
+type readOnlyDriver struct {
+	BackupStoreDriver
+}
+
+// IsReadOnlyTargetURL returns true if the backup target URL enables the read-only mode.
+func IsReadOnlyTargetURL(destURL string) (bool, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return false, err
+	}
+	value := u.Query().Get(ReadOnlyTargetOption)
+	if value == "" {
+		return false, nil
+	}
+	readOnly, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %v option %v in backup target URL", ReadOnlyTargetOption, value)
+	}
+	return readOnly, nil
+}
+
+// IsReadOnlyTarget returns true if the driver is configured in read-only mode.
+func IsReadOnlyTarget(driver BackupStoreDriver) bool {
+	_, ok := findDriver[*readOnlyDriver](driver)
+	return ok
+}
+
+func (d *readOnlyDriver) Unwrap() BackupStoreDriver {
+	return d.BackupStoreDriver
+}
+
+func (d *readOnlyDriver) Remove(path string) error {
+	return &ErrReadOnlyTarget{DestURL: d.GetURL(), Operation: "remove", Path: path}
+}
+
+func (d *readOnlyDriver) RemoveAll(paths []string) error {
+	errs := make(map[string]error, len(paths))
+	for _, path := range paths {
+		errs[path] = &ErrReadOnlyTarget{DestURL: d.GetURL(), Operation: "remove", Path: path}
+	}
+	if len(errs) > 0 {
+		return &ErrRemoveFailed{Errors: errs}
+	}
+	return nil
+}
+
+func (d *readOnlyDriver) Write(dst string, rs io.ReadSeeker) error {
+	return &ErrReadOnlyTarget{DestURL: d.GetURL(), Operation: "write", Path: dst}
+}
+
+func (d *readOnlyDriver) Upload(src, dst string) error {
+	return &ErrReadOnlyTarget{DestURL: d.GetURL(), Operation: "write", Path: dst}
+}
+
+func (d *readOnlyDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *ObjectMetadata) error {
+	return &ErrReadOnlyTarget{DestURL: d.GetURL(), Operation: "write", Path: dst}
+}
+
+func (d *readOnlyDriver) GetMetadata(filePath string) (*ObjectMetadata, error) {
+	return getMetadata(d.BackupStoreDriver, filePath)
+}
+
+func (d *readOnlyDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	return readRange(d.BackupStoreDriver, src, offset, length)
+}
+
+func (d *readOnlyDriver) Copy(src, dst string) error {
+	return &ErrReadOnlyTarget{DestURL: d.GetURL(), Operation: "write", Path: dst}
+}
+
+func (d *readOnlyDriver) ListDeletedObjects(path string) ([]DeletedObject, error) {
+	return listDeletedObjects(d.BackupStoreDriver, path)
+}
+
+func (d *readOnlyDriver) RestoreObjectVersion(filePath, versionID string) error {
+	return &ErrReadOnlyTarget{DestURL: d.GetURL(), Operation: "restore version", Path: filePath}
+}
+
+func (d *readOnlyDriver) Link(src, dst string) error {
+	return &ErrReadOnlyTarget{DestURL: d.GetURL(), Operation: "write", Path: dst}
+}
+
+func (d *readOnlyDriver) LinkCount(filePath string) (int, error) {
+	return linkCount(d.BackupStoreDriver, filePath)
+}
+
+func (d *readOnlyDriver) ExtendRetention(filePath string) error {
+	return &ErrReadOnlyTarget{DestURL: d.GetURL(), Operation: "extend retention", Path: filePath}
+}
@@ -0,0 +1,55 @@
+package backupstore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestReadOnlyTarget(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	util.SetClock(util.NewFakeClock(time.Now()))
+	defer util.SetClock(nil)
+
+	_, err := GetBackupStoreDriver(mockDriverURL + "?readOnly=maybe")
+	assert.Error(err)
+
+	readOnlyURL := mockDriverURL + "?readOnly=true"
+	driver, err := GetBackupStoreDriver(readOnlyURL)
+	assert.NoError(err)
+	assert.True(IsReadOnlyTarget(driver))
+
+	err = m.fs.MkdirAll(getVolumePath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1"}`), 0644)
+	assert.NoError(err)
+
+	err = driver.Write(getVolumeFilePath("pvc-1"), bytes.NewReader([]byte(`{}`)))
+	assert.True(IsReadOnlyTargetError(err))
+	err = driver.Remove(getVolumeFilePath("pvc-1"))
+	assert.True(IsReadOnlyTargetError(err))
+	assert.True(IsReadOnlyTargetError(CheckTargetMutable(driver, "delete volume pvc-1")))
+	err = DeleteBackupVolume("pvc-1", readOnlyURL)
+	assert.True(IsReadOnlyTargetError(err))
+
+	// The readers take the locks without storing them
+	lock, err := New(driver, "pvc-1", RESTORE_LOCK)
+	assert.NoError(err)
+	assert.NoError(lock.Lock())
+	assert.Empty(getLockNamesForVolume("pvc-1", driver))
+	assert.NoError(lock.Unlock())
+
+	volume, err := loadVolume(driver, "pvc-1")
+	assert.NoError(err)
+	assert.Equal("pvc-1", volume.Name)
+}
@@ -0,0 +1,166 @@
+package backupstore
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+// BackupCatalog is the metadata of the volumes and the completed backups of a backup target, registered
+// without reading any block, so the backups of a newly added target can be browsed before the data is moved
+// by the restores.
+type BackupCatalog struct {
+	URL          string
+	RegisteredAt string
+	Volumes      map[string]*CatalogVolume
+}
+
+// CatalogVolume is the metadata of a volume and its completed backups in the catalog. The backups which
+// cannot be loaded are skipped and reported in the messages of the volume.
+type CatalogVolume struct {
+	Volume  *VolumeInfo
+	Backups map[string]*CatalogBackup
+}
+
+// CatalogBackup is the metadata of a backup and its position in the backup chain of the volume.
+type CatalogBackup struct {
+	*BackupInfo
+	// PreviousBackupName is the backup the incremental backup follows, and BaseBackupName is the full backup
+	// the chain starts from. Both are empty for a full backup.
+	PreviousBackupName string `json:",omitempty"`
+	BaseBackupName     string `json:",omitempty"`
+	BlockCount         int64  `json:",string"`
+}
+
+// RegisterBackupTarget loads the configs of the volumes and the backups of the backup target into a catalog.
+// The backup configs are loaded concurrently, and the block files aren't read.
+func RegisterBackupTarget(destURL string) (*BackupCatalog, error) {
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	jobQueues := workerpool.New(runtime.NumCPU() * 16)
+	defer jobQueues.StopWait()
+
+	volumeNames, err := getVolumeNames(jobQueues, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &BackupCatalog{
+		URL:          driver.GetURL(),
+		RegisteredAt: util.Now(),
+		Volumes:      make(map[string]*CatalogVolume),
+	}
+	for _, volumeName := range volumeNames {
+		if !util.ValidateName(volumeName) || !volumeExists(driver, volumeName) {
+			continue
+		}
+		volume, err := registerVolume(jobQueues, driver, volumeName)
+		if err != nil {
+			return nil, err
+		}
+		catalog.Volumes[volumeName] = volume
+	}
+	return catalog, nil
+}
+
+func registerVolume(jobQueues *workerpool.WorkerPool, driver BackupStoreDriver, volumeName string) (*CatalogVolume, error) {
+	volume, err := loadVolume(driver, volumeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load volume %v", volumeName)
+	}
+	backupNames, err := getBackupNamesForVolume(driver, volumeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		lock    sync.Mutex
+		backups []*Backup
+		errs    []string
+		wg      sync.WaitGroup
+	)
+	for _, backupName := range backupNames {
+		backupName := backupName
+		wg.Add(1)
+		jobQueues.Submit(func() {
+			defer wg.Done()
+			backup, err := loadBackup(driver, backupName, volumeName)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("failed to load backup %v: %v", backupName, err))
+				return
+			}
+			if !isBackupInProgress(backup) {
+				backups = append(backups, backup)
+			}
+		})
+	}
+	wg.Wait()
+
+	result := &CatalogVolume{
+		Volume:  fillVolumeInfo(volume),
+		Backups: make(map[string]*CatalogBackup),
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		result.Volume.Messages[types.MessageTypeError] = strings.Join(errs, "\n")
+	}
+
+	// The incremental backups follow the previous backups, so the chains are rebuilt in the creation order
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].CreatedTime == backups[j].CreatedTime {
+			return backups[i].Name < backups[j].Name
+		}
+		return backups[i].CreatedTime < backups[j].CreatedTime
+	})
+	previous, base := "", ""
+	for _, backup := range backups {
+		entry := &CatalogBackup{
+			BackupInfo: fillBackupInfo(backup, driver.GetURL()),
+			BlockCount: int64(len(backup.Blocks)),
+		}
+		if backup.IsIncremental {
+			entry.PreviousBackupName = previous
+			entry.BaseBackupName = base
+		} else {
+			base = backup.Name
+		}
+		previous = backup.Name
+		result.Backups[backup.Name] = entry
+	}
+	return result, nil
+}
+
+// GetBackupChain returns the names of the backups from the full backup the chain starts from to the given
+// backup.
+func (c *BackupCatalog) GetBackupChain(volumeName, backupName string) ([]string, error) {
+	volume, exists := c.Volumes[volumeName]
+	if !exists {
+		return nil, fmt.Errorf("cannot find volume %v in the catalog of %v", volumeName, c.URL)
+	}
+	chain := []string{}
+	for name := backupName; name != ""; {
+		backup, exists := volume.Backups[name]
+		if !exists {
+			return nil, fmt.Errorf("cannot find backup %v of volume %v in the catalog of %v", name, volumeName, c.URL)
+		}
+		chain = append([]string{name}, chain...)
+		if !backup.IsIncremental {
+			return chain, nil
+		}
+		name = backup.PreviousBackupName
+	}
+	return nil, fmt.Errorf("cannot find the full backup of the chain of backup %v of volume %v", backupName, volumeName)
+}
@@ -0,0 +1,59 @@
+package backupstore
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+func TestRegisterBackupTarget(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	backups := map[string]string{
+		"backup-1": `{"Name":"backup-1","VolumeName":"pvc-1","CreatedTime":"2021-06-01T08:00:00Z","Blocks":[{"Offset":0,"BlockChecksum":"a"}]}`,
+		"backup-2": `{"Name":"backup-2","VolumeName":"pvc-1","CreatedTime":"2021-06-02T08:00:00Z","IsIncremental":true,"Blocks":[{"Offset":0,"BlockChecksum":"a"},{"Offset":2097152,"BlockChecksum":"b"}]}`,
+		"backup-3": `{"Name":"backup-3","VolumeName":"pvc-1","CreatedTime":"2021-06-03T08:00:00Z","IsIncremental":true}`,
+		"backup-4": `{"Name":"backup-4","VolumeName":"pvc-1","CreatedTime":"2021-06-04T08:00:00Z"}`,
+		"backup-5": `{"Name":"backup-5","VolumeName":"pvc-1"}`,
+		"backup-6": `invalid`,
+	}
+	assert.NoError(m.fs.MkdirAll(getBackupPath("pvc-1"), 0755))
+	assert.NoError(afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1","Size":"4096"}`), 0644))
+	for name, cfg := range backups {
+		assert.NoError(afero.WriteFile(m.fs, getBackupConfigPath(name, "pvc-1"), []byte(cfg), 0644))
+	}
+	assert.NoError(saveVolume(m, &Volume{Name: "data-1", Size: DEFAULT_BLOCK_SIZE}))
+
+	catalog, err := RegisterBackupTarget(mockDriverURL)
+	assert.NoError(err)
+	assert.Len(catalog.Volumes, 2)
+	assert.Empty(catalog.Volumes["data-1"].Backups)
+
+	// The in progress and the invalid backups are skipped
+	volume := catalog.Volumes["pvc-1"]
+	assert.Equal("pvc-1", volume.Volume.Name)
+	assert.Len(volume.Backups, 4)
+	assert.Contains(volume.Volume.Messages[types.MessageTypeError], "backup-6")
+	assert.Equal(int64(2), volume.Backups["backup-2"].BlockCount)
+	assert.Equal("backup-2", volume.Backups["backup-3"].PreviousBackupName)
+	assert.Equal("backup-1", volume.Backups["backup-3"].BaseBackupName)
+	assert.Empty(volume.Backups["backup-4"].BaseBackupName)
+
+	chain, err := catalog.GetBackupChain("pvc-1", "backup-3")
+	assert.NoError(err)
+	assert.Equal([]string{"backup-1", "backup-2", "backup-3"}, chain)
+	chain, err = catalog.GetBackupChain("pvc-1", "backup-4")
+	assert.NoError(err)
+	assert.Equal([]string{"backup-4"}, chain)
+	_, err = catalog.GetBackupChain("pvc-1", "backup-5")
+	assert.Error(err)
+	_, err = catalog.GetBackupChain("pvc-2", "backup-1")
+	assert.Error(err)
+}
@@ -0,0 +1,243 @@
+package backupstore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/longhorn/backupstore/metrics"
+	"github.com/longhorn/backupstore/util"
+
+	. "github.com/longhorn/backupstore/logging"
+)
+
+// ReplicationConflict describes a backup which exists in both targets with different content. The backup
+// in the secondary target is never overwritten.
+type ReplicationConflict struct {
+	BackupName string
+	VolumeName string
+}
+
+// ReplicationStatus is the result of the last replication pass.
+type ReplicationStatus struct {
+	LastSyncAt string
+	Replicated int
+	Failed     int
+	Pending    int
+	Conflicts  []ReplicationConflict
+	Error      string
+}
+
+// Replicator mirrors the new backups of the primary backup target to the secondary one. Each pass copies
+// the completed backups missing in the secondary target, transferring only the absent blocks.
+type Replicator struct {
+	sourceURL string
+	destURL   string
+	interval  time.Duration
+
+	lock   sync.RWMutex
+	status ReplicationStatus
+	// replicated caches the backups known to be in the secondary target, so they are not loaded again
+	replicated map[string]bool
+}
+
+// NewReplicator returns a replicator from the primary backup target to the secondary one, which runs a pass
+// every interval once started.
+func NewReplicator(sourceURL, destURL string, interval time.Duration) (*Replicator, error) {
+	if sourceURL == destURL {
+		return nil, fmt.Errorf("cannot replicate backup target %v to itself", sourceURL)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid replication interval %v", interval)
+	}
+	return &Replicator{
+		sourceURL:  sourceURL,
+		destURL:    destURL,
+		interval:   interval,
+		replicated: map[string]bool{},
+	}, nil
+}
+
+// Start runs the replication passes until the context is done.
+func (r *Replicator) Start(ctx context.Context) {
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if _, err := r.Sync(ctx); err != nil {
+			log.WithError(err).Warnf("Failed to replicate backup target %v to %v", r.sourceURL, r.destURL)
+		}
+	}, r.interval)
+}
+
+// Status returns the result of the last replication pass.
+func (r *Replicator) Status() ReplicationStatus {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	status := r.status
+	status.Conflicts = append([]ReplicationConflict{}, r.status.Conflicts...)
+	return status
+}
+
+// Sync runs a replication pass. The failure of a backup doesn't stop the pass, it's retried in the next one.
+func (r *Replicator) Sync(ctx context.Context) (*ReplicationStatus, error) {
+	status, err := r.sync(ctx)
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	r.lock.Lock()
+	r.status = *status
+	r.lock.Unlock()
+	return status, err
+}
+
+func (r *Replicator) sync(ctx context.Context) (*ReplicationStatus, error) {
+	status := &ReplicationStatus{}
+
+	src, err := GetBackupStoreDriver(r.sourceURL)
+	if err != nil {
+		return status, err
+	}
+	dst, err := GetBackupStoreDriver(r.destURL)
+	if err != nil {
+		return status, err
+	}
+	log := log.WithFields(logrus.Fields{
+		LogFieldDestURL: dst.GetURL(),
+		"sourceURL":     src.GetURL(),
+	})
+
+	if err := CheckTargetRedirect(dst); err != nil {
+		return status, err
+	}
+
+	jobQueues := workerpool.New(runtime.NumCPU() * 16)
+	volumeNames, err := getVolumeNames(jobQueues, src)
+	jobQueues.StopWait()
+	if err != nil {
+		return status, errors.Wrapf(err, "failed to list backup volumes of %v", src.GetURL())
+	}
+
+	for _, volumeName := range volumeNames {
+		if ctx.Err() != nil {
+			return status, ctx.Err()
+		}
+		if err := r.syncVolume(ctx, src, dst, volumeName, status); err != nil {
+			log.WithError(err).Warnf("Failed to replicate backup volume %v", volumeName)
+			status.Failed++
+		}
+	}
+
+	status.LastSyncAt = util.Now()
+	metrics.ReplicationLastSyncTime.WithLabelValues(src.GetURL(), dst.GetURL()).SetToCurrentTime()
+	log.Infof("Replicated %v backups with %v failed, %v pending and %v conflicts",
+		status.Replicated, status.Failed, status.Pending, len(status.Conflicts))
+	return status, nil
+}
+
+func (r *Replicator) syncVolume(ctx context.Context, src, dst BackupStoreDriver, volumeName string, status *ReplicationStatus) error {
+	log := log.WithFields(logrus.Fields{
+		LogFieldVolume:  volumeName,
+		LogFieldDestURL: dst.GetURL(),
+	})
+
+	backupNames, err := getBackupNamesForVolume(src, volumeName)
+	if err != nil {
+		return err
+	}
+
+	pending := []*Backup{}
+	for _, backupName := range backupNames {
+		key := volumeName + "/" + backupName
+		if r.isReplicated(key) {
+			continue
+		}
+		backup, err := loadBackup(src, backupName, volumeName)
+		if err != nil {
+			return err
+		}
+		// The in progress and the single file backups are not replicated
+		if isBackupInProgress(backup) || backup.SingleFile.FilePath != "" {
+			continue
+		}
+		if dst.FileExists(getBackupConfigPath(backupName, volumeName)) {
+			replica, err := loadBackup(dst, backupName, volumeName)
+			if err != nil {
+				return err
+			}
+			if !isSameBackup(backup, replica) {
+				log.Warnf("Backup %v exists in the secondary target with different content, skipping", backupName)
+				metrics.ReplicatedBackups.WithLabelValues(src.GetURL(), dst.GetURL(), metrics.ResultConflict).Inc()
+				status.Conflicts = append(status.Conflicts, ReplicationConflict{BackupName: backupName, VolumeName: volumeName})
+				continue
+			}
+			r.setReplicated(key)
+			continue
+		}
+		pending = append(pending, backup)
+	}
+
+	// The backups are replicated from the oldest one, so the last backup of the volume is updated in order
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].SnapshotCreatedAt < pending[j].SnapshotCreatedAt
+	})
+
+	lag := metrics.ReplicationLag.WithLabelValues(src.GetURL(), dst.GetURL(), volumeName)
+	for i, backup := range pending {
+		if ctx.Err() != nil {
+			status.Pending += len(pending) - i
+			setReplicationLag(lag, backup)
+			return ctx.Err()
+		}
+		backupURL := EncodeBackupURL(backup.Name, volumeName, r.sourceURL)
+		err := CopyBackup(backupURL, r.destURL)
+		metrics.ReplicatedBackups.WithLabelValues(src.GetURL(), dst.GetURL(), metrics.Result(err)).Inc()
+		if err != nil {
+			status.Pending += len(pending) - i
+			setReplicationLag(lag, backup)
+			return errors.Wrapf(err, "failed to replicate backup %v", backup.Name)
+		}
+		r.setReplicated(volumeName + "/" + backup.Name)
+		status.Replicated++
+	}
+	lag.Set(0)
+	return nil
+}
+
+func (r *Replicator) isReplicated(key string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.replicated[key]
+}
+
+func (r *Replicator) setReplicated(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.replicated[key] = true
+}
+
+func setReplicationLag(lag prometheus.Gauge, backup *Backup) {
+	createdAt, err := util.ParseTimestamp(backup.SnapshotCreatedAt)
+	if err != nil {
+		return
+	}
+	lag.Set(time.Since(createdAt).Seconds())
+}
+
+// isSameBackup checks if the backups are created from the same snapshot with the same blocks.
+func isSameBackup(a, b *Backup) bool {
+	return a.SnapshotName == b.SnapshotName &&
+		a.SnapshotCreatedAt == b.SnapshotCreatedAt &&
+		a.CompressionMethod == b.CompressionMethod &&
+		reflect.DeepEqual(a.Blocks, b.Blocks)
+}
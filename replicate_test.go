@@ -0,0 +1,75 @@
+package backupstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicatorSync(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	newDriverURL := "mock2://localhost"
+	dst := &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: newDriverURL}
+	err := RegisterDriver("mock2", func(destURL string) (BackupStoreDriver, error) {
+		return dst, nil
+	})
+	assert.NoError(err)
+	defer unregisterDriver("mock2") // nolint:errcheck
+
+	checksum := "0123456789abcdef"
+	err = m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1","Size":"4096"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","SnapshotName":"snap-1","SnapshotCreatedAt":"2021-06-07T08:00:00Z",`+
+			`"CreatedTime":"2021-06-07T08:00:00Z","Blocks":[{"Offset":0,"BlockChecksum":"`+checksum+`"}]}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-2", "pvc-1"),
+		[]byte(`{"Name":"backup-2","VolumeName":"pvc-1","SnapshotName":"snap-2","SnapshotCreatedAt":"2021-06-08T08:00:00Z",`+
+			`"CreatedTime":"2021-06-08T08:00:00Z"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-3", "pvc-1"),
+		[]byte(`{"Name":"backup-3","VolumeName":"pvc-1","SnapshotName":"snap-3"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBlockFilePath("pvc-1", checksum), []byte("data"), 0644)
+	assert.NoError(err)
+
+	// The backup of the same name but another snapshot in the secondary target is a conflict
+	err = afero.WriteFile(dst.fs, getBackupConfigPath("backup-2", "pvc-1"),
+		[]byte(`{"Name":"backup-2","VolumeName":"pvc-1","SnapshotName":"other","CreatedTime":"2021-06-08T08:00:00Z"}`), 0644)
+	assert.NoError(err)
+
+	_, err = NewReplicator(mockDriverURL, mockDriverURL, time.Minute)
+	assert.Error(err)
+	r, err := NewReplicator(mockDriverURL, newDriverURL, time.Minute)
+	assert.NoError(err)
+
+	status, err := r.Sync(context.Background())
+	assert.NoError(err)
+	assert.Equal(1, status.Replicated)
+	assert.Equal(0, status.Failed)
+	assert.Equal([]ReplicationConflict{{BackupName: "backup-2", VolumeName: "pvc-1"}}, status.Conflicts)
+	assert.NotEmpty(status.LastSyncAt)
+
+	data, err := afero.ReadFile(dst.fs, getBlockFilePath("pvc-1", checksum))
+	assert.NoError(err)
+	assert.Equal("data", string(data))
+	assert.False(dst.FileExists(getBackupConfigPath("backup-3", "pvc-1")))
+	backup, err := loadBackup(dst, "backup-2", "pvc-1")
+	assert.NoError(err)
+	assert.Equal("other", backup.SnapshotName)
+
+	status, err = r.Sync(context.Background())
+	assert.NoError(err)
+	assert.Equal(0, status.Replicated)
+	assert.Len(r.Status().Conflicts, 1)
+}
@@ -0,0 +1,100 @@
+package backupstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+var (
+	// RestoreAbortTimeout is the max time waiting for an aborted restore to stop
+	RestoreAbortTimeout = 2 * time.Minute
+
+	errRestoreAborted = errors.New("restore is aborted")
+
+	restoreOperationsLock sync.Mutex
+	restoreOperations     = map[string]*restoreOperation{}
+)
+
+// restoreOperation is an in-flight restore, which can be aborted by the volume device name.
+type restoreOperation struct {
+	cancel     context.CancelCauseFunc
+	done       chan struct{}
+	volDevPath string
+	regular    bool
+}
+
+// registerRestore registers the in-flight restore to the volume device, and returns the context canceled
+// once the restore is aborted.
+func registerRestore(ctx context.Context, volDevName, volDevPath string, regular bool) (context.Context, *restoreOperation, error) {
+	restoreOperationsLock.Lock()
+	defer restoreOperationsLock.Unlock()
+
+	if _, exists := restoreOperations[volDevName]; exists {
+		return nil, nil, fmt.Errorf("volume device %v is being restored", volDevName)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	op := &restoreOperation{
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		volDevPath: volDevPath,
+		regular:    regular,
+	}
+	restoreOperations[volDevName] = op
+	return ctx, op, nil
+}
+
+// finish removes the restore from the in-flight ones once it stops.
+func (op *restoreOperation) finish(volDevName string) {
+	restoreOperationsLock.Lock()
+	defer restoreOperationsLock.Unlock()
+
+	if restoreOperations[volDevName] == op {
+		delete(restoreOperations, volDevName)
+	}
+	op.cancel(nil)
+	close(op.done)
+}
+
+// getRestoreAbortError returns the error of the restore if it's aborted.
+func getRestoreAbortError(ctx context.Context, volumeName string) error {
+	if errors.Is(context.Cause(ctx), errRestoreAborted) {
+		return fmt.Errorf(types.ErrorMsgRestoreCancelled+" since restore is aborted for volume %v", volumeName)
+	}
+	return nil
+}
+
+// AbortRestore stops the in-flight restore to the volume device, and waits until the restore stops and its
+// status is updated. The partial output is truncated if it's a regular file and truncate is set, otherwise it's
+// preserved.
+func AbortRestore(volDevName string, truncate bool) error {
+	restoreOperationsLock.Lock()
+	op, exists := restoreOperations[volDevName]
+	restoreOperationsLock.Unlock()
+	if !exists {
+		return fmt.Errorf("cannot find in-flight restore to volume device %v", volDevName)
+	}
+
+	log.Infof("Aborting restore to volume device %v", volDevName)
+	op.cancel(errRestoreAborted)
+
+	select {
+	case <-op.done:
+	case <-time.After(RestoreAbortTimeout):
+		return fmt.Errorf("timeout waiting for aborted restore to volume device %v to stop", volDevName)
+	}
+
+	if truncate && op.regular {
+		if err := os.Truncate(op.volDevPath, 0); err != nil {
+			return errors.Wrapf(err, "failed to truncate partial output %v of aborted restore", op.volDevPath)
+		}
+		log.Infof("Truncated partial output %v of aborted restore", op.volDevPath)
+	}
+	return nil
+}
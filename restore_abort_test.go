@@ -0,0 +1,147 @@
+package backupstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+func TestAbortRestore(t *testing.T) {
+	assert := assert.New(t)
+
+	volDevPath := filepath.Join(t.TempDir(), "volume")
+	err := os.WriteFile(volDevPath, []byte("partial"), 0644)
+	assert.NoError(err)
+
+	assert.Error(AbortRestore(volDevPath, true))
+
+	ctx, op, err := registerRestore(context.Background(), volDevPath, volDevPath, true)
+	assert.NoError(err)
+	_, _, err = registerRestore(context.Background(), volDevPath, volDevPath, true)
+	assert.Error(err)
+
+	var restoreErr error
+	go func() {
+		defer op.finish(volDevPath)
+		<-ctx.Done()
+		restoreErr = getRestoreAbortError(ctx, "pvc-1")
+	}()
+
+	assert.NoError(AbortRestore(volDevPath, true))
+	assert.True(strings.HasPrefix(restoreErr.Error(), types.ErrorMsgRestoreCancelled))
+	data, err := os.ReadFile(volDevPath)
+	assert.NoError(err)
+	assert.Empty(data)
+
+	// The restore is cleaned up once stopped
+	assert.Error(AbortRestore(volDevPath, true))
+	ctx, op, err = registerRestore(context.Background(), volDevPath, volDevPath, true)
+	assert.NoError(err)
+	op.finish(volDevPath)
+	assert.NoError(getRestoreAbortError(ctx, "pvc-1"))
+}
+
+// fileRestoreOps restores to the regular file.
+type fileRestoreOps struct {
+	DeltaRestoreOperations
+	stopChan chan struct{}
+}
+
+func (f *fileRestoreOps) OpenVolumeDev(volDevName string) (*os.File, string, error) {
+	volDev, err := os.Create(volDevName)
+	return volDev, volDevName, err
+}
+
+func (f *fileRestoreOps) CloseVolumeDev(volDev *os.File) error {
+	return volDev.Close()
+}
+
+func (f *fileRestoreOps) UpdateRestoreStatus(snapshot string, restoreProgress int, err error) {}
+
+func (f *fileRestoreOps) GetStopChan() chan struct{} {
+	return f.stopChan
+}
+
+// slowReadDriver holds the reads of the blocks until they are released.
+type slowReadDriver struct {
+	*mockStoreDriver
+	reading     chan struct{}
+	readingOnce sync.Once
+	release     chan struct{}
+}
+
+func (s *slowReadDriver) Read(src string) (io.ReadCloser, error) {
+	if strings.HasSuffix(src, BLK_SUFFIX) {
+		s.readingOnce.Do(func() { close(s.reading) })
+		<-s.release
+	}
+	return s.mockStoreDriver.Read(src)
+}
+
+func TestAbortSlowRestore(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	var data []byte
+	for _, c := range []byte("abcd") {
+		data = append(data, bytes.Repeat([]byte{c}, DEFAULT_BLOCK_SIZE)...)
+	}
+	volume := &Volume{Name: "pvc-1", Size: int64(len(data)), CompressionMethod: "lz4"}
+	assert.NoError(saveVolume(m, volume))
+	_, _, err := performBackup(m, &DeltaBackupConfig{
+		Volume:   volume,
+		Snapshot: &Snapshot{Name: "snap-1", CreatedTime: "2021-06-07T08:00:00Z"},
+		DestURL:  mockDriverURL,
+		DeltaOps: &memorySnapshotOps{data: data},
+	}, &types.Mappings{
+		Mappings:  []types.Mapping{{Offset: 0, Size: int64(len(data))}},
+		BlockSize: DEFAULT_BLOCK_SIZE,
+	}, &Backup{
+		Name:              "backup-1",
+		VolumeName:        volume.Name,
+		CompressionMethod: volume.CompressionMethod,
+		Blocks:            []BlockMapping{},
+		ProcessingBlocks: &ProcessingBlocks{
+			blocks: map[string][]*BlockMapping{},
+		},
+	}, nil)
+	assert.NoError(err)
+
+	slow := &slowReadDriver{mockStoreDriver: m, reading: make(chan struct{}), release: make(chan struct{})}
+	unregisterDriver(mockDriverName) // nolint:errcheck
+	assert.NoError(RegisterDriver(mockDriverName, func(destURL string) (BackupStoreDriver, error) {
+		return slow, nil
+	}))
+
+	volDevPath := filepath.Join(t.TempDir(), "volume")
+	err = RestoreDeltaBlockBackup(context.Background(), &DeltaRestoreConfig{
+		BackupURL:       EncodeBackupURL("backup-1", volume.Name, mockDriverURL),
+		DeltaOps:        &fileRestoreOps{stopChan: make(chan struct{})},
+		Filename:        volDevPath,
+		DownloadWorkers: 2,
+	})
+	assert.NoError(err)
+
+	// The blocks being read when the restore is aborted are written after the abort is requested, and the
+	// abort waits for them before truncating the partial output
+	<-slow.reading
+	time.AfterFunc(10*time.Millisecond, func() { close(slow.release) })
+	assert.NoError(AbortRestore(volDevPath, true))
+	time.Sleep(50 * time.Millisecond)
+	restored, err := os.ReadFile(volDevPath)
+	assert.NoError(err)
+	assert.Empty(restored)
+}
@@ -0,0 +1,106 @@
+package backupstore
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gammazero/workerpool"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+// RestorePlan is the set of the blocks transferred by an incremental restore.
+type RestorePlan struct {
+	// Blocks are the blocks downloaded and written to the volume
+	Blocks []BlockMapping
+	// ZeroedOffsets are the offsets of the blocks removed since the last restored backup, which are zeroed
+	ZeroedOffsets []int64
+	// DownloadSize is the size of the data transferred from the backup target, the blocks of the same checksum
+	// are counted once for each offset since they're downloaded separately
+	DownloadSize int64
+	// WriteSize is the size of the data written to the volume, including the zeroed blocks
+	WriteSize int64
+}
+
+// EstimatedDuration returns the time spent on downloading the blocks at the given bandwidth in bytes per second.
+func (p *RestorePlan) EstimatedDuration(bytesPerSecond int64) time.Duration {
+	if bytesPerSecond <= 0 {
+		return 0
+	}
+	return time.Duration(float64(p.DownloadSize) / float64(bytesPerSecond) * float64(time.Second))
+}
+
+// PlanIncrementalRestore returns the blocks transferred by RestoreDeltaBlockBackupIncrementally from the last
+// restored backup to the target backup, without changing anything.
+func PlanIncrementalRestore(lastRestoredBackupName, targetBackupURL string) (*RestorePlan, error) {
+	bsDriver, err := GetBackupStoreDriver(targetBackupURL)
+	if err != nil {
+		return nil, err
+	}
+
+	backupName, volumeName, _, err := DecodeBackupURL(targetBackupURL)
+	if err != nil {
+		return nil, err
+	}
+	if !util.ValidateName(lastRestoredBackupName) {
+		return nil, fmt.Errorf("invalid parameter lastBackupName %v", lastRestoredBackupName)
+	}
+
+	lastBackup, err := loadBackup(bsDriver, lastRestoredBackupName, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	backup, err := loadBackup(bsDriver, backupName, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range []*Backup{lastBackup, backup} {
+		if isBackupInProgress(b) {
+			return nil, fmt.Errorf("backup %v of volume %v is still in progress", b.Name, volumeName)
+		}
+	}
+
+	plan := &RestorePlan{Blocks: []BlockMapping{}, ZeroedOffsets: []int64{}}
+	checksums := map[string]int64{}
+	diffBackupBlocks(lastBackup, backup, func(block *Block) {
+		plan.WriteSize += DEFAULT_BLOCK_SIZE
+		if block.isZeroBlock {
+			plan.ZeroedOffsets = append(plan.ZeroedOffsets, block.offset)
+			return
+		}
+		plan.Blocks = append(plan.Blocks, BlockMapping{Offset: block.offset, BlockChecksum: block.blockChecksum})
+		if block.inlineData == nil {
+			checksums[block.blockChecksum]++
+		}
+	})
+
+	// The embedded blocks come with the backup config, the others are sized by the block files
+	var (
+		lock    sync.Mutex
+		missing []string
+	)
+	jobQueues := workerpool.New(runtime.NumCPU() * 16)
+	for checksum, count := range checksums {
+		checksum, count := checksum, count
+		jobQueues.Submit(func() {
+			size := bsDriver.FileSize(findBlockFilePath(bsDriver, volumeName, checksum))
+			lock.Lock()
+			defer lock.Unlock()
+			if size < 0 {
+				missing = append(missing, checksum)
+				return
+			}
+			plan.DownloadSize += size * count
+		})
+	}
+	jobQueues.StopWait()
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("cannot find %v blocks of backup %v of volume %v: %v", len(missing), backupName, volumeName, missing)
+	}
+
+	log.Infof("Planned incremental restore from backup %v to %v of volume %v: %v blocks of %v bytes to download, %v blocks to zero",
+		lastRestoredBackupName, backupName, volumeName, len(plan.Blocks), plan.DownloadSize, len(plan.ZeroedOffsets))
+	return plan, nil
+}
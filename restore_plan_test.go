@@ -0,0 +1,54 @@
+package backupstore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanIncrementalRestore(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	volumeName := "pvc-1"
+	unchanged, changed, added := "0123456789abcdef", "fedcba9876543210", "00112233445566778"
+	for checksum, size := range map[string]int{unchanged: 10, changed: 20, added: 30} {
+		err := m.Write(getBlockFilePath(volumeName, checksum), bytes.NewReader(make([]byte, size)))
+		assert.NoError(err)
+	}
+	backups := map[string][]BlockMapping{
+		"backup-1": {
+			{Offset: 0, BlockChecksum: unchanged},
+			{Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: unchanged},
+			{Offset: 2 * DEFAULT_BLOCK_SIZE, BlockChecksum: unchanged},
+		},
+		"backup-2": {
+			{Offset: 0, BlockChecksum: unchanged},
+			{Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: changed},
+			{Offset: 3 * DEFAULT_BLOCK_SIZE, BlockChecksum: added},
+			{Offset: 4 * DEFAULT_BLOCK_SIZE, BlockChecksum: added},
+		},
+	}
+	for name, blocks := range backups {
+		err := saveBackup(m, &Backup{Name: name, VolumeName: volumeName, CreatedTime: "2024-01-01T00:00:00Z", Blocks: blocks})
+		assert.NoError(err)
+	}
+
+	plan, err := PlanIncrementalRestore("backup-1", EncodeBackupURL("backup-2", volumeName, mockDriverURL))
+	assert.NoError(err)
+	assert.Equal(backups["backup-2"][1:], plan.Blocks)
+	assert.Equal([]int64{2 * DEFAULT_BLOCK_SIZE}, plan.ZeroedOffsets)
+	assert.Equal(int64(20+30+30), plan.DownloadSize)
+	assert.Equal(int64(4*DEFAULT_BLOCK_SIZE), plan.WriteSize)
+	assert.Equal(2*time.Second, plan.EstimatedDuration(40))
+
+	err = m.Remove(getBlockFilePath(volumeName, added))
+	assert.NoError(err)
+	_, err = PlanIncrementalRestore("backup-1", EncodeBackupURL("backup-2", volumeName, mockDriverURL))
+	assert.Error(err)
+}
@@ -0,0 +1,70 @@
+package backupstore
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+
+	. "github.com/longhorn/backupstore/logging"
+)
+
+type blockSource struct {
+	driver BackupStoreDriver
+	weight int
+}
+
+// blockSources are the backup targets holding the blocks of a restoring backup, sorted by the tier weights.
+type blockSources []blockSource
+
+// getBlockSources returns the backup target of the backup and the mirrors in the restore config. The mirrors
+// which cannot be accessed are skipped, so the restore can still proceed with the others.
+func getBlockSources(bsDriver BackupStoreDriver, config *DeltaRestoreConfig) blockSources {
+	sources := blockSources{{driver: bsDriver, weight: config.TierWeights[bsDriver.GetURL()]}}
+	for _, mirrorURL := range config.MirrorURLs {
+		driver, err := GetBackupStoreDriver(mirrorURL)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to access mirror backup target %v, skipping", mirrorURL)
+			continue
+		}
+		weight, exists := config.TierWeights[mirrorURL]
+		if !exists {
+			weight = config.TierWeights[driver.GetURL()]
+		}
+		sources = append(sources, blockSource{driver: driver, weight: weight})
+	}
+	// The backup target of the backup is preferred among the ones of the same weight
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].weight < sources[j].weight
+	})
+	return sources
+}
+
+// restoreBlockToFile restores the block from the cheapest backup target, and falls back to the next one
+// if the block cannot be read or verified. ErrObjectArchived is returned if the block is archived by any of the
+// backup targets and cannot be read from the others.
+func (s blockSources) restoreBlockToFile(slots decompressionSlots, volumeName string, volDev *os.File, decompression string, blk BlockMapping) error {
+	var err, archivedErr error
+	for i, source := range s {
+		if err = restoreBlockToFile(source.driver, slots, volumeName, volDev, decompression, blk); err == nil {
+			return nil
+		}
+		if archivedErr == nil && IsObjectArchivedError(err) {
+			archivedErr = err
+		}
+		if i < len(s)-1 {
+			log.WithError(err).WithFields(logrus.Fields{
+				LogFieldVolume:  volumeName,
+				LogFieldDestURL: source.driver.GetURL(),
+			}).Warnf("Failed to restore block %v, falling back to backup target %v", blk.BlockChecksum, s[i+1].driver.GetURL())
+		}
+	}
+	if archivedErr != nil {
+		return archivedErr
+	}
+	if err == nil {
+		err = fmt.Errorf("no backup target to restore block %v", blk.BlockChecksum)
+	}
+	return err
+}
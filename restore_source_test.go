@@ -0,0 +1,70 @@
+package backupstore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestBlockSourcesFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	mirrorURL := "mock2://localhost"
+	mirror := &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: mirrorURL}
+	err := RegisterDriver("mock2", func(destURL string) (BackupStoreDriver, error) {
+		return mirror, nil
+	})
+	assert.NoError(err)
+	defer unregisterDriver("mock2") // nolint:errcheck
+
+	writeBlock := func(driver *mockStoreDriver, fill byte) string {
+		data := bytes.Repeat([]byte{fill}, DEFAULT_BLOCK_SIZE)
+		checksum := util.GetChecksum(data)
+		rs, err := util.CompressData("lz4", data)
+		assert.NoError(err)
+		compressed, err := io.ReadAll(rs)
+		assert.NoError(err)
+		err = afero.WriteFile(driver.fs, getBlockFilePath("pvc-1", checksum), compressed, 0644)
+		assert.NoError(err)
+		return checksum
+	}
+	mirrored := writeBlock(m, 'a')
+	assert.Equal(mirrored, writeBlock(mirror, 'a'))
+	// The block not replicated to the mirror yet is read from the backup target of the backup
+	lagging := writeBlock(m, 'b')
+
+	config := &DeltaRestoreConfig{
+		MirrorURLs:  []string{mirrorURL},
+		TierWeights: map[string]int{mockDriverURL: 10},
+	}
+	sources := getBlockSources(m, config)
+	assert.Len(sources, 2)
+	assert.Equal(mirrorURL, sources[0].driver.GetURL())
+
+	volDev, err := os.Create(filepath.Join(t.TempDir(), "volume"))
+	assert.NoError(err)
+	defer volDev.Close()
+
+	err = sources.restoreBlockToFile(nil, "pvc-1", volDev, "lz4", BlockMapping{Offset: 0, BlockChecksum: mirrored})
+	assert.NoError(err)
+	err = sources.restoreBlockToFile(nil, "pvc-1", volDev, "lz4", BlockMapping{Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: lagging})
+	assert.NoError(err)
+	err = sources.restoreBlockToFile(nil, "pvc-1", volDev, "lz4", BlockMapping{Offset: 0, BlockChecksum: "0123456789abcdef"})
+	assert.Error(err)
+
+	data, err := os.ReadFile(volDev.Name())
+	assert.NoError(err)
+	assert.Equal(bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE), data[:DEFAULT_BLOCK_SIZE])
+	assert.Equal(bytes.Repeat([]byte{'b'}, DEFAULT_BLOCK_SIZE), data[DEFAULT_BLOCK_SIZE:])
+}
@@ -0,0 +1,175 @@
+package backupstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+// RestoreStatusDirectory is the local directory persisting the status of the restores with operation IDs.
+var RestoreStatusDirectory = "/var/lib/longhorn-backupstore/restores"
+
+// RestoreStatus is the persisted status of a restore, which can be queried by the operation ID after
+// the process restarts.
+type RestoreStatus struct {
+	OperationID    string
+	BackupURL      string
+	LastBackupName string
+	Filename       string
+	State          types.ProgressState
+	Progress       int
+	Error          string
+	StartedAt      string
+	UpdatedAt      string
+}
+
+func getRestoreStatusFilePath(operationID string) string {
+	return filepath.Join(RestoreStatusDirectory, operationID+".json")
+}
+
+func saveRestoreStatus(status *RestoreStatus) error {
+	if err := os.MkdirAll(RestoreStatusDirectory, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	filePath := getRestoreStatusFilePath(status.OperationID)
+	tmpFilePath := filePath + ".tmp"
+	if err := os.WriteFile(tmpFilePath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilePath, filePath)
+}
+
+// GetRestoreStatus returns the persisted status of the restore. The in progress restore which is not running
+// in this process anymore is reported as interrupted, so the caller can restart it.
+func GetRestoreStatus(operationID string) (*RestoreStatus, error) {
+	data, err := os.ReadFile(getRestoreStatusFilePath(operationID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read status of restore %v", operationID)
+	}
+	status := &RestoreStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode status of restore %v", operationID)
+	}
+
+	if status.State == types.ProgressStateInProgress {
+		restoreOperationsLock.Lock()
+		_, running := restoreOperations[status.Filename]
+		restoreOperationsLock.Unlock()
+		if !running {
+			status.State = types.ProgressStateInterrupted
+		}
+	}
+	return status, nil
+}
+
+// ListRestoreStatuses returns the operation IDs of the persisted restore statuses.
+func ListRestoreStatuses() ([]string, error) {
+	entries, err := os.ReadDir(RestoreStatusDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && strings.HasSuffix(name, ".json") {
+			ids = append(ids, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	return ids, nil
+}
+
+// RemoveRestoreStatus removes the persisted status of the restore once the caller is done with it.
+func RemoveRestoreStatus(operationID string) error {
+	if err := os.Remove(getRestoreStatusFilePath(operationID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// restoreStatusRecorder wraps the DeltaOps of a restore and persists the status on each update.
+type restoreStatusRecorder struct {
+	DeltaRestoreOperations
+
+	lock   sync.Mutex
+	status *RestoreStatus
+}
+
+// recordRestoreStatus returns the restore config with the DeltaOps persisting the status, if the restore
+// has an operation ID.
+func recordRestoreStatus(config *DeltaRestoreConfig) (*DeltaRestoreConfig, error) {
+	if config.OperationID == "" {
+		return config, nil
+	}
+	if !util.ValidateName(config.OperationID) {
+		return nil, fmt.Errorf("invalid restore operation ID %v", config.OperationID)
+	}
+
+	now := util.Now()
+	recorder := &restoreStatusRecorder{
+		DeltaRestoreOperations: config.DeltaOps,
+		status: &RestoreStatus{
+			OperationID:    config.OperationID,
+			BackupURL:      config.BackupURL,
+			LastBackupName: config.LastBackupName,
+			Filename:       config.Filename,
+			State:          types.ProgressStateInProgress,
+			StartedAt:      now,
+			UpdatedAt:      now,
+		},
+	}
+	if err := saveRestoreStatus(recorder.status); err != nil {
+		return nil, errors.Wrapf(err, "failed to save status of restore %v", config.OperationID)
+	}
+
+	recorded := *config
+	recorded.DeltaOps = recorder
+	return &recorded, nil
+}
+
+func (r *restoreStatusRecorder) UpdateRestoreStatus(snapshot string, restoreProgress int, err error) {
+	r.update(restoreProgress, err)
+	r.DeltaRestoreOperations.UpdateRestoreStatus(snapshot, restoreProgress, err)
+}
+
+func (r *restoreStatusRecorder) update(restoreProgress int, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	state := types.ProgressStateInProgress
+	errMsg := ""
+	switch {
+	case err != nil && strings.Contains(err.Error(), types.ErrorMsgRestoreCancelled):
+		state, errMsg = types.ProgressStateCanceled, err.Error()
+	case err != nil:
+		state, errMsg = types.ProgressStateError, err.Error()
+	case restoreProgress == PROGRESS_PERCENTAGE_BACKUP_TOTAL:
+		state = types.ProgressStateComplete
+	}
+	// Each block restored reports the progress, only the changes are persisted
+	if r.status.State == state && r.status.Progress == restoreProgress && r.status.Error == errMsg {
+		return
+	}
+
+	r.status.State = state
+	r.status.Progress = restoreProgress
+	r.status.Error = errMsg
+	r.status.UpdatedAt = util.Now()
+	if err := saveRestoreStatus(r.status); err != nil {
+		log.WithError(err).Warnf("Failed to save status of restore %v", r.status.OperationID)
+	}
+}
@@ -0,0 +1,77 @@
+package backupstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+type mockRestoreOps struct {
+	DeltaRestoreOperations
+	progress int
+}
+
+func (m *mockRestoreOps) UpdateRestoreStatus(snapshot string, restoreProgress int, err error) {
+	m.progress = restoreProgress
+}
+
+func TestRestoreStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(dir string) { RestoreStatusDirectory = dir }(RestoreStatusDirectory)
+	RestoreStatusDirectory = t.TempDir()
+
+	ops := &mockRestoreOps{}
+	config := &DeltaRestoreConfig{
+		BackupURL: EncodeBackupURL("backup-1", "pvc-1", mockDriverURL),
+		DeltaOps:  ops,
+		Filename:  "volume.img",
+	}
+	recorded, err := recordRestoreStatus(config)
+	assert.NoError(err)
+	assert.Equal(config, recorded)
+
+	config.OperationID = "invalid/id"
+	_, err = recordRestoreStatus(config)
+	assert.Error(err)
+
+	config.OperationID = "restore-1"
+	_, op, err := registerRestore(context.Background(), config.Filename, config.Filename, true)
+	assert.NoError(err)
+	recorded, err = recordRestoreStatus(config)
+	assert.NoError(err)
+
+	status, err := GetRestoreStatus("restore-1")
+	assert.NoError(err)
+	assert.Equal(types.ProgressStateInProgress, status.State)
+	assert.Equal(config.BackupURL, status.BackupURL)
+
+	recorded.DeltaOps.UpdateRestoreStatus("volume.img", 50, nil)
+	assert.Equal(50, ops.progress)
+	status, err = GetRestoreStatus("restore-1")
+	assert.NoError(err)
+	assert.Equal(50, status.Progress)
+
+	// The restore is interrupted if it's not running anymore
+	op.finish(config.Filename)
+	status, err = GetRestoreStatus("restore-1")
+	assert.NoError(err)
+	assert.Equal(types.ProgressStateInterrupted, status.State)
+
+	recorded.DeltaOps.UpdateRestoreStatus("volume.img", 50, fmt.Errorf("failed to read block"))
+	status, err = GetRestoreStatus("restore-1")
+	assert.NoError(err)
+	assert.Equal(types.ProgressStateError, status.State)
+	assert.Equal("failed to read block", status.Error)
+
+	ids, err := ListRestoreStatuses()
+	assert.NoError(err)
+	assert.Equal([]string{"restore-1"}, ids)
+	assert.NoError(RemoveRestoreStatus("restore-1"))
+	_, err = GetRestoreStatus("restore-1")
+	assert.Error(err)
+}
@@ -0,0 +1,62 @@
+package backupstore
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+)
+
+const (
+	// RETENTION_EXTENSION_CONCURRENCY is the max number of the blocks whose retention is extended at the same
+	// time by the operations referencing the existing blocks, e.g. the synthetic full backups and the copies
+	RETENTION_EXTENSION_CONCURRENCY = 16
+)
+
+// extendRetention extends the retention of the object by the wrapped driver.
+func extendRetention(driver BackupStoreDriver, filePath string) error {
+	retainer, ok := findDriver[RetainingBackupStoreDriver](driver)
+	if !ok {
+		return fmt.Errorf("backup target %v doesn't support the retention", driver.GetURL())
+	}
+	return retainer.ExtendRetention(filePath)
+}
+
+// extendBlockRetention extends the retention of the block files of the backup. The blocks reused from the
+// earlier backups were written with the retention of the earlier backups, which would otherwise expire before
+// the one of the backup and let the blocks still referenced by the backup be removed.
+func extendBlockRetention(driver BackupStoreDriver, backup *Backup, concurrency int) error {
+	retainer, ok := findBackendDriver[RetainingBackupStoreDriver](driver)
+	if !ok {
+		return nil
+	}
+
+	checksums := map[string]struct{}{}
+	for _, block := range backup.Blocks {
+		// The embedded blocks are stored in the backup config
+		if _, inlined := backup.InlineBlocks[block.BlockChecksum]; inlined {
+			continue
+		}
+		checksums[block.BlockChecksum] = struct{}{}
+	}
+
+	var (
+		extendErr error
+		lock      sync.Mutex
+	)
+	jobQueues := workerpool.New(concurrency)
+	for checksum := range checksums {
+		checksum := checksum
+		jobQueues.Submit(func() {
+			blkFile := findBlockFilePath(driver, backup.VolumeName, checksum)
+			if err := retainer.ExtendRetention(blkFile); err != nil {
+				lock.Lock()
+				defer lock.Unlock()
+				extendErr = errors.Wrapf(err, "failed to extend retention of block %v", blkFile)
+			}
+		})
+	}
+	jobQueues.StopWait()
+	return extendErr
+}
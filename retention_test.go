@@ -0,0 +1,109 @@
+package backupstore
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// retainingMockDriver records the objects whose retention is extended.
+type retainingMockDriver struct {
+	*mockStoreDriver
+	lock     sync.Mutex
+	extended []string
+}
+
+func (r *retainingMockDriver) ExtendRetention(filePath string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.extended = append(r.extended, filePath)
+	return nil
+}
+
+func TestExtendBlockRetention(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	backup := &Backup{
+		Name:       "backup-1",
+		VolumeName: "pvc-1",
+		Blocks: []BlockMapping{
+			{Offset: 0, BlockChecksum: "0000"},
+			{Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: "1111"},
+			{Offset: 2 * DEFAULT_BLOCK_SIZE, BlockChecksum: "0000"},
+			{Offset: 3 * DEFAULT_BLOCK_SIZE, BlockChecksum: "2222"},
+		},
+		InlineBlocks: map[string][]byte{"2222": []byte("data")},
+	}
+
+	// The backup target not retaining the objects is skipped
+	driver, err := GetBackupStoreDriver(mockDriverURL)
+	assert.NoError(err)
+	assert.NoError(extendBlockRetention(driver, backup, 2))
+
+	r := &retainingMockDriver{mockStoreDriver: m}
+	unregisterDriver(mockDriverName) // nolint:errcheck
+	err = RegisterDriver(mockDriverName, func(destURL string) (BackupStoreDriver, error) {
+		return r, nil
+	})
+	assert.NoError(err)
+	driver, err = GetBackupStoreDriver(mockDriverURL)
+	assert.NoError(err)
+
+	// The blocks are extended once each, and the embedded blocks are skipped
+	assert.NoError(extendBlockRetention(driver, backup, 2))
+	assert.ElementsMatch([]string{getBlockFilePath("pvc-1", "0000"), getBlockFilePath("pvc-1", "1111")}, r.extended)
+
+	driver, err = GetBackupStoreDriver(mockDriverURL + "?" + ReadOnlyTargetOption + "=true")
+	assert.NoError(err)
+	assert.True(IsReadOnlyTargetError(extendBlockRetention(driver, backup, 2)))
+}
+
+func TestExtendReferencedBlockRetention(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+	r := &retainingMockDriver{mockStoreDriver: m}
+	unregisterDriver(mockDriverName) // nolint:errcheck
+	assert.NoError(RegisterDriver(mockDriverName, func(destURL string) (BackupStoreDriver, error) {
+		return r, nil
+	}))
+
+	newDriverURL := "mock2://localhost"
+	dst := &retainingMockDriver{mockStoreDriver: &mockStoreDriver{fs: afero.NewMemMapFs(), destURL: newDriverURL}}
+	assert.NoError(RegisterDriver("mock2", func(destURL string) (BackupStoreDriver, error) {
+		return dst, nil
+	}))
+	defer unregisterDriver("mock2") // nolint:errcheck
+
+	shared, absent := "0123456789abcdef", "fedcba9876543210"
+	assert.NoError(m.fs.MkdirAll(getBackupPath("pvc-1"), 0755))
+	assert.NoError(afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"),
+		[]byte(`{"Name":"pvc-1","Size":"4096","LastBackupName":"backup-1"}`), 0644))
+	assert.NoError(afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","SnapshotCreatedAt":"2021-06-07T08:00:00Z",`+
+			`"CreatedTime":"2021-06-07T08:00:00Z",`+
+			`"Blocks":[{"Offset":0,"BlockChecksum":"`+shared+`"},{"Offset":2097152,"BlockChecksum":"`+absent+`"}]}`), 0644))
+	for _, checksum := range []string{shared, absent} {
+		assert.NoError(afero.WriteFile(m.fs, getBlockFilePath("pvc-1", checksum), []byte(checksum), 0644))
+	}
+
+	// The synthetic full backup extends the retention of the blocks it references
+	backupURL := EncodeBackupURL("backup-1", "pvc-1", mockDriverURL)
+	_, err := CreateSyntheticFullBackup(backupURL, "backup-full")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{getBlockFilePath("pvc-1", shared), getBlockFilePath("pvc-1", absent)}, r.extended)
+
+	// The copy extends the retention of the blocks already in the destination as well
+	assert.NoError(afero.WriteFile(dst.fs, getBlockFilePath("pvc-1", shared), []byte(shared), 0644))
+	assert.NoError(CopyBackup(backupURL, newDriverURL))
+	assert.Contains(dst.extended, getBlockFilePath("pvc-1", shared))
+	assert.Contains(dst.extended, getBlockFilePath("pvc-1", absent))
+}
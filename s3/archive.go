@@ -0,0 +1,123 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+const (
+	// The backup target URL query parameters of the restores of the archived objects, e.g.
+	// s3://bucket@us-east-1/path/?glacierRestoreDays=3&glacierRestoreTier=Bulk. The restored copy is kept for
+	// the days, which should cover the restore of the backup.
+	GlacierRestoreDaysOption = "glacierRestoreDays"
+	GlacierRestoreTierOption = "glacierRestoreTier"
+
+	DefaultGlacierRestoreDays = 7
+	DefaultGlacierRestoreTier = s3.TierStandard
+
+	errCodeRestoreAlreadyInProgress = "RestoreAlreadyInProgress"
+)
+
+// restoreOptions are the options of the restores of the archived objects.
+type restoreOptions struct {
+	days int64
+	tier string
+}
+
+func parseRestoreOptions(values url.Values) (*restoreOptions, error) {
+	opts := &restoreOptions{
+		days: DefaultGlacierRestoreDays,
+		tier: DefaultGlacierRestoreTier,
+	}
+	if value := values.Get(GlacierRestoreDaysOption); value != "" {
+		days, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || days < 1 {
+			return nil, fmt.Errorf("invalid %v option %v in backup target URL, must be a positive integer",
+				GlacierRestoreDaysOption, value)
+		}
+		opts.days = days
+	}
+	if value := values.Get(GlacierRestoreTierOption); value != "" {
+		supported := false
+		for _, tier := range s3.Tier_Values() {
+			if value == tier {
+				supported = true
+			}
+		}
+		if !supported {
+			return nil, fmt.Errorf("invalid %v option %v in backup target URL, must be one of %v",
+				GlacierRestoreTierOption, value, strings.Join(s3.Tier_Values(), ", "))
+		}
+		opts.tier = value
+	}
+	return opts, nil
+}
+
+// archivedObjectError is returned by the reads of the objects in an archival storage class.
+type archivedObjectError struct {
+	key string
+}
+
+func (e *archivedObjectError) Error() string {
+	return fmt.Sprintf("object %v is archived", e.key)
+}
+
+func isInvalidObjectStateError(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeInvalidObjectState
+}
+
+// ThawObject requests the restore of the archived object unless it's already requested, and returns true if the
+// object can be read, i.e. it isn't archived or its restored copy is available.
+func (s *service) ThawObject(key string) (bool, error) {
+	head, err := s.HeadObject(key)
+	if err != nil {
+		return false, err
+	}
+
+	// The objects in the archive tiers of Intelligent-Tiering have the archive status instead of the storage
+	// class, and are restored without the days
+	storageClass := aws.StringValue(head.StorageClass)
+	inArchiveTier := head.ArchiveStatus != nil
+	if storageClass != s3.StorageClassGlacier && storageClass != s3.StorageClassDeepArchive && !inArchiveTier {
+		return true, nil
+	}
+	restore := aws.StringValue(head.Restore)
+	if strings.Contains(restore, `ongoing-request="false"`) {
+		return true, nil
+	}
+	if strings.Contains(restore, `ongoing-request="true"`) {
+		return false, nil
+	}
+
+	request := &s3.RestoreRequest{
+		GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(s.restore.tier)},
+	}
+	if !inArchiveTier {
+		request.Days = aws.Int64(s.restore.days)
+	}
+	err = s.do("RestoreObject", func(svc *s3.S3) error {
+		_, err := svc.RestoreObject(&s3.RestoreObjectInput{
+			Bucket:         aws.String(s.Bucket),
+			Key:            aws.String(key),
+			RestoreRequest: request,
+		})
+		return err
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == errCodeRestoreAlreadyInProgress {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to restore archived object: %v error: %v", key, parseAwsError(err))
+	}
+	log.Infof("Requested restore of archived object %v in %v tier", key, s.restore.tier)
+	return false, nil
+}
@@ -0,0 +1,146 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+const (
+	// The backup target URL query parameters selecting the source of the AWS credentials instead of the static
+	// keys, e.g. s3://bucket@us-east-1/path/?awsCredentialSource=instanceProfile&awsRoleARN=arn:aws:iam::...
+	// The role ARN and the token file of the web identity default to AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE
+	// set by EKS IRSA, and the role in awsRoleARN is assumed by the other sources.
+	AWSCredentialSourceOption = "awsCredentialSource"
+	AWSRoleARNOption          = "awsRoleARN"
+	AWSExternalIDOption       = "awsExternalID"
+	AWSRoleSessionNameOption  = "awsRoleSessionName"
+
+	// CredentialSourceStatic uses AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY only
+	CredentialSourceStatic = "static"
+	// CredentialSourceInstanceProfile uses the role of the EC2 instance profile
+	CredentialSourceInstanceProfile = "instanceProfile"
+	// CredentialSourceWebIdentity uses the web identity token, e.g. the service account token of EKS IRSA
+	CredentialSourceWebIdentity = "webIdentity"
+	// CredentialSourceDefault uses the default credential chain of the AWS SDK
+	CredentialSourceDefault = "default"
+
+	defaultRoleSessionName = "backupstore"
+)
+
+// credentialOptions are the options of the AWS credentials in the backup target URL.
+type credentialOptions struct {
+	source          string
+	roleARN         string
+	externalID      string
+	roleSessionName string
+}
+
+func parseCredentialOptions(values url.Values) (*credentialOptions, error) {
+	opts := &credentialOptions{
+		source:          values.Get(AWSCredentialSourceOption),
+		roleARN:         values.Get(AWSRoleARNOption),
+		externalID:      values.Get(AWSExternalIDOption),
+		roleSessionName: values.Get(AWSRoleSessionNameOption),
+	}
+	switch opts.source {
+	case "", CredentialSourceStatic, CredentialSourceInstanceProfile, CredentialSourceWebIdentity, CredentialSourceDefault:
+	default:
+		return nil, fmt.Errorf("invalid %v option %v in backup target URL, must be %v, %v, %v or %v",
+			AWSCredentialSourceOption, opts.source, CredentialSourceStatic, CredentialSourceInstanceProfile,
+			CredentialSourceWebIdentity, CredentialSourceDefault)
+	}
+	if opts.externalID != "" && opts.roleARN == "" {
+		return nil, fmt.Errorf("%v requires %v in backup target URL", AWSExternalIDOption, AWSRoleARNOption)
+	}
+	// The external ID isn't accepted by the web identity, which assumes the role by the token
+	if opts.externalID != "" && opts.source == CredentialSourceWebIdentity {
+		return nil, fmt.Errorf("%v isn't supported by %v credential source", AWSExternalIDOption, CredentialSourceWebIdentity)
+	}
+	if opts.roleSessionName == "" {
+		opts.roleSessionName = defaultRoleSessionName
+	}
+	return opts, nil
+}
+
+// newCredentials returns the credentials of the backup target, or nil for the default credential chain. The
+// credentials are shared by the clients of all the endpoints, so the temporary credentials are only refreshed
+// once they expire instead of for each client.
+func (s *service) newCredentials(opts *credentialOptions) (*credentials.Credentials, error) {
+	// The STS and the instance metadata requests don't go to the S3 endpoints
+	config := &aws.Config{Region: &s.Region, MaxRetries: aws.Int(3)}
+	if s.Client != nil {
+		config.HTTPClient = s.Client
+	}
+
+	var base *credentials.Credentials
+	accessKey, secretKey := s.getenv(types.AWSAccessKey), s.getenv(types.AWSSecretKey)
+	switch opts.source {
+	case "":
+		// The static keys of the backup target take precedence over the default credential chain, which
+		// reads the process-wide environment variables
+		if accessKey != "" && secretKey != "" {
+			base = credentials.NewStaticCredentials(accessKey, secretKey, "")
+		}
+	case CredentialSourceStatic:
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("missing %v or %v for %v credential source", types.AWSAccessKey,
+				types.AWSSecretKey, CredentialSourceStatic)
+		}
+		base = credentials.NewStaticCredentials(accessKey, secretKey, "")
+	case CredentialSourceInstanceProfile:
+		ses, err := session.NewSession(config)
+		if err != nil {
+			return nil, err
+		}
+		base = ec2rolecreds.NewCredentials(ses)
+	case CredentialSourceWebIdentity:
+		roleARN := opts.roleARN
+		if roleARN == "" {
+			roleARN = s.getPlatformEnv(types.AWSRoleARN)
+		}
+		tokenFile := s.getPlatformEnv(types.AWSWebIdentityTokenFile)
+		if roleARN == "" || tokenFile == "" {
+			return nil, fmt.Errorf("missing role ARN or %v for %v credential source", types.AWSWebIdentityTokenFile,
+				CredentialSourceWebIdentity)
+		}
+		ses, err := session.NewSession(config)
+		if err != nil {
+			return nil, err
+		}
+		return stscreds.NewWebIdentityCredentials(ses, roleARN, opts.roleSessionName, tokenFile), nil
+	}
+
+	if opts.roleARN == "" {
+		return base, nil
+	}
+	// The role is assumed with the credentials of the source, e.g. the instance profile of the node
+	config.Credentials = base
+	ses, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	return stscreds.NewCredentials(ses, opts.roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = opts.roleSessionName
+		if opts.externalID != "" {
+			p.ExternalID = aws.String(opts.externalID)
+		}
+	}), nil
+}
+
+// getPlatformEnv returns the value in the credential of the backup target, or the environment variable injected
+// by the platform, e.g. by EKS IRSA into the pod, if the credential doesn't set it.
+func (s *service) getPlatformEnv(key string) string {
+	if value := s.getenv(key); value != "" {
+		return value
+	}
+	return os.Getenv(key)
+}
@@ -0,0 +1,44 @@
+package s3
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCredentialOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		expected *credentialOptions
+		errMsg   string
+	}{
+		{"default", "", &credentialOptions{roleSessionName: defaultRoleSessionName}, ""},
+		{"instance profile assuming role", "awsCredentialSource=instanceProfile&awsRoleARN=arn:aws:iam::1:role/r&awsExternalID=id",
+			&credentialOptions{source: CredentialSourceInstanceProfile, roleARN: "arn:aws:iam::1:role/r",
+				externalID: "id", roleSessionName: defaultRoleSessionName}, ""},
+		{"web identity with session name", "awsCredentialSource=webIdentity&awsRoleSessionName=restore",
+			&credentialOptions{source: CredentialSourceWebIdentity, roleSessionName: "restore"}, ""},
+		{"static", "awsCredentialSource=static",
+			&credentialOptions{source: CredentialSourceStatic, roleSessionName: defaultRoleSessionName}, ""},
+		{"default chain", "awsCredentialSource=default",
+			&credentialOptions{source: CredentialSourceDefault, roleSessionName: defaultRoleSessionName}, ""},
+		{"invalid source", "awsCredentialSource=vault", nil, "invalid awsCredentialSource option vault"},
+		{"external ID without role", "awsExternalID=id", nil, "requires awsRoleARN"},
+		{"external ID of web identity", "awsCredentialSource=webIdentity&awsRoleARN=arn:aws:iam::1:role/r&awsExternalID=id",
+			nil, "isn't supported by webIdentity"},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err, tc.name)
+		opts, err := parseCredentialOptions(values)
+		if tc.errMsg != "" {
+			assert.ErrorContains(err, tc.errMsg, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, opts, tc.name)
+	}
+}
@@ -0,0 +1,155 @@
+package s3
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+const (
+	// The backup target URL query parameters requesting the server-side encryption of the objects, e.g.
+	// s3://bucket@us-east-1/path/?sse=aws:kms&sseKMSKeyId=arn:aws:kms:... The KMS key ID can also be set by
+	// AWS_SSE_KMS_KEY_ID, and the SSE-C key is only set by AWS_SSE_CUSTOMER_KEY in the credential, since it's
+	// the secret of the data.
+	SSEOption          = "sse"
+	SSEKMSKeyIDOption  = "sseKMSKeyId"
+	SSEAlgorithmAES256 = "AES256"
+	SSEAlgorithmKMS    = "aws:kms"
+	// SSEAlgorithmCustomer encrypts the objects by the customer provided key (SSE-C), which must be sent with
+	// each read of the objects as well
+	SSEAlgorithmCustomer = "customer"
+
+	sseCustomerKeySize = 32
+)
+
+// encryptionOptions are the server-side encryption options of the objects.
+type encryptionOptions struct {
+	algorithm string
+	kmsKeyID  string
+	// customerKey is the raw SSE-C key, the SDK encodes it and computes the MD5 of the headers
+	customerKey string
+}
+
+func (s *service) parseEncryptionOptions(values url.Values) (*encryptionOptions, error) {
+	opts := &encryptionOptions{
+		algorithm: values.Get(SSEOption),
+		kmsKeyID:  values.Get(SSEKMSKeyIDOption),
+	}
+	if opts.kmsKeyID == "" {
+		opts.kmsKeyID = s.getenv(types.AWSSSEKMSKeyID)
+	}
+	encodedKey := s.getenv(types.AWSSSECustomerKey)
+
+	switch opts.algorithm {
+	case "", SSEAlgorithmAES256, SSEAlgorithmKMS, SSEAlgorithmCustomer:
+	default:
+		return nil, fmt.Errorf("invalid %v option %v in backup target URL, must be %v, %v or %v", SSEOption,
+			opts.algorithm, SSEAlgorithmAES256, SSEAlgorithmKMS, SSEAlgorithmCustomer)
+	}
+	if opts.kmsKeyID != "" && opts.algorithm != SSEAlgorithmKMS {
+		return nil, fmt.Errorf("KMS key ID requires %v=%v in backup target URL", SSEOption, SSEAlgorithmKMS)
+	}
+	if encodedKey != "" && opts.algorithm != SSEAlgorithmCustomer {
+		return nil, fmt.Errorf("%v requires %v=%v in backup target URL", types.AWSSSECustomerKey, SSEOption,
+			SSEAlgorithmCustomer)
+	}
+
+	if opts.algorithm == SSEAlgorithmCustomer {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v, must be base64 encoded: %v", types.AWSSSECustomerKey, err)
+		}
+		if len(key) != sseCustomerKeySize {
+			return nil, fmt.Errorf("invalid %v, must be a %v bytes key", types.AWSSSECustomerKey, sseCustomerKeySize)
+		}
+		opts.customerKey = string(key)
+	}
+	return opts, nil
+}
+
+func (o *encryptionOptions) applyToPut(params *s3.PutObjectInput) {
+	if o == nil {
+		return
+	}
+	switch o.algorithm {
+	case SSEAlgorithmAES256, SSEAlgorithmKMS:
+		params.ServerSideEncryption = aws.String(o.algorithm)
+		if o.kmsKeyID != "" {
+			params.SSEKMSKeyId = aws.String(o.kmsKeyID)
+		}
+	case SSEAlgorithmCustomer:
+		params.SSECustomerAlgorithm = aws.String(SSEAlgorithmAES256)
+		params.SSECustomerKey = aws.String(o.customerKey)
+	}
+}
+
+func (o *encryptionOptions) applyToGet(params *s3.GetObjectInput) {
+	if o == nil {
+		return
+	}
+	if o.algorithm == SSEAlgorithmCustomer {
+		params.SSECustomerAlgorithm = aws.String(SSEAlgorithmAES256)
+		params.SSECustomerKey = aws.String(o.customerKey)
+	}
+}
+
+func (o *encryptionOptions) applyToHead(params *s3.HeadObjectInput) {
+	if o == nil {
+		return
+	}
+	if o.algorithm == SSEAlgorithmCustomer {
+		params.SSECustomerAlgorithm = aws.String(SSEAlgorithmAES256)
+		params.SSECustomerKey = aws.String(o.customerKey)
+	}
+}
+
+// applyToCopy encrypts the copy, the source of the same bucket is encrypted by the same options.
+func (o *encryptionOptions) applyToCopy(params *s3.CopyObjectInput) {
+	if o == nil {
+		return
+	}
+	switch o.algorithm {
+	case SSEAlgorithmAES256, SSEAlgorithmKMS:
+		params.ServerSideEncryption = aws.String(o.algorithm)
+		if o.kmsKeyID != "" {
+			params.SSEKMSKeyId = aws.String(o.kmsKeyID)
+		}
+	case SSEAlgorithmCustomer:
+		params.SSECustomerAlgorithm = aws.String(SSEAlgorithmAES256)
+		params.SSECustomerKey = aws.String(o.customerKey)
+		params.CopySourceSSECustomerAlgorithm = aws.String(SSEAlgorithmAES256)
+		params.CopySourceSSECustomerKey = aws.String(o.customerKey)
+	}
+}
+
+func (o *encryptionOptions) applyToCreateMultipartUpload(params *s3.CreateMultipartUploadInput) {
+	if o == nil {
+		return
+	}
+	switch o.algorithm {
+	case SSEAlgorithmAES256, SSEAlgorithmKMS:
+		params.ServerSideEncryption = aws.String(o.algorithm)
+		if o.kmsKeyID != "" {
+			params.SSEKMSKeyId = aws.String(o.kmsKeyID)
+		}
+	case SSEAlgorithmCustomer:
+		params.SSECustomerAlgorithm = aws.String(SSEAlgorithmAES256)
+		params.SSECustomerKey = aws.String(o.customerKey)
+	}
+}
+
+// applyToUploadPart sends the SSE-C key with each part, the other algorithms are set by the upload.
+func (o *encryptionOptions) applyToUploadPart(params *s3.UploadPartInput) {
+	if o == nil {
+		return
+	}
+	if o.algorithm == SSEAlgorithmCustomer {
+		params.SSECustomerAlgorithm = aws.String(SSEAlgorithmAES256)
+		params.SSECustomerKey = aws.String(o.customerKey)
+	}
+}
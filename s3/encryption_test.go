@@ -0,0 +1,67 @@
+package s3
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+)
+
+func TestParseEncryptionOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	customerKey := strings.Repeat("k", sseCustomerKeySize)
+	encodedKey := base64.StdEncoding.EncodeToString([]byte(customerKey))
+	for _, tc := range []struct {
+		name       string
+		query      string
+		credential map[string]string
+		expected   *encryptionOptions
+		errMsg     string
+	}{
+		{"no encryption", "", nil, &encryptionOptions{}, ""},
+		{"SSE-S3", "sse=AES256", nil, &encryptionOptions{algorithm: SSEAlgorithmAES256}, ""},
+		{"SSE-KMS with the default key", "sse=aws:kms", nil, &encryptionOptions{algorithm: SSEAlgorithmKMS}, ""},
+		{"SSE-KMS with the key in URL", "sse=aws:kms&sseKMSKeyId=key", nil,
+			&encryptionOptions{algorithm: SSEAlgorithmKMS, kmsKeyID: "key"}, ""},
+		{"SSE-KMS with the key in credential", "sse=aws:kms", map[string]string{types.AWSSSEKMSKeyID: "key"},
+			&encryptionOptions{algorithm: SSEAlgorithmKMS, kmsKeyID: "key"}, ""},
+		{"SSE-KMS key in URL takes precedence", "sse=aws:kms&sseKMSKeyId=url",
+			map[string]string{types.AWSSSEKMSKeyID: "credential"},
+			&encryptionOptions{algorithm: SSEAlgorithmKMS, kmsKeyID: "url"}, ""},
+		{"SSE-C", "sse=customer", map[string]string{types.AWSSSECustomerKey: encodedKey},
+			&encryptionOptions{algorithm: SSEAlgorithmCustomer, customerKey: customerKey}, ""},
+		{"invalid algorithm", "sse=aws:kms:dsse", nil, nil, "invalid sse option aws:kms:dsse"},
+		{"KMS key without SSE-KMS", "sse=AES256&sseKMSKeyId=key", nil, nil, "KMS key ID requires sse=aws:kms"},
+		{"KMS key in credential without SSE-KMS", "", map[string]string{types.AWSSSEKMSKeyID: "key"}, nil,
+			"KMS key ID requires sse=aws:kms"},
+		{"SSE-C key without SSE-C", "sse=AES256", map[string]string{types.AWSSSECustomerKey: encodedKey}, nil,
+			"requires sse=customer"},
+		{"SSE-C without key", "sse=customer", nil, nil, "must be a 32 bytes key"},
+		{"SSE-C key not encoded", "sse=customer", map[string]string{types.AWSSSECustomerKey: customerKey + "!"}, nil,
+			"must be base64 encoded"},
+		{"SSE-C key too short", "sse=customer",
+			map[string]string{types.AWSSSECustomerKey: base64.StdEncoding.EncodeToString([]byte("short"))}, nil,
+			"must be a 32 bytes key"},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err, tc.name)
+		credential := tc.credential
+		if credential == nil {
+			credential = map[string]string{}
+		}
+		s := &service{getenv: backupstore.TargetEnv(credential)}
+		opts, err := s.parseEncryptionOptions(values)
+		if tc.errMsg != "" {
+			assert.ErrorContains(err, tc.errMsg, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, opts, tc.name)
+	}
+}
@@ -0,0 +1,101 @@
+package s3
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/metrics"
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// The endpoints failing with the connection or gateway errors are skipped for the backoff, which is doubled
+	// by each consecutive failure. The endpoint is tried again by the requests after the backoff, and it's
+	// healthy again once a request succeeds.
+	minEndpointBackoff = 5 * time.Second
+	maxEndpointBackoff = 5 * time.Minute
+)
+
+var (
+	endpointHealthLock sync.Mutex
+	// endpointHealth is the health of the endpoints shared by the drivers, since the drivers are created by
+	// each operation
+	endpointHealth = map[string]*endpointState{}
+)
+
+type endpointState struct {
+	failures       int
+	unhealthyUntil time.Time
+}
+
+// isEndpointHealthy returns false if the endpoint is skipped after the failures.
+func isEndpointHealthy(endpoint string) bool {
+	endpointHealthLock.Lock()
+	defer endpointHealthLock.Unlock()
+
+	state, exists := endpointHealth[endpoint]
+	return !exists || !util.GetClock().Now().Before(state.unhealthyUntil)
+}
+
+func markEndpointUnhealthy(endpoint, label string, err error) {
+	endpointHealthLock.Lock()
+	defer endpointHealthLock.Unlock()
+
+	state, exists := endpointHealth[endpoint]
+	if !exists {
+		state = &endpointState{}
+		endpointHealth[endpoint] = state
+	}
+	backoff := maxEndpointBackoff
+	if state.failures < 6 {
+		backoff = minEndpointBackoff << state.failures
+		if backoff > maxEndpointBackoff {
+			backoff = maxEndpointBackoff
+		}
+	}
+	state.failures++
+	state.unhealthyUntil = util.GetClock().Now().Add(backoff)
+	metrics.S3EndpointHealthy.WithLabelValues(label).Set(0)
+	log.WithError(err).Warnf("Marked S3 endpoint %v unhealthy for %v after %v consecutive failures", label,
+		backoff, state.failures)
+}
+
+func markEndpointHealthy(endpoint, label string) {
+	endpointHealthLock.Lock()
+	defer endpointHealthLock.Unlock()
+
+	if _, exists := endpointHealth[endpoint]; !exists {
+		return
+	}
+	delete(endpointHealth, endpoint)
+	metrics.S3EndpointHealthy.WithLabelValues(label).Set(1)
+	log.Infof("S3 endpoint %v is healthy again", label)
+}
+
+// orderEndpoints returns the order of the endpoints tried by a request, starting from the given one. The
+// unhealthy endpoints are tried after the healthy ones, so the request is still tried if all are unhealthy.
+func orderEndpoints(endpoints []string, start int) []int {
+	healthy := make([]int, 0, len(endpoints))
+	unhealthy := []int{}
+	for i := 0; i < len(endpoints); i++ {
+		index := (start + i) % len(endpoints)
+		if endpoints[index] == "" || isEndpointHealthy(endpoints[index]) {
+			healthy = append(healthy, index)
+		} else {
+			unhealthy = append(unhealthy, index)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// isGatewayError returns true if the request is failed by the gateway in front of the S3 service, e.g. a
+// load balancer whose backend is down.
+func isGatewayError(err error) bool {
+	var reqErr awserr.RequestFailure
+	return errors.As(err, &reqErr) &&
+		(reqErr.StatusCode() == http.StatusBadGateway || reqErr.StatusCode() == http.StatusGatewayTimeout)
+}
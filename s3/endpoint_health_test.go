@@ -0,0 +1,185 @@
+package s3
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/metrics"
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestEndpointBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := util.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	endpoint := "http://endpoint-backoff"
+	defer markEndpointHealthy(endpoint, endpoint)
+	for _, backoff := range []time.Duration{
+		5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second,
+		maxEndpointBackoff, maxEndpointBackoff,
+	} {
+		markEndpointUnhealthy(endpoint, endpoint, errors.New("connection refused"))
+		assert.False(isEndpointHealthy(endpoint))
+		clock.Advance(backoff - time.Millisecond)
+		assert.False(isEndpointHealthy(endpoint), "backoff %v", backoff)
+		clock.Advance(time.Millisecond)
+		assert.True(isEndpointHealthy(endpoint), "backoff %v", backoff)
+	}
+
+	// The unhealthy endpoints are still tried once the healthy ones failed
+	other := "http://endpoint-backoff-other"
+	defer markEndpointHealthy(other, other)
+	assert.Equal([]int{1, 0}, orderEndpoints([]string{endpoint, other}, 1))
+	markEndpointUnhealthy(other, other, errors.New("connection refused"))
+	assert.Equal([]int{0, 1}, orderEndpoints([]string{endpoint, other}, 1))
+	markEndpointUnhealthy(endpoint, endpoint, errors.New("connection refused"))
+	assert.Equal([]int{1, 0}, orderEndpoints([]string{endpoint, other}, 1))
+}
+
+func TestEndpointFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := util.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	primary, secondary := "http://endpoint-failover-primary", "http://endpoint-failover-secondary"
+	defer markEndpointHealthy(primary, primary)
+	defer markEndpointHealthy(secondary, secondary)
+	s := &service{
+		Region:      "us-east-1",
+		Bucket:      "endpoint-failover",
+		getenv:      backupstore.TargetEnv(map[string]string{types.AWSEndPoint: primary + ", " + secondary}),
+		credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}
+
+	down := map[string]error{primary: awserr.New(request.ErrCodeRequestError, "send request failed", nil)}
+	var calls []string
+	do := func() error {
+		calls = nil
+		return s.do("HeadObject", func(svc *s3.S3) error {
+			calls = append(calls, svc.Endpoint)
+			return down[svc.Endpoint]
+		})
+	}
+
+	// The request fails over to the secondary endpoint, which is tried first by the next requests
+	assert.NoError(do())
+	assert.Equal([]string{primary, secondary}, calls)
+	assert.False(isEndpointHealthy(primary))
+	assert.NoError(do())
+	assert.Equal([]string{secondary}, calls)
+
+	// The gateway errors fail over as well, and the unhealthy primary is still tried as the last resort
+	down[secondary] = awserr.NewRequestFailure(awserr.New("BadGateway", "", nil), http.StatusBadGateway, "")
+	assert.Error(do())
+	assert.Equal([]string{secondary, primary}, calls)
+	assert.False(isEndpointHealthy(secondary))
+
+	// The other errors are returned by the endpoint without failing over
+	notFound := awserr.NewRequestFailure(awserr.New("NoSuchKey", "", nil), http.StatusNotFound, "")
+	down[secondary] = notFound
+	s.endpointIndex = 1
+	assert.Equal(notFound, do())
+	assert.Equal([]string{secondary}, calls)
+	assert.True(isEndpointHealthy(secondary))
+
+	// The primary is tried again after the backoff, and it's healthy again once a request succeeds
+	delete(down, secondary)
+	delete(down, primary)
+	s.endpointIndex = 0
+	assert.NoError(do())
+	assert.Equal([]string{secondary}, calls)
+	clock.Advance(maxEndpointBackoff)
+	s.endpointIndex = 0
+	assert.NoError(do())
+	assert.Equal([]string{primary}, calls)
+	assert.True(isEndpointHealthy(primary))
+	endpointHealthLock.Lock()
+	_, exists := endpointHealth[primary]
+	endpointHealthLock.Unlock()
+	assert.False(exists)
+}
+
+func getMetricValue(t *testing.T, collector prometheus.Collector, labels ...string) float64 {
+	var m prometheus.Metric
+	var err error
+	switch vec := collector.(type) {
+	case *prometheus.CounterVec:
+		m, err = vec.GetMetricWithLabelValues(labels...)
+	case *prometheus.GaugeVec:
+		m, err = vec.GetMetricWithLabelValues(labels...)
+	}
+	assert.NoError(t, err)
+	metric := &dto.Metric{}
+	assert.NoError(t, m.Write(metric))
+	if metric.Counter != nil {
+		return metric.Counter.GetValue()
+	}
+	return metric.Gauge.GetValue()
+}
+
+func TestEndpointFailoverMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := util.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	primary, secondary := "http://endpoint-metrics-primary", "http://endpoint-metrics-secondary"
+	defer markEndpointHealthy(primary, primary)
+	s := &service{
+		Region:      "us-east-1",
+		Bucket:      "endpoint-metrics",
+		getenv:      backupstore.TargetEnv(map[string]string{types.AWSEndPoint: primary + "," + secondary}),
+		credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}
+	connErr := awserr.New(request.ErrCodeRequestError, "send request failed", nil)
+	primaryDown := true
+	op := func(svc *s3.S3) error {
+		if svc.Endpoint == primary && primaryDown {
+			return connErr
+		}
+		return nil
+	}
+
+	// The operations are counted by the endpoint serving them, and the failover by the failed endpoint
+	assert.NoError(s.do("GetObject", op))
+	assert.Equal(1.0, getMetricValue(t, metrics.S3EndpointOperations, primary, "GetObject", metrics.ResultFailure))
+	assert.Equal(1.0, getMetricValue(t, metrics.S3EndpointOperations, secondary, "GetObject", metrics.ResultSuccess))
+	assert.Equal(1.0, getMetricValue(t, metrics.S3EndpointFailovers, primary))
+	assert.Equal(0.0, getMetricValue(t, metrics.S3EndpointFailovers, secondary))
+	assert.Equal(0.0, getMetricValue(t, metrics.S3EndpointHealthy, primary))
+
+	// The recovered endpoint is reported healthy again
+	primaryDown = false
+	clock.Advance(maxEndpointBackoff)
+	s.endpointIndex = 0
+	assert.NoError(s.do("GetObject", op))
+	assert.Equal(1.0, getMetricValue(t, metrics.S3EndpointOperations, primary, "GetObject", metrics.ResultSuccess))
+	assert.Equal(1.0, getMetricValue(t, metrics.S3EndpointHealthy, primary))
+
+	// The failovers aren't counted without the other endpoints
+	single := "http://endpoint-metrics-single"
+	s.getenv = backupstore.TargetEnv(map[string]string{types.AWSEndPoint: single})
+	assert.Equal(connErr, s.do("GetObject", func(svc *s3.S3) error { return connErr }))
+	assert.Equal(1.0, getMetricValue(t, metrics.S3EndpointOperations, single, "GetObject", metrics.ResultFailure))
+	assert.Equal(0.0, getMetricValue(t, metrics.S3EndpointFailovers, single))
+	assert.True(isEndpointHealthy(single))
+}
@@ -0,0 +1,237 @@
+package s3
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// The backup target URL query parameters tuning the multipart uploads, e.g.
+	// s3://bucket@us-east-1/path/?multipartThreshold=134217728&multipartPartSize=33554432&multipartConcurrency=8
+	// The sizes are in bytes. The objects smaller than the threshold are uploaded by a single request, and up to
+	// the part size times the concurrency is buffered per upload unless the object is read from a file.
+	MultipartThresholdOption   = "multipartThreshold"
+	MultipartPartSizeOption    = "multipartPartSize"
+	MultipartConcurrencyOption = "multipartConcurrency"
+
+	DefaultMultipartThreshold   = 64 << 20
+	DefaultMultipartPartSize    = 16 << 20
+	DefaultMultipartConcurrency = 4
+
+	// The limits of S3, the parts except the last one must be at least 5 MiB and an upload has at most 10000 parts
+	minMultipartPartSize = 5 << 20
+	maxMultipartParts    = 10000
+)
+
+// multipartOptions are the options of the multipart uploads.
+type multipartOptions struct {
+	threshold   int64
+	partSize    int64
+	concurrency int
+}
+
+func parseMultipartOptions(values url.Values) (*multipartOptions, error) {
+	opts := &multipartOptions{
+		threshold:   DefaultMultipartThreshold,
+		partSize:    DefaultMultipartPartSize,
+		concurrency: DefaultMultipartConcurrency,
+	}
+	for _, option := range []struct {
+		name  string
+		value *int64
+	}{
+		{MultipartThresholdOption, &opts.threshold},
+		{MultipartPartSizeOption, &opts.partSize},
+	} {
+		value := values.Get(option.name)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < minMultipartPartSize {
+			return nil, fmt.Errorf("invalid %v option %v in backup target URL, must be at least %v bytes",
+				option.name, value, minMultipartPartSize)
+		}
+		*option.value = size
+	}
+	if value := values.Get(MultipartConcurrencyOption); value != "" {
+		concurrency, err := strconv.Atoi(value)
+		if err != nil || concurrency < 1 {
+			return nil, fmt.Errorf("invalid %v option %v in backup target URL, must be a positive integer",
+				MultipartConcurrencyOption, value)
+		}
+		opts.concurrency = concurrency
+	}
+	return opts, nil
+}
+
+// getPartSize returns the part size of the object, which is increased if the object would have too many parts.
+func (o *multipartOptions) getPartSize(size int64) int64 {
+	partSize := o.partSize
+	if minPartSize := (size + maxMultipartParts - 1) / maxMultipartParts; partSize < minPartSize {
+		partSize = minPartSize
+	}
+	return partSize
+}
+
+// putObjectMultipart uploads the object of the given size from the current offset of the reader by the parts
+// uploaded concurrently. The upload is aborted if any part fails, so the uploaded parts aren't left behind.
+func (s *service) putObjectMultipart(key string, reader io.ReadSeeker, offset, size int64, contentType, contentEncoding string) error {
+	params := &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(s.Bucket),
+		Key:          aws.String(key),
+		StorageClass: s.getStorageClass(key),
+	}
+	if contentType != "" {
+		params.ContentType = aws.String(contentType)
+	}
+	if contentEncoding != "" {
+		params.ContentEncoding = aws.String(contentEncoding)
+	}
+	s.encryption.applyToCreateMultipartUpload(params)
+	s.objectLock.applyToCreateMultipartUpload(key, params)
+
+	var created *s3.CreateMultipartUploadOutput
+	err := s.do("CreateMultipartUpload", func(svc *s3.S3) (err error) {
+		created, err = svc.CreateMultipartUpload(params)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload of object: %v error: %v", key, parseAwsError(err))
+	}
+	uploadID := created.UploadId
+
+	parts, err := s.uploadParts(key, uploadID, reader, offset, size)
+	if err != nil {
+		abortErr := s.do("AbortMultipartUpload", func(svc *s3.S3) error {
+			_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(s.Bucket),
+				Key:      aws.String(key),
+				UploadId: uploadID,
+			})
+			return err
+		})
+		if abortErr != nil {
+			log.WithError(parseAwsError(abortErr)).Warnf("Failed to abort multipart upload of object %v", key)
+		}
+		return err
+	}
+
+	err = s.do("CompleteMultipartUpload", func(svc *s3.S3) error {
+		_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.Bucket),
+			Key:             aws.String(key),
+			UploadId:        uploadID,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload of object: %v error: %v", key, parseAwsError(err))
+	}
+	return nil
+}
+
+func (s *service) uploadParts(key string, uploadID *string, reader io.ReadSeeker, offset, size int64) ([]*s3.CompletedPart, error) {
+	partSize := s.multipart.getPartSize(size)
+	// The parts of a file are read in place, the other readers are buffered part by part
+	readerAt, isReaderAt := reader.(io.ReaderAt)
+
+	var (
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		parts   []*s3.CompletedPart
+		partErr error
+	)
+	slots := make(chan struct{}, s.multipart.concurrency)
+	for partNumber, start := int64(1), int64(0); start < size; partNumber, start = partNumber+1, start+partSize {
+		length := partSize
+		if start+length > size {
+			length = size - start
+		}
+
+		lock.Lock()
+		failed := partErr != nil
+		lock.Unlock()
+		if failed {
+			break
+		}
+
+		// The slot is taken before the part is buffered, to cap the memory of the upload
+		slots <- struct{}{}
+		var body io.ReadSeeker
+		if isReaderAt {
+			body = io.NewSectionReader(readerAt, offset+start, length)
+		} else {
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				<-slots
+				lock.Lock()
+				partErr = err
+				lock.Unlock()
+				break
+			}
+			body = bytes.NewReader(buf)
+		}
+
+		wg.Add(1)
+		go func(partNumber int64, body io.ReadSeeker) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			etag, err := s.uploadPart(key, uploadID, partNumber, body)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if partErr == nil {
+					partErr = err
+				}
+				return
+			}
+			parts = append(parts, &s3.CompletedPart{ETag: etag, PartNumber: aws.Int64(partNumber)})
+		}(partNumber, body)
+	}
+	wg.Wait()
+
+	if partErr != nil {
+		return nil, partErr
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+	})
+	return parts, nil
+}
+
+func (s *service) uploadPart(key string, uploadID *string, partNumber int64, body io.ReadSeeker) (*string, error) {
+	params := &s3.UploadPartInput{
+		Bucket:     aws.String(s.Bucket),
+		Key:        aws.String(key),
+		UploadId:   uploadID,
+		PartNumber: aws.Int64(partNumber),
+		Body:       body,
+	}
+	s.encryption.applyToUploadPart(params)
+
+	var resp *s3.UploadPartOutput
+	err := s.do("UploadPart", func(svc *s3.S3) (err error) {
+		svc.Client.Config.Retryer = uploadRetryer
+		// Rewind the body in case of failing over from another endpoint
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		resp, err = svc.UploadPart(params)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload part %v of object: %v error: %v", partNumber, key, parseAwsError(err))
+	}
+	return resp.ETag, nil
+}
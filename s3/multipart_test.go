@@ -0,0 +1,58 @@
+package s3
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMultipartOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		expected *multipartOptions
+		errMsg   string
+	}{
+		{"default", "", &multipartOptions{DefaultMultipartThreshold, DefaultMultipartPartSize, DefaultMultipartConcurrency}, ""},
+		{"custom", "multipartThreshold=134217728&multipartPartSize=33554432&multipartConcurrency=8",
+			&multipartOptions{128 << 20, 32 << 20, 8}, ""},
+		{"minimum part size", "multipartPartSize=5242880",
+			&multipartOptions{DefaultMultipartThreshold, minMultipartPartSize, DefaultMultipartConcurrency}, ""},
+		{"part size below minimum", "multipartPartSize=5242879", nil, "must be at least 5242880 bytes"},
+		{"threshold below minimum", "multipartThreshold=1024", nil, "invalid multipartThreshold option 1024"},
+		{"invalid size", "multipartPartSize=16MiB", nil, "invalid multipartPartSize option 16MiB"},
+		{"zero concurrency", "multipartConcurrency=0", nil, "must be a positive integer"},
+		{"invalid concurrency", "multipartConcurrency=many", nil, "invalid multipartConcurrency option many"},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err, tc.name)
+		opts, err := parseMultipartOptions(values)
+		if tc.errMsg != "" {
+			assert.ErrorContains(err, tc.errMsg, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, opts, tc.name)
+	}
+}
+
+func TestMultipartPartSize(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := &multipartOptions{partSize: DefaultMultipartPartSize}
+	// The part size is increased so the object doesn't exceed the max number of the parts
+	for _, tc := range []struct {
+		size     int64
+		partSize int64
+	}{
+		{0, DefaultMultipartPartSize},
+		{DefaultMultipartPartSize * maxMultipartParts, DefaultMultipartPartSize},
+		{DefaultMultipartPartSize*maxMultipartParts + 1, DefaultMultipartPartSize + 1},
+		{1 << 40, (1<<40 + maxMultipartParts - 1) / maxMultipartParts},
+	} {
+		assert.Equal(tc.partSize, opts.getPartSize(tc.size), "size %v", tc.size)
+	}
+}
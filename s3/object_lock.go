@@ -0,0 +1,230 @@
+package s3
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// The backup target URL query parameters writing the objects with the Object Lock retention, e.g.
+	// s3://bucket@us-east-1/path/?objectLockMode=COMPLIANCE&objectLockRetentionDays=30. The bucket must be
+	// created with the Object Lock enabled, and the objects within the retention period are refused to be
+	// removed. Only the blocks and the completed backup configs are written with the retention, see isRetained. The
+	// retention of the blocks reused by a backup is extended once the backup completes, see ExtendObjectRetention.
+	ObjectLockModeOption          = "objectLockMode"
+	ObjectLockRetentionDaysOption = "objectLockRetentionDays"
+
+	// retentionCheckConcurrency is the number of the objects whose retention is checked at once before removing them
+	retentionCheckConcurrency = 16
+)
+
+// objectLockOptions are the Object Lock retention options of the objects.
+type objectLockOptions struct {
+	mode      string
+	retention time.Duration
+}
+
+func parseObjectLockOptions(values url.Values) (*objectLockOptions, error) {
+	mode := values.Get(ObjectLockModeOption)
+	days := values.Get(ObjectLockRetentionDaysOption)
+	if mode == "" && days == "" {
+		return nil, nil
+	}
+	if mode == "" || days == "" {
+		return nil, fmt.Errorf("%v and %v options must be both set in backup target URL", ObjectLockModeOption,
+			ObjectLockRetentionDaysOption)
+	}
+
+	supported := false
+	for _, value := range s3.ObjectLockMode_Values() {
+		if mode == value {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("invalid %v option %v in backup target URL, must be one of %v", ObjectLockModeOption,
+			mode, strings.Join(s3.ObjectLockMode_Values(), ", "))
+	}
+	retentionDays, err := strconv.Atoi(days)
+	if err != nil || retentionDays < 1 {
+		return nil, fmt.Errorf("invalid %v option %v in backup target URL, must be a positive integer",
+			ObjectLockRetentionDaysOption, days)
+	}
+	return &objectLockOptions{mode: mode, retention: time.Duration(retentionDays) * 24 * time.Hour}, nil
+}
+
+// isRetained returns true if the object is written with the retention. Only the blocks and the backup configs are
+// immutable, the volume configs are updated by each backup, and the locks and the probe objects are removed by the
+// operations.
+func (o *objectLockOptions) isRetained(key string) bool {
+	if o == nil {
+		return false
+	}
+	if strings.HasSuffix(key, backupstore.BLK_SUFFIX) {
+		return true
+	}
+	return isBackupConfigKey(key)
+}
+
+func isBackupConfigKey(key string) bool {
+	name := filepath.Base(key)
+	return filepath.Base(filepath.Dir(key)) == backupstore.BACKUP_DIRECTORY &&
+		strings.HasPrefix(name, backupstore.BACKUP_CONFIG_PREFIX) && strings.HasSuffix(name, backupstore.CFG_SUFFIX)
+}
+
+// isCompletedBackupConfig checks the backup config written by the body, the config of the backup in progress is
+// updated once the backup completes. The body is read from its current offset, it's rewound by the put.
+func isCompletedBackupConfig(body io.Reader) bool {
+	backup := &struct{ CreatedTime string }{}
+	if err := json.NewDecoder(body).Decode(backup); err != nil {
+		// Be conservative if the config cannot be checked
+		return true
+	}
+	return backup.CreatedTime != ""
+}
+
+// getRetention returns the mode and the retain until date of the object written now, or nils if the object is
+// written without the retention. The backup config is retained only if it's completed.
+func (o *objectLockOptions) getRetention(key string, completed bool) (*string, *time.Time) {
+	if !o.isRetained(key) || (isBackupConfigKey(key) && !completed) {
+		return nil, nil
+	}
+	return aws.String(o.mode), aws.Time(util.GetClock().Now().Add(o.retention))
+}
+
+func (o *objectLockOptions) applyToPut(key string, params *s3.PutObjectInput) {
+	completed := false
+	if o.isRetained(key) && isBackupConfigKey(key) {
+		completed = isCompletedBackupConfig(params.Body)
+	}
+	params.ObjectLockMode, params.ObjectLockRetainUntilDate = o.getRetention(key, completed)
+}
+
+// applyToCreateMultipartUpload applies the retention to the large objects, which are the blocks rather than the
+// configs.
+func (o *objectLockOptions) applyToCreateMultipartUpload(key string, params *s3.CreateMultipartUploadInput) {
+	params.ObjectLockMode, params.ObjectLockRetainUntilDate = o.getRetention(key, true)
+}
+
+// applyToCopy applies the retention to the copy, the backup configs are only copied from the completed backups,
+// e.g. by the seeding and the undeletion.
+func (o *objectLockOptions) applyToCopy(key string, params *s3.CopyObjectInput) {
+	params.ObjectLockMode, params.ObjectLockRetainUntilDate = o.getRetention(key, true)
+}
+
+// checkObjectLockEnabled verifies the Object Lock is enabled for the bucket, otherwise the retention cannot be
+// applied to the objects.
+func (s *service) checkObjectLockEnabled() error {
+	if s.objectLock == nil {
+		return nil
+	}
+	var resp *s3.GetObjectLockConfigurationOutput
+	err := s.do("GetObjectLockConfiguration", func(svc *s3.S3) (err error) {
+		resp, err = svc.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+			Bucket: aws.String(s.Bucket),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get Object Lock configuration of bucket %v required by %v option error: %v",
+			s.Bucket, ObjectLockModeOption, parseAwsError(err))
+	}
+	if resp.ObjectLockConfiguration == nil ||
+		aws.StringValue(resp.ObjectLockConfiguration.ObjectLockEnabled) != s3.ObjectLockEnabledEnabled {
+		return fmt.Errorf("bucket %v doesn't have Object Lock enabled required by %v option", s.Bucket,
+			ObjectLockModeOption)
+	}
+	return nil
+}
+
+// ExtendObjectRetention extends the retention of the existing object to the one of the objects written now. The
+// objects reused by the later backups, e.g. the blocks, would otherwise expire before the backups referring them.
+// The retention can only be extended, and it's a no-op for the objects written without the retention.
+func (s *service) ExtendObjectRetention(key string) error {
+	if !s.objectLock.isRetained(key) {
+		return nil
+	}
+	mode, retainUntil := s.objectLock.getRetention(key, true)
+	params := &s3.PutObjectRetentionInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Retention: &s3.ObjectLockRetention{
+			Mode:            mode,
+			RetainUntilDate: retainUntil,
+		},
+	}
+	err := s.do("PutObjectRetention", func(svc *s3.S3) error {
+		_, err := svc.PutObjectRetention(params)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to extend retention of object: %v error: %v", key, parseAwsError(err))
+	}
+	return nil
+}
+
+// checkNotRetained returns ErrRetainedObject of the objects still within their retention periods. The retention
+// isn't returned by the listings, and S3 doesn't refuse to add the delete markers over the retained versions, so
+// the objects written with the retention are checked by the HEAD requests sent concurrently.
+func (s *service) checkNotRetained(keys []string) map[string]error {
+	failures := map[string]error{}
+	if s.objectLock == nil {
+		return failures
+	}
+
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	slots := make(chan struct{}, retentionCheckConcurrency)
+	for _, key := range keys {
+		if !s.objectLock.isRetained(key) {
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := s.checkObjectNotRetained(key); err != nil {
+				lock.Lock()
+				failures[key] = err
+				lock.Unlock()
+			}
+		}(key)
+	}
+	wg.Wait()
+	return failures
+}
+
+func (s *service) checkObjectNotRetained(key string) error {
+	head, err := s.headObject(key)
+	if err != nil {
+		// The missing objects are removed already
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to get retention of object: %v error: %v", key, parseAwsError(err))
+	}
+	if retainUntil := aws.TimeValue(head.ObjectLockRetainUntilDate); retainUntil.After(util.GetClock().Now()) {
+		return &backupstore.ErrRetainedObject{Path: key, RetainUntil: retainUntil}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestParseObjectLockOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		expected *objectLockOptions
+		errMsg   string
+	}{
+		{"not set", "", nil, ""},
+		{"compliance", "objectLockMode=COMPLIANCE&objectLockRetentionDays=30",
+			&objectLockOptions{mode: s3.ObjectLockModeCompliance, retention: 30 * 24 * time.Hour}, ""},
+		{"governance", "objectLockMode=GOVERNANCE&objectLockRetentionDays=1",
+			&objectLockOptions{mode: s3.ObjectLockModeGovernance, retention: 24 * time.Hour}, ""},
+		{"mode only", "objectLockMode=COMPLIANCE", nil, "must be both set"},
+		{"retention only", "objectLockRetentionDays=30", nil, "must be both set"},
+		{"invalid mode", "objectLockMode=LEGAL_HOLD&objectLockRetentionDays=30", nil, "invalid objectLockMode option LEGAL_HOLD"},
+		{"zero retention", "objectLockMode=COMPLIANCE&objectLockRetentionDays=0", nil, "must be a positive integer"},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err, tc.name)
+		opts, err := parseObjectLockOptions(values)
+		if tc.errMsg != "" {
+			assert.ErrorContains(err, tc.errMsg, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, opts, tc.name)
+	}
+}
+
+func TestObjectLockRetention(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	util.SetClock(util.NewFakeClock(now))
+	defer util.SetClock(nil)
+
+	o := &objectLockOptions{mode: s3.ObjectLockModeCompliance, retention: 24 * time.Hour}
+	volumePath := "backupstore/volumes/00/00/pvc-1"
+	for _, tc := range []struct {
+		name     string
+		key      string
+		body     string
+		retained bool
+	}{
+		{"block", volumePath + "/blocks/00/00/0000.blk", "data", true},
+		{"linked block", "backupstore/block-links/lz4/00/00/0000.blk", "data", true},
+		{"completed backup config", volumePath + "/backups/backup_backup-1.cfg",
+			`{"Name":"backup-1","CreatedTime":"2024-01-01T00:00:00Z"}`, true},
+		{"backup config in progress", volumePath + "/backups/backup_backup-1.cfg", `{"Name":"backup-1","CreatedTime":""}`, false},
+		{"unreadable backup config", volumePath + "/backups/backup_backup-1.cfg", "{", true},
+		{"volume config", volumePath + "/volume.cfg", `{"Name":"pvc-1"}`, false},
+		{"lock", volumePath + "/locks/lock-1.lck", "{}", false},
+		{"probe", "backupstore/probe/probe-1", "probe", false},
+	} {
+		params := &s3.PutObjectInput{Body: strings.NewReader(tc.body)}
+		o.applyToPut(tc.key, params)
+		if !tc.retained {
+			assert.Nil(params.ObjectLockMode, tc.name)
+			assert.Nil(params.ObjectLockRetainUntilDate, tc.name)
+			continue
+		}
+		assert.Equal(s3.ObjectLockModeCompliance, *params.ObjectLockMode, tc.name)
+		assert.Equal(now.Add(24*time.Hour), *params.ObjectLockRetainUntilDate, tc.name)
+	}
+
+	// The copies of the backup configs are from the completed backups
+	params := &s3.CopyObjectInput{}
+	o.applyToCopy(volumePath+"/backups/backup_backup-1.cfg", params)
+	assert.NotNil(params.ObjectLockMode)
+	params = &s3.CopyObjectInput{}
+	o.applyToCopy(volumePath+"/volume.cfg", params)
+	assert.Nil(params.ObjectLockMode)
+
+	// The objects are written without the retention if it's not enabled
+	var disabled *objectLockOptions
+	put := &s3.PutObjectInput{Body: strings.NewReader("data")}
+	disabled.applyToPut(volumePath+"/blocks/00/00/0000.blk", put)
+	assert.Nil(put.ObjectLockMode)
+}
+
+func TestDeleteRetainedObjects(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	util.SetClock(util.NewFakeClock(now))
+	defer util.SetClock(nil)
+
+	volumePath := "backupstore/volumes/00/00/pvc-1"
+	retained := volumePath + "/blocks/00/00/0000.blk"
+	expired := volumePath + "/blocks/00/00/0001.blk"
+	missing := volumePath + "/blocks/00/00/0002.blk"
+	volumeConfig := volumePath + "/volume.cfg"
+	retainUntil := map[string]time.Time{retained: now.Add(time.Hour), expired: now.Add(-time.Hour)}
+
+	var (
+		lock    sync.Mutex
+		heads   []string
+		deleted string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := strings.TrimPrefix(req.URL.Path, "/bucket/")
+		switch req.Method {
+		case http.MethodHead:
+			lock.Lock()
+			heads = append(heads, key)
+			lock.Unlock()
+			until, exists := retainUntil[key]
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("x-amz-object-lock-retain-until-date", until.Format(time.RFC3339))
+		case http.MethodPost:
+			body, _ := io.ReadAll(req.Body)
+			deleted = string(body)
+			_, _ = io.WriteString(w, "<DeleteResult></DeleteResult>")
+		}
+	}))
+	defer server.Close()
+	s := &service{
+		Region:      "us-east-1",
+		Bucket:      "bucket",
+		getenv:      backupstore.TargetEnv(map[string]string{types.AWSEndPoint: server.URL}),
+		credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		objectLock:  &objectLockOptions{mode: s3.ObjectLockModeCompliance, retention: 24 * time.Hour},
+	}
+
+	// Only the objects written with the retention are checked
+	failures := s.checkNotRetained([]string{retained, expired, missing, volumeConfig})
+	assert.ElementsMatch([]string{retained, expired, missing}, heads)
+	assert.Len(failures, 1)
+	assert.True(backupstore.IsRetainedObjectError(failures[retained]))
+
+	// The retained objects are left out of the batches
+	failures = s.DeleteObjectsBatch([]string{retained, expired, volumeConfig})
+	assert.Len(failures, 1)
+	assert.True(backupstore.IsRetainedObjectError(failures[retained]))
+	assert.NotContains(deleted, retained)
+	assert.Contains(deleted, expired)
+	assert.Contains(deleted, volumeConfig)
+
+	// The retention isn't checked if it's not enabled
+	heads = nil
+	s.objectLock = nil
+	assert.Empty(s.checkNotRetained([]string{retained}))
+	assert.Empty(heads)
+}
+
+func TestExtendObjectRetention(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	util.SetClock(util.NewFakeClock(now))
+	defer util.SetClock(nil)
+
+	var (
+		lock     sync.Mutex
+		extended = map[string]string{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPut || !req.URL.Query().Has("retention") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		lock.Lock()
+		extended[strings.TrimPrefix(req.URL.Path, "/bucket/")] = string(body)
+		lock.Unlock()
+	}))
+	defer server.Close()
+	s := &service{
+		Region:      "us-east-1",
+		Bucket:      "bucket",
+		getenv:      backupstore.TargetEnv(map[string]string{types.AWSEndPoint: server.URL}),
+		credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		objectLock:  &objectLockOptions{mode: s3.ObjectLockModeCompliance, retention: 24 * time.Hour},
+	}
+
+	volumePath := "backupstore/volumes/00/00/pvc-1"
+	block := volumePath + "/blocks/00/00/0000.blk"
+	assert.NoError(s.ExtendObjectRetention(block))
+	assert.NoError(s.ExtendObjectRetention(volumePath + "/volume.cfg"))
+	assert.Len(extended, 1)
+	assert.Contains(extended[block], "<Mode>COMPLIANCE</Mode>")
+	assert.Contains(extended[block], "<RetainUntilDate>2024-01-02T00:00:00Z</RetainUntilDate>")
+
+	// The retention isn't extended if it's not enabled
+	delete(extended, block)
+	s.objectLock = nil
+	assert.NoError(s.ExtendObjectRetention(block))
+	assert.Empty(extended)
+}
@@ -0,0 +1,39 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// RequesterPaysOption is the backup target URL query parameter accessing a requester-pays bucket, e.g.
+	// s3://bucket@us-east-1/path/?requesterPays=true. The requests are charged to the account of the credential
+	// instead of the bucket owner.
+	RequesterPaysOption = "requesterPays"
+
+	requestPayerHeader = "x-amz-request-payer"
+)
+
+func parseRequesterPays(values url.Values) (bool, error) {
+	value := values.Get(RequesterPaysOption)
+	if value == "" {
+		return false, nil
+	}
+	requesterPays, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %v option %v in backup target URL", RequesterPaysOption, value)
+	}
+	return requesterPays, nil
+}
+
+// addRequestPayerHandler sets the request payer of all the operations of the client, including the ones
+// without the RequestPayer parameter, e.g. ListObjects.
+func addRequestPayerHandler(svc *s3.S3) {
+	svc.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest.Header.Set(requestPayerHeader, s3.RequestPayerRequester)
+	})
+}
@@ -0,0 +1,51 @@
+package s3
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequesterPays(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		query         string
+		requesterPays bool
+		valid         bool
+	}{
+		{"", false, true},
+		{"requesterPays=true", true, true},
+		{"requesterPays=1", true, true},
+		{"requesterPays=false", false, true},
+		{"requesterPays=requester", false, false},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err, tc.query)
+		requesterPays, err := parseRequesterPays(values)
+		if !tc.valid {
+			assert.ErrorContains(err, "invalid requesterPays option", tc.query)
+			continue
+		}
+		assert.NoError(err, tc.query)
+		assert.Equal(tc.requesterPays, requesterPays, tc.query)
+	}
+}
+
+func TestRequestPayerHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	// The header is set on the operations without the RequestPayer parameter as well
+	svc := newTestS3(t)
+	addRequestPayerHandler(svc)
+	req, _ := svc.ListObjectsRequest(&s3.ListObjectsInput{Bucket: aws.String("bucket")})
+	assert.NoError(req.Build())
+	assert.Equal(s3.RequestPayerRequester, req.HTTPRequest.Header.Get(requestPayerHeader))
+
+	req, _ = newTestS3(t).ListObjectsRequest(&s3.ListObjectsInput{Bucket: aws.String("bucket")})
+	assert.NoError(req.Build())
+	assert.Empty(req.HTTPRequest.Header.Get(requestPayerHeader))
+}
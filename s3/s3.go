@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/longhorn/backupstore"
@@ -45,8 +46,12 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
 	}
 
+	credential, err := backupstore.LoadTargetCredential(destURL)
+	if err != nil {
+		return nil, err
+	}
 	b := &BackupStoreDriver{}
-	b.service, err = newService(u)
+	b.service, err = newService(u, credential)
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +68,9 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 	if _, err := b.List(""); err != nil {
 		return nil, err
 	}
+	if err := b.service.checkObjectLockEnabled(); err != nil {
+		return nil, err
+	}
 
 	b.destURL = KIND + "://" + b.service.Bucket
 	if b.service.Region != "" {
@@ -74,16 +82,6 @@ func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
 	return b, nil
 }
 
-func getCustomCerts() []byte {
-	// Certificates in PEM format (base64)
-	certs := os.Getenv("AWS_CERT")
-	if certs == "" {
-		return nil
-	}
-
-	return []byte(certs)
-}
-
 func (s *BackupStoreDriver) Kind() string {
 	return KIND
 }
@@ -140,6 +138,24 @@ func (s *BackupStoreDriver) List(listPath string) ([]string, error) {
 	return result, nil
 }
 
+func (s *BackupStoreDriver) ListPrefix(prefix string) ([]string, error) {
+	path := s.updatePath(prefix)
+	objects, err := s.service.ListObjectsV2(path)
+	if err != nil {
+		log.WithError(err).Error("Failed to list s3")
+		return nil, err
+	}
+
+	result := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		r := strings.TrimPrefix(strings.TrimPrefix(*obj.Key, s.path), "/")
+		if r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
 func (s *BackupStoreDriver) FileExists(filePath string) bool {
 	return s.FileSize(filePath) >= 0
 }
@@ -166,15 +182,53 @@ func (s *BackupStoreDriver) Remove(path string) error {
 	return s.service.DeleteObjects(s.updatePath(path))
 }
 
+// RemoveAll removes the files by the batch deletes of S3.
+func (s *BackupStoreDriver) RemoveAll(paths []string) error {
+	keys := make([]string, 0, len(paths))
+	keyPaths := make(map[string]string, len(paths))
+	for _, path := range paths {
+		key := s.updatePath(path)
+		keys = append(keys, key)
+		keyPaths[key] = path
+	}
+	failures := s.service.DeleteObjectsBatch(keys)
+	if len(failures) == 0 {
+		return nil
+	}
+	removeErr := &backupstore.ErrRemoveFailed{Errors: make(map[string]error, len(failures))}
+	for key, err := range failures {
+		removeErr.Errors[keyPaths[key]] = err
+	}
+	return removeErr
+}
+
 func (s *BackupStoreDriver) Read(src string) (io.ReadCloser, error) {
 	path := s.updatePath(src)
 	rc, err := s.service.GetObject(path)
 	if err != nil {
-		return nil, err
+		return nil, s.thawIfArchived(src, err)
 	}
 	return rc, nil
 }
 
+// ThawObject requests the restore of the archived object, and returns true if the object can be read.
+func (s *BackupStoreDriver) ThawObject(filePath string) (bool, error) {
+	return s.service.ThawObject(s.updatePath(filePath))
+}
+
+// thawIfArchived requests the restore of the object if it cannot be read for being archived, so the read
+// succeeds after the object is restored.
+func (s *BackupStoreDriver) thawIfArchived(filePath string, err error) error {
+	var archivedErr *archivedObjectError
+	if !errors.As(err, &archivedErr) {
+		return err
+	}
+	if _, thawErr := s.ThawObject(filePath); thawErr != nil {
+		log.WithError(thawErr).Warnf("Failed to request restore of archived object %v", filePath)
+	}
+	return &backupstore.ErrObjectArchived{Path: filePath}
+}
+
 func (s *BackupStoreDriver) Write(dst string, rs io.ReadSeeker) error {
 	path := s.updatePath(dst)
 	return s.service.PutObject(path, rs)
@@ -208,10 +262,64 @@ func (s *BackupStoreDriver) Download(src, dst string) error {
 	path := s.updatePath(src)
 	rc, err := s.service.GetObject(path)
 	if err != nil {
-		return err
+		return s.thawIfArchived(src, err)
 	}
 	defer rc.Close()
 
 	_, err = io.Copy(f, rc)
 	return err
 }
+
+func (s *BackupStoreDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *backupstore.ObjectMetadata) error {
+	path := s.updatePath(dst)
+	return s.service.PutObjectWithMetadata(path, rs, metadata.ContentType, metadata.ContentEncoding)
+}
+
+func (s *BackupStoreDriver) GetMetadata(filePath string) (*backupstore.ObjectMetadata, error) {
+	head, err := s.service.HeadObject(s.updatePath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return &backupstore.ObjectMetadata{
+		ContentType:     aws.StringValue(head.ContentType),
+		ContentEncoding: aws.StringValue(head.ContentEncoding),
+	}, nil
+}
+
+func (s *BackupStoreDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := s.service.GetObjectRange(s.updatePath(src), offset, length)
+	if err != nil {
+		return nil, s.thawIfArchived(src, err)
+	}
+	return rc, nil
+}
+
+func (s *BackupStoreDriver) ListDeletedObjects(listPath string) ([]backupstore.DeletedObject, error) {
+	prefix := s.updatePath(listPath)
+	versions, markers, err := s.service.ListDeletedObjectVersions(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []backupstore.DeletedObject{}
+	for i, version := range versions {
+		result = append(result, backupstore.DeletedObject{
+			Path:      strings.TrimPrefix(strings.TrimPrefix(aws.StringValue(version.Key), s.path), "/"),
+			VersionID: aws.StringValue(version.VersionId),
+			DeletedAt: aws.TimeValue(markers[i].LastModified).UTC(),
+		})
+	}
+	return result, nil
+}
+
+func (s *BackupStoreDriver) RestoreObjectVersion(filePath, versionID string) error {
+	return s.service.RestoreObjectVersion(s.updatePath(filePath), versionID)
+}
+
+func (s *BackupStoreDriver) ExtendRetention(filePath string) error {
+	return s.service.ExtendObjectRetention(s.updatePath(filePath))
+}
+
+func (s *BackupStoreDriver) Copy(src, dst string) error {
+	return s.service.CopyObject(s.updatePath(src), s.updatePath(dst))
+}
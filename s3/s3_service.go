@@ -3,33 +3,74 @@ package s3
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
 
+	"github.com/longhorn/backupstore"
 	bhttp "github.com/longhorn/backupstore/http"
+	"github.com/longhorn/backupstore/metrics"
+	"github.com/longhorn/backupstore/types"
 )
 
 type service struct {
 	Region string
 	Bucket string
 	Client *http.Client
+
+	// credential is the credential of the backup target, the environment variables are used if it's nil
+	credential map[string]string
+	// getenv looks up the settings in the credential, see backupstore.TargetEnv
+	getenv func(string) string
+	// credentials are the AWS credentials of the clients, the default credential chain is used if it's nil
+	credentials *credentials.Credentials
+	encryption  *encryptionOptions
+	multipart   *multipartOptions
+	// storageClass is the storage class of the block objects, the default storage class is used if it's empty
+	storageClass string
+	restore      *restoreOptions
+	// objectLock is the Object Lock retention of the objects, the objects are written without the retention if
+	// it's nil
+	objectLock    *objectLockOptions
+	requesterPays bool
+
+	endpointLock  sync.Mutex
+	endpointIndex int
 }
 
 const (
 	VirtualHostedStyle = "VIRTUAL_HOSTED_STYLE"
+
+	// maxDeleteObjectsBatch is the max number of the keys deleted by a DeleteObjects request
+	maxDeleteObjectsBatch = 1000
 )
 
-func newService(u *url.URL) (*service, error) {
-	s := service{}
+var (
+	// uploadRetryer is the retryer of the object and part uploads, which are retried longer than the other requests
+	uploadRetryer = client.DefaultRetryer{
+		NumMaxRetries:    10,
+		MinRetryDelay:    500 * time.Millisecond,
+		MinThrottleDelay: 1 * time.Second,
+		MaxRetryDelay:    300 * time.Second,
+		MaxThrottleDelay: 600 * time.Second,
+	}
+)
+
+func newService(u *url.URL, credential map[string]string) (*service, error) {
+	s := &service{credential: credential, getenv: backupstore.TargetEnv(credential)}
 	if u.User != nil {
 		s.Region = u.Host
 		s.Bucket = u.User.Username()
@@ -38,31 +79,76 @@ func newService(u *url.URL) (*service, error) {
 		s.Bucket = u.Host
 	}
 
-	// add custom ca to http client that is used by s3 service
-	customCerts := getCustomCerts()
-	client, err := bhttp.GetClientWithCustomCerts(customCerts)
+	// add custom ca, client certificate and proxies of the target to http client that is used by s3 service
+	tlsOpts, err := bhttp.LoadTLSOptions(s.getenv, bhttp.TLSEnvKeys{
+		CACerts:            types.AWSCert,
+		ClientCert:         types.AWSClientCert,
+		ClientKey:          types.AWSClientKey,
+		InsecureSkipVerify: types.AWSInsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tlsOpts.InsecureSkipVerify {
+		log.Warnf("Skipping TLS verification of S3 bucket %v", s.Bucket)
+	}
+	client, err := bhttp.GetClientWithOptions(tlsOpts, bhttp.LoadProxyOptions(s.credential))
 	if err != nil {
 		return nil, err
 	}
 	s.Client = client
 
-	return &s, nil
+	opts, err := parseCredentialOptions(u.Query())
+	if err != nil {
+		return nil, err
+	}
+	if s.credentials, err = s.newCredentials(opts); err != nil {
+		return nil, err
+	}
+	if s.encryption, err = s.parseEncryptionOptions(u.Query()); err != nil {
+		return nil, err
+	}
+	if s.multipart, err = parseMultipartOptions(u.Query()); err != nil {
+		return nil, err
+	}
+	if s.storageClass, err = parseStorageClass(u.Query()); err != nil {
+		return nil, err
+	}
+	if s.restore, err = parseRestoreOptions(u.Query()); err != nil {
+		return nil, err
+	}
+	if s.objectLock, err = parseObjectLockOptions(u.Query()); err != nil {
+		return nil, err
+	}
+	if s.requesterPays, err = parseRequesterPays(u.Query()); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *service) getEndpoints() []string {
+	endpoints := []string{}
+	for _, endpoint := range strings.Split(s.getenv(types.AWSEndPoint), ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
 }
 
-func (s *service) newInstance() (*s3.S3, error) {
-	// get custom endpoint
-	endpoints := os.Getenv("AWS_ENDPOINTS")
+func (s *service) newInstance(endpoint string) (*s3.S3, error) {
 	config := &aws.Config{Region: &s.Region, MaxRetries: aws.Int(3)}
 
-	virtualHostedStyleEnabled := os.Getenv(VirtualHostedStyle)
+	virtualHostedStyleEnabled := s.getenv(VirtualHostedStyle)
 	if virtualHostedStyleEnabled == "true" {
 		config.S3ForcePathStyle = aws.Bool(false)
 	} else if virtualHostedStyleEnabled == "false" {
 		config.S3ForcePathStyle = aws.Bool(true)
 	}
 
-	if endpoints != "" {
-		config.Endpoint = aws.String(endpoints)
+	if endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
 		if config.S3ForcePathStyle == nil {
 			config.S3ForcePathStyle = aws.Bool(true)
 		}
@@ -72,6 +158,10 @@ func (s *service) newInstance() (*s3.S3, error) {
 		config.HTTPClient = s.Client
 	}
 
+	if s.credentials != nil {
+		config.Credentials = s.credentials
+	}
+
 	ses, err := session.NewSession(config)
 	if err != nil {
 		return nil, err
@@ -79,7 +169,68 @@ func (s *service) newInstance() (*s3.S3, error) {
 	if _, err := ses.Config.Credentials.Get(); err != nil {
 		return nil, err
 	}
-	return s3.New(ses), nil
+	svc := s3.New(ses)
+	if s.requesterPays {
+		addRequestPayerHandler(svc)
+	}
+	return svc, nil
+}
+
+// do runs the operation against the configured endpoints. The endpoints in AWS_ENDPOINTS are
+// tried in order starting from the last working one, failing over to the next endpoint on connection errors
+// and gateway errors. The failed endpoints are skipped for a while, see endpoint_health.go.
+// The throttled requests are retried by each endpoint with the backoff.
+func (s *service) do(operation string, fn func(svc *s3.S3) error) error {
+	endpoints := s.getEndpoints()
+	if len(endpoints) == 0 {
+		endpoints = []string{""}
+	}
+
+	s.endpointLock.Lock()
+	start := s.endpointIndex % len(endpoints)
+	s.endpointLock.Unlock()
+
+	var err error
+	for _, index := range orderEndpoints(endpoints, start) {
+		endpoint := endpoints[index]
+		label := endpoint
+		if label == "" {
+			label = "default"
+		}
+
+		var svc *s3.S3
+		svc, err = s.newInstance(endpoint)
+		if err == nil {
+			err = s.doWithBackoff(operation, svc, fn)
+			s.Close()
+		}
+		metrics.S3EndpointOperations.WithLabelValues(label, operation, metrics.Result(err)).Inc()
+		if err == nil || !(isConnectionError(err) || isGatewayError(err)) {
+			if len(endpoints) > 1 {
+				markEndpointHealthy(endpoint, label)
+			}
+			s.endpointLock.Lock()
+			s.endpointIndex = index
+			s.endpointLock.Unlock()
+			return err
+		}
+
+		if len(endpoints) > 1 {
+			markEndpointUnhealthy(endpoint, label, err)
+			metrics.S3EndpointFailovers.WithLabelValues(label).Inc()
+			log.WithError(err).Warnf("Failed to connect to S3 endpoint %v for %v, failing over to the next endpoint", label, operation)
+		}
+	}
+	return err
+}
+
+func isConnectionError(err error) bool {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == request.ErrCodeRequestError {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
 func (s *service) Close() {
@@ -97,11 +248,6 @@ func parseAwsError(err error) error {
 }
 
 func (s *service) ListObjects(key, delimiter string) ([]*s3.Object, []*s3.CommonPrefix, error) {
-	svc, err := s.newInstance()
-	if err != nil {
-		return nil, nil, err
-	}
-	defer s.Close()
 	// WARNING: Directory must end in "/" in S3, otherwise it may match
 	// unintentionally
 	params := &s3.ListObjectsInput{
@@ -114,10 +260,13 @@ func (s *service) ListObjects(key, delimiter string) ([]*s3.Object, []*s3.Common
 		objects       []*s3.Object
 		commonPrefixs []*s3.CommonPrefix
 	)
-	err = svc.ListObjectsPages(params, func(page *s3.ListObjectsOutput, lastPage bool) bool {
-		objects = append(objects, page.Contents...)
-		commonPrefixs = append(commonPrefixs, page.CommonPrefixes...)
-		return !lastPage
+	err := s.do("ListObjects", func(svc *s3.S3) error {
+		objects, commonPrefixs = nil, nil
+		return svc.ListObjectsPages(params, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+			objects = append(objects, page.Contents...)
+			commonPrefixs = append(commonPrefixs, page.CommonPrefixes...)
+			return !lastPage
+		})
 	})
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to list objects with param: %+v error: %v",
@@ -126,17 +275,30 @@ func (s *service) ListObjects(key, delimiter string) ([]*s3.Object, []*s3.Common
 	return objects, commonPrefixs, nil
 }
 
-func (s *service) HeadObject(key string) (*s3.HeadObjectOutput, error) {
-	svc, err := s.newInstance()
-	if err != nil {
-		return nil, err
-	}
-	defer s.Close()
-	params := &s3.HeadObjectInput{
+// ListObjectsV2 lists all the objects with the given prefix, without grouping them by the delimiter.
+func (s *service) ListObjectsV2(prefix string) ([]*s3.Object, error) {
+	params := &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.Bucket),
-		Key:    aws.String(key),
+		Prefix: aws.String(prefix),
+	}
+
+	var objects []*s3.Object
+	err := s.do("ListObjectsV2", func(svc *s3.S3) error {
+		objects = nil
+		return svc.ListObjectsV2Pages(params, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			objects = append(objects, page.Contents...)
+			return !lastPage
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects with param: %+v error: %v",
+			params, parseAwsError(err))
 	}
-	resp, err := svc.HeadObject(params)
+	return objects, nil
+}
+
+func (s *service) HeadObject(key string) (*s3.HeadObjectOutput, error) {
+	resp, err := s.headObject(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get metadata for object: %v response: %v error: %v",
 			key, resp.String(), parseAwsError(err))
@@ -144,19 +306,43 @@ func (s *service) HeadObject(key string) (*s3.HeadObjectOutput, error) {
 	return resp, nil
 }
 
+// headObject gets the metadata of the object, returning the AWS error as is.
+func (s *service) headObject(key string) (*s3.HeadObjectOutput, error) {
+	params := &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+	s.encryption.applyToHead(params)
+	var resp *s3.HeadObjectOutput
+	err := s.do("HeadObject", func(svc *s3.S3) (err error) {
+		resp, err = svc.HeadObject(params)
+		return err
+	})
+	return resp, err
+}
+
 func (s *service) PutObject(key string, reader io.ReadSeeker) error {
-	svc, err := s.newInstance()
+	return s.PutObjectWithMetadata(key, reader, "", "")
+}
+
+// PutObjectWithMetadata puts the object with the given Content-Type and Content-Encoding, the empty values are omitted.
+// The objects at least as large as the multipart threshold are uploaded by the multipart upload.
+func (s *service) PutObjectWithMetadata(key string, reader io.ReadSeeker, contentType, contentEncoding string) error {
+	offset, err := reader.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return err
 	}
-	defer s.Close()
-
-	svc.Client.Config.Retryer = client.DefaultRetryer{
-		NumMaxRetries:    10,
-		MinRetryDelay:    500 * time.Millisecond,
-		MinThrottleDelay: 1 * time.Second,
-		MaxRetryDelay:    300 * time.Second,
-		MaxThrottleDelay: 600 * time.Second,
+	if s.multipart != nil {
+		end, err := reader.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if size := end - offset; size >= s.multipart.threshold {
+			return s.putObjectMultipart(key, reader, offset, size, contentType, contentEncoding)
+		}
 	}
 
 	params := &s3.PutObjectInput{
@@ -164,8 +350,27 @@ func (s *service) PutObject(key string, reader io.ReadSeeker) error {
 		Key:    aws.String(key),
 		Body:   reader,
 	}
+	if contentType != "" {
+		params.ContentType = aws.String(contentType)
+	}
+	if contentEncoding != "" {
+		params.ContentEncoding = aws.String(contentEncoding)
+	}
+	params.StorageClass = s.getStorageClass(key)
+	s.encryption.applyToPut(params)
+	s.objectLock.applyToPut(key, params)
 
-	resp, err := svc.PutObject(params)
+	var resp *s3.PutObjectOutput
+	err = s.do("PutObject", func(svc *s3.S3) (err error) {
+		svc.Client.Config.Retryer = uploadRetryer
+
+		// Rewind the body in case of failing over from another endpoint
+		if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		resp, err = svc.PutObject(params)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to put object: %v response: %v error: %v",
 			key, resp.String(), parseAwsError(err))
@@ -174,18 +379,41 @@ func (s *service) PutObject(key string, reader io.ReadSeeker) error {
 }
 
 func (s *service) GetObject(key string) (io.ReadCloser, error) {
-	svc, err := s.newInstance()
-	if err != nil {
-		return nil, err
+	return s.getObject(key, nil)
+}
+
+// GetObjectRange gets the data range of the object, a negative length reads the data until the end.
+func (s *service) GetObjectRange(key string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		if length == 0 {
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
 	}
-	defer s.Close()
+	return s.getObject(key, aws.String(byteRange))
+}
 
+func (s *service) getObject(key string, byteRange *string) (io.ReadCloser, error) {
 	params := &s3.GetObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key),
+		Range:  byteRange,
+	}
+	s.encryption.applyToGet(params)
+
+	var resp *s3.GetObjectOutput
+	err := s.do("GetObject", func(svc *s3.S3) (err error) {
+		// Explicitly request the stored data, otherwise the HTTP transport transparently
+		// decompresses the objects stored with the gzip Content-Encoding
+		resp, err = svc.GetObjectWithContext(aws.BackgroundContext(), params, request.WithSetRequestHeaders(map[string]string{
+			"Accept-Encoding": "identity",
+		}))
+		return err
+	})
+	if isInvalidObjectStateError(err) {
+		return nil, &archivedObjectError{key: key}
 	}
-
-	resp, err := svc.GetObject(params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object: %v response: %v error: %v",
 			key, resp.String(), parseAwsError(err))
@@ -200,18 +428,29 @@ func (s *service) DeleteObjects(key string) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to list objects with prefix %v before removing them", key)
 	}
-
-	svc, err := s.newInstance()
-	if err != nil {
-		return errors.Wrap(err, "failed to get a new s3 client instance before removing objects")
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, aws.StringValue(object.Key))
+	}
+	// The objects are all checked before removing any, so a directory isn't partially removed
+	if failures := s.checkNotRetained(keys); len(failures) > 0 {
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err, failed := failures[key]; failed {
+				return err
+			}
+		}
 	}
-	defer s.Close()
 
 	var deletionFailures []string
 	for _, object := range objects {
-		resp, err := svc.DeleteObject(&s3.DeleteObjectInput{
-			Bucket: aws.String(s.Bucket),
-			Key:    object.Key,
+		var resp *s3.DeleteObjectOutput
+		err := s.do("DeleteObject", func(svc *s3.S3) (err error) {
+			resp, err = svc.DeleteObject(&s3.DeleteObjectInput{
+				Bucket: aws.String(s.Bucket),
+				Key:    object.Key,
+			})
+			return err
 		})
 
 		if err != nil {
@@ -227,3 +466,147 @@ func (s *service) DeleteObjects(key string) error {
 
 	return nil
 }
+
+// ListDeletedObjectVersions returns the latest version of the objects with the given prefix
+// whose current version is a delete marker.
+func (s *service) ListDeletedObjectVersions(prefix string) ([]*s3.ObjectVersion, []*s3.DeleteMarkerEntry, error) {
+	params := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	var (
+		deleteMarkers  map[string]*s3.DeleteMarkerEntry
+		latestVersions map[string]*s3.ObjectVersion
+	)
+	err := s.do("ListObjectVersions", func(svc *s3.S3) error {
+		deleteMarkers = map[string]*s3.DeleteMarkerEntry{}
+		latestVersions = map[string]*s3.ObjectVersion{}
+		return svc.ListObjectVersionsPages(params, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			for _, marker := range page.DeleteMarkers {
+				if aws.BoolValue(marker.IsLatest) {
+					deleteMarkers[aws.StringValue(marker.Key)] = marker
+				}
+			}
+			for _, version := range page.Versions {
+				key := aws.StringValue(version.Key)
+				latest, exists := latestVersions[key]
+				if !exists || aws.TimeValue(version.LastModified).After(aws.TimeValue(latest.LastModified)) {
+					latestVersions[key] = version
+				}
+			}
+			return !lastPage
+		})
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list object versions with param: %+v error: %v",
+			params, parseAwsError(err))
+	}
+
+	var (
+		versions []*s3.ObjectVersion
+		markers  []*s3.DeleteMarkerEntry
+	)
+	for key, marker := range deleteMarkers {
+		version, exists := latestVersions[key]
+		if !exists {
+			continue
+		}
+		versions = append(versions, version)
+		markers = append(markers, marker)
+	}
+	return versions, markers, nil
+}
+
+// RestoreObjectVersion copies the given version of the object over its current version.
+func (s *service) RestoreObjectVersion(key, versionID string) error {
+	source := (&url.URL{Path: s.Bucket + "/" + key}).EscapedPath() + "?versionId=" + url.QueryEscape(versionID)
+	params := &s3.CopyObjectInput{
+		Bucket:       aws.String(s.Bucket),
+		Key:          aws.String(key),
+		CopySource:   aws.String(source),
+		StorageClass: s.getStorageClass(key),
+	}
+	s.encryption.applyToCopy(params)
+	s.objectLock.applyToCopy(key, params)
+	var resp *s3.CopyObjectOutput
+	err := s.do("CopyObject", func(svc *s3.S3) (err error) {
+		resp, err = svc.CopyObject(params)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore object: %v version: %v response: %v error: %v",
+			key, versionID, resp.String(), parseAwsError(err))
+	}
+	return nil
+}
+
+// CopyObject copies the object to the destination key inside the bucket.
+func (s *service) CopyObject(srcKey, dstKey string) error {
+	source := (&url.URL{Path: s.Bucket + "/" + srcKey}).EscapedPath()
+	params := &s3.CopyObjectInput{
+		Bucket:       aws.String(s.Bucket),
+		Key:          aws.String(dstKey),
+		CopySource:   aws.String(source),
+		StorageClass: s.getStorageClass(dstKey),
+	}
+	s.encryption.applyToCopy(params)
+	s.objectLock.applyToCopy(dstKey, params)
+	var resp *s3.CopyObjectOutput
+	err := s.do("CopyObject", func(svc *s3.S3) (err error) {
+		resp, err = svc.CopyObject(params)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %v to: %v response: %v error: %v",
+			srcKey, dstKey, resp.String(), parseAwsError(err))
+	}
+	return nil
+}
+
+// DeleteObjectsBatch deletes the objects by the batches of maxDeleteObjectsBatch keys, and returns the errors of
+// the keys which cannot be deleted. The missing keys are not reported by S3.
+func (s *service) DeleteObjectsBatch(keys []string) map[string]error {
+	failures := s.checkNotRetained(keys)
+	if len(failures) > 0 {
+		removable := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if _, failed := failures[key]; !failed {
+				removable = append(removable, key)
+			}
+		}
+		keys = removable
+	}
+
+	for start := 0; start < len(keys); start += maxDeleteObjectsBatch {
+		end := start + maxDeleteObjectsBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		var resp *s3.DeleteObjectsOutput
+		err := s.do("DeleteObjects", func(svc *s3.S3) (err error) {
+			resp, err = svc.DeleteObjects(&s3.DeleteObjectsInput{
+				Bucket: aws.String(s.Bucket),
+				Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+			})
+			return err
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to delete objects error: %v", parseAwsError(err))
+			for _, key := range keys[start:end] {
+				failures[key] = err
+			}
+			continue
+		}
+		for _, deleteErr := range resp.Errors {
+			failures[aws.StringValue(deleteErr.Key)] = fmt.Errorf("failed to delete object: %v error: %v %v",
+				aws.StringValue(deleteErr.Key), aws.StringValue(deleteErr.Code), aws.StringValue(deleteErr.Message))
+		}
+	}
+	return failures
+}
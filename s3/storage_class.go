@@ -0,0 +1,41 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/longhorn/backupstore"
+)
+
+const (
+	// StorageClassOption is the backup target URL query parameter setting the storage class of the block
+	// objects, e.g. s3://bucket@us-east-1/path/?storageClass=STANDARD_IA. The other objects, e.g. the configs
+	// and the locks, are kept in the default storage class since they're small and frequently accessed.
+	StorageClassOption = "storageClass"
+)
+
+func parseStorageClass(values url.Values) (string, error) {
+	storageClass := values.Get(StorageClassOption)
+	if storageClass == "" {
+		return "", nil
+	}
+	for _, supported := range s3.StorageClass_Values() {
+		if storageClass == supported {
+			return storageClass, nil
+		}
+	}
+	return "", fmt.Errorf("invalid %v option %v in backup target URL, must be one of %v", StorageClassOption,
+		storageClass, strings.Join(s3.StorageClass_Values(), ", "))
+}
+
+// getStorageClass returns the storage class of the object, or nil for the default storage class.
+func (s *service) getStorageClass(key string) *string {
+	if s.storageClass == "" || !strings.HasSuffix(key, backupstore.BLK_SUFFIX) {
+		return nil
+	}
+	return aws.String(s.storageClass)
+}
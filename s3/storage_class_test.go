@@ -0,0 +1,50 @@
+package s3
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStorageClass(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		query        string
+		storageClass string
+		valid        bool
+	}{
+		{"", "", true},
+		{"storageClass=STANDARD_IA", s3.StorageClassStandardIa, true},
+		{"storageClass=GLACIER_IR", s3.StorageClassGlacierIr, true},
+		{"storageClass=DEEP_ARCHIVE", s3.StorageClassDeepArchive, true},
+		{"storageClass=standard_ia", "", false},
+		{"storageClass=COLD", "", false},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		assert.NoError(err, tc.query)
+		storageClass, err := parseStorageClass(values)
+		if !tc.valid {
+			assert.ErrorContains(err, "must be one of", tc.query)
+			continue
+		}
+		assert.NoError(err, tc.query)
+		assert.Equal(tc.storageClass, storageClass, tc.query)
+	}
+}
+
+func TestGetStorageClass(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &service{}
+	assert.Nil(s.getStorageClass("backupstore/volumes/00/00/pvc-1/blocks/00/00/0000.blk"))
+
+	// Only the block objects are written in the storage class
+	s.storageClass = s3.StorageClassStandardIa
+	assert.Equal(aws.String(s3.StorageClassStandardIa), s.getStorageClass("backupstore/volumes/00/00/pvc-1/blocks/00/00/0000.blk"))
+	assert.Nil(s.getStorageClass("backupstore/volumes/00/00/pvc-1/volume.cfg"))
+	assert.Nil(s.getStorageClass("backupstore/volumes/00/00/pvc-1/locks/lock-0000.lck"))
+}
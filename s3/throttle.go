@@ -0,0 +1,185 @@
+package s3
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/metrics"
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// The throttled requests are retried with the exponential backoff and the jitter, instead of by the
+	// retryer of the SDK. The retries stop once the total wait would exceed maxThrottleWait.
+	maxThrottleRetries = 8
+	minThrottleBackoff = 1 * time.Second
+	maxThrottleBackoff = 60 * time.Second
+	maxThrottleWait    = 5 * time.Minute
+
+	// throttleDecreaseInterval is the min interval between the decreases of the concurrency limit, so the
+	// requests throttled at once only decrease the limit once
+	throttleDecreaseInterval = 1 * time.Second
+
+	errCodeSlowDown = "SlowDown"
+)
+
+var (
+	concurrencyLimitersLock sync.Mutex
+	// concurrencyLimiters are the concurrency limiters of the buckets, shared by the drivers of the same bucket
+	// since the rate limits are applied per bucket
+	concurrencyLimiters = map[string]*concurrencyLimiter{}
+)
+
+// concurrencyLimiter limits the concurrent requests to a bucket once the requests are throttled. The limit is
+// halved by the throttling and increased by one per limit of the successful requests, until it's recovered to
+// the concurrency when the throttling started and the requests are unlimited again.
+type concurrencyLimiter struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	bucket   string
+	inFlight int
+	// limit is the max concurrent requests, 0 if unlimited
+	limit        float64
+	peak         int
+	lastDecrease time.Time
+}
+
+func getConcurrencyLimiter(bucket string) *concurrencyLimiter {
+	concurrencyLimitersLock.Lock()
+	defer concurrencyLimitersLock.Unlock()
+
+	limiter, exists := concurrencyLimiters[bucket]
+	if !exists {
+		limiter = &concurrencyLimiter{bucket: bucket}
+		limiter.cond = sync.NewCond(&limiter.lock)
+		concurrencyLimiters[bucket] = limiter
+	}
+	return limiter
+}
+
+func (l *concurrencyLimiter) acquire() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for l.limit > 0 && l.inFlight >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+func (l *concurrencyLimiter) release(throttled bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	inFlight := l.inFlight
+	l.inFlight--
+	switch {
+	case throttled:
+		l.decrease(inFlight)
+	case l.limit > 0:
+		l.limit += 1 / l.limit
+		if l.limit >= float64(l.peak) {
+			log.Infof("Removed concurrency limit of S3 bucket %v after throttling", l.bucket)
+			l.limit = 0
+		}
+	}
+	metrics.S3ConcurrencyLimit.WithLabelValues(l.bucket).Set(float64(int(l.limit)))
+	l.cond.Broadcast()
+}
+
+// decrease halves the limit, the concurrency of the throttled request is the starting limit if it's unlimited.
+func (l *concurrencyLimiter) decrease(inFlight int) {
+	now := util.GetClock().Now()
+	if l.limit > 0 && now.Sub(l.lastDecrease) < throttleDecreaseInterval {
+		return
+	}
+	current := l.limit
+	if current == 0 {
+		l.peak = inFlight
+		current = float64(inFlight)
+	}
+	l.limit = current / 2
+	if l.limit < 1 {
+		l.limit = 1
+	}
+	l.lastDecrease = now
+	log.Warnf("Reduced concurrency limit of S3 bucket %v to %v after throttling", l.bucket, int(l.limit))
+}
+
+// isThrottleError returns true if the request is rejected by the rate limits, e.g. 503 SlowDown.
+func isThrottleError(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) &&
+		(reqErr.StatusCode() == http.StatusServiceUnavailable || reqErr.StatusCode() == http.StatusTooManyRequests) {
+		return true
+	}
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && (awsErr.Code() == errCodeSlowDown || request.IsErrorThrottle(awsErr))
+}
+
+// getMaxThrottleBackoff returns the exponential backoff of the attempt before the jitter.
+func getMaxThrottleBackoff(attempt int) time.Duration {
+	if attempt >= 6 {
+		return maxThrottleBackoff
+	}
+	backoff := minThrottleBackoff << attempt
+	if backoff > maxThrottleBackoff {
+		backoff = maxThrottleBackoff
+	}
+	return backoff
+}
+
+// getThrottleBackoff returns the exponential backoff of the attempt with the jitter, so the throttled requests
+// are not retried at once.
+func getThrottleBackoff(attempt int) time.Duration {
+	backoff := getMaxThrottleBackoff(attempt)
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
+
+// throttleRetryer is the retryer of the SDK not retrying the throttled requests, which are retried by
+// doWithBackoff under the concurrency limit instead.
+type throttleRetryer struct {
+	request.Retryer
+}
+
+func (r throttleRetryer) ShouldRetry(req *request.Request) bool {
+	return !isThrottleError(req.Error) && r.Retryer.ShouldRetry(req)
+}
+
+// doWithBackoff runs the operation under the concurrency limit of the bucket, retrying it if it's throttled.
+func (s *service) doWithBackoff(operation string, svc *s3.S3, fn func(svc *s3.S3) error) error {
+	if _, ok := svc.Client.Retryer.(throttleRetryer); !ok {
+		svc.Client.Retryer = throttleRetryer{Retryer: svc.Client.Retryer}
+	}
+
+	limiter := getConcurrencyLimiter(s.Bucket)
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		limiter.acquire()
+		err := fn(svc)
+		throttled := isThrottleError(err)
+		limiter.release(throttled)
+		if !throttled {
+			return err
+		}
+
+		metrics.S3ThrottledRequests.WithLabelValues(operation).Inc()
+		backoff := getThrottleBackoff(attempt)
+		if attempt >= maxThrottleRetries || waited+backoff > maxThrottleWait {
+			log.WithError(parseAwsError(err)).Warnf("S3 %v is still throttled by bucket %v after %v retries in %v",
+				operation, s.Bucket, attempt, waited)
+			return err
+		}
+		log.WithError(parseAwsError(err)).Warnf("S3 %v is throttled by bucket %v, retrying in %v", operation,
+			s.Bucket, backoff)
+		util.GetClock().Sleep(backoff)
+		waited += backoff
+	}
+}
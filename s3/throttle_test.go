@@ -0,0 +1,192 @@
+package s3
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func newThrottleError() error {
+	return awserr.NewRequestFailure(awserr.New(errCodeSlowDown, "Please reduce your request rate.", nil),
+		http.StatusServiceUnavailable, "request-id")
+}
+
+func newTestS3(t *testing.T) *s3.S3 {
+	ses, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(3),
+	})
+	assert.NoError(t, err)
+	return s3.New(ses)
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		attempt int
+		backoff time.Duration
+	}{
+		{0, 1 * time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 16 * time.Second},
+		{5, 32 * time.Second},
+		{6, 60 * time.Second},
+		{7, 60 * time.Second},
+		{100, 60 * time.Second},
+	} {
+		assert.Equal(tc.backoff, getMaxThrottleBackoff(tc.attempt), "attempt %v", tc.attempt)
+		for i := 0; i < 10; i++ {
+			backoff := getThrottleBackoff(tc.attempt)
+			assert.GreaterOrEqual(backoff, tc.backoff/2, "attempt %v", tc.attempt)
+			assert.Less(backoff, tc.backoff, "attempt %v", tc.attempt)
+		}
+	}
+}
+
+func TestIsThrottleError(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		err       error
+		throttled bool
+	}{
+		{nil, false},
+		{errors.New("connection reset"), false},
+		{newThrottleError(), true},
+		{awserr.NewRequestFailure(awserr.New("TooManyRequests", "", nil), http.StatusTooManyRequests, ""), true},
+		{awserr.New(errCodeSlowDown, "", nil), true},
+		{awserr.NewRequestFailure(awserr.New("NoSuchKey", "", nil), http.StatusNotFound, ""), false},
+		{awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusInternalServerError, ""), false},
+	} {
+		assert.Equal(tc.throttled, isThrottleError(tc.err), "error %v", tc.err)
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := util.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	limiter := getConcurrencyLimiter("limiter")
+	for _, tc := range []struct {
+		name      string
+		inFlight  int
+		throttled bool
+		advance   time.Duration
+		limit     int
+	}{
+		{"unlimited without throttling", 8, false, 0, 0},
+		{"halved from the concurrency of the throttled request", 8, true, 0, 4},
+		{"decreased once by the requests throttled at once", 4, true, 0, 4},
+		{"halved again after the interval", 4, true, throttleDecreaseInterval, 2},
+		{"not below one", 1, true, throttleDecreaseInterval, 1},
+		{"increased by the successful requests", 1, false, 0, 2},
+	} {
+		clock.Advance(tc.advance)
+		// The request is released with the others still in flight
+		limiter.inFlight = tc.inFlight
+		limiter.release(tc.throttled)
+		assert.Equal(tc.inFlight-1, limiter.inFlight, tc.name)
+		assert.Equal(tc.limit, int(limiter.limit), tc.name)
+		limiter.inFlight = 0
+	}
+
+	// The limit is removed once it's recovered to the concurrency when the throttling started
+	for i := 0; i < 100 && limiter.limit > 0; i++ {
+		limiter.acquire()
+		limiter.release(false)
+	}
+	assert.Equal(0, int(limiter.limit))
+	assert.Equal(0, limiter.inFlight)
+}
+
+func TestDoWithBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := util.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	s := &service{Bucket: "do-with-backoff"}
+	limiter := getConcurrencyLimiter(s.Bucket)
+
+	// The throttled request is retried until it succeeds, and the limiter is released by each attempt
+	calls := 0
+	start := clock.Now()
+	err := s.doWithBackoff("PutObject", newTestS3(t), func(svc *s3.S3) error {
+		assert.Equal(1, limiter.inFlight)
+		calls++
+		if calls < 3 {
+			return newThrottleError()
+		}
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(3, calls)
+	assert.Equal(0, limiter.inFlight)
+	assert.GreaterOrEqual(clock.Now().Sub(start), 1500*time.Millisecond)
+
+	// The other errors are returned without the retries
+	calls = 0
+	notFound := awserr.NewRequestFailure(awserr.New("NoSuchKey", "", nil), http.StatusNotFound, "")
+	err = s.doWithBackoff("GetObject", newTestS3(t), func(svc *s3.S3) error {
+		calls++
+		return notFound
+	})
+	assert.Equal(notFound, err)
+	assert.Equal(1, calls)
+	assert.Equal(0, limiter.inFlight)
+
+	// The retries are bounded by the total wait
+	calls = 0
+	start = clock.Now()
+	err = s.doWithBackoff("PutObject", newTestS3(t), func(svc *s3.S3) error {
+		calls++
+		return newThrottleError()
+	})
+	assert.True(isThrottleError(err))
+	assert.LessOrEqual(calls, maxThrottleRetries+1)
+	assert.LessOrEqual(clock.Now().Sub(start), maxThrottleWait)
+	assert.Equal(0, limiter.inFlight)
+}
+
+func TestThrottleRetryer(t *testing.T) {
+	assert := assert.New(t)
+
+	svc := newTestS3(t)
+	assert.NoError(new(service).doWithBackoff("HeadObject", svc, func(svc *s3.S3) error { return nil }))
+	retryer, ok := svc.Client.Retryer.(throttleRetryer)
+	assert.True(ok)
+	assert.Equal(3, retryer.MaxRetries())
+
+	// The throttled requests are only retried by doWithBackoff, the SDK still retries the other errors
+	throttled := &request.Request{
+		Error:        newThrottleError(),
+		HTTPResponse: &http.Response{StatusCode: http.StatusServiceUnavailable},
+	}
+	assert.True(client.DefaultRetryer{NumMaxRetries: 3}.ShouldRetry(throttled))
+	assert.False(retryer.ShouldRetry(throttled))
+	internal := &request.Request{
+		Error:        awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusInternalServerError, ""),
+		HTTPResponse: &http.Response{StatusCode: http.StatusInternalServerError},
+	}
+	assert.True(retryer.ShouldRetry(internal))
+}
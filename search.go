@@ -0,0 +1,121 @@
+package backupstore
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+// BackupQuery is the criteria of SearchBackups. The zero values match all the backups.
+type BackupQuery struct {
+	// VolumeNameGlob and SnapshotNameGlob match the names in the syntax of path.Match
+	VolumeNameGlob   string
+	SnapshotNameGlob string
+	// Labels are the labels the backups must have with the same values
+	Labels map[string]string
+	// CreatedAfter and CreatedBefore bound the creation time of the backups, both are inclusive
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+func (q *BackupQuery) validate() error {
+	for _, glob := range []string{q.VolumeNameGlob, q.SnapshotNameGlob} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid name glob %v: %v", glob, err)
+		}
+	}
+	return q.createdRange().Validate()
+}
+
+func (q *BackupQuery) createdRange() util.TimeRange {
+	return util.TimeRange{After: q.CreatedAfter, Before: q.CreatedBefore}
+}
+
+func (q *BackupQuery) matches(backup *Backup) bool {
+	if q.SnapshotNameGlob != "" {
+		if matched, _ := path.Match(q.SnapshotNameGlob, backup.SnapshotName); !matched {
+			return false
+		}
+	}
+	for key, value := range q.Labels {
+		if v, exists := backup.Labels[key]; !exists || v != value {
+			return false
+		}
+	}
+	return q.createdRange().ContainsTimestamp(backup.CreatedTime)
+}
+
+// SearchBackups returns the completed backups in the backup target matching the query, sorted by the creation
+// time from the latest. The volumes are filtered by the name before loading any backup, and the backup configs
+// of the remaining volumes are loaded concurrently.
+func SearchBackups(destURL string, query BackupQuery) ([]*BackupInfo, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	listing, err := ListVolumes(destURL, ListOptions{NameGlob: query.VolumeNameGlob})
+	if err != nil {
+		return nil, err
+	}
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	jobQueues := workerpool.New(runtime.NumCPU() * 16)
+	defer jobQueues.StopWait()
+
+	var (
+		lock    sync.Mutex
+		results []*BackupInfo
+		errs    []error
+		wg      sync.WaitGroup
+	)
+	for volumeName, volumeInfo := range listing.Volumes {
+		if len(volumeInfo.Backups) == 0 {
+			continue
+		}
+		volume, err := loadVolume(driver, volumeName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load volume %v", volumeName)
+		}
+		for backupName := range volumeInfo.Backups {
+			backupName := backupName
+			wg.Add(1)
+			jobQueues.Submit(func() {
+				defer wg.Done()
+				backup, err := loadBackup(driver, backupName, volume.Name)
+				lock.Lock()
+				defer lock.Unlock()
+				if err != nil {
+					errs = append(errs, errors.Wrapf(err, "failed to load backup %v of volume %v", backupName, volume.Name))
+					return
+				}
+				if !isBackupInProgress(backup) && query.matches(backup) {
+					results = append(results, fillFullBackupInfo(backup, volume, driver.GetURL()))
+				}
+			})
+		}
+	}
+	wg.Wait()
+
+	// The backups which cannot be loaded are skipped, so a corrupted backup doesn't break the search
+	for _, err := range errs {
+		log.WithError(err).Warn("Skipped backup in search")
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Created == results[j].Created {
+			return results[i].URL < results[j].URL
+		}
+		return results[i].Created > results[j].Created
+	})
+	return results, nil
+}
@@ -0,0 +1,65 @@
+package backupstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchBackups(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	backups := map[string]string{
+		"backup-1": `{"Name":"backup-1","VolumeName":"pvc-1","SnapshotName":"snap-1","CreatedTime":"2021-06-01T08:00:00Z","Labels":{"app":"postgres"}}`,
+		"backup-2": `{"Name":"backup-2","VolumeName":"pvc-1","SnapshotName":"snap-2","CreatedTime":"2021-06-08T08:00:00Z","Labels":{"app":"postgres"}}`,
+		"backup-3": `{"Name":"backup-3","VolumeName":"pvc-1","SnapshotName":"snap-3","CreatedTime":"2021-06-08T09:00:00Z","Labels":{"app":"mysql"}}`,
+		"backup-4": `{"Name":"backup-4","VolumeName":"pvc-1","SnapshotName":"snap-4","Labels":{"app":"postgres"}}`,
+	}
+	err := m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1","Size":"4096"}`), 0644)
+	assert.NoError(err)
+	for name, cfg := range backups {
+		err = afero.WriteFile(m.fs, getBackupConfigPath(name, "pvc-1"), []byte(cfg), 0644)
+		assert.NoError(err)
+	}
+	err = saveVolume(m, &Volume{Name: "data-1", Size: DEFAULT_BLOCK_SIZE})
+	assert.NoError(err)
+
+	// The in progress backup is skipped
+	results, err := SearchBackups(mockDriverURL, BackupQuery{Labels: map[string]string{"app": "postgres"}})
+	assert.NoError(err)
+	assert.Len(results, 2)
+	assert.Equal("backup-2", results[0].Name)
+	assert.Equal("backup-1", results[1].Name)
+	assert.Equal("pvc-1", results[0].VolumeName)
+
+	results, err = SearchBackups(mockDriverURL, BackupQuery{
+		VolumeNameGlob: "pvc-*",
+		CreatedAfter:   time.Date(2021, 6, 8, 0, 0, 0, 0, time.UTC),
+		CreatedBefore:  time.Date(2021, 6, 9, 0, 0, 0, 0, time.UTC),
+	})
+	assert.NoError(err)
+	assert.Len(results, 2)
+	assert.Equal("backup-3", results[0].Name)
+
+	results, err = SearchBackups(mockDriverURL, BackupQuery{SnapshotNameGlob: "snap-1"})
+	assert.NoError(err)
+	assert.Len(results, 1)
+
+	results, err = SearchBackups(mockDriverURL, BackupQuery{VolumeNameGlob: "data-*"})
+	assert.NoError(err)
+	assert.Empty(results)
+
+	_, err = SearchBackups(mockDriverURL, BackupQuery{
+		CreatedAfter:  time.Date(2021, 6, 9, 0, 0, 0, 0, time.UTC),
+		CreatedBefore: time.Date(2021, 6, 8, 0, 0, 0, 0, time.UTC),
+	})
+	assert.Error(err)
+}
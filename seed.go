@@ -0,0 +1,80 @@
+package backupstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// loadSeedBlocks lists the blocks of the seed volume for the first backup of the volume, so the blocks
+// already stored for the seed volume are copied inside the backup target instead of uploaded.
+func loadSeedBlocks(bsDriver BackupStoreDriver, config *DeltaBackupConfig, volume *Volume) error {
+	config.seedBlocks = nil
+	if config.SeedVolumeName == "" {
+		return nil
+	}
+	if config.SeedVolumeName == volume.Name {
+		return fmt.Errorf("cannot seed volume %v from itself", volume.Name)
+	}
+	if volume.LastBackupName != "" || volume.BlockCount != 0 {
+		log.Infof("Skipped seeding volume %v from volume %v since it already has backups", volume.Name, config.SeedVolumeName)
+		return nil
+	}
+
+	seed, err := loadVolume(bsDriver, config.SeedVolumeName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load seed volume %v", config.SeedVolumeName)
+	}
+	// The blocks of the same checksum must be compressed in the same way to be shared
+	if seed.CompressionMethod != volume.CompressionMethod {
+		return fmt.Errorf("cannot seed volume %v compressed by %v from volume %v compressed by %v",
+			volume.Name, volume.CompressionMethod, seed.Name, seed.CompressionMethod)
+	}
+	manifest, err := getBlockManifest(bsDriver, seed.Name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get block manifest of seed volume %v", seed.Name)
+	}
+	config.seedBlocks = manifest
+
+	log.Infof("Seeding volume %v from %v blocks of volume %v", volume.Name, len(manifest), seed.Name)
+	return nil
+}
+
+// copyBlockFile copies the block file inside the backup target, through the client if the driver cannot
+// copy the objects in the backend.
+func copyBlockFile(driver BackupStoreDriver, src, dst, compressionMethod string) error {
+	if copier, ok := findBackendDriver[CopyingBackupStoreDriver](driver); ok {
+		return copier.Copy(src, dst)
+	}
+
+	rc, err := driver.Read(src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read block file %v", src)
+	}
+	return WriteCompressedObject(driver, dst, bytes.NewReader(data), compressionMethod)
+}
+
+// copyObject copies the object by the wrapped driver, through the client if it cannot copy the objects in the
+// backend.
+func copyObject(driver BackupStoreDriver, src, dst string) error {
+	if copier, ok := findDriver[CopyingBackupStoreDriver](driver); ok {
+		return copier.Copy(src, dst)
+	}
+	rc, err := driver.Read(src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %v", src)
+	}
+	return driver.Write(dst, bytes.NewReader(data))
+}
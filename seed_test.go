@@ -0,0 +1,138 @@
+package backupstore
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+const copyingMockDriverName = "copyingmock"
+
+// copyingMockDriver copies the files in the backend.
+type copyingMockDriver struct {
+	*mockStoreDriver
+	copies int
+}
+
+func (d *copyingMockDriver) Copy(src, dst string) error {
+	d.copies++
+	data, err := afero.ReadFile(d.fs, src)
+	if err != nil {
+		return err
+	}
+	return d.Write(dst, bytes.NewReader(data))
+}
+
+func TestPerformBackupSeedVolume(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	blockSize := int64(DEFAULT_BLOCK_SIZE)
+	seeded, changed := bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE), bytes.Repeat([]byte{'b'}, DEFAULT_BLOCK_SIZE)
+	data := append(append([]byte{}, seeded...), changed...)
+
+	err := saveVolume(m, &Volume{Name: "pvc-old", Size: blockSize, CompressionMethod: "lz4"})
+	assert.NoError(err)
+	// The seed block is copied as is, which tells it from the uploaded one
+	err = afero.WriteFile(m.fs, getBlockFilePath("pvc-old", util.GetChecksum(seeded)), []byte("seed"), 0644)
+	assert.NoError(err)
+
+	volume := &Volume{Name: "pvc-new", Size: int64(len(data)), CompressionMethod: "lz4"}
+	err = saveVolume(m, volume)
+	assert.NoError(err)
+
+	config := &DeltaBackupConfig{
+		Volume:         volume,
+		Snapshot:       &Snapshot{Name: "snap-1", CreatedTime: "2021-06-07T08:00:00Z"},
+		DestURL:        mockDriverURL,
+		DeltaOps:       &memorySnapshotOps{data: data},
+		SeedVolumeName: "pvc-old",
+	}
+	err = loadSeedBlocks(m, config, volume)
+	assert.NoError(err)
+	assert.Len(config.seedBlocks, 1)
+
+	deltaBackup := &Backup{
+		Name:              "backup-1",
+		VolumeName:        volume.Name,
+		CompressionMethod: volume.CompressionMethod,
+		Blocks:            []BlockMapping{},
+		ProcessingBlocks: &ProcessingBlocks{
+			blocks: map[string][]*BlockMapping{},
+		},
+	}
+	delta := &types.Mappings{
+		Mappings:  []types.Mapping{{Offset: 0, Size: int64(len(data))}},
+		BlockSize: blockSize,
+	}
+	_, _, err = performBackup(m, config, delta, deltaBackup, nil)
+	assert.NoError(err)
+
+	copied, err := afero.ReadFile(m.fs, getBlockFilePath(volume.Name, util.GetChecksum(seeded)))
+	assert.NoError(err)
+	assert.Equal("seed", string(copied))
+	assert.True(m.FileExists(getBlockFilePath(volume.Name, util.GetChecksum(changed))))
+
+	backup, err := loadBackup(m, "backup-1", volume.Name)
+	assert.NoError(err)
+	assert.Len(backup.Blocks, 2)
+	compressed, err := util.CompressData("lz4", changed)
+	assert.NoError(err)
+	size, err := getTransferDataSize(compressed)
+	assert.NoError(err)
+	assert.Equal(size, backup.NewlyUploadedDataSize)
+	volume, err = loadVolume(m, volume.Name)
+	assert.NoError(err)
+	assert.Equal(int64(2), volume.BlockCount)
+
+	// The volume with backups is not seeded again
+	err = loadSeedBlocks(m, config, volume)
+	assert.NoError(err)
+	assert.Nil(config.seedBlocks)
+
+	config.SeedVolumeName = volume.Name
+	assert.Error(loadSeedBlocks(m, config, &Volume{Name: volume.Name}))
+	config.SeedVolumeName = "pvc-old"
+	assert.Error(loadSeedBlocks(m, config, &Volume{Name: "pvc-gzip", CompressionMethod: "gzip"}))
+}
+
+func TestCopyBlockFileThroughWrappers(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	d := &copyingMockDriver{mockStoreDriver: m}
+	assert.NoError(RegisterDriver(copyingMockDriverName, func(destURL string) (BackupStoreDriver, error) { return d, nil }))
+	defer unregisterDriver(copyingMockDriverName) // nolint:errcheck
+	destURL := copyingMockDriverName + "://localhost"
+
+	src := getBlockFilePath("pvc-old", fmt.Sprintf("%064x", 0))
+	dst := getBlockFilePath("pvc-new", fmt.Sprintf("%064x", 0))
+	assert.NoError(m.Write(src, bytes.NewReader([]byte("seed"))))
+
+	driver, err := GetBackupStoreDriver(destURL + "?" + ReadOnlyTargetOption + "=true")
+	assert.NoError(err)
+	assert.True(IsReadOnlyTargetError(copyBlockFile(driver, src, dst, "lz4")))
+	assert.False(m.FileExists(dst))
+
+	driver, err = GetBackupStoreDriver(destURL + "?" + ImmutableTargetOption + "=true")
+	assert.NoError(err)
+	assert.NoError(copyBlockFile(driver, src, dst, "lz4"))
+	assert.Equal(1, d.copies)
+	assert.True(IsImmutableTargetError(copyBlockFile(driver, src, dst, "lz4")))
+	assert.Equal(1, d.copies)
+	copied, err := afero.ReadFile(m.fs, dst)
+	assert.NoError(err)
+	assert.Equal("seed", string(copied))
+}
@@ -0,0 +1,79 @@
+package backupstore
+
+import (
+	"net/url"
+	"time"
+)
+
+// ReadOnlySession is the read-only access to a backup target for the reporting tools, e.g. the monitoring
+// dashboards pointed at a production target. The target is opened in the read-only mode, so the session never
+// creates the lock files interfering with the backups, and the mount-based drivers mount the share read-only
+// on a mount point of its own, which works without the write permission of the share.
+type ReadOnlySession struct {
+	destURL string
+	driver  BackupStoreDriver
+}
+
+// NewReadOnlySession opens the backup target in the read-only mode. The consistency probe writing the probe
+// objects is disabled for the session.
+func NewReadOnlySession(destURL string) (*ReadOnlySession, error) {
+	readOnlyURL, err := withReadOnlyOption(destURL)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := GetBackupStoreDriver(readOnlyURL)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadOnlySession{destURL: readOnlyURL, driver: driver}, nil
+}
+
+// withReadOnlyOption returns the URL with the read-only mode enabled.
+func withReadOnlyOption(destURL string) (string, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return "", err
+	}
+	v := u.Query()
+	v.Del(ConsistencyProbeOption)
+	v.Set(ReadOnlyTargetOption, "true")
+	u.RawQuery = v.Encode()
+	return u.String(), nil
+}
+
+// GetURL returns the URL of the backup target, without the read-only mode option.
+func (s *ReadOnlySession) GetURL() string {
+	return s.driver.GetURL()
+}
+
+func (s *ReadOnlySession) List(volumeName string, volumeOnly bool) (map[string]*VolumeInfo, error) {
+	return List(volumeName, s.destURL, volumeOnly)
+}
+
+func (s *ReadOnlySession) ListVolumes(opts ListOptions) (*VolumeListing, error) {
+	return ListVolumes(s.destURL, opts)
+}
+
+func (s *ReadOnlySession) ListBackups(volumeName string, asOf time.Time) ([]string, error) {
+	return ListBackups(EncodeBackupURL("", volumeName, s.destURL), asOf)
+}
+
+func (s *ReadOnlySession) SearchBackups(query BackupQuery) ([]*BackupInfo, error) {
+	return SearchBackups(s.destURL, query)
+}
+
+func (s *ReadOnlySession) InspectVolume(volumeName string) (*VolumeInfo, error) {
+	return InspectVolume(EncodeBackupURL("", volumeName, s.destURL))
+}
+
+func (s *ReadOnlySession) InspectBackup(backupName, volumeName string) (*BackupInfo, error) {
+	return InspectBackup(EncodeBackupURL(backupName, volumeName, s.destURL))
+}
+
+func (s *ReadOnlySession) GetBackupHistory(volumeName, backupName string) ([]*HistoryRecord, error) {
+	return GetBackupHistory(EncodeBackupURL("", volumeName, s.destURL), backupName)
+}
+
+func (s *ReadOnlySession) GetTargetCapacity() (*TargetCapacity, error) {
+	return GetTargetCapacity(s.destURL)
+}
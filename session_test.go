@@ -0,0 +1,48 @@
+package backupstore
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnlySession(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	err := m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"),
+		[]byte(`{"Name":"pvc-1","Size":"2147483648","CreatedTime":"2021-05-12T00:52:01Z","LastBackupName":"backup-1"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","CreatedTime":"2021-06-07T08:57:25Z"}`), 0644)
+	assert.NoError(err)
+
+	// The probe writing the probe objects is disabled
+	session, err := NewReadOnlySession(mockDriverURL + "?probe=true")
+	assert.NoError(err)
+	assert.True(IsReadOnlyTarget(session.driver))
+	assert.Equal(mockDriverURL, session.GetURL())
+
+	listing, err := session.ListVolumes(ListOptions{})
+	assert.NoError(err)
+	assert.Contains(listing.Volumes, "pvc-1")
+	volumeInfo, err := session.InspectVolume("pvc-1")
+	assert.NoError(err)
+	assert.Equal("backup-1", volumeInfo.LastBackupName)
+	backupInfo, err := session.InspectBackup("backup-1", "pvc-1")
+	assert.NoError(err)
+	assert.Equal("backup-1", backupInfo.Name)
+
+	// Nothing is written to the target by the session
+	exists, err := afero.DirExists(m.fs, getLockPath("pvc-1"))
+	assert.NoError(err)
+	assert.False(exists)
+	_, err = NewReadOnlySession("://invalid")
+	assert.Error(err)
+}
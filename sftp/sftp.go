@@ -0,0 +1,258 @@
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	mount "k8s.io/mount-utils"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/fsops"
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "sftp"})
+
+	// The SSH handshake and the authentication take longer than mounting the kernel file systems
+	defaultMountTimeout = 30 * time.Second
+)
+
+type BackupStoreDriver struct {
+	destURL      string
+	serverPath   string
+	port         string
+	mountDir     string
+	mountOptions []string
+
+	getenv func(string) string
+
+	*fsops.FileSystemOperator
+}
+
+const (
+	KIND = "sftp"
+
+	// fuseKind is the kind of the mount points of the SSHFS file systems
+	fuseKind = "fuse"
+
+	// SftpMaxConnsOption is the backup target URL query parameter setting the number of the SSH connections
+	// transferring the files concurrently, e.g. sftp://user@server:/path/?sftpMaxConns=8
+	SftpMaxConnsOption = "sftpMaxConns"
+	// SftpOptions is the backup target URL query parameter overriding the SSHFS mount options
+	SftpOptions = "sftpOptions"
+
+	defaultMaxConns = 4
+)
+
+func init() {
+	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
+	b := &BackupStoreDriver{}
+	b.FileSystemOperator = fsops.NewFileSystemOperator(b)
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+	if u.Hostname() == "" || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("SFTP path must follow format: sftp://<user>@<server-address>:/<path>/")
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("cannot find SFTP path")
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		return nil, fmt.Errorf("SFTP password must be provided by %v instead of the URL", types.SFTPPassword)
+	}
+
+	b.getenv = backupstore.TargetEnv(backupstore.GetTargetCredential(destURL))
+	b.port = u.Port()
+	b.serverPath = u.User.Username() + "@" + u.Hostname() + ":" + u.Path
+	b.destURL = KIND + "://" + u.User.Username() + "@" + u.Host + u.Path
+	b.mountDir = filepath.Join(util.GetMountDir(), KIND, strings.Replace(u.Hostname(), ".", "_", -1), u.User.Username(), u.Path)
+
+	b.mountOptions, err = getMountOptions(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.mount(); err != nil {
+		return nil, errors.Wrapf(err, "cannot mount SFTP path %v, options %v", b.serverPath, b.mountOptions)
+	}
+
+	if _, err := b.List(""); err != nil {
+		return nil, errors.Wrapf(err, "SFTP path %v doesn't exist or is not a directory", b.serverPath)
+	}
+
+	log.Infof("Loaded driver for %v", b.destURL)
+
+	return b, nil
+}
+
+// getMountOptions returns the SSHFS mount options. The broken connections are reconnected, so the backups
+// survive the server restarts, and the files are transferred over multiple connections concurrently.
+func getMountOptions(values url.Values) ([]string, error) {
+	if sftpOptions, exist := values[SftpOptions]; exist {
+		options := util.SplitMountOptions(sftpOptions)
+		log.Infof("Overriding SFTP mountOptions:  %v", options)
+		return options, nil
+	}
+
+	maxConns := defaultMaxConns
+	if value := values.Get(SftpMaxConnsOption); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid %v %v in SFTP URL", SftpMaxConnsOption, value)
+		}
+		maxConns = parsed
+	}
+	return []string{
+		"reconnect",
+		"ServerAliveInterval=15",
+		"ServerAliveCountMax=3",
+		fmt.Sprintf("max_conns=%v", maxConns),
+	}, nil
+}
+
+// credentialDir holds the private key and the known hosts of the SFTP server, it's next to the mount
+// point so each backup target has its own
+func (b *BackupStoreDriver) credentialDir() string {
+	return b.mountDir + ".ssh"
+}
+
+// getAuthOptions returns the SSH options authenticating the client and verifying the host key of the
+// server. The host key is always verified, against the known hosts in SFTP_KNOWN_HOSTS if it's set, or the
+// known hosts of the user otherwise.
+func (b *BackupStoreDriver) getAuthOptions() ([]string, string, error) {
+	options := []string{"StrictHostKeyChecking=yes"}
+	writeFile := func(name, content string) (string, error) {
+		if err := os.MkdirAll(b.credentialDir(), 0700); err != nil {
+			return "", err
+		}
+		path := filepath.Join(b.credentialDir(), name)
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		return path, os.WriteFile(path, []byte(content), 0600)
+	}
+
+	if knownHosts := b.getenv(types.SFTPKnownHosts); knownHosts != "" {
+		path, err := writeFile("known_hosts", knownHosts)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to write SFTP known hosts")
+		}
+		options = append(options, "UserKnownHostsFile="+path)
+	}
+
+	password := b.getenv(types.SFTPPassword)
+	if privateKey := b.getenv(types.SFTPPrivateKey); privateKey != "" {
+		path, err := writeFile("id", privateKey)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to write SFTP private key")
+		}
+		options = append(options, "IdentityFile="+path, "IdentitiesOnly=yes")
+	}
+	if password != "" {
+		options = append(options, "password_stdin")
+	} else {
+		// Fail instead of prompting for the password
+		options = append(options, "BatchMode=yes")
+	}
+	return options, password, nil
+}
+
+func (b *BackupStoreDriver) mount() error {
+	mounter := mount.New("")
+
+	mounted, err := util.EnsureMountPoint(fuseKind, b.mountDir, mounter, log)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return nil
+	}
+
+	authOptions, password, err := b.getAuthOptions()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Mounting SFTP path %v on mount point %v with options %+v", b.destURL, b.mountDir, b.mountOptions)
+
+	args := []string{b.serverPath, b.mountDir, "-o", strings.Join(append(b.mountOptions, authOptions...), ",")}
+	if b.port != "" {
+		args = append(args, "-p", b.port)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultMountTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sshfs", args...)
+	if password != "" {
+		cmd.Stdin = strings.NewReader(password + "\n")
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "mounting SFTP path %v on %v timed out", b.serverPath, b.mountDir)
+		}
+		return errors.Wrapf(err, "failed to mount SFTP path %v on %v: %v", b.serverPath, b.mountDir, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ShouldRemount returns true if the SSH connection is lost and cannot be reconnected.
+func (b *BackupStoreDriver) ShouldRemount(err error) bool {
+	return fsops.IsErrno(err, syscall.EIO, syscall.ENOTCONN, syscall.EHOSTDOWN)
+}
+
+// Remount cleans up the broken mount point and mounts the SFTP path again.
+func (b *BackupStoreDriver) Remount() error {
+	log.Warnf("Remounting SFTP path %v on mount point %v", b.destURL, b.mountDir)
+	return b.mount()
+}
+
+// MountPoint returns the mount point of the SFTP path.
+func (b *BackupStoreDriver) MountPoint() string {
+	return b.mountDir
+}
+
+// Mount mounts the SFTP path again after it's unmounted for being idle.
+func (b *BackupStoreDriver) Mount() error {
+	return b.mount()
+}
+
+// Unmount unmounts the idle SFTP path.
+func (b *BackupStoreDriver) Unmount() error {
+	return util.UnmountMountPoint(b.mountDir, log)
+}
+
+func (b *BackupStoreDriver) Kind() string {
+	return KIND
+}
+
+func (b *BackupStoreDriver) GetURL() string {
+	return b.destURL
+}
+
+func (b *BackupStoreDriver) LocalPath(path string) string {
+	return filepath.Join(b.mountDir, path)
+}
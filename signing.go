@@ -0,0 +1,332 @@
+package backupstore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SIGNATURE_SUFFIX is the suffix of the signature stored alongside the backup config
+	SIGNATURE_SUFFIX = ".sig"
+
+	SignatureAlgorithmEd25519 = "ed25519"
+
+	// signatureContext is prepended to the signed message, so the signatures can't be taken for the ones of
+	// other messages signed by the same key
+	signatureContext = "backupstore-backup-signature-v1"
+)
+
+// BackupSigningConfig configures the signing of the completed backup configs and the verification of the
+// signatures on inspection and restoration, so tampering with the metadata on a shared target is detected.
+type BackupSigningConfig struct {
+	// SigningKey signs the backup configs, nil disables the signing
+	SigningKey ed25519.PrivateKey
+	// TrustedKeys verify the signatures along with the public key of the signing key
+	TrustedKeys []ed25519.PublicKey
+	// RequireSignature refuses the backups without signatures, otherwise only the existing signatures are
+	// verified
+	RequireSignature bool
+}
+
+// BackupSignature is the signature of the backup config and the Merkle root of its blocks.
+type BackupSignature struct {
+	Algorithm    string
+	KeyID        string
+	ConfigDigest string
+	MerkleRoot   string
+	Signature    []byte
+}
+
+// ErrInvalidBackupSignature is returned when the signature of the backup is missing while it's required, or
+// the backup config doesn't match its signature.
+type ErrInvalidBackupSignature struct {
+	VolumeName string
+	BackupName string
+	Reason     string
+}
+
+func (e *ErrInvalidBackupSignature) Error() string {
+	return fmt.Sprintf("invalid signature of backup %v of volume %v: %v, the backup metadata may be tampered with",
+		e.BackupName, e.VolumeName, e.Reason)
+}
+
+// IsInvalidBackupSignatureError returns true if the error is caused by a missing or mismatched backup signature.
+func IsInvalidBackupSignatureError(err error) bool {
+	var signatureErr *ErrInvalidBackupSignature
+	return errors.As(err, &signatureErr)
+}
+
+var (
+	signingConfigLock sync.RWMutex
+	signingConfig     *BackupSigningConfig
+)
+
+// SetBackupSigningConfig sets the signing configuration of the backups of all the targets, nil disables the
+// signing and the verification.
+func SetBackupSigningConfig(config *BackupSigningConfig) {
+	signingConfigLock.Lock()
+	defer signingConfigLock.Unlock()
+	signingConfig = config
+}
+
+func getBackupSigningConfig() *BackupSigningConfig {
+	signingConfigLock.RLock()
+	defer signingConfigLock.RUnlock()
+	return signingConfig
+}
+
+// ParseSigningKey parses the PEM encoded PKCS #8 Ed25519 private key.
+func ParseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM signing key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse signing key")
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is %T, an Ed25519 key is required", key)
+	}
+	return privateKey, nil
+}
+
+// ParseTrustedKey parses the PEM encoded PKIX Ed25519 public key.
+func ParseTrustedKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM trusted key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse trusted key")
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("trusted key is %T, an Ed25519 key is required", key)
+	}
+	return publicKey, nil
+}
+
+func getKeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func getBackupSignaturePath(backupName, volumeName string) string {
+	return getBackupConfigPath(backupName, volumeName) + SIGNATURE_SUFFIX
+}
+
+// getBlocksMerkleRoot returns the root of the Merkle tree of the blocks sorted by offset, each leaf hashes the
+// offset and the checksum of a block.
+func getBlocksMerkleRoot(blocks []BlockMapping) string {
+	sorted := make([]BlockMapping, len(blocks))
+	copy(sorted, blocks)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	level := make([][]byte, 0, len(sorted))
+	for _, block := range sorted {
+		leaf := sha256.New()
+		leaf.Write([]byte{0})
+		binary.Write(leaf, binary.BigEndian, block.Offset) // nolint:errcheck
+		leaf.Write([]byte(block.BlockChecksum))
+		level = append(level, leaf.Sum(nil))
+	}
+	if len(level) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:])
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				// The odd node is promoted to the next level
+				next = append(next, level[i])
+				continue
+			}
+			node := sha256.New()
+			node.Write([]byte{1})
+			node.Write(level[i])
+			node.Write(level[i+1])
+			next = append(next, node.Sum(nil))
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0])
+}
+
+func getSignedMessage(configDigest, merkleRoot string) []byte {
+	return []byte(signatureContext + "\n" + configDigest + "\n" + merkleRoot)
+}
+
+// signBackup stores the signature of the config data of the completed backup if the signing is enabled.
+// Otherwise the signature of the earlier config data is removed, since it doesn't match the rewritten config
+// and the backup would be refused by the verification.
+func signBackup(driver BackupStoreDriver, backup *Backup, data []byte) error {
+	config := getBackupSigningConfig()
+	if config == nil || config.SigningKey == nil || isBackupInProgress(backup) {
+		return removeStaleBackupSignature(driver, backup)
+	}
+
+	digest := sha256.Sum256(data)
+	signature := &BackupSignature{
+		Algorithm:    SignatureAlgorithmEd25519,
+		KeyID:        getKeyID(config.SigningKey.Public().(ed25519.PublicKey)),
+		ConfigDigest: hex.EncodeToString(digest[:]),
+		MerkleRoot:   getBlocksMerkleRoot(backup.Blocks),
+	}
+	signature.Signature = ed25519.Sign(config.SigningKey, getSignedMessage(signature.ConfigDigest, signature.MerkleRoot))
+
+	j, err := json.Marshal(signature)
+	if err != nil {
+		return err
+	}
+	filePath := getBackupSignaturePath(backup.Name, backup.VolumeName)
+	if err := driver.Write(filePath, bytes.NewReader(j)); err != nil {
+		return errors.Wrapf(err, "failed to save signature of backup %v", backup.Name)
+	}
+	return nil
+}
+
+// checkBackupResignable refuses to modify the signed backup config without the signing key, since the signature
+// cannot be updated along with the config.
+func checkBackupResignable(driver BackupStoreDriver, backup *Backup) error {
+	if config := getBackupSigningConfig(); config != nil && config.SigningKey != nil {
+		return nil
+	}
+	if !driver.FileExists(getBackupSignaturePath(backup.Name, backup.VolumeName)) {
+		return nil
+	}
+	return fmt.Errorf("cannot modify signed backup %v of volume %v without signing key", backup.Name, backup.VolumeName)
+}
+
+// removeStaleBackupSignature removes the signature of the backup config which cannot be signed again.
+func removeStaleBackupSignature(driver BackupStoreDriver, backup *Backup) error {
+	filePath := getBackupSignaturePath(backup.Name, backup.VolumeName)
+	if !driver.FileExists(filePath) {
+		return nil
+	}
+	log.Warnf("Removing signature of backup %v of volume %v rewritten without signing key",
+		backup.Name, backup.VolumeName)
+	if err := driver.Remove(filePath); err != nil {
+		return errors.Wrapf(err, "failed to remove stale signature of backup %v", backup.Name)
+	}
+	return nil
+}
+
+// verifyBackupSignature verifies the signature of the config data of the completed backup if the verification
+// is enabled.
+func verifyBackupSignature(driver BackupStoreDriver, backup *Backup, data []byte) error {
+	config := getBackupSigningConfig()
+	if config == nil || isBackupInProgress(backup) {
+		return nil
+	}
+	trustedKeys := map[string]ed25519.PublicKey{}
+	for _, key := range config.TrustedKeys {
+		trustedKeys[getKeyID(key)] = key
+	}
+	if config.SigningKey != nil {
+		key := config.SigningKey.Public().(ed25519.PublicKey)
+		trustedKeys[getKeyID(key)] = key
+	}
+	if len(trustedKeys) == 0 {
+		return nil
+	}
+	fail := func(format string, v ...interface{}) error {
+		return &ErrInvalidBackupSignature{
+			VolumeName: backup.VolumeName,
+			BackupName: backup.Name,
+			Reason:     fmt.Sprintf(format, v...),
+		}
+	}
+
+	filePath := getBackupSignaturePath(backup.Name, backup.VolumeName)
+	if !driver.FileExists(filePath) {
+		if config.RequireSignature {
+			return fail("the signature is missing")
+		}
+		log.Debugf("Skipped verifying unsigned backup %v of volume %v", backup.Name, backup.VolumeName)
+		return nil
+	}
+	rc, err := driver.Read(filePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read signature of backup %v", backup.Name)
+	}
+	defer rc.Close()
+	signature := &BackupSignature{}
+	if err := json.NewDecoder(rc).Decode(signature); err != nil {
+		return fail("failed to decode the signature: %v", err)
+	}
+
+	if signature.Algorithm != SignatureAlgorithmEd25519 {
+		return fail("unsupported signature algorithm %v", signature.Algorithm)
+	}
+	key, ok := trustedKeys[signature.KeyID]
+	if !ok {
+		return fail("signed by untrusted key %v", signature.KeyID)
+	}
+	if !ed25519.Verify(key, getSignedMessage(signature.ConfigDigest, signature.MerkleRoot), signature.Signature) {
+		return fail("the signature doesn't match the signed digests")
+	}
+	digest := sha256.Sum256(data)
+	if hex.EncodeToString(digest[:]) != signature.ConfigDigest {
+		return fail("the backup config doesn't match the signed digest")
+	}
+	if getBlocksMerkleRoot(backup.Blocks) != signature.MerkleRoot {
+		return fail("the blocks don't match the signed Merkle root")
+	}
+	return nil
+}
+
+// loadVerifiedBackup loads the backup and verifies its signature if the verification is enabled, the signed
+// digest is compared with the same config data the backup is decoded from.
+func loadVerifiedBackup(driver BackupStoreDriver, backupName, volumeName string) (*Backup, error) {
+	if getBackupSigningConfig() == nil {
+		return loadBackup(driver, backupName, volumeName)
+	}
+
+	filePath := getBackupConfigPath(backupName, volumeName)
+	if !driver.FileExists(filePath) {
+		return nil, fmt.Errorf("cannot find %v in backupstore", filePath)
+	}
+	rc, err := driver.Read(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := &Backup{}
+	if err := json.Unmarshal(data, backup); err != nil {
+		return nil, err
+	}
+	// The name and the volume are verified as a part of the config data
+	if backup.Name != backupName || backup.VolumeName != volumeName {
+		return nil, &ErrInvalidBackupSignature{
+			VolumeName: volumeName,
+			BackupName: backupName,
+			Reason:     fmt.Sprintf("the config is of backup %v of volume %v", backup.Name, backup.VolumeName),
+		}
+	}
+	if err := verifyBackupSignature(driver, backup, data); err != nil {
+		return nil, err
+	}
+	fillBackupDefaults(backup)
+	return backup, nil
+}
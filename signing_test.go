@@ -0,0 +1,161 @@
+package backupstore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestBackupSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	util.SetClock(util.NewFakeClock(time.Now()))
+	defer util.SetClock(nil)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	defer SetBackupSigningConfig(nil)
+	SetBackupSigningConfig(&BackupSigningConfig{SigningKey: privateKey})
+
+	assert.NoError(saveVolume(m, &Volume{Name: "pvc-1", Size: 2 * DEFAULT_BLOCK_SIZE}))
+	backup := &Backup{Name: "backup-1", VolumeName: "pvc-1", CreatedTime: "2024-01-01T00:00:00Z",
+		Blocks: []BlockMapping{{Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: "b"}, {Offset: 0, BlockChecksum: "a"}}}
+	assert.NoError(saveBackup(m, backup))
+	signaturePath := getBackupSignaturePath("backup-1", "pvc-1")
+	assert.True(m.FileExists(signaturePath))
+
+	// The in progress backups aren't signed
+	assert.NoError(saveBackup(m, &Backup{Name: "backup-2", VolumeName: "pvc-1"}))
+	assert.False(m.FileExists(getBackupSignaturePath("backup-2", "pvc-1")))
+
+	// Only the trusted keys verify the signatures
+	SetBackupSigningConfig(&BackupSigningConfig{TrustedKeys: []ed25519.PublicKey{publicKey}})
+	loaded, err := loadVerifiedBackup(m, "backup-1", "pvc-1")
+	assert.NoError(err)
+	assert.Equal(backup.Blocks, loaded.Blocks)
+	assert.Equal(LEGACY_COMPRESSION_METHOD, loaded.CompressionMethod)
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	SetBackupSigningConfig(&BackupSigningConfig{TrustedKeys: []ed25519.PublicKey{otherKey}})
+	_, err = loadVerifiedBackup(m, "backup-1", "pvc-1")
+	assert.True(IsInvalidBackupSignatureError(err), "unexpected error %v", err)
+	assert.ErrorContains(err, "untrusted key")
+
+	// The tampered configs fail the verification
+	SetBackupSigningConfig(&BackupSigningConfig{TrustedKeys: []ed25519.PublicKey{publicKey}})
+	tampered := &Backup{Name: backup.Name, VolumeName: backup.VolumeName, CreatedTime: backup.CreatedTime,
+		Blocks: []BlockMapping{{Offset: 0, BlockChecksum: "c"}}}
+	assert.NoError(SaveConfigInBackupStore(m, getBackupConfigPath("backup-1", "pvc-1"), tampered))
+	_, err = loadVerifiedBackup(m, "backup-1", "pvc-1")
+	assert.True(IsInvalidBackupSignatureError(err), "unexpected error %v", err)
+	assert.ErrorContains(err, "doesn't match the signed digest")
+	assert.NoError(m.Write(getBackupConfigPath("backup-3", "pvc-1"), bytes.NewReader([]byte(
+		`{"Name":"backup-1","VolumeName":"pvc-1","CreatedTime":"2024-01-01T00:00:00Z"}`))))
+	_, err = loadVerifiedBackup(m, "backup-3", "pvc-1")
+	assert.ErrorContains(err, "the config is of backup backup-1")
+
+	// The unsigned backups are refused only if the signatures are required
+	SetBackupSigningConfig(&BackupSigningConfig{SigningKey: privateKey})
+	assert.NoError(saveBackup(m, backup))
+	assert.NoError(m.Remove(signaturePath))
+	_, err = loadVerifiedBackup(m, "backup-1", "pvc-1")
+	assert.NoError(err)
+	SetBackupSigningConfig(&BackupSigningConfig{SigningKey: privateKey, RequireSignature: true})
+	_, err = loadVerifiedBackup(m, "backup-1", "pvc-1")
+	assert.ErrorContains(err, "the signature is missing")
+	_, err = InspectBackup(EncodeBackupURL("backup-1", "pvc-1", mockDriverURL))
+	assert.True(IsInvalidBackupSignatureError(err), "unexpected error %v", err)
+
+	// The signature is removed along with the backup
+	assert.NoError(saveBackup(m, backup))
+	assert.NoError(removeBackup(backup, m))
+	assert.False(m.FileExists(signaturePath))
+}
+
+func TestBlocksMerkleRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	blocks := []BlockMapping{{Offset: 0, BlockChecksum: "a"}, {Offset: 1, BlockChecksum: "b"}, {Offset: 2, BlockChecksum: "c"}}
+	root := getBlocksMerkleRoot(blocks)
+	assert.Equal(root, getBlocksMerkleRoot([]BlockMapping{blocks[2], blocks[0], blocks[1]}))
+	assert.NotEqual(root, getBlocksMerkleRoot(blocks[:2]))
+	assert.NotEqual(root, getBlocksMerkleRoot([]BlockMapping{blocks[0], blocks[1], {Offset: 3, BlockChecksum: "c"}}))
+	assert.Len(getBlocksMerkleRoot(nil), 64)
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NoError(err)
+	parsed, err := ParseSigningKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	assert.NoError(err)
+	assert.Equal(privateKey, parsed)
+
+	der, err = x509.MarshalPKIXPublicKey(publicKey)
+	assert.NoError(err)
+	trusted, err := ParseTrustedKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.NoError(err)
+	assert.Equal(publicKey, trusted)
+
+	_, err = ParseSigningKey([]byte("invalid"))
+	assert.Error(err)
+	_, err = ParseTrustedKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("invalid")}))
+	assert.Error(err)
+}
+
+func TestUpdateSignedBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	defer SetBackupSigningConfig(nil)
+	SetBackupSigningConfig(&BackupSigningConfig{SigningKey: privateKey})
+
+	assert.NoError(saveVolume(m, &Volume{Name: "pvc-1", Size: DEFAULT_BLOCK_SIZE}))
+	backup := &Backup{Name: "backup-1", VolumeName: "pvc-1", CreatedTime: "2024-01-01T00:00:00Z",
+		Blocks: []BlockMapping{{Offset: 0, BlockChecksum: "a"}}}
+	assert.NoError(saveBackup(m, backup))
+	backupURL := EncodeBackupURL("backup-1", "pvc-1", mockDriverURL)
+
+	// The signed backup isn't modified without the signing key, so it's still verified
+	SetBackupSigningConfig(&BackupSigningConfig{TrustedKeys: []ed25519.PublicKey{publicKey}, RequireSignature: true})
+	_, err = UpdateBackupLabels(backupURL, map[string]string{"app": "db"}, nil, 0)
+	assert.ErrorContains(err, "without signing key")
+	loaded, err := loadVerifiedBackup(m, "backup-1", "pvc-1")
+	assert.NoError(err)
+	assert.Empty(loaded.Labels)
+
+	// The backup is signed again along with the update by the signing key
+	SetBackupSigningConfig(&BackupSigningConfig{SigningKey: privateKey, RequireSignature: true})
+	_, err = UpdateBackupLabels(backupURL, map[string]string{"app": "db"}, nil, 0)
+	assert.NoError(err)
+	loaded, err = loadVerifiedBackup(m, "backup-1", "pvc-1")
+	assert.NoError(err)
+	assert.Equal(map[string]string{"app": "db"}, loaded.Labels)
+
+	// The stale signature of the config rewritten without the signing key is removed
+	SetBackupSigningConfig(nil)
+	assert.NoError(saveBackup(m, backup))
+	assert.False(m.FileExists(getBackupSignaturePath("backup-1", "pvc-1")))
+	SetBackupSigningConfig(&BackupSigningConfig{TrustedKeys: []ed25519.PublicKey{publicKey}})
+	_, err = loadVerifiedBackup(m, "backup-1", "pvc-1")
+	assert.NoError(err)
+}
@@ -1,6 +1,7 @@
 package backupstore
 
 import (
+	"os"
 	"path/filepath"
 
 	"github.com/pkg/errors"
@@ -29,6 +30,15 @@ func CreateSingleFileBackup(volume *Volume, snapshot *Snapshot, filePath, destUR
 		return "", err
 	}
 
+	if err := CheckTargetRedirect(driver); err != nil {
+		return "", err
+	}
+
+	releaseBackupSlot := acquireBackupSlot(driver)
+	defer releaseBackupSlot()
+
+	log := log.WithField(LogFieldOperationID, NewOperationID())
+
 	if err := addVolume(driver, volume); err != nil {
 		return "", err
 	}
@@ -50,12 +60,20 @@ func CreateSingleFileBackup(volume *Volume, snapshot *Snapshot, filePath, destUR
 		Name:              util.GenerateName("backup"),
 		VolumeName:        volume.Name,
 		SnapshotName:      snapshot.Name,
-		SnapshotCreatedAt: snapshot.CreatedTime,
+		SnapshotCreatedAt: util.NormalizeTimestamp(snapshot.CreatedTime),
 		CompressionMethod: volume.CompressionMethod,
+		SourceClusterID:   GetClientID(),
+		SourceNodeID:      GetNodeID(),
 	}
 	backup.SingleFile.FilePath = getSingleFileBackupFilePath(backup)
 
-	if err := driver.Upload(filePath, backup.SingleFile.FilePath); err != nil {
+	releaseUploadSlot := acquireUploadSlot(driver)
+	if info, statErr := os.Stat(filePath); statErr == nil {
+		throttleBackupUpload(driver, info.Size())
+	}
+	err = driver.Upload(filePath, backup.SingleFile.FilePath)
+	releaseUploadSlot()
+	if err != nil {
 		return "", err
 	}
 
@@ -63,6 +81,7 @@ func CreateSingleFileBackup(volume *Volume, snapshot *Snapshot, filePath, destUR
 	if err := saveBackup(driver, backup); err != nil {
 		return "", err
 	}
+	recordBackupHistory(driver, volume.Name, backup.Name, HistoryEventCreated)
 
 	log.WithFields(logrus.Fields{
 		LogFieldReason:   LogReasonComplete,
@@ -92,13 +111,13 @@ func RestoreSingleFileBackup(backupURL, path string) (string, error) {
 		}, "Volume doesn't exist in backupstore: %v", err)
 	}
 
-	backup, err := loadBackup(driver, srcBackupName, srcVolumeName)
+	backup, err := loadVerifiedBackup(driver, srcBackupName, srcVolumeName)
 	if err != nil {
 		return "", err
 	}
 
 	dstFile := filepath.Join(path, filepath.Base(backup.SingleFile.FilePath))
-	if err := driver.Download(backup.SingleFile.FilePath, dstFile); err != nil {
+	if err := DownloadObject(driver, backup.SingleFile.FilePath, dstFile, nil); err != nil {
 		return "", err
 	}
 
@@ -116,7 +135,11 @@ func DeleteSingleFileBackup(backupURL string) error {
 		return err
 	}
 
-	_, err = loadVolume(driver, volumeName)
+	if err := CheckTargetMutable(driver, "delete backup "+backupName); err != nil {
+		return err
+	}
+
+	volume, err := loadVolume(driver, volumeName)
 	if err != nil {
 		return errors.Wrapf(err, "cannot find volume %v in backupstore", volumeName)
 	}
@@ -130,5 +153,11 @@ func DeleteSingleFileBackup(backupURL string) error {
 		return err
 	}
 
-	return removeBackup(backup, driver)
+	if err := removeBackup(backup, driver); err != nil {
+		return err
+	}
+	recordBackupHistory(driver, volumeName, backupName, HistoryEventDeleted)
+
+	// The volume config is saved, so the modification is picked up by the incremental listings
+	return saveVolume(driver, volume)
 }
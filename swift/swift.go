@@ -0,0 +1,276 @@
+package swift
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/backupstore"
+	bhttp "github.com/longhorn/backupstore/http"
+	"github.com/longhorn/backupstore/types"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "swift"})
+)
+
+// BackupStoreDriver defines the variables and method that backupstore will use.
+type BackupStoreDriver struct {
+	destURL string
+	path    string
+	getenv  func(string) string
+	service *service
+}
+
+const (
+	// KIND defines the kind of backupstore driver
+	KIND = "swift"
+
+	// SwiftSegmentSizeOption is the backup target URL query parameter setting the size in bytes above which the
+	// objects are uploaded in segments, e.g. swift://container/path/?swiftSegmentSize=536870912
+	SwiftSegmentSizeOption = "swiftSegmentSize"
+)
+
+func init() {
+	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("wrong driver dispatching %v to %v?", u.Scheme, KIND)
+	}
+
+	b := &BackupStoreDriver{getenv: backupstore.TargetEnv(backupstore.GetTargetCredential(destURL))}
+	b.path = strings.TrimLeft(u.Path, "/")
+	if u.Host == "" || b.path == "" {
+		return nil, fmt.Errorf("invalid URL. Must be swift://container/path/")
+	}
+
+	segmentSize := int64(defaultSegmentSize)
+	if value := u.Query().Get(SwiftSegmentSizeOption); value != "" {
+		segmentSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || segmentSize <= 0 {
+			return nil, fmt.Errorf("invalid %v %v in Swift URL", SwiftSegmentSizeOption, value)
+		}
+	}
+
+	var customCerts []byte
+	if certs := b.getenv(types.SwiftCert); certs != "" {
+		customCerts = []byte(certs)
+	}
+	client, err := bhttp.GetClientWithCustomCerts(customCerts)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := newKeystoneAuth(client, b.getenv)
+	if err != nil {
+		return nil, err
+	}
+	b.service = &service{
+		Container:   u.Host,
+		SegmentSize: segmentSize,
+		Client:      client,
+		auth:        auth,
+	}
+
+	if _, err := b.List(""); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + u.Host + "/" + b.path
+	log.Infof("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+// Kind returns the driver type
+func (s *BackupStoreDriver) Kind() string {
+	return KIND
+}
+
+// GetURL returns URL of the backup target
+func (s *BackupStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *BackupStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+// List return items that on the backup target including prefixes
+func (s *BackupStoreDriver) List(listPath string) ([]string, error) {
+	var result []string
+
+	path := s.updatePath(listPath) + "/"
+	objects, err := s.service.listObjects(path, "/")
+	if err != nil {
+		log.WithError(err).Error("Failed to list swift")
+		return result, err
+	}
+
+	if len(objects) == 0 {
+		return result, nil
+	}
+	result = []string{}
+	for _, obj := range objects {
+		name := obj.Name
+		if obj.Subdir != "" {
+			name = strings.TrimSuffix(obj.Subdir, "/")
+		}
+		if r := strings.TrimPrefix(name, path); r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// ListPrefix returns the paths of all the objects under the prefix
+func (s *BackupStoreDriver) ListPrefix(prefix string) ([]string, error) {
+	path := s.updatePath(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		path += "/"
+	}
+	objects, err := s.service.listObjects(path, "")
+	if err != nil {
+		log.WithError(err).Error("Failed to list swift")
+		return nil, err
+	}
+
+	result := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		if r := strings.TrimPrefix(strings.TrimPrefix(obj.Name, s.path), "/"); r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// FileExists checks if file exists on the backup target
+func (s *BackupStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+// FileSize return content length of the filePath on the backup target
+func (s *BackupStoreDriver) FileSize(filePath string) int64 {
+	info, err := s.service.headObject(s.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return info.size
+}
+
+// FileTime returns file last modified time on the backup target
+func (s *BackupStoreDriver) FileTime(filePath string) time.Time {
+	info, err := s.service.headObject(s.updatePath(filePath))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.modified.UTC()
+}
+
+// Remove deletes files on the backup target
+func (s *BackupStoreDriver) Remove(path string) error {
+	name := s.updatePath(path)
+	if err := s.service.deleteObject(name); err != nil {
+		return err
+	}
+	return s.service.deleteObjects(name + "/")
+}
+
+func (s *BackupStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return s.service.getObjectRange(s.updatePath(src), 0, -1)
+}
+
+// Write creates a item on the backup target from io stream
+func (s *BackupStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return s.service.putObject(s.updatePath(dst), rs, "", "")
+}
+
+// WriteWithMetadata creates a item with the HTTP metadata on the backup target from io stream
+func (s *BackupStoreDriver) WriteWithMetadata(dst string, rs io.ReadSeeker, metadata *backupstore.ObjectMetadata) error {
+	return s.service.putObject(s.updatePath(dst), rs, metadata.ContentType, metadata.ContentEncoding)
+}
+
+// GetMetadata returns the HTTP metadata of the item on the backup target
+func (s *BackupStoreDriver) GetMetadata(filePath string) (*backupstore.ObjectMetadata, error) {
+	info, err := s.service.headObject(s.updatePath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return &backupstore.ObjectMetadata{
+		ContentType:     info.contentType,
+		ContentEncoding: info.contentEncoding,
+	}, nil
+}
+
+// ReadRange reads the data range of the item on the backup target, a negative length reads the data until the end
+func (s *BackupStoreDriver) ReadRange(src string, offset, length int64) (io.ReadCloser, error) {
+	return s.service.getObjectRange(s.updatePath(src), offset, length)
+}
+
+// Copy copies the item inside the container without transferring the data through the client
+func (s *BackupStoreDriver) Copy(src, dst string) error {
+	return s.service.copyObject(s.updatePath(src), s.updatePath(dst))
+}
+
+// GetCapacity returns the bytes used by the container and the quota of the container or the account
+func (s *BackupStoreDriver) GetCapacity() (*backupstore.TargetCapacity, error) {
+	used, quota, err := s.service.getCapacity()
+	if err != nil {
+		return nil, err
+	}
+	capacity := &backupstore.TargetCapacity{Total: quota, Used: used, Available: -1}
+	if quota >= 0 && used >= 0 {
+		capacity.Available = max(quota-used, 0)
+	}
+	return capacity, nil
+}
+
+// Upload creates a item on the backup target by opening source file
+func (s *BackupStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.service.putObject(s.updatePath(dst), file, "", "")
+}
+
+// Download gets a item data from the backup target
+func (s *BackupStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0700); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rc, err := s.service.getObjectRange(s.updatePath(src), 0, -1)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
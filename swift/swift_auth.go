@@ -0,0 +1,219 @@
+package swift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+const (
+	objectStoreServiceType = "object-store"
+	defaultDomainName      = "Default"
+
+	// tokenRefreshMargin refreshes the tokens before they expire, so the requests in flight don't carry an
+	// expired token
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// keystoneAuth authenticates with the Keystone v3 identity service, and finds the public object storage
+// endpoint of the region in the service catalog of the token.
+type keystoneAuth struct {
+	client *http.Client
+	getenv func(string) string
+
+	lock      sync.Mutex
+	token     string
+	expiry    time.Time
+	storage   string
+	forceAuth bool
+}
+
+type keystoneRequest struct {
+	Auth keystoneAuthRequest `json:"auth"`
+}
+
+type keystoneAuthRequest struct {
+	Identity keystoneIdentity `json:"identity"`
+	Scope    *keystoneScope   `json:"scope,omitempty"`
+}
+
+type keystoneIdentity struct {
+	Methods               []string                       `json:"methods"`
+	Password              *keystonePassword              `json:"password,omitempty"`
+	ApplicationCredential *keystoneApplicationCredential `json:"application_credential,omitempty"`
+}
+
+type keystonePassword struct {
+	User keystoneUser `json:"user"`
+}
+
+type keystoneUser struct {
+	Name     string          `json:"name"`
+	Password string          `json:"password"`
+	Domain   *keystoneDomain `json:"domain"`
+}
+
+type keystoneApplicationCredential struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+type keystoneScope struct {
+	Project *keystoneProject `json:"project"`
+}
+
+type keystoneProject struct {
+	Name   string          `json:"name"`
+	Domain *keystoneDomain `json:"domain"`
+}
+
+type keystoneDomain struct {
+	Name string `json:"name"`
+}
+
+type keystoneResponse struct {
+	Token struct {
+		ExpiresAt time.Time `json:"expires_at"`
+		Catalog   []struct {
+			Type      string `json:"type"`
+			Endpoints []struct {
+				Interface string `json:"interface"`
+				Region    string `json:"region"`
+				RegionID  string `json:"region_id"`
+				URL       string `json:"url"`
+			} `json:"endpoints"`
+		} `json:"catalog"`
+	} `json:"token"`
+}
+
+func newKeystoneAuth(client *http.Client, getenv func(string) string) (*keystoneAuth, error) {
+	if getenv(types.SwiftAuthURL) == "" {
+		return nil, fmt.Errorf("missing Keystone identity URL %v", types.SwiftAuthURL)
+	}
+	if getenv(types.SwiftApplicationCredentialID) == "" &&
+		(getenv(types.SwiftUsername) == "" || getenv(types.SwiftPassword) == "") {
+		return nil, fmt.Errorf("missing Swift credential, either %v and %v or %v and %v must be set",
+			types.SwiftUsername, types.SwiftPassword, types.SwiftApplicationCredentialID, types.SwiftApplicationCredentialSecret)
+	}
+	return &keystoneAuth{client: client, getenv: getenv}, nil
+}
+
+// authenticate returns the token and the object storage endpoint, the cached token is used until it's about to
+// expire or it's rejected by the object storage.
+func (a *keystoneAuth) authenticate() (string, string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.token != "" && !a.forceAuth && time.Now().Add(tokenRefreshMargin).Before(a.expiry) {
+		return a.token, a.storage, nil
+	}
+
+	token, expiry, storage, err := a.requestToken()
+	if err != nil {
+		return "", "", err
+	}
+	a.token, a.expiry, a.storage, a.forceAuth = token, expiry, storage, false
+	return a.token, a.storage, nil
+}
+
+// invalidate forces the next request to authenticate again, e.g. the token is revoked.
+func (a *keystoneAuth) invalidate() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.forceAuth = true
+}
+
+func (a *keystoneAuth) newRequest() *keystoneRequest {
+	req := &keystoneRequest{}
+	if id := a.getenv(types.SwiftApplicationCredentialID); id != "" {
+		// The application credentials are scoped to their project already
+		req.Auth.Identity = keystoneIdentity{
+			Methods: []string{"application_credential"},
+			ApplicationCredential: &keystoneApplicationCredential{
+				ID:     id,
+				Secret: a.getenv(types.SwiftApplicationCredentialSecret),
+			},
+		}
+		return req
+	}
+
+	domainName := func(key string) *keystoneDomain {
+		if name := a.getenv(key); name != "" {
+			return &keystoneDomain{Name: name}
+		}
+		return &keystoneDomain{Name: defaultDomainName}
+	}
+	req.Auth.Identity = keystoneIdentity{
+		Methods: []string{"password"},
+		Password: &keystonePassword{User: keystoneUser{
+			Name:     a.getenv(types.SwiftUsername),
+			Password: a.getenv(types.SwiftPassword),
+			Domain:   domainName(types.SwiftUserDomainName),
+		}},
+	}
+	if project := a.getenv(types.SwiftProjectName); project != "" {
+		req.Auth.Scope = &keystoneScope{Project: &keystoneProject{
+			Name:   project,
+			Domain: domainName(types.SwiftProjectDomainName),
+		}}
+	}
+	return req
+}
+
+func (a *keystoneAuth) requestToken() (string, time.Time, string, error) {
+	body, err := json.Marshal(a.newRequest())
+	if err != nil {
+		return "", time.Time{}, "", err
+	}
+
+	authURL := strings.TrimRight(a.getenv(types.SwiftAuthURL), "/")
+	if !strings.HasSuffix(authURL, "/v3") {
+		authURL += "/v3"
+	}
+	resp, err := a.client.Post(authURL+"/auth/tokens", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, "", errors.Wrap(err, "failed to authenticate with Keystone")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", time.Time{}, "", fmt.Errorf("failed to authenticate with Keystone: %v %v", resp.Status,
+			strings.TrimSpace(string(respBody)))
+	}
+
+	result := &keystoneResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return "", time.Time{}, "", errors.Wrap(err, "failed to decode Keystone token")
+	}
+	token := resp.Header.Get("X-Subject-Token")
+	if token == "" {
+		return "", time.Time{}, "", fmt.Errorf("missing token in Keystone response")
+	}
+
+	region := a.getenv(types.SwiftRegion)
+	for _, service := range result.Token.Catalog {
+		if service.Type != objectStoreServiceType {
+			continue
+		}
+		for _, endpoint := range service.Endpoints {
+			if endpoint.Interface != "public" {
+				continue
+			}
+			if region != "" && endpoint.Region != region && endpoint.RegionID != region {
+				continue
+			}
+			return token, result.Token.ExpiresAt, strings.TrimRight(endpoint.URL, "/"), nil
+		}
+	}
+	return "", time.Time{}, "", fmt.Errorf("cannot find the public %v endpoint of region %q in the Keystone catalog",
+		objectStoreServiceType, region)
+}
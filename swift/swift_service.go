@@ -0,0 +1,448 @@
+package swift
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultSegmentSize is the size above which the objects are uploaded as the static large objects in
+	// segments, Swift rejects the objects larger than 5 GiB by default
+	defaultSegmentSize = 1 << 30
+	// segmentContainerSuffix follows the convention of the Swift clients storing the segments of the large
+	// objects in the container named after the one holding the manifests
+	segmentContainerSuffix = "_segments"
+
+	listLimit = 10000
+)
+
+var errNotFound = fmt.Errorf("object not found")
+
+type service struct {
+	Container   string
+	SegmentSize int64
+	Client      *http.Client
+
+	auth *keystoneAuth
+}
+
+// object is an object or a pseudo directory of the container listings.
+type object struct {
+	Name   string `json:"name"`
+	Subdir string `json:"subdir"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type objectInfo struct {
+	size            int64
+	modified        time.Time
+	contentType     string
+	contentEncoding string
+}
+
+// segment is an entry of the static large object manifest.
+type segment struct {
+	Path      string `json:"path"`
+	ETag      string `json:"etag"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+func escapePath(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// do sends the authorized request to the object storage path, e.g. container/object or the empty path of the
+// account, the response body is closed and an error is returned unless the status is one of the expected ones.
+// The request is sent again with a new token if the token is rejected.
+func (s *service) do(method, path string, query url.Values, body io.ReadSeeker, header http.Header, expected ...int) (*http.Response, error) {
+	var offset, size int64
+	if body != nil {
+		var err error
+		if offset, err = body.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		end, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		size = end - offset
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, storage, err := s.auth.authenticate()
+		if err != nil {
+			return nil, err
+		}
+		target := storage
+		if path != "" {
+			target += "/" + escapePath(path)
+		}
+		if len(query) > 0 {
+			target += "?" + query.Encode()
+		}
+
+		var reqBody io.Reader
+		if body != nil {
+			if _, err := body.Seek(offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			reqBody = io.NopCloser(body)
+		}
+		req, err := http.NewRequest(method, target, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.ContentLength = size
+			if size == 0 {
+				req.Body = http.NoBody
+			}
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("X-Auth-Token", token)
+
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range expected {
+			if resp.StatusCode == status {
+				return resp, nil
+			}
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			s.auth.invalidate()
+			continue
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, errNotFound
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("swift error: %v %v %v %v", method, path, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+}
+
+func discard(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func (s *service) objectPath(name string) string {
+	return s.Container + "/" + name
+}
+
+func (s *service) segmentContainer() string {
+	return s.Container + segmentContainerSuffix
+}
+
+// listObjects lists the objects with the prefix, the objects under the delimiter after the prefix are listed
+// as the pseudo directories if the delimiter is set.
+func (s *service) listObjects(prefix, delimiter string) ([]*object, error) {
+	var objects []*object
+	query := url.Values{
+		"format": {"json"},
+		"prefix": {prefix},
+		"limit":  {strconv.Itoa(listLimit)},
+	}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	for {
+		resp, err := s.do(http.MethodGet, s.Container, query, nil, nil, http.StatusOK, http.StatusNoContent)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list objects with prefix %v", prefix)
+		}
+		page := []*object{}
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&page)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode objects with prefix %v", prefix)
+		}
+
+		objects = append(objects, page...)
+		if len(page) < listLimit {
+			return objects, nil
+		}
+		last := page[len(page)-1]
+		if last.Subdir != "" {
+			query.Set("marker", last.Subdir)
+		} else {
+			query.Set("marker", last.Name)
+		}
+	}
+}
+
+func (s *service) headObject(name string) (*objectInfo, error) {
+	resp, err := s.do(http.MethodHead, s.objectPath(name), nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	info := &objectInfo{
+		size:            resp.ContentLength,
+		contentType:     resp.Header.Get("Content-Type"),
+		contentEncoding: resp.Header.Get("Content-Encoding"),
+	}
+	if value := resp.Header.Get("Content-Length"); value != "" {
+		if info.size, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "invalid content length of object %v", name)
+		}
+	}
+	if value := resp.Header.Get("Last-Modified"); value != "" {
+		if info.modified, err = http.ParseTime(value); err != nil {
+			return nil, errors.Wrapf(err, "invalid last modified time of object %v", name)
+		}
+	}
+	return info, nil
+}
+
+// headBytes returns the integer header of the account or the container, or -1 if it's missing.
+func headBytes(resp *http.Response, key string) (int64, error) {
+	value := resp.Header.Get(key)
+	if value == "" {
+		return -1, nil
+	}
+	bytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return -1, errors.Wrapf(err, "invalid %v %v", key, value)
+	}
+	return bytes, nil
+}
+
+// getCapacity returns the bytes used by the container and its segment container, and the quota of the
+// container, falling back to the quota of the account.
+func (s *service) getCapacity() (used, quota int64, err error) {
+	resp, err := s.do(http.MethodHead, s.Container, nil, nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return -1, -1, err
+	}
+	resp.Body.Close()
+	if used, err = headBytes(resp, "X-Container-Bytes-Used"); err != nil {
+		return -1, -1, err
+	}
+	if quota, err = headBytes(resp, "X-Container-Meta-Quota-Bytes"); err != nil {
+		return -1, -1, err
+	}
+	if quota >= 0 {
+		return used, quota, nil
+	}
+
+	resp, err = s.do(http.MethodHead, s.segmentContainer(), nil, nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil && err != errNotFound {
+		return -1, -1, err
+	}
+	if err == nil {
+		resp.Body.Close()
+		segments, err := headBytes(resp, "X-Container-Bytes-Used")
+		if err != nil {
+			return -1, -1, err
+		}
+		if segments > 0 && used >= 0 {
+			used += segments
+		}
+	}
+
+	// The account quota is shared by all the containers of the account
+	resp, err = s.do(http.MethodHead, "", nil, nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return -1, -1, err
+	}
+	resp.Body.Close()
+	if quota, err = headBytes(resp, "X-Account-Meta-Quota-Bytes"); err != nil || quota < 0 {
+		return used, -1, err
+	}
+	accountUsed, err := headBytes(resp, "X-Account-Bytes-Used")
+	if err != nil {
+		return -1, -1, err
+	}
+	return accountUsed, quota, nil
+}
+
+// getObjectRange gets the data range of the object, a negative length reads the data until the end.
+func (s *service) getObjectRange(name string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	// The objects are read as stored, otherwise the HTTP client transparently decompresses the objects stored
+	// with the gzip Content-Encoding
+	header := http.Header{"Accept-Encoding": {"gzip"}}
+	if offset > 0 || length > 0 {
+		byteRange := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			byteRange += strconv.FormatInt(offset+length-1, 10)
+		}
+		header.Set("Range", byteRange)
+	}
+	resp, err := s.do(http.MethodGet, s.objectPath(name), nil, nil, header, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get object %v", name)
+	}
+	return resp.Body, nil
+}
+
+// putObject uploads the object with the given Content-Type and Content-Encoding, the empty values are omitted.
+// The objects larger than the segment size are uploaded as the static large objects.
+func (s *service) putObject(name string, reader io.ReadSeeker, contentType, contentEncoding string) error {
+	offset, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if contentEncoding != "" {
+		header.Set("Content-Encoding", contentEncoding)
+	}
+
+	if end-offset > s.SegmentSize {
+		err = s.putLargeObject(name, reader, offset, end-offset, header)
+	} else {
+		_, err = s.putData(s.objectPath(name), io.NewSectionReader(readerAt{reader}, offset, end-offset), header)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to put object %v", name)
+	}
+	return nil
+}
+
+// putData uploads the data with its MD5 checksum, so Swift rejects the corrupted uploads. The checksum is
+// returned for the large object manifests.
+func (s *service) putData(path string, data io.ReadSeeker, header http.Header) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, data); err != nil {
+		return "", err
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+
+	dataHeader := header.Clone()
+	dataHeader.Set("ETag", etag)
+	return etag, discard(s.do(http.MethodPut, path, nil, data, dataHeader, http.StatusCreated))
+}
+
+// putLargeObject uploads the segments of the object, then the manifest joining them. The segments of the
+// previous version of the object are removed along with the manifest.
+func (s *service) putLargeObject(name string, reader io.ReadSeeker, offset, size int64, header http.Header) error {
+	if err := discard(s.do(http.MethodPut, s.segmentContainer(), nil, nil, nil,
+		http.StatusCreated, http.StatusAccepted, http.StatusNoContent)); err != nil {
+		return errors.Wrapf(err, "failed to create segment container %v", s.segmentContainer())
+	}
+
+	prefix := fmt.Sprintf("%s/slo/%d", name, time.Now().UTC().UnixNano())
+	var segments []segment
+	for i, base := 0, int64(0); base < size; i, base = i+1, base+s.SegmentSize {
+		length := s.SegmentSize
+		if base+length > size {
+			length = size - base
+		}
+		segmentPath := s.segmentContainer() + "/" + fmt.Sprintf("%s/%08d", prefix, i)
+		etag, err := s.putData(segmentPath, io.NewSectionReader(readerAt{reader}, offset+base, length), http.Header{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to put segment %v", segmentPath)
+		}
+		segments = append(segments, segment{Path: "/" + segmentPath, ETag: etag, SizeBytes: length})
+	}
+
+	manifest, err := json.Marshal(segments)
+	if err != nil {
+		return err
+	}
+	if err := s.deleteObject(name); err != nil {
+		log.WithError(err).Warnf("Failed to delete the previous segments of object %v", name)
+	}
+	return discard(s.do(http.MethodPut, s.objectPath(name), url.Values{"multipart-manifest": {"put"}},
+		bytes.NewReader(manifest), header, http.StatusCreated))
+}
+
+// deleteObject deletes the object, and the segments of it if it's a static large object.
+func (s *service) deleteObject(name string) error {
+	err := discard(s.do(http.MethodDelete, s.objectPath(name), url.Values{"multipart-manifest": {"delete"}}, nil, nil,
+		http.StatusOK, http.StatusNoContent))
+	if err == errNotFound {
+		return nil
+	}
+	return err
+}
+
+// deleteObjects deletes all the objects with the given prefix.
+func (s *service) deleteObjects(prefix string) error {
+	objects, err := s.listObjects(prefix, "")
+	if err != nil {
+		return errors.Wrapf(err, "failed to list objects with prefix %v before removing them", prefix)
+	}
+
+	var deletionFailures []string
+	for _, obj := range objects {
+		if err := s.deleteObject(obj.Name); err != nil {
+			log.WithError(err).Errorf("Failed to delete object: %v", obj.Name)
+			deletionFailures = append(deletionFailures, obj.Name)
+		}
+	}
+	if len(deletionFailures) > 0 {
+		return fmt.Errorf("failed to delete objects %v", deletionFailures)
+	}
+	return nil
+}
+
+// copyObject copies the object inside the container on the server.
+func (s *service) copyObject(src, dst string) error {
+	header := http.Header{"X-Copy-From": {"/" + escapePath(s.objectPath(src))}}
+	if err := discard(s.do(http.MethodPut, s.objectPath(dst), nil, bytes.NewReader(nil), header,
+		http.StatusCreated)); err != nil {
+		return errors.Wrapf(err, "failed to copy object %v to %v", src, dst)
+	}
+	return nil
+}
+
+// readerAt reads the seekable reader at the offsets, the reads are not concurrent.
+type readerAt struct {
+	io.ReadSeeker
+}
+
+func (r readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,354 @@
+package swift
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+)
+
+// fakeSwift implements the Keystone v3 token API and the subset of the Swift API used by the driver.
+type fakeSwift struct {
+	t      *testing.T
+	server *httptest.Server
+
+	lock         sync.Mutex
+	tokens       int
+	objects      map[string]*fakeObject
+	accountQuota int64
+}
+
+type fakeObject struct {
+	data            []byte
+	contentType     string
+	contentEncoding string
+	manifest        []segment
+}
+
+func newFakeSwift(t *testing.T) *fakeSwift {
+	f := &fakeSwift{t: t, objects: map[string]*fakeObject{}}
+	f.server = httptest.NewServer(f)
+	return f
+}
+
+func (f *fakeSwift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.URL.Path == "/identity/v3/auth/tokens" {
+		f.serveToken(w, r)
+		return
+	}
+	if r.Header.Get("X-Auth-Token") != fmt.Sprintf("token-%d", f.tokens) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/v1/AUTH_project" {
+		w.Header().Set("X-Account-Bytes-Used", strconv.FormatInt(f.bytesUsed(""), 10))
+		if f.accountQuota > 0 {
+			w.Header().Set("X-Account-Meta-Quota-Bytes", strconv.FormatInt(f.accountQuota, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/AUTH_project/")
+	container, name, _ := strings.Cut(path, "/")
+	if name == "" {
+		f.serveContainer(w, r, container)
+		return
+	}
+	f.serveObject(w, r, path)
+}
+
+func (f *fakeSwift) serveToken(w http.ResponseWriter, r *http.Request) {
+	request := &keystoneRequest{}
+	assert.NoError(f.t, json.NewDecoder(r.Body).Decode(request))
+	password := request.Auth.Identity.Password
+	if password == nil || password.User.Name != "user" || password.User.Password != "secret" ||
+		password.User.Domain.Name != "Default" || request.Auth.Scope.Project.Name != "project" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	f.tokens++
+	w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%d", f.tokens))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"token": {"expires_at": %q, "catalog": [
+		{"type": "identity", "endpoints": [{"interface": "public", "region": "RegionOne", "url": "%s/identity"}]},
+		{"type": "object-store", "endpoints": [
+			{"interface": "internal", "region": "RegionOne", "url": "http://internal.invalid/v1/AUTH_project"},
+			{"interface": "public", "region": "RegionTwo", "url": "http://other.invalid/v1/AUTH_project"},
+			{"interface": "public", "region": "RegionOne", "url": "%s/v1/AUTH_project"}
+		]}
+	]}}`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), f.server.URL, f.server.URL)
+}
+
+func (f *fakeSwift) bytesUsed(container string) int64 {
+	used := int64(0)
+	for name, obj := range f.objects {
+		if container == "" || strings.HasPrefix(name, container+"/") {
+			used += int64(len(obj.data))
+		}
+	}
+	return used
+}
+
+func (f *fakeSwift) serveContainer(w http.ResponseWriter, r *http.Request, container string) {
+	switch r.Method {
+	case http.MethodPut:
+		w.WriteHeader(http.StatusCreated)
+		return
+	case http.MethodHead:
+		used := f.bytesUsed(container)
+		if strings.HasSuffix(container, segmentContainerSuffix) && used == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Container-Bytes-Used", strconv.FormatInt(used, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	query := r.URL.Query()
+	prefix, delimiter, marker := container+"/"+query.Get("prefix"), query.Get("delimiter"), query.Get("marker")
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	var names []string
+	for name := range f.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	entries := []map[string]interface{}{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimPrefix(name, container+"/")
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix)-len(container)-1:], delimiter); i >= 0 {
+				subdir := name[:len(prefix)-len(container)-1+i+1]
+				if !seen[subdir] && subdir > marker {
+					seen[subdir] = true
+					entries = append(entries, map[string]interface{}{"subdir": subdir})
+				}
+				continue
+			}
+		}
+		if name > marker {
+			entries = append(entries, map[string]interface{}{"name": name, "bytes": len(f.objects[container+"/"+name].data)})
+		}
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func (f *fakeSwift) serveObject(w http.ResponseWriter, r *http.Request, path string) {
+	switch r.Method {
+	case http.MethodPut:
+		obj := &fakeObject{contentType: r.Header.Get("Content-Type"), contentEncoding: r.Header.Get("Content-Encoding")}
+		if source := r.Header.Get("X-Copy-From"); source != "" {
+			src, ok := f.objects[strings.TrimPrefix(source, "/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			copied := *src
+			f.objects[path] = &copied
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if r.URL.Query().Get("multipart-manifest") == "put" {
+			assert.NoError(f.t, json.Unmarshal(data, &obj.manifest))
+			for _, seg := range obj.manifest {
+				segObj, ok := f.objects[strings.TrimPrefix(seg.Path, "/")]
+				if !assert.True(f.t, ok, "missing segment %v", seg.Path) {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				obj.data = append(obj.data, segObj.data...)
+			}
+		} else {
+			sum := md5.Sum(data)
+			if r.Header.Get("ETag") != hex.EncodeToString(sum[:]) {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			obj.data = data
+		}
+		f.objects[path] = obj
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodHead, http.MethodGet:
+		obj, ok := f.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if obj.contentType != "" {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		if obj.contentEncoding != "" {
+			w.Header().Set("Content-Encoding", obj.contentEncoding)
+		}
+		w.Header().Set("Last-Modified", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+		data := obj.data
+		status := http.StatusOK
+		if byteRange := r.Header.Get("Range"); byteRange != "" {
+			var start, end int
+			if _, err := fmt.Sscanf(byteRange, "bytes=%d-%d", &start, &end); err != nil {
+				end = len(data) - 1
+			}
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodDelete:
+		obj, ok := f.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("multipart-manifest") == "delete" {
+			for _, seg := range obj.manifest {
+				delete(f.objects, strings.TrimPrefix(seg.Path, "/"))
+			}
+		}
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestDriver(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := newFakeSwift(t)
+	defer fake.server.Close()
+
+	destURL := "swift://container/backups/?" + SwiftSegmentSizeOption + "=16"
+	backupstore.SetTargetCredential(destURL, map[string]string{
+		types.SwiftAuthURL:     fake.server.URL + "/identity",
+		types.SwiftUsername:    "user",
+		types.SwiftPassword:    "secret",
+		types.SwiftProjectName: "project",
+		types.SwiftRegion:      "RegionOne",
+	})
+	defer backupstore.SetTargetCredential(destURL, nil)
+
+	driver, err := initFunc(destURL)
+	assert.NoError(err)
+	assert.Equal("swift://container/backups/", driver.GetURL())
+
+	assert.NoError(driver.Write("volumes/01/volume.cfg", strings.NewReader("config")))
+	assert.True(driver.FileExists("volumes/01/volume.cfg"))
+	assert.Equal(int64(6), driver.FileSize("volumes/01/volume.cfg"))
+	assert.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), driver.FileTime("volumes/01/volume.cfg"))
+	assert.Equal(int64(-1), driver.FileSize("volumes/01/missing.cfg"))
+
+	// The objects larger than the segment size are uploaded in segments joined by a manifest
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	assert.NoError(driver.Write("system-backups/backup.zip", bytes.NewReader(data)))
+	manifest := fake.objects["container/backups/system-backups/backup.zip"].manifest
+	assert.Len(manifest, 3)
+	assert.Equal(int64(4), manifest[2].SizeBytes)
+	assert.True(strings.HasPrefix(manifest[0].Path, "/container_segments/backups/system-backups/backup.zip/slo/"))
+
+	rc, err := driver.(backupstore.ObjectMetadataBackupStoreDriver).ReadRange("system-backups/backup.zip", 10, 20)
+	assert.NoError(err)
+	read, err := io.ReadAll(rc)
+	assert.NoError(err)
+	assert.NoError(rc.Close())
+	assert.Equal(data[10:30], read)
+
+	metadataDriver := driver.(backupstore.ObjectMetadataBackupStoreDriver)
+	assert.NoError(metadataDriver.WriteWithMetadata("volumes/01/blocks/a.blk", strings.NewReader("gzipped"),
+		&backupstore.ObjectMetadata{ContentType: "application/octet-stream", ContentEncoding: "gzip"}))
+	metadata, err := metadataDriver.GetMetadata("volumes/01/blocks/a.blk")
+	assert.NoError(err)
+	assert.Equal(&backupstore.ObjectMetadata{ContentType: "application/octet-stream", ContentEncoding: "gzip"}, metadata)
+	rc, err = driver.Read("volumes/01/blocks/a.blk")
+	assert.NoError(err)
+	read, err = io.ReadAll(rc)
+	assert.NoError(err)
+	assert.NoError(rc.Close())
+	assert.Equal("gzipped", string(read), "the object should be read as stored")
+
+	assert.NoError(driver.(backupstore.CopyingBackupStoreDriver).Copy("volumes/01/volume.cfg", "volumes/02/volume.cfg"))
+	names, err := driver.List("volumes")
+	assert.NoError(err)
+	assert.Equal([]string{"01", "02"}, names)
+	names, err = driver.List("volumes/01")
+	assert.NoError(err)
+	assert.Equal([]string{"blocks", "volume.cfg"}, names)
+	paths, err := driver.(backupstore.PrefixListingBackupStoreDriver).ListPrefix("volumes/")
+	assert.NoError(err)
+	assert.Equal([]string{"volumes/01/blocks/a.blk", "volumes/01/volume.cfg", "volumes/02/volume.cfg"}, paths)
+
+	// The revoked token is replaced
+	fake.tokens++
+	assert.NoError(driver.Remove("volumes/01"))
+	names, err = driver.List("volumes")
+	assert.NoError(err)
+	assert.Equal([]string{"02"}, names)
+
+	// The segments are counted along with the manifests
+	reporter := driver.(backupstore.CapacityBackupStoreDriver)
+	capacity, err := reporter.GetCapacity()
+	assert.NoError(err)
+	used := fake.bytesUsed("")
+	assert.True(used > int64(len(data)))
+	assert.Equal(&backupstore.TargetCapacity{Total: -1, Used: used, Available: -1}, capacity)
+	fake.accountQuota = used + 100
+	capacity, err = reporter.GetCapacity()
+	assert.NoError(err)
+	assert.Equal(&backupstore.TargetCapacity{Total: used + 100, Used: used, Available: 100}, capacity)
+
+	assert.NoError(driver.Remove("system-backups/backup.zip"))
+	for name := range fake.objects {
+		assert.False(strings.HasPrefix(name, "container_segments/"), "segment %v should be removed", name)
+	}
+}
+
+func TestKeystoneAuthRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	credential := map[string]string{
+		types.SwiftAuthURL:                     "https://keystone.example.com/v3",
+		types.SwiftApplicationCredentialID:     "id",
+		types.SwiftApplicationCredentialSecret: "secret",
+	}
+	auth, err := newKeystoneAuth(http.DefaultClient, func(key string) string { return credential[key] })
+	assert.NoError(err)
+	request := auth.newRequest()
+	assert.Equal([]string{"application_credential"}, request.Auth.Identity.Methods)
+	assert.Equal(&keystoneApplicationCredential{ID: "id", Secret: "secret"}, request.Auth.Identity.ApplicationCredential)
+	assert.Nil(request.Auth.Scope)
+
+	_, err = newKeystoneAuth(http.DefaultClient, func(key string) string {
+		return map[string]string{types.SwiftAuthURL: "https://keystone.example.com"}[key]
+	})
+	assert.Error(err)
+}
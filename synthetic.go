@@ -0,0 +1,118 @@
+package backupstore
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	. "github.com/longhorn/backupstore/logging"
+	"github.com/longhorn/backupstore/util"
+)
+
+// promoteToSyntheticFull marks the backup as a full one restarting the incremental chain. The backup config
+// already contains all the blocks of the volume, so no block is uploaded again.
+func promoteToSyntheticFull(backup *Backup) {
+	backup.IsIncremental = false
+	backup.IsSyntheticFull = true
+	backup.ChainLength = 0
+}
+
+// CreateSyntheticFullBackup creates a full backup of the same snapshot as the backup, by re-referencing the
+// existing blocks in the backup target. The following backups of the volume are chained from the synthetic
+// full backup if the backup is the last one of the volume, so the older incremental backups can be pruned.
+func CreateSyntheticFullBackup(backupURL, syntheticBackupName string) (string, error) {
+	bsDriver, err := GetBackupStoreDriver(backupURL)
+	if err != nil {
+		return "", err
+	}
+
+	backupName, volumeName, _, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return "", err
+	}
+	if !util.ValidateName(syntheticBackupName) {
+		return "", fmt.Errorf("invalid backup name %v", syntheticBackupName)
+	}
+	log := log.WithFields(logrus.Fields{
+		LogFieldBackup: backupName,
+		LogFieldVolume: volumeName,
+	})
+
+	lock, err := New(bsDriver, volumeName, BACKUP_LOCK)
+	if err != nil {
+		return "", err
+	}
+	if err := lock.Lock(); err != nil {
+		return "", err
+	}
+	defer func() {
+		if unlockErr := lock.Unlock(); unlockErr != nil {
+			log.WithError(unlockErr).Warn("Failed to unlock")
+		}
+	}()
+
+	if err := CheckTargetRedirect(bsDriver); err != nil {
+		return "", err
+	}
+
+	volume, err := loadVolume(bsDriver, volumeName)
+	if err != nil {
+		return "", err
+	}
+	backup, err := loadBackup(bsDriver, backupName, volumeName)
+	if err != nil {
+		return "", err
+	}
+	if isBackupInProgress(backup) {
+		return "", fmt.Errorf("backup %v of volume %v is still in progress", backupName, volumeName)
+	}
+	if backup.SingleFile.FilePath != "" {
+		return "", fmt.Errorf("synthesizing full backup from single file backup %v is not supported", backupName)
+	}
+	if bsDriver.FileExists(getBackupConfigPath(syntheticBackupName, volumeName)) {
+		return "", fmt.Errorf("backup %v of volume %v already exists", syntheticBackupName, volumeName)
+	}
+
+	synthetic := &Backup{
+		Name:              syntheticBackupName,
+		VolumeName:        volumeName,
+		SnapshotName:      backup.SnapshotName,
+		SnapshotCreatedAt: backup.SnapshotCreatedAt,
+		CreatedTime:       util.Now(),
+		Size:              backup.Size,
+		Labels:            backup.Labels,
+		Parameters:        backup.Parameters,
+		CompressionMethod: backup.CompressionMethod,
+		Blocks:            backup.Blocks,
+		InlineBlocks:      backup.InlineBlocks,
+		SourceClusterID:   GetClientID(),
+		SourceNodeID:      GetNodeID(),
+
+		LogicalSectorSize:  backup.LogicalSectorSize,
+		PhysicalSectorSize: backup.PhysicalSectorSize,
+
+		Description:     backup.Description,
+		UserMetadata:    backup.UserMetadata,
+		MetadataVersion: backup.MetadataVersion,
+	}
+	promoteToSyntheticFull(synthetic)
+
+	// The blocks are retained as long as the synthetic full backup referencing them
+	if err := extendBlockRetention(bsDriver, synthetic, RETENTION_EXTENSION_CONCURRENCY); err != nil {
+		return "", err
+	}
+	if err := saveBackup(bsDriver, synthetic); err != nil {
+		return "", err
+	}
+	recordBackupHistory(bsDriver, volumeName, syntheticBackupName, HistoryEventCreated)
+
+	if volume.LastBackupName == backupName {
+		volume.LastBackupName = syntheticBackupName
+		if err := saveVolume(bsDriver, volume); err != nil {
+			return "", err
+		}
+	}
+
+	log.Infof("Created synthetic full backup %v of %v blocks", syntheticBackupName, len(synthetic.Blocks))
+	return EncodeBackupURL(syntheticBackupName, volumeName, bsDriver.GetURL()), nil
+}
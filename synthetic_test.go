@@ -0,0 +1,115 @@
+package backupstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+func TestCreateSyntheticFullBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	err := m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"),
+		[]byte(`{"Name":"pvc-1","Size":"4096","LastBackupName":"backup-2"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-2", "pvc-1"),
+		[]byte(`{"Name":"backup-2","VolumeName":"pvc-1","SnapshotName":"snap-2","SnapshotCreatedAt":"2021-06-07T08:00:00Z",`+
+			`"CreatedTime":"2021-06-07T08:00:00Z","IsIncremental":true,"ChainLength":"1",`+
+			`"LogicalSectorSize":"512","PhysicalSectorSize":"4096","Description":"nightly",`+
+			`"UserMetadata":{"owner":"team-a"},"MetadataVersion":"2",`+
+			`"Blocks":[{"Offset":0,"BlockChecksum":"0123456789abcdef"}]}`), 0644)
+	assert.NoError(err)
+
+	backupURL := EncodeBackupURL("backup-2", "pvc-1", mockDriverURL)
+	syntheticURL, err := CreateSyntheticFullBackup(backupURL, "backup-full")
+	assert.NoError(err)
+	assert.Equal(EncodeBackupURL("backup-full", "pvc-1", mockDriverURL), syntheticURL)
+
+	backup, err := loadBackup(m, "backup-full", "pvc-1")
+	assert.NoError(err)
+	assert.False(backup.IsIncremental)
+	assert.True(backup.IsSyntheticFull)
+	assert.Equal(int64(0), backup.ChainLength)
+	assert.Equal("snap-2", backup.SnapshotName)
+	assert.Len(backup.Blocks, 1)
+	assert.Equal(int64(512), backup.LogicalSectorSize)
+	assert.Equal(int64(4096), backup.PhysicalSectorSize)
+	assert.Equal("nightly", backup.Description)
+	assert.JSONEq(`{"owner":"team-a"}`, string(backup.UserMetadata))
+	assert.Equal(int64(2), backup.MetadataVersion)
+
+	// The following backups are chained from the synthetic full backup
+	volume, err := loadVolume(m, "pvc-1")
+	assert.NoError(err)
+	assert.Equal("backup-full", volume.LastBackupName)
+
+	_, err = CreateSyntheticFullBackup(backupURL, "backup-full")
+	assert.Error(err)
+}
+
+func TestPerformBackupMaxChainLength(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	data := bytes.Repeat([]byte{'a'}, DEFAULT_BLOCK_SIZE)
+	volume := &Volume{Name: "pvc-1", Size: int64(len(data)), CompressionMethod: "lz4"}
+	err := saveVolume(m, volume)
+	assert.NoError(err)
+
+	lastBackup := &Backup{
+		Name:              "backup-2",
+		VolumeName:        volume.Name,
+		CompressionMethod: volume.CompressionMethod,
+		IsIncremental:     true,
+		ChainLength:       2,
+	}
+	for _, maxChainLength := range []int64{3, 2} {
+		config := &DeltaBackupConfig{
+			Volume:         volume,
+			Snapshot:       &Snapshot{Name: "snap-3", CreatedTime: "2021-06-07T08:00:00Z"},
+			DestURL:        mockDriverURL,
+			DeltaOps:       &memorySnapshotOps{data: data},
+			MaxChainLength: maxChainLength,
+		}
+		deltaBackup := &Backup{
+			Name:              "backup-3",
+			VolumeName:        volume.Name,
+			CompressionMethod: volume.CompressionMethod,
+			Blocks:            []BlockMapping{},
+			ProcessingBlocks: &ProcessingBlocks{
+				blocks: map[string][]*BlockMapping{},
+			},
+		}
+		delta := &types.Mappings{
+			Mappings:  []types.Mapping{{Offset: 0, Size: int64(len(data))}},
+			BlockSize: DEFAULT_BLOCK_SIZE,
+		}
+
+		_, _, err = performBackup(m, config, delta, deltaBackup, lastBackup)
+		assert.NoError(err)
+
+		backup, err := loadBackup(m, "backup-3", volume.Name)
+		assert.NoError(err)
+		if maxChainLength == 3 {
+			assert.True(backup.IsIncremental)
+			assert.Equal(int64(3), backup.ChainLength)
+		} else {
+			assert.False(backup.IsIncremental)
+			assert.True(backup.IsSyntheticFull)
+			assert.Equal(int64(0), backup.ChainLength)
+		}
+	}
+}
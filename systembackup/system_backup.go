@@ -3,7 +3,6 @@ package systembackup
 import (
 	"fmt"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -11,7 +10,6 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/longhorn/backupstore"
-	"github.com/longhorn/backupstore/util"
 )
 
 var (
@@ -79,24 +77,10 @@ func Download(localFilePath string, cfg *Config) error {
 		return fmt.Errorf("system backup %v doesn't exist", remoteBackupURI)
 	}
 
-	err = backupstore.SaveBackupStoreToLocalFile(driver, remoteBackupURI, localFilePath)
-	if err != nil {
-		return err
-	}
-
-	sha512sum, err := util.GetFileChecksum(localFilePath)
-	if err != nil {
-		return errors.Wrapf(err, "failed to get %v checksum", localFilePath)
-	}
-
-	if sha512sum != cfg.Checksum {
-		if err := os.Remove(localFilePath); err != nil {
-			log.WithError(err).Warnf("Failed to clean up local file %v", localFilePath)
-		}
-		return fmt.Errorf("downloaded %v checksum mismatched: got %v: expect %v", localFilePath, sha512sum, cfg.Checksum)
-	}
-
-	return nil
+	// The large system backups are downloaded in parallel ranges, then verified against the checksum
+	return backupstore.DownloadObject(driver, remoteBackupURI, localFilePath, &backupstore.DownloadOptions{
+		Checksum: cfg.Checksum,
+	})
 }
 
 func List(destURL string) (SystemBackups, error) {
@@ -119,6 +103,10 @@ func Delete(cfg *Config) error {
 		return err
 	}
 
+	if err := backupstore.CheckTargetMutable(driver, "delete system backup "+cfg.Name); err != nil {
+		return err
+	}
+
 	systemBackupURI := getSystemBackupURI(cfg.Name, cfg.LonghornVersion)
 	if err := driver.Remove(systemBackupURI); err != nil {
 		return err
@@ -0,0 +1,166 @@
+package backupstore
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CredentialsProvider provides the credential of a backup target when its driver is initialized. The keys are
+// the same as the environment variables, e.g. AWS_ACCESS_KEY_ID.
+type CredentialsProvider interface {
+	GetCredential(destURL string) (map[string]string, error)
+}
+
+// MapCredentialsProvider provides the fixed credential.
+type MapCredentialsProvider map[string]string
+
+func (p MapCredentialsProvider) GetCredential(destURL string) (map[string]string, error) {
+	copied := make(map[string]string, len(p))
+	for k, v := range p {
+		copied[k] = v
+	}
+	return copied, nil
+}
+
+// FileCredentialsProvider reads the credential from the file each time a driver is initialized, so the rotated
+// credential is picked up by the drivers initialized later. The file is either a JSON object, or a directory
+// with a file per key like a mounted Kubernetes secret.
+type FileCredentialsProvider struct {
+	Path string
+}
+
+func (p *FileCredentialsProvider) GetCredential(destURL string) (map[string]string, error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read credential of backup target %v", destURL)
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(p.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read credential of backup target %v", destURL)
+		}
+		credential := map[string]string{}
+		if err := json.Unmarshal(data, &credential); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse credential file %v of backup target %v", p.Path, destURL)
+		}
+		return credential, nil
+	}
+
+	entries, err := os.ReadDir(p.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read credential of backup target %v", destURL)
+	}
+	credential := map[string]string{}
+	for _, entry := range entries {
+		// The hidden entries are the internals of the mounted secret, e.g. ..data
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(p.Path, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read credential of backup target %v", destURL)
+		}
+		credential[entry.Name()] = string(data)
+	}
+	return credential, nil
+}
+
+// CredentialsProviderFunc provides the credential by the callback, e.g. to fetch it from a secret store.
+type CredentialsProviderFunc func(destURL string) (map[string]string, error)
+
+func (f CredentialsProviderFunc) GetCredential(destURL string) (map[string]string, error) {
+	return f(destURL)
+}
+
+var (
+	targetCredentialsLock sync.RWMutex
+	targetCredentials     = map[string]CredentialsProvider{}
+)
+
+// getTargetCredentialKey returns the backup target URL without the query, so the credential is shared by the
+// URLs of the same target with different options.
+func getTargetCredentialKey(destURL string) string {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return destURL
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+// SetTargetCredential sets the credential used by the driver of the backup target instead of the process-wide
+// environment variables, so the targets of different accounts can be accessed concurrently. The keys are the
+// same as the environment variables, e.g. AWS_ACCESS_KEY_ID. The drivers already initialized are not affected.
+func SetTargetCredential(destURL string, credential map[string]string) {
+	copied := make(MapCredentialsProvider, len(credential))
+	for k, v := range credential {
+		copied[k] = v
+	}
+	SetTargetCredentialsProvider(destURL, copied)
+}
+
+// SetTargetCredentialsProvider sets the provider of the credential used by the driver of the backup target
+// instead of the process-wide environment variables. The provider is called each time the driver is initialized.
+func SetTargetCredentialsProvider(destURL string, provider CredentialsProvider) {
+	targetCredentialsLock.Lock()
+	defer targetCredentialsLock.Unlock()
+	targetCredentials[getTargetCredentialKey(destURL)] = provider
+}
+
+// RemoveTargetCredential removes the credential or the provider of the backup target, the environment variables
+// are used again.
+func RemoveTargetCredential(destURL string) {
+	targetCredentialsLock.Lock()
+	defer targetCredentialsLock.Unlock()
+	delete(targetCredentials, getTargetCredentialKey(destURL))
+}
+
+// GetTargetCredential returns a copy of the credential of the backup target, or nil if the driver should fall
+// back to the environment variables. The credential is empty if the provider fails, so the environment
+// variables of another account are never used for the target.
+func GetTargetCredential(destURL string) map[string]string {
+	credential, err := LoadTargetCredential(destURL)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to get credential of backup target %v", destURL)
+		return map[string]string{}
+	}
+	return credential
+}
+
+// TargetEnv returns the lookup of the settings of a driver, e.g. AWS_ACCESS_KEY_ID, in the credential of the
+// backup target, or in the environment variables if the credential is nil. The empty credential doesn't fall
+// back to the environment variables, so the targets of different accounts never share them.
+func TargetEnv(credential map[string]string) func(string) string {
+	if credential == nil {
+		return os.Getenv
+	}
+	return func(key string) string {
+		return credential[key]
+	}
+}
+
+// LoadTargetCredential returns the credential of the backup target from its provider, or nil if the driver
+// should fall back to the environment variables.
+func LoadTargetCredential(destURL string) (map[string]string, error) {
+	targetCredentialsLock.RLock()
+	provider, exists := targetCredentials[getTargetCredentialKey(destURL)]
+	targetCredentialsLock.RUnlock()
+	if !exists {
+		return nil, nil
+	}
+
+	credential, err := provider.GetCredential(destURL)
+	if err != nil {
+		return nil, err
+	}
+	if credential == nil {
+		credential = map[string]string{}
+	}
+	return credential, nil
+}
@@ -0,0 +1,98 @@
+package backupstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetCredential(t *testing.T) {
+	assert := assert.New(t)
+
+	destURL := "s3://bucket-1@us-east-1/backupstore"
+	assert.Nil(GetTargetCredential(destURL))
+
+	credential := map[string]string{"AWS_ACCESS_KEY_ID": "key-1"}
+	SetTargetCredential(destURL, credential)
+	defer RemoveTargetCredential(destURL)
+	// The credential is copied, so the later changes by the caller don't bleed into the target
+	credential["AWS_ACCESS_KEY_ID"] = "key-2"
+
+	assert.Equal(map[string]string{"AWS_ACCESS_KEY_ID": "key-1"}, GetTargetCredential(destURL))
+	assert.Equal(map[string]string{"AWS_ACCESS_KEY_ID": "key-1"}, GetTargetCredential(destURL+"?immutable=true"))
+	assert.Nil(GetTargetCredential("s3://bucket-2@us-east-1/backupstore"))
+
+	RemoveTargetCredential(destURL)
+	assert.Nil(GetTargetCredential(destURL))
+}
+
+func TestTargetEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+
+	// The environment variables are used without the credential of the backup target
+	getenv := TargetEnv(nil)
+	assert.Equal("env-key", getenv("AWS_ACCESS_KEY_ID"))
+
+	getenv = TargetEnv(map[string]string{"AWS_ACCESS_KEY_ID": "key-1"})
+	assert.Equal("key-1", getenv("AWS_ACCESS_KEY_ID"))
+	assert.Empty(getenv("AWS_SECRET_ACCESS_KEY"))
+
+	getenv = TargetEnv(map[string]string{})
+	assert.Empty(getenv("AWS_ACCESS_KEY_ID"))
+}
+
+func TestTargetCredentialsProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	destURL := "azblob://container-1@core.windows.net/backupstore"
+	defer RemoveTargetCredential(destURL)
+
+	calls := 0
+	SetTargetCredentialsProvider(destURL, CredentialsProviderFunc(func(url string) (map[string]string, error) {
+		calls++
+		return map[string]string{"AZBLOB_ACCOUNT_NAME": fmt.Sprintf("account-%v", calls)}, nil
+	}))
+	// The provider is called each time, so the rotated credential is picked up
+	credential, err := LoadTargetCredential(destURL)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"AZBLOB_ACCOUNT_NAME": "account-1"}, credential)
+	assert.Equal(map[string]string{"AZBLOB_ACCOUNT_NAME": "account-2"}, GetTargetCredential(destURL))
+
+	// The failing provider never falls back to the environment variables
+	SetTargetCredentialsProvider(destURL, CredentialsProviderFunc(func(url string) (map[string]string, error) {
+		return nil, fmt.Errorf("secret store is unavailable")
+	}))
+	_, err = LoadTargetCredential(destURL)
+	assert.Error(err)
+	assert.Equal(map[string]string{}, GetTargetCredential(destURL))
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "credential.json")
+	err = os.WriteFile(file, []byte(`{"AZBLOB_ACCOUNT_NAME":"account-1","AZBLOB_ACCOUNT_KEY":"key-1"}`), 0600)
+	assert.NoError(err)
+	SetTargetCredentialsProvider(destURL, &FileCredentialsProvider{Path: file})
+	credential, err = LoadTargetCredential(destURL)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"AZBLOB_ACCOUNT_NAME": "account-1", "AZBLOB_ACCOUNT_KEY": "key-1"}, credential)
+
+	// The directory is read like a mounted secret
+	secretDir := filepath.Join(dir, "secret")
+	err = os.MkdirAll(filepath.Join(secretDir, "..data"), 0700)
+	assert.NoError(err)
+	err = os.WriteFile(filepath.Join(secretDir, "AZBLOB_ACCOUNT_NAME"), []byte("account-2"), 0600)
+	assert.NoError(err)
+	SetTargetCredentialsProvider(destURL, &FileCredentialsProvider{Path: secretDir})
+	credential, err = LoadTargetCredential(destURL)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"AZBLOB_ACCOUNT_NAME": "account-2"}, credential)
+
+	SetTargetCredentialsProvider(destURL, &FileCredentialsProvider{Path: filepath.Join(dir, "missing")})
+	_, err = LoadTargetCredential(destURL)
+	assert.Error(err)
+}
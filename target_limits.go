@@ -0,0 +1,249 @@
+package backupstore
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+// TargetLimits are the limits shared by all the volumes backed up to a backup target in this process, so the
+// small backup targets are not overwhelmed when many backups start at once. The zero values are unlimited.
+type TargetLimits struct {
+	// MaxConcurrentBackups is the max number of the backups being created at the same time
+	MaxConcurrentBackups int
+	// MaxInFlightUploads is the max number of the block and file uploads in flight at the same time
+	MaxInFlightUploads int
+
+	// BackupBytesPerSecond and BackupRequestsPerSecond limit the bandwidth and the rate of the block and file
+	// uploads of the backups
+	BackupBytesPerSecond    int64
+	BackupRequestsPerSecond int
+	// RestoreBytesPerSecond and RestoreRequestsPerSecond limit the bandwidth and the rate of the block downloads
+	// of the restores. They're budgeted separately from the backups, so the restores can run at full speed
+	// while the routine backups are throttled.
+	RestoreBytesPerSecond    int64
+	RestoreRequestsPerSecond int
+}
+
+// semaphore is a counting semaphore, the nil one is unlimited.
+type semaphore chan struct{}
+
+func newSemaphore(size int) semaphore {
+	if size <= 0 {
+		return nil
+	}
+	return make(semaphore, size)
+}
+
+func (s semaphore) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// rateLimiter is a token bucket holding up to one second of the rate, the nil one is unlimited. The requests
+// larger than the bucket borrow from the future, so they're delayed instead of being rejected.
+type rateLimiter struct {
+	lock      sync.Mutex
+	rate      float64
+	available float64
+	last      time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), available: float64(rate), last: util.GetClock().Now()}
+}
+
+// wait waits until n tokens are available.
+func (r *rateLimiter) wait(n int64) {
+	if r == nil || n <= 0 {
+		return
+	}
+
+	r.lock.Lock()
+	now := util.GetClock().Now()
+	r.available += now.Sub(r.last).Seconds() * r.rate
+	if r.available > r.rate {
+		r.available = r.rate
+	}
+	r.last = now
+	r.available -= float64(n)
+	var delay time.Duration
+	if r.available < 0 {
+		delay = time.Duration(-r.available / r.rate * float64(time.Second))
+	}
+	r.lock.Unlock()
+
+	if delay > 0 {
+		util.GetClock().Sleep(delay)
+	}
+}
+
+type throttledReader struct {
+	io.Reader
+	bandwidth *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.Reader.Read(p)
+	t.bandwidth.wait(int64(n))
+	return n, err
+}
+
+type targetLimiter struct {
+	limits  TargetLimits
+	backups semaphore
+	uploads semaphore
+
+	backupBandwidth  *rateLimiter
+	backupRequests   *rateLimiter
+	restoreBandwidth *rateLimiter
+	restoreRequests  *rateLimiter
+}
+
+var (
+	targetLimitersLock sync.RWMutex
+	targetLimiters     = map[string]*targetLimiter{}
+)
+
+// getTargetLimitsKey returns the key of the limits of the backup target, which is the same for the backup target
+// URL and the URL of its driver. The query, the password and the slashes around the path are dropped, so the
+// limits are set without initializing the driver, e.g. mounting the share.
+func getTargetLimitsKey(destURL string) (string, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return "", err
+	}
+	host := u.Host
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	return u.Scheme + "://" + host + "/" + strings.Trim(u.Path, "/"), nil
+}
+
+// SetTargetLimits sets the limits of the backup target. The backups and the uploads already holding a slot
+// are not affected by the change.
+func SetTargetLimits(destURL string, limits TargetLimits) error {
+	if limits.MaxConcurrentBackups < 0 || limits.MaxInFlightUploads < 0 ||
+		limits.BackupBytesPerSecond < 0 || limits.BackupRequestsPerSecond < 0 ||
+		limits.RestoreBytesPerSecond < 0 || limits.RestoreRequestsPerSecond < 0 {
+		return fmt.Errorf("invalid limits %+v of backup target %v", limits, destURL)
+	}
+	key, err := getTargetLimitsKey(destURL)
+	if err != nil {
+		return err
+	}
+
+	targetLimitersLock.Lock()
+	defer targetLimitersLock.Unlock()
+
+	if limits == (TargetLimits{}) {
+		delete(targetLimiters, key)
+		return nil
+	}
+	targetLimiters[key] = &targetLimiter{
+		limits:  limits,
+		backups: newSemaphore(limits.MaxConcurrentBackups),
+		uploads: newSemaphore(limits.MaxInFlightUploads),
+
+		backupBandwidth:  newRateLimiter(limits.BackupBytesPerSecond),
+		backupRequests:   newRateLimiter(int64(limits.BackupRequestsPerSecond)),
+		restoreBandwidth: newRateLimiter(limits.RestoreBytesPerSecond),
+		restoreRequests:  newRateLimiter(int64(limits.RestoreRequestsPerSecond)),
+	}
+	return nil
+}
+
+// GetTargetLimits returns the limits of the backup target.
+func GetTargetLimits(driver BackupStoreDriver) TargetLimits {
+	if limiter := getTargetLimiter(driver); limiter != nil {
+		return limiter.limits
+	}
+	return TargetLimits{}
+}
+
+func getTargetLimiter(driver BackupStoreDriver) *targetLimiter {
+	key, err := getTargetLimitsKey(driver.GetURL())
+	if err != nil {
+		return nil
+	}
+
+	targetLimitersLock.RLock()
+	defer targetLimitersLock.RUnlock()
+	return targetLimiters[key]
+}
+
+// acquireBackupSlot waits until a backup can be created in the backup target, and returns the function
+// releasing the slot.
+func acquireBackupSlot(driver BackupStoreDriver) func() {
+	limiter := getTargetLimiter(driver)
+	if limiter == nil {
+		return func() {}
+	}
+	limiter.backups.acquire()
+	return limiter.backups.release
+}
+
+// acquireUploadSlot waits until an upload can be started to the backup target, and returns the function
+// releasing the slot.
+func acquireUploadSlot(driver BackupStoreDriver) func() {
+	limiter := getTargetLimiter(driver)
+	if limiter == nil {
+		return func() {}
+	}
+	limiter.uploads.acquire()
+	return limiter.uploads.release
+}
+
+// throttleBackupUpload waits until the upload of the given size fits in the backup budgets of the backup target.
+func throttleBackupUpload(driver BackupStoreDriver, size int64) {
+	limiter := getTargetLimiter(driver)
+	if limiter == nil {
+		return
+	}
+	limiter.backupRequests.wait(1)
+	limiter.backupBandwidth.wait(size)
+}
+
+// restoreThrottledDriver throttles the reads of the restores by the restore budgets of the backup target.
+type restoreThrottledDriver struct {
+	BackupStoreDriver
+	limiter *targetLimiter
+}
+
+func (d *restoreThrottledDriver) Unwrap() BackupStoreDriver {
+	return d.BackupStoreDriver
+}
+
+func (d *restoreThrottledDriver) Read(src string) (io.ReadCloser, error) {
+	d.limiter.restoreRequests.wait(1)
+	rc, err := d.BackupStoreDriver.Read(src)
+	if err != nil || d.limiter.restoreBandwidth == nil {
+		return rc, err
+	}
+	return &readCloser{Reader: &throttledReader{Reader: rc, bandwidth: d.limiter.restoreBandwidth}, Closer: rc}, nil
+}
+
+// getRestoreDriver returns the driver reading the blocks of the restores, which is throttled if the backup
+// target has the restore limits.
+func getRestoreDriver(driver BackupStoreDriver) BackupStoreDriver {
+	limiter := getTargetLimiter(driver)
+	if limiter == nil || (limiter.restoreBandwidth == nil && limiter.restoreRequests == nil) {
+		return driver
+	}
+	return &restoreThrottledDriver{BackupStoreDriver: driver, limiter: limiter}
+}
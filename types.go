@@ -7,8 +7,12 @@ const (
 	BLOCKS_DIRECTORY      = "blocks"
 	BLOCK_SEPARATE_LAYER1 = 2
 	BLOCK_SEPARATE_LAYER2 = 4
+	BLOCK_SEPARATE_LAYER3 = 6
 	BLK_SUFFIX            = ".blk"
 
+	// DEFAULT_READ_COALESCE_SIZE is the max size of a snapshot read coalescing the adjacent changed extents
+	DEFAULT_READ_COALESCE_SIZE = 8 * DEFAULT_BLOCK_SIZE
+
 	PROGRESS_PERCENTAGE_BACKUP_SNAPSHOT = 95
 	PROGRESS_PERCENTAGE_BACKUP_TOTAL    = 100
 )
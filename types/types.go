@@ -8,6 +8,9 @@ const (
 	ProgressStateComplete   = ProgressState("complete")
 	ProgressStateError      = ProgressState("error")
 	ProgressStateCanceled   = ProgressState("canceled")
+	// ProgressStateInterrupted is the state of a persisted in progress operation which is no longer running,
+	// e.g. the process restarted in the middle of it
+	ProgressStateInterrupted = ProgressState("interrupted")
 )
 
 const (
@@ -16,18 +19,81 @@ const (
 	AWSEndPoint  = "AWS_ENDPOINTS"
 	AWSCert      = "AWS_CERT"
 
+	AWSClientCert         = "AWS_CLIENT_CERT"
+	AWSClientKey          = "AWS_CLIENT_KEY"
+	AWSInsecureSkipVerify = "AWS_INSECURE_SKIP_VERIFY"
+
+	AWSRoleARN              = "AWS_ROLE_ARN"
+	AWSWebIdentityTokenFile = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	AWSSSEKMSKeyID          = "AWS_SSE_KMS_KEY_ID"
+	AWSSSECustomerKey       = "AWS_SSE_CUSTOMER_KEY"
+
 	CIFSUsername = "CIFS_USERNAME"
 	CIFSPassword = "CIFS_PASSWORD"
+	CIFSDomain   = "CIFS_DOMAIN"
+
+	CephFSUser    = "CEPHFS_USER"
+	CephFSSecret  = "CEPHFS_SECRET"
+	CephFSKeyring = "CEPHFS_KEYRING"
 
 	AZBlobAccountName = "AZBLOB_ACCOUNT_NAME"
 	AZBlobAccountKey  = "AZBLOB_ACCOUNT_KEY"
 	AZBlobEndpoint    = "AZBLOB_ENDPOINT"
 	AZBlobCert        = "AZBLOB_CERT"
+	AZBlobSASToken    = "AZBLOB_SAS_TOKEN"
+
+	AZBlobCredentialSource = "AZBLOB_CREDENTIAL_SOURCE"
+
+	AzureTenantID           = "AZURE_TENANT_ID"
+	AzureClientID           = "AZURE_CLIENT_ID"
+	AzureClientSecret       = "AZURE_CLIENT_SECRET"
+	AzureFederatedTokenFile = "AZURE_FEDERATED_TOKEN_FILE"
+	AzureAuthorityHost      = "AZURE_AUTHORITY_HOST"
+
+	AZBlobClientCert         = "AZBLOB_CLIENT_CERT"
+	AZBlobClientKey          = "AZBLOB_CLIENT_KEY"
+	AZBlobInsecureSkipVerify = "AZBLOB_INSECURE_SKIP_VERIFY"
+
+	GCSServiceAccount = "GCS_SERVICE_ACCOUNT_JSON"
+	GCSEndpoint       = "GCS_ENDPOINT"
+	GCSCert           = "GCS_CERT"
+
+	SFTPPassword   = "SFTP_PASSWORD"
+	SFTPPrivateKey = "SFTP_PRIVATE_KEY"
+	SFTPKnownHosts = "SFTP_KNOWN_HOSTS"
+
+	SwiftAuthURL                     = "OS_AUTH_URL"
+	SwiftUsername                    = "OS_USERNAME"
+	SwiftPassword                    = "OS_PASSWORD"
+	SwiftUserDomainName              = "OS_USER_DOMAIN_NAME"
+	SwiftProjectName                 = "OS_PROJECT_NAME"
+	SwiftProjectDomainName           = "OS_PROJECT_DOMAIN_NAME"
+	SwiftApplicationCredentialID     = "OS_APPLICATION_CREDENTIAL_ID"
+	SwiftApplicationCredentialSecret = "OS_APPLICATION_CREDENTIAL_SECRET"
+	SwiftRegion                      = "OS_REGION_NAME"
+	SwiftCert                        = "SWIFT_CERT"
+
+	WebDAVUsername           = "WEBDAV_USERNAME"
+	WebDAVPassword           = "WEBDAV_PASSWORD"
+	WebDAVCert               = "WEBDAV_CERT"
+	WebDAVInsecureSkipVerify = "WEBDAV_INSECURE_SKIP_VERIFY"
+
+	HTTPSCert = "HTTPS_CERT"
+
+	OSSAccessKeyID     = "OSS_ACCESS_KEY_ID"
+	OSSAccessKeySecret = "OSS_ACCESS_KEY_SECRET"
+	OSSSessionToken    = "OSS_SESSION_TOKEN"
+	OSSRAMRoleName     = "OSS_RAM_ROLE_NAME"
+	OSSEndpoint        = "OSS_ENDPOINT"
+	OSSCert            = "OSS_CERT"
 
 	HTTPSProxy = "HTTPS_PROXY"
 	HTTPProxy  = "HTTP_PROXY"
 	NOProxy    = "NO_PROXY"
 
+	ProxyUsername = "PROXY_USERNAME"
+	ProxyPassword = "PROXY_PASSWORD"
+
 	VirtualHostedStyle = "VIRTUAL_HOSTED_STYLE"
 )
 
@@ -44,7 +110,8 @@ type Mappings struct {
 type MessageType string
 
 const (
-	MessageTypeError = MessageType("error")
+	MessageTypeError   = MessageType("error")
+	MessageTypeWarning = MessageType("warning")
 )
 
 type JobResult struct {
@@ -0,0 +1,198 @@
+package backupstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/longhorn/backupstore/logging"
+)
+
+// DeletedBackupInfo describes a deleted backup which can still be recovered from a versioned backup target.
+type DeletedBackupInfo struct {
+	Name       string
+	VolumeName string
+	VersionID  string
+	DeletedAt  time.Time
+}
+
+func getVersionedDriver(driver BackupStoreDriver) (VersionedBackupStoreDriver, error) {
+	versioned, ok := findBackendDriver[VersionedBackupStoreDriver](driver)
+	if !ok {
+		return nil, fmt.Errorf("backup target %v doesn't support undeleting backups", driver.GetURL())
+	}
+	return versioned, nil
+}
+
+// listDeletedObjects lists the deleted objects by the wrapped driver.
+func listDeletedObjects(driver BackupStoreDriver, path string) ([]DeletedObject, error) {
+	versioned, ok := findDriver[VersionedBackupStoreDriver](driver)
+	if !ok {
+		return nil, fmt.Errorf("backup target %v doesn't support undeleting backups", driver.GetURL())
+	}
+	return versioned.ListDeletedObjects(path)
+}
+
+// restoreObjectVersion restores the version of the object by the wrapped driver.
+func restoreObjectVersion(driver BackupStoreDriver, filePath, versionID string) error {
+	versioned, ok := findDriver[VersionedBackupStoreDriver](driver)
+	if !ok {
+		return fmt.Errorf("backup target %v doesn't support undeleting backups", driver.GetURL())
+	}
+	return versioned.RestoreObjectVersion(filePath, versionID)
+}
+
+// ListDeletedBackups returns the deleted backups of the volume which still have a recoverable version
+// in the backup target, sorted by the deletion time.
+func ListDeletedBackups(volumeURL string) ([]*DeletedBackupInfo, error) {
+	driver, err := GetBackupStoreDriver(volumeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	_, volumeName, _, err := DecodeBackupURL(volumeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	versioned, err := getVersionedDriver(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := versioned.ListDeletedObjects(getBackupPath(volumeName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list deleted backups of volume %v", volumeName)
+	}
+
+	result := []*DeletedBackupInfo{}
+	for _, object := range objects {
+		name, ok := GetPathLayout().ParseBackupConfigName(filepath.Base(object.Path))
+		if !ok {
+			continue
+		}
+		result = append(result, &DeletedBackupInfo{
+			Name:       name,
+			VolumeName: volumeName,
+			VersionID:  object.VersionID,
+			DeletedAt:  object.DeletedAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DeletedAt.Before(result[j].DeletedAt)
+	})
+	return result, nil
+}
+
+// UndeleteBackup recovers a deleted backup from a versioned backup target. The latest version of the backup
+// config is used if the version ID is empty. The volume config and the deleted blocks referenced by the
+// backup are recovered as well.
+func UndeleteBackup(backupURL, versionID string) error {
+	driver, err := GetBackupStoreDriver(backupURL)
+	if err != nil {
+		return err
+	}
+
+	backupName, volumeName, _, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+	log := log.WithFields(logrus.Fields{
+		LogFieldBackup: backupName,
+		LogFieldVolume: volumeName,
+	})
+
+	if err := CheckTargetMutable(driver, fmt.Sprintf("undelete backup %v", backupName)); err != nil {
+		return err
+	}
+	versioned, err := getVersionedDriver(driver)
+	if err != nil {
+		return err
+	}
+
+	lock, err := New(driver, volumeName, BACKUP_LOCK)
+	if err != nil {
+		return err
+	}
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := lock.Unlock(); unlockErr != nil {
+			log.WithError(unlockErr).Warn("Failed to unlock")
+		}
+	}()
+
+	backupConfigPath := getBackupConfigPath(backupName, volumeName)
+	if driver.FileExists(backupConfigPath) {
+		return fmt.Errorf("backup %v of volume %v is not deleted", backupName, volumeName)
+	}
+
+	deleted, err := versioned.ListDeletedObjects(getVolumePath(volumeName))
+	if err != nil {
+		return errors.Wrapf(err, "failed to list deleted objects of volume %v", volumeName)
+	}
+	deletedVersions := map[string]string{}
+	for _, object := range deleted {
+		deletedVersions[object.Path] = object.VersionID
+	}
+
+	if versionID == "" {
+		versionID = deletedVersions[backupConfigPath]
+		if versionID == "" {
+			return fmt.Errorf("cannot find a recoverable version of backup %v of volume %v", backupName, volumeName)
+		}
+	}
+	if err := versioned.RestoreObjectVersion(backupConfigPath, versionID); err != nil {
+		return errors.Wrapf(err, "failed to recover backup config of backup %v", backupName)
+	}
+
+	volumeConfigPath := getVolumeFilePath(volumeName)
+	if !driver.FileExists(volumeConfigPath) {
+		volumeVersionID, exists := deletedVersions[volumeConfigPath]
+		if !exists {
+			return fmt.Errorf("cannot find a recoverable version of volume %v config", volumeName)
+		}
+		if err := versioned.RestoreObjectVersion(volumeConfigPath, volumeVersionID); err != nil {
+			return errors.Wrapf(err, "failed to recover config of volume %v", volumeName)
+		}
+	}
+
+	backup, err := loadBackup(driver, backupName, volumeName)
+	if err != nil {
+		return err
+	}
+	// The blocks may be written by the legacy layout
+	legacyLayout, hasLegacyPaths := GetPathLayout().(LegacyBlockPathLayout)
+	dataPaths := []string{}
+	for _, block := range backup.Blocks {
+		dataPaths = append(dataPaths, getBlockFilePath(volumeName, block.BlockChecksum))
+		if hasLegacyPaths {
+			dataPaths = append(dataPaths, legacyLayout.LegacyBlockFilePath(getBlockPath(volumeName), block.BlockChecksum))
+		}
+	}
+	if backup.SingleFile.FilePath != "" {
+		dataPaths = append(dataPaths, backup.SingleFile.FilePath)
+	}
+
+	recovered := 0
+	for _, dataPath := range dataPaths {
+		dataVersionID, exists := deletedVersions[dataPath]
+		if !exists {
+			continue
+		}
+		if err := versioned.RestoreObjectVersion(dataPath, dataVersionID); err != nil {
+			return errors.Wrapf(err, "failed to recover %v of backup %v", dataPath, backupName)
+		}
+		delete(deletedVersions, dataPath)
+		recovered++
+	}
+
+	recordBackupHistory(driver, volumeName, backupName, HistoryEventCreated)
+	log.Infof("Undeleted backup with %v recovered data files", recovered)
+	return nil
+}
@@ -0,0 +1,120 @@
+package backupstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// versionedMockDriver keeps the content of the removed files like a versioned bucket.
+type versionedMockDriver struct {
+	*mockStoreDriver
+	deleted map[string][]byte
+}
+
+func (v *versionedMockDriver) Remove(path string) error {
+	data, err := afero.ReadFile(v.fs, path)
+	if err != nil {
+		return err
+	}
+	v.deleted[path] = data
+	return v.fs.Remove(path)
+}
+
+func (v *versionedMockDriver) ListDeletedObjects(path string) ([]DeletedObject, error) {
+	result := []DeletedObject{}
+	for filePath := range v.deleted {
+		if strings.HasPrefix(filePath, path) && !v.FileExists(filePath) {
+			result = append(result, DeletedObject{Path: filePath, VersionID: "v1", DeletedAt: time.Now().UTC()})
+		}
+	}
+	return result, nil
+}
+
+func (v *versionedMockDriver) RestoreObjectVersion(filePath, versionID string) error {
+	data, exists := v.deleted[filePath]
+	if !exists || versionID != "v1" {
+		return fmt.Errorf("cannot find version %v of %v", versionID, filePath)
+	}
+	if err := v.fs.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	return afero.WriteFile(v.fs, filePath, data, 0644)
+}
+
+func TestUndeleteBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{delay: time.Millisecond}
+	m.Init()
+	defer m.uninstall()
+
+	volumeURL := EncodeBackupURL("", "pvc-1", mockDriverURL)
+	_, err := ListDeletedBackups(volumeURL)
+	assert.Error(err)
+
+	v := &versionedMockDriver{mockStoreDriver: m, deleted: map[string][]byte{}}
+	unregisterDriver(mockDriverName) // nolint:errcheck
+	err = RegisterDriver(mockDriverName, func(destURL string) (BackupStoreDriver, error) {
+		return v, nil
+	})
+	assert.NoError(err)
+
+	checksum := "0123456789abcdef"
+	err = m.fs.MkdirAll(getBackupPath("pvc-1"), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getVolumeFilePath("pvc-1"), []byte(`{"Name":"pvc-1"}`), 0644)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBackupConfigPath("backup-1", "pvc-1"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","CreatedTime":"2021-06-07T08:00:00Z",`+
+			`"Blocks":[{"Offset":0,"BlockChecksum":"`+checksum+`"}]}`), 0644)
+	assert.NoError(err)
+	err = m.fs.MkdirAll(filepath.Dir(getBlockFilePath("pvc-1", checksum)), 0755)
+	assert.NoError(err)
+	err = afero.WriteFile(m.fs, getBlockFilePath("pvc-1", checksum), []byte("data"), 0644)
+	assert.NoError(err)
+
+	backupURL := EncodeBackupURL("backup-1", "pvc-1", mockDriverURL)
+	err = UndeleteBackup(backupURL, "")
+	assert.Error(err)
+
+	assert.NoError(v.Remove(getBackupConfigPath("backup-1", "pvc-1")))
+	assert.NoError(v.Remove(getBlockFilePath("pvc-1", checksum)))
+
+	deleted, err := ListDeletedBackups(volumeURL)
+	assert.NoError(err)
+	assert.Equal(1, len(deleted))
+	assert.Equal("backup-1", deleted[0].Name)
+
+	// The read-only and immutable targets list the deleted backups but refuse to undelete them
+	for _, option := range []string{ReadOnlyTargetOption, ImmutableTargetOption} {
+		targetURL := mockDriverURL + "?" + option + "=true"
+		deleted, err = ListDeletedBackups(EncodeBackupURL("", "pvc-1", targetURL))
+		assert.NoError(err)
+		assert.Equal(1, len(deleted))
+		err = UndeleteBackup(EncodeBackupURL("backup-1", "pvc-1", targetURL), "")
+		assert.True(IsReadOnlyTargetError(err) || IsImmutableTargetError(err), "unexpected error %v", err)
+		assert.False(m.FileExists(getBackupConfigPath("backup-1", "pvc-1")))
+
+		driver, err := GetBackupStoreDriver(targetURL)
+		assert.NoError(err)
+		versioned, err := getVersionedDriver(driver)
+		assert.NoError(err)
+		err = versioned.RestoreObjectVersion(getBackupConfigPath("backup-1", "pvc-1"), "v1")
+		assert.True(IsReadOnlyTargetError(err) || IsImmutableTargetError(err), "unexpected error %v", err)
+	}
+
+	err = UndeleteBackup(backupURL, "")
+	assert.NoError(err)
+	assert.True(m.FileExists(getBackupConfigPath("backup-1", "pvc-1")))
+	assert.True(m.FileExists(getBlockFilePath("pvc-1", checksum)))
+
+	deleted, err = ListDeletedBackups(volumeURL)
+	assert.NoError(err)
+	assert.Equal(0, len(deleted))
+}
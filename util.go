@@ -1,6 +1,7 @@
 package backupstore
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
 	"io"
@@ -18,12 +19,13 @@ func getBlockPath(volumeName string) string {
 }
 
 func getBlockFilePath(volumeName, checksum string) string {
-	blockSubDirLayer1 := checksum[0:BLOCK_SEPARATE_LAYER1]
-	blockSubDirLayer2 := checksum[BLOCK_SEPARATE_LAYER1:BLOCK_SEPARATE_LAYER2]
-	path := filepath.Join(getBlockPath(volumeName), blockSubDirLayer1, blockSubDirLayer2)
-	fileName := checksum + BLK_SUFFIX
+	return GetPathLayout().BlockFilePath(getBlockPath(volumeName), checksum)
+}
 
-	return filepath.Join(path, fileName)
+// findBlockFilePath returns the path of the existing block file of the volume, which may be written by the
+// legacy layout, or the path in the layout if the block doesn't exist.
+func findBlockFilePath(driver BackupStoreDriver, volumeName, checksum string) string {
+	return findLayoutBlockFilePath(driver, getBlockPath(volumeName), checksum)
 }
 
 // mergeErrorChannels will merge all error channels into a single error out channel.
@@ -61,23 +63,32 @@ func mergeErrorChannels(ctx context.Context, channels ...<-chan error) <-chan er
 // DecompressAndVerifyWithFallback decompresses the given data and verifies the data integrity.
 // If the decompression fails, it will try to decompress with the fallback method.
 func DecompressAndVerifyWithFallback(bsDriver BackupStoreDriver, blkFile, decompression, checksum string) (io.Reader, error) {
-	// Helper function to read block from backup store
-	readBlock := func() (io.ReadCloser, error) {
-		rc, err := bsDriver.Read(blkFile)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to read block %v", blkFile)
-		}
-		return rc, nil
+	data, err := readBlockData(bsDriver, blkFile)
+	if err != nil {
+		return nil, err
 	}
+	return decompressAndVerifyDataWithFallback(data, blkFile, decompression, checksum)
+}
 
-	// First attempt to read and decompress/verify
-	rc, err := readBlock()
+// readBlockData reads the compressed block from the backup store.
+func readBlockData(bsDriver BackupStoreDriver, blkFile string) ([]byte, error) {
+	rc, err := bsDriver.Read(blkFile)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrapf(err, "failed to read block %v", blkFile)
 	}
 	defer rc.Close()
 
-	r, err := util.DecompressAndVerify(decompression, rc, checksum)
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read block %v", blkFile)
+	}
+	return data, nil
+}
+
+// decompressAndVerifyDataWithFallback decompresses the downloaded block and verifies the data integrity, the
+// block is decompressed again with the fallback method if the decompression fails.
+func decompressAndVerifyDataWithFallback(data []byte, blkFile, decompression, checksum string) (io.Reader, error) {
+	r, err := util.DecompressAndVerify(decompression, bytes.NewReader(data), checksum)
 	if err == nil {
 		return r, nil
 	}
@@ -92,13 +103,7 @@ func DecompressAndVerifyWithFallback(bsDriver BackupStoreDriver, blkFile, decomp
 
 	// Second attempt with alternative decompression, if applicable
 	if alternativeDecompression != "" {
-		retriedRc, err := readBlock()
-		if err != nil {
-			return nil, err
-		}
-		defer retriedRc.Close()
-
-		r, err = util.DecompressAndVerify(alternativeDecompression, retriedRc, checksum)
+		r, err = util.DecompressAndVerify(alternativeDecompression, bytes.NewReader(data), checksum)
 		if err != nil {
 			return nil, errors.Wrapf(err, "fallback decompression also failed for block %v", blkFile)
 		}
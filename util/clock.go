@@ -0,0 +1,104 @@
+package util
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock provides the current time and the sleeps, so the time dependent logic can be tested without waiting.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// IDGenerator provides the unique IDs used for the names of the backups, locks and operations.
+type IDGenerator interface {
+	NewUUID() string
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewUUID() string {
+	return uuid.New().String()
+}
+
+var (
+	clockLock   sync.RWMutex
+	clock       Clock       = realClock{}
+	idGenerator IDGenerator = uuidGenerator{}
+)
+
+// SetClock replaces the clock used by the package and the backupstore, nil restores the system clock.
+func SetClock(c Clock) {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	if c == nil {
+		c = realClock{}
+	}
+	clock = c
+}
+
+// GetClock returns the clock in use.
+func GetClock() Clock {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return clock
+}
+
+// SetIDGenerator replaces the ID generator used by the package and the backupstore, nil restores the random
+// UUID generator.
+func SetIDGenerator(g IDGenerator) {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	if g == nil {
+		g = uuidGenerator{}
+	}
+	idGenerator = g
+}
+
+// GetIDGenerator returns the ID generator in use.
+func GetIDGenerator() IDGenerator {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return idGenerator
+}
+
+// FakeClock is a manually advanced Clock. Sleep advances the time instead of blocking.
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// NewFakeClock returns a FakeClock starting at the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance moves the time of the clock forward.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
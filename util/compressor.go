@@ -0,0 +1,96 @@
+package util
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	lz4 "github.com/pierrec/lz4/v4"
+)
+
+// Compressor compresses and decompresses the blocks. The name the compressor is registered with is stored
+// as the compression method of the backup volume, so the same compressor must be registered to restore it.
+type Compressor interface {
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type noneCompressor struct{}
+
+func (noneCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return NopCloser{w}, nil
+}
+
+func (noneCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type lz4Compressor struct{}
+
+func (lz4Compressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return lz4.NewWriter(w), nil
+}
+
+func (lz4Compressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(lz4.NewReader(r)), nil
+}
+
+var (
+	compressorsLock sync.RWMutex
+	compressors     = map[string]Compressor{
+		"none": noneCompressor{},
+		"gzip": gzipCompressor{},
+		"lz4":  lz4Compressor{},
+	}
+)
+
+// RegisterCompressor registers the compressor under the name, to be used as the compression method of the
+// backup volumes. Registering a name again replaces the compressor, e.g. with a hardware offloaded
+// implementation of the same format.
+func RegisterCompressor(name string, compressor Compressor) error {
+	if name == "" {
+		return fmt.Errorf("empty compression method name")
+	}
+	if compressor == nil {
+		return fmt.Errorf("nil compressor for compression method %v", name)
+	}
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	compressors[name] = compressor
+	return nil
+}
+
+// GetCompressor returns the compressor registered under the name
+func GetCompressor(name string) (Compressor, error) {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	compressor, ok := compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression method: %v", name)
+	}
+	return compressor, nil
+}
+
+// ListCompressors returns the names of the registered compressors
+func ListCompressors() []string {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
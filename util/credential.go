@@ -13,8 +13,14 @@ func SetupCredential(backupType string, credential map[string]string) error {
 		return setupS3Credential(credential)
 	case "cifs":
 		return setupCIFSCredential(credential)
+	case "cephfs":
+		return setupCephFSCredential(credential)
 	case "azblob":
 		return setupAZBlobCredential(credential)
+	case "sftp":
+		return setupSFTPCredential(credential)
+	case "webdav":
+		return setupWebDAVCredential(credential)
 	default:
 		return nil
 	}
@@ -40,12 +46,19 @@ func setupS3Credential(credential map[string]string) error {
 	os.Setenv(types.HTTPSProxy, credential[types.HTTPSProxy])
 	os.Setenv(types.HTTPProxy, credential[types.HTTPProxy])
 	os.Setenv(types.NOProxy, credential[types.NOProxy])
+	os.Setenv(types.ProxyUsername, credential[types.ProxyUsername])
+	os.Setenv(types.ProxyPassword, credential[types.ProxyPassword])
 	os.Setenv(types.VirtualHostedStyle, credential[types.VirtualHostedStyle])
+	os.Setenv(types.AWSSSEKMSKeyID, credential[types.AWSSSEKMSKeyID])
+	os.Setenv(types.AWSSSECustomerKey, credential[types.AWSSSECustomerKey])
 
 	// set a custom ca cert if available
 	if credential[types.AWSCert] != "" {
 		os.Setenv(types.AWSCert, credential[types.AWSCert])
 	}
+	os.Setenv(types.AWSClientCert, credential[types.AWSClientCert])
+	os.Setenv(types.AWSClientKey, credential[types.AWSClientKey])
+	os.Setenv(types.AWSInsecureSkipVerify, credential[types.AWSInsecureSkipVerify])
 
 	return nil
 }
@@ -57,6 +70,19 @@ func setupCIFSCredential(credential map[string]string) error {
 
 	os.Setenv(types.CIFSUsername, credential[types.CIFSUsername])
 	os.Setenv(types.CIFSPassword, credential[types.CIFSPassword])
+	os.Setenv(types.CIFSDomain, credential[types.CIFSDomain])
+
+	return nil
+}
+
+func setupCephFSCredential(credential map[string]string) error {
+	if credential == nil {
+		return nil
+	}
+
+	os.Setenv(types.CephFSUser, credential[types.CephFSUser])
+	os.Setenv(types.CephFSSecret, credential[types.CephFSSecret])
+	os.Setenv(types.CephFSKeyring, credential[types.CephFSKeyring])
 
 	return nil
 }
@@ -69,20 +95,82 @@ func setupAZBlobCredential(credential map[string]string) error {
 	if credential[types.AZBlobAccountName] == "" && credential[types.AZBlobAccountKey] != "" {
 		return errors.New("Azure Blob Storage credential account name not found")
 	}
-	if credential[types.AZBlobAccountName] != "" && credential[types.AZBlobAccountKey] == "" {
+	if credential[types.AZBlobAccountName] != "" && credential[types.AZBlobAccountKey] == "" &&
+		!isAZBlobTokenCredential(credential) {
 		return errors.New("Azure Blob Storage credential account key not found")
 	}
 
 	os.Setenv(types.AZBlobAccountName, credential[types.AZBlobAccountName])
 	os.Setenv(types.AZBlobAccountKey, credential[types.AZBlobAccountKey])
 	os.Setenv(types.AZBlobEndpoint, credential[types.AZBlobEndpoint])
+	os.Setenv(types.AZBlobSASToken, credential[types.AZBlobSASToken])
+	os.Setenv(types.AZBlobCredentialSource, credential[types.AZBlobCredentialSource])
 	os.Setenv(types.HTTPSProxy, credential[types.HTTPSProxy])
 	os.Setenv(types.HTTPProxy, credential[types.HTTPProxy])
 	os.Setenv(types.NOProxy, credential[types.NOProxy])
+	os.Setenv(types.ProxyUsername, credential[types.ProxyUsername])
+	os.Setenv(types.ProxyPassword, credential[types.ProxyPassword])
 
 	if credential[types.AZBlobCert] != "" {
 		os.Setenv(types.AZBlobCert, credential[types.AZBlobCert])
 	}
+	os.Setenv(types.AZBlobClientCert, credential[types.AZBlobClientCert])
+	os.Setenv(types.AZBlobClientKey, credential[types.AZBlobClientKey])
+	os.Setenv(types.AZBlobInsecureSkipVerify, credential[types.AZBlobInsecureSkipVerify])
+
+	// The Azure AD settings may be injected by the platform, e.g. by the workload identity webhook, so they're
+	// only overridden if set by the credential
+	for _, key := range []string{types.AzureTenantID, types.AzureClientID, types.AzureClientSecret,
+		types.AzureFederatedTokenFile, types.AzureAuthorityHost} {
+		if credential[key] != "" {
+			os.Setenv(key, credential[key])
+		}
+	}
+
+	return nil
+}
+
+// isAZBlobTokenCredential returns true if the account is accessed by the SAS token or the Azure AD credential
+// instead of the account key.
+func isAZBlobTokenCredential(credential map[string]string) bool {
+	if source := credential[types.AZBlobCredentialSource]; source != "" && source != "sharedKey" {
+		return true
+	}
+	return credential[types.AZBlobSASToken] != "" || credential[types.AzureClientSecret] != "" ||
+		credential[types.AzureFederatedTokenFile] != ""
+}
+
+func setupSFTPCredential(credential map[string]string) error {
+	if credential == nil {
+		return nil
+	}
+
+	os.Setenv(types.SFTPPassword, credential[types.SFTPPassword])
+	os.Setenv(types.SFTPPrivateKey, credential[types.SFTPPrivateKey])
+	os.Setenv(types.SFTPKnownHosts, credential[types.SFTPKnownHosts])
+
+	return nil
+}
+
+func setupWebDAVCredential(credential map[string]string) error {
+	if credential == nil {
+		return nil
+	}
+
+	if credential[types.WebDAVUsername] == "" && credential[types.WebDAVPassword] != "" {
+		return errors.New("WebDAV credential username not found")
+	}
+
+	os.Setenv(types.WebDAVUsername, credential[types.WebDAVUsername])
+	os.Setenv(types.WebDAVPassword, credential[types.WebDAVPassword])
+	os.Setenv(types.WebDAVInsecureSkipVerify, credential[types.WebDAVInsecureSkipVerify])
+	os.Setenv(types.HTTPSProxy, credential[types.HTTPSProxy])
+	os.Setenv(types.HTTPProxy, credential[types.HTTPProxy])
+	os.Setenv(types.NOProxy, credential[types.NOProxy])
+
+	if credential[types.WebDAVCert] != "" {
+		os.Setenv(types.WebDAVCert, credential[types.WebDAVCert])
+	}
 
 	return nil
 }
@@ -93,8 +181,14 @@ func getCredentialFromEnvVars(backupType string) (map[string]string, error) {
 		return getS3CredentialFromEnvVars()
 	case "cifs":
 		return getCIFSCredentialFromEnvVars()
+	case "cephfs":
+		return getCephFSCredentialFromEnvVars()
 	case "azblob":
 		return getAZBlobCredentialFromEnvVars()
+	case "sftp":
+		return getSFTPCredentialFromEnvVars()
+	case "webdav":
+		return getWebDAVCredentialFromEnvVars()
 	default:
 		return nil, nil
 	}
@@ -107,9 +201,21 @@ func getAZBlobCredentialFromEnvVars() (map[string]string, error) {
 	credential[types.AZBlobAccountKey] = os.Getenv(types.AZBlobAccountKey)
 	credential[types.AZBlobEndpoint] = os.Getenv(types.AZBlobEndpoint)
 	credential[types.AZBlobCert] = os.Getenv(types.AZBlobCert)
+	credential[types.AZBlobSASToken] = os.Getenv(types.AZBlobSASToken)
+	credential[types.AZBlobCredentialSource] = os.Getenv(types.AZBlobCredentialSource)
+	credential[types.AZBlobClientCert] = os.Getenv(types.AZBlobClientCert)
+	credential[types.AZBlobClientKey] = os.Getenv(types.AZBlobClientKey)
+	credential[types.AZBlobInsecureSkipVerify] = os.Getenv(types.AZBlobInsecureSkipVerify)
+	credential[types.AzureTenantID] = os.Getenv(types.AzureTenantID)
+	credential[types.AzureClientID] = os.Getenv(types.AzureClientID)
+	credential[types.AzureClientSecret] = os.Getenv(types.AzureClientSecret)
+	credential[types.AzureFederatedTokenFile] = os.Getenv(types.AzureFederatedTokenFile)
+	credential[types.AzureAuthorityHost] = os.Getenv(types.AzureAuthorityHost)
 	credential[types.HTTPSProxy] = os.Getenv(types.HTTPSProxy)
 	credential[types.HTTPProxy] = os.Getenv(types.HTTPProxy)
 	credential[types.NOProxy] = os.Getenv(types.NOProxy)
+	credential[types.ProxyUsername] = os.Getenv(types.ProxyUsername)
+	credential[types.ProxyPassword] = os.Getenv(types.ProxyPassword)
 
 	return credential, nil
 }
@@ -119,6 +225,41 @@ func getCIFSCredentialFromEnvVars() (map[string]string, error) {
 
 	credential[types.CIFSUsername] = os.Getenv(types.CIFSUsername)
 	credential[types.CIFSPassword] = os.Getenv(types.CIFSPassword)
+	credential[types.CIFSDomain] = os.Getenv(types.CIFSDomain)
+
+	return credential, nil
+}
+
+func getCephFSCredentialFromEnvVars() (map[string]string, error) {
+	credential := map[string]string{}
+
+	credential[types.CephFSUser] = os.Getenv(types.CephFSUser)
+	credential[types.CephFSSecret] = os.Getenv(types.CephFSSecret)
+	credential[types.CephFSKeyring] = os.Getenv(types.CephFSKeyring)
+
+	return credential, nil
+}
+
+func getSFTPCredentialFromEnvVars() (map[string]string, error) {
+	credential := map[string]string{}
+
+	credential[types.SFTPPassword] = os.Getenv(types.SFTPPassword)
+	credential[types.SFTPPrivateKey] = os.Getenv(types.SFTPPrivateKey)
+	credential[types.SFTPKnownHosts] = os.Getenv(types.SFTPKnownHosts)
+
+	return credential, nil
+}
+
+func getWebDAVCredentialFromEnvVars() (map[string]string, error) {
+	credential := map[string]string{}
+
+	credential[types.WebDAVUsername] = os.Getenv(types.WebDAVUsername)
+	credential[types.WebDAVPassword] = os.Getenv(types.WebDAVPassword)
+	credential[types.WebDAVCert] = os.Getenv(types.WebDAVCert)
+	credential[types.WebDAVInsecureSkipVerify] = os.Getenv(types.WebDAVInsecureSkipVerify)
+	credential[types.HTTPSProxy] = os.Getenv(types.HTTPSProxy)
+	credential[types.HTTPProxy] = os.Getenv(types.HTTPProxy)
+	credential[types.NOProxy] = os.Getenv(types.NOProxy)
 
 	return credential, nil
 }
@@ -141,9 +282,16 @@ func getS3CredentialFromEnvVars() (map[string]string, error) {
 
 	credential[types.AWSEndPoint] = os.Getenv(types.AWSEndPoint)
 	credential[types.AWSCert] = os.Getenv(types.AWSCert)
+	credential[types.AWSClientCert] = os.Getenv(types.AWSClientCert)
+	credential[types.AWSClientKey] = os.Getenv(types.AWSClientKey)
+	credential[types.AWSInsecureSkipVerify] = os.Getenv(types.AWSInsecureSkipVerify)
+	credential[types.AWSSSEKMSKeyID] = os.Getenv(types.AWSSSEKMSKeyID)
+	credential[types.AWSSSECustomerKey] = os.Getenv(types.AWSSSECustomerKey)
 	credential[types.HTTPSProxy] = os.Getenv(types.HTTPSProxy)
 	credential[types.HTTPProxy] = os.Getenv(types.HTTPProxy)
 	credential[types.NOProxy] = os.Getenv(types.NOProxy)
+	credential[types.ProxyUsername] = os.Getenv(types.ProxyUsername)
+	credential[types.ProxyPassword] = os.Getenv(types.ProxyPassword)
 	credential[types.VirtualHostedStyle] = os.Getenv(types.VirtualHostedStyle)
 
 	return credential, nil
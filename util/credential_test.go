@@ -0,0 +1,65 @@
+package util
+
+import (
+	"os"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/longhorn/backupstore/types"
+)
+
+func (s *TestSuite) TestSetupAZBlobCredential(c *C) {
+	defer func() {
+		for _, key := range []string{types.AZBlobAccountName, types.AZBlobAccountKey, types.AZBlobEndpoint,
+			types.AZBlobSASToken, types.AZBlobCredentialSource, types.AZBlobClientCert, types.AZBlobClientKey,
+			types.AZBlobInsecureSkipVerify, types.AzureClientSecret, types.AzureFederatedTokenFile,
+			types.HTTPSProxy, types.HTTPProxy, types.NOProxy, types.ProxyUsername, types.ProxyPassword} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	for _, tc := range []struct {
+		credential map[string]string
+		valid      bool
+	}{
+		{map[string]string{types.AZBlobAccountName: "account", types.AZBlobAccountKey: "key"}, true},
+		{map[string]string{types.AZBlobAccountKey: "key"}, false},
+		// The account key is required by the shared key
+		{map[string]string{types.AZBlobAccountName: "account"}, false},
+		{map[string]string{types.AZBlobAccountName: "account", types.AZBlobCredentialSource: "sharedKey"}, false},
+		// The account key isn't required by the SAS token and the Azure AD credentials
+		{map[string]string{types.AZBlobAccountName: "account", types.AZBlobSASToken: "sv=2022-11-02&sig=abc"}, true},
+		{map[string]string{types.AZBlobAccountName: "account", types.AZBlobCredentialSource: "managedIdentity"}, true},
+		{map[string]string{types.AZBlobAccountName: "account", types.AzureClientSecret: "secret"}, true},
+		{map[string]string{types.AZBlobAccountName: "account", types.AzureFederatedTokenFile: "/token"}, true},
+	} {
+		err := SetupCredential("azblob", tc.credential)
+		if tc.valid {
+			c.Assert(err, IsNil, Commentf("credential %v", tc.credential))
+			c.Assert(os.Getenv(types.AZBlobAccountName), Equals, tc.credential[types.AZBlobAccountName])
+		} else {
+			c.Assert(err, NotNil, Commentf("credential %v", tc.credential))
+		}
+	}
+}
+
+func (s *TestSuite) TestCephFSCredential(c *C) {
+	defer func() {
+		for _, key := range []string{types.CephFSUser, types.CephFSSecret, types.CephFSKeyring} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	credential := map[string]string{types.CephFSUser: "backup", types.CephFSSecret: "c2VjcmV0"}
+	c.Assert(SetupCredential("cephfs", credential), IsNil)
+	c.Assert(os.Getenv(types.CephFSUser), Equals, "backup")
+	c.Assert(os.Getenv(types.CephFSSecret), Equals, "c2VjcmV0")
+
+	loaded, err := getCredentialFromEnvVars("cephfs")
+	c.Assert(err, IsNil)
+	c.Assert(loaded, DeepEquals, map[string]string{
+		types.CephFSUser:    "backup",
+		types.CephFSSecret:  "c2VjcmV0",
+		types.CephFSKeyring: "",
+	})
+}
@@ -0,0 +1,45 @@
+package util
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultSectorSize is the sector size assumed if it's not recorded
+	DefaultSectorSize = 512
+	// MaxSectorSize is the largest sector size supported
+	MaxSectorSize = 64 * 1024
+)
+
+// GetDeviceSectorSizes returns the logical and the physical sector sizes of the block device.
+func GetDeviceSectorSizes(dev *os.File) (int64, int64, error) {
+	logical, err := unix.IoctlGetInt(int(dev.Fd()), unix.BLKSSZGET)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get logical sector size of %v: %v", dev.Name(), err)
+	}
+	physical, err := unix.IoctlGetInt(int(dev.Fd()), unix.BLKPBSZGET)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get physical sector size of %v: %v", dev.Name(), err)
+	}
+	return int64(logical), int64(physical), nil
+}
+
+// ValidateSectorSizes checks the sector sizes are powers of 2 between DefaultSectorSize and MaxSectorSize,
+// and the logical one is not larger than the physical one. 0 means the size is not specified.
+func ValidateSectorSizes(logical, physical int64) error {
+	for _, size := range []int64{logical, physical} {
+		if size == 0 {
+			continue
+		}
+		if size < DefaultSectorSize || size > MaxSectorSize || size&(size-1) != 0 {
+			return fmt.Errorf("invalid sector size %v", size)
+		}
+	}
+	if logical != 0 && physical != 0 && logical > physical {
+		return fmt.Errorf("logical sector size %v is larger than physical sector size %v", logical, physical)
+	}
+	return nil
+}
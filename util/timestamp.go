@@ -0,0 +1,102 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	// legacyTimestampLayouts are the layouts of the timestamps stored by the earlier versions or passed by the
+	// callers in the local time, tried in order after RFC3339
+	legacyTimestampLayouts = []string{
+		"2006-01-02 15:04:05.999999999 -0700 MST",
+		"2006-01-02 15:04:05 -0700 MST",
+		"2006-01-02 15:04:05 -0700",
+		time.RFC1123Z,
+		time.RFC1123,
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+	}
+)
+
+// ParseTimestamp parses the timestamp in RFC3339 or one of the legacy layouts, and returns the time in UTC. The
+// timestamps of the legacy layouts without the timezone are taken as UTC, and the integers are taken as the
+// Unix time in seconds.
+func ParseTimestamp(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	for _, layout := range legacyTimestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %v, must be in RFC3339", value)
+}
+
+// FormatTimestamp formats the time in RFC3339 in UTC, the format of all the stored timestamps.
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// NormalizeTimestamp converts the timestamp to RFC3339 in UTC. The empty timestamp stays empty, and the one
+// which cannot be parsed is returned as is, so it's still kept.
+func NormalizeTimestamp(value string) string {
+	if value == "" {
+		return ""
+	}
+	t, err := ParseTimestamp(value)
+	if err != nil {
+		return value
+	}
+	return FormatTimestamp(t)
+}
+
+// TimeRange is a range of time with the inclusive bounds, a zero bound doesn't bound the range. The bounds can
+// be in any timezone, they're compared as the instants.
+type TimeRange struct {
+	After  time.Time
+	Before time.Time
+}
+
+// Validate returns an error if the range is empty.
+func (r TimeRange) Validate() error {
+	if !r.After.IsZero() && !r.Before.IsZero() && r.Before.Before(r.After) {
+		return fmt.Errorf("invalid time range from %v to %v", FormatTimestamp(r.After), FormatTimestamp(r.Before))
+	}
+	return nil
+}
+
+// IsZero returns true if the range isn't bounded.
+func (r TimeRange) IsZero() bool {
+	return r.After.IsZero() && r.Before.IsZero()
+}
+
+// Contains returns true if the time is in the range.
+func (r TimeRange) Contains(t time.Time) bool {
+	if !r.After.IsZero() && t.Before(r.After) {
+		return false
+	}
+	if !r.Before.IsZero() && t.After(r.Before) {
+		return false
+	}
+	return true
+}
+
+// ContainsTimestamp returns true if the timestamp is in the range, the timestamp which cannot be parsed is only
+// in the unbounded range.
+func (r TimeRange) ContainsTimestamp(value string) bool {
+	if r.IsZero() {
+		return true
+	}
+	t, err := ParseTimestamp(value)
+	if err != nil {
+		return false
+	}
+	return r.Contains(t)
+}
@@ -2,7 +2,6 @@ package util
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -16,28 +15,42 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/google/uuid"
-	lz4 "github.com/pierrec/lz4/v4"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 
-	"k8s.io/apimachinery/pkg/util/wait"
 	mount "k8s.io/mount-utils"
 )
 
 const (
 	PreservedChecksumLength = 64
-
-	MountDir = "/var/lib/longhorn-backupstore-mounts"
 )
 
 var (
+	mountDirLock sync.RWMutex
+	// mountDir is the root of the mount points of the mount-based drivers, see SetMountDir
+	mountDir = "/var/lib/longhorn-backupstore-mounts"
+	// listMountPoints lists the mounted file systems, see SetMountDir
+	listMountPoints = func() ([]mount.MountPoint, error) { return mount.New("").List() }
+
+	stagingDirLock sync.RWMutex
+	// stagingDir is the directory of the temporary files, see SetStagingDir
+	stagingDir = ""
+
 	cmdTimeout = time.Minute // one minute by default
 
+	mountCommand = "mount"
+	// mountKillWaitDelay bounds the wait for a killed mount helper process to exit
+	mountKillWaitDelay = 10 * time.Second
+	// detachMount lazily unmounts the mount point, it doesn't wait for the unreachable server like umount does
+	detachMount = func(target string) error {
+		return unix.Unmount(target, unix.MNT_DETACH)
+	}
+
 	forceCleanupMountTimeout = 30 * time.Second
 )
 
@@ -55,6 +68,10 @@ func fstypeToKind(fstype int64) (string, error) {
 		return "nfs", nil
 	case unix.CIFS_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC, unix.SMB_SUPER_MAGIC:
 		return "cifs", nil
+	case unix.CEPH_SUPER_MAGIC:
+		return "cephfs", nil
+	case unix.FUSE_SUPER_MAGIC:
+		return "fuse", nil
 	default:
 		return "", fmt.Errorf("unknown fstype %v", fstype)
 	}
@@ -68,7 +85,7 @@ func GenerateName(prefix string) string {
 
 // NewUUID generates an UUID
 func NewUUID() string {
-	return uuid.New().String()
+	return GetIDGenerator().NewUUID()
 }
 
 // GetChecksum gets the SHA256 of the given data
@@ -133,31 +150,28 @@ func DecompressAndVerify(method string, src io.Reader, checksum string) (io.Read
 }
 
 func newCompressionWriter(method string, buffer io.Writer) (io.WriteCloser, error) {
-	switch method {
-	case "gzip":
-		return gzip.NewWriter(buffer), nil
-	case "lz4":
-		return lz4.NewWriter(buffer), nil
-	default:
-		return nil, fmt.Errorf("unsupported compression method: %v", method)
+	compressor, err := GetCompressor(method)
+	if err != nil {
+		return nil, err
 	}
+	return compressor.NewWriter(buffer)
+}
+
+// NewDecompressionReader returns a reader decompressing the data using the specified compression method
+func NewDecompressionReader(method string, r io.Reader) (io.ReadCloser, error) {
+	return newDecompressionReader(method, r)
 }
 
 func newDecompressionReader(method string, r io.Reader) (io.ReadCloser, error) {
-	switch method {
-	case "none":
-		return io.NopCloser(r), nil
-	case "gzip":
-		return gzip.NewReader(r)
-	case "lz4":
-		return io.NopCloser(lz4.NewReader(r)), nil
-	default:
+	compressor, err := GetCompressor(method)
+	if err != nil {
 		return nil, fmt.Errorf("unsupported decompression method: %v", method)
 	}
+	return compressor.NewReader(r)
 }
 
 func Now() string {
-	return time.Now().UTC().Format(time.RFC3339)
+	return FormatTimestamp(GetClock().Now())
 }
 
 func UnorderedEqual(x, y []string) bool {
@@ -289,6 +303,71 @@ func cleanupMount(mountDir string, mounter mount.Interface, log logrus.FieldLogg
 	return mount.CleanupMountPoint(mountDir, forceUnmounter, false)
 }
 
+// UnmountMountPoint unmounts the file system and removes the mount point.
+func UnmountMountPoint(mountPoint string, log logrus.FieldLogger) error {
+	return cleanupMount(mountPoint, mount.New(""), log)
+}
+
+// RemoveMountDirs removes the mount point and its parent directories under the mount directory which are left
+// empty after the file system is unmounted. The directories outside the mount directory are never removed.
+func RemoveMountDirs(mountPoint string, log logrus.FieldLogger) {
+	root := GetMountDir() + string(filepath.Separator)
+	for dir := filepath.Clean(mountPoint); strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			if !os.IsNotExist(err) {
+				log.WithError(err).Debugf("Stopped removing mount directories at %v", dir)
+				return
+			}
+		}
+	}
+}
+
+// AllowMountDirsSearch lets the other users search the parent directories of the mount point under the mount
+// directory, e.g. for the operations accessing the file system with the ids of the owner of the files. The
+// directories stay unreadable to them.
+func AllowMountDirsSearch(mountPoint string) error {
+	root := GetMountDir()
+	for dir := filepath.Dir(filepath.Clean(mountPoint)); dir == root || strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		st, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if mode := st.Mode().Perm(); mode&0011 != 0011 {
+			if err := os.Chmod(dir, mode|0011); err != nil {
+				return errors.Wrapf(err, "failed to allow searching mount directory %v", dir)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadOnlyMountPoint returns the mount point of the read-only mount of the share, which is separated from the
+// writable mount point of the share.
+func ReadOnlyMountPoint(mountPoint string) string {
+	return filepath.Clean(mountPoint) + "-ro"
+}
+
+// WithReadOnlyOption returns the mount options mounting the file system read-only.
+func WithReadOnlyOption(options []string) []string {
+	result := []string{}
+	for _, option := range options {
+		if option != "rw" && option != "ro" {
+			result = append(result, option)
+		}
+	}
+	return append(result, "ro")
+}
+
+// DetachMountPoint lazily unmounts the file system, e.g. the share of an unreachable server whose mount point
+// cannot be accessed anymore. The mount point not mounted is ignored.
+func DetachMountPoint(mountPoint string, log logrus.FieldLogger) error {
+	log.Infof("Detaching mount point %v", mountPoint)
+	if err := detachMount(mountPoint); err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		return errors.Wrapf(err, "failed to detach mount point %v", mountPoint)
+	}
+	return nil
+}
+
 // EnsureMountPoint checks if the mount point is valid. If it is invalid, clean up mount point.
 func EnsureMountPoint(Kind, mountPoint string, mounter mount.Interface, log logrus.FieldLogger) (mounted bool, err error) {
 	defer func() {
@@ -352,21 +431,87 @@ func EnsureMountPoint(Kind, mountPoint string, mounter mount.Interface, log logr
 // MountWithTimeout mounts the backup store to a given mount point with a specified timeout
 func MountWithTimeout(mounter mount.Interface, source string, target string, fstype string,
 	options []string, sensitiveOptions []string, interval, timeout time.Duration) error {
-	mountComplete := false
-	err := wait.PollUntilContextTimeout(context.Background(), interval, timeout, true, func(context.Context) (bool, error) {
-		err := mounter.MountSensitiveWithoutSystemd(source, target, fstype, options, sensitiveOptions)
-		mountComplete = true
-		return true, err
-	})
-	if !mountComplete {
-		return errors.Wrapf(err, "mounting %v share %v on %v timed out", fstype, source, target)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return MountWithContext(ctx, mounter, source, target, fstype, options, sensitiveOptions)
+}
+
+// MountWithContext mounts the backup store to a given mount point until the context is done. The mount syscall
+// can hang in the kernel on an unreachable server, so the mount command runs in a helper process that is killed
+// once the context is done, and a mount completed by the kernel after that is lazily detached. This way a
+// cancelled mount doesn't leave a stuck process or mount point behind.
+func MountWithContext(ctx context.Context, mounter mount.Interface, source string, target string, fstype string,
+	options []string, sensitiveOptions []string) error {
+	return MountWithEnv(ctx, mounter, source, target, fstype, options, sensitiveOptions, nil)
+}
+
+// MountWithEnv mounts the backup store like MountWithContext, with the additional environment variables of the
+// mount helper process, e.g. KRB5CCNAME of the Kerberos credentials cache. The environment variables are
+// ignored by the mounters other than the mount helper.
+func MountWithEnv(ctx context.Context, mounter mount.Interface, source string, target string, fstype string,
+	options []string, sensitiveOptions []string, env []string) error {
+	if _, ok := mounter.(*mount.Mounter); ok {
+		return mountWithHelperProcess(ctx, source, target, fstype, options, sensitiveOptions, env)
+	}
+
+	// The other mounters can't be interrupted, give up waiting for them and undo the mount once it completes
+	done := make(chan error, 1)
+	go func() {
+		done <- mounter.MountSensitiveWithoutSystemd(source, target, fstype, options, sensitiveOptions)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		go func() {
+			if err := <-done; err == nil {
+				logrus.Warnf("Unmounting %v share %v on %v completed after the mount was cancelled", fstype, source, target)
+				if err := mounter.Unmount(target); err != nil {
+					logrus.WithError(err).Warnf("Failed to unmount %v after the mount was cancelled", target)
+				}
+			}
+		}()
+		return errors.Wrapf(ctx.Err(), "mounting %v share %v on %v timed out", fstype, source, target)
 	}
-	return err
+}
+
+func mountWithHelperProcess(ctx context.Context, source string, target string, fstype string,
+	options []string, sensitiveOptions []string, env []string) error {
+	mountArgs, mountArgsLogStr := mount.MakeMountArgsSensitive(source, target, fstype, options, sensitiveOptions)
+
+	cmd := exec.CommandContext(ctx, mountCommand, mountArgs...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	// Kill the whole process group, the mount helpers (e.g. mount.nfs) are forked by mount
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// The killed helpers blocked in the kernel exit only after the pending request is aborted, don't wait for
+	// their output forever
+	cmd.WaitDelay = mountKillWaitDelay
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		// The kernel may have completed the mount before the helper was killed
+		if detachErr := detachMount(target); detachErr != nil && detachErr != unix.EINVAL && detachErr != unix.ENOENT {
+			logrus.WithError(detachErr).Warnf("Failed to detach mount point %v after the mount was cancelled", target)
+		}
+		return errors.Wrapf(ctx.Err(), "mounting %v share %v on %v timed out", fstype, source, target)
+	}
+	if err != nil {
+		return fmt.Errorf("mount failed: %v\nMounting command: %s\nMounting arguments: %s\nOutput: %s",
+			err, mountCommand, mountArgsLogStr, string(output))
+	}
+	return nil
 }
 
 // CleanUpMountPoints tries to clean up all existing mount points for existing backup stores
 func CleanUpMountPoints(mounter mount.Interface, log logrus.FieldLogger) error {
-	return filepath.Walk(MountDir, func(path string, info os.FileInfo, err error) error {
+	return filepath.Walk(GetMountDir(), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return errors.Wrapf(err, "failed to get file info of %v", path)
 		}
@@ -411,3 +556,69 @@ func SplitMountOptions(options []string) []string {
 	// Options in the form "nfsOptions=soft,timeo=450,retrans=3" are more likely, but we must split them.
 	return strings.Split(options[0], ",")
 }
+
+// SetMountDir sets the root of the mount points of the mount-based drivers. It must be called before any driver
+// is initialized, the existing mount points are not moved, so it's refused while any file system is mounted
+// under the current mount directory.
+func SetMountDir(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("mount directory %v must be an absolute path", dir)
+	}
+	dir = filepath.Clean(dir)
+
+	mountDirLock.Lock()
+	defer mountDirLock.Unlock()
+	if dir == mountDir {
+		return nil
+	}
+	mountPoints, err := listMountPoints()
+	if err != nil {
+		return errors.Wrap(err, "failed to list mount points")
+	}
+	for _, mountPoint := range mountPoints {
+		path := filepath.Clean(mountPoint.Path)
+		if path == mountDir || strings.HasPrefix(path, mountDir+string(filepath.Separator)) {
+			return fmt.Errorf("cannot change mount directory from %v to %v while %v is mounted", mountDir, dir, path)
+		}
+	}
+	mountDir = dir
+	return nil
+}
+
+// GetMountDir returns the root of the mount points of the mount-based drivers.
+func GetMountDir() string {
+	mountDirLock.RLock()
+	defer mountDirLock.RUnlock()
+	return mountDir
+}
+
+// SetStagingDir sets the directory of the temporary files staging the data, e.g. the files copied between the
+// backup targets. The default temporary directory is used if it's empty.
+func SetStagingDir(dir string) error {
+	if dir != "" && !filepath.IsAbs(dir) {
+		return fmt.Errorf("staging directory %v must be an absolute path", dir)
+	}
+	stagingDirLock.Lock()
+	defer stagingDirLock.Unlock()
+	stagingDir = dir
+	return nil
+}
+
+// GetStagingDir returns the directory of the temporary files.
+func GetStagingDir() string {
+	stagingDirLock.RLock()
+	defer stagingDirLock.RUnlock()
+	if stagingDir == "" {
+		return os.TempDir()
+	}
+	return stagingDir
+}
+
+// CreateStagingFile creates a temporary file in the staging directory, the caller needs to remove it.
+func CreateStagingFile(pattern string) (*os.File, error) {
+	dir := GetStagingDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create staging directory %v", dir)
+	}
+	return os.CreateTemp(dir, pattern)
+}
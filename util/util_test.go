@@ -1,15 +1,23 @@
 package util
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"math/rand"
 	"net/url"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 	. "gopkg.in/check.v1"
+	mount "k8s.io/mount-utils"
 )
 
 func Test(t *testing.T) { TestingT(t) }
@@ -78,6 +86,74 @@ func (s *TestSuite) TestCompress(c *C) {
 	}
 }
 
+type reverseCompressor struct{}
+
+func (reverseCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &reverseWriter{w: w}, nil
+}
+
+func (reverseCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(reverse(data))), nil
+}
+
+type reverseWriter struct {
+	w    io.Writer
+	data []byte
+}
+
+func (r *reverseWriter) Write(p []byte) (int, error) {
+	r.data = append(r.data, p...)
+	return len(p), nil
+}
+
+func (r *reverseWriter) Close() error {
+	_, err := r.w.Write(reverse(r.data))
+	return err
+}
+
+func reverse(data []byte) []byte {
+	result := make([]byte, len(data))
+	for i, b := range data {
+		result[len(data)-1-i] = b
+	}
+	return result
+}
+
+func (s *TestSuite) TestRegisterCompressor(c *C) {
+	data := []byte("Some random string")
+
+	_, err := CompressData("reverse", data)
+	c.Assert(err, NotNil)
+
+	err = RegisterCompressor("reverse", reverseCompressor{})
+	c.Assert(err, IsNil)
+	defer func() {
+		compressorsLock.Lock()
+		delete(compressors, "reverse")
+		compressorsLock.Unlock()
+	}()
+	c.Assert(ListCompressors(), DeepEquals, []string{"gzip", "lz4", "none", "reverse"})
+
+	compressed, err := CompressData("reverse", data)
+	c.Assert(err, IsNil)
+	raw, err := io.ReadAll(compressed)
+	c.Assert(err, IsNil)
+	c.Assert(raw, DeepEquals, reverse(data))
+
+	decompressed, err := DecompressAndVerify("reverse", bytes.NewReader(raw), GetChecksum(data))
+	c.Assert(err, IsNil)
+	result, err := io.ReadAll(decompressed)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, data)
+
+	c.Assert(RegisterCompressor("", reverseCompressor{}), NotNil)
+	c.Assert(RegisterCompressor("reverse", nil), NotNil)
+}
+
 func GenerateRandString() string {
 	r := make([]rune, nameLength)
 	r[0] = firstLetters[rand.Intn(len(firstLetters))]
@@ -199,3 +275,231 @@ func (s *TestSuite) TestSplitMountOptions(c *C) {
 		}
 	}
 }
+
+func (s *TestSuite) TestStagingDir(c *C) {
+	defer SetStagingDir("") // nolint:errcheck
+
+	err := SetStagingDir("relative")
+	c.Assert(err, NotNil)
+
+	dir := filepath.Join(testRoot, "staging")
+	err = SetStagingDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(GetStagingDir(), Equals, dir)
+
+	file, err := CreateStagingFile("test-")
+	c.Assert(err, IsNil)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	c.Assert(filepath.Dir(file.Name()), Equals, dir)
+
+	err = SetStagingDir("")
+	c.Assert(err, IsNil)
+	c.Assert(GetStagingDir(), Equals, os.TempDir())
+}
+
+func (s *TestSuite) TestSetMountDir(c *C) {
+	defer SetMountDir(GetMountDir()) // nolint:errcheck
+
+	err := SetMountDir("relative")
+	c.Assert(err, NotNil)
+
+	err = SetMountDir("/mnt/backupstore/")
+	c.Assert(err, IsNil)
+	c.Assert(GetMountDir(), Equals, "/mnt/backupstore")
+
+	// The mount directory isn't changed while the file systems are mounted under it
+	defer func(list func() ([]mount.MountPoint, error)) { listMountPoints = list }(listMountPoints)
+	listMountPoints = func() ([]mount.MountPoint, error) {
+		return []mount.MountPoint{{Path: "/mnt/backupstore-other"}, {Path: "/mnt/backupstore/server/export"}}, nil
+	}
+	err = SetMountDir("/mnt/other")
+	c.Assert(err, ErrorMatches, ".*while /mnt/backupstore/server/export is mounted")
+	c.Assert(GetMountDir(), Equals, "/mnt/backupstore")
+
+	listMountPoints = func() ([]mount.MountPoint, error) {
+		return []mount.MountPoint{{Path: "/mnt/backupstore-other"}}, nil
+	}
+	err = SetMountDir("/mnt/other")
+	c.Assert(err, IsNil)
+	c.Assert(GetMountDir(), Equals, "/mnt/other")
+}
+
+func (s *TestSuite) TestAllowMountDirsSearch(c *C) {
+	defer SetMountDir(GetMountDir()) // nolint:errcheck
+
+	root := c.MkDir()
+	c.Assert(SetMountDir(filepath.Join(root, "mounts")), IsNil)
+	mountPoint := filepath.Join(GetMountDir(), "server", "export")
+	c.Assert(os.MkdirAll(mountPoint, 0700), IsNil)
+	c.Assert(os.Chmod(GetMountDir(), 0700), IsNil)
+
+	c.Assert(AllowMountDirsSearch(mountPoint), IsNil)
+	for _, dir := range []string{GetMountDir(), filepath.Join(GetMountDir(), "server")} {
+		st, err := os.Stat(dir)
+		c.Assert(err, IsNil)
+		c.Assert(st.Mode().Perm(), Equals, os.FileMode(0711))
+	}
+	// The mount point and the directories outside the mount directory are kept
+	st, err := os.Stat(mountPoint)
+	c.Assert(err, IsNil)
+	c.Assert(st.Mode().Perm(), Equals, os.FileMode(0700))
+	st, err = os.Stat(root)
+	c.Assert(err, IsNil)
+	c.Assert(st.Mode().Perm()&0011, Equals, os.FileMode(0))
+}
+
+type sequentialIDs struct {
+	next int
+}
+
+func (g *sequentialIDs) NewUUID() string {
+	g.next++
+	return fmt.Sprintf("%016x", g.next)
+}
+
+func (s *TestSuite) TestClockAndIDInjection(c *C) {
+	defer SetClock(nil)
+	defer SetIDGenerator(nil)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	SetClock(clock)
+	c.Assert(Now(), Equals, "2024-01-01T00:00:00Z")
+	clock.Sleep(time.Hour)
+	c.Assert(Now(), Equals, "2024-01-01T01:00:00Z")
+
+	SetIDGenerator(&sequentialIDs{})
+	c.Assert(GenerateName("backup"), Equals, "backup-0000000000000001")
+	c.Assert(GenerateName("backup"), Equals, "backup-0000000000000002")
+
+	SetIDGenerator(nil)
+	c.Assert(GenerateName("backup"), Not(Equals), "backup-0000000000000003")
+}
+
+func (s *TestSuite) TestValidateSectorSizes(c *C) {
+	c.Assert(ValidateSectorSizes(0, 0), IsNil)
+	c.Assert(ValidateSectorSizes(512, 4096), IsNil)
+	c.Assert(ValidateSectorSizes(4096, 4096), IsNil)
+	c.Assert(ValidateSectorSizes(4096, 512), NotNil)
+	c.Assert(ValidateSectorSizes(256, 0), NotNil)
+	c.Assert(ValidateSectorSizes(0, 3000), NotNil)
+	c.Assert(ValidateSectorSizes(2*MaxSectorSize, 0), NotNil)
+}
+
+// blockingMounter blocks the mounts until it's released
+type blockingMounter struct {
+	*mount.FakeMounter
+	release chan struct{}
+}
+
+func (m *blockingMounter) MountSensitiveWithoutSystemd(source string, target string, fstype string, options []string, sensitiveOptions []string) error {
+	<-m.release
+	return m.FakeMounter.MountSensitiveWithoutSystemd(source, target, fstype, options, sensitiveOptions)
+}
+
+func (s *TestSuite) TestMountWithTimeout(c *C) {
+	target := filepath.Join(c.MkDir(), "mnt")
+
+	// The hung mount helper and its children are killed, and the target is detached
+	helper := filepath.Join(c.MkDir(), "mount")
+	c.Assert(os.WriteFile(helper, []byte("#!/bin/sh\nsleep 60 &\nwait\n"), 0700), IsNil)
+	oldMountCommand, oldDetachMount := mountCommand, detachMount
+	defer func() { mountCommand, detachMount = oldMountCommand, oldDetachMount }()
+	mountCommand = helper
+	detached := []string{}
+	detachMount = func(target string) error {
+		detached = append(detached, target)
+		return nil
+	}
+
+	start := time.Now()
+	err := MountWithTimeout(mount.New(""), "server:/export", target, "nfs4", []string{"soft"}, nil,
+		time.Second, 500*time.Millisecond)
+	c.Assert(err, ErrorMatches, "mounting nfs4 share server:/export on .* timed out.*")
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+	c.Assert(detached, DeepEquals, []string{target})
+
+	// The failure of the mount helper is returned
+	c.Assert(os.WriteFile(helper, []byte("#!/bin/sh\necho access denied\nexit 32\n"), 0700), IsNil)
+	err = MountWithTimeout(mount.New(""), "server:/export", target, "nfs4", nil, []string{"password=secret"},
+		time.Second, 5*time.Second)
+	c.Assert(err, ErrorMatches, "(?s)mount failed.*access denied.*")
+	c.Assert(strings.Contains(err.Error(), "secret"), Equals, false)
+
+	// The mount completed after the timeout is undone
+	mounter := &blockingMounter{FakeMounter: mount.NewFakeMounter(nil), release: make(chan struct{})}
+	err = MountWithTimeout(mounter, "server:/export", target, "nfs4", nil, nil, time.Second, 100*time.Millisecond)
+	c.Assert(err, ErrorMatches, ".*timed out.*")
+	close(mounter.release)
+	for i := 0; i < 50; i++ {
+		if len(mounter.GetLog()) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log := mounter.GetLog()
+	c.Assert(log, HasLen, 2)
+	c.Assert(log[1].Action, Equals, mount.FakeActionUnmount)
+}
+
+func (s *TestSuite) TestReadOnlyMount(c *C) {
+	c.Assert(ReadOnlyMountPoint("/mnt/server/export/"), Equals, "/mnt/server/export-ro")
+	c.Assert(WithReadOnlyOption([]string{"rw", "soft", "ro"}), DeepEquals, []string{"soft", "ro"})
+	c.Assert(WithReadOnlyOption(nil), DeepEquals, []string{"ro"})
+}
+
+func (s *TestSuite) TestDetachMountPoint(c *C) {
+	oldDetachMount := detachMount
+	defer func() { detachMount = oldDetachMount }()
+
+	detached := []string{}
+	detachErr := error(nil)
+	detachMount = func(target string) error {
+		detached = append(detached, target)
+		return detachErr
+	}
+	c.Assert(DetachMountPoint("/mnt/stale", logrus.StandardLogger()), IsNil)
+	c.Assert(detached, DeepEquals, []string{"/mnt/stale"})
+
+	// The mount point not mounted is ignored
+	detachErr = unix.EINVAL
+	c.Assert(DetachMountPoint("/mnt/stale", logrus.StandardLogger()), IsNil)
+
+	detachErr = unix.EBUSY
+	c.Assert(DetachMountPoint("/mnt/stale", logrus.StandardLogger()), ErrorMatches, "failed to detach mount point /mnt/stale.*")
+}
+
+func (s *TestSuite) TestTimestamps(c *C) {
+	expected := time.Date(2021, 6, 7, 8, 57, 23, 0, time.UTC)
+	for _, value := range []string{
+		"2021-06-07T08:57:23Z",
+		"2021-06-07T16:57:23+08:00",
+		"2021-06-07 16:57:23 +0800 CST",
+		"2021-06-07T08:57:23",
+		"1623056243",
+	} {
+		t, err := ParseTimestamp(value)
+		c.Assert(err, IsNil, Commentf("timestamp %v", value))
+		c.Assert(t.Equal(expected), Equals, true, Commentf("timestamp %v", value))
+		c.Assert(t.Location(), Equals, time.UTC)
+	}
+	_, err := ParseTimestamp("yesterday")
+	c.Assert(err, NotNil)
+
+	c.Assert(NormalizeTimestamp("2021-06-07T16:57:23+08:00"), Equals, "2021-06-07T08:57:23Z")
+	c.Assert(NormalizeTimestamp(""), Equals, "")
+	c.Assert(NormalizeTimestamp("yesterday"), Equals, "yesterday")
+
+	// The bounds in different timezones are compared as the instants
+	r := TimeRange{
+		After:  time.Date(2021, 6, 7, 16, 0, 0, 0, time.FixedZone("CST", 8*3600)),
+		Before: time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC),
+	}
+	c.Assert(r.Validate(), IsNil)
+	c.Assert(r.ContainsTimestamp("2021-06-07T08:57:23Z"), Equals, true)
+	c.Assert(r.ContainsTimestamp("2021-06-07T09:57:23+08:00"), Equals, false)
+	c.Assert(r.ContainsTimestamp("yesterday"), Equals, false)
+	c.Assert(TimeRange{}.ContainsTimestamp("yesterday"), Equals, true)
+	c.Assert(TimeRange{After: r.Before, Before: r.After}.Validate(), NotNil)
+}
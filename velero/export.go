@@ -0,0 +1,247 @@
+package velero
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/longhorn/backupstore"
+)
+
+const (
+	APIVersion = "velero.io/v1"
+
+	KindBackupRepository = "BackupRepository"
+	KindPodVolumeBackup  = "PodVolumeBackup"
+
+	// RepositoryType identifies the Longhorn native backups among the Velero repository types
+	RepositoryType = "longhorn"
+
+	LabelVolumeName = "longhorn.io/volume"
+	LabelBackupName = "longhorn.io/backup"
+
+	AnnotationBackupURL = "longhorn.io/backup-url"
+
+	defaultNamespace             = "velero"
+	defaultBackupStorageLocation = "default"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "velero"})
+)
+
+// ExportOptions configures the Velero objects generated by the exporter.
+type ExportOptions struct {
+	// Namespace of the Velero installation, defaults to velero
+	Namespace string
+	// BackupStorageLocation referring to the backup target, defaults to default
+	BackupStorageLocation string
+	// VolumeNamespace is the namespace of the workloads using the volumes
+	VolumeNamespace string
+}
+
+type ObjectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type BackupRepository struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   ObjectMeta             `yaml:"metadata"`
+	Spec       BackupRepositorySpec   `yaml:"spec"`
+	Status     BackupRepositoryStatus `yaml:"status"`
+}
+
+type BackupRepositorySpec struct {
+	VolumeNamespace       string `yaml:"volumeNamespace"`
+	BackupStorageLocation string `yaml:"backupStorageLocation"`
+	RepositoryType        string `yaml:"repositoryType"`
+	ResticIdentifier      string `yaml:"resticIdentifier"`
+	MaintenanceFrequency  string `yaml:"maintenanceFrequency"`
+}
+
+type BackupRepositoryStatus struct {
+	Phase string `yaml:"phase"`
+}
+
+type PodVolumeBackup struct {
+	APIVersion string                `yaml:"apiVersion"`
+	Kind       string                `yaml:"kind"`
+	Metadata   ObjectMeta            `yaml:"metadata"`
+	Spec       PodVolumeBackupSpec   `yaml:"spec"`
+	Status     PodVolumeBackupStatus `yaml:"status"`
+}
+
+type PodVolumeBackupSpec struct {
+	Volume                string            `yaml:"volume"`
+	BackupStorageLocation string            `yaml:"backupStorageLocation"`
+	RepoIdentifier        string            `yaml:"repoIdentifier"`
+	UploaderType          string            `yaml:"uploaderType"`
+	Tags                  map[string]string `yaml:"tags,omitempty"`
+}
+
+type PodVolumeBackupStatus struct {
+	Phase               string                  `yaml:"phase"`
+	SnapshotID          string                  `yaml:"snapshotID"`
+	StartTimestamp      string                  `yaml:"startTimestamp,omitempty"`
+	CompletionTimestamp string                  `yaml:"completionTimestamp,omitempty"`
+	Progress            PodVolumeBackupProgress `yaml:"progress"`
+}
+
+type PodVolumeBackupProgress struct {
+	TotalBytes int64 `yaml:"totalBytes"`
+	BytesDone  int64 `yaml:"bytesDone"`
+}
+
+// Manifests are the Velero objects describing the backups in the backup target.
+type Manifests struct {
+	Repositories  []*BackupRepository
+	VolumeBackups []*PodVolumeBackup
+}
+
+func (o *ExportOptions) setDefaults() {
+	if o.Namespace == "" {
+		o.Namespace = defaultNamespace
+	}
+	if o.BackupStorageLocation == "" {
+		o.BackupStorageLocation = defaultBackupStorageLocation
+	}
+}
+
+// getObjectName returns a Kubernetes object name for the given parts.
+func getObjectName(parts ...string) string {
+	name := strings.ToLower(strings.Join(parts, "-"))
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+		return '-'
+	}, name)
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return strings.Trim(name, "-.")
+}
+
+// Export generates a BackupRepository for each backup volume and a completed PodVolumeBackup for each
+// backup in the backup target, so Velero can catalogue the Longhorn native backups.
+func Export(destURL string, options *ExportOptions) (*Manifests, error) {
+	opts := ExportOptions{}
+	if options != nil {
+		opts = *options
+	}
+	opts.setDefaults()
+
+	volumeInfos, err := backupstore.List("", destURL, false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list backup volumes of %v", destURL)
+	}
+
+	volumeNames := make([]string, 0, len(volumeInfos))
+	for volumeName := range volumeInfos {
+		volumeNames = append(volumeNames, volumeName)
+	}
+	sort.Strings(volumeNames)
+
+	manifests := &Manifests{}
+	for _, volumeName := range volumeNames {
+		volumeURL := backupstore.EncodeBackupURL("", volumeName, destURL)
+		volume, err := backupstore.InspectVolume(volumeURL)
+		if err != nil {
+			log.WithError(err).Warnf("Skipped exporting invalid backup volume %v", volumeName)
+			continue
+		}
+
+		manifests.Repositories = append(manifests.Repositories, &BackupRepository{
+			APIVersion: APIVersion,
+			Kind:       KindBackupRepository,
+			Metadata: ObjectMeta{
+				Name:      getObjectName(opts.BackupStorageLocation, RepositoryType, volumeName),
+				Namespace: opts.Namespace,
+				Labels:    map[string]string{LabelVolumeName: volumeName},
+			},
+			Spec: BackupRepositorySpec{
+				VolumeNamespace:       opts.VolumeNamespace,
+				BackupStorageLocation: opts.BackupStorageLocation,
+				RepositoryType:        RepositoryType,
+				ResticIdentifier:      volumeURL,
+				MaintenanceFrequency:  "0s",
+			},
+			Status: BackupRepositoryStatus{Phase: "Ready"},
+		})
+
+		backupNames := make([]string, 0, len(volumeInfos[volumeName].Backups))
+		for backupName := range volumeInfos[volumeName].Backups {
+			backupNames = append(backupNames, backupName)
+		}
+		sort.Strings(backupNames)
+
+		for _, backupName := range backupNames {
+			backupURL := backupstore.EncodeBackupURL(backupName, volumeName, destURL)
+			backup, err := backupstore.InspectBackup(backupURL)
+			if err != nil {
+				log.WithError(err).Warnf("Skipped exporting backup %v of volume %v", backupName, volumeName)
+				continue
+			}
+			manifests.VolumeBackups = append(manifests.VolumeBackups, &PodVolumeBackup{
+				APIVersion: APIVersion,
+				Kind:       KindPodVolumeBackup,
+				Metadata: ObjectMeta{
+					Name:      getObjectName(volumeName, backupName),
+					Namespace: opts.Namespace,
+					Labels: map[string]string{
+						LabelVolumeName: volumeName,
+						LabelBackupName: backupName,
+					},
+					Annotations: map[string]string{AnnotationBackupURL: backupURL},
+				},
+				Spec: PodVolumeBackupSpec{
+					Volume:                volumeName,
+					BackupStorageLocation: opts.BackupStorageLocation,
+					RepoIdentifier:        volumeURL,
+					UploaderType:          RepositoryType,
+					Tags:                  backup.Labels,
+				},
+				Status: PodVolumeBackupStatus{
+					Phase:               "Completed",
+					SnapshotID:          backupName,
+					StartTimestamp:      backup.SnapshotCreated,
+					CompletionTimestamp: backup.Created,
+					Progress: PodVolumeBackupProgress{
+						TotalBytes: volume.Size,
+						BytesDone:  backup.Size,
+					},
+				},
+			})
+		}
+	}
+	return manifests, nil
+}
+
+// WriteManifests writes the manifests as a multi-document YAML which can be applied by kubectl.
+func WriteManifests(w io.Writer, manifests *Manifests) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	for _, repository := range manifests.Repositories {
+		if err := encoder.Encode(repository); err != nil {
+			return errors.Wrapf(err, "failed to encode backup repository %v", repository.Metadata.Name)
+		}
+	}
+	for _, volumeBackup := range manifests.VolumeBackups {
+		if err := encoder.Encode(volumeBackup); err != nil {
+			return errors.Wrapf(err, "failed to encode pod volume backup %v", volumeBackup.Metadata.Name)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to write the manifests: %w", err)
+	}
+	return nil
+}
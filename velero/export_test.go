@@ -0,0 +1,50 @@
+package velero
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/longhorn/backupstore/vfs"
+)
+
+func TestExport(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	volumeDir := filepath.Join(dir, "backupstore", "volumes", "7e", "ed", "pvc-1")
+	assert.NoError(os.MkdirAll(filepath.Join(volumeDir, "backups"), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(volumeDir, "volume.cfg"),
+		[]byte(`{"Name":"pvc-1","Size":"2097152","CreatedTime":"2021-06-07T07:00:00Z"}`), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(volumeDir, "backups", "backup_backup-1.cfg"),
+		[]byte(`{"Name":"backup-1","VolumeName":"pvc-1","SnapshotCreatedAt":"2021-06-07T08:00:00Z",`+
+			`"CreatedTime":"2021-06-07T08:01:00Z","Size":"1048576","Labels":{"app":"test"}}`), 0644))
+	// in progress backups are not exported
+	assert.NoError(os.WriteFile(filepath.Join(volumeDir, "backups", "backup_backup-2.cfg"),
+		[]byte(`{"Name":"backup-2","VolumeName":"pvc-1"}`), 0644))
+
+	destURL := "vfs://" + dir
+	manifests, err := Export(destURL, &ExportOptions{VolumeNamespace: "default"})
+	assert.NoError(err)
+	assert.Equal(1, len(manifests.Repositories))
+	assert.Equal("default-longhorn-pvc-1", manifests.Repositories[0].Metadata.Name)
+	assert.Equal("velero", manifests.Repositories[0].Metadata.Namespace)
+	assert.Equal("default", manifests.Repositories[0].Spec.VolumeNamespace)
+
+	assert.Equal(1, len(manifests.VolumeBackups))
+	volumeBackup := manifests.VolumeBackups[0]
+	assert.Equal("pvc-1-backup-1", volumeBackup.Metadata.Name)
+	assert.Equal("Completed", volumeBackup.Status.Phase)
+	assert.Equal("2021-06-07T08:01:00Z", volumeBackup.Status.CompletionTimestamp)
+	assert.Equal(int64(1048576), volumeBackup.Status.Progress.BytesDone)
+	assert.Equal(map[string]string{"app": "test"}, volumeBackup.Spec.Tags)
+
+	var buf bytes.Buffer
+	assert.NoError(WriteManifests(&buf, manifests))
+	assert.Contains(buf.String(), "kind: BackupRepository")
+	assert.Contains(buf.String(), "---\n")
+	assert.Contains(buf.String(), "kind: PodVolumeBackup")
+}
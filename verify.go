@@ -0,0 +1,275 @@
+package backupstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/gammazero/workerpool"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/longhorn/backupstore/logging"
+	"github.com/longhorn/backupstore/util"
+)
+
+// VerificationResult is the result of a verification run of a backup, recorded in the volume config.
+type VerificationResult struct {
+	BackupName      string
+	VerifiedAt      string
+	Succeeded       bool
+	VerifiedBlocks  int64  `json:",string"`
+	CorruptedBlocks int64  `json:",string"`
+	Message         string `json:",omitempty"`
+
+	// TotalBlocks, SamplePercent and SampleSeed are only set by the sampled runs, so the run can be repeated
+	TotalBlocks   int64  `json:",string,omitempty"`
+	SamplePercent int    `json:",omitempty"`
+	SampleSeed    string `json:",omitempty"`
+}
+
+// VerifyOptions are the options of VerifyBackupWithOptions. The zero values verify all the blocks at full speed.
+type VerifyOptions struct {
+	// SamplePercent is the percentage of the distinct blocks to verify, 0 and 100 verify all of them
+	SamplePercent int
+	// SampleSeed picks the sampled blocks deterministically, the same seed picks the same blocks. The time of
+	// the run is used if it's empty, so the runs cover the different blocks over time.
+	SampleSeed string
+	// Workers is the number of the blocks verified at the same time, the default is 16 per CPU
+	Workers int
+	// BytesPerSecond caps the bandwidth of the block reads, 0 is unlimited
+	BytesPerSecond int64
+}
+
+func (o *VerifyOptions) validate() error {
+	if o.SamplePercent < 0 || o.SamplePercent > 100 {
+		return fmt.Errorf("invalid sample percent %v, must be between 0 and 100", o.SamplePercent)
+	}
+	if o.Workers < 0 || o.BytesPerSecond < 0 {
+		return fmt.Errorf("invalid verify options %+v", *o)
+	}
+	return nil
+}
+
+func (o *VerifyOptions) isSampled() bool {
+	return o.SamplePercent > 0 && o.SamplePercent < 100
+}
+
+// VerifyBackup reads all the blocks of the backup and checks them against their checksums. The result is
+// recorded in the volume config unless the backup target is read-only, and the error is only returned if the
+// verification cannot be done.
+func VerifyBackup(backupURL string) (*VerificationResult, error) {
+	return VerifyBackupWithOptions(backupURL, VerifyOptions{})
+}
+
+// VerifyBackupWithOptions verifies the blocks of the backup like VerifyBackup, only the sampled blocks are
+// verified by the workers sharing the bandwidth cap, so the continuous background verification of the large
+// backup targets doesn't compete with the backups.
+func VerifyBackupWithOptions(backupURL string, opts VerifyOptions) (*VerificationResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	bsDriver, err := GetBackupStoreDriver(backupURL)
+	if err != nil {
+		return nil, err
+	}
+
+	backupName, volumeName, _, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	log := log.WithFields(logrus.Fields{
+		LogFieldBackup: backupName,
+		LogFieldVolume: volumeName,
+	})
+
+	result, err := verifyBackup(bsDriver, backupName, volumeName, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result.Succeeded {
+		log.Infof("Verified %v blocks of backup", result.VerifiedBlocks)
+	} else {
+		log.Warnf("Verification of backup failed: %v", result.Message)
+	}
+
+	// The result is still returned if it cannot be recorded, e.g. the volume is locked by a deletion
+	if IsReadOnlyTarget(bsDriver) {
+		log.Info("Skipped recording verification result on read-only backup target")
+	} else if err := recordVerification(bsDriver, volumeName, result); err != nil {
+		log.WithError(err).Warn("Failed to record verification result")
+	}
+	return result, nil
+}
+
+// verifyBackup verifies the blocks of the backup under the restore lock, which is shared with the restores and
+// the backups of the volume.
+func verifyBackup(bsDriver BackupStoreDriver, backupName, volumeName string, opts VerifyOptions) (*VerificationResult, error) {
+	lock, err := New(bsDriver, volumeName, RESTORE_LOCK)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if unlockErr := lock.Unlock(); unlockErr != nil {
+			log.WithError(unlockErr).Warnf("Failed to unlock volume %v", volumeName)
+		}
+	}()
+
+	if _, err := loadVolume(bsDriver, volumeName); err != nil {
+		return nil, err
+	}
+	backup, err := loadBackup(bsDriver, backupName, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	if isBackupInProgress(backup) {
+		return nil, fmt.Errorf("backup %v of volume %v is still in progress", backupName, volumeName)
+	}
+	if backup.SingleFile.FilePath != "" {
+		return nil, fmt.Errorf("verifying single file backup %v is not supported", backupName)
+	}
+
+	verifiedAt := util.Now()
+	if opts.isSampled() && opts.SampleSeed == "" {
+		opts.SampleSeed = verifiedAt
+	}
+	result := verifyBackupBlocks(bsDriver, backup, opts)
+	result.BackupName = backupName
+	result.VerifiedAt = verifiedAt
+	return result, nil
+}
+
+// verifyBackupBlocks checks each distinct block referenced by the backup, or each sampled one, once.
+func verifyBackupBlocks(bsDriver BackupStoreDriver, backup *Backup, opts VerifyOptions) *VerificationResult {
+	distinct := map[string]struct{}{}
+	for _, block := range backup.Blocks {
+		distinct[block.BlockChecksum] = struct{}{}
+	}
+	checksums := make([]string, 0, len(distinct))
+	for checksum := range distinct {
+		checksums = append(checksums, checksum)
+	}
+	if opts.isSampled() {
+		checksums = sampleChecksums(checksums, opts.SamplePercent, opts.SampleSeed)
+	}
+	if opts.BytesPerSecond > 0 {
+		bsDriver = &bandwidthThrottledDriver{BackupStoreDriver: bsDriver, bandwidth: newRateLimiter(opts.BytesPerSecond)}
+	}
+	workers := opts.Workers
+	if workers == 0 {
+		workers = runtime.NumCPU() * 16
+	}
+
+	var (
+		lock      sync.Mutex
+		corrupted []string
+	)
+	jobQueues := workerpool.New(workers)
+	for _, checksum := range checksums {
+		checksum := checksum
+		jobQueues.Submit(func() {
+			if err := verifyBlock(bsDriver, backup, checksum); err != nil {
+				log.WithError(err).Warnf("Failed to verify block %v of backup %v", checksum, backup.Name)
+				lock.Lock()
+				corrupted = append(corrupted, checksum)
+				lock.Unlock()
+			}
+		})
+	}
+	jobQueues.StopWait()
+
+	result := &VerificationResult{
+		Succeeded:       len(corrupted) == 0,
+		VerifiedBlocks:  int64(len(checksums)),
+		CorruptedBlocks: int64(len(corrupted)),
+	}
+	if opts.isSampled() {
+		result.TotalBlocks = int64(len(distinct))
+		result.SamplePercent = opts.SamplePercent
+		result.SampleSeed = opts.SampleSeed
+	}
+	if !result.Succeeded {
+		result.Message = fmt.Sprintf("%v of %v blocks are missing or corrupted", len(corrupted), len(checksums))
+	}
+	return result
+}
+
+// sampleChecksums returns the given percentage of the checksums, at least one. The checksums are ranked by
+// their hashes with the seed, so the same seed always picks the same checksums.
+func sampleChecksums(checksums []string, percent int, seed string) []string {
+	if len(checksums) == 0 {
+		return checksums
+	}
+	ranks := make(map[string]string, len(checksums))
+	for _, checksum := range checksums {
+		hash := sha256.Sum256([]byte(seed + "/" + checksum))
+		ranks[checksum] = hex.EncodeToString(hash[:])
+	}
+	sorted := append([]string{}, checksums...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return ranks[sorted[i]] < ranks[sorted[j]]
+	})
+	count := (len(sorted)*percent + 99) / 100
+	return sorted[:count]
+}
+
+// bandwidthThrottledDriver throttles the reads of the driver by the bandwidth cap.
+type bandwidthThrottledDriver struct {
+	BackupStoreDriver
+	bandwidth *rateLimiter
+}
+
+func (d *bandwidthThrottledDriver) Unwrap() BackupStoreDriver {
+	return d.BackupStoreDriver
+}
+
+func (d *bandwidthThrottledDriver) Read(src string) (io.ReadCloser, error) {
+	rc, err := d.BackupStoreDriver.Read(src)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: &throttledReader{Reader: rc, bandwidth: d.bandwidth}, Closer: rc}, nil
+}
+
+func verifyBlock(bsDriver BackupStoreDriver, backup *Backup, checksum string) error {
+	if data, ok := backup.InlineBlocks[checksum]; ok {
+		_, err := util.DecompressAndVerify(backup.CompressionMethod, bytes.NewReader(data), checksum)
+		return err
+	}
+	_, err := DecompressAndVerifyWithFallback(bsDriver, findBlockFilePath(bsDriver, backup.VolumeName, checksum), backup.CompressionMethod, checksum)
+	return err
+}
+
+// recordVerification saves the verification result in the volume config. The deletion lock keeps the backups
+// from updating the volume config meanwhile, like the quarantine does.
+func recordVerification(bsDriver BackupStoreDriver, volumeName string, result *VerificationResult) error {
+	lock, err := New(bsDriver, volumeName, DELETION_LOCK)
+	if err != nil {
+		return err
+	}
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := lock.Unlock(); unlockErr != nil {
+			log.WithError(unlockErr).Warnf("Failed to unlock volume %v", volumeName)
+		}
+	}()
+
+	volume, err := loadVolume(bsDriver, volumeName)
+	if err != nil {
+		return err
+	}
+	volume.LastVerification = result
+	if result.Succeeded {
+		volume.LastSuccessfulVerification = result
+	}
+	return saveVolume(bsDriver, volume)
+}
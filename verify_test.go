@@ -0,0 +1,137 @@
+package backupstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	volumeName := "pvc-1"
+	err := saveVolume(m, &Volume{Name: volumeName, Size: 2 * DEFAULT_BLOCK_SIZE, CompressionMethod: "lz4"})
+	assert.NoError(err)
+
+	good := bytes.Repeat([]byte("a"), DEFAULT_BLOCK_SIZE)
+	bad := bytes.Repeat([]byte("b"), DEFAULT_BLOCK_SIZE)
+	goodChecksum, badChecksum := util.GetChecksum(good), util.GetChecksum(bad)
+	for checksum, data := range map[string][]byte{goodChecksum: good, badChecksum: good} {
+		compressed, err := util.CompressData("lz4", data)
+		assert.NoError(err)
+		err = m.Write(getBlockFilePath(volumeName, checksum), compressed)
+		assert.NoError(err)
+	}
+
+	for name, blocks := range map[string][]BlockMapping{
+		"backup-good": {{Offset: 0, BlockChecksum: goodChecksum}},
+		"backup-bad":  {{Offset: 0, BlockChecksum: goodChecksum}, {Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: badChecksum}},
+	} {
+		err = saveBackup(m, &Backup{
+			Name:              name,
+			VolumeName:        volumeName,
+			CreatedTime:       "2024-01-01T00:00:00Z",
+			CompressionMethod: "lz4",
+			Blocks:            blocks,
+		})
+		assert.NoError(err)
+	}
+
+	result, err := VerifyBackup(EncodeBackupURL("backup-good", volumeName, mockDriverURL))
+	assert.NoError(err)
+	assert.True(result.Succeeded)
+	assert.Equal(int64(1), result.VerifiedBlocks)
+
+	result, err = VerifyBackup(EncodeBackupURL("backup-bad", volumeName, mockDriverURL))
+	assert.NoError(err)
+	assert.False(result.Succeeded)
+	assert.Equal(int64(2), result.VerifiedBlocks)
+	assert.Equal(int64(1), result.CorruptedBlocks)
+
+	// The last run and the last successful run are both exposed
+	info, err := InspectVolume(EncodeBackupURL("", volumeName, mockDriverURL))
+	assert.NoError(err)
+	assert.Equal("backup-bad", info.LastVerification.BackupName)
+	assert.False(info.LastVerification.Succeeded)
+	assert.Equal("backup-good", info.LastSuccessfulVerification.BackupName)
+	assert.NotEmpty(info.LastSuccessfulVerification.VerifiedAt)
+
+	// The result isn't recorded on the read-only target
+	readOnlyURL := mockDriverURL + "?" + ReadOnlyTargetOption + "=true"
+	result, err = VerifyBackup(EncodeBackupURL("backup-good", volumeName, readOnlyURL))
+	assert.NoError(err)
+	assert.True(result.Succeeded)
+	volume, err := loadVolume(m, volumeName)
+	assert.NoError(err)
+	assert.Equal("backup-bad", volume.LastVerification.BackupName)
+
+	_, err = VerifyBackup(EncodeBackupURL("backup-missing", volumeName, mockDriverURL))
+	assert.Error(err)
+}
+
+func TestVerifyBackupSampling(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	volumeName := "pvc-1"
+	err := saveVolume(m, &Volume{Name: volumeName, Size: 10 * DEFAULT_BLOCK_SIZE, CompressionMethod: "lz4"})
+	assert.NoError(err)
+
+	blocks := []BlockMapping{}
+	for i := 0; i < 10; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i)}, DEFAULT_BLOCK_SIZE)
+		checksum := util.GetChecksum(data)
+		compressed, err := util.CompressData("lz4", data)
+		assert.NoError(err)
+		err = m.Write(getBlockFilePath(volumeName, checksum), compressed)
+		assert.NoError(err)
+		blocks = append(blocks, BlockMapping{Offset: int64(i) * DEFAULT_BLOCK_SIZE, BlockChecksum: checksum})
+	}
+	err = saveBackup(m, &Backup{
+		Name:              "backup-1",
+		VolumeName:        volumeName,
+		CreatedTime:       "2024-01-01T00:00:00Z",
+		CompressionMethod: "lz4",
+		Blocks:            blocks,
+	})
+	assert.NoError(err)
+
+	backupURL := EncodeBackupURL("backup-1", volumeName, mockDriverURL)
+	result, err := VerifyBackupWithOptions(backupURL, VerifyOptions{SamplePercent: 30, SampleSeed: "run-1", Workers: 2,
+		BytesPerSecond: 1 << 30})
+	assert.NoError(err)
+	assert.True(result.Succeeded)
+	assert.Equal(int64(3), result.VerifiedBlocks)
+	assert.Equal(int64(10), result.TotalBlocks)
+	assert.Equal("run-1", result.SampleSeed)
+
+	// The runs without a seed are seeded by the run time
+	result, err = VerifyBackupWithOptions(backupURL, VerifyOptions{SamplePercent: 1})
+	assert.NoError(err)
+	assert.Equal(int64(1), result.VerifiedBlocks)
+	assert.Equal(result.VerifiedAt, result.SampleSeed)
+
+	checksums := []string{}
+	for _, block := range blocks {
+		checksums = append(checksums, block.BlockChecksum)
+	}
+	sampled := sampleChecksums(checksums, 50, "run-1")
+	assert.Len(sampled, 5)
+	assert.Equal(sampled, sampleChecksums(checksums, 50, "run-1"))
+	assert.NotEqual(sampled, sampleChecksums(checksums, 50, "run-2"))
+
+	_, err = VerifyBackupWithOptions(backupURL, VerifyOptions{SamplePercent: 101})
+	assert.Error(err)
+	_, err = VerifyBackupWithOptions(backupURL, VerifyOptions{Workers: -1})
+	assert.Error(err)
+}
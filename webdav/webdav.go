@@ -0,0 +1,261 @@
+package webdav
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "webdav"})
+)
+
+// BackupStoreDriver defines the variables and method that backupstore will use.
+type BackupStoreDriver struct {
+	destURL string
+	path    string
+	getenv  func(string) string
+	service *service
+}
+
+const (
+	// KIND defines the kind of backupstore driver
+	KIND = "webdav"
+
+	// WebdavSchemeOption is the backup target URL query parameter choosing the protocol of the WebDAV server,
+	// https by default, e.g. webdav://nas.local:5005/backups/?webdavScheme=http
+	WebdavSchemeOption = "webdavScheme"
+)
+
+func init() {
+	if err := backupstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL string) (backupstore.BackupStoreDriver, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("wrong driver dispatching %v to %v?", u.Scheme, KIND)
+	}
+	if u.Host == "" || u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("invalid URL. Must be webdav://<server-address>[:port]/<path>/")
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("WebDAV user must be provided by %v and %v instead of the URL",
+			types.WebDAVUsername, types.WebDAVPassword)
+	}
+
+	scheme := u.Query().Get(WebdavSchemeOption)
+	switch scheme {
+	case "":
+		scheme = "https"
+	case "https", "http":
+	default:
+		return nil, fmt.Errorf("invalid %v %v in WebDAV URL", WebdavSchemeOption, scheme)
+	}
+
+	b := &BackupStoreDriver{
+		path:   path.Clean(u.Path),
+		getenv: backupstore.TargetEnv(backupstore.GetTargetCredential(destURL)),
+	}
+	b.service, err = newService(&url.URL{Scheme: scheme, Host: u.Host}, b.getenv)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == "http" && b.getenv(types.WebDAVPassword) != "" {
+		log.Warnf("WebDAV credential of %v is sent over plain HTTP", u.Host)
+	}
+
+	if _, err := b.service.list(b.path); err != nil {
+		return nil, fmt.Errorf("WebDAV path %v doesn't exist or is not a collection: %v", b.path, err)
+	}
+
+	b.destURL = KIND + "://" + u.Host + b.path
+	log.Infof("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+// Kind returns the driver type
+func (s *BackupStoreDriver) Kind() string {
+	return KIND
+}
+
+// GetURL returns URL of the backup target
+func (s *BackupStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *BackupStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+// List return items that on the backup target
+func (s *BackupStoreDriver) List(listPath string) ([]string, error) {
+	names, err := s.service.list(s.updatePath(listPath))
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to list webdav")
+		return nil, err
+	}
+	return names, nil
+}
+
+// FileExists checks if file exists on the backup target
+func (s *BackupStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+// FileSize return content length of the filePath on the backup target
+func (s *BackupStoreDriver) FileSize(filePath string) int64 {
+	res, err := s.service.stat(s.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return res.size
+}
+
+// FileTime returns file last modified time on the backup target
+func (s *BackupStoreDriver) FileTime(filePath string) time.Time {
+	res, err := s.service.stat(s.updatePath(filePath))
+	if err != nil {
+		return time.Time{}
+	}
+	return res.modified.UTC()
+}
+
+// Remove deletes files on the backup target, then cleans up the empty upper level collections
+func (s *BackupStoreDriver) Remove(path string) error {
+	name := s.updatePath(path)
+	if err := s.service.remove(name); err != nil {
+		return err
+	}
+
+	for dir := filepath.Dir(name); dir != s.path && filepath.Dir(dir) != dir; dir = filepath.Dir(dir) {
+		names, err := s.service.list(dir)
+		if err != nil || len(names) > 0 {
+			break
+		}
+		if err := s.service.remove(dir); err != nil {
+			log.WithError(err).Warnf("Failed to clean up empty WebDAV collection %v", dir)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *BackupStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return s.service.get(s.updatePath(src))
+}
+
+// Write creates a item on the backup target from io stream. The data is written to a temporary file moved to
+// the destination, so the readers never see a partially written file.
+func (s *BackupStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	name := s.updatePath(dst)
+	// we append the timestamp to the tmp files so that we should never have 2 backups using the same tmp file
+	tmpName := name + ".tmp" + "." + strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
+
+	offset, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	err = s.service.put(tmpName, rs)
+	if err == errConflict || err == errNotFound {
+		// The parent collections are created on demand, the servers report them missing as a conflict
+		if err := s.service.mkcolAll(s.path, filepath.Dir(name)); err != nil {
+			return err
+		}
+		if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		err = s.service.put(tmpName, rs)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.service.move(tmpName, name); err != nil {
+		if removeErr := s.service.remove(tmpName); removeErr != nil {
+			log.WithError(removeErr).Warnf("Failed to remove tmp file %v", tmpName)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetCapacity returns the quota of the collection reported by the WebDAV server
+func (s *BackupStoreDriver) GetCapacity() (*backupstore.TargetCapacity, error) {
+	used, available, err := s.service.quota(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if used < 0 && available < 0 {
+		return nil, backupstore.ErrCapacityNotSupported
+	}
+	capacity := &backupstore.TargetCapacity{Total: -1, Used: used, Available: available}
+	if used >= 0 && available >= 0 {
+		capacity.Total = used + available
+	}
+	return capacity, nil
+}
+
+// Copy copies the item on the WebDAV server without transferring the data through the client
+func (s *BackupStoreDriver) Copy(src, dst string) error {
+	name := s.updatePath(dst)
+	if err := s.service.mkcolAll(s.path, filepath.Dir(name)); err != nil {
+		return err
+	}
+	return s.service.copy(s.updatePath(src), name)
+}
+
+// Upload creates a item on the backup target by opening source file
+func (s *BackupStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.Write(dst, file)
+}
+
+// Download gets a item data from the backup target
+func (s *BackupStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0700); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rc, err := s.service.get(s.updatePath(src))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
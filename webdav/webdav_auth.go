@@ -0,0 +1,209 @@
+package webdav
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	authSchemeBasic  = "basic"
+	authSchemeDigest = "digest"
+)
+
+// authenticator authorizes the requests with the basic or the digest authentication, whichever the server
+// challenges with. The challenge is remembered, so only the first request and the ones with a stale nonce are
+// sent twice.
+type authenticator struct {
+	username string
+	password string
+
+	lock   sync.Mutex
+	scheme string
+	digest *digestChallenge
+	nc     uint32
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+func newAuthenticator(username, password string) *authenticator {
+	return &authenticator{username: username, password: password}
+}
+
+// authorize sets the Authorization header of the request if the server already challenged the client.
+func (a *authenticator) authorize(req *http.Request) error {
+	if a.username == "" {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	switch a.scheme {
+	case authSchemeBasic:
+		req.SetBasicAuth(a.username, a.password)
+	case authSchemeDigest:
+		a.nc++
+		authorization, err := a.digestAuthorization(req.Method, req.URL.RequestURI(), a.nc)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", authorization)
+	}
+	return nil
+}
+
+// challenge updates the authentication scheme from the challenges of the 401 response, it returns true if the
+// request should be sent again with the new authorization.
+func (a *authenticator) challenge(resp *http.Response) bool {
+	if a.username == "" {
+		return false
+	}
+
+	var basic bool
+	var digest map[string]string
+	for _, value := range resp.Header.Values("WWW-Authenticate") {
+		scheme, params := parseChallenge(value)
+		switch scheme {
+		case authSchemeDigest:
+			digest = params
+		case authSchemeBasic:
+			basic = true
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// The digest authentication is preferred since it doesn't send the password
+	if digest != nil {
+		qop := ""
+		for _, value := range strings.Split(digest["qop"], ",") {
+			if strings.TrimSpace(value) == "auth" {
+				qop = "auth"
+			}
+		}
+		retry := a.scheme != authSchemeDigest || a.digest.nonce != digest["nonce"] ||
+			strings.EqualFold(digest["stale"], "true")
+		a.scheme = authSchemeDigest
+		a.digest = &digestChallenge{
+			realm:     digest["realm"],
+			nonce:     digest["nonce"],
+			opaque:    digest["opaque"],
+			algorithm: digest["algorithm"],
+			qop:       qop,
+		}
+		a.nc = 0
+		return retry
+	}
+	if basic && a.scheme != authSchemeBasic {
+		a.scheme = authSchemeBasic
+		return true
+	}
+	return false
+}
+
+func (a *authenticator) digestAuthorization(method, uri string, nc uint32) (string, error) {
+	c := a.digest
+	var newHash func() hash.Hash
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(c.algorithm), "-sess")) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported WebDAV digest authentication algorithm %v", c.algorithm)
+	}
+	h := func(data string) string {
+		hash := newHash()
+		hash.Write([]byte(data))
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+
+	cnonceBytes := make([]byte, 16)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	ncValue := fmt.Sprintf("%08x", nc)
+
+	ha1 := h(a.username + ":" + c.realm + ":" + a.password)
+	if strings.HasSuffix(strings.ToLower(c.algorithm), "-sess") {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var response string
+	if c.qop == "auth" {
+		response = h(strings.Join([]string{ha1, c.nonce, ncValue, cnonce, c.qop, ha2}, ":"))
+	} else {
+		response = h(ha1 + ":" + c.nonce + ":" + ha2)
+	}
+
+	params := []string{
+		fmt.Sprintf("username=%q", a.username),
+		fmt.Sprintf("realm=%q", c.realm),
+		fmt.Sprintf("nonce=%q", c.nonce),
+		fmt.Sprintf("uri=%q", uri),
+		fmt.Sprintf("response=%q", response),
+	}
+	if c.algorithm != "" {
+		params = append(params, "algorithm="+c.algorithm)
+	}
+	if c.opaque != "" {
+		params = append(params, fmt.Sprintf("opaque=%q", c.opaque))
+	}
+	if c.qop != "" {
+		params = append(params, "qop="+c.qop, "nc="+ncValue, fmt.Sprintf("cnonce=%q", cnonce))
+	}
+	return "Digest " + strings.Join(params, ", "), nil
+}
+
+// parseChallenge parses a WWW-Authenticate header value, e.g. `Digest realm="dav", nonce="abc", qop="auth"`,
+// into the lower case scheme and the parameters.
+func parseChallenge(value string) (string, map[string]string) {
+	value = strings.TrimSpace(value)
+	scheme, rest, _ := strings.Cut(value, " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+		key, remaining, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		remaining = strings.TrimLeft(remaining, " ")
+
+		var param string
+		if strings.HasPrefix(remaining, `"`) {
+			end := 1
+			for end < len(remaining) && remaining[end] != '"' {
+				if remaining[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(remaining) {
+				end = len(remaining) - 1
+			}
+			param = strings.ReplaceAll(remaining[1:end], `\`, "")
+			rest = remaining[end+1:]
+		} else {
+			param, rest, _ = strings.Cut(remaining, ",")
+			param = strings.TrimSpace(param)
+		}
+		params[key] = param
+	}
+	return strings.ToLower(scheme), params
+}
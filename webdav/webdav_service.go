@@ -0,0 +1,373 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	bhttp "github.com/longhorn/backupstore/http"
+	"github.com/longhorn/backupstore/types"
+)
+
+var (
+	errNotFound = fmt.Errorf("resource not found")
+	// errConflict is returned when the parent collection of the resource doesn't exist
+	errConflict = fmt.Errorf("resource conflict")
+)
+
+// maxRedirects is the number of the redirects followed, the requests are redirected by the service instead of
+// the HTTP client which would change the WebDAV methods to GET
+const maxRedirects = 3
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// quotaPropfindBody requests the quota properties of RFC 4331, they're requested separately since some servers
+// calculate them on demand
+const quotaPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:quota-available-bytes/><d:quota-used-bytes/></d:prop></d:propfind>`
+
+type service struct {
+	// Endpoint is the scheme and the host of the WebDAV server, e.g. https://nas.example.com:5006
+	Endpoint *url.URL
+	Client   *http.Client
+
+	auth *authenticator
+}
+
+// resource is a file or a collection on the WebDAV server.
+type resource struct {
+	path         string
+	size         int64
+	modified     time.Time
+	isCollection bool
+}
+
+type multistatus struct {
+	Responses []propfindResponse `xml:"DAV: response"`
+}
+
+type propfindResponse struct {
+	Href      string     `xml:"DAV: href"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   struct {
+		ResourceType struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+		ContentLength  string `xml:"DAV: getcontentlength"`
+		LastModified   string `xml:"DAV: getlastmodified"`
+		QuotaAvailable string `xml:"DAV: quota-available-bytes"`
+		QuotaUsed      string `xml:"DAV: quota-used-bytes"`
+	} `xml:"DAV: prop"`
+}
+
+func newService(endpoint *url.URL, getenv func(string) string) (*service, error) {
+	var customCerts []byte
+	if certs := getenv(types.WebDAVCert); certs != "" {
+		customCerts = []byte(certs)
+	}
+	insecure := false
+	if value := getenv(types.WebDAVInsecureSkipVerify); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v %v", types.WebDAVInsecureSkipVerify, value)
+		}
+		insecure = parsed
+	}
+	client, err := bhttp.GetClient(insecure, customCerts)
+	if err != nil {
+		return nil, err
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &service{
+		Endpoint: endpoint,
+		Client:   client,
+		auth:     newAuthenticator(getenv(types.WebDAVUsername), getenv(types.WebDAVPassword)),
+	}, nil
+}
+
+func (s *service) resourceURL(name string, isCollection bool) string {
+	u := *s.Endpoint
+	u.Path = path.Join("/", name)
+	if isCollection && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// do sends the authorized request, the response body is closed and an error is returned unless the status is
+// one of the expected ones. The body is rewound and sent again if the server challenges the authorization or
+// redirects the request, e.g. to the collection URL ending with a slash.
+func (s *service) do(method, target string, body io.ReadSeeker, header http.Header, expected ...int) (*http.Response, error) {
+	var offset, size int64
+	if body != nil {
+		var err error
+		if offset, err = body.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		end, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		size = end - offset
+	}
+
+	challenged := false
+	for redirects := 0; ; {
+		var reqBody io.Reader
+		if body != nil {
+			if _, err := body.Seek(offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			reqBody = io.NopCloser(body)
+		}
+		req, err := http.NewRequest(method, target, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.ContentLength = size
+			if size == 0 {
+				req.Body = http.NoBody
+			}
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if err := s.auth.authorize(req); err != nil {
+			return nil, err
+		}
+
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range expected {
+			if resp.StatusCode == status {
+				return resp, nil
+			}
+		}
+
+		retry := false
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			if !challenged && s.auth.challenge(resp) {
+				challenged, retry = true, true
+			}
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			if location, err := resp.Location(); err == nil && redirects < maxRedirects {
+				redirects++
+				target, retry = location.String(), true
+			}
+		}
+		if retry {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			continue
+		}
+
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return nil, errNotFound
+		case http.StatusConflict:
+			return nil, errConflict
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("WebDAV error: %v %v %v %v", method, target, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+}
+
+// propfind returns the resource and its children if depth is 1.
+func (s *service) propfind(name string, depth int) ([]*resource, error) {
+	header := http.Header{
+		"Depth":        {strconv.Itoa(depth)},
+		"Content-Type": {`application/xml; charset="utf-8"`},
+	}
+	resp, err := s.do("PROPFIND", s.resourceURL(name, depth > 0), strings.NewReader(propfindBody), header,
+		http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &multistatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode WebDAV properties of %v", name)
+	}
+
+	resources := []*resource{}
+	for _, r := range result.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid WebDAV href %v", r.Href)
+		}
+		res := &resource{path: path.Clean(href.Path), size: -1}
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			res.isCollection = ps.Prop.ResourceType.Collection != nil
+			if ps.Prop.ContentLength != "" {
+				if res.size, err = strconv.ParseInt(ps.Prop.ContentLength, 10, 64); err != nil {
+					return nil, errors.Wrapf(err, "invalid WebDAV content length of %v", r.Href)
+				}
+			}
+			if ps.Prop.LastModified != "" {
+				if res.modified, err = http.ParseTime(ps.Prop.LastModified); err != nil {
+					return nil, errors.Wrapf(err, "invalid WebDAV last modified time of %v", r.Href)
+				}
+			}
+		}
+		if res.isCollection && res.size < 0 {
+			res.size = 0
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+// quota returns the used and the available bytes of the collection, or -1 if the server doesn't report them.
+func (s *service) quota(name string) (used, available int64, err error) {
+	header := http.Header{
+		"Depth":        {"0"},
+		"Content-Type": {`application/xml; charset="utf-8"`},
+	}
+	resp, err := s.do("PROPFIND", s.resourceURL(name, true), strings.NewReader(quotaPropfindBody), header,
+		http.StatusMultiStatus)
+	if err != nil {
+		return -1, -1, err
+	}
+	defer resp.Body.Close()
+
+	result := &multistatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return -1, -1, errors.Wrapf(err, "failed to decode WebDAV quota of %v", name)
+	}
+
+	used, available = -1, -1
+	for _, r := range result.Responses {
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			if ps.Prop.QuotaUsed != "" {
+				if used, err = strconv.ParseInt(ps.Prop.QuotaUsed, 10, 64); err != nil {
+					return -1, -1, errors.Wrapf(err, "invalid WebDAV quota used bytes of %v", r.Href)
+				}
+			}
+			// A negative value means the quota is unlimited
+			if ps.Prop.QuotaAvailable != "" {
+				if available, err = strconv.ParseInt(ps.Prop.QuotaAvailable, 10, 64); err != nil {
+					return -1, -1, errors.Wrapf(err, "invalid WebDAV quota available bytes of %v", r.Href)
+				}
+			}
+		}
+	}
+	return used, max(available, -1), nil
+}
+
+func (s *service) stat(name string) (*resource, error) {
+	resources, err := s.propfind(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 {
+		return nil, errNotFound
+	}
+	return resources[0], nil
+}
+
+// list returns the names of the children of the collection.
+func (s *service) list(name string) ([]string, error) {
+	resources, err := s.propfind(name, 1)
+	if err != nil {
+		return nil, err
+	}
+	self := path.Clean(path.Join("/", name))
+	names := []string{}
+	for _, res := range resources {
+		if res.path == self || !strings.HasPrefix(res.path, strings.TrimSuffix(self, "/")+"/") {
+			continue
+		}
+		names = append(names, path.Base(res.path))
+	}
+	return names, nil
+}
+
+func (s *service) get(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s.resourceURL(name, false), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *service) discard(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func (s *service) put(name string, body io.ReadSeeker) error {
+	return s.discard(s.do(http.MethodPut, s.resourceURL(name, false), body, nil,
+		http.StatusOK, http.StatusCreated, http.StatusNoContent))
+}
+
+// move renames the resource, replacing the destination if it exists.
+func (s *service) move(src, dst string) error {
+	header := http.Header{"Destination": {s.resourceURL(dst, false)}, "Overwrite": {"T"}}
+	return s.discard(s.do("MOVE", s.resourceURL(src, false), nil, header,
+		http.StatusCreated, http.StatusNoContent))
+}
+
+// copy copies the resource on the server, replacing the destination if it exists.
+func (s *service) copy(src, dst string) error {
+	header := http.Header{"Destination": {s.resourceURL(dst, false)}, "Overwrite": {"T"}}
+	return s.discard(s.do("COPY", s.resourceURL(src, false), nil, header,
+		http.StatusCreated, http.StatusNoContent))
+}
+
+// mkcolAll creates the collection and its missing parents under the base collection, which must exist.
+func (s *service) mkcolAll(base, name string) error {
+	if name == base || !strings.HasPrefix(name, base+"/") {
+		return nil
+	}
+	current := base
+	for _, part := range strings.Split(strings.TrimPrefix(name, base+"/"), "/") {
+		current = path.Join(current, part)
+		// 405 Method Not Allowed is returned if the collection exists
+		if err := s.discard(s.do("MKCOL", s.resourceURL(current, true), nil, nil,
+			http.StatusCreated, http.StatusMethodNotAllowed)); err != nil {
+			return errors.Wrapf(err, "failed to create WebDAV collection %v", current)
+		}
+	}
+	return nil
+}
+
+// remove deletes the file or the collection with all its members.
+func (s *service) remove(name string) error {
+	err := s.discard(s.do(http.MethodDelete, s.resourceURL(name, false), nil, nil,
+		http.StatusOK, http.StatusNoContent, http.StatusAccepted))
+	if err == errNotFound {
+		return nil
+	}
+	return err
+}
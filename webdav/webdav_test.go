@@ -0,0 +1,305 @@
+package webdav
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore"
+	"github.com/longhorn/backupstore/types"
+)
+
+// fakeWebDAV implements the subset of WebDAV used by the driver behind the digest authentication.
+type fakeWebDAV struct {
+	t *testing.T
+
+	lock        sync.Mutex
+	files       map[string][]byte
+	collections map[string]bool
+	nonce       string
+	challenges  int
+	quota       int
+}
+
+func newFakeWebDAV(t *testing.T) *fakeWebDAV {
+	return &fakeWebDAV{
+		t:           t,
+		files:       map[string][]byte{},
+		collections: map[string]bool{"/": true, "/dav": true, "/dav/backups": true},
+		nonce:       "nonce-1",
+	}
+}
+
+func (f *fakeWebDAV) authorized(r *http.Request) bool {
+	scheme, params := parseChallenge(r.Header.Get("Authorization"))
+	if scheme != authSchemeDigest || params["nonce"] != f.nonce || params["username"] != "user" {
+		return false
+	}
+	h := func(data string) string {
+		sum := md5.Sum([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := h("user:dav:secret")
+	ha2 := h(r.Method + ":" + params["uri"])
+	expected := h(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	return params["uri"] == r.URL.RequestURI() && params["response"] == expected
+}
+
+func (f *fakeWebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.authorized(r) {
+		f.challenges++
+		w.Header().Add("WWW-Authenticate", `Basic realm="dav"`)
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="dav", nonce="%s", qop="auth,auth-int", algorithm=MD5`, f.nonce))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := path.Clean(r.URL.Path)
+	switch r.Method {
+	case "PROPFIND":
+		f.servePropfind(w, r, name)
+	case http.MethodGet:
+		data, ok := f.files[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	case http.MethodPut:
+		if !f.collections[path.Dir(name)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		assert.Equal(f.t, r.ContentLength, int64(len(data)))
+		f.files[name] = data
+		w.WriteHeader(http.StatusCreated)
+	case "MKCOL":
+		if f.collections[name] || f.files[name] != nil {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !f.collections[path.Dir(name)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.collections[name] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if !f.remove(name) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "MOVE", "COPY":
+		data, ok := f.files[name]
+		destination, err := url.Parse(r.Header.Get("Destination"))
+		if !ok || err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		dst := path.Clean(destination.Path)
+		if !f.collections[path.Dir(dst)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.files[dst] = data
+		if r.Method == "MOVE" {
+			delete(f.files, name)
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeWebDAV) remove(name string) bool {
+	found := false
+	if _, ok := f.files[name]; ok {
+		delete(f.files, name)
+		found = true
+	}
+	for collection := range f.collections {
+		if collection == name || strings.HasPrefix(collection, name+"/") {
+			delete(f.collections, collection)
+			found = true
+		}
+	}
+	for file := range f.files {
+		if strings.HasPrefix(file, name+"/") {
+			delete(f.files, file)
+		}
+	}
+	return found
+}
+
+func (f *fakeWebDAV) servePropfind(w http.ResponseWriter, r *http.Request, name string) {
+	modified := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	entry := func(href string, isCollection bool, size int) string {
+		resourceType, length := "", ""
+		if isCollection {
+			resourceType = "<d:collection/>"
+		} else {
+			length = fmt.Sprintf("<d:getcontentlength>%d</d:getcontentlength>", size)
+		}
+		return fmt.Sprintf(`<d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype>%s</d:resourcetype>%s`+
+			`<d:getlastmodified>%s</d:getlastmodified></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`,
+			(&url.URL{Path: href}).EscapedPath(), resourceType, length, modified)
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	if strings.Contains(string(body), "quota-used-bytes") {
+		prop, status := "<d:quota-used-bytes/><d:quota-available-bytes/>", "404 Not Found"
+		if f.quota > 0 {
+			used := 0
+			for _, data := range f.files {
+				used += len(data)
+			}
+			prop = fmt.Sprintf("<d:quota-used-bytes>%d</d:quota-used-bytes><d:quota-available-bytes>%d</d:quota-available-bytes>",
+				used, f.quota-used)
+			status = "200 OK"
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>%s/</d:href>`+
+			`<d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 %s</d:status></d:propstat></d:response></d:multistatus>`,
+			name, prop, status)
+		return
+	}
+
+	var entries []string
+	if data, ok := f.files[name]; ok {
+		entries = append(entries, entry(name, false, len(data)))
+	} else if f.collections[name] {
+		entries = append(entries, entry(name+"/", true, 0))
+		if r.Header.Get("Depth") == "1" {
+			var children []string
+			for collection := range f.collections {
+				if collection != name && path.Dir(collection) == name {
+					children = append(children, entry(collection+"/", true, 0))
+				}
+			}
+			for file, data := range f.files {
+				if path.Dir(file) == name {
+					children = append(children, entry(file, false, len(data)))
+				}
+			}
+			sort.Strings(children)
+			entries = append(entries, children...)
+		}
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:">%s</d:multistatus>`,
+		strings.Join(entries, ""))
+}
+
+func TestDriver(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := newFakeWebDAV(t)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	assert.NoError(err)
+	destURL := "webdav://" + serverURL.Host + "/dav/backups/?" + WebdavSchemeOption + "=http"
+
+	backupstore.SetTargetCredential(destURL, map[string]string{
+		types.WebDAVUsername: "user",
+		types.WebDAVPassword: "wrong",
+	})
+	_, err = initFunc(destURL)
+	assert.Error(err)
+
+	backupstore.SetTargetCredential(destURL, map[string]string{
+		types.WebDAVUsername: "user",
+		types.WebDAVPassword: "secret",
+	})
+	defer backupstore.SetTargetCredential(destURL, nil)
+	driver, err := initFunc(destURL)
+	assert.NoError(err)
+	assert.Equal("webdav://"+serverURL.Host+"/dav/backups", driver.GetURL())
+
+	challenges := fake.challenges
+	assert.NoError(driver.Write("volumes/01/volume.cfg", strings.NewReader("config")))
+	assert.Equal(challenges, fake.challenges, "the digest challenge should be reused")
+	assert.True(driver.FileExists("volumes/01/volume.cfg"))
+	assert.Equal(int64(6), driver.FileSize("volumes/01/volume.cfg"))
+	assert.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), driver.FileTime("volumes/01/volume.cfg"))
+	assert.Equal(int64(-1), driver.FileSize("volumes/01/missing.cfg"))
+
+	rc, err := driver.Read("volumes/01/volume.cfg")
+	assert.NoError(err)
+	data, err := io.ReadAll(rc)
+	assert.NoError(err)
+	assert.NoError(rc.Close())
+	assert.Equal("config", string(data))
+
+	assert.NoError(driver.Write("volumes/01/volume.cfg", bytes.NewReader([]byte("new config"))))
+	assert.NoError(driver.(backupstore.CopyingBackupStoreDriver).Copy("volumes/01/volume.cfg", "volumes/02/volume.cfg"))
+
+	reporter := driver.(backupstore.CapacityBackupStoreDriver)
+	_, err = reporter.GetCapacity()
+	assert.ErrorIs(err, backupstore.ErrCapacityNotSupported)
+	fake.quota = 100
+	capacity, err := reporter.GetCapacity()
+	assert.NoError(err)
+	assert.Equal(&backupstore.TargetCapacity{Total: 100, Used: 20, Available: 80}, capacity)
+
+	names, err := driver.List("volumes")
+	assert.NoError(err)
+	assert.Equal([]string{"01", "02"}, names)
+	names, err = driver.List("volumes/01")
+	assert.NoError(err)
+	assert.Equal([]string{"volume.cfg"}, names, "the tmp file should be moved")
+	names, err = driver.List("missing")
+	assert.NoError(err)
+	assert.Empty(names)
+
+	// The empty upper level collections are cleaned up, the backup target itself is kept
+	assert.NoError(driver.Remove("volumes/01/volume.cfg"))
+	names, err = driver.List("volumes")
+	assert.NoError(err)
+	assert.Equal([]string{"02"}, names)
+	assert.NoError(driver.Remove("volumes"))
+	assert.NoError(driver.Remove("volumes"))
+	assert.True(fake.collections["/dav/backups"])
+}
+
+func TestParseChallenge(t *testing.T) {
+	assert := assert.New(t)
+
+	scheme, params := parseChallenge(`Digest realm="a, \"quoted\" realm", nonce="abc", qop="auth,auth-int", algorithm=SHA-256, stale=true`)
+	assert.Equal(authSchemeDigest, scheme)
+	assert.Equal(map[string]string{
+		"realm":     `a, "quoted" realm`,
+		"nonce":     "abc",
+		"qop":       "auth,auth-int",
+		"algorithm": "SHA-256",
+		"stale":     "true",
+	}, params)
+
+	scheme, params = parseChallenge(`Basic realm="dav"`)
+	assert.Equal(authSchemeBasic, scheme)
+	assert.Equal("dav", params["realm"])
+}
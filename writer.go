@@ -0,0 +1,120 @@
+package backupstore
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/longhorn/backupstore/util"
+)
+
+const (
+	// MULTI_WRITER_WINDOW is the period in which the writes of different clients to the same volume are
+	// reported as a misconfiguration
+	MULTI_WRITER_WINDOW = time.Hour
+	// MAX_VOLUME_WRITERS is the max number of the recent writers recorded in the volume config
+	MAX_VOLUME_WRITERS = 8
+)
+
+// VolumeWriter is a client that wrote a backup of the volume.
+type VolumeWriter struct {
+	ClientID    string
+	LastWriteAt string
+}
+
+var (
+	clientIDLock sync.RWMutex
+	clientID     string
+	nodeID       string
+)
+
+// SetClientID sets the identity of the client recorded in the locks, the backups and the volume configs, which
+// is used to trace the cluster creating or deleting the backups and to detect different clients writing the
+// backups of the same volume. It should identify the cluster or the installation rather than the node, since a
+// volume can be backed up from any of the nodes. The detection is disabled if it's not set.
+func SetClientID(id string) {
+	clientIDLock.Lock()
+	defer clientIDLock.Unlock()
+	clientID = id
+}
+
+// GetClientID returns the identity of the client.
+func GetClientID() string {
+	clientIDLock.RLock()
+	defer clientIDLock.RUnlock()
+	return clientID
+}
+
+// SetNodeID sets the identity of the node recorded along with the client ID, the host name is used if it's
+// not set.
+func SetNodeID(id string) {
+	clientIDLock.Lock()
+	defer clientIDLock.Unlock()
+	nodeID = id
+}
+
+// GetNodeID returns the identity of the node.
+func GetNodeID() string {
+	clientIDLock.RLock()
+	id := nodeID
+	clientIDLock.RUnlock()
+	if id != "" {
+		return id
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return hostname
+}
+
+// recordVolumeWriter records the write of the client in the volume, keeping the most recent writers.
+func recordVolumeWriter(volume *Volume, id string) {
+	if id == "" {
+		return
+	}
+	now := util.Now()
+	found := false
+	for i := range volume.Writers {
+		if volume.Writers[i].ClientID == id {
+			volume.Writers[i].LastWriteAt = now
+			found = true
+			break
+		}
+	}
+	if !found {
+		volume.Writers = append(volume.Writers, VolumeWriter{ClientID: id, LastWriteAt: now})
+	}
+	sort.SliceStable(volume.Writers, func(i, j int) bool {
+		return volume.Writers[i].LastWriteAt > volume.Writers[j].LastWriteAt
+	})
+	if len(volume.Writers) > MAX_VOLUME_WRITERS {
+		volume.Writers = volume.Writers[:MAX_VOLUME_WRITERS]
+	}
+}
+
+// getMultiWriterWarning returns the warning if more than one client wrote the volume in MULTI_WRITER_WINDOW.
+func getMultiWriterWarning(volume *Volume) string {
+	if len(volume.Writers) < 2 {
+		return ""
+	}
+	latest, err := util.ParseTimestamp(volume.Writers[0].LastWriteAt)
+	if err != nil {
+		return ""
+	}
+	clients := []string{volume.Writers[0].ClientID}
+	for _, writer := range volume.Writers[1:] {
+		writeAt, err := util.ParseTimestamp(writer.LastWriteAt)
+		if err != nil || latest.Sub(writeAt) > MULTI_WRITER_WINDOW {
+			continue
+		}
+		clients = append(clients, writer.ClientID)
+	}
+	if len(clients) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("volume %v is written by multiple clients %v within %v, the clients may be misconfigured to "+
+		"use the same backup target", volume.Name, clients, MULTI_WRITER_WINDOW)
+}
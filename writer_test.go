@@ -0,0 +1,64 @@
+package backupstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/longhorn/backupstore/types"
+	"github.com/longhorn/backupstore/util"
+)
+
+func TestMultiWriterDetection(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := util.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	volume := &Volume{Name: "pvc-1"}
+	recordVolumeWriter(volume, "")
+	assert.Empty(volume.Writers)
+
+	recordVolumeWriter(volume, "cluster-a")
+	clock.Advance(2 * MULTI_WRITER_WINDOW)
+	recordVolumeWriter(volume, "cluster-b")
+	assert.Equal("cluster-b", volume.Writers[0].ClientID)
+	assert.Empty(getMultiWriterWarning(volume))
+
+	// The writes of the different clients in the window are reported
+	clock.Advance(time.Minute)
+	recordVolumeWriter(volume, "cluster-a")
+	assert.Len(volume.Writers, 2)
+	assert.Equal("cluster-a", volume.Writers[0].ClientID)
+	info := fillVolumeInfo(volume)
+	assert.Contains(info.Messages[types.MessageTypeWarning], "cluster-b")
+
+	for i := 0; i < MAX_VOLUME_WRITERS; i++ {
+		recordVolumeWriter(volume, util.GenerateName("cluster"))
+	}
+	assert.Len(volume.Writers, MAX_VOLUME_WRITERS)
+}
+
+func TestLockOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &mockStoreDriver{}
+	m.Init()
+	defer m.uninstall()
+
+	SetClientID("cluster-a")
+	defer SetClientID("")
+	SetNodeID("node-1")
+	defer SetNodeID("")
+
+	lock, err := New(m, "pvc-1", BACKUP_LOCK)
+	assert.NoError(err)
+	err = saveLock(lock)
+	assert.NoError(err)
+	loaded, err := loadLock("pvc-1", lock.Name, m)
+	assert.NoError(err)
+	assert.Equal("cluster-a", loaded.Owner)
+	assert.Equal("node-1", loaded.Node)
+}